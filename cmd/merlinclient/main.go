@@ -0,0 +1,173 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// merlinclient connects an operator to a Merlin team server started with -rpc. Without a command it opens the
+// operator's console; with one it calls the team server's JSON-RPC services and prints the result.
+package main
+
+import (
+	// Standard
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/chzyer/readline"
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg"
+	"github.com/Ne0nd0g/merlin/pkg/api/rpc"
+	"github.com/Ne0nd0g/merlin/pkg/cli/client"
+)
+
+// build is the build number of the client
+var build = "nonRelease"
+
+func main() {
+	server := flag.String("server", "127.0.0.1:50051", "The team server's operator address")
+	operator := flag.String("operator", operatorDefault(), "Your operator name")
+	password := flag.String("password", os.Getenv("MERLIN_PASSWORD"), "Your operator password, or OIDC access token if the team server uses OIDC; defaults to the MERLIN_PASSWORD environment variable and is prompted for if empty")
+	fingerprint := flag.String("fingerprint", "", "SHA256 fingerprint of the team server's certificate, shown when the server starts")
+	flag.Usage = func() {
+		color.Blue("#################################################")
+		color.Blue("#\t\tMERLIN CLIENT\t\t\t#")
+		color.Blue("#################################################")
+		color.Blue("Version: " + merlin.Version)
+		color.Blue("Build: " + build)
+		color.Blue("Usage: merlinclient -server <address> -fingerprint <SHA256> [flags] [command]")
+		color.Blue("Commands:")
+		color.Blue("\tagents\t\t\t\t\tList the agents")
+		color.Blue("\tmodules\t\t\t\t\tList the modules")
+		color.Blue("\tsessions\t\t\t\tList the operators connected to the team server")
+		color.Blue("\tjob <agent> <type> [<arg>...]\t\tCreate a job for an agent")
+		color.Blue("\tmodule <module> <agent> [<option>=<value>...]\tRun a module")
+		color.Blue("Without a command, the operator's console is opened")
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+	flag.Parse()
+
+	if *password == "" {
+		p, err := readline.Password(fmt.Sprintf("Password for %s: ", *operator))
+		if err != nil {
+			os.Exit(1)
+		}
+		*password = string(p)
+	}
+
+	if flag.NArg() == 0 {
+		if err := client.Console(*server, *fingerprint, *operator, *password); err != nil {
+			color.Red(fmt.Sprintf("[!]%s", err.Error()))
+			os.Exit(1)
+		}
+		return
+	}
+
+	c, err := rpc.NewClient(*server, *fingerprint, *operator, *password)
+	if err != nil {
+		color.Red(fmt.Sprintf("[!]%s", err.Error()))
+		os.Exit(1)
+	}
+	defer c.Close() // #nosec G307
+
+	args := flag.Args()
+	switch args[0] {
+	case "agents":
+		var list []rpc.Agent
+		err = c.Call("Agents.List", struct{}{}, &list)
+		if err == nil {
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"Agent GUID", "Platform", "User", "Host", "Transport", "Status", "Last Check In"})
+			for _, a := range list {
+				table.Append([]string{a.ID, a.Platform + "/" + a.Architecture, a.UserName, a.HostName, a.Transport,
+					a.Status, a.StatusCheckIn.Format(time.RFC3339)})
+			}
+			table.Render()
+		}
+	case "modules":
+		var list []string
+		err = c.Call("Modules.List", struct{}{}, &list)
+		if err == nil {
+			fmt.Println(strings.Join(list, "\n"))
+		}
+	case "sessions":
+		var list []rpc.Session
+		err = c.Call("Operators.Sessions", struct{}{}, &list)
+		if err == nil {
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"Operator", "Role", "Address", "Console", "Logged In", "Last Seen"})
+			for _, s := range list {
+				table.Append([]string{s.Operator, s.Role, s.Address, fmt.Sprintf("%t", s.Console),
+					s.Created.Format(time.RFC3339), s.LastSeen.Format(time.RFC3339)})
+			}
+			table.Render()
+		}
+	case "job":
+		if len(args) < 3 {
+			err = fmt.Errorf("job <agent> <type> [<arg>...]")
+			break
+		}
+		var job string
+		err = c.Call("Agents.AddJob", rpc.JobArgs{Agent: args[1], Type: args[2], Args: args[3:]}, &job)
+		if err == nil {
+			color.Green(fmt.Sprintf("[+]Created job %s for agent %s", job, args[1]))
+		}
+	case "module":
+		if len(args) < 3 {
+			err = fmt.Errorf("module <module> <agent> [<option>=<value>...]")
+			break
+		}
+		options := make(map[string]string)
+		for _, o := range args[3:] {
+			kv := strings.SplitN(o, "=", 2)
+			if len(kv) != 2 {
+				err = fmt.Errorf("invalid module option %s; use <option>=<value>", o)
+				break
+			}
+			options[kv[0]] = kv[1]
+		}
+		if err != nil {
+			break
+		}
+		var job string
+		err = c.Call("Modules.Run", rpc.ModuleArgs{Module: args[1], Agent: args[2], Options: options}, &job)
+		if err == nil {
+			color.Green(fmt.Sprintf("[+]Created job %s for agent %s", job, args[2]))
+		}
+	default:
+		err = fmt.Errorf("invalid command %s", args[0])
+	}
+	if err != nil {
+		color.Red(fmt.Sprintf("[!]%s", err.Error()))
+		os.Exit(1)
+	}
+}
+
+// operatorDefault returns the name of the user running the client
+func operatorDefault() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	// Windows user names include the domain
+	return u.Username[strings.LastIndex(u.Username, "\\")+1:]
+}
@@ -0,0 +1,173 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	// Standard
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	// 3rd Party
+	"github.com/fatih/color"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/config"
+	"github.com/Ne0nd0g/merlin/pkg/profiles"
+)
+
+func main() {
+	var c config.Config
+	flag.StringVar(&c.URL, "url", "https://127.0.0.1:443", "Full URL for agent to connect to")
+	flag.StringVar(&c.PSK, "psk", "merlin", "Pre-Shared Key used to encrypt initial communications")
+	flag.StringVar(&c.Proxy, "proxy", "", "Hardcoded proxy to use for http/1.1 traffic only")
+	flag.StringVar(&c.Host, "host", "", "HTTP Host header")
+	flag.StringVar(&c.Protocol, "proto", "h2", "Protocol for the agent to connect with [https (HTTP/1.1), h2 (HTTP/2), hq (QUIC or HTTP/3.0)]")
+	flag.StringVar(&c.UserAgent, "ua", "", "HTTP User-Agent header; empty uses the agent's default")
+	flag.StringVar(&c.Sleep, "sleep", "", "Time for the agent to sleep between check ins (i.e. 30s); empty uses the agent's default")
+	profile := flag.String("profile", "", "HTTP profile file that shapes the agent's messages; it must match the listener's profile")
+	clientCert := flag.String("clientcert", "", "PEM file with the client certificate and key, from the listeners menu certs issue command, the agent presents to listeners")
+	engagement := flag.String("engagement", "", "Engagement ID to watermark the agent with")
+	operator := flag.String("operator", "", "Operator to watermark the agent with")
+	keyFile := flag.String("key", filepath.Join("data", "x509", "watermark.key"), "The server's watermark key file; created if it does not exist when building an agent")
+	extract := flag.String("extract", "", "Extract and print the encrypted configuration from a generated agent file")
+	verify := flag.Bool("verify", false, "With -extract, verify the configuration matches the url, psk, proxy, host, proto, ua, sleep, engagement, and operator flags that were provided")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *extract == "" {
+		if *profile != "" {
+			p, err := profiles.Load(*profile)
+			if err != nil {
+				color.Red(err.Error())
+				os.Exit(1)
+			}
+			c.Profile = p
+		}
+		if *clientCert != "" {
+			cert, err := ioutil.ReadFile(*clientCert)
+			if err != nil {
+				color.Red(fmt.Sprintf("there was an error reading %s:\r\n%s", *clientCert, err.Error()))
+				os.Exit(1)
+			}
+			c.ClientCert = string(cert)
+		}
+		if *engagement != "" || *operator != "" {
+			key, err := config.NewWatermarkKey(*keyFile)
+			if err != nil {
+				color.Red(err.Error())
+				os.Exit(1)
+			}
+			w := config.Watermark{Engagement: *engagement, Operator: *operator, Timestamp: time.Now().UTC()}
+			c.Watermark, err = config.EncryptWatermark(w, key)
+			if err != nil {
+				color.Red(err.Error())
+				os.Exit(1)
+			}
+		}
+		// Print an encrypted configuration for the Make file to embed with -ldflags -X
+		encrypted, err := config.Encrypt(c)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(encrypted)
+		return
+	}
+
+	data, err := ioutil.ReadFile(*extract)
+	if err != nil {
+		color.Red(fmt.Sprintf("there was an error reading %s:\r\n%s", *extract, err.Error()))
+		os.Exit(1)
+	}
+	embedded, err := config.Extract(data)
+	if err != nil {
+		color.Red(fmt.Sprintf("%s: %s", *extract, err.Error()))
+		os.Exit(1)
+	}
+	j, err := json.MarshalIndent(embedded, "", "  ")
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	fmt.Println(string(j))
+
+	var watermark config.Watermark
+	if embedded.Watermark != "" {
+		key, errKey := ioutil.ReadFile(*keyFile)
+		if errKey != nil {
+			color.Red(fmt.Sprintf("the watermark could not be decrypted without the key:\r\n%s", errKey.Error()))
+		} else if watermark, err = config.DecryptWatermark(embedded.Watermark, key); err != nil {
+			color.Red(err.Error())
+		} else {
+			color.Green(fmt.Sprintf("Watermark %s", watermark))
+		}
+	}
+
+	if !*verify {
+		return
+	}
+	// Only the flags that were explicitly provided are compared
+	mismatch := false
+	flag.Visit(func(f *flag.Flag) {
+		var value string
+		switch f.Name {
+		case "url":
+			value = embedded.URL
+		case "psk":
+			value = embedded.PSK
+		case "proxy":
+			value = embedded.Proxy
+		case "host":
+			value = embedded.Host
+		case "proto":
+			value = embedded.Protocol
+		case "ua":
+			value = embedded.UserAgent
+		case "sleep":
+			value = embedded.Sleep
+		case "engagement":
+			value = watermark.Engagement
+		case "operator":
+			value = watermark.Operator
+		default:
+			return
+		}
+		if value != f.Value.String() {
+			color.Red(fmt.Sprintf("%s does not match: the agent has \"%s\" and \"%s\" was expected", f.Name, value, f.Value.String()))
+			mismatch = true
+		}
+	})
+	if mismatch {
+		os.Exit(1)
+	}
+	color.Green(fmt.Sprintf("%s matches the provided configuration", *extract))
+}
+
+// usage prints command line options
+func usage() {
+	fmt.Printf("Merlin Agent Configuration\r\n")
+	fmt.Printf("Encrypts an agent configuration for embedding at build time, or extracts and verifies it from an agent\r\n")
+	fmt.Printf("The key is embedded with the configuration, so it is obfuscated, not kept secret from anyone with the agent\r\n")
+	flag.PrintDefaults()
+	os.Exit(0)
+}
@@ -0,0 +1,64 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// merlinedge is a lightweight edge listener node deployed near targets. It terminates agents' HTTPS connections and
+// relays their requests to the central Merlin server's edge listener over mutual TLS with the credentials created by
+// the central server's listeners menu edge enroll command.
+package main
+
+import (
+	// Standard
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg"
+	"github.com/Ne0nd0g/merlin/pkg/edge"
+)
+
+// build is the build number of the edge node
+var build = "nonRelease"
+
+func main() {
+	central := flag.String("central", "", "The central server's edge listener URL (i.e. https://10.0.0.5:7443)")
+	creds := flag.String("creds", ".", "The directory with the node.crt, node.key, and ca.crt files created when the node was enrolled")
+	iface := flag.String("i", "0.0.0.0", "The IP address of the interface agents connect to")
+	port := flag.Int("p", 443, "The port agents connect to")
+	certificate := flag.String("x509cert", "", "The x.509 public key file presented to agents; an ephemeral certificate is used if it is empty")
+	key := flag.String("x509key", "", "The x.509 private key file presented to agents")
+	flag.Usage = func() {
+		fmt.Printf("Merlin edge node version %s build %s\n", merlin.Version, build)
+		fmt.Println("Usage: merlinedge -central <URL> -creds <directory> [flags]")
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+	flag.Parse()
+
+	r, err := edge.New(*central, *creds, *iface, *port, *certificate, *key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = r.Ping(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Relaying agents on %s:%d to %s", r.Interface, r.Port, r.Central)
+	if err = r.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -19,21 +19,47 @@ package main
 
 import (
 	// Standard
+	"context"
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"os/signal"
+	"os/user"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	// 3rd Party
 	"github.com/fatih/color"
 
 	// Merlin
 	"github.com/Ne0nd0g/merlin/pkg"
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/api/rest"
+	"github.com/Ne0nd0g/merlin/pkg/api/rpc"
+	"github.com/Ne0nd0g/merlin/pkg/backup"
 	"github.com/Ne0nd0g/merlin/pkg/banner"
 	"github.com/Ne0nd0g/merlin/pkg/cli"
+	"github.com/Ne0nd0g/merlin/pkg/clipboard"
 	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/demo"
+	"github.com/Ne0nd0g/merlin/pkg/events"
+	"github.com/Ne0nd0g/merlin/pkg/generate"
 	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/migrate"
+	"github.com/Ne0nd0g/merlin/pkg/modules"
+	"github.com/Ne0nd0g/merlin/pkg/observer"
+	"github.com/Ne0nd0g/merlin/pkg/profiles"
+	"github.com/Ne0nd0g/merlin/pkg/redact"
+	"github.com/Ne0nd0g/merlin/pkg/scoring"
 	"github.com/Ne0nd0g/merlin/pkg/servers/http2"
+	"github.com/Ne0nd0g/merlin/pkg/storage"
+	"github.com/Ne0nd0g/merlin/pkg/util"
+	"github.com/Ne0nd0g/merlin/pkg/webhook"
 )
 
 // Global Variables
@@ -46,46 +72,421 @@ func main() {
 	flag.BoolVar(&core.Verbose, "v", false, "Enable verbose output")
 	flag.BoolVar(&core.Debug, "debug", false, "Enable debug output")
 	port := flag.Int("p", 443, "Merlin Server Port")
-	ip := flag.String("i", "127.0.0.1", "The IP address of the interface to bind to")
+	ip := flag.String("i", "127.0.0.1", "The IPv4 or IPv6 address of the interface to bind to; use :: to bind to every IPv4 and IPv6 interface")
 	proto := flag.String("proto", "h2", "Protocol for the agent to connect with [h2, hq]")
 	crt := flag.String("x509cert", filepath.Join(string(core.CurrentDir), "data", "x509", "server.crt"),
 		"The x509 certificate for the HTTPS listener")
 	key := flag.String("x509key", filepath.Join(string(core.CurrentDir), "data", "x509", "server.key"),
 		"The x509 certificate key for the HTTPS listener")
 	flag.StringVar(&psk, "psk", psk, "Pre-Shared Key used to encrypt initial communications")
+	profileFile := flag.String("profile", "", "HTTP profile file that shapes agent messages (i.e. data/profiles/jquery.json)")
+	backupInterval := flag.Duration("backup", 0, "Interval to create an encrypted backup of the engagement data (i.e. 4h)")
+	flag.StringVar(&backup.Remote, "backup-remote", "", "Directory or HTTP(S) URL to copy backups to with a PUT request")
+	rpcAddr := flag.String("rpc", "", "Address for the team server to listen on for operators' merlinclient connections (i.e. 0.0.0.0:50051)")
+	authFile := flag.String("auth", filepath.Join(core.CurrentDir, "data", "auth.json"), "Team server authentication configuration with the local, LDAP, and OIDC backends operators log in with and their group to role mapping; local accounts only if it does not exist")
+	apiAddr := flag.String("api", "", "Interface and port to start the REST API on (i.e. 127.0.0.1:50050); disabled if empty")
+	apiToken := flag.String("api-token", "", "Bearer token for the REST API; a random token is generated if empty")
+	apiObserver := flag.String("api-observer-token", "", "Bearer token for read-only REST API observers; disabled if empty")
+	observe := flag.String("observe", "", "Observe the primary server's REST API at this URL read-only using -api-token")
+	observePin := flag.String("observe-pin", "", "SHA256 fingerprint of the primary server's REST API certificate")
+	observeInterval := flag.Duration("observe-interval", 10*time.Second, "How often to poll the primary server")
+	store := flag.String("storage", "sqlite", "Where to keep agents and queued jobs across restarts [sqlite, memory]")
+	scoringFile := flag.String("scoring", "", "Capture-the-flag scoring rules file; awards points and calls its webhook when jobs succeed")
+	webhookFile := flag.String("webhooks", filepath.Join(core.CurrentDir, "data", "webhooks.json"), "Slack, Discord, and generic webhooks notified of new agents, dead agents, and job output keywords; managed with the notifications command")
+	demoAgents := flag.Int("demo", 0, "Start this many simulated agents that respond with canned output for training and demonstrations")
+	flag.StringVar(&modules.Index, "module-index", "", "URL or file path of the module index used by 'modules install'")
+	flag.BoolVar(&modules.RequireSigned, "require-signed-modules", false, "Refuse to load modules without a signature from a key in data/modules/trusted.keys")
+	signModule := flag.String("sign-module", "", "Sign a module's JSON file with -module-key, print the public key to trust, and exit")
+	moduleKey := flag.String("module-key", filepath.Join(core.CurrentDir, "data", "x509", "module-signing.key"),
+		"The Ed25519 module signing key; it is created if it does not exist")
+	flag.StringVar(&generate.Output, "generate-output", generate.Output, "The directory agents built with the generate command are written to")
+	throttleMax := flag.Int("throttle-max", 0, "The most agents a module or group job runs on at once; 0 is unlimited")
+	throttleStagger := flag.Duration("throttle-stagger", 0, "The time between releasing a module or group job to one agent and the next (i.e. 30s)")
+	approve := flag.Bool("approve", false, "Hold agents that register as pending until they are accepted with the accept command")
+	flag.BoolVar(&clipboard.Disabled, "no-clipboard", false, "Do not copy to the operator's clipboard with the copy commands, such as on hardened hosts")
+	redactFile := flag.String("redact", filepath.Join(core.CurrentDir, "data", "redact.txt"), "File of regular expressions, one per line, for secrets masked in the console, history, and logs")
+	operatorName := flag.String("operator", operatorDefault(), "The operator profile that keeps your console preferences, such as the confirmation policy, in data/operators")
+	validate := flag.Bool("validate", false, "Load all modules, HTTP profiles, listener templates, and the listener configuration, report any errors, and exit")
+	headless := flag.Bool("headless", false, "Run without the interactive command line interface, such as under systemd or in a container; requires -api or -rpc")
+	busURL := flag.String("bus", "", "NATS or Redis broker the server shares events with other server processes on, such as an API node and its listener nodes (i.e. nats://127.0.0.1:4222 or redis://:password@127.0.0.1:6379/merlin.events)")
+	nodeName := flag.String("node", "", "The name this server publishes its events as on the -bus; the host name and process ID if empty")
+	listenersFile := flag.String("listeners", "", "JSON file of listeners to start with the server (i.e. [{\"protocol\": \"dns\", \"options\": {\"Domain\": \"example.com\"}}])")
 	flag.Usage = func() {
 		color.Blue("#################################################")
 		color.Blue("#\t\tMERLIN SERVER\t\t\t#")
 		color.Blue("#################################################")
 		color.Blue("Version: " + merlin.Version)
 		color.Blue("Build: " + build)
+		color.Blue("Usage: merlinserver [flags] [migrate status|up]")
 		flag.PrintDefaults()
 		os.Exit(0)
 	}
 	flag.Parse()
 
+	if flag.Arg(0) == "migrate" {
+		if !migration(flag.Arg(1)) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if *observe != "" {
+		color.Blue(banner.MerlinBanner1)
+		o, err := observer.New(*observe, *apiToken, *observePin, filepath.Join(core.CurrentDir, "data", "observer"),
+			*observeInterval)
+		if err != nil {
+			color.Red(fmt.Sprintf("[!]%s", err.Error()))
+			os.Exit(1)
+		}
+		// The observer keeps retrying the primary server until it is stopped with ctrl-c or SIGTERM
+		ctx, cancel := context.WithCancel(context.Background())
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-stop
+			cancel()
+		}()
+		if err := o.Run(ctx); err != nil && err != context.Canceled {
+			color.Red(fmt.Sprintf("[!]%s", err.Error()))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if *signModule != "" {
+		publicKey, err := modules.Sign(*signModule, *moduleKey)
+		if err != nil {
+			color.Red(fmt.Sprintf("[!]%s", err.Error()))
+			os.Exit(1)
+		}
+		color.Green(fmt.Sprintf("[+]Signed %s; trust the signature by adding this line to data/modules/trusted.keys:", *signModule))
+		fmt.Printf("%s <author>\n", publicKey)
+		os.Exit(0)
+	}
+
+	if *validate {
+		if !validateConfiguration(*ip, *port, *proto, *key, *crt, *listenersFile) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// A headless server can only be managed through the REST API or the team server
+	if *headless && *apiAddr == "" && *rpcAddr == "" {
+		color.Red("[!]A headless server requires the REST API (-api) or the team server (-rpc)")
+		os.Exit(1)
+	}
+
 	color.Blue(banner.MerlinBanner1)
 	color.Blue("\t\t   Version: %s", merlin.Version)
 	color.Blue("\t\t   Build: %s", build)
 
-	// Start Merlin Command Line Interface
-	go cli.Shell()
+	if *busURL != "" {
+		if err := events.Connect(*busURL, *nodeName); err != nil {
+			color.Red(fmt.Sprintf("[!]%s", err.Error()))
+			os.Exit(1)
+		}
+		color.Cyan(fmt.Sprintf("[i]Sharing events on the event bus at %s", *busURL))
+	}
+
+	// The SQLite driver requires cgo, which cross-compiled servers are built without
+	if *store == "sqlite" && !storage.SQLiteSupported {
+		color.Yellow("[-]This server was built without cgo, which the SQLite storage requires; agents and queued jobs " +
+			"are kept in memory and lost when the server restarts")
+		*store = "memory"
+	}
+
+	// Opening the SQLite storage applies any pending data directory migrations
+	s, errStorage := storage.Open(*store, filepath.Join(core.CurrentDir, "data"))
+	if errStorage != nil {
+		color.Red(fmt.Sprintf("[!]There was an error opening the %s storage; use \"-storage memory\" to start "+
+			"without it:\r\n%s", *store, errStorage.Error()))
+		os.Exit(1)
+	}
+	storage.Use(s)
+	restored, errRestore := agents.Restore()
+	if errRestore != nil {
+		color.Red(fmt.Sprintf("[!]%s", errRestore.Error()))
+	} else if restored > 0 {
+		color.Cyan(fmt.Sprintf("[i]Restored %d agents from storage", restored))
+	}
+	agents.MonitorStatus()
+	agents.RunScheduler()
+
+	if *scoringFile != "" {
+		if err := scoring.Load(*scoringFile, scoringReport); err != nil {
+			color.Red(fmt.Sprintf("[!]%s", err.Error()))
+			os.Exit(1)
+		}
+		color.Cyan(fmt.Sprintf("[i]Loaded capture-the-flag scoring rules from %s", *scoringFile))
+	}
+
+	if err := webhook.Load(*webhookFile); err != nil {
+		color.Red(fmt.Sprintf("[!]%s", err.Error()))
+		os.Exit(1)
+	}
+	if n := len(webhook.Webhooks()); n > 0 {
+		color.Cyan(fmt.Sprintf("[i]Loaded %d notification webhooks from %s", n, *webhookFile))
+	}
+
+	if *demoAgents > 0 {
+		ids, err := demo.Start(*demoAgents, 10*time.Second)
+		if err != nil {
+			color.Red(fmt.Sprintf("[!]%s", err.Error()))
+		}
+		color.Yellow(fmt.Sprintf("[-]Demo mode: started %d simulated agents; they are not real hosts and only return canned output", len(ids)))
+	}
+
+	if *backupInterval > 0 {
+		cli.ScheduleBackup(*backupInterval)
+	}
+
+	if err := agents.SetThrottle(*throttleMax, *throttleStagger); err != nil {
+		color.Red(fmt.Sprintf("[!]%s", err.Error()))
+		os.Exit(1)
+	}
+	agents.SetApproval(*approve)
+	if _, err := os.Stat(*redactFile); err == nil {
+		if err = redact.Load(*redactFile); err != nil {
+			color.Red(fmt.Sprintf("[!]%s", err.Error()))
+			os.Exit(1)
+		}
+	}
+
+	if *rpcAddr != "" {
+		if _, err := os.Stat(*authFile); err == nil {
+			if err = rpc.LoadAuth(*authFile); err != nil {
+				color.Red(fmt.Sprintf("[!]%s", err.Error()))
+				os.Exit(1)
+			}
+		}
+		if err := rpc.Listen(*rpcAddr, *crt, *key, cli.Remote); err != nil {
+			color.Red(fmt.Sprintf("[!]%s", err.Error()))
+			os.Exit(1)
+		}
+	}
 
-	// Start Merlin Server to listen for agents
-	server, err := http2.New(*ip, *port, *proto, *key, *crt, psk)
+	if *apiAddr != "" {
+		if *apiToken == "" {
+			*apiToken = core.RandStringBytesMaskImprSrc(32)
+			color.Yellow(fmt.Sprintf("[-]REST API bearer token: %s", *apiToken))
+		}
+		api, err := rest.New(*apiAddr, *apiToken, *crt, *key, psk)
+		if err != nil {
+			color.Red(fmt.Sprintf("[!]There was an error creating the REST API:\r\n%s", err.Error()))
+			os.Exit(1)
+		}
+		if *apiObserver != "" {
+			api.AllowObservers(*apiObserver)
+		}
+		go func() {
+			if err := api.Run(); err != nil {
+				m := fmt.Sprintf("There was an error starting the REST API:\r\n%s", err.Error())
+				logging.Server(m)
+				color.Red("[!]" + m)
+			}
+		}()
+	}
+
+	// Create the Merlin Server to listen for agents
+	var profile *profiles.Profile
+	if *profileFile != "" {
+		var err error
+		profile, err = profiles.Load(*profileFile)
+		if err != nil {
+			color.Red(fmt.Sprintf("[!]%s", err.Error()))
+			os.Exit(1)
+		}
+	}
+	server, err := http2.NewWithProfile(*ip, *port, *proto, *key, *crt, psk, profile)
 	if err != nil {
 		color.Red(fmt.Sprintf("[!]There was an error creating a new server instance:\r\n%s", err.Error()))
 		os.Exit(1)
+	}
+
+	// Start Merlin Command Line Interface
+	if err = cli.SetOperator(*operatorName); err != nil {
+		color.Red(fmt.Sprintf("[!]There was an error loading the operator profile:\r\n%s", err.Error()))
+	}
+	cli.SetPSK(psk)
+	cli.SetServer(server.ID, "https://"+util.JoinHostPort(*ip, strconv.Itoa(*port)), *proto, *profileFile)
+	if *listenersFile != "" {
+		started, err := cli.StartListeners(*listenersFile)
+		if err != nil {
+			color.Red(fmt.Sprintf("[!]%s", err.Error()))
+			logging.Server(err.Error())
+			if *headless && started == 0 {
+				os.Exit(1)
+			}
+		}
+		logging.Server(fmt.Sprintf("Started %d listeners from %s", started, *listenersFile))
+	}
+	if *headless {
+		m := "Running headless; manage the server with the REST API or the team server"
+		color.Cyan("[i]" + m)
+		logging.Server(m)
+		// systemd and container runtimes stop the server with SIGTERM
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-stop
+			logging.Server(fmt.Sprintf("Shutting down headless Merlin Server due to %s", sig))
+			os.Exit(0)
+		}()
 	} else {
-		err := server.Run()
+		go cli.Shell()
+	}
+
+	// Start the Merlin Server
+	rest.AddListener(&server)
+	agents.RegisterListener(server.ID, &server)
+	if err := server.Run(); err != nil {
+		color.Red(fmt.Sprintf("[!]There was an error starting the server:\r\n%s", err.Error()))
+		os.Exit(1)
+	}
+}
+
+// scoringReport logs whether a capture-the-flag score was delivered to the scoring webhook
+func scoringReport(s scoring.Score, err error) {
+	if err != nil {
+		m := fmt.Sprintf("There was an error sending the %s score for %s to the webhook:\r\n%s", s.Rule, s.Host, err.Error())
+		logging.Server(m)
+		color.Red("[!]" + m)
+		return
+	}
+	logging.Server(fmt.Sprintf("Sent the %s score for %s to the webhook", s.Rule, s.Host))
+}
+
+// migration shows the status of, or applies, the data directory migrations and returns false if there was an error
+func migration(command string) bool {
+	dataDir := filepath.Join(core.CurrentDir, "data")
+	switch command {
+	case "status":
+		current, err := migrate.Current(dataDir)
 		if err != nil {
-			color.Red(fmt.Sprintf("[!]There was an error starting the server:\r\n%s", err.Error()))
-			os.Exit(1)
+			color.Red(fmt.Sprintf("[!]%s", err.Error()))
+			return false
+		}
+		status, err := migrate.GetStatus(dataDir)
+		if err != nil {
+			color.Red(fmt.Sprintf("[!]%s", err.Error()))
+			return false
+		}
+		color.Cyan(fmt.Sprintf("[i]Data directory schema version %d of %d", current, migrate.Latest()))
+		for _, s := range status {
+			if s.Applied.IsZero() {
+				color.Yellow(fmt.Sprintf("[-]%d %s: pending", s.Version, s.Description))
+			} else {
+				color.Green(fmt.Sprintf("[+]%d %s: applied %s", s.Version, s.Description,
+					s.Applied.Format(time.RFC3339)))
+			}
 		}
+	case "up":
+		done, err := migrate.Up(dataDir)
+		for _, m := range done {
+			color.Green(fmt.Sprintf("[+]Applied migration %d %s", m.Version, m.Description))
+			logging.Server(fmt.Sprintf("Applied data directory migration %d %s", m.Version, m.Description))
+		}
+		if err != nil {
+			color.Red(fmt.Sprintf("[!]%s", err.Error()))
+			return false
+		}
+		color.Cyan(fmt.Sprintf("[i]Data directory is at schema version %d", migrate.Latest()))
+	default:
+		color.Red("[!]Invalid migrate command; use \"migrate status\" or \"migrate up\"")
+		return false
 	}
+	return true
+}
+
+// validateConfiguration loads every module, HTTP profile, listener template, and the listener configuration without
+// starting the server or the command line interface so that errors are found before an engagement instead of when the
+// module, profile, or listener is used. It returns false if any errors were found
+func validateConfiguration(ip string, port int, proto string, key string, crt string, listenersFile string) bool {
+	valid := true
+
+	count, errs := modules.Validate()
+	if !reportErrors("Module", errs) {
+		valid = false
+	}
+	color.Cyan(fmt.Sprintf("[i]Checked %d modules, %d with errors", count, len(errs)))
+
+	count, errs = profiles.ValidateDirectory(filepath.Join(core.CurrentDir, "data", "profiles"))
+	if !reportErrors("Profile", errs) {
+		valid = false
+	}
+	color.Cyan(fmt.Sprintf("[i]Checked %d profiles, %d with errors", count, len(errs)))
+
+	count, errs = cli.ValidateListenerTemplates()
+	if !reportErrors("Listener template", errs) {
+		valid = false
+	}
+	color.Cyan(fmt.Sprintf("[i]Checked %d listener templates, %d with errors", count, len(errs)))
+
+	if net.ParseIP(ip) == nil {
+		color.Red(fmt.Sprintf("[!]Listener interface %s is not a valid IP address", ip))
+		valid = false
+	}
+	if port < 1 || port > 65535 {
+		color.Red(fmt.Sprintf("[!]Listener port %d is not between 1 and 65535", port))
+		valid = false
+	}
+	if _, err := http2.New(ip, port, proto, key, crt, psk); err != nil {
+		color.Red(fmt.Sprintf("[!]There was an error creating a new server instance:\r\n%s", err.Error()))
+		valid = false
+	} else {
+		color.Cyan(fmt.Sprintf("[i]Checked %s listener configuration for %s", proto, util.JoinHostPort(ip, strconv.Itoa(port))))
+	}
+
+	if listenersFile != "" {
+		count, err := cli.ValidateListeners(listenersFile)
+		if err != nil {
+			color.Red(fmt.Sprintf("[!]%s", err.Error()))
+			valid = false
+		} else {
+			color.Cyan(fmt.Sprintf("[i]Checked %d listeners in %s", count, listenersFile))
+		}
+	}
+
+	if valid {
+		color.Green("[+]No errors found")
+	}
+	return valid
+}
+
+// reportErrors prints the errors found in the files, sorted by their path, and returns false if there were any
+func reportErrors(kind string, errs map[string]error) bool {
+	var paths []string
+	for p := range errs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		color.Red(fmt.Sprintf("[!]%s %s:\r\n%s", kind, p, errs[p].Error()))
+	}
+	return len(errs) == 0
 }
 
 // TODO add CSRF tokens
 // TODO check if agentLog exists even outside of InitialCheckIn
 // TODO readline for file paths to use with upload
 // TODO handle file names containing a space for upload/download
+
+// operatorDefault returns the name of the user running the server, without a Windows domain, for the operator profile.
+// Characters that can not be used in a file name are replaced with underscores.
+func operatorDefault() string {
+	u, err := user.Current()
+	if err != nil {
+		return "operator"
+	}
+	name := u.Username
+	if i := strings.LastIndex(name, "\\"); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 0x80 && (r == '.' || r == '-' || r == '_' || (r >= '0' && r <= '9') || (r|0x20 >= 'a' && r|0x20 <= 'z')) {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,544 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package acme obtains and renews TLS certificates from an RFC 8555 ACME certificate authority, such as Let's Encrypt.
+// The tls-alpn-01 challenge is answered by the HTTPS listener that uses the certificate so no other port is opened.
+package acme
+
+import (
+	// Standard
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LetsEncrypt is the directory URL of Let's Encrypt's production ACME server
+const LetsEncrypt = "https://acme-v02.api.letsencrypt.org/directory"
+
+// ALPNProto is the TLS application protocol the certificate authority uses to validate the tls-alpn-01 challenge
+const ALPNProto = "acme-tls/1"
+
+// RenewBefore is how long before a certificate expires that it is renewed
+var RenewBefore = 30 * 24 * time.Hour
+
+// pollInterval is how long to wait between checks of an authorization or order the certificate authority is processing
+var pollInterval = 2 * time.Second
+
+// idPeAcmeIdentifier is the certificate extension that holds the tls-alpn-01 key authorization hash (RFC 8737)
+var idPeAcmeIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// validDomain matches the fully qualified domain names a certificate can be requested for; wildcards require the
+// dns-01 challenge, which is not supported
+var validDomain = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.)+[A-Za-z]{2,63}$`)
+
+// Manager obtains and renews the certificate for a domain and answers its tls-alpn-01 challenges
+type Manager struct {
+	Domain     string // The fully qualified domain name the certificate is for
+	Email      string // The contact address registered with the certificate authority; empty registers none
+	Directory  string // The ACME server's directory URL
+	Dir        string // The directory the account key, certificate, and key are stored in
+	client     *http.Client
+	accountKey *ecdsa.PrivateKey
+	kid        string // The account URL used to sign requests once the account is registered
+	nonce      string
+	dir        directory
+	mu         sync.RWMutex
+	challenge  *tls.Certificate // The tls-alpn-01 challenge certificate while an authorization is pending
+}
+
+// directory is the ACME server's resource URLs
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+// problem is an ACME error document (RFC 7807)
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+// order is an ACME certificate order
+type order struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+	Error          *problem `json:"error"`
+}
+
+// authorization is the certificate authority's validation of the domain and the challenges that prove control of it
+type authorization struct {
+	Status     string `json:"status"`
+	Challenges []struct {
+		Type   string   `json:"type"`
+		URL    string   `json:"url"`
+		Token  string   `json:"token"`
+		Status string   `json:"status"`
+		Error  *problem `json:"error"`
+	} `json:"challenges"`
+}
+
+// New returns a manager for the domain's certificate from the ACME server at the directory URL that stores its files
+// in dir. The account key is created the first time it is used and shared by every domain in dir.
+func New(domain string, email string, directoryURL string, dir string) (*Manager, error) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if !validDomain.MatchString(domain) {
+		return nil, fmt.Errorf("%s is not a valid domain name for an ACME certificate", domain)
+	}
+	if directoryURL == "" {
+		directoryURL = LetsEncrypt
+	}
+	if !strings.HasPrefix(directoryURL, "https://") {
+		return nil, fmt.Errorf("%s is not an https ACME directory URL", directoryURL)
+	}
+	return &Manager{
+		Domain:    domain,
+		Email:     email,
+		Directory: directoryURL,
+		Dir:       dir,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Cached returns the certificate stored in the manager's directory by an earlier Obtain
+func (m *Manager) Cached() (*tls.Certificate, error) {
+	cer, err := tls.LoadX509KeyPair(m.file(".crt"), m.file(".key"))
+	if err != nil {
+		return nil, err
+	}
+	if cer.Leaf, err = x509.ParseCertificate(cer.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cer, nil
+}
+
+// NeedsRenewal returns true if the certificate expires within RenewBefore
+func NeedsRenewal(cert *tls.Certificate) bool {
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return true
+		}
+	}
+	return time.Until(leaf.NotAfter) < RenewBefore
+}
+
+// ChallengeCertificate returns the tls-alpn-01 challenge certificate when the TLS client is the certificate authority
+// validating the domain. It is used by the listener's GetCertificate callback.
+func (m *Manager) ChallengeCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, bool) {
+	if !strings.EqualFold(hello.ServerName, m.Domain) {
+		return nil, false
+	}
+	for _, proto := range hello.SupportedProtos {
+		if proto == ALPNProto {
+			m.mu.RLock()
+			defer m.mu.RUnlock()
+			return m.challenge, m.challenge != nil
+		}
+	}
+	return nil, false
+}
+
+// Obtain orders a new certificate for the domain, answers its tls-alpn-01 challenge, and stores the certificate and
+// its key in the manager's directory. The listener using the manager's ChallengeCertificate must be reachable on port
+// 443 of the domain while the order is validated.
+func (m *Manager) Obtain(ctx context.Context) (*tls.Certificate, error) {
+	if err := m.register(ctx); err != nil {
+		return nil, err
+	}
+	payload := map[string]interface{}{"identifiers": []map[string]string{{"type": "dns", "value": m.Domain}}}
+	resp, body, err := m.post(ctx, m.dir.NewOrder, payload)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error creating the ACME order:\r\n%s", err.Error())
+	}
+	orderURL := resp.Header.Get("Location")
+	var o order
+	if err = json.Unmarshal(body, &o); err != nil {
+		return nil, fmt.Errorf("there was an error parsing the ACME order:\r\n%s", err.Error())
+	}
+	for _, a := range o.Authorizations {
+		if err = m.authorize(ctx, a); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error generating the certificate's key:\r\n%s", err.Error())
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.Domain},
+		DNSNames: []string{m.Domain},
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error creating the certificate signing request:\r\n%s", err.Error())
+	}
+	if _, body, err = m.post(ctx, o.Finalize, map[string]string{"csr": encode(csr)}); err != nil {
+		return nil, fmt.Errorf("there was an error finalizing the ACME order:\r\n%s", err.Error())
+	}
+	for {
+		if err = json.Unmarshal(body, &o); err != nil {
+			return nil, fmt.Errorf("there was an error parsing the ACME order:\r\n%s", err.Error())
+		}
+		if o.Status == "valid" {
+			break
+		}
+		if o.Status == "invalid" {
+			return nil, fmt.Errorf("the ACME order for %s is invalid: %s", m.Domain, o.Error.message())
+		}
+		if err = wait(ctx); err != nil {
+			return nil, err
+		}
+		if _, body, err = m.post(ctx, orderURL, nil); err != nil {
+			return nil, fmt.Errorf("there was an error checking the ACME order:\r\n%s", err.Error())
+		}
+	}
+	if _, body, err = m.post(ctx, o.Certificate, nil); err != nil {
+		return nil, fmt.Errorf("there was an error downloading the certificate:\r\n%s", err.Error())
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	cer, err := tls.X509KeyPair(body, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("the certificate authority returned an invalid certificate:\r\n%s", err.Error())
+	}
+	if cer.Leaf, err = x509.ParseCertificate(cer.Certificate[0]); err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(m.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("there was an error creating the ACME directory %s:\r\n%s", m.Dir, err.Error())
+	}
+	if err = ioutil.WriteFile(m.file(".key"), keyPEM, 0600); err != nil {
+		return nil, fmt.Errorf("there was an error writing the certificate's key:\r\n%s", err.Error())
+	}
+	if err = ioutil.WriteFile(m.file(".crt"), body, 0600); err != nil {
+		return nil, fmt.Errorf("there was an error writing the certificate:\r\n%s", err.Error())
+	}
+	return &cer, nil
+}
+
+// register reads the ACME directory and registers the account key, which returns the existing account if it was
+// registered before
+func (m *Manager) register(ctx context.Context) error {
+	if m.kid != "" {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodGet, m.Directory, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("there was an error getting the ACME directory:\r\n%s", err.Error())
+	}
+	defer resp.Body.Close() // #nosec G307
+	if err = json.NewDecoder(resp.Body).Decode(&m.dir); err != nil || m.dir.NewOrder == "" {
+		return fmt.Errorf("%s is not an ACME directory", m.Directory)
+	}
+	if m.accountKey, err = accountKey(filepath.Join(m.Dir, "account.key")); err != nil {
+		return err
+	}
+	account := map[string]interface{}{"termsOfServiceAgreed": true}
+	if m.Email != "" {
+		account["contact"] = []string{"mailto:" + m.Email}
+	}
+	resp, _, err = m.post(ctx, m.dir.NewAccount, account)
+	if err != nil {
+		return fmt.Errorf("there was an error registering the ACME account:\r\n%s", err.Error())
+	}
+	m.kid = resp.Header.Get("Location")
+	return nil
+}
+
+// authorize answers the authorization's tls-alpn-01 challenge and waits for the certificate authority to validate it
+func (m *Manager) authorize(ctx context.Context, authzURL string) error {
+	_, body, err := m.post(ctx, authzURL, nil)
+	if err != nil {
+		return fmt.Errorf("there was an error getting the ACME authorization:\r\n%s", err.Error())
+	}
+	var a authorization
+	if err = json.Unmarshal(body, &a); err != nil {
+		return fmt.Errorf("there was an error parsing the ACME authorization:\r\n%s", err.Error())
+	}
+	if a.Status == "valid" {
+		return nil
+	}
+	var challengeURL string
+	for _, c := range a.Challenges {
+		if c.Type != "tls-alpn-01" {
+			continue
+		}
+		cert, err := challengeCertificate(m.Domain, c.Token+"."+thumbprint(&m.accountKey.PublicKey))
+		if err != nil {
+			return err
+		}
+		m.mu.Lock()
+		m.challenge = cert
+		m.mu.Unlock()
+		challengeURL = c.URL
+	}
+	if challengeURL == "" {
+		return fmt.Errorf("the certificate authority did not offer a tls-alpn-01 challenge for %s", m.Domain)
+	}
+	defer func() {
+		m.mu.Lock()
+		m.challenge = nil
+		m.mu.Unlock()
+	}()
+	if _, _, err = m.post(ctx, challengeURL, struct{}{}); err != nil {
+		return fmt.Errorf("there was an error answering the ACME challenge:\r\n%s", err.Error())
+	}
+	for {
+		if err = wait(ctx); err != nil {
+			return err
+		}
+		if _, body, err = m.post(ctx, authzURL, nil); err != nil {
+			return fmt.Errorf("there was an error checking the ACME authorization:\r\n%s", err.Error())
+		}
+		if err = json.Unmarshal(body, &a); err != nil {
+			return fmt.Errorf("there was an error parsing the ACME authorization:\r\n%s", err.Error())
+		}
+		switch a.Status {
+		case "valid":
+			return nil
+		case "pending", "processing":
+			continue
+		}
+		var p *problem
+		for _, c := range a.Challenges {
+			if c.Type == "tls-alpn-01" {
+				p = c.Error
+			}
+		}
+		return fmt.Errorf("the certificate authority could not validate %s: %s", m.Domain, p.message())
+	}
+}
+
+// post sends a JWS signed request to the ACME server; a nil payload is a POST-as-GET request. A request rejected for
+// its nonce is sent again once with the new nonce the server returned.
+func (m *Manager) post(ctx context.Context, url string, payload interface{}) (*http.Response, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		if m.nonce == "" {
+			req, err := http.NewRequest(http.MethodHead, m.dir.NewNonce, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			resp, err := m.client.Do(req.WithContext(ctx))
+			if err != nil {
+				return nil, nil, err
+			}
+			_ = resp.Body.Close()
+			m.nonce = resp.Header.Get("Replay-Nonce")
+		}
+		data, err := m.sign(url, payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header.Set("Content-Type", "application/jose+json")
+		resp, err := m.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		m.nonce = resp.Header.Get("Replay-Nonce")
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.StatusCode < 400 {
+			return resp, body, nil
+		}
+		var p problem
+		_ = json.Unmarshal(body, &p)
+		if p.Type == "urn:ietf:params:acme:error:badNonce" && attempt == 0 {
+			continue
+		}
+		return nil, nil, fmt.Errorf("the ACME server returned %s: %s", resp.Status, p.message())
+	}
+}
+
+// sign returns the flattened JWS of the payload signed with the account key. The key is sent as a JWK until the
+// account is registered and then its URL is sent instead.
+func (m *Manager) sign(url string, payload interface{}) ([]byte, error) {
+	protected := map[string]interface{}{"alg": "ES256", "nonce": m.nonce, "url": url}
+	if m.kid == "" {
+		protected["jwk"] = jwk(&m.accountKey.PublicKey)
+	} else {
+		protected["kid"] = m.kid
+	}
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	var body string
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = encode(data)
+	}
+	input := encode(header) + "." + body
+	hash := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, m.accountKey, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return json.Marshal(map[string]string{"protected": encode(header), "payload": body, "signature": encode(signature)})
+}
+
+// file returns the path of the domain's certificate or key file in the manager's directory
+func (m *Manager) file(extension string) string {
+	return filepath.Join(m.Dir, m.Domain+extension)
+}
+
+// message returns the problem's detail for an error message
+func (p *problem) message() string {
+	if p == nil {
+		return "no reason was given"
+	}
+	if p.Detail == "" {
+		return p.Type
+	}
+	return p.Detail
+}
+
+// accountKey reads the ACME account key from the file and creates the file with a new key if it does not exist
+func accountKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path) // #nosec G304 - The file is in Merlin's data directory
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s does not contain a PEM encoded key", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("there was an error reading the ACME account key:\r\n%s", err.Error())
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error generating the ACME account key:\r\n%s", err.Error())
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("there was an error creating the ACME directory:\r\n%s", err.Error())
+	}
+	if err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, fmt.Errorf("there was an error writing the ACME account key:\r\n%s", err.Error())
+	}
+	return key, nil
+}
+
+// challengeCertificate returns the self-signed tls-alpn-01 certificate for the domain that holds the SHA-256 hash of
+// the key authorization in its acmeIdentifier extension. It uses an RSA key because the listeners only offer
+// ECDHE_RSA cipher suites for TLS 1.2.
+func challengeCertificate(domain string, keyAuthorization string) (*tls.Certificate, error) {
+	hash := sha256.Sum256([]byte(keyAuthorization))
+	value, err := asn1.Marshal(hash[:])
+	if err != nil {
+		return nil, err
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error generating the challenge certificate's key:\r\n%s", err.Error())
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:    serial,
+		Subject:         pkix.Name{CommonName: domain},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(24 * time.Hour),
+		DNSNames:        []string{domain},
+		ExtraExtensions: []pkix.Extension{{Id: idPeAcmeIdentifier, Critical: true, Value: value}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error creating the challenge certificate:\r\n%s", err.Error())
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: crypto.Signer(key)}, nil
+}
+
+// jwk returns the JSON Web Key of the account's public key
+func jwk(key *ecdsa.PublicKey) map[string]string {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	return map[string]string{"crv": "P-256", "kty": "EC", "x": encode(x), "y": encode(y)}
+}
+
+// thumbprint returns the JWK thumbprint (RFC 7638) of the account's public key used in key authorizations
+func thumbprint(key *ecdsa.PublicKey) string {
+	k := jwk(key)
+	// The members must be in lexicographic order without white space
+	data := fmt.Sprintf(`{"crv":"%s","kty":"%s","x":"%s","y":"%s"}`, k["crv"], k["kty"], k["x"], k["y"])
+	hash := sha256.Sum256([]byte(data))
+	return encode(hash[:])
+}
+
+// encode returns the unpadded base64url encoding used by JWS
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// wait sleeps for the poll interval or returns the context's error if it ends first
+func wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("the ACME server did not finish in time:\r\n%s", ctx.Err().Error())
+	case <-time.After(pollInterval):
+		return nil
+	}
+}
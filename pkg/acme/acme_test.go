@@ -0,0 +1,261 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package acme
+
+import (
+	// Standard
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCA is a fake ACME server that validates the tls-alpn-01 challenge by connecting to the listener at address
+type testCA struct {
+	sync.Mutex
+	t       *testing.T
+	url     string
+	address string
+	nonce   int
+	nonces  map[string]bool
+	key     *ecdsa.PublicKey
+	valid   bool
+	cert    []byte
+}
+
+// decode verifies the request's JWS signature and nonce and returns its payload
+func (ca *testCA) decode(r *http.Request) []byte {
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		ca.t.Fatal(err)
+	}
+	header, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var protected struct {
+		Alg, Nonce, URL, Kid string
+		JWK                  map[string]string
+	}
+	if err := json.Unmarshal(header, &protected); err != nil {
+		ca.t.Fatal(err)
+	}
+	if !ca.nonces[protected.Nonce] || protected.URL != ca.url+r.URL.Path {
+		ca.t.Errorf("the request to %s had the nonce %q and URL %s", r.URL.Path, protected.Nonce, protected.URL)
+	}
+	delete(ca.nonces, protected.Nonce)
+	if protected.JWK != nil {
+		x, _ := base64.RawURLEncoding.DecodeString(protected.JWK["x"])
+		y, _ := base64.RawURLEncoding.DecodeString(protected.JWK["y"])
+		ca.key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	} else if protected.Kid != ca.url+"/account/1" {
+		ca.t.Errorf("the request to %s was signed by %s", r.URL.Path, protected.Kid)
+	}
+	signature, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	hash := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if len(signature) != 64 || !ecdsa.Verify(ca.key, hash[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		ca.t.Errorf("the request to %s had an invalid signature", r.URL.Path)
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	return payload
+}
+
+// ServeHTTP answers the ACME requests
+func (ca *testCA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ca.Lock()
+	defer ca.Unlock()
+	ca.nonce++
+	nonce := fmt.Sprintf("nonce%d", ca.nonce)
+	ca.nonces[nonce] = true
+	w.Header().Set("Replay-Nonce", nonce)
+	if r.Method == http.MethodGet && r.URL.Path == "/directory" {
+		fmt.Fprintf(w, `{"newNonce":"%[1]s/nonce","newAccount":"%[1]s/account","newOrder":"%[1]s/order"}`, ca.url)
+		return
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	payload := ca.decode(r)
+	status := "pending"
+	if ca.valid {
+		status = "valid"
+	}
+	switch r.URL.Path {
+	case "/account":
+		w.Header().Set("Location", ca.url+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"status":"valid"}`)
+	case "/order":
+		w.Header().Set("Location", ca.url+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"status":"pending","authorizations":["%[1]s/authz/1"],"finalize":"%[1]s/finalize/1"}`, ca.url)
+	case "/authz/1":
+		fmt.Fprintf(w, `{"status":"%s","challenges":[{"type":"http-01","url":"%[2]s/http","token":"other"},`+
+			`{"type":"tls-alpn-01","url":"%[2]s/challenge/1","token":"token1"}]}`, status, ca.url)
+	case "/challenge/1":
+		ca.validate()
+		fmt.Fprint(w, `{"status":"processing"}`)
+	case "/finalize/1":
+		var csr struct{ CSR string }
+		_ = json.Unmarshal(payload, &csr)
+		der, _ := base64.RawURLEncoding.DecodeString(csr.CSR)
+		ca.issue(der)
+		fmt.Fprintf(w, `{"status":"valid","certificate":"%s/certificate/1"}`, ca.url)
+	case "/certificate/1":
+		_, _ = w.Write(ca.cert)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"type":"urn:ietf:params:acme:error:malformed","detail":"not found"}`)
+	}
+}
+
+// validate connects to the listener with the acme-tls/1 protocol and checks the challenge certificate's key
+// authorization hash
+func (ca *testCA) validate() {
+	conn, err := tls.Dial("tcp", ca.address, &tls.Config{
+		ServerName:         "c2.example.com",
+		NextProtos:         []string{ALPNProto},
+		InsecureSkipVerify: true, // #nosec G402 - The challenge certificate is self-signed
+	})
+	if err != nil {
+		ca.t.Errorf("there was an error connecting to the listener: %s", err)
+		return
+	}
+	defer conn.Close()
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	ca.key.X.FillBytes(x)
+	ca.key.Y.FillBytes(y)
+	jwk, _ := json.Marshal(map[string]string{"crv": "P-256", "kty": "EC", "x": base64.RawURLEncoding.EncodeToString(x),
+		"y": base64.RawURLEncoding.EncodeToString(y)})
+	thumb := sha256.Sum256(jwk)
+	want := sha256.Sum256([]byte("token1." + base64.RawURLEncoding.EncodeToString(thumb[:])))
+	for _, e := range conn.ConnectionState().PeerCertificates[0].Extensions {
+		var got []byte
+		if e.Id.Equal(idPeAcmeIdentifier) && e.Critical {
+			if _, err = asn1.Unmarshal(e.Value, &got); err == nil && bytes.Equal(got, want[:]) {
+				ca.valid = true
+			}
+		}
+	}
+	if !ca.valid {
+		ca.t.Error("the challenge certificate did not have the key authorization hash")
+	}
+}
+
+// issue signs the certificate request with a self-signed issuer
+func (ca *testCA) issue(der []byte) {
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	issuer := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test CA"}, IsCA: true,
+		BasicConstraintsValid: true, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	leaf := &x509.Certificate{SerialNumber: big.NewInt(2), Subject: csr.Subject, DNSNames: csr.DNSNames,
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(90 * 24 * time.Hour)}
+	cert, err := x509.CreateCertificate(rand.Reader, leaf, issuer, csr.PublicKey, key)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	ca.cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+}
+
+// TestObtain verifies a certificate is ordered with a signed account, the tls-alpn-01 challenge is answered on the
+// listener, and the certificate is stored for the next start
+func TestObtain(t *testing.T) {
+	pollInterval = 10 * time.Millisecond
+	m, err := New("C2.example.com.", "ops@example.com", "", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Domain != "c2.example.com" || m.Directory != LetsEncrypt {
+		t.Errorf("the manager was created as %+v", m)
+	}
+	if _, err = New("*.example.com", "", "", t.TempDir()); err == nil {
+		t.Error("a wildcard domain was accepted")
+	}
+
+	// The listener answers challenges with the manager and presents no other certificate
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		NextProtos: []string{ALPNProto},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if cert, ok := m.ChallengeCertificate(hello); ok {
+				return cert, nil
+			}
+			return nil, fmt.Errorf("not a challenge")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				_ = c.(*tls.Conn).Handshake()
+				_, _ = ioutil.ReadAll(c)
+				c.Close()
+			}(conn)
+		}
+	}()
+
+	ca := &testCA{t: t, address: l.Addr().String(), nonces: make(map[string]bool)}
+	srv := httptest.NewTLSServer(ca)
+	defer srv.Close()
+	ca.url = srv.URL
+	m.Directory = srv.URL + "/directory"
+	m.client = srv.Client()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cert, err := m.Obtain(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Leaf.DNSNames[0] != "c2.example.com" || NeedsRenewal(cert) {
+		t.Errorf("the certificate was issued for %v until %s", cert.Leaf.DNSNames, cert.Leaf.NotAfter)
+	}
+	if _, ok := m.ChallengeCertificate(&tls.ClientHelloInfo{ServerName: "c2.example.com", SupportedProtos: []string{ALPNProto}}); ok {
+		t.Error("the challenge certificate was kept after the authorization was valid")
+	}
+	cached, err := m.Cached()
+	if err != nil || !strings.EqualFold(cached.Leaf.Subject.CommonName, "c2.example.com") {
+		t.Errorf("the certificate was not stored: %v", err)
+	}
+}
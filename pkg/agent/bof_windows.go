@@ -0,0 +1,733 @@
+// +build windows
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+
+	// Sub Repositories
+	"golang.org/x/sys/windows"
+)
+
+// COFF section characteristics and relocation types used to load a Beacon Object File (BOF)
+const (
+	imageScnCntUninitializedData = 0x00000080
+	imageScnLnkRemove            = 0x00000800
+	imageScnMemDiscardable       = 0x02000000
+	imageScnMemExecute           = 0x20000000
+	imageScnMemWrite             = 0x80000000
+	imageRelAMD64Addr64          = 0x0001
+	imageRelAMD64Addr32NB        = 0x0003
+	imageRelAMD64Rel32           = 0x0004
+	imageRelAMD64Rel325          = 0x0009
+	imageRelI386Dir32            = 0x0006
+	imageRelI386Rel32            = 0x0014
+)
+
+// bofCallbackError is the Beacon output type for errors; its output is returned as the job's error output
+const bofCallbackError = 0x0d
+
+// bofLPTR is the LocalAlloc flag that allocates fixed memory initialized to zero
+const bofLPTR = 0x0040
+
+// bofPageSize is the alignment of each section so sections can have different memory protections
+const bofPageSize = 0x1000
+
+// The Beacon API functions a BOF can import and the output of the BOF that is running. The callbacks are created once
+// because Windows callbacks are never freed, and only one BOF runs at a time.
+var (
+	bofAPI     map[string]uintptr
+	bofAPIOnce sync.Once
+	bofMutex   sync.Mutex
+	bofStdout  bytes.Buffer
+	bofStderr  bytes.Buffer
+)
+
+// datap is the Beacon API structure for both the argument parser (datap) and the format buffer (formatp)
+type datap struct {
+	original uintptr // The start of the buffer
+	buffer   uintptr // The current position in the buffer
+	length   int32   // The bytes left to parse or, for a format buffer, the bytes written
+	size     int32   // The size of the buffer
+}
+
+// executeBOF loads a COFF object file compiled for the agent's architecture into memory, runs its go function with the
+// packed arguments, and returns the output it sent with the Beacon API. The BOF runs on the agent's own thread, so a
+// BOF that crashes ends the agent.
+func executeBOF(object []byte, args []byte) (stdout string, stderr string, err error) {
+	bofMutex.Lock()
+	defer bofMutex.Unlock()
+	bofAPIOnce.Do(bofInitAPI)
+
+	f, err := pe.NewFile(bytes.NewReader(object))
+	if err != nil {
+		return "", "", fmt.Errorf("there was an error parsing the COFF object file:\r\n%s", err.Error())
+	}
+	machine, entryName := uint16(pe.IMAGE_FILE_MACHINE_AMD64), "go"
+	if runtime.GOARCH == "386" {
+		machine, entryName = pe.IMAGE_FILE_MACHINE_I386, "_go"
+	}
+	if f.Machine != machine {
+		return "", "", fmt.Errorf("the object file is for machine type 0x%x, not the agent's %s architecture", f.Machine, runtime.GOARCH)
+	}
+
+	// Each section starts on its own page; imported function pointers, common symbols, and the arguments follow them
+	offsets := make([]uintptr, len(f.Sections))
+	var size uintptr
+	for i, s := range f.Sections {
+		offsets[i] = size
+		size += (uintptr(s.Size) + bofPageSize - 1) &^ (bofPageSize - 1)
+	}
+	extra := make(map[string]uintptr)
+	tail := size
+	entry := -1
+	for i := 0; i < len(f.COFFSymbols); i += 1 + int(f.COFFSymbols[i].NumberOfAuxSymbols) {
+		sym := f.COFFSymbols[i]
+		name, errName := sym.FullName(f.StringTable)
+		if errName != nil {
+			return "", "", fmt.Errorf("there was an error reading a COFF symbol name:\r\n%s", errName.Error())
+		}
+		if name == entryName && sym.SectionNumber > 0 {
+			entry = i
+		}
+		if _, ok := extra[name]; ok || sym.SectionNumber != 0 {
+			continue
+		}
+		// An undefined symbol with a value is a common symbol, an uninitialized variable of that size
+		if strings.HasPrefix(name, "__imp_") {
+			extra[name] = tail
+			tail += unsafe.Sizeof(uintptr(0))
+		} else if sym.Value > 0 {
+			extra[name] = tail
+			tail += (uintptr(sym.Value) + 7) &^ 7
+		}
+	}
+	if entry < 0 {
+		return "", "", fmt.Errorf("the object file does not have a %s function", entryName)
+	}
+
+	base, err := windows.VirtualAlloc(0, tail+uintptr(len(args))+1, windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
+	if err != nil {
+		return "", "", fmt.Errorf("there was an error allocating memory for the BOF:\r\n%s", err.Error())
+	}
+	defer func() {
+		_ = windows.VirtualFree(base, 0, windows.MEM_RELEASE)
+	}()
+
+	for i, s := range f.Sections {
+		if s.Characteristics&imageScnCntUninitializedData != 0 || s.Size == 0 {
+			continue
+		}
+		data, errData := s.Data()
+		if errData != nil {
+			return "", "", fmt.Errorf("there was an error reading the %s section:\r\n%s", s.Name, errData.Error())
+		}
+		copy(bofMemory(base+offsets[i], len(data)), data)
+	}
+	for name, offset := range extra {
+		if !strings.HasPrefix(name, "__imp_") {
+			continue
+		}
+		address, errResolve := bofResolve(name)
+		if errResolve != nil {
+			return "", "", errResolve
+		}
+		*(*uintptr)(toPointer(base + offset)) = address
+	}
+	copy(bofMemory(base+tail, len(args)), args)
+
+	// symbol returns the address of a symbol in the loaded object file
+	symbol := func(index uint32) (uintptr, error) {
+		if int(index) >= len(f.COFFSymbols) {
+			return 0, fmt.Errorf("the relocation symbol index %d is not in the symbol table", index)
+		}
+		sym := f.COFFSymbols[index]
+		if sym.SectionNumber > 0 && int(sym.SectionNumber) <= len(f.Sections) {
+			return base + offsets[sym.SectionNumber-1] + uintptr(sym.Value), nil
+		}
+		name, _ := sym.FullName(f.StringTable)
+		if offset, ok := extra[name]; ok && sym.SectionNumber == 0 {
+			return base + offset, nil
+		}
+		return 0, fmt.Errorf("the BOF symbol %s is not supported", name)
+	}
+	for i, s := range f.Sections {
+		// Sections that are not part of the image, such as debug information, are not relocated
+		if s.Characteristics&(imageScnLnkRemove|imageScnMemDiscardable) != 0 {
+			continue
+		}
+		for _, r := range s.Relocs {
+			if r.VirtualAddress+4 > s.Size {
+				return "", "", fmt.Errorf("a relocation in the %s section is outside of the section", s.Name)
+			}
+			target, errSymbol := symbol(r.SymbolTableIndex)
+			if errSymbol != nil {
+				return "", "", errSymbol
+			}
+			if err = bofRelocate(base+offsets[i]+uintptr(r.VirtualAddress), target, r.Type); err != nil {
+				return "", "", fmt.Errorf("there was an error relocating the %s section:\r\n%s", s.Name, err.Error())
+			}
+		}
+	}
+	for i, s := range f.Sections {
+		if s.Characteristics&imageScnMemExecute == 0 || s.Size == 0 {
+			continue
+		}
+		protect := uint32(windows.PAGE_EXECUTE_READ)
+		if s.Characteristics&imageScnMemWrite != 0 {
+			protect = windows.PAGE_EXECUTE_READWRITE
+		}
+		var old uint32
+		if err = windows.VirtualProtect(base+offsets[i], uintptr(s.Size), protect, &old); err != nil {
+			return "", "", fmt.Errorf("there was an error making the %s section executable:\r\n%s", s.Name, err.Error())
+		}
+	}
+
+	goFunction, err := symbol(uint32(entry))
+	if err != nil {
+		return "", "", err
+	}
+	bofStdout.Reset()
+	bofStderr.Reset()
+	var argsAddress uintptr
+	if len(args) > 0 {
+		argsAddress = base + tail
+	}
+	// BeaconUseToken impersonates on the thread running the BOF, so the thread is reverted before it is released
+	runtime.LockOSThread()
+	_, _, _ = syscall.Syscall(goFunction, 2, argsAddress, uintptr(len(args)), 0)
+	_ = windows.RevertToSelf()
+	runtime.UnlockOSThread()
+	return bofStdout.String(), bofStderr.String(), nil
+}
+
+// bofRelocate applies a COFF relocation at the address for the target symbol's address
+func bofRelocate(address uintptr, target uintptr, relocation uint16) error {
+	if runtime.GOARCH == "386" {
+		switch relocation {
+		case imageRelI386Dir32:
+			*(*uint32)(toPointer(address)) += uint32(target)
+		case imageRelI386Rel32:
+			*(*uint32)(toPointer(address)) += uint32(target - (address + 4))
+		default:
+			return fmt.Errorf("the relocation type 0x%x is not supported", relocation)
+		}
+		return nil
+	}
+	switch {
+	case relocation == imageRelAMD64Addr64:
+		*(*uint64)(toPointer(address)) += uint64(target)
+	case relocation == imageRelAMD64Addr32NB, relocation >= imageRelAMD64Rel32 && relocation <= imageRelAMD64Rel325:
+		// REL32_1 through REL32_5 are relative to the end of an instruction with 1 to 5 more bytes after the address
+		var after int64
+		if relocation != imageRelAMD64Addr32NB {
+			after = int64(relocation - imageRelAMD64Rel32)
+		}
+		offset := int64(target) - int64(address+4) - after
+		if offset > math.MaxInt32 || offset < math.MinInt32 {
+			return fmt.Errorf("the relative address 0x%x is out of range", offset)
+		}
+		*(*int32)(toPointer(address)) += int32(offset)
+	default:
+		return fmt.Errorf("the relocation type 0x%x is not supported", relocation)
+	}
+	return nil
+}
+
+// bofResolve returns the address of a function a BOF imports. Beacon API functions are implemented by the agent;
+// other functions are named LIBRARY$Function or, like LoadLibraryA and GetProcAddress, are exported by kernel32.dll.
+func bofResolve(name string) (uintptr, error) {
+	function := strings.TrimPrefix(name, "__imp_")
+	if runtime.GOARCH == "386" {
+		// 32-bit symbols start with an underscore and stdcall functions end with the size of their arguments
+		function = strings.TrimPrefix(function, "_")
+		if i := strings.LastIndexByte(function, '@'); i > 0 {
+			function = function[:i]
+		}
+	}
+	if address, ok := bofAPI[function]; ok {
+		return address, nil
+	}
+	library := "kernel32"
+	if i := strings.IndexByte(function, '$'); i > 0 {
+		library, function = function[:i], function[i+1:]
+	}
+	handle, err := windows.LoadLibrary(library + ".dll")
+	if err != nil {
+		return 0, fmt.Errorf("there was an error loading %s.dll for the BOF:\r\n%s", library, err.Error())
+	}
+	address, err := windows.GetProcAddress(handle, function)
+	if err != nil {
+		return 0, fmt.Errorf("there was an error finding %s in %s.dll for the BOF:\r\n%s", function, library, err.Error())
+	}
+	return address, nil
+}
+
+// bofInitAPI creates the callbacks for the Beacon API functions a BOF can import
+func bofInitAPI() {
+	api := map[string]interface{}{
+		"BeaconDataParse":              bofDataParse,
+		"BeaconDataInt":                bofDataInt,
+		"BeaconDataShort":              bofDataShort,
+		"BeaconDataLength":             bofDataLength,
+		"BeaconDataExtract":            bofDataExtract,
+		"BeaconFormatAlloc":            bofFormatAlloc,
+		"BeaconFormatReset":            bofFormatReset,
+		"BeaconFormatFree":             bofFormatFree,
+		"BeaconFormatAppend":           bofFormatAppend,
+		"BeaconFormatPrintf":           bofFormatPrintf,
+		"BeaconFormatToString":         bofFormatToString,
+		"BeaconFormatInt":              bofFormatInt,
+		"BeaconPrintf":                 bofPrintf,
+		"BeaconOutput":                 bofOutput,
+		"BeaconUseToken":               bofUseToken,
+		"BeaconRevertToken":            bofRevertToken,
+		"BeaconIsAdmin":                bofIsAdmin,
+		"BeaconGetSpawnTo":             bofGetSpawnTo,
+		"BeaconSpawnTemporaryProcess":  bofSpawnTemporaryProcess,
+		"BeaconInjectProcess":          bofInjectProcess,
+		"BeaconInjectTemporaryProcess": bofInjectTemporaryProcess,
+		"BeaconCleanupProcess":         bofCleanupProcess,
+		"toWideChar":                   bofToWideChar,
+	}
+	bofAPI = make(map[string]uintptr, len(api))
+	for name, function := range api {
+		bofAPI[name] = windows.NewCallbackCDecl(function)
+	}
+}
+
+// bofDataParse implements BeaconDataParse; the buffer starts with its own 32-bit length
+func bofDataParse(parser uintptr, buffer uintptr, size uintptr) uintptr {
+	if parser == 0 {
+		return 0
+	}
+	p := (*datap)(toPointer(parser))
+	*p = datap{original: buffer}
+	if buffer != 0 && int32(size) >= 4 {
+		p.buffer, p.length, p.size = buffer+4, int32(size)-4, int32(size)-4
+	}
+	return 0
+}
+
+// bofDataInt implements BeaconDataInt
+func bofDataInt(parser uintptr) uintptr {
+	p := (*datap)(toPointer(parser))
+	if parser == 0 || p.length < 4 {
+		return 0
+	}
+	v := int32(binary.LittleEndian.Uint32(bofMemory(p.buffer, 4)))
+	p.buffer, p.length = p.buffer+4, p.length-4
+	return uintptr(v)
+}
+
+// bofDataShort implements BeaconDataShort
+func bofDataShort(parser uintptr) uintptr {
+	p := (*datap)(toPointer(parser))
+	if parser == 0 || p.length < 2 {
+		return 0
+	}
+	v := int16(binary.LittleEndian.Uint16(bofMemory(p.buffer, 2)))
+	p.buffer, p.length = p.buffer+2, p.length-2
+	return uintptr(v)
+}
+
+// bofDataLength implements BeaconDataLength
+func bofDataLength(parser uintptr) uintptr {
+	if parser == 0 {
+		return 0
+	}
+	return uintptr((*datap)(toPointer(parser)).length)
+}
+
+// bofDataExtract implements BeaconDataExtract; it returns a pointer to length prefixed data and writes its length
+func bofDataExtract(parser uintptr, size uintptr) uintptr {
+	p := (*datap)(toPointer(parser))
+	if parser == 0 || p.length < 4 {
+		return 0
+	}
+	n := int32(binary.LittleEndian.Uint32(bofMemory(p.buffer, 4)))
+	if n < 0 || n > p.length-4 {
+		return 0
+	}
+	data := p.buffer + 4
+	p.buffer, p.length = data+uintptr(n), p.length-4-n
+	if size != 0 {
+		*(*int32)(toPointer(size)) = n
+	}
+	return data
+}
+
+// bofFormatAlloc implements BeaconFormatAlloc
+func bofFormatAlloc(format uintptr, maxSize uintptr) uintptr {
+	if format == 0 {
+		return 0
+	}
+	f := (*datap)(toPointer(format))
+	*f = datap{}
+	LocalAlloc := windows.NewLazySystemDLL("kernel32.dll").NewProc("LocalAlloc")
+	buffer, _, _ := LocalAlloc.Call(bofLPTR, maxSize)
+	if buffer != 0 {
+		*f = datap{original: buffer, buffer: buffer, size: int32(maxSize)}
+	}
+	return 0
+}
+
+// bofFormatReset implements BeaconFormatReset
+func bofFormatReset(format uintptr) uintptr {
+	f := (*datap)(toPointer(format))
+	if format != 0 && f.original != 0 {
+		b := bofMemory(f.original, int(f.size))
+		for i := range b {
+			b[i] = 0
+		}
+		f.buffer, f.length = f.original, 0
+	}
+	return 0
+}
+
+// bofFormatFree implements BeaconFormatFree
+func bofFormatFree(format uintptr) uintptr {
+	f := (*datap)(toPointer(format))
+	if format != 0 && f.original != 0 {
+		_, _ = windows.LocalFree(windows.Handle(f.original))
+		*f = datap{}
+	}
+	return 0
+}
+
+// bofFormatAppend implements BeaconFormatAppend
+func bofFormatAppend(format uintptr, text uintptr, length uintptr) uintptr {
+	if format != 0 && text != 0 && int32(length) > 0 {
+		bofFormatWrite((*datap)(toPointer(format)), bofMemory(text, int(int32(length))))
+	}
+	return 0
+}
+
+// bofFormatPrintf implements BeaconFormatPrintf with up to eight arguments
+func bofFormatPrintf(format uintptr, text uintptr, a0, a1, a2, a3, a4, a5, a6, a7 uintptr) uintptr {
+	if format != 0 && text != 0 {
+		s := bofSprintf(bofString(text), []uintptr{a0, a1, a2, a3, a4, a5, a6, a7})
+		bofFormatWrite((*datap)(toPointer(format)), []byte(s))
+	}
+	return 0
+}
+
+// bofFormatToString implements BeaconFormatToString
+func bofFormatToString(format uintptr, size uintptr) uintptr {
+	if format == 0 {
+		return 0
+	}
+	f := (*datap)(toPointer(format))
+	if size != 0 {
+		*(*int32)(toPointer(size)) = f.length
+	}
+	return f.original
+}
+
+// bofFormatInt implements BeaconFormatInt; the integer is appended in network byte order
+func bofFormatInt(format uintptr, value uintptr) uintptr {
+	if format != 0 {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(value))
+		bofFormatWrite((*datap)(toPointer(format)), b)
+	}
+	return 0
+}
+
+// bofFormatWrite appends as much of the data as fits to a format buffer
+func bofFormatWrite(f *datap, data []byte) {
+	if f.original == 0 {
+		return
+	}
+	n := int(f.size - f.length)
+	if n > len(data) {
+		n = len(data)
+	}
+	if n <= 0 {
+		return
+	}
+	copy(bofMemory(f.buffer, n), data[:n])
+	f.buffer, f.length = f.buffer+uintptr(n), f.length+int32(n)
+}
+
+// bofPrintf implements BeaconPrintf with up to eight arguments
+func bofPrintf(outputType uintptr, text uintptr, a0, a1, a2, a3, a4, a5, a6, a7 uintptr) uintptr {
+	if text != 0 {
+		bofWrite(outputType, []byte(bofSprintf(bofString(text), []uintptr{a0, a1, a2, a3, a4, a5, a6, a7})))
+	}
+	return 0
+}
+
+// bofOutput implements BeaconOutput
+func bofOutput(outputType uintptr, data uintptr, length uintptr) uintptr {
+	if data != 0 && int32(length) > 0 {
+		bofWrite(outputType, bofMemory(data, int(int32(length))))
+	}
+	return 0
+}
+
+// bofWrite adds BOF output to the job's output, or its error output for the error output type
+func bofWrite(outputType uintptr, data []byte) {
+	if uint32(outputType) == bofCallbackError {
+		bofStderr.Write(data)
+		return
+	}
+	bofStdout.Write(data)
+}
+
+// bofUseToken implements BeaconUseToken by impersonating the token on the thread running the BOF
+func bofUseToken(token uintptr) uintptr {
+	if err := windows.SetThreadToken(nil, windows.Token(token)); err != nil {
+		bofWrite(bofCallbackError, []byte(fmt.Sprintf("there was an error impersonating the token:\r\n%s\r\n", err.Error())))
+		return 0
+	}
+	return 1
+}
+
+// bofRevertToken implements BeaconRevertToken
+func bofRevertToken() uintptr {
+	_ = windows.RevertToSelf()
+	return 0
+}
+
+// bofIsAdmin implements BeaconIsAdmin
+func bofIsAdmin() uintptr {
+	if windows.GetCurrentProcessToken().IsElevated() {
+		return 1
+	}
+	return 0
+}
+
+// bofGetSpawnTo implements BeaconGetSpawnTo; the agent does not have a spawnto setting so rundll32.exe is used
+func bofGetSpawnTo(x86 uintptr, buffer uintptr, length uintptr) uintptr {
+	dir := `C:\Windows`
+	if system, err := windows.GetSystemDirectory(); err == nil {
+		dir = filepath.Dir(system)
+	}
+	system := `System32`
+	if uint32(x86) != 0 && runtime.GOARCH == "amd64" {
+		system = `SysWOW64`
+	}
+	path := append([]byte(dir+`\`+system+`\rundll32.exe`), 0)
+	if buffer != 0 && int(int32(length)) >= len(path) {
+		copy(bofMemory(buffer, len(path)), path)
+	}
+	return 0
+}
+
+// bofSpawnTemporaryProcess implements BeaconSpawnTemporaryProcess, which the agent does not support
+func bofSpawnTemporaryProcess(x86 uintptr, ignoreToken uintptr, startupInfo uintptr, processInfo uintptr) uintptr {
+	bofWrite(bofCallbackError, []byte("BeaconSpawnTemporaryProcess is not supported by the agent\r\n"))
+	return 0
+}
+
+// bofInjectProcess implements BeaconInjectProcess, which the agent does not support
+func bofInjectProcess(process, pid, payload, length, offset, arg, argLength uintptr) uintptr {
+	bofWrite(bofCallbackError, []byte("BeaconInjectProcess is not supported by the agent\r\n"))
+	return 0
+}
+
+// bofInjectTemporaryProcess implements BeaconInjectTemporaryProcess, which the agent does not support
+func bofInjectTemporaryProcess(processInfo, payload, length, offset, arg, argLength uintptr) uintptr {
+	bofWrite(bofCallbackError, []byte("BeaconInjectTemporaryProcess is not supported by the agent\r\n"))
+	return 0
+}
+
+// bofCleanupProcess implements BeaconCleanupProcess by closing the process and thread handles
+func bofCleanupProcess(processInfo uintptr) uintptr {
+	if processInfo != 0 {
+		pi := (*windows.ProcessInformation)(toPointer(processInfo))
+		_ = windows.CloseHandle(pi.Process)
+		_ = windows.CloseHandle(pi.Thread)
+	}
+	return 0
+}
+
+// bofToWideChar implements toWideChar; the maximum is the size of the destination in bytes
+func bofToWideChar(src uintptr, dst uintptr, max uintptr) uintptr {
+	n := int(int32(max)) / 2
+	if src == 0 || dst == 0 || n < 1 {
+		return 0
+	}
+	wide := utf16.Encode([]rune(bofString(src)))
+	if len(wide) > n-1 {
+		wide = wide[:n-1]
+	}
+	d := (*[1 << 29]uint16)(toPointer(dst))[: len(wide)+1 : len(wide)+1]
+	copy(d, wide)
+	d[len(wide)] = 0
+	return 1
+}
+
+// bofMemory returns a slice for memory that is not managed by Go
+func bofMemory(address uintptr, length int) []byte {
+	return (*[1 << 30]byte)(toPointer(address))[:length:length]
+}
+
+// bofString returns the Go string for a null terminated string that is not managed by Go
+func bofString(address uintptr) string {
+	var n uintptr
+	for *(*byte)(toPointer(address + n)) != 0 {
+		n++
+	}
+	return string(bofMemory(address, int(n)))
+}
+
+// bofWideString returns the Go string for a null terminated UTF-16 string that is not managed by Go
+func bofWideString(address uintptr) string {
+	var s []uint16
+	for c := *(*uint16)(toPointer(address)); c != 0; c = *(*uint16)(toPointer(address)) {
+		s = append(s, c)
+		address += 2
+	}
+	return string(utf16.Decode(s))
+}
+
+// bofSprintf formats a C printf format string with the arguments passed to a variadic Beacon API function. 64-bit
+// integers and doubles take two arguments on 32-bit Windows; arguments that were not passed are read as zero.
+func bofSprintf(format string, args []uintptr) string {
+	next := func() uintptr {
+		if len(args) == 0 {
+			return 0
+		}
+		a := args[0]
+		args = args[1:]
+		return a
+	}
+	next64 := func() uint64 {
+		if runtime.GOARCH == "386" {
+			low := next()
+			return uint64(low) | uint64(next())<<32
+		}
+		return uint64(next())
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		j := i + 1
+		spec := "%"
+		for j < len(format) && strings.IndexByte("-+ #0", format[j]) >= 0 {
+			spec += format[j : j+1]
+			j++
+		}
+		// Width and precision digits, where an asterisk takes the value from the arguments
+		for j < len(format) && (format[j] == '.' || format[j] == '*' || (format[j] >= '0' && format[j] <= '9')) {
+			if format[j] == '*' {
+				spec += strconv.Itoa(int(int32(next())))
+			} else {
+				spec += format[j : j+1]
+			}
+			j++
+		}
+		var size string
+		for j < len(format) && strings.IndexByte("hlLqjztwI", format[j]) >= 0 {
+			switch {
+			case strings.HasPrefix(format[j:], "I64"):
+				size, j = "ll", j+3
+			case strings.HasPrefix(format[j:], "I32"):
+				size, j = "", j+3
+			case format[j] == 'I':
+				size, j = "z", j+1
+			default:
+				size, j = size+format[j:j+1], j+1
+			}
+		}
+		if j >= len(format) {
+			b.WriteString(format[i:])
+			break
+		}
+		wide := size == "ll" || size == "q" || size == "j" ||
+			(runtime.GOARCH == "amd64" && (size == "z" || size == "t"))
+		switch c := format[j]; c {
+		case 'd', 'i':
+			var v int64
+			switch {
+			case wide:
+				v = int64(next64())
+			case size == "h":
+				v = int64(int16(next()))
+			case size == "hh":
+				v = int64(int8(next()))
+			default:
+				v = int64(int32(next()))
+			}
+			b.WriteString(fmt.Sprintf(spec+"d", v))
+		case 'u', 'x', 'X', 'o':
+			var v uint64
+			switch {
+			case wide:
+				v = next64()
+			case size == "h":
+				v = uint64(uint16(next()))
+			case size == "hh":
+				v = uint64(uint8(next()))
+			default:
+				v = uint64(uint32(next()))
+			}
+			if c == 'u' {
+				c = 'd'
+			}
+			b.WriteString(fmt.Sprintf(spec+string(c), v))
+		case 'c', 'C':
+			b.WriteString(fmt.Sprintf(spec+"c", rune(uint16(next()))))
+		case 's', 'S':
+			var s string
+			address := next()
+			switch {
+			case address == 0:
+				s = "(null)"
+			case (c == 's' && (size == "l" || size == "w")) || (c == 'S' && size != "h"):
+				s = bofWideString(address)
+			default:
+				s = bofString(address)
+			}
+			b.WriteString(fmt.Sprintf(spec+"s", s))
+		case 'p':
+			b.WriteString(fmt.Sprintf("%0*X", unsafe.Sizeof(uintptr(0))*2, next()))
+		case 'f', 'F', 'e', 'E', 'g', 'G':
+			b.WriteString(fmt.Sprintf(spec+string(c), math.Float64frombits(next64())))
+		case 'n':
+			next()
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteString(format[i : j+1])
+		}
+		i = j
+	}
+	return b.String()
+}
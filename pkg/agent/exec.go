@@ -51,6 +51,11 @@ func ExecuteCommand(name string, arg string) (stdout string, stderr string) {
 	return stdout, stderr
 }
 
+// shellCommand returns the command shell run for the interactive shell
+func shellCommand() *exec.Cmd {
+	return exec.Command("/bin/sh") // #nosec G204
+}
+
 // ExecuteShellcodeSelf executes provided shellcode in the current process
 //lint:ignore SA4009 Function needs to mirror exec_windows.go and inputs must be used
 func ExecuteShellcodeSelf(shellcode []byte) error {
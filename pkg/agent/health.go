@@ -0,0 +1,77 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+package agent
+
+import (
+	// Standard
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// health counts the agent's errors and samples its resource usage for the report sent with every status check in, so
+// an agent that leaks memory or goroutines, or keeps failing, is noticed before the host's user notices it
+type health struct {
+	handlerErrors uint32 // Messages from the server the agent could not handle
+	sendErrors    uint32 // Job results the agent could not send to the server
+	jobErrors     uint32 // Jobs that returned an error
+	panics        uint32 // Panics recovered while handling messages from the server
+	sync.Mutex
+	started time.Time     // When the agent started
+	cpu     time.Duration // The process CPU time at the last report
+	sampled time.Time     // When the last report was created
+}
+
+// newHealth returns the health counters for an agent that started now
+func newHealth() *health {
+	now := time.Now()
+	cpu, _ := processCPUTime()
+	return &health{started: now, cpu: cpu, sampled: now}
+}
+
+// report returns the agent's resource usage since the last report and its error counters
+func (h *health) report() messages.Health {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	r := messages.Health{
+		Memory:        mem.Sys,
+		Heap:          mem.HeapAlloc,
+		Goroutines:    runtime.NumGoroutine(),
+		HandlerErrors: int(atomic.LoadUint32(&h.handlerErrors)),
+		SendErrors:    int(atomic.LoadUint32(&h.sendErrors)),
+		JobErrors:     int(atomic.LoadUint32(&h.jobErrors)),
+		Panics:        int(atomic.LoadUint32(&h.panics)),
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	now := time.Now()
+	r.Uptime = now.Sub(h.started).Round(time.Second).String()
+	cpu, err := processCPUTime()
+	if err != nil {
+		return r
+	}
+	if elapsed := now.Sub(h.sampled); elapsed > 0 {
+		r.CPU = float64(cpu-h.cpu) / float64(elapsed) * 100
+	}
+	h.cpu, h.sampled = cpu, now
+	return r
+}
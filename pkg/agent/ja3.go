@@ -0,0 +1,149 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// clientHello holds the cipher suites and elliptic curves the agent offers in its TLS Client Hello. Together they
+// determine most of the agent's JA3 fingerprint, so changing them changes how the agent's connections are fingerprinted.
+type clientHello struct {
+	sync.Mutex
+	ciphers []uint16
+	curves  []tls.CurveID
+}
+
+// defaultCiphers are the cipher suites the agent offers when no JA3 specification was set
+var defaultCiphers = []uint16{
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+}
+
+// curveIDs are the elliptic curves a JA3 specification can use
+var curveIDs = map[tls.CurveID]bool{tls.X25519: true, tls.CurveP256: true, tls.CurveP384: true, tls.CurveP521: true}
+
+// hello holds the agent's Client Hello settings; it is read each time the agent creates a TLS client
+var hello = &clientHello{ciphers: defaultCiphers}
+
+// set parses and applies a JA3 specification in the form "<ciphers>;<curves>" where each list is comma separated
+// hexadecimal IDs (e.g. "c030,c02f;001d,0017"). The curves are optional and "default" restores the agent's defaults.
+func (h *clientHello) set(spec string) error {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || strings.ToLower(spec) == "default" {
+		h.Lock()
+		h.ciphers, h.curves = defaultCiphers, nil
+		h.Unlock()
+		return nil
+	}
+
+	supported := make(map[uint16]bool)
+	for _, suite := range tls.CipherSuites() {
+		supported[suite.ID] = true
+	}
+
+	parts := strings.SplitN(spec, ";", 2)
+	var ciphers []uint16
+	for _, c := range strings.Split(parts[0], ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(c), 16, 16)
+		if err != nil {
+			return fmt.Errorf("there was an error parsing the %s cipher suite:\r\n%s", c, err.Error())
+		}
+		if !supported[uint16(id)] {
+			return fmt.Errorf("the %s cipher suite is not supported", c)
+		}
+		ciphers = append(ciphers, uint16(id))
+	}
+
+	var curves []tls.CurveID
+	if len(parts) == 2 && strings.TrimSpace(parts[1]) != "" {
+		for _, c := range strings.Split(parts[1], ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(c), 16, 16)
+			if err != nil {
+				return fmt.Errorf("there was an error parsing the %s curve:\r\n%s", c, err.Error())
+			}
+			if !curveIDs[tls.CurveID(id)] {
+				return fmt.Errorf("the %s curve is not supported", c)
+			}
+			curves = append(curves, tls.CurveID(id))
+		}
+	}
+
+	h.Lock()
+	h.ciphers, h.curves = ciphers, curves
+	h.Unlock()
+	return nil
+}
+
+// get returns copies of the cipher suites and curves
+func (h *clientHello) get() ([]uint16, []tls.CurveID) {
+	h.Lock()
+	defer h.Unlock()
+	return append([]uint16(nil), h.ciphers...), append([]tls.CurveID(nil), h.curves...)
+}
+
+// String returns the settings as a JA3 specification that set accepts
+func (h *clientHello) String() string {
+	ciphers, curves := h.get()
+	var c []string
+	for _, id := range ciphers {
+		c = append(c, fmt.Sprintf("%04x", id))
+	}
+	spec := strings.Join(c, ",")
+	if len(curves) > 0 {
+		c = nil
+		for _, id := range curves {
+			c = append(c, fmt.Sprintf("%04x", uint16(id)))
+		}
+		spec += ";" + strings.Join(c, ",")
+	}
+	return spec
+}
+
+// controlJA3 handles the ja3 AgentControl command by applying the specification and replacing the agent's HTTP client
+// so the next connection uses the new Client Hello
+func (a *Agent) controlJA3(args string) error {
+	previous := hello.String()
+	if err := hello.set(args); err != nil {
+		return err
+	}
+	client, err := getClient(a.Proto, a.proxy)
+	if err != nil {
+		if errSet := hello.set(previous); errSet != nil {
+			return errSet
+		}
+		return fmt.Errorf("there was an error getting a transport client:\r\n%s", err.Error())
+	}
+	a.Client = client
+	return nil
+}
+
+// controlPSK handles the psk AgentControl command that replaces the pre-shared key the agent uses before it
+// authenticates with the server
+func (a *Agent) controlPSK(args string) error {
+	if args == "" {
+		return fmt.Errorf("the pre-shared key can not be empty")
+	}
+	a.psk = args
+	return nil
+}
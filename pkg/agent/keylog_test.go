@@ -0,0 +1,55 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"strings"
+	"testing"
+)
+
+// TestKeylogBuffer verifies keys typed into the same window are grouped and the oldest keys are dropped when the
+// buffer is full
+func TestKeylogBuffer(t *testing.T) {
+	a := Agent{keylogger: &keylogger{}}
+	a.keylogger.add("Notepad", "hello")
+	a.keylogger.add("Notepad", " world")
+	a.keylogger.add("cmd.exe", "whoami\n")
+
+	dump := a.keylog("job1", "dump")
+	if len(dump.Keystrokes) != 2 || dump.Keystrokes[0].Keys != "hello world" || dump.Keystrokes[1].Window != "cmd.exe" {
+		t.Errorf("the keystrokes were not grouped by window: %+v", dump.Keystrokes)
+	}
+	if dump.Running || dump.Stderr != "" {
+		t.Errorf("a dump of a stopped keylogger returned running %t and error %q", dump.Running, dump.Stderr)
+	}
+	if dump = a.keylog("job2", "dump"); len(dump.Keystrokes) != 0 {
+		t.Error("the keystrokes were returned by more than one dump")
+	}
+
+	a.keylogger.add("first", "old")
+	a.keylogger.add("second", strings.Repeat("x", keylogBufferSize-1))
+	a.keylogger.add("second", "yz")
+	dump = a.keylog("job3", "dump")
+	if dump.Dropped != 4 || len(dump.Keystrokes) != 1 {
+		t.Fatalf("the full buffer dropped %d keys and kept %d windows instead of 4 keys and 1 window", dump.Dropped, len(dump.Keystrokes))
+	}
+	if keys := dump.Keystrokes[0].Keys; len(keys) != keylogBufferSize || !strings.HasSuffix(keys, "xyz") {
+		t.Errorf("the full buffer kept %d keys ending in %q", len(keys), keys[len(keys)-3:])
+	}
+}
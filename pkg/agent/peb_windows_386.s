@@ -0,0 +1,10 @@
+// +build apihash
+
+#include "textflag.h"
+
+// func getPEB() uintptr
+TEXT ·getPEB(SB),NOSPLIT,$0-4
+	// The PEB pointer is at offset 0x30 of the TEB
+	MOVL 0x30(FS), AX
+	MOVL AX, ret+0(FP)
+	RET
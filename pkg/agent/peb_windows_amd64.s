@@ -0,0 +1,10 @@
+// +build apihash
+
+#include "textflag.h"
+
+// func getPEB() uintptr
+TEXT ·getPEB(SB),NOSPLIT,$0-8
+	// The PEB pointer is at offset 0x60 of the TEB
+	MOVQ 0x60(GS), AX
+	MOVQ AX, ret+0(FP)
+	RET
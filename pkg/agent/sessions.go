@@ -0,0 +1,48 @@
+// +build !windows
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"errors"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// enumerateSessions is a Windows only function to list the console and RDP sessions on the provided host
+//lint:ignore SA4009 Function needs to mirror sessions_windows.go and inputs must be used
+func enumerateSessions(host string, user string, password string) ([]messages.UserSession, error) {
+	var sessions []messages.UserSession
+	host = ""
+	user = ""
+	password = ""
+	return sessions, errors.New("session enumeration is not implemented for this operating system")
+}
+
+// enumerateLoggedOn is a Windows only function to list the users logged on to the provided host
+//lint:ignore SA4009 Function needs to mirror sessions_windows.go and inputs must be used
+func enumerateLoggedOn(host string, user string, password string) ([]messages.UserSession, error) {
+	var sessions []messages.UserSession
+	host = ""
+	user = ""
+	password = ""
+	return sessions, errors.New("logged on user enumeration is not implemented for this operating system")
+}
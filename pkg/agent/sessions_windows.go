@@ -0,0 +1,263 @@
+// +build windows
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+
+	// Sub Repositories
+	"golang.org/x/sys/windows"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+const (
+	// LOGON32_LOGON_NEW_CREDENTIALS is a Windows constant used with the LogonUser API call
+	LOGON32_LOGON_NEW_CREDENTIALS = 9
+	// LOGON32_PROVIDER_WINNT50 is a Windows constant used with the LogonUser API call
+	LOGON32_PROVIDER_WINNT50 = 3
+	// MAX_PREFERRED_LENGTH is a Windows constant used with NetApi32 API calls
+	MAX_PREFERRED_LENGTH = 0xFFFFFFFF
+	// ERROR_MORE_DATA is a Windows constant returned by API calls when more data is available
+	ERROR_MORE_DATA = 234
+	// WTS_USER_NAME is the WTS_INFO_CLASS value used to query a session's user name
+	WTS_USER_NAME = 5
+	// WTS_DOMAIN_NAME is the WTS_INFO_CLASS value used to query a session's domain name
+	WTS_DOMAIN_NAME = 7
+	// WTS_CLIENT_NAME is the WTS_INFO_CLASS value used to query a session's remote client name
+	WTS_CLIENT_NAME = 10
+)
+
+// wtsStates maps the WTS_CONNECTSTATE_CLASS enumeration to a readable string
+var wtsStates = []string{"Active", "Connected", "ConnectQuery", "Shadow", "Disconnected", "Idle", "Listen", "Reset", "Down", "Init"}
+
+// wtsSessionInfo is the WTS_SESSION_INFOW structure returned by WTSEnumerateSessionsW
+type wtsSessionInfo struct {
+	SessionID      uint32
+	WinStationName *uint16
+	State          uint32
+}
+
+// wkstaUserInfo1 is the WKSTA_USER_INFO_1 structure returned by NetWkstaUserEnum
+type wkstaUserInfo1 struct {
+	UserName    *uint16
+	LogonDomain *uint16
+	OthDomains  *uint16
+	LogonServer *uint16
+}
+
+// enumerateSessions uses the Windows Terminal Services API to list the console and RDP sessions on the provided host.
+// An empty host enumerates the local host. If a user name is provided, the credentials are used for the network logon.
+func enumerateSessions(host string, user string, password string) ([]messages.UserSession, error) {
+	var sessions []messages.UserSession
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	revert, errImpersonate := impersonate(user, password)
+	if errImpersonate != nil {
+		return sessions, errImpersonate
+	}
+	defer revert()
+
+	wtsapi32 := windows.NewLazySystemDLL("wtsapi32.dll")
+	WTSOpenServerW := wtsapi32.NewProc("WTSOpenServerW")
+	WTSCloseServer := wtsapi32.NewProc("WTSCloseServer")
+	WTSEnumerateSessionsW := wtsapi32.NewProc("WTSEnumerateSessionsW")
+	WTSFreeMemory := wtsapi32.NewProc("WTSFreeMemory")
+
+	// WTS_CURRENT_SERVER_HANDLE is 0 and is used for the local host
+	var hServer uintptr
+	if host != "" {
+		h, _, errOpen := WTSOpenServerW.Call(uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(host))))
+		if h == 0 {
+			return sessions, fmt.Errorf("there was an error calling WTSOpenServerW for %s:\r\n%s", host, errOpen.Error())
+		}
+		hServer = h
+		defer WTSCloseServer.Call(hServer)
+	}
+
+	var info *wtsSessionInfo
+	var count uint32
+	r, _, errEnum := WTSEnumerateSessionsW.Call(hServer, 0, 1, uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&count)))
+	if r == 0 {
+		return sessions, fmt.Errorf("there was an error calling WTSEnumerateSessionsW:\r\n%s", errEnum.Error())
+	}
+	defer WTSFreeMemory.Call(uintptr(unsafe.Pointer(info)))
+
+	if count == 0 {
+		return sessions, nil
+	}
+
+	for _, s := range (*[1 << 20]wtsSessionInfo)(unsafe.Pointer(info))[:count:count] {
+		session := messages.UserSession{
+			SessionID: s.SessionID,
+			Station:   utf16PtrToString(s.WinStationName),
+			UserName:  querySessionString(hServer, s.SessionID, WTS_USER_NAME),
+			Domain:    querySessionString(hServer, s.SessionID, WTS_DOMAIN_NAME),
+			Client:    querySessionString(hServer, s.SessionID, WTS_CLIENT_NAME),
+		}
+		if int(s.State) < len(wtsStates) {
+			session.State = wtsStates[s.State]
+		}
+		// Skip the services session and listeners that do not have a user associated with them
+		if session.UserName == "" {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// enumerateLoggedOn uses the NetWkstaUserEnum API to list users currently logged on to the provided host, including
+// service and batch logons. An empty host enumerates the local host.
+func enumerateLoggedOn(host string, user string, password string) ([]messages.UserSession, error) {
+	var sessions []messages.UserSession
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	revert, errImpersonate := impersonate(user, password)
+	if errImpersonate != nil {
+		return sessions, errImpersonate
+	}
+	defer revert()
+
+	netapi32 := windows.NewLazySystemDLL("netapi32.dll")
+	NetWkstaUserEnum := netapi32.NewProc("NetWkstaUserEnum")
+	NetApiBufferFree := netapi32.NewProc("NetApiBufferFree")
+
+	var server uintptr
+	if host != "" {
+		server = uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(host)))
+	}
+
+	var resume uint32
+	for {
+		var buf *wkstaUserInfo1
+		var read, total uint32
+		r, _, _ := NetWkstaUserEnum.Call(server, 1, uintptr(unsafe.Pointer(&buf)), MAX_PREFERRED_LENGTH,
+			uintptr(unsafe.Pointer(&read)), uintptr(unsafe.Pointer(&total)), uintptr(unsafe.Pointer(&resume)))
+		if r != 0 && r != ERROR_MORE_DATA {
+			return sessions, fmt.Errorf("there was an error calling NetWkstaUserEnum:\r\n%s", syscall.Errno(r).Error())
+		}
+		if buf != nil {
+			for _, u := range (*[1 << 20]wkstaUserInfo1)(unsafe.Pointer(buf))[:read:read] {
+				sessions = append(sessions, messages.UserSession{
+					UserName: utf16PtrToString(u.UserName),
+					Domain:   utf16PtrToString(u.LogonDomain),
+					Server:   utf16PtrToString(u.LogonServer),
+				})
+			}
+			NetApiBufferFree.Call(uintptr(unsafe.Pointer(buf)))
+		}
+		if r != ERROR_MORE_DATA {
+			break
+		}
+	}
+	return sessions, nil
+}
+
+// querySessionString returns the string value of the provided WTS_INFO_CLASS for a session, or an empty string
+func querySessionString(hServer uintptr, sessionID uint32, infoClass uintptr) string {
+	wtsapi32 := windows.NewLazySystemDLL("wtsapi32.dll")
+	WTSQuerySessionInformationW := wtsapi32.NewProc("WTSQuerySessionInformationW")
+	WTSFreeMemory := wtsapi32.NewProc("WTSFreeMemory")
+
+	var buf *uint16
+	var size uint32
+	r, _, _ := WTSQuerySessionInformationW.Call(hServer, uintptr(sessionID), infoClass, uintptr(unsafe.Pointer(&buf)), uintptr(unsafe.Pointer(&size)))
+	if r == 0 || buf == nil {
+		return ""
+	}
+	defer WTSFreeMemory.Call(uintptr(unsafe.Pointer(buf)))
+	return utf16PtrToString(buf)
+}
+
+// impersonate uses the provided credentials for outbound network authentication on the current thread and returns a
+// function to revert to the agent's own token. The caller must lock the goroutine to its OS thread.
+func impersonate(user string, password string) (func(), error) {
+	if user == "" {
+		return func() {}, nil
+	}
+
+	domain := "."
+	if strings.Contains(user, "\\") {
+		s := strings.SplitN(user, "\\", 2)
+		domain, user = s[0], s[1]
+	}
+
+	LogonUserW, err := getProc(apiAdvapi32, apiLogonUserW)
+	if err != nil {
+		return nil, err
+	}
+	ImpersonateLoggedOnUser, err := getProc(apiAdvapi32, apiImpersonateLoggedOnUser)
+	if err != nil {
+		return nil, err
+	}
+	RevertToSelf, err := getProc(apiAdvapi32, apiRevertToSelf)
+	if err != nil {
+		return nil, err
+	}
+
+	var token syscall.Handle
+	r, _, errLogon := LogonUserW.Call(
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(user))),
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(domain))),
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(password))),
+		LOGON32_LOGON_NEW_CREDENTIALS,
+		LOGON32_PROVIDER_WINNT50,
+		uintptr(unsafe.Pointer(&token)),
+	)
+	if r == 0 {
+		return nil, fmt.Errorf("there was an error calling LogonUserW for %s\\%s:\r\n%s", domain, user, errLogon.Error())
+	}
+
+	r, _, errImpersonate := ImpersonateLoggedOnUser.Call(uintptr(token))
+	if r == 0 {
+		_ = syscall.CloseHandle(token)
+		return nil, errors.New("there was an error calling ImpersonateLoggedOnUser:\r\n" + errImpersonate.Error())
+	}
+
+	return func() {
+		RevertToSelf.Call()
+		_ = syscall.CloseHandle(token)
+	}, nil
+}
+
+// utf16PtrToString converts a pointer to a null terminated UTF-16 string returned by a Windows API into a Go string
+func utf16PtrToString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+	n := 0
+	for ptr := unsafe.Pointer(p); *(*uint16)(ptr) != 0; n++ {
+		ptr = unsafe.Pointer(uintptr(ptr) + unsafe.Sizeof(*p))
+	}
+	return string(utf16.Decode((*[1 << 29]uint16)(unsafe.Pointer(p))[:n:n]))
+}
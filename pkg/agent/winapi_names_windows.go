@@ -0,0 +1,84 @@
+// +build windows,!apihash windows,!amd64,!386
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"fmt"
+
+	// Sub Repositories
+	"golang.org/x/sys/windows"
+)
+
+// apiNames maps the Windows API hashes to the module and function names they are resolved from
+var apiNames = map[uint32]string{
+	apiKernel32: "kernel32.dll",
+	apiNtdll:    "ntdll.dll",
+	apiAdvapi32: "advapi32.dll",
+
+	apiCloseHandle:              "CloseHandle",
+	apiCreateRemoteThreadEx:     "CreateRemoteThreadEx",
+	apiCreateToolhelp32Snapshot: "CreateToolhelp32Snapshot",
+	apiOpenProcess:              "OpenProcess",
+	apiOpenThread:               "OpenThread",
+	apiQueueUserAPC:             "QueueUserAPC",
+	apiThread32First:            "Thread32First",
+	apiThread32Next:             "Thread32Next",
+	apiVirtualAllocEx:           "VirtualAllocEx",
+	apiVirtualProtectEx:         "VirtualProtectEx",
+	apiWaitForSingleObject:      "WaitForSingleObject",
+	apiWriteProcessMemory:       "WriteProcessMemory",
+
+	apiNtAdjustPrivilegesToken: "NtAdjustPrivilegesToken",
+	apiNtAllocateVirtualMemory: "NtAllocateVirtualMemory",
+	apiNtCreateThreadEx:        "NtCreateThreadEx",
+	apiNtOpenProcess:           "NtOpenProcess",
+	apiNtOpenProcessToken:      "NtOpenProcessToken",
+	apiNtOpenThread:            "NtOpenThread",
+	apiNtProtectVirtualMemory:  "NtProtectVirtualMemory",
+	apiNtQueueApcThread:        "NtQueueApcThread",
+	apiNtWriteVirtualMemory:    "NtWriteVirtualMemory",
+	apiRtlCopyMemory:           "RtlCopyMemory",
+	apiRtlCreateUserThread:     "RtlCreateUserThread",
+
+	apiAdjustTokenPrivileges:   "AdjustTokenPrivileges",
+	apiImpersonateLoggedOnUser: "ImpersonateLoggedOnUser",
+	apiLogonUserW:              "LogonUserW",
+	apiLookupPrivilegeValueW:   "LookupPrivilegeValueW",
+	apiOpenProcessToken:        "OpenProcessToken",
+	apiRevertToSelf:            "RevertToSelf",
+}
+
+// getProc loads the module and function for the provided API hashes by name
+func getProc(module uint32, function uint32) (winProc, error) {
+	moduleName, ok := apiNames[module]
+	if !ok {
+		return nil, fmt.Errorf("there is no module name for API hash 0x%08x", module)
+	}
+	functionName, ok := apiNames[function]
+	if !ok {
+		return nil, fmt.Errorf("there is no function name for API hash 0x%08x", function)
+	}
+	proc := windows.NewLazySystemDLL(moduleName).NewProc(functionName)
+	if err := proc.Find(); err != nil {
+		return nil, fmt.Errorf("there was an error loading %s from %s:\r\n%s", functionName, moduleName, err.Error())
+	}
+	return proc, nil
+}
@@ -0,0 +1,38 @@
+// +build windows
+
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"unsafe"
+)
+
+// winProc is a Windows API function resolved by getProc. getProc is implemented with a table of names by default and
+// by walking the loaded modules' export tables for matching hashes when the agent is built with the apihash tag.
+type winProc interface {
+	Addr() uintptr
+	Call(a ...uintptr) (r1, r2 uintptr, lastErr error)
+}
+
+
+// toPointer converts the address of memory that is not managed by Go, such as a loaded module, into a pointer
+func toPointer(addr uintptr) unsafe.Pointer {
+	return *(*unsafe.Pointer)(unsafe.Pointer(&addr))
+}
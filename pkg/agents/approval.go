@@ -0,0 +1,103 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"sort"
+	"sync"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+)
+
+// approval is true when agents that register must be accepted by an operator before they can be tasked
+var approval = struct {
+	sync.Mutex
+	required bool
+}{}
+
+// SetApproval sets whether agents that register from now on are held as pending until an operator accepts them
+func SetApproval(required bool) {
+	approval.Lock()
+	approval.required = required
+	approval.Unlock()
+}
+
+// GetApproval returns true if agents that register are held as pending until an operator accepts them
+func GetApproval() bool {
+	approval.Lock()
+	defer approval.Unlock()
+	return approval.required
+}
+
+// Accept approves a pending agent so it can be tasked
+func Accept(agentID uuid.UUID) error {
+	if !isAgent(agentID) {
+		return api.Errorf(api.AgentNotFound, "%s is not a known agent", agentID)
+	}
+	if !Agents[agentID].Pending {
+		return fmt.Errorf("agent %s is not pending approval", agentID)
+	}
+	Agents[agentID].Pending = false
+	save(agentID)
+	Log(agentID, "Agent was accepted by an operator")
+	logging.Server(fmt.Sprintf("Accepted pending agent %s", agentID))
+	return nil
+}
+
+// GetPendingAgents returns the IDs of the agents waiting to be accepted sorted by their initial check in
+func GetPendingAgents() []uuid.UUID {
+	var pending []uuid.UUID
+	for id, a := range Agents {
+		if a.Pending {
+			pending = append(pending, id)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return Agents[pending[i]].InitialCheckIn.Before(Agents[pending[j]].InitialCheckIn)
+	})
+	return pending
+}
+
+// GetPendingList returns the IDs of the agents waiting to be accepted; used with tab completion
+func GetPendingList() func(string) []string {
+	return func(line string) []string {
+		var a []string
+		for _, id := range GetPendingAgents() {
+			a = append(a, id.String())
+		}
+		return a
+	}
+}
+
+// canTask returns an error if the agent is not known or has not been accepted
+func canTask(agentID uuid.UUID) error {
+	if !isAgent(agentID) {
+		return api.Errorf(api.AgentNotFound, "%s is not a known agent", agentID)
+	}
+	if Agents[agentID].Pending {
+		return api.Errorf(api.Forbidden, "agent %s is pending approval and can not be tasked; use 'accept %s'", agentID, agentID)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// ParseArchive parses the arguments of a zip or unzip job into an Archive message. The arguments are
+// zip [-p <password>] <archive> <path> [<path>...] and unzip [-p <password>] <archive> [<directory>].
+func ParseArchive(method string, args []string) (messages.Archive, error) {
+	a := messages.Archive{Method: method}
+	if len(args) > 1 && args[0] == "-p" {
+		a.Password = args[1]
+		args = args[2:]
+	}
+	switch method {
+	case "zip":
+		if len(args) < 2 {
+			return a, fmt.Errorf("zip [-p <password>] <archive> <path> [<path>...]")
+		}
+		a.Archive, a.Paths = args[0], args[1:]
+	case "unzip":
+		if len(args) < 1 || len(args) > 2 {
+			return a, fmt.Errorf("unzip [-p <password>] <archive> [<directory>]")
+		}
+		a.Archive = args[0]
+		if len(args) == 2 {
+			a.Dest = args[1]
+		}
+	default:
+		return a, fmt.Errorf("%s is not a valid archive method", method)
+	}
+	lower := strings.ToLower(a.Archive)
+	if !strings.HasSuffix(lower, ".zip") && !strings.HasSuffix(lower, ".tar.gz") && !strings.HasSuffix(lower, ".tgz") {
+		return a, fmt.Errorf("%s does not have a .zip, .tar.gz, or .tgz extension", a.Archive)
+	}
+	if a.Password != "" && !strings.HasSuffix(lower, ".zip") {
+		return a, fmt.Errorf("passwords are only supported for zip archives")
+	}
+	return a, nil
+}
+
+// cleanupManifest returns the path to the agent's cleanup manifest
+func cleanupManifest(agentID uuid.UUID) string {
+	return filepath.Join(core.CurrentDir, "data", "agents", agentID.String(), "cleanup_manifest.txt")
+}
+
+// recordCleanup appends the files and directories a job created on the agent's host to its cleanup manifest
+func recordCleanup(agentID uuid.UUID, job string, created []string) {
+	f, err := os.OpenFile(cleanupManifest(agentID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		message("warn", fmt.Sprintf("there was an error opening the cleanup manifest:\r\n%s", err.Error()))
+		return
+	}
+	defer f.Close() // #nosec G307
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, path := range created {
+		if _, err = fmt.Fprintf(f, "%s\t%s\t%s\n", now, job, path); err != nil {
+			message("warn", fmt.Sprintf("there was an error writing to the cleanup manifest:\r\n%s", err.Error()))
+			return
+		}
+	}
+	Log(agentID, fmt.Sprintf("Added %d paths created by job %s to the cleanup manifest", len(created), job))
+}
+
+// CleanupManifest returns the time, job, and path of every file and directory that jobs created on the agent's host,
+// in the order they were created
+func CleanupManifest(agentID uuid.UUID) ([][]string, error) {
+	var entries [][]string
+	f, err := os.Open(cleanupManifest(agentID))
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // #nosec G307
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.SplitN(scanner.Text(), "\t", 3); len(fields) == 3 {
+			entries = append(entries, fields)
+		}
+	}
+	return entries, scanner.Err()
+}
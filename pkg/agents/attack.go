@@ -0,0 +1,372 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+)
+
+// AttackFile is the file the MITRE ATT&CK techniques jobs used are appended to, one JSON object per line
+var AttackFile = filepath.Join(core.CurrentDir, "data", "log", "attack.json")
+
+// Techniques are the names of the MITRE ATT&CK techniques the agent commands and the modules Merlin ships with use
+var Techniques = map[string]string{
+	"T1003":     "OS Credential Dumping",
+	"T1003.001": "OS Credential Dumping: LSASS Memory",
+	"T1003.002": "OS Credential Dumping: Security Account Manager",
+	"T1003.004": "OS Credential Dumping: LSA Secrets",
+	"T1003.005": "OS Credential Dumping: Cached Domain Credentials",
+	"T1003.007": "OS Credential Dumping: Proc Filesystem",
+	"T1003.008": "OS Credential Dumping: /etc/passwd and /etc/shadow",
+	"T1005":     "Data from Local System",
+	"T1021.002": "Remote Services: SMB/Windows Admin Shares",
+	"T1021.003": "Remote Services: Distributed Component Object Model",
+	"T1021.004": "Remote Services: SSH",
+	"T1021.006": "Remote Services: Windows Remote Management",
+	"T1027.004": "Obfuscated Files or Information: Compile After Delivery",
+	"T1033":     "System Owner/User Discovery",
+	"T1041":     "Exfiltration Over C2 Channel",
+	"T1047":     "Windows Management Instrumentation",
+	"T1049":     "System Network Connections Discovery",
+	"T1053.003": "Scheduled Task/Job: Cron",
+	"T1053.005": "Scheduled Task/Job: Scheduled Task",
+	"T1055":     "Process Injection",
+	"T1055.001": "Process Injection: Dynamic-link Library Injection",
+	"T1056.001": "Input Capture: Keylogging",
+	"T1057":     "Process Discovery",
+	"T1059":     "Command and Scripting Interpreter",
+	"T1059.001": "Command and Scripting Interpreter: PowerShell",
+	"T1059.002": "Command and Scripting Interpreter: AppleScript",
+	"T1059.003": "Command and Scripting Interpreter: Windows Command Shell",
+	"T1059.004": "Command and Scripting Interpreter: Unix Shell",
+	"T1059.006": "Command and Scripting Interpreter: Python",
+	"T1059.007": "Command and Scripting Interpreter: JavaScript",
+	"T1069":     "Permission Groups Discovery",
+	"T1070.003": "Indicator Removal: Clear Command History",
+	"T1070.004": "Indicator Removal: File Deletion",
+	"T1070.006": "Indicator Removal: Timestomp",
+	"T1082":     "System Information Discovery",
+	"T1083":     "File and Directory Discovery",
+	"T1087.002": "Account Discovery: Domain Account",
+	"T1090":     "Proxy",
+	"T1105":     "Ingress Tool Transfer",
+	"T1112":     "Modify Registry",
+	"T1113":     "Screen Capture",
+	"T1134.004": "Access Token Manipulation: Parent PID Spoofing",
+	"T1185":     "Browser Session Hijacking",
+	"T1518.001": "Software Discovery: Security Software Discovery",
+	"T1543.001": "Create or Modify System Process: Launch Agent",
+	"T1543.002": "Create or Modify System Process: Systemd Service",
+	"T1546.004": "Event Triggered Execution: Unix Shell Configuration Modification",
+	"T1546.015": "Event Triggered Execution: Component Object Model Hijacking",
+	"T1547.001": "Boot or Logon Autostart Execution: Registry Run Keys / Startup Folder",
+	"T1547.009": "Boot or Logon Autostart Execution: Shortcut Modification",
+	"T1552.001": "Unsecured Credentials: Credentials In Files",
+	"T1552.006": "Unsecured Credentials: Group Policy Preferences",
+	"T1555":     "Credentials from Password Stores",
+	"T1555.004": "Credentials from Password Stores: Windows Credential Manager",
+	"T1557.001": "Adversary-in-the-Middle: LLMNR/NBT-NS Poisoning and SMB Relay",
+	"T1558.003": "Steal or Forge Kerberos Tickets: Kerberoasting",
+	"T1560.002": "Archive Collected Data: Archive via Library",
+	"T1562.003": "Impair Defenses: Impair Command History Logging",
+	"T1562.004": "Impair Defenses: Disable or Modify System Firewall",
+	"T1564.004": "Hide Artifacts: NTFS File Attributes",
+	"T1570":     "Lateral Tool Transfer",
+	"T1574":     "Hijack Execution Flow",
+	"T1574.006": "Hijack Execution Flow: Dynamic Linker Hijacking",
+	"T1615":     "Group Policy Discovery",
+	"T1620":     "Reflective Code Loading",
+}
+
+// commandTechniques are the MITRE ATT&CK techniques each agent command uses. A cmd or shell job uses the Windows
+// Command Shell or Unix Shell technique by the agent's platform, and persist and move jobs use the technique of
+// their method too.
+var commandTechniques = map[string][]string{
+	"bof":              {"T1620"},
+	"cmd":              {"T1059"},
+	"download":         {"T1041"},
+	"execute-assembly": {"T1620"},
+	"fetch-tool":       {"T1105"},
+	"find":             {"T1083"},
+	"firewall":         {"T1562.004"},
+	"grep":             {"T1005", "T1083"},
+	"keylog":           {"T1056.001"},
+	"loggedon":         {"T1033"},
+	"ls":               {"T1083"},
+	"Minidump":         {"T1003.001"},
+	"move":             {"T1570"},
+	"node":             {"T1059.007"},
+	"osascript":        {"T1059.002"},
+	"powerpick":        {"T1059.001", "T1620"},
+	"powershell":       {"T1059.001"},
+	"python":           {"T1059.006"},
+	"screenshot":       {"T1113"},
+	"selfdestruct":     {"T1070.004"},
+	"sessions-enum":    {"T1033", "T1049"},
+	"shell":            {"T1059"},
+	"shellcode":        {"T1055"},
+	"tunnel":           {"T1090"},
+	"upload":           {"T1105"},
+	"wasm":             {"T1059"},
+	"zip":              {"T1560.002"},
+}
+
+// methodTechniques are the MITRE ATT&CK techniques of each persist and move job method
+var methodTechniques = map[string]string{
+	"cron":    "T1053.003",
+	"launchd": "T1543.001",
+	"runkey":  "T1547.001",
+	"schtask": "T1053.005",
+	"systemd": "T1543.002",
+	"psexec":  "T1021.002",
+	"ssh":     "T1021.004",
+	"winrm":   "T1021.006",
+	"wmiexec": "T1047",
+}
+
+// Exercise is a MITRE ATT&CK technique a job used on an agent's host
+type Exercise struct {
+	Time      time.Time `json:"time"`
+	Technique string    `json:"technique"`
+	Agent     string    `json:"agent"`
+	Host      string    `json:"host"`
+	Job       string    `json:"job"`
+	Source    string    `json:"source"` // The agent command or module that used the technique
+}
+
+// TechniqueCoverage is a MITRE ATT&CK technique that was used during the operation and the hosts it was used on
+type TechniqueCoverage struct {
+	ID      string
+	Name    string
+	Hosts   []string // The host names, sorted
+	Sources []string // The agent commands and modules that used the technique, sorted
+	Jobs    int
+	First   time.Time
+	Last    time.Time
+}
+
+// attackJob is the module that created a job and the techniques the module uses
+type attackJob struct {
+	source     string
+	techniques []string
+}
+
+// exercises holds the techniques used during the operation, which are read from AttackFile the first time they are
+// needed, and the techniques of the jobs modules created
+var exercises = struct {
+	sync.Mutex
+	loaded bool
+	list   []Exercise
+	jobs   map[string]attackJob // Job ID to the module that created it
+}{jobs: make(map[string]attackJob)}
+
+// AttackCommands returns the agent commands and the MITRE ATT&CK techniques they use
+func AttackCommands() map[string][]string {
+	commands := make(map[string][]string)
+	for command, techniques := range commandTechniques {
+		commands[command] = append([]string(nil), techniques...)
+	}
+	for method, technique := range methodTechniques {
+		command := "persist"
+		if _, ok := MoveMethods[method]; ok {
+			command = "move"
+		}
+		commands[command+" "+method] = append(append([]string(nil), commandTechniques[command]...), technique)
+	}
+	return commands
+}
+
+// TrackAttack records the MITRE ATT&CK techniques of the module that created the job, instead of the agent command's,
+// as used on the agent's host when the job's results are returned
+func TrackAttack(job string, module string, techniques []string) {
+	if len(techniques) == 0 {
+		return
+	}
+	exercises.Lock()
+	defer exercises.Unlock()
+	exercises.jobs[job] = attackJob{source: module, techniques: techniques}
+}
+
+// jobTechniques returns the MITRE ATT&CK techniques an agent command's job uses on the agent's host
+func jobTechniques(agentID uuid.UUID, job Job) []string {
+	techniques := append([]string(nil), commandTechniques[job.Type]...)
+	switch job.Type {
+	case "cmd", "shell":
+		if strings.ToLower(Agents[agentID].Platform) == "windows" {
+			techniques = []string{"T1059.003"}
+		} else {
+			techniques = []string{"T1059.004"}
+		}
+	case "persist":
+		// Args are the action, add or remove, followed by its method
+		if len(job.Args) > 1 && job.Args[0] == "add" && methodTechniques[job.Args[1]] != "" {
+			techniques = append(techniques, methodTechniques[job.Args[1]])
+		}
+	case "move":
+		// Args start with the method
+		if len(job.Args) > 0 && methodTechniques[job.Args[0]] != "" {
+			techniques = append(techniques, methodTechniques[job.Args[0]])
+		}
+	}
+	return techniques
+}
+
+// attackResult records the MITRE ATT&CK techniques a job used when its results are returned
+func attackResult(agentID uuid.UUID, jobID string, job Job) {
+	exercises.Lock()
+	defer exercises.Unlock()
+	source, techniques := job.Type, []string(nil)
+	if j, ok := exercises.jobs[jobID]; ok {
+		delete(exercises.jobs, jobID)
+		source, techniques = j.source, j.techniques
+	} else if job.Type != "" {
+		techniques = jobTechniques(agentID, job)
+	}
+	if len(techniques) == 0 {
+		return
+	}
+	if err := loadExercises(); err != nil {
+		message("warn", err.Error())
+	}
+	now := time.Now().UTC()
+	for _, t := range techniques {
+		e := Exercise{Time: now, Technique: t, Agent: agentID.String(), Host: Agents[agentID].HostName, Job: jobID,
+			Source: source}
+		exercises.list = append(exercises.list, e)
+		if !Agents[agentID].simulated {
+			if err := appendExercise(e); err != nil {
+				message("warn", err.Error())
+			}
+		}
+	}
+}
+
+// Exercises returns the MITRE ATT&CK techniques jobs used in the time range; a zero since starts at the beginning of
+// the operation and a zero until ends now
+func Exercises(since time.Time, until time.Time) ([]Exercise, error) {
+	exercises.Lock()
+	defer exercises.Unlock()
+	if err := loadExercises(); err != nil {
+		return nil, err
+	}
+	var list []Exercise
+	for _, e := range exercises.list {
+		if !e.Time.Before(since) && (until.IsZero() || e.Time.Before(until)) {
+			list = append(list, e)
+		}
+	}
+	return list, nil
+}
+
+// Coverage returns the MITRE ATT&CK techniques jobs used in the time range with the hosts they were used on, sorted by
+// technique ID
+func Coverage(since time.Time, until time.Time) ([]TechniqueCoverage, error) {
+	list, err := Exercises(since, until)
+	if err != nil {
+		return nil, err
+	}
+	techniques := make(map[string]*TechniqueCoverage)
+	for _, e := range list {
+		c, ok := techniques[e.Technique]
+		if !ok {
+			c = &TechniqueCoverage{ID: e.Technique, Name: Techniques[e.Technique], First: e.Time}
+			techniques[e.Technique] = c
+		}
+		if !containsString(c.Hosts, e.Host) {
+			c.Hosts = append(c.Hosts, e.Host)
+		}
+		if !containsString(c.Sources, e.Source) {
+			c.Sources = append(c.Sources, e.Source)
+		}
+		c.Jobs++
+		c.Last = e.Time
+	}
+	var coverage []TechniqueCoverage
+	for _, c := range techniques {
+		sort.Strings(c.Hosts)
+		sort.Strings(c.Sources)
+		coverage = append(coverage, *c)
+	}
+	sort.Slice(coverage, func(i, j int) bool { return coverage[i].ID < coverage[j].ID })
+	return coverage, nil
+}
+
+// loadExercises reads the techniques used in earlier runs of the server from AttackFile once; the caller must hold the
+// exercises lock
+func loadExercises() error {
+	if exercises.loaded {
+		return nil
+	}
+	exercises.loaded = true
+	f, err := os.Open(AttackFile) // #nosec G304 the file is in the server's data directory
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("there was an error opening the MITRE ATT&CK log %s:\r\n%s", AttackFile, err.Error())
+	}
+	defer f.Close() // #nosec G307
+	var list []Exercise
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Exercise
+		if err = json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("there was an error reading the MITRE ATT&CK log %s:\r\n%s", AttackFile, err.Error())
+		}
+		list = append(list, e)
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("there was an error reading the MITRE ATT&CK log %s:\r\n%s", AttackFile, err.Error())
+	}
+	exercises.list = append(list, exercises.list...)
+	return nil
+}
+
+// appendExercise appends the technique a job used to AttackFile
+func appendExercise(e Exercise) error {
+	if err := os.MkdirAll(filepath.Dir(AttackFile), 0750); err != nil {
+		return fmt.Errorf("there was an error creating the MITRE ATT&CK log directory:\r\n%s", err.Error())
+	}
+	f, err := os.OpenFile(AttackFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600) // #nosec G304 the file is in the server's data directory
+	if err != nil {
+		return fmt.Errorf("there was an error opening the MITRE ATT&CK log %s:\r\n%s", AttackFile, err.Error())
+	}
+	defer f.Close() // #nosec G307
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("there was an error writing to the MITRE ATT&CK log %s:\r\n%s", AttackFile, err.Error())
+	}
+	return nil
+}
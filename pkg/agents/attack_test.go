@@ -0,0 +1,72 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestAttackCoverage verifies the techniques of agent commands and modules are recorded for the agent's host when the
+// job's results are returned
+func TestAttackCoverage(t *testing.T) {
+	AttackFile = filepath.Join(t.TempDir(), "attack.json")
+	exercises.Lock()
+	exercises.list, exercises.loaded = nil, false
+	exercises.Unlock()
+	windows, linux := testAgent(t), testAgent(t)
+	Agents[windows].Platform, Agents[windows].HostName = "windows", "ws01"
+	Agents[linux].Platform, Agents[linux].HostName = "linux", "web01"
+
+	attackResult(windows, "job1", Job{Type: "cmd", Args: []string{"whoami"}})
+	attackResult(linux, "job2", Job{Type: "cmd", Args: []string{"id"}})
+	attackResult(linux, "job3", Job{Type: "persist", Args: []string{"add", "cron", "update"}})
+	attackResult(linux, "job4", Job{Type: "sleep", Args: []string{"10s"}})
+	TrackAttack("job5", "Invoke-Mimikatz", []string{"T1003.001"})
+	attackResult(windows, "job5", Job{Type: "powershell"})
+
+	coverage, err := Coverage(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := make(map[string][]string)
+	for _, c := range coverage {
+		hosts[c.ID] = c.Hosts
+	}
+	expected := map[string][]string{
+		"T1059.003": {"ws01"},
+		"T1059.004": {"web01"},
+		"T1053.003": {"web01"},
+		"T1003.001": {"ws01"},
+	}
+	if len(hosts) != len(expected) {
+		t.Errorf("expected techniques %v, got %v", expected, hosts)
+	}
+	for id, h := range expected {
+		if len(hosts[id]) != 1 || hosts[id][0] != h[0] {
+			t.Errorf("expected technique %s on %v, got %v", id, h, hosts[id])
+		}
+	}
+	for _, c := range coverage {
+		if c.ID == "T1003.001" && (len(c.Sources) != 1 || c.Sources[0] != "Invoke-Mimikatz") {
+			t.Errorf("expected technique T1003.001 from the Invoke-Mimikatz module, got %v", c.Sources)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+)
+
+// barrierMargin is added to the longest check in interval of a barrier's agents to allow for network latency
+const barrierMargin = 5 * time.Second
+
+// Barrier holds a job for each of several agents until every agent has checked in after the barrier was created,
+// acknowledging it is ready. The jobs are then sent on each agent's next check in along with the time left until the
+// release time, which is after every agent's next check in, so that every agent runs its job at the same time.
+type Barrier struct {
+	ID      string
+	Created time.Time
+	Jobs    map[uuid.UUID]string    // The job held for each agent
+	Ready   map[uuid.UUID]time.Time // When each agent that is ready checked in
+	Release time.Time               // When the agents run their jobs; zero until every agent is ready
+}
+
+// barriers are the barriers with jobs that have not been sent to their agents
+var barriers = struct {
+	sync.Mutex
+	m map[string]*Barrier
+}{m: make(map[string]*Barrier)}
+
+// AddBarrierJobs creates the job for every agent and holds them behind a new barrier that is released once every agent
+// is ready. The jobs are not throttled because they are meant to run at the same time.
+func AddBarrierJobs(agentIDs []uuid.UUID, job Job) (Barrier, error) {
+	if len(agentIDs) < 2 {
+		return Barrier{}, errors.New("a barrier needs at least 2 agents")
+	}
+	for _, a := range agentIDs {
+		if err := canTask(a); err != nil {
+			return Barrier{}, err
+		}
+	}
+	b := &Barrier{
+		ID:      core.RandStringBytesMaskImprSrc(10),
+		Created: time.Now().UTC(),
+		Jobs:    make(map[uuid.UUID]string),
+		Ready:   make(map[uuid.UUID]time.Time),
+	}
+	// The barrier is added first so an agent checking in while the jobs are queued can not receive its job early
+	barriers.Lock()
+	barriers.m[b.ID] = b
+	barriers.Unlock()
+	for _, a := range agentIDs {
+		j := Job{
+			ID:      core.RandStringBytesMaskImprSrc(10),
+			Type:    job.Type,
+			Status:  "created",
+			Args:    job.Args,
+			Created: time.Now().UTC(),
+			After:   job.After,
+			Barrier: b.ID,
+		}
+		barriers.Lock()
+		b.Jobs[a] = j.ID
+		barriers.Unlock()
+		releaseJobs(a, []Job{j})
+		Log(a, fmt.Sprintf("Job %s is held by barrier %s until every agent is ready", j.ID, b.ID))
+	}
+	logging.Server(fmt.Sprintf("Created barrier %s holding jobs for %d agents", b.ID, len(agentIDs)))
+	return GetBarrier(b.ID)
+}
+
+// GetBarrier returns a copy of the barrier
+func GetBarrier(id string) (Barrier, error) {
+	barriers.Lock()
+	defer barriers.Unlock()
+	b, ok := barriers.m[id]
+	if !ok {
+		return Barrier{}, fmt.Errorf("%s is not a barrier with jobs waiting to be sent", id)
+	}
+	return b.copy(), nil
+}
+
+// GetBarriers returns a copy of the barriers with jobs waiting to be sent sorted by the time they were created
+func GetBarriers() []Barrier {
+	barriers.Lock()
+	var list []Barrier
+	for _, b := range barriers.m {
+		list = append(list, b.copy())
+	}
+	barriers.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+// CancelBarrier removes the barrier and the jobs it holds that have not been sent to their agents
+func CancelBarrier(id string) error {
+	barriers.Lock()
+	b, ok := barriers.m[id]
+	if ok {
+		delete(barriers.m, id)
+	}
+	barriers.Unlock()
+	if !ok {
+		return fmt.Errorf("%s is not a barrier with jobs waiting to be sent", id)
+	}
+	for a, job := range b.Jobs {
+		if isAgent(a) && removeJob(a, job) {
+			Log(a, fmt.Sprintf("Removed job %s because barrier %s was canceled", job, id))
+		}
+	}
+	logging.Server(fmt.Sprintf("Canceled barrier %s", id))
+	return nil
+}
+
+// copy returns a copy of the barrier that does not share its maps
+func (b *Barrier) copy() Barrier {
+	c := *b
+	c.Jobs = make(map[uuid.UUID]string)
+	c.Ready = make(map[uuid.UUID]time.Time)
+	for k, v := range b.Jobs {
+		c.Jobs[k] = v
+	}
+	for k, v := range b.Ready {
+		c.Ready[k] = v
+	}
+	return c
+}
+
+// barrierCheckIn marks the agent ready for every barrier holding one of its jobs and releases the barriers whose
+// agents are all ready
+func barrierCheckIn(agentID uuid.UUID) {
+	barriers.Lock()
+	defer barriers.Unlock()
+	now := time.Now().UTC()
+	for _, b := range barriers.m {
+		if _, ok := b.Jobs[agentID]; !ok || !b.Release.IsZero() {
+			continue
+		}
+		if _, ok := b.Ready[agentID]; !ok {
+			b.Ready[agentID] = now
+			Log(agentID, fmt.Sprintf("Agent is ready for barrier %s", b.ID))
+		}
+		if len(b.Ready) < len(b.Jobs) {
+			continue
+		}
+		// Every agent checks in again, and receives its job, within its sleep and skew
+		var longest time.Duration
+		for a := range b.Jobs {
+			if !isAgent(a) {
+				continue
+			}
+			sleep, _ := time.ParseDuration(Agents[a].WaitTime)
+			if interval := sleep + time.Duration(Agents[a].Skew)*time.Millisecond; interval > longest {
+				longest = interval
+			}
+		}
+		b.Release = now.Add(longest + barrierMargin)
+		logging.Server(fmt.Sprintf("Released barrier %s; its %d agents will run their jobs at %s", b.ID, len(b.Jobs),
+			b.Release.Format(time.RFC3339)))
+	}
+}
+
+// barrierHeld returns true if the job belongs to a barrier that has not been released
+func barrierHeld(job Job) bool {
+	if job.Barrier == "" {
+		return false
+	}
+	barriers.Lock()
+	defer barriers.Unlock()
+	b, ok := barriers.m[job.Barrier]
+	return !ok || b.Release.IsZero()
+}
+
+// barrierSent returns the time left until the job's barrier is released and forgets the job because it is being sent
+// to its agent; the barrier is removed after its last job is sent
+func barrierSent(agentID uuid.UUID, job Job) time.Duration {
+	if job.Barrier == "" {
+		return 0
+	}
+	barriers.Lock()
+	defer barriers.Unlock()
+	b, ok := barriers.m[job.Barrier]
+	if !ok {
+		return 0
+	}
+	delete(b.Jobs, agentID)
+	if len(b.Jobs) == 0 {
+		delete(barriers.m, b.ID)
+	}
+	if d := time.Until(b.Release); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// restoreBarrier adds the agent's restored job to its barrier, which is created again if needed; every agent must
+// check in again before the barrier is released
+func restoreBarrier(agentID uuid.UUID, job Job) {
+	barriers.Lock()
+	defer barriers.Unlock()
+	b, ok := barriers.m[job.Barrier]
+	if !ok {
+		b = &Barrier{ID: job.Barrier, Created: job.Created, Jobs: make(map[uuid.UUID]string),
+			Ready: make(map[uuid.UUID]time.Time)}
+		barriers.m[b.ID] = b
+	}
+	b.Jobs[agentID] = job.ID
+}
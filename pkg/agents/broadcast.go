@@ -0,0 +1,251 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// Broadcast is the same job sent to every agent that matched a filter, with the results each agent returned
+type Broadcast struct {
+	ID        string
+	Filter    string // The filter the agents were selected with
+	Type      string
+	Args      []string
+	Created   time.Time
+	Jobs      map[uuid.UUID]string          // The job created for each agent
+	Results   map[uuid.UUID]BroadcastResult // The results each agent returned
+	Completed time.Time                     // When every agent returned its results or died; zero until then
+}
+
+// BroadcastResult is the output an agent returned for its broadcast job
+type BroadcastResult struct {
+	Time   time.Time
+	Stdout string
+	Stderr string
+}
+
+// broadcasts are the broadcasts sent since the server started, and the broadcast each of their jobs belongs to
+var broadcasts = struct {
+	sync.Mutex
+	m    map[string]*Broadcast
+	jobs map[string]string
+}{m: make(map[string]*Broadcast), jobs: make(map[string]string)}
+
+// AddBroadcast sends the job to every agent that is not dead or pending and reports every agent's results in a single
+// table once they have all returned. The jobs are released within the group job throttle.
+func AddBroadcast(agentIDs []uuid.UUID, filter string, job Job) (Broadcast, error) {
+	var tasked []uuid.UUID
+	for _, id := range agentIDs {
+		if status := GetAgentStatus(id); isAgent(id) && status != "Dead" && status != "Pending" {
+			tasked = append(tasked, id)
+		}
+	}
+	if len(tasked) == 0 {
+		return Broadcast{}, errors.New("there are no agents that are not dead or pending to broadcast to")
+	}
+	// The broadcast is added before its jobs are created so a result can not be returned before it is recorded
+	b := &Broadcast{
+		ID:      core.RandStringBytesMaskImprSrc(10),
+		Filter:  filter,
+		Type:    job.Type,
+		Args:    job.Args,
+		Created: time.Now().UTC(),
+		Jobs:    make(map[uuid.UUID]string),
+		Results: make(map[uuid.UUID]BroadcastResult),
+	}
+	broadcasts.Lock()
+	defer broadcasts.Unlock()
+	ids, err := AddGroupJobs(tasked, []Job{job})
+	if err != nil {
+		return Broadcast{}, err
+	}
+	for id, jobs := range ids {
+		b.Jobs[id] = jobs[0]
+		broadcasts.jobs[jobs[0]] = b.ID
+	}
+	broadcasts.m[b.ID] = b
+	logging.Server(fmt.Sprintf("Created broadcast %s sending a %s job to %d agents that matched %s", b.ID, job.Type,
+		len(tasked), filter))
+	return b.copy(), nil
+}
+
+// GetBroadcast returns a copy of the broadcast
+func GetBroadcast(id string) (Broadcast, error) {
+	broadcasts.Lock()
+	defer broadcasts.Unlock()
+	b, ok := broadcasts.m[id]
+	if !ok {
+		return Broadcast{}, api.Errorf(api.NotFound, "%s is not a broadcast", id)
+	}
+	return b.copy(), nil
+}
+
+// GetBroadcasts returns a copy of the broadcasts sorted by the time they were created
+func GetBroadcasts() []Broadcast {
+	broadcasts.Lock()
+	var list []Broadcast
+	for _, b := range broadcasts.m {
+		list = append(list, b.copy())
+	}
+	broadcasts.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+// Waiting returns the agents that have not returned their results and are not dead
+func (b Broadcast) Waiting() []uuid.UUID {
+	var waiting []uuid.UUID
+	for id := range b.Jobs {
+		if _, ok := b.Results[id]; !ok && isAgent(id) && GetAgentStatus(id) != "Dead" {
+			waiting = append(waiting, id)
+		}
+	}
+	return waiting
+}
+
+// ShowBroadcast displays every agent's results for the broadcast in a single table, with the agents that are still
+// waiting or died before returning their results
+func ShowBroadcast(id string) {
+	b, err := GetBroadcast(id)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	var ids []uuid.UUID
+	for agentID := range b.Jobs {
+		ids = append(ids, agentID)
+	}
+	// Agents are grouped by their host name so results from the same host are together
+	sort.Slice(ids, func(i, j int) bool {
+		if hi, hj := broadcastHost(ids[i]), broadcastHost(ids[j]); hi != hj {
+			return hi < hj
+		}
+		return ids[i].String() < ids[j].String()
+	})
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Agent", "Host", "User", "Status", "Output"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAutoWrapText(false)
+	table.SetRowLine(true)
+	outputs := make(map[string]bool)
+	for _, agentID := range ids {
+		var user, status, output string
+		if isAgent(agentID) {
+			user = Agents[agentID].UserName
+		}
+		r, ok := b.Results[agentID]
+		switch {
+		case ok && r.Stderr != "":
+			status, output = "error", strings.TrimSpace(combined(r.Stdout, r.Stderr))
+		case ok:
+			status, output = "returned", strings.TrimSpace(r.Stdout)
+		case !isAgent(agentID) || GetAgentStatus(agentID) == "Dead":
+			status = "dead"
+		default:
+			status = "waiting"
+		}
+		if ok {
+			outputs[output] = true
+		}
+		table.Append([]string{agentID.String(), broadcastHost(agentID), user, status, output})
+	}
+	message("info", fmt.Sprintf("Broadcast %s of '%s' to the agents that matched %s at %s", b.ID,
+		strings.TrimSpace(b.Type+" "+strings.Join(b.Args, " ")), b.Filter, b.Created.Format(time.RFC3339)))
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+	message("info", fmt.Sprintf("%d of %d agents returned results with %d distinct outputs", len(b.Results), len(b.Jobs),
+		len(outputs)))
+}
+
+// broadcastHost returns the host name of a broadcast's agent, which is empty if the agent was removed
+func broadcastHost(agentID uuid.UUID) string {
+	if !isAgent(agentID) {
+		return ""
+	}
+	return Agents[agentID].HostName
+}
+
+// broadcastResult records the agent's results for its broadcast job and shows the broadcast's report once every agent
+// has returned its results
+func broadcastResult(agentID uuid.UUID, p messages.CmdResults) {
+	broadcasts.Lock()
+	id, ok := broadcasts.jobs[p.Job]
+	if !ok {
+		broadcasts.Unlock()
+		return
+	}
+	delete(broadcasts.jobs, p.Job)
+	b := broadcasts.m[id]
+	b.Results[agentID] = BroadcastResult{Time: time.Now().UTC(), Stdout: p.Stdout, Stderr: p.Stderr}
+	broadcasts.Unlock()
+	broadcastCheck()
+}
+
+// broadcastCheck completes the broadcasts whose agents have all returned their results or died and shows their reports
+func broadcastCheck() {
+	var completed []string
+	broadcasts.Lock()
+	for id, b := range broadcasts.m {
+		if b.Completed.IsZero() && len(b.copy().Waiting()) == 0 {
+			b.Completed = time.Now().UTC()
+			completed = append(completed, id)
+		}
+	}
+	broadcasts.Unlock()
+	for _, id := range completed {
+		b, _ := GetBroadcast(id)
+		note := fmt.Sprintf("Broadcast %s completed with results from %d of %d agents", id, len(b.Results), len(b.Jobs))
+		logging.Server(note)
+		fmt.Println()
+		message("success", note)
+		ShowBroadcast(id)
+	}
+}
+
+// copy returns a copy of the broadcast that does not share its maps
+func (b *Broadcast) copy() Broadcast {
+	c := *b
+	c.Jobs = make(map[uuid.UUID]string)
+	c.Results = make(map[uuid.UUID]BroadcastResult)
+	for k, v := range b.Jobs {
+		c.Jobs[k] = v
+	}
+	for k, v := range b.Results {
+		c.Results[k] = v
+	}
+	return c
+}
@@ -0,0 +1,56 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"testing"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// TestBroadcast verifies a broadcast skips dead agents and completes once every tasked agent returned its results
+func TestBroadcast(t *testing.T) {
+	a, b, dead := testAgent(t), testAgent(t), testAgent(t)
+	Agents[dead].StatusCheckIn = time.Now().UTC().Add(-24 * time.Hour)
+	broadcast, err := AddBroadcast([]uuid.UUID{a, b, dead}, "all", Job{Type: "cmd", Args: []string{"whoami"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(broadcast.Jobs) != 2 || broadcast.Jobs[dead] != "" {
+		t.Fatalf("expected a job for the 2 agents that are not dead, got %v", broadcast.Jobs)
+	}
+	if _, err = AddBroadcast([]uuid.UUID{dead}, "all", Job{Type: "cmd"}); err == nil {
+		t.Error("expected an error broadcasting to only dead agents")
+	}
+
+	broadcastResult(a, messages.CmdResults{Job: broadcast.Jobs[a], Stdout: "a\\user"})
+	if got, _ := GetBroadcast(broadcast.ID); !got.Completed.IsZero() || len(got.Waiting()) != 1 {
+		t.Fatalf("expected the broadcast to wait for agent %s, got %v", b, got.Waiting())
+	}
+	broadcastResult(b, messages.CmdResults{Job: broadcast.Jobs[b], Stderr: "access denied"})
+	got, _ := GetBroadcast(broadcast.ID)
+	if got.Completed.IsZero() || len(got.Results) != 2 || got.Results[b].Stderr != "access denied" {
+		t.Errorf("expected the broadcast to be completed with both results, got %+v", got)
+	}
+}
@@ -0,0 +1,116 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/events"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// crashesKept is the number of crash reports kept for each agent; older reports are only in the agent's log
+const crashesKept = 20
+
+// Crash handles a crash report an agent sent after it recovered from a panic and stores it under the agent's record
+func Crash(m messages.Base) error {
+	if core.Debug {
+		message("debug", "Entering into agents.Crash")
+	}
+
+	// Check to make sure it is a known agent
+	if !isAgent(m.ID) {
+		return api.Errorf(api.AgentNotFound, "%s is not a known agent", m.ID)
+	}
+
+	p, ok := m.Payload.(messages.Crash)
+	if !ok {
+		return fmt.Errorf("the crash report payload was a %T, not a Crash message", m.Payload)
+	}
+	a := Agents[m.ID]
+	a.Crashes = append(a.Crashes, p)
+	if len(a.Crashes) > crashesKept {
+		a.Crashes = a.Crashes[len(a.Crashes)-crashesKept:]
+	}
+	save(m.ID)
+
+	note := fmt.Sprintf("Agent %s recovered from a panic while handling a %s message: %s", m.ID, p.Message, p.Panic)
+	message("warn", note)
+	logging.Server(note)
+	Log(m.ID, fmt.Sprintf("%s\r\n%s", note, p.Stack))
+	publish(events.CheckIn, m.ID, note)
+	return nil
+}
+
+// GetCrashes returns a copy of the crash reports stored for the agent, oldest first
+func GetCrashes(agentID uuid.UUID) ([]messages.Crash, error) {
+	if !isAgent(agentID) {
+		return nil, api.Errorf(api.AgentNotFound, "%s is not a known agent", agentID)
+	}
+	return append([]messages.Crash(nil), Agents[agentID].Crashes...), nil
+}
+
+// ShowCrashes displays the agent's crash reports. The number is the crash report to show with its full stack trace,
+// counting from 1 for the oldest, or 0 to list them all.
+func ShowCrashes(agentID uuid.UUID, number int) {
+	crashes, err := GetCrashes(agentID)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	if len(crashes) == 0 {
+		message("info", fmt.Sprintf("Agent %s has not sent any crash reports; agents built with CrashReports "+
+			"enabled send one when they recover from a panic", agentID))
+		return
+	}
+	if number > len(crashes) || number < 0 {
+		message("warn", fmt.Sprintf("%d is not a valid crash report; agent %s has %d", number, agentID, len(crashes)))
+		return
+	}
+	if number > 0 {
+		c := crashes[number-1]
+		fmt.Println()
+		message("note", fmt.Sprintf("Crash %d at %s while handling a %s message: %s", number,
+			c.Time.Format(time.RFC3339), c.Message, c.Panic))
+		fmt.Println(c.Stack)
+		fmt.Println()
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeader([]string{"Crash", "Time", "Message", "Panic"})
+	for i, c := range crashes {
+		table.Append([]string{strconv.Itoa(i + 1), c.Time.Format(time.RFC3339), c.Message, c.Panic})
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
@@ -0,0 +1,212 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// FirewallRule is a firewall rule added on an agent's host by a firewall job
+type FirewallRule struct {
+	Name      string    `json:"name"`
+	Direction string    `json:"direction"`
+	Protocol  string    `json:"protocol"`
+	Port      string    `json:"port"`
+	Remote    string    `json:"remote,omitempty"` // Empty when the rule allows any remote address
+	Job       string    `json:"job"`
+	Added     time.Time `json:"added"`
+}
+
+// firewallFile returns the path of the file tracking the firewall rules added on an agent's host
+func firewallFile(agentID uuid.UUID) string {
+	return filepath.Join(core.CurrentDir, "data", "agents", agentID.String(), "firewall.json")
+}
+
+// FirewallRules returns the firewall rules that were added on an agent's host and not deleted yet, in the order they
+// were added
+func FirewallRules(agentID uuid.UUID) ([]FirewallRule, error) {
+	var rules []FirewallRule
+	data, err := ioutil.ReadFile(firewallFile(agentID)) // #nosec G304 the path is built from the agent's ID
+	if os.IsNotExist(err) {
+		return rules, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("there was an error parsing the firewall file:\r\n%s", err.Error())
+	}
+	return rules, nil
+}
+
+// ParseFirewall parses the arguments of a firewall job into a Firewall message. The arguments are list [<name>],
+// add <name> <tcp|udp> <port> [in|out] [<remote address>], and delete <name> [<tcp|udp> <port> [in|out]
+// [<remote address>]]. A delete job without a rule uses the rule the server recorded when it was added.
+func ParseFirewall(agentID uuid.UUID, args []string) (messages.Firewall, error) {
+	var f messages.Firewall
+	if len(args) < 1 {
+		return f, api.Errorf(api.InvalidOption, "the firewall job requires an action")
+	}
+	f.Action = args[0]
+	switch f.Action {
+	case "list":
+		if len(args) > 1 {
+			f.Name = args[1]
+		}
+	case "add":
+		if len(args) < 4 {
+			return f, api.Errorf(api.InvalidOption, "firewall add requires a name, protocol, and port")
+		}
+		f.Name = args[1]
+		if err := parseFirewallRule(&f, args[2:]); err != nil {
+			return f, err
+		}
+	case "delete":
+		if len(args) < 2 {
+			return f, api.Errorf(api.InvalidOption, "firewall delete requires a name")
+		}
+		f.Name = args[1]
+		if len(args) > 2 {
+			if err := parseFirewallRule(&f, args[2:]); err != nil {
+				return f, err
+			}
+			break
+		}
+		rules, err := FirewallRules(agentID)
+		if err != nil {
+			return f, err
+		}
+		for _, r := range rules {
+			if r.Name == f.Name {
+				f.Direction, f.Protocol, f.Port, f.Remote = r.Direction, r.Protocol, r.Port, r.Remote
+			}
+		}
+		if f.Protocol == "" {
+			return f, api.Errorf(api.NotFound, "there is no firewall rule named %s added by agent %s; provide its protocol and port", f.Name, agentID)
+		}
+	default:
+		return f, api.Errorf(api.InvalidOption, "%s is not a valid firewall action", f.Action)
+	}
+	if f.Name != "" && strings.ContainsAny(f.Name, " \t\r\n\"'=") {
+		return f, api.Errorf(api.InvalidOption, "%q is not a valid firewall rule name", f.Name)
+	}
+	if f.Action != "list" && isAgent(agentID) && Agents[agentID].Platform != "" {
+		if platform := Agents[agentID].Platform; platform != "windows" && platform != "linux" {
+			return f, api.Errorf(api.InvalidOption, "firewall rules can only be added and deleted on windows and linux agents, not %s", platform)
+		}
+	}
+	return f, nil
+}
+
+// parseFirewallRule parses the <tcp|udp> <port> [in|out] [<remote address>] arguments of a firewall rule. The rule is
+// inbound unless out is provided.
+func parseFirewallRule(f *messages.Firewall, args []string) error {
+	f.Protocol = strings.ToLower(args[0])
+	if f.Protocol != "tcp" && f.Protocol != "udp" {
+		return api.Errorf(api.InvalidOption, "%s is not a valid firewall protocol; use tcp or udp", args[0])
+	}
+	if len(args) < 2 {
+		return api.Errorf(api.InvalidOption, "the firewall rule requires a port")
+	}
+	if port, err := strconv.Atoi(args[1]); err != nil || port < 1 || port > 65535 {
+		return api.Errorf(api.InvalidOption, "%s is not a valid port", args[1])
+	}
+	f.Port = args[1]
+	f.Direction = "in"
+	rest := args[2:]
+	if len(rest) > 0 && (rest[0] == "in" || rest[0] == "out") {
+		f.Direction = rest[0]
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		remote := rest[0]
+		if net.ParseIP(remote) == nil {
+			if _, _, err := net.ParseCIDR(remote); err != nil {
+				return api.Errorf(api.InvalidOption, "%s is not a valid remote IP address or network", remote)
+			}
+		}
+		f.Remote = remote
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		return api.Errorf(api.InvalidOption, "unexpected firewall rule arguments: %s", strings.Join(rest, " "))
+	}
+	return nil
+}
+
+// recordFirewall updates the firewall rules added on an agent's host after a firewall add or delete job succeeded
+func recordFirewall(agentID uuid.UUID, job Job) {
+	f, err := ParseFirewall(agentID, job.Args)
+	if err != nil {
+		message("warn", fmt.Sprintf("there was an error recording the firewall rule for job %s:\r\n%s", job.ID, err.Error()))
+		return
+	}
+	if f.Action == "list" {
+		return
+	}
+	rules, err := FirewallRules(agentID)
+	if err != nil {
+		message("warn", fmt.Sprintf("there was an error reading the firewall file:\r\n%s", err.Error()))
+		return
+	}
+	var kept []FirewallRule
+	for _, r := range rules {
+		if r.Name != f.Name {
+			kept = append(kept, r)
+		}
+	}
+	if f.Action == "add" {
+		kept = append(kept, FirewallRule{Name: f.Name, Direction: f.Direction, Protocol: f.Protocol, Port: f.Port,
+			Remote: f.Remote, Job: job.ID, Added: time.Now().UTC()})
+	}
+	data, err := json.MarshalIndent(kept, "", "  ")
+	if err != nil {
+		message("warn", fmt.Sprintf("there was an error encoding the firewall file:\r\n%s", err.Error()))
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(firewallFile(agentID)), 0750); err != nil {
+		message("warn", fmt.Sprintf("there was an error creating the agent's directory:\r\n%s", err.Error()))
+		return
+	}
+	if err = ioutil.WriteFile(firewallFile(agentID), data, 0600); err != nil {
+		message("warn", fmt.Sprintf("there was an error writing the firewall file:\r\n%s", err.Error()))
+		return
+	}
+	if f.Action == "add" {
+		Log(agentID, fmt.Sprintf("Recorded %s %s/%s firewall rule %s added by job %s", f.Direction, f.Port, f.Protocol, f.Name, job.ID))
+	} else {
+		Log(agentID, fmt.Sprintf("Removed firewall rule %s from the record after job %s", f.Name, job.ID))
+	}
+}
@@ -0,0 +1,80 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"testing"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+)
+
+// TestFirewall verifies firewall job arguments are parsed and that added rules are recorded so a delete job can use
+// them
+func TestFirewall(t *testing.T) {
+	dir := core.CurrentDir
+	core.CurrentDir = t.TempDir()
+	defer func() { core.CurrentDir = dir }()
+	id := testAgent(t)
+	Agents[id].Platform = "linux"
+
+	invalid := [][]string{
+		{"add", "pivot", "icmp", "4444"},
+		{"add", "pivot", "tcp", "70000"},
+		{"add", "pivot rule", "tcp", "4444"},
+		{"add", "pivot", "tcp", "4444", "in", "not-an-address"},
+		{"delete", "pivot"},
+		{"flush"},
+	}
+	for _, args := range invalid {
+		if _, err := ParseFirewall(id, args); err == nil {
+			t.Errorf("the firewall arguments %v were parsed", args)
+		}
+	}
+
+	f, err := ParseFirewall(id, []string{"add", "pivot", "TCP", "4444", "10.0.0.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Protocol != "tcp" || f.Port != "4444" || f.Direction != "in" || f.Remote != "10.0.0.0/24" {
+		t.Errorf("the firewall rule was parsed as %+v", f)
+	}
+
+	recordFirewall(id, Job{ID: "job1", Type: "firewall", Args: []string{"add", "pivot", "udp", "53", "out"}})
+	rules, err := FirewallRules(id)
+	if err != nil || len(rules) != 1 || rules[0].Job != "job1" || rules[0].Direction != "out" {
+		t.Fatalf("the recorded rules were %+v: %v", rules, err)
+	}
+	f, err = ParseFirewall(id, []string{"delete", "pivot"})
+	if err != nil || f.Protocol != "udp" || f.Port != "53" || f.Direction != "out" {
+		t.Errorf("the delete job was parsed as %+v: %v", f, err)
+	}
+	recordFirewall(id, Job{ID: "job2", Type: "firewall", Args: []string{"delete", "pivot"}})
+	if rules, _ = FirewallRules(id); len(rules) != 0 {
+		t.Errorf("the deleted rule was still recorded: %+v", rules)
+	}
+
+	Agents[id].Platform = "darwin"
+	if _, err = ParseFirewall(id, []string{"add", "pivot", "tcp", "4444"}); err == nil {
+		t.Error("a firewall rule was added on a darwin agent")
+	}
+	if _, err = ParseFirewall(id, []string{"list"}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,192 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+	"github.com/satori/go.uuid"
+)
+
+// stormCheckIns is the number of consecutive status check ins that, when received within a single sleep interval, is
+// considered a retry storm
+const stormCheckIns = 5
+
+// health tracks an agent's transport reliability as observed by the server and as reported by the agent
+type health struct {
+	checkIns        int           // Number of status check ins received
+	recent          []time.Time   // The most recent status check in times used to detect a retry storm
+	storm           bool          // True while the agent is in a retry storm so one storm is only counted once
+	storms          int           // Number of times the agent checked in several times within a single sleep interval
+	outages         int           // Number of times the agent missed check ins and then reconnected
+	reconnectTotal  time.Duration // Total time the agent took to reconnect across all outages
+	reconnectMax    time.Duration // The longest time the agent took to reconnect
+	failures        int           // Total number of failed check ins reported by the agent after it reconnected
+	authentications int           // Number of OPAQUE authentications, including the first one
+	reAuths         int           // Number of times the agent was instructed to re-authenticate
+}
+
+// recordCheckIn updates the agent's transport health with a status check in received now. It must be called before the
+// agent's StatusCheckIn time is updated.
+func recordCheckIn(agentID uuid.UUID) {
+	if !isAgent(agentID) {
+		return
+	}
+	now := time.Now().UTC()
+	h := &Agents[agentID].health
+	h.checkIns++
+
+	sleep, err := time.ParseDuration(Agents[agentID].WaitTime)
+	if err != nil || sleep <= 0 {
+		return
+	}
+	interval := maxSleep(agentID) + time.Duration(Agents[agentID].Skew)*time.Millisecond
+
+	// An outage is any gap longer than two check in intervals
+	last := Agents[agentID].StatusCheckIn
+	if !last.IsZero() {
+		// A gap while the agent waits for its working hours to open is not an outage
+		if gap := now.Sub(last); gap > 2*interval && now.After(nextCheckIn(agentID, nextCheckIn(agentID, last))) {
+			h.outages++
+			h.reconnectTotal += gap
+			if gap > h.reconnectMax {
+				h.reconnectMax = gap
+			}
+			Log(agentID, fmt.Sprintf("Agent reconnected after %s without a status check in", gap.Round(time.Second)))
+		}
+	}
+
+	h.recent = append(h.recent, now)
+	if len(h.recent) > stormCheckIns {
+		h.recent = h.recent[1:]
+	}
+	if len(h.recent) == stormCheckIns && now.Sub(h.recent[0]) < sleep {
+		if !h.storm {
+			h.storms++
+			h.storm = true
+			message("warn", fmt.Sprintf("Agent %s checked in %d times in %s but its sleep is %s",
+				agentID, stormCheckIns, now.Sub(h.recent[0]).Round(time.Millisecond), sleep))
+			Log(agentID, fmt.Sprintf("Retry storm detected: %d status check ins in %s",
+				stormCheckIns, now.Sub(h.recent[0]).Round(time.Millisecond)))
+		}
+	} else {
+		h.storm = false
+	}
+}
+
+// Diagnose displays a summary of an agent's transport health along with recommended sleep, skew, and max retry settings
+func Diagnose(agentID uuid.UUID) {
+	if !isAgent(agentID) {
+		message("warn", fmt.Sprintf("%s is not a valid agent!", agentID))
+		return
+	}
+	h := Agents[agentID].health
+
+	var rate, average string
+	if h.checkIns+h.failures > 0 {
+		rate = fmt.Sprintf("%.1f%%", 100*float64(h.failures)/float64(h.checkIns+h.failures))
+	}
+	if h.outages > 0 {
+		average = (h.reconnectTotal / time.Duration(h.outages)).Round(time.Second).String()
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+
+	data := [][]string{
+		{"Status", GetAgentStatus(agentID)},
+		{"Last Check In", Agents[agentID].StatusCheckIn.Format(time.RFC3339)},
+		{"Agent Wait Time", Agents[agentID].WaitTime},
+		{"Agent Wait Time Skew", strconv.FormatInt(Agents[agentID].Skew, 10)},
+		{"Agent Max Retries", strconv.Itoa(Agents[agentID].MaxRetry)},
+		{"Status Check Ins", strconv.Itoa(h.checkIns)},
+		{"Failed Check Ins (agent reported)", strconv.Itoa(h.failures)},
+		{"Failure Rate", rate},
+		{"Outages", strconv.Itoa(h.outages)},
+		{"Average Time To Reconnect", average},
+		{"Longest Time To Reconnect", h.reconnectMax.Round(time.Second).String()},
+		{"Retry Storms", strconv.Itoa(h.storms)},
+		{"Authentications", strconv.Itoa(h.authentications)},
+		{"Re-Authentications", strconv.Itoa(h.reAuths)},
+	}
+	table.AppendBulk(data)
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+
+	for _, r := range recommendations(agentID) {
+		message("note", r)
+	}
+}
+
+// recommendations returns the agent menu settings that would make the agent's transport more reliable based on the
+// agent's transport health
+func recommendations(agentID uuid.UUID) []string {
+	var r []string
+	h := Agents[agentID].health
+
+	sleep, err := time.ParseDuration(Agents[agentID].WaitTime)
+	if err != nil || sleep <= 0 {
+		return []string{"The agent has not sent its configuration or has a sleep of zero; no recommendations can be made"}
+	}
+	skew := time.Duration(Agents[agentID].Skew) * time.Millisecond
+	interval := sleep + skew
+
+	// Frequent failures: slow the agent down and give it more retries
+	if h.checkIns+h.failures > 0 && float64(h.failures)/float64(h.checkIns+h.failures) > 0.2 {
+		r = append(r, fmt.Sprintf("More than 20%% of check ins are failing; reduce the request rate with 'set sleep %s'",
+			2*sleep))
+	}
+
+	// Outages that use up most of the retry budget: give the agent enough retries to survive twice the longest outage
+	budget := time.Duration(Agents[agentID].MaxRetry) * interval
+	if h.reconnectMax > 0 && h.reconnectMax*2 > budget {
+		retries := int(math.Ceil(float64(2*h.reconnectMax) / float64(interval)))
+		r = append(r, fmt.Sprintf("The longest outage (%s) is more than half of the agent's retry budget (%s); "+
+			"use 'set maxretry %d'", h.reconnectMax.Round(time.Second), budget, retries))
+	}
+
+	// Retry storms: spread the check ins out
+	if h.storms > 0 {
+		r = append(r, fmt.Sprintf("The agent checked in faster than its sleep %d time(s); verify only one agent is "+
+			"using this ID and spread out check ins with 'set skew %d'", h.storms, (sleep/2).Nanoseconds()/int64(time.Millisecond)))
+	}
+
+	// Re-authentication means the agent's token expired, which happens when outages last longer than its lifetime
+	if h.reAuths > 0 {
+		r = append(r, fmt.Sprintf("The agent had to re-authenticate %d time(s) because its token expired; "+
+			"increasing max retries also extends the token lifetime", h.reAuths))
+	}
+
+	if h.outages > 0 && skew == 0 {
+		r = append(r, fmt.Sprintf("The agent has no skew; use 'set skew %d' so check ins do not retry in lockstep "+
+			"with a recurring network outage", (sleep/5).Nanoseconds()/int64(time.Millisecond)))
+	}
+
+	if len(r) == 0 {
+		r = append(r, "The agent's transport is healthy; no changes are recommended")
+	}
+	return r
+}
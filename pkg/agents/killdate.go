@@ -0,0 +1,237 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/events"
+)
+
+// KillDateWarning is how long before an agent's killdate an event is published that it is about to exit
+var KillDateWarning = 24 * time.Hour
+
+// killDateLayouts are the date and time layouts a killdate can be written in, with or without a time zone after them
+var killDateLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	"Jan 2 2006 15:04",
+	"Jan 2 2006",
+	"2 Jan 2006 15:04",
+	"2 Jan 2006",
+}
+
+// zoneAbbreviations are the UTC offsets, in hours, of the common time zone abbreviations; Go can not look them up
+// because most are ambiguous, so IST is India and CST is US Central
+var zoneAbbreviations = map[string]float64{
+	"UTC": 0, "GMT": 0, "Z": 0, "WET": 0, "WEST": 1, "BST": 1, "CET": 1, "CEST": 2, "EET": 2, "EEST": 3, "MSK": 3,
+	"IST": 5.5, "SGT": 8, "HKT": 8, "JST": 9, "KST": 9, "AEST": 10, "AEDT": 11, "NZST": 12, "NZDT": 13,
+	"AST": -4, "ADT": -3, "EST": -5, "EDT": -4, "CST": -6, "CDT": -5, "MST": -7, "MDT": -6, "PST": -8, "PDT": -7,
+	"AKST": -9, "AKDT": -8, "HST": -10,
+}
+
+// killDateWarned is the killdate each agent's event was last published for so it is only published once
+var killDateWarned = struct {
+	sync.Mutex
+	m map[uuid.UUID]int64
+}{m: make(map[uuid.UUID]int64)}
+
+// ParseKillDate returns the Unix time of a killdate written as a Unix timestamp, an RFC3339 time, a date and time
+// followed by a time zone abbreviation, UTC offset, or IANA name (i.e. 2025-12-01 18:00 CET), or a duration from now
+// (i.e. +72h or +3d). A date and time without a time zone is in the agent host's time zone. 0 removes the killdate.
+func ParseKillDate(agentID uuid.UUID, value string) (int64, error) {
+	zone := time.UTC
+	if a, ok := Agents[agentID]; ok {
+		zone = time.FixedZone("agent", a.UTCOffset)
+	}
+	t, err := parseKillDate(value, zone, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if t.IsZero() {
+		return 0, nil
+	}
+	return t.Unix(), nil
+}
+
+// parseKillDate returns the time of a killdate, or the zero time when it is 0, with dates and times that do not have
+// a time zone in the zone and durations added to now
+func parseKillDate(value string, zone *time.Location, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, fmt.Errorf("a killdate is required")
+	}
+	if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if epoch == 0 {
+			return time.Time{}, nil
+		}
+		return checkKillDate(time.Unix(epoch, 0), now)
+	}
+	if strings.HasPrefix(value, "+") {
+		d, err := parseKillDuration(value[1:])
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return checkKillDate(t, now)
+	}
+
+	// A time zone is the last word when the rest is a date and time
+	loc := zone
+	date := value
+	if i := strings.LastIndex(value, " "); i > 0 {
+		if l, ok := parseZone(value[i+1:]); ok {
+			loc, date = l, strings.TrimSpace(value[:i])
+		}
+	}
+	for _, layout := range killDateLayouts {
+		if t, err := time.ParseInLocation(layout, date, loc); err == nil {
+			return checkKillDate(t, now)
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s is not a valid killdate; use a Unix timestamp, an RFC3339 time, a date and time "+
+		"with an optional time zone such as 2025-12-01 18:00 CET, or a duration from now such as +72h or +3d", value)
+}
+
+// parseKillDuration parses a duration that can also be a number of days, such as 3d
+func parseKillDuration(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(value, "d"), 64)
+		if err == nil && days > 0 {
+			return time.Duration(days * float64(24*time.Hour)), nil
+		}
+	} else if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("+%s is not a valid killdate duration; use a positive duration such as +72h or +3d", value)
+}
+
+// parseZone returns the location of a time zone abbreviation, a UTC offset such as +01:00 or UTC+1, or an IANA name
+func parseZone(zone string) (*time.Location, bool) {
+	if hours, ok := zoneAbbreviations[strings.ToUpper(zone)]; ok {
+		return time.FixedZone(strings.ToUpper(zone), int(hours*3600)), true
+	}
+	offset := strings.TrimPrefix(strings.TrimPrefix(strings.ToUpper(zone), "UTC"), "GMT")
+	if strings.HasPrefix(offset, "+") || strings.HasPrefix(offset, "-") {
+		sign := 1
+		if offset[0] == '-' {
+			sign = -1
+		}
+		digits := strings.Replace(offset[1:], ":", "", 1)
+		var hours, minutes int
+		var err error
+		switch len(digits) {
+		case 1, 2:
+			hours, err = strconv.Atoi(digits)
+		case 4:
+			if hours, err = strconv.Atoi(digits[:2]); err == nil {
+				minutes, err = strconv.Atoi(digits[2:])
+			}
+		default:
+			return nil, false
+		}
+		if err != nil || hours > 14 || minutes > 59 {
+			return nil, false
+		}
+		return time.FixedZone(zone, sign*(hours*3600+minutes*60)), true
+	}
+	if strings.Contains(zone, "/") {
+		if loc, err := time.LoadLocation(zone); err == nil {
+			return loc, true
+		}
+	}
+	return nil, false
+}
+
+// checkKillDate returns an error for a killdate that already passed, which would make the agent exit at its next
+// check in
+func checkKillDate(t time.Time, now time.Time) (time.Time, error) {
+	if !t.After(now) {
+		return time.Time{}, fmt.Errorf("the killdate %s already passed; use 0 to remove the killdate",
+			t.UTC().Format(time.RFC3339))
+	}
+	return t, nil
+}
+
+// killDate describes the agent's killdate in UTC and in the agent host's time zone with how long is left until it
+func killDate(agentID uuid.UUID) string {
+	if Agents[agentID].KillDate == 0 {
+		return "none"
+	}
+	t := time.Unix(Agents[agentID].KillDate, 0)
+	local := t.In(time.FixedZone("agent", Agents[agentID].UTCOffset))
+	left := time.Until(t)
+	s := fmt.Sprintf("%s (agent local %s)", t.UTC().Format(time.RFC3339), local.Format("2006-01-02 15:04:05 UTC-07:00"))
+	if left <= 0 {
+		return s + ", passed"
+	}
+	s += ", in " + left.Round(time.Minute).String()
+	if KillDateImminent(Agents[agentID].KillDate) {
+		s += " (imminent)"
+	}
+	return s
+}
+
+// KillDateImminent returns true when the Unix time is a killdate that is less than KillDateWarning away
+func KillDateImminent(epoch int64) bool {
+	return epoch > 0 && time.Until(time.Unix(epoch, 0)) < KillDateWarning
+}
+
+// killDateCheck warns the operator and publishes an event once for each agent whose killdate is less than
+// KillDateWarning away
+func killDateCheck() {
+	for _, id := range imminentKillDates() {
+		kill := time.Unix(Agents[id].KillDate, 0)
+		note := fmt.Sprintf("Agent exits at its killdate %s, in %s", kill.UTC().Format(time.RFC3339),
+			time.Until(kill).Round(time.Minute))
+		Log(id, note)
+		message("warn", fmt.Sprintf("Agent %s exits at its killdate %s, in %s", id, kill.UTC().Format(time.RFC3339),
+			time.Until(kill).Round(time.Minute)))
+		publish(events.KillDate, id, note)
+	}
+}
+
+// imminentKillDates returns the agents whose killdate is less than KillDateWarning away and that were not returned
+// for the same killdate before
+func imminentKillDates() []uuid.UUID {
+	killDateWarned.Lock()
+	defer killDateWarned.Unlock()
+	var ids []uuid.UUID
+	for id, a := range Agents {
+		if !KillDateImminent(a.KillDate) || time.Now().Unix() >= a.KillDate || killDateWarned.m[id] == a.KillDate {
+			continue
+		}
+		killDateWarned.m[id] = a.KillDate
+		ids = append(ids, id)
+	}
+	return ids
+}
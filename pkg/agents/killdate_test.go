@@ -0,0 +1,84 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"testing"
+	"time"
+)
+
+// TestParseKillDate verifies killdates written as Unix times, RFC3339 times, dates in a time zone, and durations are
+// parsed to the same instant, and dates without a time zone are in the agent's time zone
+func TestParseKillDate(t *testing.T) {
+	now := time.Date(2025, 11, 1, 12, 0, 0, 0, time.UTC)
+	agent := time.FixedZone("agent", -5*3600)
+	want := time.Date(2025, 12, 1, 17, 0, 0, 0, time.UTC)
+	tests := map[string]time.Time{
+		"1764608400":                     want,
+		"2025-12-01T18:00:00+01:00":      want,
+		"2025-12-01 18:00 CET":           want,
+		"2025-12-01 18:00 UTC+1":         want,
+		"2025-12-01 18:00 +01:00":        want,
+		"2025-12-01 18:00 Europe/Berlin": want,
+		"Dec 1 2025 17:00 UTC":           want,
+		"2025-12-01 12:00":               want,
+		"2025-12-01":                     time.Date(2025, 12, 1, 5, 0, 0, 0, time.UTC),
+		"+72h":                           now.Add(72 * time.Hour),
+		"+1.5d":                          now.Add(36 * time.Hour),
+		"0":                              {},
+	}
+	for value, expected := range tests {
+		got, err := parseKillDate(value, agent, now)
+		if err != nil {
+			t.Errorf("%s: %s", value, err)
+			continue
+		}
+		if !got.Equal(expected) {
+			t.Errorf("%s was parsed to %s instead of %s", value, got.UTC(), expected)
+		}
+	}
+	for _, value := range []string{"", "tomorrow", "2025-10-01 18:00 UTC", "+0s", "-3d", "2025-12-01 18:00 XYZ"} {
+		if got, err := parseKillDate(value, agent, now); err == nil {
+			t.Errorf("%q was parsed to %s", value, got)
+		}
+	}
+}
+
+// TestImminentKillDates verifies an agent is returned once when its killdate is less than KillDateWarning away
+func TestImminentKillDates(t *testing.T) {
+	id := testAgent(t)
+	count := func() int {
+		var n int
+		for _, a := range imminentKillDates() {
+			if a == id {
+				n++
+			}
+		}
+		return n
+	}
+
+	Agents[id].KillDate = time.Now().Add(48 * time.Hour).Unix()
+	if n := count(); n != 0 {
+		t.Errorf("an agent with a killdate in two days was returned %d times", n)
+	}
+	Agents[id].KillDate = time.Now().Add(time.Hour).Unix()
+	if n := count() + count(); n != 1 {
+		t.Errorf("an agent with a killdate in an hour was returned %d times", n)
+	}
+}
@@ -0,0 +1,53 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+
+	// 3rd Party
+	uuid "github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+)
+
+// KillSwitch tasks each agent that is not dead or pending with a selfdestruct job so it removes its persistence and
+// files and exits, for end-of-engagement cleanup. It returns the job created for each agent and the agents skipped.
+func KillSwitch(ids []uuid.UUID, reason string) (map[uuid.UUID]string, []uuid.UUID) {
+	jobs := make(map[uuid.UUID]string)
+	var skipped []uuid.UUID
+	for _, id := range ids {
+		if status := GetAgentStatus(id); status == "Dead" || status == "Pending" {
+			skipped = append(skipped, id)
+			continue
+		}
+		job, err := AddJob(id, "selfdestruct", []string{"selfdestruct"})
+		if err != nil {
+			message("warn", err.Error())
+			skipped = append(skipped, id)
+			continue
+		}
+		jobs[id] = job
+		Log(id, fmt.Sprintf("Kill switch %s created selfdestruct job %s", reason, job))
+	}
+	logging.Server(fmt.Sprintf("Kill switch %s created selfdestruct jobs for %d agents and skipped %d dead, pending, or "+
+		"failed agents", reason, len(jobs), len(skipped)))
+	return jobs, skipped
+}
@@ -0,0 +1,56 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"testing"
+	"time"
+
+	// 3rd Party
+	uuid "github.com/satori/go.uuid"
+)
+
+// TestKillSwitch verifies only the agents on the listener that are not dead are tasked with a selfdestruct job
+func TestKillSwitch(t *testing.T) {
+	listener := uuid.NewV4()
+	active, dead, other := testAgent(t), testAgent(t), testAgent(t)
+	SetListener(active, listener)
+	SetListener(dead, listener)
+	SetListener(other, uuid.NewV4())
+	Agents[dead].StatusCheckIn = time.Now().UTC().Add(-24 * time.Hour)
+
+	ids := ListenerAgents(listener)
+	if len(ids) != 2 {
+		t.Fatalf("expected 2 agents on the listener, got %d", len(ids))
+	}
+	jobs, skipped := KillSwitch(ids, "for a test")
+	if len(jobs) != 1 || jobs[active] == "" {
+		t.Errorf("expected a selfdestruct job for only the active agent, got %v", jobs)
+	}
+	if len(skipped) != 1 || skipped[0] != dead {
+		t.Errorf("expected the dead agent to be skipped, got %v", skipped)
+	}
+	queued := queuedJobs(active)
+	if len(queued) != 1 || queued[0].Type != "selfdestruct" {
+		t.Errorf("expected the active agent to have a queued selfdestruct job, got %v", queued)
+	}
+	if len(queuedJobs(other)) != 0 {
+		t.Error("an agent on another listener was tasked")
+	}
+}
@@ -0,0 +1,115 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/highlight"
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// ModuleResult is the latest output a module returned on an agent
+type ModuleResult struct {
+	Module string
+	Job    string
+	Time   time.Time
+	Stdout string
+	Stderr string
+}
+
+// moduleJob is a job created by running a module
+type moduleJob struct {
+	module string
+	diff   bool // Display how the job's results differ from the module's previous results on the agent
+}
+
+// moduleRuns holds the jobs created by modules and the latest results of each module on each agent
+var moduleRuns = struct {
+	sync.Mutex
+	jobs    map[string]moduleJob                  // Job ID to the module that created it
+	results map[uuid.UUID]map[string]ModuleResult // Agent ID to module name to its latest results
+}{jobs: make(map[string]moduleJob), results: make(map[uuid.UUID]map[string]ModuleResult)}
+
+// TrackModuleJob caches the results of a job created by a module. With diff, the results are compared with the
+// module's previous results on the agent when they are returned.
+func TrackModuleJob(job string, module string, diff bool) {
+	moduleRuns.Lock()
+	defer moduleRuns.Unlock()
+	moduleRuns.jobs[job] = moduleJob{module: module, diff: diff}
+}
+
+// LastModuleResult returns the latest cached results of the module on the agent
+func LastModuleResult(agentID uuid.UUID, module string) (ModuleResult, bool) {
+	moduleRuns.Lock()
+	defer moduleRuns.Unlock()
+	r, ok := moduleRuns.results[agentID][module]
+	return r, ok
+}
+
+// moduleResult caches the results of a job if a module created it and displays how they differ from the module's
+// previous results on the agent if the module was run again with diff
+func moduleResult(agentID uuid.UUID, p messages.CmdResults) {
+	moduleRuns.Lock()
+	defer moduleRuns.Unlock()
+	j, ok := moduleRuns.jobs[p.Job]
+	if !ok {
+		return
+	}
+	delete(moduleRuns.jobs, p.Job)
+	if moduleRuns.results[agentID] == nil {
+		moduleRuns.results[agentID] = make(map[string]ModuleResult)
+	}
+	previous, ok := moduleRuns.results[agentID][j.module]
+	moduleRuns.results[agentID][j.module] = ModuleResult{Module: j.module, Job: p.Job, Time: time.Now().UTC(),
+		Stdout: p.Stdout, Stderr: p.Stderr}
+	if !j.diff {
+		return
+	}
+	if !ok {
+		message("note", fmt.Sprintf("There are no previous %s module results for agent %s to compare with", j.module, agentID))
+		return
+	}
+	out, changes := highlight.Diff(combined(previous.Stdout, previous.Stderr), combined(p.Stdout, p.Stderr))
+	if changes == 0 {
+		message("info", fmt.Sprintf("The %s module results are the same as job %s at %s", j.module, previous.Job,
+			previous.Time.Format(time.RFC3339)))
+		return
+	}
+	message("info", fmt.Sprintf("The %s module results changed %d lines since job %s at %s:", j.module, changes,
+		previous.Job, previous.Time.Format(time.RFC3339)))
+	fmt.Println(out)
+	Log(agentID, fmt.Sprintf("The %s module results for job %s changed %d lines since job %s", j.module, p.Job,
+		changes, previous.Job))
+}
+
+// combined returns a job's standard output followed by its standard error on their own lines
+func combined(stdout string, stderr string) string {
+	if stderr == "" || stdout == "" {
+		return stdout + stderr
+	}
+	return strings.TrimRight(stdout, "\r\n") + "\n" + stderr
+}
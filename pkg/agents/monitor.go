@@ -0,0 +1,71 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// statusInterval is how often the agents' statuses are checked for changes
+const statusInterval = 10 * time.Second
+
+// monitor starts the status monitor once
+var monitor sync.Once
+
+// MonitorStatus checks the agents' statuses in the background and publishes a dead event when an agent misses more
+// check ins than its dead threshold allows, and a status event when it becomes delayed or checks in again. Agents that
+// were already dead when the monitor started are not reported.
+func MonitorStatus() {
+	monitor.Do(func() {
+		go func() {
+			last := checkStatus(nil)
+			ticker := time.NewTicker(statusInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				last = checkStatus(last)
+			}
+		}()
+	})
+}
+
+// checkStatus publishes an event for every agent whose status changed since the last statuses, and returns the agents'
+// current statuses
+func checkStatus(last map[uuid.UUID]string) map[uuid.UUID]string {
+	current := make(map[uuid.UUID]string)
+	for id := range Agents {
+		current[id] = GetAgentStatus(id)
+	}
+	for id, status := range current {
+		previous, ok := last[id]
+		if !ok {
+			continue
+		}
+		if event, note := statusChange(id, previous, status); event != "" {
+			Log(id, note)
+			publish(event, id, note)
+		}
+	}
+	// A broadcast is complete once the agents it is waiting for are dead
+	broadcastCheck()
+	return current
+}
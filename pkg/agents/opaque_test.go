@@ -0,0 +1,55 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"bytes"
+	"testing"
+
+	// 3rd Party
+	"github.com/cretz/gopaque/gopaque"
+)
+
+// TestOPAQUERecordRoundTrip verifies a stored OPAQUE registration record loads back unchanged
+func TestOPAQUERecordRoundTrip(t *testing.T) {
+	record := gopaque.ServerRegisterComplete{
+		UserID:           []byte("agent"),
+		ServerPrivateKey: gopaque.CryptoDefault.Scalar().Pick(gopaque.CryptoDefault.RandomStream()),
+		UserPublicKey:    gopaque.CryptoDefault.Point().Pick(gopaque.CryptoDefault.RandomStream()),
+		EnvU:             []byte("envelope"),
+		KU:               gopaque.CryptoDefault.Scalar().Pick(gopaque.CryptoDefault.RandomStream()),
+	}
+	data, err := marshalOPAQUE(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := unmarshalOPAQUE(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.UserID, record.UserID) || !bytes.Equal(loaded.EnvU, record.EnvU) {
+		t.Error("the OPAQUE user ID or envelope did not survive the round trip")
+	}
+	if !loaded.ServerPrivateKey.Equal(record.ServerPrivateKey) || !loaded.KU.Equal(record.KU) {
+		t.Error("the OPAQUE scalars did not survive the round trip")
+	}
+	if !loaded.UserPublicKey.Equal(record.UserPublicKey) {
+		t.Error("the OPAQUE user public key did not survive the round trip")
+	}
+}
@@ -0,0 +1,165 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// PersistMethods are the persistence methods an agent supports on each platform
+var PersistMethods = map[string][]string{
+	"windows": {"schtask", "runkey"},
+	"linux":   {"systemd", "cron"},
+	"darwin":  {"launchd", "cron"},
+}
+
+// Persistence is a persistence mechanism installed on an agent's host by a persist job
+type Persistence struct {
+	Name      string    `json:"name"`
+	Method    string    `json:"method"`
+	Command   string    `json:"command,omitempty"` // Empty when the agent persisted its own command line
+	Job       string    `json:"job"`
+	Installed time.Time `json:"installed"`
+}
+
+// persistenceFile returns the path of the file tracking the persistence installed on an agent's host
+func persistenceFile(agentID uuid.UUID) string {
+	return filepath.Join(core.CurrentDir, "data", "agents", agentID.String(), "persistence.json")
+}
+
+// InstalledPersistence returns the persistence mechanisms that are installed on an agent's host, in the order they
+// were installed
+func InstalledPersistence(agentID uuid.UUID) ([]Persistence, error) {
+	var installed []Persistence
+	data, err := ioutil.ReadFile(persistenceFile(agentID)) // #nosec G304 the path is built from the agent's ID
+	if os.IsNotExist(err) {
+		return installed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &installed); err != nil {
+		return nil, fmt.Errorf("there was an error parsing the persistence file:\r\n%s", err.Error())
+	}
+	return installed, nil
+}
+
+// ParsePersist parses the arguments of a persist job into a Persist message. The arguments are
+// add <method> <name> [<command>] and remove <name> [<method>]. A remove job without a method uses the method the
+// named persistence was installed with.
+func ParsePersist(agentID uuid.UUID, args []string) (messages.Persist, error) {
+	var p messages.Persist
+	if len(args) < 2 {
+		return p, api.Errorf(api.InvalidOption, "the persist job requires an action and its arguments")
+	}
+	p.Action = args[0]
+	switch p.Action {
+	case "add":
+		if len(args) < 3 {
+			return p, api.Errorf(api.InvalidOption, "persist add requires a method and a name")
+		}
+		p.Method, p.Name = args[1], args[2]
+		p.Command = strings.Join(args[3:], " ")
+	case "remove":
+		p.Name = args[1]
+		if len(args) > 2 {
+			p.Method = args[2]
+			break
+		}
+		installed, err := InstalledPersistence(agentID)
+		if err != nil {
+			return p, err
+		}
+		for _, i := range installed {
+			if i.Name == p.Name {
+				p.Method = i.Method
+			}
+		}
+		if p.Method == "" {
+			return p, api.Errorf(api.NotFound, "there is no persistence named %s installed by agent %s", p.Name, agentID)
+		}
+	default:
+		return p, api.Errorf(api.InvalidOption, "%s is not a valid persist action", p.Action)
+	}
+	if p.Name == "" || strings.ContainsAny(p.Name, " \t\r\n/\\") {
+		return p, api.Errorf(api.InvalidOption, "%q is not a valid persistence name", p.Name)
+	}
+	if isAgent(agentID) && Agents[agentID].Platform != "" {
+		platform := Agents[agentID].Platform
+		for _, method := range PersistMethods[platform] {
+			if method == p.Method {
+				return p, nil
+			}
+		}
+		return p, api.Errorf(api.InvalidOption, "%s is not a persistence method for %s agents, use one of: %s",
+			p.Method, platform, strings.Join(PersistMethods[platform], ", "))
+	}
+	return p, nil
+}
+
+// recordPersistence updates the persistence installed on an agent's host after a persist job succeeded
+func recordPersistence(agentID uuid.UUID, job Job) {
+	p, err := ParsePersist(agentID, job.Args)
+	if err != nil {
+		message("warn", fmt.Sprintf("there was an error recording the persistence for job %s:\r\n%s", job.ID, err.Error()))
+		return
+	}
+	installed, err := InstalledPersistence(agentID)
+	if err != nil {
+		message("warn", fmt.Sprintf("there was an error reading the persistence file:\r\n%s", err.Error()))
+		return
+	}
+	var kept []Persistence
+	for _, i := range installed {
+		if i.Name != p.Name || i.Method != p.Method {
+			kept = append(kept, i)
+		}
+	}
+	if p.Action == "add" {
+		kept = append(kept, Persistence{Name: p.Name, Method: p.Method, Command: p.Command, Job: job.ID, Installed: time.Now().UTC()})
+	}
+	data, err := json.MarshalIndent(kept, "", "  ")
+	if err != nil {
+		message("warn", fmt.Sprintf("there was an error encoding the persistence file:\r\n%s", err.Error()))
+		return
+	}
+	if err = ioutil.WriteFile(persistenceFile(agentID), data, 0600); err != nil {
+		message("warn", fmt.Sprintf("there was an error writing the persistence file:\r\n%s", err.Error()))
+		return
+	}
+	if p.Action == "add" {
+		Log(agentID, fmt.Sprintf("Recorded %s persistence %s installed by job %s", p.Method, p.Name, job.ID))
+	} else {
+		Log(agentID, fmt.Sprintf("Removed %s persistence %s from the record after job %s", p.Method, p.Name, job.ID))
+	}
+}
@@ -0,0 +1,93 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// ParsePins returns the certificate public key pins for a list of arguments that are each a comma separated list of
+// base64 encoded SHA-256 pins or PEM certificate files. Every certificate in a file is pinned, so a file that holds a
+// certificate chain pins its intermediate and root certificates as well.
+func ParsePins(args []string) ([]string, error) {
+	var pins []string
+	add := func(pin string) {
+		for _, p := range pins {
+			if p == pin {
+				return
+			}
+		}
+		pins = append(pins, pin)
+	}
+	for _, arg := range args {
+		for _, a := range strings.Split(arg, ",") {
+			if a = strings.TrimSpace(a); a == "" {
+				continue
+			}
+			if pin, err := util.ParsePin(a); err == nil {
+				add(pin)
+				continue
+			}
+			filePins, err := certificatePins(a)
+			if err != nil {
+				return nil, err
+			}
+			for _, pin := range filePins {
+				add(pin)
+			}
+		}
+	}
+	return pins, nil
+}
+
+// certificatePins returns the public key pins of every certificate in a PEM file
+func certificatePins(file string) ([]string, error) {
+	data, err := ioutil.ReadFile(file) // #nosec G304 - User should be able to read in any file
+	if err != nil {
+		return nil, api.Errorf(api.InvalidOption, "%s is not a public key pin or a readable certificate file", file)
+	}
+	var pins []string
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("there was an error parsing a certificate in %s:\r\n%s", file, err.Error())
+		}
+		pins = append(pins, util.PublicKeyPin(cert))
+	}
+	if len(pins) == 0 {
+		return nil, api.Errorf(api.InvalidOption, "%s does not contain a PEM encoded certificate", file)
+	}
+	return pins, nil
+}
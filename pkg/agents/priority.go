@@ -0,0 +1,132 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+)
+
+const (
+	// PriorityLow jobs are sent after the agent's other queued jobs
+	PriorityLow = -1
+	// PriorityNormal is the priority of jobs created without one
+	PriorityNormal = 0
+	// PriorityHigh jobs are sent before the agent's normal and low priority queued jobs
+	PriorityHigh = 1
+	// PriorityUrgent jobs are sent before all of the agent's other queued jobs
+	PriorityUrgent = 2
+)
+
+// priorities are the names of the job priority levels
+var priorities = map[string]int{
+	"low":    PriorityLow,
+	"normal": PriorityNormal,
+	"high":   PriorityHigh,
+	"urgent": PriorityUrgent,
+}
+
+// Priorities returns the names of the job priority levels from lowest to highest
+func Priorities() []string {
+	return []string{"low", "normal", "high", "urgent"}
+}
+
+// ParsePriority returns the job priority level with the name
+func ParsePriority(name string) (int, error) {
+	p, ok := priorities[strings.ToLower(name)]
+	if !ok {
+		return PriorityNormal, api.Errorf(api.InvalidOption, "%s is not a valid job priority; use %s", name,
+			strings.Join(Priorities(), ", "))
+	}
+	return p, nil
+}
+
+// PriorityName returns the name of the job priority level
+func PriorityName(priority int) string {
+	for name, p := range priorities {
+		if p == priority {
+			return name
+		}
+	}
+	return fmt.Sprintf("%d", priority)
+}
+
+// SetJobPriority changes the priority of the agent's queued job
+func SetJobPriority(agentID uuid.UUID, jobID string, priority int) error {
+	if !isAgent(agentID) {
+		return api.Errorf(api.AgentNotFound, "%s is not a known agent", agentID)
+	}
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	for i, job := range Agents[agentID].queue {
+		if job.ID == jobID {
+			Agents[agentID].queue[i].Priority = priority
+			saveJobs(agentID)
+			Log(agentID, fmt.Sprintf("Changed the priority of job %s to %s", jobID, PriorityName(priority)))
+			return nil
+		}
+	}
+	return jobNotQueued(agentID, jobID)
+}
+
+// CancelJob removes the job from its agent's queued jobs before it is sent and returns the agent; a nil agent ID
+// searches the queued jobs of every agent. Jobs held by a barrier are canceled with the barrier so the barrier's other
+// agents are not left waiting.
+func CancelJob(agentID uuid.UUID, jobID string) (uuid.UUID, error) {
+	if agentID == uuid.Nil {
+		for id := range Agents {
+			for _, job := range queuedJobs(id) {
+				if job.ID == jobID {
+					agentID = id
+				}
+			}
+		}
+		if agentID == uuid.Nil {
+			return agentID, api.Errorf(api.NotFound, "%s is not a queued job", jobID)
+		}
+	}
+	if !isAgent(agentID) {
+		return agentID, api.Errorf(api.AgentNotFound, "%s is not a known agent", agentID)
+	}
+	for _, job := range queuedJobs(agentID) {
+		if job.ID == jobID && job.Barrier != "" {
+			return agentID, api.Errorf(api.InvalidOption, "job %s is held by barrier %s; cancel the barrier instead",
+				jobID, job.Barrier)
+		}
+	}
+	if !removeJob(agentID, jobID) {
+		return agentID, jobNotQueued(agentID, jobID)
+	}
+	Log(agentID, fmt.Sprintf("Canceled job %s", jobID))
+	return agentID, nil
+}
+
+// jobNotQueued returns the error for a job that is not in the agent's queued jobs, which says if it was already sent
+func jobNotQueued(agentID uuid.UUID, jobID string) error {
+	if _, ok := Agents[agentID].sent[jobID]; ok {
+		return api.Errorf(api.InvalidOption, "job %s was already sent to agent %s", jobID, agentID)
+	}
+	return api.Errorf(api.NotFound, "%s is not a queued job for agent %s", jobID, agentID)
+}
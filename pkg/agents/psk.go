@@ -0,0 +1,236 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+)
+
+// Keys are the pre-shared keys a running listener accepts; the first key is the listener's own and agents re-keyed to
+// it stop using the others
+type Keys interface {
+	PSKs() []string
+	SetPSKs(psks []string) error
+}
+
+// PSKRotation is the outcome of replacing one listener's pre-shared key
+type PSKRotation struct {
+	Listener uuid.UUID
+	PSK      string      // The listener's new pre-shared key
+	Agents   []uuid.UUID // The agents that check in through the listener and were tasked with the new key
+	Expires  time.Time   // When the listener stops accepting its previous keys
+}
+
+// graceKeys are previous keys a listener accepts until they expire
+type graceKeys struct {
+	keys    []string
+	expires time.Time
+	agents  []uuid.UUID    // The agents tasked with the key that replaced these
+	jobs    map[string]int // The re-key job IDs mapped to their agent's index in agents
+}
+
+// keyring holds the listeners whose pre-shared keys can be rotated and the previous keys each still accepts
+var keyring = struct {
+	sync.Mutex
+	listeners map[uuid.UUID]Keys
+	grace     map[uuid.UUID][]*graceKeys
+}{listeners: make(map[uuid.UUID]Keys), grace: make(map[uuid.UUID][]*graceKeys)}
+
+// RegisterKeys adds a running listener to the listeners whose pre-shared key can be rotated with RotatePSK
+func RegisterKeys(id uuid.UUID, k Keys) {
+	keyring.Lock()
+	keyring.listeners[id] = k
+	keyring.Unlock()
+}
+
+// SetListener records the listener the agent last checked in through
+func SetListener(agentID uuid.UUID, listener uuid.UUID) {
+	if isAgent(agentID) {
+		Agents[agentID].Listener = listener
+	}
+}
+
+// ListenerAgents returns the agents that last checked in through the listener, sorted by their ID
+func ListenerAgents(listener uuid.UUID) []uuid.UUID {
+	var ids []uuid.UUID
+	for id, a := range Agents {
+		if a.Listener == listener {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	return ids
+}
+
+// RotatePSK replaces a listener's pre-shared key with the given key, or a random key when it is empty, and tasks the
+// agents that checked in through the listener since the server started to use it. The listener keeps accepting its
+// previous keys for the grace period so agents that have not received the new key yet can still authenticate; once it
+// ends, agents that did not re-key keep working until they need to authenticate again.
+func RotatePSK(id uuid.UUID, psk string, grace time.Duration) (PSKRotation, error) {
+	r := PSKRotation{Listener: id, PSK: psk}
+	if grace < 0 {
+		return r, errors.New("the grace period can not be negative")
+	}
+	rotation.Lock()
+	running := rotation.running
+	rotation.Unlock()
+	if running {
+		return r, errors.New("a rotation of every listener is in progress")
+	}
+	keyring.Lock()
+	l, ok := keyring.listeners[id]
+	keyring.Unlock()
+	if !ok {
+		return r, fmt.Errorf("%s is not a running listener whose pre-shared key can be rotated", id)
+	}
+	if r.PSK == "" {
+		r.PSK = core.RandStringBytesMaskImprSrc(30)
+	}
+	old := l.PSKs()
+	for _, k := range old {
+		if k == r.PSK {
+			return r, fmt.Errorf("listener %s already accepts that pre-shared key", id)
+		}
+	}
+
+	// The listener accepts the new key first so agents can authenticate with it as soon as they receive it
+	if err := l.SetPSKs(append([]string{r.PSK}, old...)); err != nil {
+		return r, fmt.Errorf("there was an error adding the new PSK to listener %s:\r\n%s", id, err.Error())
+	}
+	g := &graceKeys{keys: old, jobs: make(map[string]int)}
+	for _, agentID := range ListenerAgents(id) {
+		job, err := AddJob(agentID, "psk", []string{"psk", r.PSK})
+		if err != nil {
+			message("warn", fmt.Sprintf("there was an error tasking agent %s with listener %s's new PSK:\r\n%s", agentID, id, err.Error()))
+			continue
+		}
+		g.jobs[job] = len(g.agents)
+		g.agents = append(g.agents, agentID)
+	}
+	r.Agents = g.agents
+	r.Expires = time.Now().UTC().Add(grace)
+	g.expires = r.Expires
+
+	keyring.Lock()
+	keyring.grace[id] = append(keyring.grace[id], g)
+	keyring.Unlock()
+	time.AfterFunc(grace, func() { expireKeys(id, l, g) })
+	logging.Server(fmt.Sprintf("Rotated the PSK of listener %s and tasked %d agents with it; the previous keys are "+
+		"accepted until %s", id, len(r.Agents), r.Expires.Format(time.RFC3339)))
+	return r, nil
+}
+
+// GracePeriod returns how many previous pre-shared keys a listener still accepts and when the last of them expires
+func GracePeriod(id uuid.UUID) (int, time.Time) {
+	keyring.Lock()
+	defer keyring.Unlock()
+	var count int
+	var expires time.Time
+	for _, g := range keyring.grace[id] {
+		count += len(g.keys)
+		if g.expires.After(expires) {
+			expires = g.expires
+		}
+	}
+	return count, expires
+}
+
+// clearGrace forgets the previous keys of a listener that no longer accepts them because every listener was rotated
+func clearGrace(id uuid.UUID) {
+	keyring.Lock()
+	delete(keyring.grace, id)
+	keyring.Unlock()
+}
+
+// expireKeys stops the listener from accepting the previous keys of a rotation whose grace period ended and reports
+// the agents that have not received the new key yet
+func expireKeys(id uuid.UUID, l Keys, g *graceKeys) {
+	keyring.Lock()
+	list := keyring.grace[id]
+	found := false
+	for i, e := range list {
+		if e == g {
+			keyring.grace[id] = append(list[:i:i], list[i+1:]...)
+			found = true
+			break
+		}
+	}
+	// A rotation of every listener already removed the previous keys
+	if !found {
+		keyring.Unlock()
+		return
+	}
+	// Keys a later rotation moved into its own grace period are kept until it expires
+	keep := make(map[string]bool)
+	for _, e := range keyring.grace[id] {
+		for _, k := range e.keys {
+			keep[k] = true
+		}
+	}
+	keyring.Unlock()
+
+	// Agents whose re-key job is still queued have not received the new key
+	var pending int
+	for _, agentID := range g.agents {
+		for _, job := range queuedJobs(agentID) {
+			if _, ok := g.jobs[job.ID]; ok {
+				pending++
+				break
+			}
+		}
+	}
+
+	expired := make(map[string]bool)
+	for _, k := range g.keys {
+		expired[k] = !keep[k]
+	}
+	current := l.PSKs()
+	keys := current[:1:1]
+	for _, k := range current[1:] {
+		if !expired[k] {
+			keys = append(keys, k)
+		}
+	}
+	if err := l.SetPSKs(keys); err != nil {
+		m := fmt.Sprintf("there was an error removing the expired PSKs from listener %s:\r\n%s", id, err.Error())
+		message("warn", m)
+		logging.Server(m)
+		return
+	}
+
+	m := fmt.Sprintf("The grace period for listener %s's previous PSK ended", id)
+	if pending > 0 {
+		m += fmt.Sprintf("; %d agents have not received the new key and can not authenticate again until they do", pending)
+		message("warn", m)
+	} else {
+		message("info", m)
+	}
+	logging.Server(m)
+}
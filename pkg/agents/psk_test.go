@@ -0,0 +1,86 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"testing"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// TestRotatePSK verifies rotating a listener's PSK tasks its agents with the new key and accepts the previous key only
+// until the grace period ends
+func TestRotatePSK(t *testing.T) {
+	l := &testListener{psks: []string{"merlin"}}
+	listenerID := uuid.NewV4()
+	RegisterKeys(listenerID, l)
+	t.Cleanup(func() {
+		keyring.Lock()
+		delete(keyring.listeners, listenerID)
+		delete(keyring.grace, listenerID)
+		keyring.Unlock()
+	})
+	id := testAgent(t)
+	Agents[id].Listener = listenerID
+	other := testAgent(t)
+
+	if _, err := RotatePSK(uuid.NewV4(), "", time.Minute); err == nil {
+		t.Error("the PSK of a listener that is not registered was rotated")
+	}
+	if _, err := RotatePSK(listenerID, "merlin", time.Minute); err == nil {
+		t.Error("the listener was rotated to a PSK it already accepts")
+	}
+
+	r, err := RotatePSK(listenerID, "", 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := l.PSKs(); len(keys) != 2 || keys[0] != r.PSK || keys[1] != "merlin" {
+		t.Errorf("the listener accepts %v during the grace period", keys)
+	}
+	if len(r.Agents) != 1 || r.Agents[0] != id {
+		t.Errorf("agents %v were tasked with the new PSK instead of %s", r.Agents, id)
+	}
+	jobs := queuedJobs(id)
+	if len(jobs) != 1 || jobs[0].Type != "psk" || jobs[0].Args[1] != r.PSK {
+		t.Errorf("the listener's agent was queued %+v", jobs)
+	}
+	if len(queuedJobs(other)) != 0 {
+		t.Error("an agent on another listener was tasked with the new PSK")
+	}
+	if count, _ := GracePeriod(listenerID); count != 1 {
+		t.Errorf("the listener has %d previous PSKs instead of 1", count)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(l.PSKs()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the listener still accepts %v after the grace period", l.PSKs())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if keys := l.PSKs(); keys[0] != r.PSK {
+		t.Errorf("the listener accepts %v instead of its new PSK", keys)
+	}
+	if count, _ := GracePeriod(listenerID); count != 0 {
+		t.Errorf("the listener has %d previous PSKs after the grace period", count)
+	}
+}
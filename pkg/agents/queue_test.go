@@ -0,0 +1,263 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// testAgent adds an active simulated agent that logs to a temporary file to the agents map and removes it when the test
+// ends
+func testAgent(t *testing.T) uuid.UUID {
+	f, err := ioutil.TempFile("", "merlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := uuid.NewV4()
+	Agents[id] = &agent{ID: id, agentLog: f, simulated: true, sent: make(map[string]Job), WaitTime: "30s",
+		StatusCheckIn: time.Now().UTC()}
+	t.Cleanup(func() {
+		delete(Agents, id)
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	})
+	return id
+}
+
+// takeJobs returns the IDs of the jobs the agent would receive, in order, until none are left that can be sent
+func takeJobs(agentID uuid.UUID) []string {
+	var ids []string
+	for {
+		job, ok := nextJob(agentID)
+		if !ok {
+			return ids
+		}
+		ids = append(ids, job.ID)
+	}
+}
+
+// TestQueueMoreThanTen verifies queueing jobs does not block once an agent has more jobs than it used to hold
+func TestQueueMoreThanTen(t *testing.T) {
+	id := testAgent(t)
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 25; i++ {
+			if _, err := AddJob(id, "cmd", []string{"whoami"}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queueing 25 jobs for an agent blocked")
+	}
+	if n := len(takeJobs(id)); n != 25 {
+		t.Errorf("expected 25 jobs to be sent but got %d", n)
+	}
+}
+
+// TestEmbargo verifies an embargoed job is not sent before its time while the jobs behind it are
+func TestEmbargo(t *testing.T) {
+	id := testAgent(t)
+	embargoed, err := AddJobAfter(id, "cmd", []string{"whoami"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready, err := AddJobAfter(id, "cmd", []string{"hostname"}, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := takeJobs(id); len(got) != 1 || got[0] != ready {
+		t.Errorf("expected only job %s to be sent but got %v", ready, got)
+	}
+	if jobs := queuedJobs(id); len(jobs) != 1 || jobs[0].ID != embargoed {
+		t.Errorf("expected embargoed job %s to stay queued but got %v", embargoed, jobs)
+	}
+}
+
+// TestPriority verifies higher priority jobs are sent first and jobs with the same priority are sent in queued order
+func TestPriority(t *testing.T) {
+	id := testAgent(t)
+	var want []string
+	for _, p := range []int{PriorityLow, PriorityNormal, PriorityUrgent, PriorityNormal, PriorityHigh} {
+		job, err := AddJobPriority(id, "cmd", []string{"whoami"}, time.Time{}, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, job)
+	}
+	if err := SetJobPriority(id, want[3], PriorityUrgent); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{want[2], want[3], want[4], want[1], want[0]}
+	if got := takeJobs(id); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected the jobs to be sent in the order %v but got %v", want, got)
+	}
+	if _, err := ParsePriority("highest"); err == nil {
+		t.Error("expected an error parsing an unknown priority")
+	}
+}
+
+// TestCancelJob verifies a queued job is removed by ID, found without its agent, and not canceled once it is sent
+func TestCancelJob(t *testing.T) {
+	id := testAgent(t)
+	first, err := AddJob(id, "cmd", []string{"whoami"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := AddJob(id, "cmd", []string{"hostname"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agentID, err := CancelJob(uuid.Nil, second); err != nil || agentID != id {
+		t.Fatalf("expected job %s to be canceled for agent %s but got %s: %v", second, id, agentID, err)
+	}
+	if jobs := queuedJobs(id); len(jobs) != 1 || jobs[0].ID != first {
+		t.Errorf("expected only job %s to stay queued but got %v", first, jobs)
+	}
+	job, _ := nextJob(id)
+	Agents[id].sent[job.ID] = job
+	if _, err = CancelJob(id, first); err == nil {
+		t.Error("expected an error canceling a job that was already sent")
+	}
+}
+
+// TestBarrier verifies a barrier's jobs are held until every agent has checked in
+func TestBarrier(t *testing.T) {
+	a, b := testAgent(t), testAgent(t)
+	barrier, err := AddBarrierJobs([]uuid.UUID{a, b}, Job{Type: "cmd", Args: []string{"whoami"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = CancelBarrier(barrier.ID) }()
+	if _, err = AddBarrierJobs([]uuid.UUID{a}, Job{Type: "cmd"}); err == nil {
+		t.Error("expected an error creating a barrier for one agent")
+	}
+
+	barrierCheckIn(a)
+	if got := takeJobs(a); len(got) != 0 {
+		t.Fatalf("expected the barrier to hold the job until every agent checked in but got %v", got)
+	}
+	barrierCheckIn(b)
+	for _, agentID := range []uuid.UUID{a, b} {
+		if got := takeJobs(agentID); len(got) != 1 || got[0] != barrier.Jobs[agentID] {
+			t.Errorf("expected job %s to be sent to agent %s once the barrier was released but got %v",
+				barrier.Jobs[agentID], agentID, got)
+		}
+	}
+}
+
+// TestCancelBarrier verifies canceling a barrier removes the jobs it holds
+func TestCancelBarrier(t *testing.T) {
+	a, b := testAgent(t), testAgent(t)
+	barrier, err := AddBarrierJobs([]uuid.UUID{a, b}, Job{Type: "cmd", Args: []string{"whoami"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = CancelBarrier(barrier.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, agentID := range []uuid.UUID{a, b} {
+		if jobs := queuedJobs(agentID); len(jobs) != 0 {
+			t.Errorf("expected agent %s to have no queued jobs after the barrier was canceled but got %v", agentID, jobs)
+		}
+	}
+}
+
+// TestThrottle verifies the second agent's group jobs are not released until the first agent's jobs are finished
+func TestThrottle(t *testing.T) {
+	a, b := testAgent(t), testAgent(t)
+	groups := [][]Job{{{ID: "throttleA", Type: "cmd", Args: []string{"whoami"}}}, {{ID: "throttleB", Type: "cmd", Args: []string{"whoami"}}}}
+	done := make(chan struct{})
+	go func() {
+		releaseThrottled([]uuid.UUID{a, b}, groups, Throttle{Max: 1})
+		close(done)
+	}()
+
+	wait := func(agentID uuid.UUID) []Job {
+		for end := time.Now().Add(5 * time.Second); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+			if jobs := queuedJobs(agentID); len(jobs) > 0 {
+				return jobs
+			}
+		}
+		return nil
+	}
+	if jobs := wait(a); len(jobs) != 1 || jobs[0].ID != "throttleA" {
+		t.Fatalf("expected job throttleA to be released to the first agent but got %v", jobs)
+	}
+	// The throttle checks the unfinished jobs every second
+	time.Sleep(1500 * time.Millisecond)
+	if jobs := queuedJobs(b); len(jobs) != 0 {
+		t.Errorf("expected the second agent's job to be held while the first agent's job is queued but got %v", jobs)
+	}
+	// The first agent's job is finished once it is sent because it is not waiting for results
+	takeJobs(a)
+	if jobs := wait(b); len(jobs) != 1 || jobs[0].ID != "throttleB" {
+		t.Errorf("expected job throttleB to be released to the second agent but got %v", jobs)
+	}
+	<-done
+}
+
+// TestQueueConcurrent verifies jobs queued and sent from several goroutines are each sent once
+func TestQueueConcurrent(t *testing.T) {
+	id := testAgent(t)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var sent int
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				jobID := fmt.Sprintf("%d-%d", i, j)
+				queueJob(id, Job{ID: jobID, Type: "cmd", Args: []string{"whoami"}, Created: time.Now().UTC()})
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, ok := nextJob(id); ok {
+					mutex.Lock()
+					sent++
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	sent += len(takeJobs(id))
+	if sent != 200 {
+		t.Errorf("expected 200 jobs to be sent but got %d", sent)
+	}
+}
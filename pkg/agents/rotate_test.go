@@ -0,0 +1,219 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"crypto/tls"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// testListener is a listener that records its certificate and PSKs
+type testListener struct {
+	sync.Mutex
+	cert tls.Certificate
+	psks []string
+}
+
+func (l *testListener) TLSCertificate() tls.Certificate {
+	l.Lock()
+	defer l.Unlock()
+	return l.cert
+}
+
+func (l *testListener) SetCertificate(cert tls.Certificate) error {
+	l.Lock()
+	defer l.Unlock()
+	l.cert = cert
+	return nil
+}
+
+func (l *testListener) PSKs() []string {
+	l.Lock()
+	defer l.Unlock()
+	return append([]string{}, l.psks...)
+}
+
+func (l *testListener) SetPSKs(psks []string) error {
+	if len(psks) == 0 {
+		return errors.New("at least one pre-shared key is required")
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.psks = append([]string{}, psks...)
+	return nil
+}
+
+func (l *testListener) AnyURI() bool {
+	return true
+}
+
+// testRotation registers a listener and an active HTTP agent for a rotation and removes the listener when the test ends
+func testRotation(t *testing.T) (*testListener, uuid.UUID) {
+	cert, err := util.GenerateTLSCert(nil, nil, nil, nil, nil, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &testListener{cert: *cert, psks: []string{"merlin"}}
+	listenerID := uuid.NewV4()
+	RegisterListener(listenerID, l)
+	rotationPoll = 10 * time.Millisecond
+	t.Cleanup(func() {
+		rotation.Lock()
+		delete(rotation.listeners, listenerID)
+		rotation.Unlock()
+	})
+
+	id := testAgent(t)
+	Agents[id].Proto = "h2"
+	Agents[id].Skew = 3000
+	Agents[id].JA3 = "c030,c014"
+	Agents[id].Callbacks = []string{"https://127.0.0.1:443/"}
+	return l, id
+}
+
+// TestRotate verifies a rotation whose agent applies its changes replaces the listener's certificate and PSK and tasks
+// the agent with removing its old callback URL
+func TestRotate(t *testing.T) {
+	l, id := testRotation(t)
+	old := l.TLSCertificate()
+
+	// Simulate the agent applying its jobs and reporting its settings
+	stop, done := make(chan struct{}), make(chan struct{})
+	// The agent must stop before the test removes it
+	defer func() {
+		close(stop)
+		<-done
+	}()
+	removed := make(chan string, 1)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			job, ok := nextJob(id)
+			if !ok {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			// The settings are changed the way UpdateInfo changes them when the agent checks in
+			settingsMutex.Lock()
+			switch job.Type {
+			case "sleep":
+				Agents[id].WaitTime = job.Args[1]
+			case "skew":
+				Agents[id].Skew, _ = strconv.ParseInt(job.Args[1], 10, 64)
+			case "ja3":
+				Agents[id].JA3 = job.Args[1]
+			case "callbacks":
+				f := strings.Fields(job.Args[1])
+				if f[0] == "add" {
+					Agents[id].Callbacks = append(Agents[id].Callbacks, f[1])
+				} else {
+					removed <- f[1]
+				}
+			}
+			settingsMutex.Unlock()
+		}
+	}()
+
+	r, err := Rotate(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RolledBack || len(r.Agents) != 1 || r.Agents[0] != id {
+		t.Fatalf("expected agent %s to be rotated, got %+v", id, r)
+	}
+	if psks := l.PSKs(); len(psks) != 1 || psks[0] != r.PSK {
+		t.Errorf("expected the listener to only accept the new PSK, got %v", psks)
+	}
+	if string(l.TLSCertificate().Certificate[0]) == string(old.Certificate[0]) {
+		t.Error("expected the listener's certificate to be replaced")
+	}
+	select {
+	case u := <-removed:
+		if u != "https://127.0.0.1:443/" {
+			t.Errorf("expected the agent's old callback URL to be removed, got %s", u)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected the agent to be tasked with removing its old callback URL")
+	}
+}
+
+// TestRotateRollback verifies a rotation whose agent does not confirm its changes restores the listener and tasks the
+// agent with its previous settings
+func TestRotateRollback(t *testing.T) {
+	l, id := testRotation(t)
+	old := l.TLSCertificate()
+
+	r, err := Rotate(50 * time.Millisecond)
+	if err == nil {
+		t.Fatal("expected the rotation to fail when the agent does not confirm its changes")
+	}
+	if !r.RolledBack || len(r.Pending) != 1 {
+		t.Fatalf("expected the rotation to be rolled back with one pending agent, got %+v", r)
+	}
+	if psks := l.PSKs(); len(psks) != 1 || psks[0] != "merlin" {
+		t.Errorf("expected the listener's PSK to be restored, got %v", psks)
+	}
+	if string(l.TLSCertificate().Certificate[0]) != string(old.Certificate[0]) {
+		t.Error("expected the listener's certificate to be unchanged")
+	}
+
+	// The rollback jobs follow the rotation's jobs and end with the agent's previous settings
+	var jobs []Job
+	for {
+		job, ok := nextJob(id)
+		if !ok {
+			break
+		}
+		jobs = append(jobs, job)
+	}
+	last := make(map[string]string)
+	for _, j := range jobs {
+		last[j.Type] = strings.Join(j.Args[1:], " ")
+	}
+	if last["sleep"] != "30s" || last["skew"] != "3000" || last["ja3"] != "c030,c014" || last["psk"] != "merlin" {
+		t.Errorf("expected the agent to be tasked with its previous settings, got %v", last)
+	}
+}
+
+// TestRandomJA3 verifies a random Client Hello always offers a cipher suite for RSA certificates
+func TestRandomJA3(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		spec := randomJA3()
+		ciphers := strings.Split(strings.Split(spec, ";")[0], ",")
+		if !contains(ciphers, "c02f") && !contains(ciphers, "c030") && !contains(ciphers, "cca8") {
+			t.Fatalf("%s does not offer a cipher suite for RSA certificates", spec)
+		}
+	}
+}
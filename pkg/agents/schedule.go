@@ -0,0 +1,69 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"time"
+
+	// 3rd Party
+	uuid "github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/schedule"
+)
+
+// maxSleep returns the longest the agent sleeps between check ins with its jitter
+func maxSleep(agentID uuid.UUID) time.Duration {
+	sleep, err := time.ParseDuration(Agents[agentID].WaitTime)
+	if err != nil || sleep <= 0 {
+		return 0
+	}
+	return sleep + sleep*time.Duration(Agents[agentID].Jitter)/100
+}
+
+// nextCheckIn returns the latest time the agent checks in after the time, which is pushed to the start of its working
+// hours when the agent would otherwise check in outside of them
+func nextCheckIn(agentID uuid.UUID, after time.Time) time.Time {
+	return scheduled(agentID, after.Add(maxSleep(agentID)))
+}
+
+// scheduled returns the time if it is inside the agent's working hours, otherwise the time they next open
+func scheduled(agentID uuid.UUID, t time.Time) time.Time {
+	hours, err := schedule.Parse(Agents[agentID].WorkingHours)
+	if err != nil || hours == nil {
+		return t
+	}
+	// The working hours are in the agent host's time zone
+	zone := time.FixedZone("agent", Agents[agentID].UTCOffset)
+	return hours.Next(t.In(zone))
+}
+
+// workingHours describes the agent's working hours and the UTC offset of the time zone they are in
+func workingHours(agentID uuid.UUID) string {
+	if Agents[agentID].WorkingHours == "" {
+		return "any time"
+	}
+	offset := Agents[agentID].UTCOffset
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	return fmt.Sprintf("%s (UTC%s%02d:%02d)", Agents[agentID].WorkingHours, sign, offset/3600, offset%3600/60)
+}
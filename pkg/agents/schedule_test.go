@@ -0,0 +1,59 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestStatusSchedule verifies an agent's status accounts for its jitter and for the time it waits for its working
+// hours to open
+func TestStatusSchedule(t *testing.T) {
+	id := testAgent(t)
+	Agents[id].MaxRetry = 7
+
+	Agents[id].StatusCheckIn = time.Now().UTC().Add(-40 * time.Second)
+	if status := GetAgentStatus(id); status != "Delayed" {
+		t.Errorf("expected an agent 10 seconds past its sleep to be Delayed, got %s", status)
+	}
+	Agents[id].Jitter = 50
+	if status := GetAgentStatus(id); status != "Active" {
+		t.Errorf("expected an agent within its jitter to be Active, got %s", status)
+	}
+
+	// The working hours open in two hours, so the agent is not expected until then
+	Agents[id].Jitter = 0
+	Agents[id].StatusCheckIn = time.Now().UTC().Add(-time.Hour)
+	if status := GetAgentStatus(id); status != "Dead" {
+		t.Errorf("expected an agent without working hours to be Dead, got %s", status)
+	}
+	open := time.Now().UTC().Add(2 * time.Hour)
+	Agents[id].WorkingHours = fmt.Sprintf("%02d00-%02d00", open.Hour(), (open.Hour()+1)%24)
+	if status := GetAgentStatus(id); status != "Active" {
+		t.Errorf("expected an agent waiting for its working hours %s to be Active, got %s", Agents[id].WorkingHours, status)
+	}
+	// Hours around the current time in a time zone two hours ahead are already open
+	Agents[id].UTCOffset = 2 * 60 * 60
+	Agents[id].WorkingHours = fmt.Sprintf("%02d00-%02d00", (open.Hour()+21)%24, (open.Hour()+2)%24)
+	if status := GetAgentStatus(id); status != "Dead" {
+		t.Errorf("expected an agent inside its working hours %s in its time zone to be Dead, got %s", Agents[id].WorkingHours, status)
+	}
+}
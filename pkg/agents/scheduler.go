@@ -0,0 +1,258 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+	"github.com/Ne0nd0g/merlin/pkg/core"
+)
+
+// schedulerInterval is how often the scheduled jobs are checked for jobs that are due
+const schedulerInterval = 5 * time.Second
+
+// ScheduledJob is a job the server queues for an agent at a time, once or again every interval
+type ScheduledJob struct {
+	ID      string        `json:"id"`
+	Type    string        `json:"type"`
+	Args    []string      `json:"args"`
+	Next    time.Time     `json:"next"`            // When the job is queued next
+	Every   time.Duration `json:"every,omitempty"` // How often the job is queued; zero queues it once
+	Runs    int           `json:"runs"`            // How many times the job was queued
+	Last    string        `json:"last,omitempty"`  // The ID of the job queued last
+	Created time.Time     `json:"created"`
+}
+
+// schedules are the agents' scheduled jobs, keyed by agent
+var schedules = struct {
+	sync.Mutex
+	m map[uuid.UUID][]ScheduledJob
+}{m: make(map[uuid.UUID][]ScheduledJob)}
+
+// scheduler starts the job scheduler once
+var scheduler sync.Once
+
+// scheduleFile returns the path of the file the agent's scheduled jobs are kept in across restarts
+func scheduleFile(agentID uuid.UUID) string {
+	return filepath.Join(core.CurrentDir, "data", "agents", agentID.String(), "schedule.json")
+}
+
+// RunScheduler loads the agents' scheduled jobs and queues each one in the background when it is due
+func RunScheduler() {
+	scheduler.Do(func() {
+		schedules.Lock()
+		for id := range Agents {
+			list, err := readSchedule(id)
+			if err != nil {
+				message("warn", err.Error())
+				continue
+			}
+			if len(list) > 0 {
+				schedules.m[id] = list
+			}
+		}
+		schedules.Unlock()
+		go func() {
+			ticker := time.NewTicker(schedulerInterval)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				runSchedules(now.UTC())
+			}
+		}()
+	})
+}
+
+// Schedule queues the job for the agent at the time, and again every interval if it is not zero. A zero time queues
+// the job for the first time one interval from now.
+func Schedule(agentID uuid.UUID, jobType string, args []string, at time.Time, every time.Duration) (ScheduledJob, error) {
+	var s ScheduledJob
+	if !isAgent(agentID) {
+		return s, api.Errorf(api.AgentNotFound, "%s is not a valid agent", agentID)
+	}
+	if every < 0 || (every > 0 && every < time.Minute) {
+		return s, api.Errorf(api.InvalidOption, "a job can not be scheduled more often than every minute")
+	}
+	now := time.Now().UTC()
+	if at.IsZero() {
+		if every == 0 {
+			return s, api.Errorf(api.InvalidOption, "a scheduled job needs a time or an interval")
+		}
+		at = now.Add(every)
+	}
+	if !at.After(now) {
+		return s, api.Errorf(api.InvalidOption, "the scheduled time %s has already passed", at.Format(time.RFC3339))
+	}
+	s = ScheduledJob{ID: core.RandStringBytesMaskImprSrc(10), Type: jobType, Args: args, Next: at.UTC(), Every: every, Created: now}
+	schedules.Lock()
+	defer schedules.Unlock()
+	schedules.m[agentID] = append(schedules.m[agentID], s)
+	if err := writeSchedule(agentID); err != nil {
+		return s, err
+	}
+	Log(agentID, fmt.Sprintf("Scheduled %s job %s for %s%s", s.Type, s.ID, s.Next.Format(time.RFC3339), scheduleInterval(s)))
+	return s, nil
+}
+
+// Schedules returns the agent's scheduled jobs in the order they are queued next
+func Schedules(agentID uuid.UUID) []ScheduledJob {
+	schedules.Lock()
+	defer schedules.Unlock()
+	list := append([]ScheduledJob{}, schedules.m[agentID]...)
+	sort.Slice(list, func(i, j int) bool { return list[i].Next.Before(list[j].Next) })
+	return list
+}
+
+// Unschedule removes the agent's scheduled job; jobs it already queued are not removed
+func Unschedule(agentID uuid.UUID, id string) error {
+	schedules.Lock()
+	defer schedules.Unlock()
+	var kept []ScheduledJob
+	for _, s := range schedules.m[agentID] {
+		if s.ID != id {
+			kept = append(kept, s)
+		}
+	}
+	if len(kept) == len(schedules.m[agentID]) {
+		return api.Errorf(api.NotFound, "%s is not a scheduled job for agent %s", id, agentID)
+	}
+	schedules.m[agentID] = kept
+	if err := writeSchedule(agentID); err != nil {
+		return err
+	}
+	Log(agentID, fmt.Sprintf("Removed scheduled job %s", id))
+	return nil
+}
+
+// runSchedules queues the scheduled jobs that are due. A recurring job is skipped while the job it queued last is still
+// waiting to be sent, so an agent that checks in less often than the interval does not fall behind with a backlog, and
+// the runs missed while the server was down are not made up.
+func runSchedules(now time.Time) {
+	schedules.Lock()
+	defer schedules.Unlock()
+	for id, list := range schedules.m {
+		if !isAgent(id) {
+			delete(schedules.m, id)
+			continue
+		}
+		var kept []ScheduledJob
+		changed := false
+		for _, s := range list {
+			if s.Next.After(now) {
+				kept = append(kept, s)
+				continue
+			}
+			changed = true
+			if s.Every == 0 || !queued(id, s.Last) {
+				job, err := AddJob(id, s.Type, s.Args)
+				if err != nil {
+					Log(id, fmt.Sprintf("Removed scheduled job %s because its %s job could not be queued:\r\n%s", s.ID, s.Type, err.Error()))
+					continue
+				}
+				s.Runs++
+				s.Last = job
+				Log(id, fmt.Sprintf("Scheduled job %s queued %s job %s", s.ID, s.Type, job))
+			}
+			if s.Every == 0 {
+				continue
+			}
+			for !s.Next.After(now) {
+				s.Next = s.Next.Add(s.Every)
+			}
+			kept = append(kept, s)
+		}
+		if changed {
+			schedules.m[id] = kept
+			if err := writeSchedule(id); err != nil {
+				message("warn", err.Error())
+			}
+		}
+	}
+}
+
+// queued returns true if the job is waiting to be sent to the agent
+func queued(agentID uuid.UUID, jobID string) bool {
+	for _, job := range queuedJobs(agentID) {
+		if job.ID == jobID {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleInterval describes how often a scheduled job is queued for log messages
+func scheduleInterval(s ScheduledJob) string {
+	if s.Every == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" and every %s after", s.Every)
+}
+
+// readSchedule reads the agent's scheduled jobs from its schedule file
+func readSchedule(agentID uuid.UUID) ([]ScheduledJob, error) {
+	var list []ScheduledJob
+	data, err := ioutil.ReadFile(scheduleFile(agentID)) // #nosec G304 the path is built from the agent's ID
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("there was an error reading the schedule file for agent %s:\r\n%s", agentID, err.Error())
+	}
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("there was an error parsing the schedule file for agent %s:\r\n%s", agentID, err.Error())
+	}
+	return list, nil
+}
+
+// writeSchedule writes the agent's scheduled jobs to its schedule file; the caller holds the schedules lock. The
+// scheduled jobs of simulated agents are not kept across restarts.
+func writeSchedule(agentID uuid.UUID) error {
+	if Agents[agentID].simulated {
+		return nil
+	}
+	if len(schedules.m[agentID]) == 0 {
+		delete(schedules.m, agentID)
+		if err := os.Remove(scheduleFile(agentID)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("there was an error removing the schedule file for agent %s:\r\n%s", agentID, err.Error())
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(schedules.m[agentID], "", "  ")
+	if err != nil {
+		return fmt.Errorf("there was an error encoding the schedule file for agent %s:\r\n%s", agentID, err.Error())
+	}
+	if err = os.MkdirAll(filepath.Dir(scheduleFile(agentID)), 0750); err != nil {
+		return fmt.Errorf("there was an error creating the directory for agent %s:\r\n%s", agentID, err.Error())
+	}
+	if err = ioutil.WriteFile(scheduleFile(agentID), data, 0600); err != nil {
+		return fmt.Errorf("there was an error writing the schedule file for agent %s:\r\n%s", agentID, err.Error())
+	}
+	return nil
+}
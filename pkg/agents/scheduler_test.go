@@ -0,0 +1,74 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"testing"
+	"time"
+)
+
+// TestSchedule verifies a one-shot job is queued once when it is due, and a recurring job is skipped while its last job
+// is waiting to be sent
+func TestSchedule(t *testing.T) {
+	id := testAgent(t)
+	now := time.Now().UTC()
+	once, err := Schedule(id, "cmd", []string{"whoami"}, now.Add(time.Minute), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	every, err := Schedule(id, "cmd", []string{"hostname"}, time.Time{}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Schedule(id, "cmd", []string{"id"}, time.Time{}, time.Second); err == nil {
+		t.Error("expected an interval shorter than a minute to be rejected")
+	}
+	if list := Schedules(id); len(list) != 2 || list[0].ID != once.ID {
+		t.Fatalf("expected 2 scheduled jobs with the one-shot job first, got %v", list)
+	}
+
+	runSchedules(now)
+	if len(queuedJobs(id)) != 0 {
+		t.Fatal("a job was queued before it was due")
+	}
+	runSchedules(now.Add(2 * time.Minute))
+	if queued := queuedJobs(id); len(queued) != 1 || queued[0].Args[0] != "whoami" {
+		t.Fatalf("expected the one-shot job to be queued, got %v", queued)
+	}
+	if list := Schedules(id); len(list) != 1 || list[0].ID != every.ID {
+		t.Fatalf("expected only the recurring job to remain scheduled, got %v", list)
+	}
+
+	runSchedules(now.Add(61 * time.Minute))
+	runSchedules(now.Add(121 * time.Minute))
+	if queued := queuedJobs(id); len(queued) != 2 {
+		t.Fatalf("expected the recurring job to be skipped while its last job is queued, got %d queued jobs", len(queued))
+	}
+	s := Schedules(id)[0]
+	if s.Runs != 1 || !s.Next.After(now.Add(121*time.Minute)) {
+		t.Errorf("expected the recurring job to run once and move past the missed run, got %d runs next at %s", s.Runs, s.Next)
+	}
+
+	if err = Unschedule(id, every.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = Unschedule(id, every.ID); err == nil {
+		t.Error("expected removing a job that is not scheduled to fail")
+	}
+}
@@ -0,0 +1,98 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// defaultSearchLimit is the most results a find or grep job returns unless -limit is used
+const defaultSearchLimit = 500
+
+// ParseSearch parses the arguments of a find or grep job into a Search message. The arguments are
+// find <path> [-name|-iname <glob>] [-mtime N] [-limit N] and grep [-i] [-name <glob>] [-limit N] <pattern> <path>.
+func ParseSearch(method string, args []string) (messages.Search, error) {
+	s := messages.Search{Method: method, Limit: defaultSearchLimit}
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		flag := args[i]
+		if flag == "-i" && method == "grep" {
+			s.IgnoreCase = true
+			continue
+		}
+		if flag != "-name" && flag != "-iname" && flag != "-mtime" && flag != "-limit" {
+			positional = append(positional, flag)
+			continue
+		}
+		if i+1 >= len(args) {
+			return s, fmt.Errorf("the %s flag requires a value", flag)
+		}
+		i++
+		switch flag {
+		case "-name", "-iname":
+			if _, err := filepath.Match(args[i], ""); err != nil {
+				return s, fmt.Errorf("%s is not a valid name pattern:\r\n%s", args[i], err.Error())
+			}
+			s.Name = args[i]
+			if flag == "-iname" {
+				s.IgnoreCase = true
+			}
+		case "-mtime":
+			if method != "find" {
+				return s, fmt.Errorf("the -mtime flag is only valid for find")
+			}
+			if _, err := strconv.Atoi(strings.TrimPrefix(args[i], "+")); err != nil {
+				return s, fmt.Errorf("%s is not a valid -mtime value", args[i])
+			}
+			s.MTime = args[i]
+		case "-limit":
+			limit, err := strconv.Atoi(args[i])
+			if err != nil || limit <= 0 {
+				return s, fmt.Errorf("%s is not a valid result limit", args[i])
+			}
+			s.Limit = limit
+		}
+	}
+
+	switch method {
+	case "find":
+		if len(positional) != 1 {
+			return s, fmt.Errorf("find <path> [-name|-iname <glob>] [-mtime N] [-limit N]")
+		}
+		s.Path = positional[0]
+	case "grep":
+		if len(positional) != 2 {
+			return s, fmt.Errorf("grep [-i] [-name <glob>] [-limit N] <pattern> <path>")
+		}
+		if _, err := regexp.Compile(positional[0]); err != nil {
+			return s, fmt.Errorf("%s is not a valid regular expression:\r\n%s", positional[0], err.Error())
+		}
+		s.Pattern, s.Path = positional[0], positional[1]
+	default:
+		return s, fmt.Errorf("%s is not a valid search method", method)
+	}
+	return s, nil
+}
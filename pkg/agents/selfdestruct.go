@@ -0,0 +1,90 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// selfDestructMessage returns the SelfDestruct message with the persistence the server recorded as installed by the
+// agent and the paths in its cleanup manifest. Paths are sent newest first so files are deleted before the
+// directories that hold them.
+func selfDestructMessage(agentID uuid.UUID, job Job) (messages.SelfDestruct, error) {
+	p := messages.SelfDestruct{Job: job.ID}
+	installed, err := InstalledPersistence(agentID)
+	if err != nil {
+		return p, fmt.Errorf("there was an error reading the agent's persistence:\r\n%s", err.Error())
+	}
+	for _, i := range installed {
+		p.Persistence = append(p.Persistence, messages.Persist{Action: "remove", Method: i.Method, Name: i.Name, Command: i.Command})
+	}
+	entries, err := CleanupManifest(agentID)
+	if err != nil {
+		return p, fmt.Errorf("there was an error reading the cleanup manifest:\r\n%s", err.Error())
+	}
+	seen := make(map[string]bool)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if path := entries[i][2]; !seen[path] {
+			seen[path] = true
+			p.Paths = append(p.Paths, path)
+		}
+	}
+	return p, nil
+}
+
+// recordSelfDestruct removes the persistence the agent reported it removed from the server's record and logs what the
+// agent could not remove
+func recordSelfDestruct(agentID uuid.UUID, job Job, removed []string, failed string) {
+	installed, err := InstalledPersistence(agentID)
+	if err != nil {
+		message("warn", fmt.Sprintf("there was an error reading the persistence file:\r\n%s", err.Error()))
+		return
+	}
+	var kept []Persistence
+	for _, i := range installed {
+		if !containsString(removed, i.Name) {
+			kept = append(kept, i)
+		}
+	}
+	if len(kept) != len(installed) {
+		data, err := json.MarshalIndent(kept, "", "  ")
+		if err != nil {
+			message("warn", fmt.Sprintf("there was an error encoding the persistence file:\r\n%s", err.Error()))
+			return
+		}
+		if err = ioutil.WriteFile(persistenceFile(agentID), data, 0600); err != nil {
+			message("warn", fmt.Sprintf("there was an error writing the persistence file:\r\n%s", err.Error()))
+			return
+		}
+	}
+	m := fmt.Sprintf("Agent %s self-destructed with job %s and removed %d items", agentID, job.ID, len(removed))
+	if failed != "" {
+		m += "; some could not be removed:\r\n" + strings.TrimSpace(failed)
+	}
+	Log(agentID, m)
+}
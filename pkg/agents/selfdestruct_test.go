@@ -0,0 +1,70 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"os"
+	"path/filepath"
+	"testing"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+)
+
+// TestSelfDestruct verifies the selfdestruct message carries the recorded persistence and the cleanup manifest, newest
+// first, and that the persistence the agent removed is dropped from the record
+func TestSelfDestruct(t *testing.T) {
+	dir := core.CurrentDir
+	core.CurrentDir = t.TempDir()
+	defer func() { core.CurrentDir = dir }()
+	id := testAgent(t)
+	Agents[id].Platform = "linux"
+	if err := os.MkdirAll(filepath.Join(core.CurrentDir, "data", "agents", id.String()), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	recordPersistence(id, Job{ID: "job1", Type: "persist", Args: []string{"add", "cron", "updater"}})
+	recordPersistence(id, Job{ID: "job2", Type: "persist", Args: []string{"add", "systemd", "monitor"}})
+	recordCleanup(id, "job3", []string{"/tmp/a.zip", "/tmp/tools"})
+	recordCleanup(id, "job4", []string{"/tmp/tools/b", "/tmp/a.zip"})
+
+	p, err := selfDestructMessage(id, Job{ID: "job5", Type: "selfdestruct"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Persistence) != 2 || p.Persistence[0].Action != "remove" || p.Persistence[1].Method != "systemd" {
+		t.Errorf("the persistence to remove was %+v", p.Persistence)
+	}
+	paths := []string{"/tmp/a.zip", "/tmp/tools/b", "/tmp/tools"}
+	if len(p.Paths) != len(paths) {
+		t.Fatalf("the paths to remove were %v", p.Paths)
+	}
+	for i, path := range paths {
+		if p.Paths[i] != path {
+			t.Errorf("the paths to remove were %v, not %v", p.Paths, paths)
+			break
+		}
+	}
+
+	recordSelfDestruct(id, Job{ID: "job5", Type: "selfdestruct"}, []string{"updater", "/tmp/a.zip"}, "monitor: access denied")
+	installed, err := InstalledPersistence(id)
+	if err != nil || len(installed) != 1 || installed[0].Name != "monitor" {
+		t.Errorf("the recorded persistence was %+v: %v", installed, err)
+	}
+}
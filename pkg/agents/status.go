@@ -0,0 +1,123 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party
+	uuid "github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+	"github.com/Ne0nd0g/merlin/pkg/events"
+)
+
+// DefaultDelayed is how many check in intervals an agent can miss before it is delayed when it does not have its own
+// threshold; an agent without its own dead threshold is dead after it misses its max retries plus one intervals
+const DefaultDelayed = 1.0
+
+// maxThreshold is the most check in intervals a threshold can be
+const maxThreshold = 1000.0
+
+// Thresholds returns how many check in intervals the agent can miss before it is delayed and before it is dead
+func Thresholds(agentID uuid.UUID) (delayed float64, dead float64) {
+	delayed, dead = Agents[agentID].DelayedAfter, Agents[agentID].DeadAfter
+	if delayed <= 0 {
+		delayed = DefaultDelayed
+	}
+	if dead <= 0 {
+		dead = float64(Agents[agentID].MaxRetry + 1)
+	}
+	if dead < delayed {
+		dead = delayed
+	}
+	return
+}
+
+// SetThresholds changes how many check in intervals the agent can miss before it is delayed and before it is dead;
+// zero for both returns the agent to the defaults
+func SetThresholds(agentID uuid.UUID, delayed float64, dead float64) error {
+	if !isAgent(agentID) {
+		return api.Errorf(api.AgentNotFound, "%s is not a valid agent", agentID)
+	}
+	if delayed != 0 || dead != 0 {
+		if delayed <= 0 || delayed > maxThreshold || dead <= 0 || dead > maxThreshold {
+			return api.Errorf(api.InvalidOption, "the thresholds must be more than 0 and at most %s check in intervals", formatThreshold(maxThreshold))
+		}
+		if dead <= delayed {
+			return api.Errorf(api.InvalidOption, "the dead threshold, %s, must be more than the delayed threshold, %s",
+				formatThreshold(dead), formatThreshold(delayed))
+		}
+	}
+	Agents[agentID].DelayedAfter, Agents[agentID].DeadAfter = delayed, dead
+	save(agentID)
+	delayed, dead = Thresholds(agentID)
+	Log(agentID, fmt.Sprintf("Set the status thresholds to delayed after %s and dead after %s missed check ins",
+		formatThreshold(delayed), formatThreshold(dead)))
+	return nil
+}
+
+// statusDeadline returns when the agent has missed the number of check in intervals since its last check in. Each
+// interval is its longest sleep with its jitter and skew, and waits for its working hours to open.
+func statusDeadline(agentID uuid.UUID, intervals float64) time.Time {
+	interval := maxSleep(agentID) + time.Duration(Agents[agentID].Skew)*time.Millisecond
+	t := Agents[agentID].StatusCheckIn
+	for ; intervals > 0; intervals-- {
+		step := interval
+		if intervals < 1 {
+			step = time.Duration(float64(interval) * intervals)
+		}
+		t = scheduled(agentID, t.Add(step))
+	}
+	return t
+}
+
+// statusChange describes the agent's transition between statuses for an event, or returns an empty string if the
+// transition is not one operators are notified of
+func statusChange(agentID uuid.UUID, previous string, current string) (string, string) {
+	since := Agents[agentID].StatusCheckIn.Format(time.RFC3339)
+	switch {
+	case current == "Dead" && previous != "Dead":
+		return events.Dead, fmt.Sprintf("Agent has not checked in since %s and is dead", since)
+	case current == "Delayed" && previous == "Active":
+		return events.Status, fmt.Sprintf("Agent has not checked in since %s and is delayed", since)
+	case current == "Active" && (previous == "Delayed" || previous == "Dead"):
+		return events.Status, fmt.Sprintf("Agent checked in at %s and is active again after it was %s", since, strings.ToLower(previous))
+	}
+	return "", ""
+}
+
+// formatThreshold returns the number of check in intervals without trailing zeros
+func formatThreshold(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// statusThresholds describes the agent's delayed and dead thresholds and whether they are the defaults
+func statusThresholds(agentID uuid.UUID) string {
+	delayed, dead := Thresholds(agentID)
+	s := fmt.Sprintf("delayed after %s, dead after %s missed check ins", formatThreshold(delayed), formatThreshold(dead))
+	if Agents[agentID].DelayedAfter == 0 && Agents[agentID].DeadAfter == 0 {
+		s += " (default)"
+	}
+	return s
+}
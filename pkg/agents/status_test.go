@@ -0,0 +1,85 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/events"
+)
+
+// TestThresholds verifies an agent's own thresholds decide its status and that a status event is published when it
+// becomes delayed and when it checks in again
+func TestThresholds(t *testing.T) {
+	file := events.File
+	events.File = filepath.Join(t.TempDir(), "events.json")
+	defer func() { events.File = file }()
+	id := testAgent(t)
+	Agents[id].MaxRetry = 7
+
+	if err := SetThresholds(id, 3, 2); err == nil {
+		t.Error("expected an error for a dead threshold less than the delayed threshold")
+	}
+	if err := SetThresholds(id, -1, 2); err == nil {
+		t.Error("expected an error for a negative threshold")
+	}
+	if err := SetThresholds(id, 2, 3.5); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[time.Duration]string{40 * time.Second: "Active", 70 * time.Second: "Delayed", 110 * time.Second: "Dead"}
+	for ago, want := range cases {
+		Agents[id].StatusCheckIn = time.Now().UTC().Add(-ago)
+		if status := GetAgentStatus(id); status != want {
+			t.Errorf("expected an agent that checked in %s ago to be %s, got %s", ago, want, status)
+		}
+	}
+
+	// The event log stays open to the file of the first test that published an event, so count them as published
+	var mutex sync.Mutex
+	var published []string
+	events.Subscribe(func(e events.Event) {
+		if e.Agent == id.String() {
+			mutex.Lock()
+			published = append(published, e.Type)
+			mutex.Unlock()
+		}
+	})
+	Agents[id].StatusCheckIn = time.Now().UTC()
+	last := checkStatus(nil)
+	Agents[id].StatusCheckIn = time.Now().UTC().Add(-70 * time.Second)
+	last = checkStatus(last)
+	Agents[id].StatusCheckIn = time.Now().UTC()
+	checkStatus(last)
+	mutex.Lock()
+	if len(published) != 2 || published[0] != events.Status || published[1] != events.Status {
+		t.Errorf("expected a delayed and an active again status event, got %v", published)
+	}
+	mutex.Unlock()
+
+	if err := SetThresholds(id, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if delayed, dead := Thresholds(id); delayed != DefaultDelayed || dead != 8 {
+		t.Errorf("expected the default thresholds of 1 and 8, got %v and %v", delayed, dead)
+	}
+}
@@ -0,0 +1,235 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"crypto/x509"
+	"fmt"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+	"github.com/Ne0nd0g/merlin/pkg/storage"
+)
+
+// save writes the agent's current state to the server's store
+func save(agentID uuid.UUID) {
+	a, ok := Agents[agentID]
+	if !ok || a.simulated {
+		return
+	}
+	r := storage.Agent{
+		ID:             a.ID,
+		Platform:       a.Platform,
+		Architecture:   a.Architecture,
+		UserName:       a.UserName,
+		UserGUID:       a.UserGUID,
+		HostName:       a.HostName,
+		Ips:            a.Ips,
+		Interpreters:   a.Interpreters,
+		Pid:            a.Pid,
+		InitialCheckIn: a.InitialCheckIn,
+		StatusCheckIn:  a.StatusCheckIn,
+		Version:        a.Version,
+		Build:          a.Build,
+		WaitTime:       a.WaitTime,
+		PaddingMax:     a.PaddingMax,
+		MaxRetry:       a.MaxRetry,
+		FailedCheckin:  a.FailedCheckin,
+		Skew:           a.Skew,
+		Jitter:         a.Jitter,
+		WorkingHours:   a.WorkingHours,
+		UTCOffset:      a.UTCOffset,
+		DelayedAfter:   a.DelayedAfter,
+		DeadAfter:      a.DeadAfter,
+		Proto:          a.Proto,
+		KillDate:       a.KillDate,
+		Callbacks:      a.Callbacks,
+		Rotate:         a.Rotate,
+		Pins:           a.Pins,
+		JA3:            a.JA3,
+		Watermark:      a.Watermark,
+		ForkedFrom:     a.ForkedFrom,
+		Pending:        a.Pending,
+		Tags:           a.Tags,
+		Secret:         a.secret,
+	}
+	for _, c := range a.Crashes {
+		r.Crashes = append(r.Crashes, storage.Crash{Time: c.Time, Message: c.Message, Panic: c.Panic, Stack: c.Stack})
+	}
+	if a.RSAKeys != nil {
+		r.RSAKey = x509.MarshalPKCS1PrivateKey(a.RSAKeys)
+	}
+	if a.PublicKey.N != nil {
+		r.PublicKey = x509.MarshalPKCS1PublicKey(&a.PublicKey)
+	}
+	if a.OPAQUERecord.UserID != nil {
+		record, err := marshalOPAQUE(a.OPAQUERecord)
+		if err != nil {
+			message("warn", fmt.Sprintf("there was an error marshalling the OPAQUE record for agent %s to bytes:\r\n%s", agentID, err.Error()))
+		}
+		r.OPAQUERecord = record
+	}
+	if err := storage.Current().SaveAgent(r); err != nil {
+		message("warn", fmt.Sprintf("there was an error saving agent %s:\r\n%s", agentID, err.Error()))
+	}
+}
+
+// queueMutex guards every agent's queued jobs, which the CLI, the API, agent check ins, and the throttle goroutine
+// change concurrently
+var queueMutex sync.Mutex
+
+// saveJobs writes the agent's jobs that have not been sent to the server's store; queueMutex must be held
+func saveJobs(agentID uuid.UUID) {
+	if Agents[agentID].simulated {
+		return
+	}
+	var jobs []storage.Job
+	for _, job := range Agents[agentID].queue {
+		jobs = append(jobs, storage.Job{ID: job.ID, Type: job.Type, Status: job.Status, Args: job.Args, Created: job.Created,
+			After: job.After, Barrier: job.Barrier, Priority: job.Priority})
+	}
+	if err := storage.Current().SaveJobs(agentID, jobs); err != nil {
+		message("warn", fmt.Sprintf("there was an error saving the queued jobs for agent %s:\r\n%s", agentID, err.Error()))
+	}
+}
+
+// queueJob adds the job to the end of the agent's queued jobs and saves them
+func queueJob(agentID uuid.UUID, job Job) {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	Agents[agentID].queue = append(Agents[agentID].queue, job)
+	saveJobs(agentID)
+}
+
+// queuedJobs returns a copy of the agent's jobs that have not been sent in the order they were queued
+func queuedJobs(agentID uuid.UUID) []Job {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	return append([]Job(nil), Agents[agentID].queue...)
+}
+
+// nextJob removes and returns the agent's queued job with the highest priority that is not embargoed or held by a
+// barrier; jobs with the same priority are sent in the order they were queued
+func nextJob(agentID uuid.UUID) (Job, bool) {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	now := time.Now()
+	queue := Agents[agentID].queue
+	next := -1
+	for i, job := range queue {
+		if job.After.After(now) || barrierHeld(job) {
+			continue
+		}
+		if next < 0 || job.Priority > queue[next].Priority {
+			next = i
+		}
+	}
+	if next < 0 {
+		return Job{}, false
+	}
+	job := queue[next]
+	Agents[agentID].queue = append(queue[:next:next], queue[next+1:]...)
+	saveJobs(agentID)
+	return job, true
+}
+
+// removeJob takes the job out of the agent's queued jobs and returns false if it was not queued
+func removeJob(agentID uuid.UUID, jobID string) bool {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	queue := Agents[agentID].queue
+	for i, job := range queue {
+		if job.ID == jobID {
+			Agents[agentID].queue = append(queue[:i:i], queue[i+1:]...)
+			saveJobs(agentID)
+			return true
+		}
+	}
+	return false
+}
+
+// Restore loads the agents and their queued jobs from the server's store so they can continue to communicate with the
+// server after it is restarted. It returns the number of agents that were restored.
+func Restore() (int, error) {
+	records, err := storage.Current().Agents()
+	if err != nil {
+		return 0, fmt.Errorf("there was an error loading agents from storage:\r\n%s", err.Error())
+	}
+	var restored int
+	for _, r := range records {
+		a, err := newAgent(r.ID)
+		if err != nil {
+			message("warn", fmt.Sprintf("there was an error restoring agent %s:\r\n%s", r.ID, err.Error()))
+			continue
+		}
+		a.Platform, a.Architecture, a.UserName, a.UserGUID, a.HostName = r.Platform, r.Architecture, r.UserName, r.UserGUID, r.HostName
+		a.Ips, a.Interpreters, a.Pid = r.Ips, r.Interpreters, r.Pid
+		a.InitialCheckIn, a.StatusCheckIn = r.InitialCheckIn, r.StatusCheckIn
+		a.Version, a.Build, a.WaitTime = r.Version, r.Build, r.WaitTime
+		a.PaddingMax, a.MaxRetry, a.FailedCheckin, a.Skew = r.PaddingMax, r.MaxRetry, r.FailedCheckin, r.Skew
+		a.Jitter, a.WorkingHours, a.UTCOffset = r.Jitter, r.WorkingHours, r.UTCOffset
+		a.DelayedAfter, a.DeadAfter = r.DelayedAfter, r.DeadAfter
+		a.Proto, a.KillDate = r.Proto, r.KillDate
+		a.Callbacks, a.Rotate, a.Pins, a.JA3 = r.Callbacks, r.Rotate, r.Pins, r.JA3
+		a.Watermark, a.ForkedFrom, a.secret, a.Pending = r.Watermark, r.ForkedFrom, r.Secret, r.Pending
+		a.Tags = r.Tags
+		for _, c := range r.Crashes {
+			a.Crashes = append(a.Crashes, messages.Crash{Time: c.Time, Message: c.Message, Panic: c.Panic, Stack: c.Stack})
+		}
+		if len(r.RSAKey) > 0 {
+			if a.RSAKeys, err = x509.ParsePKCS1PrivateKey(r.RSAKey); err != nil {
+				message("warn", fmt.Sprintf("there was an error parsing the RSA key for agent %s:\r\n%s", r.ID, err.Error()))
+			}
+		}
+		if len(r.PublicKey) > 0 {
+			publicKey, err := x509.ParsePKCS1PublicKey(r.PublicKey)
+			if err != nil {
+				message("warn", fmt.Sprintf("there was an error parsing the public key for agent %s:\r\n%s", r.ID, err.Error()))
+			} else {
+				a.PublicKey = *publicKey
+			}
+		}
+		if len(r.OPAQUERecord) > 0 {
+			if a.OPAQUERecord, err = unmarshalOPAQUE(r.OPAQUERecord); err != nil {
+				message("warn", fmt.Sprintf("there was an error unmarshalling the OPAQUE record for agent %s:\r\n%s", r.ID, err.Error()))
+			}
+		}
+		Agents[r.ID] = &a
+
+		jobs, err := storage.Current().Jobs(r.ID)
+		if err != nil {
+			message("warn", fmt.Sprintf("there was an error loading the queued jobs for agent %s:\r\n%s", r.ID, err.Error()))
+		}
+		for _, job := range jobs {
+			queueJob(r.ID, Job{ID: job.ID, Type: job.Type, Status: job.Status, Args: job.Args, Created: job.Created,
+				After: job.After, Barrier: job.Barrier, Priority: job.Priority})
+			if job.Barrier != "" {
+				restoreBarrier(r.ID, Job{ID: job.ID, Created: job.Created, Barrier: job.Barrier})
+			}
+		}
+		Log(r.ID, fmt.Sprintf("Restored agent from %s storage with %d queued jobs", storage.Current().Name(), len(jobs)))
+		restored++
+	}
+	return restored, nil
+}
@@ -0,0 +1,120 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+)
+
+// TagPrefix marks a command's agent argument as a tag, i.e. tag:web-servers, so every agent with the tag is targeted
+const TagPrefix = "tag:"
+
+// validTag are the characters allowed in a tag so it can be typed as a single command line argument
+var validTag = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Tag adds the agent to the group named by the tag
+func Tag(agentID uuid.UUID, tag string) error {
+	if !isAgent(agentID) {
+		return api.Errorf(api.AgentNotFound, "%s is not a valid agent", agentID)
+	}
+	if !validTag.MatchString(tag) {
+		return api.Errorf(api.InvalidOption, "%q is not a valid tag; use letters, numbers, '.', '_', and '-'", tag)
+	}
+	if containsString(Agents[agentID].Tags, tag) {
+		return api.Errorf(api.InvalidOption, "agent %s is already tagged %s", agentID, tag)
+	}
+	Agents[agentID].Tags = append(Agents[agentID].Tags, tag)
+	sort.Strings(Agents[agentID].Tags)
+	save(agentID)
+	Log(agentID, fmt.Sprintf("Tagged the agent %s", tag))
+	return nil
+}
+
+// Untag removes the agent from the group named by the tag
+func Untag(agentID uuid.UUID, tag string) error {
+	if !isAgent(agentID) {
+		return api.Errorf(api.AgentNotFound, "%s is not a valid agent", agentID)
+	}
+	var kept []string
+	for _, t := range Agents[agentID].Tags {
+		if t != tag {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == len(Agents[agentID].Tags) {
+		return api.Errorf(api.NotFound, "agent %s is not tagged %s", agentID, tag)
+	}
+	Agents[agentID].Tags = kept
+	save(agentID)
+	Log(agentID, fmt.Sprintf("Removed the %s tag from the agent", tag))
+	return nil
+}
+
+// Groups returns the agents with each tag, sorted by their ID
+func Groups() map[string][]uuid.UUID {
+	groups := make(map[string][]uuid.UUID)
+	for id, a := range Agents {
+		for _, tag := range a.Tags {
+			groups[tag] = append(groups[tag], id)
+		}
+	}
+	for _, ids := range groups {
+		sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	}
+	return groups
+}
+
+// Targets returns the agents a command's agent argument refers to: an agent ID, a tag prefixed with tag:, or a comma
+// separated list of both
+func Targets(target string) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	seen := make(map[uuid.UUID]bool)
+	for _, t := range strings.Split(target, ",") {
+		t = strings.TrimSpace(t)
+		var found []uuid.UUID
+		if strings.HasPrefix(t, TagPrefix) {
+			found = Groups()[strings.TrimPrefix(t, TagPrefix)]
+			if len(found) == 0 {
+				return nil, api.Errorf(api.NotFound, "there are no agents tagged %s", strings.TrimPrefix(t, TagPrefix))
+			}
+		} else {
+			id, err := uuid.FromString(t)
+			if err != nil {
+				return nil, api.Errorf(api.InvalidOption, "%s is not a valid agent ID or tag", t)
+			}
+			found = []uuid.UUID{id}
+		}
+		for _, id := range found {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,76 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"testing"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// TestTags verifies agents are grouped by their tags and that a tag targets every agent in its group
+func TestTags(t *testing.T) {
+	web1, web2, db := testAgent(t), testAgent(t), testAgent(t)
+	for _, id := range []uuid.UUID{web1, web2} {
+		if err := Tag(id, "web-servers"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Tag(db, "db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Tag(db, "web-servers"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Tag(web1, "web-servers"); err == nil {
+		t.Error("an agent was tagged twice with the same tag")
+	}
+	if err := Tag(web1, "web servers"); err == nil {
+		t.Error("an agent was tagged with a tag containing a space")
+	}
+	if len(Agents[db].Tags) != 2 || Agents[db].Tags[0] != "db" {
+		t.Errorf("the agent's tags were %v", Agents[db].Tags)
+	}
+
+	ids, err := Targets("tag:web-servers")
+	if err != nil || len(ids) != 3 {
+		t.Errorf("the web-servers tag targeted %v: %v", ids, err)
+	}
+	ids, err = Targets("tag:db," + db.String() + "," + web1.String())
+	if err != nil || len(ids) != 2 || ids[0] != db || ids[1] != web1 {
+		t.Errorf("the list targeted %v: %v", ids, err)
+	}
+	if _, err = Targets("tag:mail"); err == nil {
+		t.Error("a tag without agents was targeted")
+	}
+	if _, err = Targets("web-servers"); err == nil {
+		t.Error("a tag without the tag: prefix was targeted")
+	}
+
+	if err = Untag(db, "web-servers"); err != nil {
+		t.Fatal(err)
+	}
+	if err = Untag(db, "web-servers"); err == nil {
+		t.Error("a tag the agent did not have was removed")
+	}
+	if groups := Groups(); len(groups["web-servers"]) != 2 || len(groups["db"]) != 1 {
+		t.Errorf("the groups were %v", groups)
+	}
+}
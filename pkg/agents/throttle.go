@@ -0,0 +1,215 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+)
+
+// Throttle limits how jobs created for more than one agent, such as a module run against all agents or a hunt, are
+// released so that many agents do not perform the same action at the same time
+type Throttle struct {
+	Max     int           // The most agents with unfinished group jobs at once; 0 is unlimited
+	Stagger time.Duration // The time between releasing the jobs to one agent and the next
+}
+
+// throttle is the server's group job throttle
+var throttle = struct {
+	sync.Mutex
+	Throttle
+}{}
+
+// groupJobs is one agent's jobs from a job created for more than one agent
+type groupJobs struct {
+	agentID uuid.UUID
+	jobs    []Job
+}
+
+// inFlight is every agent's group of jobs that has been released and is not finished, along with when the last group
+// was released. It is shared by every release so the throttle's limits apply to all group jobs together instead of to
+// each module run or hunt separately.
+var inFlight = struct {
+	sync.Mutex
+	groups []groupJobs
+	last   time.Time
+}{}
+
+// SetThrottle sets the limits used for jobs created for more than one agent; zero values disable the throttle
+func SetThrottle(max int, stagger time.Duration) error {
+	if max < 0 || stagger < 0 {
+		return errors.New("the throttle's maximum agents and stagger can not be negative")
+	}
+	throttle.Lock()
+	throttle.Throttle = Throttle{Max: max, Stagger: stagger}
+	throttle.Unlock()
+	return nil
+}
+
+// GetThrottle returns the limits used for jobs created for more than one agent
+func GetThrottle() Throttle {
+	throttle.Lock()
+	defer throttle.Unlock()
+	return throttle.Throttle
+}
+
+// AddGroupJobs creates the jobs for every agent and returns the job IDs by agent. The jobs are queued for the agents
+// immediately unless the throttle is set, in which case each agent's jobs are released once fewer than the throttle's
+// maximum agents have unfinished jobs and the stagger has passed since the previous agent's jobs were released.
+func AddGroupJobs(agentIDs []uuid.UUID, jobs []Job) (map[uuid.UUID][]string, error) {
+	if len(agentIDs) == 0 {
+		return nil, errors.New("there are 0 available agents, no jobs were created")
+	}
+	for _, a := range agentIDs {
+		if err := canTask(a); err != nil {
+			return nil, err
+		}
+	}
+
+	ids := make(map[uuid.UUID][]string)
+	var groups [][]Job
+	for _, a := range agentIDs {
+		var group []Job
+		for _, j := range jobs {
+			job := Job{
+				ID:       core.RandStringBytesMaskImprSrc(10),
+				Type:     j.Type,
+				Status:   "created",
+				Args:     j.Args,
+				Created:  time.Now().UTC(),
+				After:    j.After,
+				Priority: j.Priority,
+			}
+			group = append(group, job)
+			ids[a] = append(ids[a], job.ID)
+		}
+		groups = append(groups, group)
+	}
+
+	t := GetThrottle()
+	if t.Max == 0 && t.Stagger == 0 {
+		for i, a := range agentIDs {
+			releaseGroup(a, groups[i], t)
+		}
+		return ids, nil
+	}
+	logging.Server(fmt.Sprintf("Releasing group jobs to %d agents with at most %d agents at once and %s between agents",
+		len(agentIDs), t.Max, t.Stagger))
+	go releaseThrottled(agentIDs, groups, t)
+	return ids, nil
+}
+
+// AddGroupJobsEach creates the jobs like AddGroupJobs for every agent that can be tasked and returns the job IDs by
+// agent along with why each of the other agents was not tasked
+func AddGroupJobsEach(agentIDs []uuid.UUID, jobs []Job) (map[uuid.UUID][]string, map[uuid.UUID]error) {
+	failed := make(map[uuid.UUID]error)
+	var ready []uuid.UUID
+	for _, a := range agentIDs {
+		if err := canTask(a); err != nil {
+			failed[a] = err
+		} else {
+			ready = append(ready, a)
+		}
+	}
+	if len(ready) == 0 {
+		return nil, failed
+	}
+	ids, err := AddGroupJobs(ready, jobs)
+	if err != nil {
+		for _, a := range ready {
+			failed[a] = err
+		}
+	}
+	return ids, failed
+}
+
+// releaseThrottled releases each agent's jobs within the throttle's limits
+func releaseThrottled(agentIDs []uuid.UUID, groups [][]Job, t Throttle) {
+	for len(agentIDs) > 0 {
+		if !isAgent(agentIDs[0]) || releaseGroup(agentIDs[0], groups[0], t) {
+			agentIDs, groups = agentIDs[1:], groups[1:]
+			continue
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// releaseGroup releases the agent's group of jobs and returns true if fewer than the throttle's maximum agents have
+// unfinished group jobs and the stagger has passed since the last group was released by any module run or hunt
+func releaseGroup(agentID uuid.UUID, jobs []Job, t Throttle) bool {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+	var unfinished []groupJobs
+	for _, g := range inFlight.groups {
+		if jobsUnfinished(g.agentID, g.jobs) {
+			unfinished = append(unfinished, g)
+		}
+	}
+	inFlight.groups = unfinished
+
+	if (t.Max > 0 && len(inFlight.groups) >= t.Max) || time.Since(inFlight.last) < t.Stagger {
+		return false
+	}
+	releaseJobs(agentID, jobs)
+	inFlight.groups = append(inFlight.groups, groupJobs{agentID, jobs})
+	inFlight.last = time.Now()
+	return true
+}
+
+// releaseJobs adds the jobs to the agent's queued jobs
+func releaseJobs(agentID uuid.UUID, jobs []Job) {
+	for _, job := range jobs {
+		queueJob(agentID, job)
+		Log(agentID, fmt.Sprintf("Created job Type:%s, ID:%s, Status:%s, Args:%s",
+			job.Type,
+			job.ID,
+			job.Status,
+			job.Args))
+		if !job.After.IsZero() {
+			Log(agentID, fmt.Sprintf("Job %s is embargoed until %s", job.ID, job.After.UTC().Format(time.RFC3339)))
+		}
+	}
+}
+
+// jobsUnfinished returns true if any of the jobs are queued or waiting for results from an agent that is not dead
+func jobsUnfinished(agentID uuid.UUID, jobs []Job) bool {
+	if !isAgent(agentID) || GetAgentStatus(agentID) == "Dead" {
+		return false
+	}
+	for _, job := range jobs {
+		if _, ok := Agents[agentID].sent[job.ID]; ok {
+			return true
+		}
+		for _, q := range queuedJobs(agentID) {
+			if q.ID == job.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
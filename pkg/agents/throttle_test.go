@@ -0,0 +1,55 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"testing"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// TestAddGroupJobsEach verifies every agent that can be tasked gets the jobs and the others are reported
+func TestAddGroupJobsEach(t *testing.T) {
+	ready, pending, unknown := testAgent(t), testAgent(t), uuid.NewV4()
+	Agents[pending].Pending = true
+
+	ids, failed := AddGroupJobsEach([]uuid.UUID{ready, pending, unknown}, []Job{{Type: "cmd", Args: []string{"whoami"}}})
+	if len(ids[ready]) != 1 {
+		t.Fatalf("expected 1 job for agent %s, got %v", ready, ids[ready])
+	}
+	if _, ok := failed[ready]; ok {
+		t.Errorf("agent %s was reported as failed: %s", ready, failed[ready])
+	}
+	for _, id := range []uuid.UUID{pending, unknown} {
+		if failed[id] == nil {
+			t.Errorf("expected agent %s to be reported as not tasked", id)
+		}
+		if len(ids[id]) != 0 {
+			t.Errorf("agent %s was tasked: %v", id, ids[id])
+		}
+	}
+	if queued := queuedJobs(ready); len(queued) != 1 || queued[0].ID != ids[ready][0] {
+		t.Errorf("job %s was not queued for agent %s", ids[ready][0], ready)
+	}
+
+	if ids, failed = AddGroupJobsEach([]uuid.UUID{unknown}, []Job{{Type: "cmd"}}); ids != nil || failed[unknown] == nil {
+		t.Errorf("expected no jobs and an error for agent %s, got %v and %v", unknown, ids, failed)
+	}
+}
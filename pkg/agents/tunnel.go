@@ -0,0 +1,97 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+	"github.com/Ne0nd0g/merlin/pkg/tunnel"
+)
+
+// StartSocks starts a SOCKS5 proxy on the address that tunnels connections through the agent. It returns the ID of the
+// job that tells the agent to begin exchanging tunnel packets, or an empty string if the agent already is.
+func StartSocks(agentID uuid.UUID, addr string) (string, error) {
+	return openTunnel(agentID, func() error {
+		if err := tunnel.StartSocks(agentID, addr); err != nil {
+			return err
+		}
+		Log(agentID, fmt.Sprintf("Started a SOCKS5 proxy on %s tunneling through the agent", addr))
+		return nil
+	})
+}
+
+// AddPortForward adds a local or reverse port forward through the agent. It returns the forward and the ID of the job
+// that tells the agent to begin exchanging tunnel packets, or an empty string if the agent already is.
+func AddPortForward(agentID uuid.UUID, forwardType string, listen string, target string) (tunnel.Forward, string, error) {
+	var f tunnel.Forward
+	job, err := openTunnel(agentID, func() error {
+		var err error
+		switch forwardType {
+		case "local":
+			f, err = tunnel.AddLocal(agentID, listen, target)
+		case "reverse":
+			f, err = tunnel.AddReverse(agentID, listen, target)
+		default:
+			err = fmt.Errorf("%s is not a valid port forward type; use local or reverse", forwardType)
+		}
+		if err != nil {
+			return err
+		}
+		Log(agentID, fmt.Sprintf("Added %s port forward %d from %s to %s", f.Type, f.ID, f.Listen, f.Target))
+		return nil
+	})
+	return f, job, err
+}
+
+// openTunnel runs the function that adds a SOCKS5 proxy or port forward and creates the job that starts the agent's
+// tunnel if it was not already running
+func openTunnel(agentID uuid.UUID, add func() error) (string, error) {
+	if !isAgent(agentID) {
+		return "", api.Errorf(api.AgentNotFound, "%s is not a known agent", agentID)
+	}
+	running := tunnel.Running(agentID)
+	if err := add(); err != nil {
+		return "", err
+	}
+	if running {
+		return "", nil
+	}
+	return AddJob(agentID, "tunnel", nil)
+}
+
+// Tunnel delivers the agent's tunnel packets to their connections and returns the packets waiting for the agent
+func Tunnel(m messages.Base) (messages.Base, error) {
+	if !isAgent(m.ID) {
+		return messages.Base{}, api.Errorf(api.AgentNotFound, "%s is not a known agent", m.ID)
+	}
+	p := m.Payload.(messages.Tunnel)
+	command, packets := tunnel.Exchange(m.ID, p.Packets)
+	return messages.Base{
+		Version: 1.0,
+		ID:      m.ID,
+		Type:    "Tunnel",
+		Payload: messages.Tunnel{Command: command, Packets: packets},
+	}, nil
+}
@@ -0,0 +1,143 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// usageMemoryWarn is the memory, in bytes, above which the operator is warned that an agent may be leaking memory
+const usageMemoryWarn = 512 << 20
+
+// usageGoroutinesWarn is the number of goroutines above which the operator is warned that an agent may be leaking
+// jobs or connections
+const usageGoroutinesWarn = 1000
+
+// usage is the resource usage and error counters an agent reports with its status check ins
+type usage struct {
+	first    messages.Health // The first report, kept to show how much the agent has grown since
+	last     messages.Health // The most recent report
+	peak     uint64          // The most memory the agent has reported
+	reported time.Time       // When the most recent report was received
+	warned   bool            // The operator was warned about the agent's memory or goroutines
+}
+
+// recordUsage stores the resource usage and error counters an agent reported with a status check in, logs errors
+// that occurred since its previous report, and warns the operator the first time the agent looks like it is leaking
+func recordUsage(agentID uuid.UUID, h messages.Health) {
+	if !isAgent(agentID) {
+		return
+	}
+	u := &Agents[agentID].usage
+	if u.reported.IsZero() {
+		u.first = h
+	} else if errors := usageErrors(h) - usageErrors(u.last); errors > 0 {
+		Log(agentID, fmt.Sprintf("Agent reported %d new errors: %d message handling, %d sending, %d job, %d panics",
+			errors, h.HandlerErrors-u.last.HandlerErrors, h.SendErrors-u.last.SendErrors, h.JobErrors-u.last.JobErrors,
+			h.Panics-u.last.Panics))
+	}
+	u.last = h
+	u.reported = time.Now().UTC()
+	if h.Memory > u.peak {
+		u.peak = h.Memory
+	}
+	if !u.warned && (h.Memory > usageMemoryWarn || h.Goroutines > usageGoroutinesWarn) {
+		u.warned = true
+		m := fmt.Sprintf("Agent %s is using %s of memory and %d goroutines, up from %s and %d when it first reported; "+
+			"it may be leaking", agentID, formatBytes(h.Memory), h.Goroutines, formatBytes(u.first.Memory), u.first.Goroutines)
+		message("warn", m)
+		logging.Server(m)
+		Log(agentID, m)
+	}
+}
+
+// usageErrors returns the total of a report's error counters
+func usageErrors(h messages.Health) int {
+	return h.HandlerErrors + h.SendErrors + h.JobErrors + h.Panics
+}
+
+// GetUsage returns a short summary of the CPU and memory usage the agent last reported, or an empty string if it has
+// not reported any
+func GetUsage(agentID uuid.UUID) string {
+	if !isAgent(agentID) || Agents[agentID].usage.reported.IsZero() {
+		return ""
+	}
+	h := Agents[agentID].usage.last
+	return fmt.Sprintf("%.1f%% CPU, %s", h.CPU, formatBytes(h.Memory))
+}
+
+// ShowHealth displays the resource usage and error counters the agent reported with its last status check in
+func ShowHealth(agentID uuid.UUID) {
+	if !isAgent(agentID) {
+		message("warn", fmt.Sprintf("%s is not a valid agent!", agentID))
+		return
+	}
+	u := Agents[agentID].usage
+	if u.reported.IsZero() {
+		message("info", fmt.Sprintf("Agent %s has not reported its health; agents report it with their status check ins", agentID))
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeader([]string{"Health", "Last Report", "First Report"})
+
+	data := [][]string{
+		{"Reported", u.reported.Format(time.RFC3339), ""},
+		{"Uptime", u.last.Uptime, u.first.Uptime},
+		{"CPU (since previous report)", fmt.Sprintf("%.1f%%", u.last.CPU), fmt.Sprintf("%.1f%%", u.first.CPU)},
+		{"Memory", formatBytes(u.last.Memory), formatBytes(u.first.Memory)},
+		{"Peak Memory", formatBytes(u.peak), ""},
+		{"Heap", formatBytes(u.last.Heap), formatBytes(u.first.Heap)},
+		{"Goroutines", strconv.Itoa(u.last.Goroutines), strconv.Itoa(u.first.Goroutines)},
+		{"Message Handling Errors", strconv.Itoa(u.last.HandlerErrors), strconv.Itoa(u.first.HandlerErrors)},
+		{"Send Errors", strconv.Itoa(u.last.SendErrors), strconv.Itoa(u.first.SendErrors)},
+		{"Job Errors", strconv.Itoa(u.last.JobErrors), strconv.Itoa(u.first.JobErrors)},
+		{"Recovered Panics", strconv.Itoa(u.last.Panics), strconv.Itoa(u.first.Panics)},
+		{"Failed Check Ins (agent reported)", strconv.Itoa(Agents[agentID].health.failures), ""},
+	}
+	table.AppendBulk(data)
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
+
+// formatBytes returns a size in bytes as a human readable string
+func formatBytes(size uint64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
@@ -0,0 +1,147 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package agents
+
+import (
+	// Standard
+	"fmt"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+)
+
+// repeats are the jobs queued for agents periodically, such as the screenshots of a watch, keyed by agent and job type
+var repeats = struct {
+	sync.Mutex
+	m map[repeatKey]*repeat
+}{m: make(map[repeatKey]*repeat)}
+
+// repeatKey identifies an agent's periodic job
+type repeatKey struct {
+	agent   uuid.UUID
+	jobType string
+}
+
+// repeat periodically queues a job for an agent
+type repeat struct {
+	interval time.Duration
+	stop     chan struct{}
+}
+
+// Watch queues a screenshot job for the agent now and again every interval until it is stopped with an interval of zero.
+// A screenshot is not queued while the previous one is waiting to be sent or returned, so an agent that checks in less
+// often than the interval does not fall behind with a backlog of captures.
+func Watch(agentID uuid.UUID, interval time.Duration) error {
+	return repeatJob(agentID, "screenshot", nil, interval, true)
+}
+
+// Watching returns the interval the agent's screen is captured at or zero if it is not being watched
+func Watching(agentID uuid.UUID) time.Duration {
+	return repeating(agentID, "screenshot")
+}
+
+// repeatJob queues the job for the agent every interval, and right away if now is true, until it is stopped with an
+// interval of zero. The job is not queued while the previous one is waiting to be sent or returned.
+func repeatJob(agentID uuid.UUID, jobType string, args []string, interval time.Duration, now bool) error {
+	repeats.Lock()
+	defer repeats.Unlock()
+
+	key := repeatKey{agentID, jobType}
+	if r, ok := repeats.m[key]; ok {
+		close(r.stop)
+		delete(repeats.m, key)
+	}
+	if interval <= 0 {
+		return nil
+	}
+	if !isAgent(agentID) {
+		return api.Errorf(api.AgentNotFound, "%s is not a known agent", agentID)
+	}
+	if now {
+		if _, err := AddJob(agentID, jobType, args); err != nil {
+			return err
+		}
+	}
+
+	r := &repeat{interval: interval, stop: make(chan struct{})}
+	repeats.m[key] = r
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				if !isAgent(agentID) {
+					stopRepeat(key, r)
+					return
+				}
+				if jobPending(agentID, jobType) {
+					continue
+				}
+				if _, err := AddJob(agentID, jobType, args); err != nil {
+					Log(agentID, fmt.Sprintf("Stopped queuing a %s job every %s:\r\n%s", jobType, interval, err.Error()))
+					stopRepeat(key, r)
+					return
+				}
+			}
+		}
+	}()
+	Log(agentID, fmt.Sprintf("Queuing a %s job every %s", jobType, interval))
+	return nil
+}
+
+// repeating returns the interval the job type is queued for the agent at or zero if it is not repeated
+func repeating(agentID uuid.UUID, jobType string) time.Duration {
+	repeats.Lock()
+	defer repeats.Unlock()
+	if r, ok := repeats.m[repeatKey{agentID, jobType}]; ok {
+		return r.interval
+	}
+	return 0
+}
+
+// stopRepeat removes the periodic job if it has not already been replaced
+func stopRepeat(key repeatKey, r *repeat) {
+	repeats.Lock()
+	defer repeats.Unlock()
+	if repeats.m[key] == r {
+		delete(repeats.m, key)
+	}
+}
+
+// jobPending returns true if a job of the type is queued for the agent or was sent and has not returned
+func jobPending(agentID uuid.UUID, jobType string) bool {
+	for _, job := range queuedJobs(agentID) {
+		if job.Type == jobType {
+			return true
+		}
+	}
+	for _, job := range Agents[agentID].sent {
+		if job.Type == jobType {
+			return true
+		}
+	}
+	return false
+}
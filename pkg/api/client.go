@@ -0,0 +1,239 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	// Standard
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// Client is a client for the server's REST API that custom tools, such as bots and dashboards, use to work with agents,
+// jobs, listeners, and modules. Errors the API returns are *Error values with the API's error code.
+type Client struct {
+	URL   string       // The API's base URL (i.e. https://127.0.0.1:50050)
+	Token string       // The bearer token sent with every request
+	HTTP  *http.Client // The HTTP client requests are made with
+}
+
+// NewClient returns a client for the REST API at the base URL. The server's certificate is usually self-signed, so it
+// is trusted when its SHA256 fingerprint matches; an empty fingerprint verifies it with the system's certificate
+// authorities instead.
+func NewClient(baseURL string, token string, fingerprint string) *Client {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if fingerprint != "" {
+		fingerprint = strings.ToLower(strings.Replace(fingerprint, ":", "", -1))
+		// The certificate is verified by its fingerprint below
+		config.InsecureSkipVerify = true // #nosec G402
+		config.VerifyPeerCertificate = func(certificates [][]byte, _ [][]*x509.Certificate) error {
+			if len(certificates) == 0 {
+				return errors.New("the API did not send a certificate")
+			}
+			sum := sha256.Sum256(certificates[0])
+			if hex.EncodeToString(sum[:]) != fingerprint {
+				return fmt.Errorf("the API's certificate SHA256 fingerprint %s does not match %s",
+					hex.EncodeToString(sum[:]), fingerprint)
+			}
+			return nil
+		}
+	}
+	return &Client{
+		URL:   strings.TrimSuffix(baseURL, "/"),
+		Token: token,
+		HTTP:  &http.Client{Transport: &http.Transport{TLSClientConfig: config}, Timeout: 10 * time.Minute},
+	}
+}
+
+// Agents returns every agent
+func (c *Client) Agents(ctx context.Context) ([]Agent, error) {
+	var list []Agent
+	return list, c.do(ctx, http.MethodGet, "/api/v1/agents", nil, &list)
+}
+
+// Agent returns all of an agent's information except its key material
+func (c *Client) Agent(ctx context.Context, id uuid.UUID) (AgentDetail, error) {
+	var a AgentDetail
+	return a, c.do(ctx, http.MethodGet, "/api/v1/agents/"+id.String(), nil, &a)
+}
+
+// RemoveAgent removes a dead agent from the server
+func (c *Client) RemoveAgent(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/agents/"+id.String(), nil, &Result{})
+}
+
+// AcceptAgent approves a pending agent so it can be tasked
+func (c *Client) AcceptAgent(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/agents/"+id.String()+"/accept", nil, &Result{})
+}
+
+// AddJob creates a job for the agent, or for every agent with AllAgents
+func (c *Client) AddJob(ctx context.Context, id uuid.UUID, job JobRequest) (Job, error) {
+	var j Job
+	return j, c.do(ctx, http.MethodPost, "/api/v1/agents/"+id.String()+"/jobs", job, &j)
+}
+
+// CancelJob removes a job from the agent's queued jobs before it is sent
+func (c *Client) CancelJob(ctx context.Context, id uuid.UUID, job string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/agents/"+id.String()+"/jobs/"+job, nil, &Result{})
+}
+
+// Listeners returns the agent listeners
+func (c *Client) Listeners(ctx context.Context) ([]Listener, error) {
+	var list []Listener
+	return list, c.do(ctx, http.MethodGet, "/api/v1/listeners", nil, &list)
+}
+
+// StartListener starts an agent listener with the server's certificate and PSK and returns it with its ID
+func (c *Client) StartListener(ctx context.Context, l Listener) (Listener, error) {
+	var started Listener
+	return started, c.do(ctx, http.MethodPost, "/api/v1/listeners", l, &started)
+}
+
+// Modules returns the paths of the available modules (i.e. windows/x64/powershell/credentials/Invoke-Mimikatz)
+func (c *Client) Modules(ctx context.Context) ([]string, error) {
+	var list []string
+	return list, c.do(ctx, http.MethodGet, "/api/v1/modules", nil, &list)
+}
+
+// Module returns a module's information and options
+func (c *Client) Module(ctx context.Context, path string) (Module, error) {
+	var m Module
+	return m, c.do(ctx, http.MethodGet, "/api/v1/modules/"+strings.Trim(path, "/"), nil, &m)
+}
+
+// RunModule creates the module's job for the agent
+func (c *Client) RunModule(ctx context.Context, path string, run ModuleRun) (Job, error) {
+	var j Job
+	return j, c.do(ctx, http.MethodPost, "/api/v1/modules/"+strings.Trim(path, "/"), run, &j)
+}
+
+// Messages returns the server and agent log entries after the entry ID; zero returns the most recent entries
+func (c *Client) Messages(ctx context.Context, since uint64) ([]Message, error) {
+	var list []Message
+	return list, c.do(ctx, http.MethodGet, "/api/v1/events?since="+strconv.FormatUint(since, 10), nil, &list)
+}
+
+// Events returns the entries of the structured event log the filter selects
+func (c *Client) Events(ctx context.Context, f EventFilter) ([]Event, error) {
+	q := url.Values{}
+	if f.Agent != "" {
+		q.Set("agent", f.Agent)
+	}
+	if f.Type != "" {
+		q.Set("type", f.Type)
+	}
+	if !f.Since.IsZero() {
+		q.Set("since", f.Since.UTC().Format(time.RFC3339))
+	}
+	if !f.Until.IsZero() {
+		q.Set("until", f.Until.UTC().Format(time.RFC3339))
+	}
+	if f.After > 0 {
+		q.Set("after", strconv.FormatUint(f.After, 10))
+	}
+	var list []Event
+	return list, c.do(ctx, http.MethodGet, "/api/v1/eventlog?"+q.Encode(), nil, &list)
+}
+
+// Loot returns the files in every agent's directory
+func (c *Client) Loot(ctx context.Context) ([]LootFile, error) {
+	var list []LootFile
+	return list, c.do(ctx, http.MethodGet, "/api/v1/loot", nil, &list)
+}
+
+// DownloadLoot writes a file from an agent's directory to the writer
+func (c *Client) DownloadLoot(ctx context.Context, agent string, name string, w io.Writer) error {
+	resp, err := c.request(ctx, http.MethodGet, "/api/v1/loot/"+url.PathEscape(agent)+"/"+url.PathEscape(name), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // #nosec G307
+	if _, err = io.Copy(w, resp.Body); err != nil {
+		return Wrap(TransportFailure, err)
+	}
+	return nil
+}
+
+// Scores returns the capture-the-flag scores awarded since the server started
+func (c *Client) Scores(ctx context.Context) ([]Score, error) {
+	var list []Score
+	return list, c.do(ctx, http.MethodGet, "/api/v1/scores", nil, &list)
+}
+
+// do makes an API request with the value encoded as the JSON body, if it is not nil, and decodes the response into out
+func (c *Client) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	resp, err := c.request(ctx, method, path, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // #nosec G307
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return Errorf(Internal, "there was an error decoding the API response:\r\n%s", err.Error())
+	}
+	return nil
+}
+
+// request makes an API request and returns the response when its status is successful; otherwise the API's error is
+// returned
+func (c *Client) request(ctx context.Context, method string, path string, in interface{}) (*http.Response, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, Errorf(InvalidOption, "there was an error encoding the request:\r\n%s", err.Error())
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.URL+path, body)
+	if err != nil {
+		return nil, Errorf(InvalidOption, "there was an error creating the request:\r\n%s", err.Error())
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, Wrap(TransportFailure, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close() // #nosec G307
+	var e Error
+	if err = json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Code == "" {
+		return nil, Errorf(Internal, "the API returned %s", resp.Status)
+	}
+	return nil, &e
+}
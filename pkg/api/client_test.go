@@ -0,0 +1,105 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	// Standard
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// testClient returns a client for a TLS test server with the handler that trusts the server by its fingerprint
+func testClient(t *testing.T, handler http.HandlerFunc) *Client {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(Error{Code: Unauthorized, Message: "a valid bearer token is required"})
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	sum := sha256.Sum256(srv.Certificate().Raw)
+	return NewClient(srv.URL, "token", hex.EncodeToString(sum[:]))
+}
+
+// TestClientRequests verifies the client sends requests to the API's paths and decodes the responses
+func TestClientRequests(t *testing.T) {
+	id := uuid.NewV4()
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/v1/agents":
+			_ = json.NewEncoder(w).Encode([]Agent{{ID: id, HostName: "host", Status: "Active"}})
+		case "POST /api/v1/agents/" + AllAgents.String() + "/jobs":
+			var job JobRequest
+			if err := json.NewDecoder(r.Body).Decode(&job); err != nil || job.Type != "cmd" || job.Args[0] != "whoami" {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(Error{Code: InvalidOption, Message: "unexpected job"})
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(Job{ID: "AbCdEfGhIj", Agent: AllAgents})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(Error{Code: AgentNotFound, Message: "unknown"})
+		}
+	})
+
+	list, err := c.Agents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != id || list[0].HostName != "host" {
+		t.Errorf("expected agent %s, got %+v", id, list)
+	}
+	job, err := c.AddJob(context.Background(), AllAgents, JobRequest{Type: "cmd", Args: []string{"whoami"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID != "AbCdEfGhIj" || job.Agent != AllAgents {
+		t.Errorf("expected job AbCdEfGhIj for every agent, got %+v", job)
+	}
+	if _, err = c.Agent(context.Background(), id); !Is(err, AgentNotFound) {
+		t.Errorf("expected an %s error, got %v", AgentNotFound, err)
+	}
+}
+
+// TestClientErrors verifies a rejected token returns the API's error and a certificate that does not match the
+// fingerprint is not trusted
+func TestClientErrors(t *testing.T) {
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode([]Listener{})
+	})
+	c.Token = "wrong"
+	if _, err := c.Listeners(context.Background()); !Is(err, Unauthorized) {
+		t.Errorf("expected an %s error, got %v", Unauthorized, err)
+	}
+
+	untrusted := NewClient(c.URL, "token", "00")
+	if _, err := untrusted.Listeners(context.Background()); !Is(err, TransportFailure) {
+		t.Errorf("expected a %s error for an untrusted certificate, got %v", TransportFailure, err)
+	}
+}
@@ -0,0 +1,114 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package api is the Go SDK for Merlin's REST API. Its Client and the documented types for agents, jobs, listeners,
+// modules, and log messages are what the REST API sends and receives, so custom tools such as bots and dashboards can be
+// built against a server without the command line interface. It also holds the error codes the command line interface
+// and the REST API use to branch on an error without matching its message. The package only depends on the standard
+// library and a UUID package so it can be imported on its own.
+package api
+
+import (
+	// Standard
+	"fmt"
+	"net/http"
+)
+
+// Code identifies the kind of error an API function returned
+type Code string
+
+const (
+	// AgentNotFound is returned when an agent ID is not a known agent
+	AgentNotFound Code = "agent-not-found"
+	// ListenerExists is returned when a listener is already using the interface and port, or path, of a new listener
+	ListenerExists Code = "listener-exists"
+	// InvalidOption is returned when an option, argument, or request value is not valid
+	InvalidOption Code = "invalid-option"
+	// TransportFailure is returned when a listener or connection could not be started or used
+	TransportFailure Code = "transport-failure"
+	// NotFound is returned when a requested item other than an agent, such as a module or loot file, does not exist
+	NotFound Code = "not-found"
+	// Unauthorized is returned when a request does not have a valid credential
+	Unauthorized Code = "unauthorized"
+	// Forbidden is returned when a credential is not allowed to make the request
+	Forbidden Code = "forbidden"
+	// Internal is returned for errors that do not have a more specific code
+	Internal Code = "internal"
+)
+
+// Error is an error with a code that API clients can branch on
+type Error struct {
+	Code    Code   `json:"code"`
+	Message string `json:"error"`
+}
+
+// Error returns the error's message
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Errorf returns an error with the code and a message formatted like fmt.Errorf
+func Errorf(code Code, format string, a ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// Wrap returns the error with the code unless the error already has one; nil is returned if the error is nil
+func Wrap(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return &Error{Code: code, Message: err.Error()}
+}
+
+// CodeOf returns the error's code, Internal if the error does not have one, or an empty code if the error is nil
+func CodeOf(err error) Code {
+	if err == nil {
+		return ""
+	}
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return Internal
+}
+
+// Is returns true if the error has the code
+func Is(err error, code Code) bool {
+	return err != nil && CodeOf(err) == code
+}
+
+// Status returns the HTTP status code a REST API response for the error code uses
+func Status(code Code) int {
+	switch code {
+	case AgentNotFound, NotFound:
+		return http.StatusNotFound
+	case ListenerExists:
+		return http.StatusConflict
+	case InvalidOption:
+		return http.StatusBadRequest
+	case TransportFailure:
+		return http.StatusBadGateway
+	case Unauthorized:
+		return http.StatusUnauthorized
+	case Forbidden:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
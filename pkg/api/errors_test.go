@@ -0,0 +1,45 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	// Standard
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// TestCode verifies errors keep their code when wrapped and untyped errors are treated as internal errors
+func TestCode(t *testing.T) {
+	err := Errorf(AgentNotFound, "%s is not a known agent", "c1090dbc-f2f7-4d90-a241-86e0c0217786")
+	if !Is(err, AgentNotFound) || err.Error() != "c1090dbc-f2f7-4d90-a241-86e0c0217786 is not a known agent" {
+		t.Errorf("the error was %q with the code %q", err, CodeOf(err))
+	}
+	if CodeOf(Wrap(TransportFailure, err)) != AgentNotFound {
+		t.Error("wrapping an error with a code replaced its code")
+	}
+	if CodeOf(Wrap(TransportFailure, errors.New("connection refused"))) != TransportFailure {
+		t.Error("wrapping an untyped error did not add the code")
+	}
+	if CodeOf(errors.New("untyped")) != Internal || CodeOf(nil) != "" || Wrap(Internal, nil) != nil {
+		t.Error("untyped and nil errors did not return the expected codes")
+	}
+	if Status(ListenerExists) != http.StatusConflict || Status(Code("unknown")) != http.StatusInternalServerError {
+		t.Error("the error codes did not return the expected HTTP status codes")
+	}
+}
@@ -0,0 +1,647 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package rest is an HTTP REST API for the agent, listener, and module functions so automation tools and dashboards can
+// use Merlin without the interactive command line interface
+package rest
+
+import (
+	// Standard
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/fatih/color"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/api"
+	"github.com/Ne0nd0g/merlin/pkg/audit"
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/events"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/modules"
+	"github.com/Ne0nd0g/merlin/pkg/scoring"
+	"github.com/Ne0nd0g/merlin/pkg/servers/http2"
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// jobTypes are the agent job types that can be created through the API
+var jobTypes = []string{"cmd", "shellcode", "download", "kill", "ls", "killdate", "cd", "pwd", "maxretry", "padding",
+	"skew", "sleep", "jitter", "workinghours", "Minidump", "python", "node", "osascript", "wasm",
+	"sessions-enum", "loggedon", "upload", "find", "grep", "zip", "unzip", "fetch-tool", "screenshot", "keylog",
+	"callbacks", "pins", "psk", "ja3", "execute-assembly", "powershell", "powerpick", "bof", "persist", "firewall", "move", "selfdestruct"}
+
+// listeners are the agent listeners the API knows about, keyed by listener ID
+var listeners = struct {
+	sync.Mutex
+	servers map[uuid.UUID]*http2.Server
+}{servers: make(map[uuid.UUID]*http2.Server)}
+
+// Server is the REST API server
+type Server struct {
+	Addr        string // The interface and port the API listens on (i.e. 127.0.0.1:50050)
+	Certificate string // The x509 certificate file; an in-memory certificate is used if it does not exist
+	Key         string // The x509 certificate key file
+	token       string // The bearer token every request must have
+	observer    string // The bearer token for read-only observers; observers are disabled if it is empty
+	psk         string // The pre-shared key used for listeners started through the API
+}
+
+// New returns a REST API server that requires the bearer token on every request. Listeners started through the API use
+// the certificate, key, and PSK provided here.
+func New(addr string, token string, certificate string, key string, psk string) (*Server, error) {
+	if token == "" {
+		return nil, fmt.Errorf("an API token is required")
+	}
+	return &Server{Addr: addr, Certificate: certificate, Key: key, token: token, psk: psk}, nil
+}
+
+// AllowObservers enables a second bearer token that can only make read-only GET requests, for observers who watch an
+// engagement but must not be able to task agents
+func (s *Server) AllowObservers(token string) {
+	s.observer = token
+}
+
+// AddListener makes a running agent listener available to the API
+func AddListener(s *http2.Server) {
+	listeners.Lock()
+	defer listeners.Unlock()
+	listeners.servers[s.ID] = s
+}
+
+// Run starts the REST API server and blocks until it stops
+func (s *Server) Run() error {
+	srv := &http.Server{
+		Addr:           s.Addr,
+		Handler:        s.Handler(),
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Minute, // Loot downloads, such as a minidump, can be large
+		MaxHeaderBytes: 1 << 20,
+		TLSConfig:      &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+
+	certificate, key := s.Certificate, s.Key
+	if _, err := os.Stat(certificate); os.IsNotExist(err) {
+		cer, errCert := util.GenerateTLSCert(nil, nil, nil, nil, nil, nil, true)
+		if errCert != nil {
+			return fmt.Errorf("there was an error generating the API's TLS certificate:\r\n%s", errCert.Error())
+		}
+		srv.TLSConfig.Certificates = []tls.Certificate{*cer}
+		certificate, key = "", ""
+	}
+
+	m := fmt.Sprintf("Starting REST API on https://%s", s.Addr)
+	logging.Server(m)
+	message("note", m)
+	return srv.ListenAndServeTLS(certificate, key)
+}
+
+// Handler returns the API's HTTP handler with bearer token authentication
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/agents", s.agents)
+	mux.HandleFunc("/api/v1/agents/", s.agent)
+	mux.HandleFunc("/api/v1/events", s.events)
+	mux.HandleFunc("/api/v1/eventlog", s.eventLog)
+	mux.HandleFunc("/api/v1/listeners", s.listeners)
+	mux.HandleFunc("/api/v1/loot", s.lootList)
+	mux.HandleFunc("/api/v1/loot/", s.loot)
+	mux.HandleFunc("/api/v1/modules", s.modules)
+	mux.HandleFunc("/api/v1/modules/", s.module)
+	mux.HandleFunc("/api/v1/scores", s.scores)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A token without the Bearer scheme is rejected
+		header := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == header || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			if token == header || s.observer == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.observer)) != 1 {
+				logging.Server(fmt.Sprintf("Unauthorized REST API request for %s from %s", r.URL.Path, r.RemoteAddr))
+				writeError(w, http.StatusUnauthorized, api.Unauthorized, "a valid bearer token is required")
+				return
+			}
+			if r.Method != http.MethodGet {
+				logging.Server(fmt.Sprintf("Denied REST API observer %s request for %s from %s", r.Method, r.URL.Path,
+					r.RemoteAddr))
+				writeError(w, http.StatusForbidden, api.Forbidden, "observers can only make read-only requests")
+				return
+			}
+		}
+		// Read-only requests are not logged because observers poll the API
+		if r.Method != http.MethodGet {
+			logging.Server(fmt.Sprintf("REST API %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr))
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// agents handles GET /api/v1/agents to list all agents
+func (s *Server) agents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, api.InvalidOption, "use GET to list agents")
+		return
+	}
+	list := make([]api.Agent, 0, len(agents.Agents))
+	for id := range agents.Agents {
+		list = append(list, summary(id))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID.String() < list[j].ID.String() })
+	writeJSON(w, http.StatusOK, list)
+}
+
+// agent handles the requests for a single agent. GET /api/v1/agents/<id> returns the agent's information, DELETE
+// removes a dead agent from the server, POST /api/v1/agents/<id>/accept approves a pending agent, and
+// POST /api/v1/agents/<id>/jobs creates a job from {"type": "cmd", "args": ["whoami"], "priority": "high"}. The agent ID
+// "all" creates the job for every agent. DELETE /api/v1/agents/<id>/jobs/<job> cancels a queued job.
+func (s *Server) agent(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/agents/"), "/"), "/")
+	if parts[0] == "all" {
+		parts[0] = api.AllAgents.String()
+	}
+	id, err := uuid.FromString(parts[0])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, api.InvalidOption, fmt.Sprintf("%s is not a valid agent ID", parts[0]))
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		if _, ok := agents.Agents[id]; !ok {
+			writeError(w, http.StatusNotFound, api.AgentNotFound, fmt.Sprintf("%s is not a known agent", id))
+			return
+		}
+		writeJSON(w, http.StatusOK, detail(id))
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		record(r, id, "remove", nil)
+		if err := agents.RemoveAgent(id); err != nil {
+			writeAPIError(w, err, api.Internal)
+			return
+		}
+		writeJSON(w, http.StatusOK, api.Result{Result: fmt.Sprintf("agent %s was removed", id)})
+	case len(parts) == 2 && parts[1] == "accept" && r.Method == http.MethodPost:
+		record(r, id, "accept", nil)
+		if err := agents.Accept(id); err != nil {
+			writeAPIError(w, err, api.InvalidOption)
+			return
+		}
+		writeJSON(w, http.StatusOK, api.Result{Result: fmt.Sprintf("agent %s was accepted", id)})
+	case len(parts) == 2 && parts[1] == "jobs" && r.Method == http.MethodPost:
+		var job api.JobRequest
+		if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+			writeError(w, http.StatusBadRequest, api.InvalidOption, fmt.Sprintf("there was an error decoding the job:\r\n%s", err.Error()))
+			return
+		}
+		if !inSlice(job.Type, jobTypes) {
+			writeError(w, http.StatusBadRequest, api.InvalidOption, fmt.Sprintf("%s is not a valid job type", job.Type))
+			return
+		}
+		priority := agents.PriorityNormal
+		if job.Priority != "" {
+			if priority, err = agents.ParsePriority(job.Priority); err != nil {
+				writeAPIError(w, err, api.InvalidOption)
+				return
+			}
+		}
+		s.addJob(w, r, id, job.Type, job.Args, job.After, priority)
+	case len(parts) == 3 && parts[1] == "jobs" && r.Method == http.MethodDelete:
+		record(r, id, "jobs", []string{"cancel", parts[2]})
+		if _, err := agents.CancelJob(id, parts[2]); err != nil {
+			writeAPIError(w, err, api.InvalidOption)
+			return
+		}
+		writeJSON(w, http.StatusOK, api.Result{Result: fmt.Sprintf("job %s was canceled", parts[2])})
+	default:
+		writeError(w, http.StatusNotFound, api.NotFound, "unknown agent API request")
+	}
+}
+
+// listeners handles GET /api/v1/listeners to list the agent listeners and POST /api/v1/listeners to start a new
+// listener from {"interface": "0.0.0.0", "port": 8443, "protocol": "h2"}
+func (s *Server) listeners(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listeners.Lock()
+		list := make([]api.Listener, 0, len(listeners.servers))
+		for _, l := range listeners.servers {
+			list = append(list, api.Listener{ID: l.ID, Interface: l.Interface, Port: l.Port, Protocol: l.Protocol})
+		}
+		listeners.Unlock()
+		sort.Slice(list, func(i, j int) bool { return list[i].Port < list[j].Port })
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodPost:
+		var l api.Listener
+		if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+			writeError(w, http.StatusBadRequest, api.InvalidOption, fmt.Sprintf("there was an error decoding the listener:\r\n%s", err.Error()))
+			return
+		}
+		if l.Port < 1 || l.Port > 65535 {
+			writeError(w, http.StatusBadRequest, api.InvalidOption, fmt.Sprintf("%d is not a valid port", l.Port))
+			return
+		}
+		listeners.Lock()
+		for _, e := range listeners.servers {
+			if e.Interface == l.Interface && e.Port == l.Port {
+				listeners.Unlock()
+				writeError(w, http.StatusConflict, api.ListenerExists, fmt.Sprintf("listener %s is already using %s", e.ID,
+					util.JoinHostPort(l.Interface, strconv.Itoa(l.Port))))
+				return
+			}
+		}
+		listeners.Unlock()
+		record(r, uuid.Nil, "listener", []string{"start", l.Protocol, util.JoinHostPort(l.Interface, strconv.Itoa(l.Port))})
+		server, err := http2.New(l.Interface, l.Port, l.Protocol, s.Key, s.Certificate, s.psk)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, api.InvalidOption, err.Error())
+			return
+		}
+		go func() {
+			if err := server.Run(); err != nil {
+				m := fmt.Sprintf("There was an error starting the %s listener on %s:\r\n%s", l.Protocol,
+					util.JoinHostPort(l.Interface, strconv.Itoa(l.Port)), err.Error())
+				logging.Server(m)
+				message("warn", m)
+			}
+		}()
+		AddListener(&server)
+		l.ID = server.ID
+		writeJSON(w, http.StatusCreated, l)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, api.InvalidOption, "use GET to list listeners or POST to start one")
+	}
+}
+
+// modules handles GET /api/v1/modules to list the available modules
+func (s *Server) modules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, api.InvalidOption, "use GET to list modules")
+		return
+	}
+	list := modules.GetModuleList()("")
+	sort.Strings(list)
+	writeJSON(w, http.StatusOK, list)
+}
+
+// module handles the requests for a single module. GET /api/v1/modules/<module path> returns the module's information
+// and options and POST runs the module from {"agent": "<id|all>", "options": {"<name>": "<value>"}}
+func (s *Server) module(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/modules/"), "/")
+	// Only modules found in the module directory can be loaded
+	if !inSlice(name, modules.GetModuleList()("")) {
+		writeError(w, http.StatusNotFound, api.NotFound, fmt.Sprintf("%s is not a known module", name))
+		return
+	}
+	m, err := modules.Create(filepath.Join(core.CurrentDir, "data", "modules", filepath.FromSlash(name)+".json"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, api.Internal, err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, m)
+	case http.MethodPost:
+		var run api.ModuleRun
+		if err := json.NewDecoder(r.Body).Decode(&run); err != nil {
+			writeError(w, http.StatusBadRequest, api.InvalidOption, fmt.Sprintf("there was an error decoding the module options:\r\n%s",
+				err.Error()))
+			return
+		}
+		if _, err := m.SetAgent(run.Agent); err != nil {
+			writeError(w, http.StatusBadRequest, api.InvalidOption, err.Error())
+			return
+		}
+		for k, v := range run.Options {
+			if _, err := m.SetOption(k, v); err != nil {
+				writeError(w, http.StatusBadRequest, api.InvalidOption, err.Error())
+				return
+			}
+		}
+		command, err := m.RunContext(r.Context())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, api.InvalidOption, err.Error())
+			return
+		}
+		if len(command) <= 0 {
+			writeError(w, http.StatusBadRequest, api.InvalidOption, fmt.Sprintf("the %s module did not return a command to task an agent with",
+				m.Name))
+			return
+		}
+		job := m.Job(command)
+		job.After = run.After
+		if !m.Fanout() {
+			if id := s.addJob(w, r, m.Agent, job.Type, job.Args, job.After, agents.PriorityNormal); id != "" {
+				agents.TrackAttack(id, m.Name, m.Attack)
+			}
+			return
+		}
+		// A module run on every agent, or a list of agents, is only sent to the agents it runs on
+		record(r, api.AllAgents, job.Type, job.Args)
+		compatible, failed, err := m.RunAgents()
+		if err != nil {
+			writeAPIError(w, err, api.InvalidOption)
+			return
+		}
+		ids, errs := agents.AddGroupJobsEach(compatible, []agents.Job{job})
+		for id, e := range errs {
+			failed[id] = e
+		}
+		var created []uuid.UUID
+		for _, id := range compatible {
+			if _, ok := failed[id]; !ok {
+				created = append(created, id)
+				agents.TrackAttack(ids[id][0], m.Name, m.Attack)
+			}
+		}
+		if len(created) == 0 {
+			writeError(w, http.StatusBadRequest, api.InvalidOption, fmt.Sprintf("no %s module jobs were created for the %d agents",
+				m.Name, len(failed)))
+			return
+		}
+		msg := fmt.Sprintf("Created %s module jobs for %d of %d agents from the REST API at %s", m.Name,
+			len(created), len(created)+len(failed), time.Now().UTC().Format(time.RFC3339))
+		message("note", msg)
+		logging.Server(msg)
+		writeJSON(w, http.StatusCreated, api.Job{ID: ids[created[0]][0], Agent: api.AllAgents})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, api.InvalidOption, "use GET to show a module or POST to run it")
+	}
+}
+
+// events handles GET /api/v1/events?since=<event ID> to return the server and agent log entries after the event ID
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, api.InvalidOption, "use GET to list events")
+		return
+	}
+	var since uint64
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		since, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, api.InvalidOption, fmt.Sprintf("%s is not a valid event ID", v))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, logging.Events(since))
+}
+
+// eventLog handles GET /api/v1/eventlog to query the structured event log with the optional agent, type, since, and
+// until filters and after=<event ID> to return only newer events
+func (s *Server) eventLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, api.InvalidOption, "use GET to query the event log")
+		return
+	}
+	q := r.URL.Query()
+	f := events.Filter{Agent: q.Get("agent"), Type: q.Get("type")}
+	if f.Type != "" && !inSlice(f.Type, events.Types()) {
+		writeError(w, http.StatusBadRequest, api.InvalidOption, fmt.Sprintf("%s is not a valid event type", f.Type))
+		return
+	}
+	var err error
+	now := time.Now().UTC()
+	if v := q.Get("since"); v != "" {
+		if f.Since, err = events.ParseTime(v, now); err != nil {
+			writeError(w, http.StatusBadRequest, api.InvalidOption, err.Error())
+			return
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		if f.Until, err = events.ParseTime(v, now); err != nil {
+			writeError(w, http.StatusBadRequest, api.InvalidOption, err.Error())
+			return
+		}
+	}
+	if v := q.Get("after"); v != "" {
+		if f.After, err = strconv.ParseUint(v, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, api.InvalidOption, fmt.Sprintf("%s is not a valid event ID", v))
+			return
+		}
+	}
+	list, err := events.QueryContext(r.Context(), f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, api.Internal, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// scores handles GET /api/v1/scores to list the capture-the-flag scores awarded since the server started
+func (s *Server) scores(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, api.InvalidOption, "use GET to list scores")
+		return
+	}
+	if !scoring.Enabled() {
+		writeError(w, http.StatusNotFound, api.NotFound, "scoring is not enabled; start the server with -scoring")
+		return
+	}
+	writeJSON(w, http.StatusOK, scoring.Scores())
+}
+
+// lootList handles GET /api/v1/loot to list the files in every agent's directory
+func (s *Server) lootList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, api.InvalidOption, "use GET to list loot")
+		return
+	}
+	list := make([]api.LootFile, 0)
+	dirs, err := ioutil.ReadDir(filepath.Join(core.CurrentDir, "data", "agents"))
+	if err != nil && !os.IsNotExist(err) {
+		writeError(w, http.StatusInternalServerError, api.Internal, err.Error())
+		return
+	}
+	for _, d := range dirs {
+		if _, errID := uuid.FromString(d.Name()); !d.IsDir() || errID != nil {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(core.CurrentDir, "data", "agents", d.Name()))
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if f.Mode().IsRegular() {
+				list = append(list, api.LootFile{Agent: d.Name(), Name: f.Name(), Size: f.Size(), Modified: f.ModTime()})
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// loot handles GET /api/v1/loot/<agent ID>/<file name> to download a file from an agent's directory
+func (s *Server) loot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, api.InvalidOption, "use GET to download loot")
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/loot/"), "/")
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, api.NotFound, "use /api/v1/loot/<agent ID>/<file name>")
+		return
+	}
+	id, err := uuid.FromString(parts[0])
+	// The file name can not contain a path so only files in the agent's directory are served
+	if err != nil || parts[1] == "" || parts[1] == "." || parts[1] == ".." || strings.ContainsAny(parts[1], "/\\") {
+		writeError(w, http.StatusNotFound, api.NotFound, "unknown loot file")
+		return
+	}
+	file := filepath.Join(core.CurrentDir, "data", "agents", id.String(), parts[1])
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() {
+		writeError(w, http.StatusNotFound, api.NotFound, "unknown loot file")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeFile(w, r, file)
+}
+
+// addJob creates the job for the agent, embargoed until the after time if it is not zero, writes the job ID to the
+// response, and returns the job ID; it is empty if the job was not created
+func (s *Server) addJob(w http.ResponseWriter, r *http.Request, id uuid.UUID, jobType string, args []string, after time.Time, priority int) string {
+	record(r, id, jobType, args)
+	job, err := agents.AddJobPriority(id, jobType, args, after, priority)
+	if err != nil {
+		writeAPIError(w, err, api.InvalidOption)
+		return ""
+	}
+	m := fmt.Sprintf("Created job %s for agent %s from the REST API at %s", job, id, time.Now().UTC().Format(time.RFC3339))
+	message("note", m)
+	logging.Server(m)
+	writeJSON(w, http.StatusCreated, api.Job{ID: job, Agent: id})
+	return job
+}
+
+// record adds the API request's action to the audit log; the API's client is identified by its address because every
+// client uses the same bearer token
+func record(r *http.Request, agent uuid.UUID, command string, args []string) {
+	e := audit.Entry{Client: audit.API, Session: r.RemoteAddr, Command: command, Args: args}
+	if agent != uuid.Nil {
+		e.Agent = agent.String()
+	}
+	if err := audit.Record(e); err != nil {
+		logging.Server(err.Error())
+		message("warn", err.Error())
+	}
+}
+
+// summary returns the listed information for an agent
+func summary(id uuid.UUID) api.Agent {
+	a := agents.Agents[id]
+	return api.Agent{
+		ID:           id,
+		Platform:     a.Platform,
+		Architecture: a.Architecture,
+		UserName:     a.UserName,
+		HostName:     a.HostName,
+		Proto:        a.Proto,
+		Status:       agents.GetAgentStatus(id),
+		LastCheckIn:  a.StatusCheckIn,
+	}
+}
+
+// detail returns all of an agent's information except its key material
+func detail(id uuid.UUID) api.AgentDetail {
+	a := agents.Agents[id]
+	delayed, dead := agents.Thresholds(id)
+	return api.AgentDetail{
+		Agent:          summary(id),
+		UserGUID:       a.UserGUID,
+		Pid:            a.Pid,
+		Ips:            a.Ips,
+		Interpreters:   a.Interpreters,
+		InitialCheckIn: a.InitialCheckIn,
+		Version:        a.Version,
+		Build:          a.Build,
+		WaitTime:       a.WaitTime,
+		Skew:           a.Skew,
+		Jitter:         a.Jitter,
+		WorkingHours:   a.WorkingHours,
+		DelayedAfter:   delayed,
+		DeadAfter:      dead,
+		PaddingMax:     a.PaddingMax,
+		MaxRetry:       a.MaxRetry,
+		FailedCheckin:  a.FailedCheckin,
+		KillDate:       a.KillDate,
+		Watermark:      a.Watermark,
+		ForkedFrom:     a.ForkedFrom,
+	}
+}
+
+// writeJSON writes the value to the response as JSON with the status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Server(fmt.Sprintf("There was an error encoding a REST API response:\r\n%s", err.Error()))
+	}
+}
+
+// writeError writes an error message and its code to the response as JSON with the status code
+func writeError(w http.ResponseWriter, status int, code api.Code, err string) {
+	writeJSON(w, status, api.Error{Code: code, Message: err})
+}
+
+// writeAPIError writes an error returned by another package to the response with the status code for its error code,
+// using the fallback code if the error does not have one
+func writeAPIError(w http.ResponseWriter, err error, fallback api.Code) {
+	code := api.CodeOf(err)
+	if code == api.Internal {
+		code = fallback
+	}
+	writeError(w, api.Status(code), code, err.Error())
+}
+
+// inSlice returns true if the provided string is an element of the provided slice
+func inSlice(s string, list []string) bool {
+	for _, v := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// message is used to print a message to the command line
+func message(level string, message string) {
+	switch level {
+	case "info":
+		color.Cyan("[i]" + message)
+	case "note":
+		color.Yellow("[-]" + message)
+	case "warn":
+		color.Red("[!]" + message)
+	case "debug":
+		color.Red("[DEBUG]" + message)
+	case "success":
+		color.Green("[+]" + message)
+	default:
+		color.Red("[_-_]Invalid message level: " + message)
+	}
+}
@@ -0,0 +1,148 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package rest
+
+import (
+	// Standard
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+)
+
+// testToken is the bearer token the test server requires
+const testToken = "merlin-test-token"
+
+// serve sends a request with the bearer token to a test API server and returns the response
+func serve(t *testing.T, method string, path string, token string) *httptest.ResponseRecorder {
+	if token == "" {
+		return serveAuthorization(t, method, path, "")
+	}
+	return serveAuthorization(t, method, path, "Bearer "+token)
+}
+
+// serveAuthorization sends a request with the Authorization header value to a test API server and returns the response
+func serveAuthorization(t *testing.T, method string, path string, authorization string) *httptest.ResponseRecorder {
+	s, err := New("127.0.0.1:0", testToken, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AllowObservers("merlin-observer-token")
+	r := httptest.NewRequest(method, path, nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	return w
+}
+
+// decodeError verifies the response is a JSON API error with the status and code
+func decodeError(t *testing.T, w *httptest.ResponseRecorder, status int, code api.Code) {
+	if w.Code != status {
+		t.Errorf("expected status %d, got %d", status, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected a JSON response, got %s", ct)
+	}
+	var e api.Error
+	if err := json.NewDecoder(w.Body).Decode(&e); err != nil {
+		t.Fatalf("there was an error decoding the error response:\r\n%s", err.Error())
+	}
+	if e.Code != code || e.Message == "" {
+		t.Errorf("expected a %s error with a message, got %+v", code, e)
+	}
+}
+
+// TestNew verifies an API server can not be created without a token
+func TestNew(t *testing.T) {
+	if _, err := New("127.0.0.1:0", "", "", "", ""); err == nil {
+		t.Error("expected an error creating an API server without a token")
+	}
+}
+
+// TestUnauthorized verifies requests without a valid bearer token are rejected before they reach a handler
+func TestUnauthorized(t *testing.T) {
+	for _, token := range []string{"", "wrong-token", "Bearer " + testToken} {
+		decodeError(t, serve(t, http.MethodGet, "/api/v1/agents", token), http.StatusUnauthorized, api.Unauthorized)
+	}
+	// The token must use the Bearer scheme
+	for _, authorization := range []string{testToken, "merlin-observer-token", "Basic " + testToken} {
+		w := serveAuthorization(t, http.MethodGet, "/api/v1/agents", authorization)
+		decodeError(t, w, http.StatusUnauthorized, api.Unauthorized)
+	}
+}
+
+// TestObserver verifies the observer token can make GET requests but not change anything
+func TestObserver(t *testing.T) {
+	if w := serve(t, http.MethodGet, "/api/v1/agents", "merlin-observer-token"); w.Code != http.StatusOK {
+		t.Errorf("expected status %d for an observer GET request, got %d", http.StatusOK, w.Code)
+	}
+	w := serve(t, http.MethodPost, "/api/v1/agents/all/jobs", "merlin-observer-token")
+	decodeError(t, w, http.StatusForbidden, api.Forbidden)
+}
+
+// TestMethodNotAllowed verifies the list endpoints reject methods other than GET
+func TestMethodNotAllowed(t *testing.T) {
+	for _, path := range []string{"/api/v1/agents", "/api/v1/loot", "/api/v1/scores"} {
+		decodeError(t, serve(t, http.MethodPost, path, testToken), http.StatusMethodNotAllowed, api.InvalidOption)
+	}
+}
+
+// TestNotFound verifies unknown agents, modules, and agent requests return a not found error
+func TestNotFound(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		code   api.Code
+	}{
+		{http.MethodGet, "/api/v1/agents/" + uuid.NewV4().String(), api.AgentNotFound},
+		{http.MethodGet, "/api/v1/agents/" + uuid.NewV4().String() + "/unknown", api.NotFound},
+		{http.MethodGet, "/api/v1/modules/merlin/not/a/module", api.NotFound},
+		{http.MethodGet, "/api/v1/scores", api.NotFound},
+	}
+	for _, test := range tests {
+		decodeError(t, serve(t, test.method, test.path, testToken), http.StatusNotFound, test.code)
+	}
+	decodeError(t, serve(t, http.MethodGet, "/api/v1/agents/not-an-id", testToken), http.StatusBadRequest,
+		api.InvalidOption)
+}
+
+// TestAgents verifies the agent list is a JSON array, and not null, when there are no agents
+func TestAgents(t *testing.T) {
+	w := serve(t, http.MethodGet, "/api/v1/agents", testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected a JSON response, got %s", ct)
+	}
+	var list []api.Agent
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("there was an error decoding the agent list:\r\n%s", err.Error())
+	}
+	if list == nil || len(list) != 0 {
+		t.Errorf("expected an empty agent list, got %s", w.Body.String())
+	}
+}
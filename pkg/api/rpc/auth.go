@@ -0,0 +1,207 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	// Standard
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	// 3rd Party
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Operator roles
+const (
+	RoleOperator = "operator" // Can use the console and every service
+	RoleObserver = "observer" // Can only list agents, modules, and sessions with the JSON-RPC services
+)
+
+// Identity is an operator who was authenticated by a backend and the groups their identity provider put them in
+type Identity struct {
+	Name   string
+	Groups []string
+}
+
+// Backend authenticates operators against an account store
+type Backend interface {
+	// Name is the backend's name as it is used in the authentication configuration, such as "ldap"
+	Name() string
+	// Authenticate returns the operator's identity if the password, or token, is theirs
+	Authenticate(operator string, password string) (Identity, error)
+}
+
+// AuthConfig is the team server's authentication configuration file. Backends are tried in order until one
+// authenticates the operator. Local accounts are operators; the groups of every other identity are mapped to a role.
+type AuthConfig struct {
+	Backends []string          `json:"backends"`       // local, ldap, and oidc; local alone if empty
+	LDAP     *LDAPConfig       `json:"ldap,omitempty"` // Required for the ldap backend
+	OIDC     *OIDCConfig       `json:"oidc,omitempty"` // Required for the oidc backend
+	Roles    map[string]string `json:"roles"`          // Group names, or LDAP group DNs, and the role they grant
+}
+
+// auth is the configured backends and group to role mapping
+var auth = struct {
+	sync.Mutex
+	backends []Backend
+	roles    map[string]string
+}{backends: []Backend{local{}}}
+
+// errDenied is returned by a backend when the operator or their password is not valid
+var errDenied = errors.New("invalid operator or password")
+
+// LoadAuth reads the authentication configuration file and uses its backends for every login that follows
+func LoadAuth(file string) error {
+	data, err := ioutil.ReadFile(file) // #nosec G304 - The configuration file is provided by the server
+	if err != nil {
+		return fmt.Errorf("there was an error reading the authentication configuration %s:\r\n%s", file, err.Error())
+	}
+	var config AuthConfig
+	if err = json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("there was an error parsing the authentication configuration %s:\r\n%s", file, err.Error())
+	}
+	return SetAuth(config)
+}
+
+// SetAuth uses the configuration's backends and role mapping for every login that follows
+func SetAuth(config AuthConfig) error {
+	if len(config.Backends) == 0 {
+		config.Backends = []string{"local"}
+	}
+	var backends []Backend
+	for _, name := range config.Backends {
+		switch name {
+		case "local":
+			backends = append(backends, local{})
+		case "ldap":
+			if config.LDAP == nil {
+				return errors.New("the ldap backend requires an ldap section in the authentication configuration")
+			}
+			backends = append(backends, config.LDAP)
+		case "oidc":
+			if config.OIDC == nil {
+				return errors.New("the oidc backend requires an oidc section in the authentication configuration")
+			}
+			backends = append(backends, config.OIDC)
+		default:
+			return fmt.Errorf("%s is not an authentication backend; use local, ldap, or oidc", name)
+		}
+	}
+	for group, role := range config.Roles {
+		if role != RoleOperator && role != RoleObserver {
+			return fmt.Errorf("%s is not a valid role for group %s; use %s or %s", role, group, RoleOperator, RoleObserver)
+		}
+	}
+	auth.Lock()
+	auth.backends = backends
+	auth.roles = config.Roles
+	auth.Unlock()
+	var names []string
+	for _, b := range backends {
+		names = append(names, b.Name())
+	}
+	message("info", fmt.Sprintf("Team server operators authenticate with %s", strings.Join(names, ", ")))
+	return nil
+}
+
+// authenticate tries each backend in order and returns the role of the first one that authenticates the operator,
+// or an error if none does or the operator's groups do not grant a role
+func authenticate(operator string, password string) (string, error) {
+	auth.Lock()
+	backends, roles := auth.backends, auth.roles
+	auth.Unlock()
+	if operator == "" || password == "" {
+		return "", errDenied
+	}
+	for _, b := range backends {
+		identity, err := b.Authenticate(operator, password)
+		if err == errDenied {
+			continue
+		}
+		if err != nil {
+			message("warn", fmt.Sprintf("There was an error authenticating operator %q with %s:\r\n%s", operator,
+				b.Name(), err.Error()))
+			continue
+		}
+		if b.Name() == "local" {
+			return RoleOperator, nil
+		}
+		if role := mapRole(identity.Groups, roles); role != "" {
+			return role, nil
+		}
+		return "", fmt.Errorf("none of operator %s's %s groups are mapped to a role", operator, b.Name())
+	}
+	return "", errDenied
+}
+
+// mapRole returns the most privileged role granted by any of the groups, ignoring case, or an empty string
+func mapRole(groups []string, roles map[string]string) string {
+	var role string
+	for _, g := range groups {
+		for group, r := range roles {
+			if strings.EqualFold(g, group) || strings.EqualFold(ldapCN(g), group) {
+				if r == RoleOperator {
+					return r
+				}
+				role = r
+			}
+		}
+	}
+	return role
+}
+
+// local authenticates operators with the accounts created by "operators add"
+type local struct{}
+
+// Name returns the backend's name
+func (local) Name() string {
+	return "local"
+}
+
+// Authenticate compares the password with the operator account's bcrypt hash
+func (local) Authenticate(operator string, password string) (Identity, error) {
+	accounts.Lock()
+	a, err := load()
+	accounts.Unlock()
+	if err != nil {
+		return Identity{}, err
+	}
+	hash, ok := a[operator]
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return Identity{}, errDenied
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return Identity{}, errDenied
+	}
+	return Identity{Name: operator}, nil
+}
+
+// ldapCN returns the value of the first relative distinguished name of an LDAP DN, such as RedTeam for
+// CN=RedTeam,OU=Groups,DC=corp,DC=local, or the DN itself if it is not one
+func ldapCN(dn string) string {
+	rdn := strings.SplitN(dn, ",", 2)[0]
+	if i := strings.Index(rdn, "="); i > 0 {
+		return strings.TrimSpace(rdn[i+1:])
+	}
+	return dn
+}
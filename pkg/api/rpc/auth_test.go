@@ -0,0 +1,219 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	// Standard
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc/jsonrpc"
+	"testing"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// TestOIDC verifies an operator logs in with their own access token, gets the role of their groups, and an observer
+// can list but not task agents or open a console
+func TestOIDC(t *testing.T) {
+	users := map[string]map[string]interface{}{
+		"token-alice": {"preferred_username": "alice", "groups": []string{"red-team"}},
+		"token-eve":   {"preferred_username": "eve", "groups": []string{"white-cell"}},
+		"token-bob":   {"preferred_username": "bob", "groups": []string{"finance"}},
+	}
+	mux := http.NewServeMux()
+	idp := httptest.NewServer(mux)
+	defer idp.Close()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"userinfo_endpoint": idp.URL + "/userinfo"})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := users[r.Header.Get("Authorization")[len("Bearer "):]]
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(claims)
+	})
+
+	defer func() { _ = SetAuth(AuthConfig{}) }()
+	err := SetAuth(AuthConfig{
+		Backends: []string{"oidc"},
+		OIDC:     &OIDCConfig{Issuer: idp.URL},
+		Roles:    map[string]string{"red-team": RoleOperator, "white-cell": RoleObserver},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		hello Hello
+		ok    bool
+	}{
+		{Hello{Operator: "alice", Password: "token-alice", Console: true}, true},
+		{Hello{Operator: "eve", Password: "token-alice"}, false}, // Another operator's token
+		{Hello{Operator: "bob", Password: "token-bob"}, false},   // No group mapped to a role
+		{Hello{Operator: "eve", Password: "token-eve", Console: true}, false},
+		{Hello{Operator: "alice", Password: "expired"}, false},
+	}
+	for _, test := range tests {
+		conn, w := loginPipe(t, test.hello)
+		if (w.Error == "") != test.ok {
+			t.Errorf("expected login of %s with %s to succeed: %t, got error %q", test.hello.Operator,
+				test.hello.Password, test.ok, w.Error)
+		}
+		_ = conn.Close()
+	}
+
+	conn, w := loginPipe(t, Hello{Operator: "eve", Password: "token-eve"})
+	if w.Error != "" {
+		t.Fatal(w.Error)
+	}
+	c := jsonrpc.NewClient(conn)
+	defer c.Close() // #nosec G307
+	var list []Session
+	if err = c.Call("Operators.Sessions", struct{}{}, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Role != RoleObserver {
+		t.Errorf("expected eve's observer session, got %+v", list)
+	}
+	var job string
+	if err = c.Call("Agents.AddJob", JobArgs{Agent: "all", Type: "cmd", Args: []string{"whoami"}}, &job); err == nil {
+		t.Error("an observer created a job")
+	}
+}
+
+// TestLDAP verifies an operator binds to the directory as themselves, over TLS started with StartTLS, and the groups in
+// their entry are read
+func TestLDAP(t *testing.T) {
+	cert, err := util.GenerateTLSCert(nil, nil, nil, nil, nil, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &LDAPConfig{URL: "ldap://" + listenLDAP(t, cert), BindDN: "%s@corp.local", BaseDN: "DC=corp,DC=local",
+		UserAttribute: "sAMAccountName"}
+	// The fake directory's self-signed certificate is not trusted
+	if _, err = c.Authenticate("alice", "Summer2019!"); err == nil {
+		t.Error("expected StartTLS with an untrusted certificate to fail")
+	}
+	c.InsecureSkipVerify = true
+	identity, err := c.Authenticate("alice", "Summer2019!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identity.Groups) != 2 || identity.Groups[0] != "CN=RedTeam,OU=Groups,DC=corp,DC=local" {
+		t.Errorf("expected alice's two groups, got %v", identity.Groups)
+	}
+	if role := mapRole(identity.Groups, map[string]string{"redteam": RoleOperator}); role != RoleOperator {
+		t.Errorf("expected the RedTeam group's CN to map to the operator role, got %q", role)
+	}
+	if _, err = c.Authenticate("alice", "wrong"); err != errDenied {
+		t.Errorf("expected a wrong password to be denied, got %v", err)
+	}
+	if _, err = c.Authenticate("alice,OU=Admins", "Summer2019!"); err != errDenied {
+		t.Errorf("expected a name with DN characters to be denied, got %v", err)
+	}
+}
+
+// TestLDAPNoStartTLS verifies the password is not sent to a directory that refuses StartTLS unless the configuration
+// opts out of it
+func TestLDAPNoStartTLS(t *testing.T) {
+	c := &LDAPConfig{URL: "ldap://" + listenLDAP(t, nil), BindDN: "%s@corp.local", BaseDN: "DC=corp,DC=local"}
+	if _, err := c.Authenticate("alice", "Summer2019!"); err == nil || err == errDenied {
+		t.Errorf("expected the directory refusing StartTLS to fail the login, got %v", err)
+	}
+	c.InsecureNoStartTLS = true
+	if _, err := c.Authenticate("alice", "Summer2019!"); err != nil {
+		t.Errorf("expected alice to bind without StartTLS when it is turned off, got %v", err)
+	}
+}
+
+// listenLDAP starts a fake directory that supports StartTLS with the certificate, or refuses it if the certificate is
+// nil, and returns its address
+func listenLDAP(t *testing.T, cert *tls.Certificate) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go fakeLDAP(conn, cert)
+		}
+	}()
+	return l.Addr().String()
+}
+
+// fakeLDAP answers StartTLS, a bind as alice@corp.local, and a search for her entry. StartTLS is refused if the
+// certificate is nil.
+func fakeLDAP(conn net.Conn, cert *tls.Certificate) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	for {
+		tag, op, err := readLDAPMessage(r)
+		if err != nil {
+			return
+		}
+		switch tag {
+		case ldapExtendedRequest:
+			_, name, _, _ := berRead(op)
+			code := 0
+			if string(name) != ldapStartTLS || cert == nil {
+				code = 2 // protocolError
+			}
+			_, _ = conn.Write(ldapMessage(1, berSequence(ldapExtendedResponse, berInt(0x0a, code), berString(0x04, ""),
+				berString(0x04, ""))))
+			if code == 0 {
+				conn = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{*cert}, MinVersion: tls.VersionTLS12})
+				r = bufio.NewReader(conn)
+			}
+		case ldapBindRequest:
+			_, _, rest, _ := berRead(op)
+			_, name, rest, _ := berRead(rest)
+			_, password, _, _ := berRead(rest)
+			code := 0
+			if string(name) != "alice@corp.local" || string(password) != "Summer2019!" {
+				code = ldapInvalidCredsCode
+			}
+			_, _ = conn.Write(ldapMessage(2, berSequence(ldapBindResponse, berInt(0x0a, code), berString(0x04, ""),
+				berString(0x04, ""))))
+		case ldapSearchRequest:
+			entry := berSequence(ldapSearchResult, berString(0x04, "CN=Alice,OU=Users,DC=corp,DC=local"),
+				berSequence(0x30,
+					berSequence(0x30, berString(0x04, "memberOf"), berSequence(0x31,
+						berString(0x04, "CN=RedTeam,OU=Groups,DC=corp,DC=local"),
+						berString(0x04, "CN=Domain Users,CN=Users,DC=corp,DC=local"),
+					)),
+				))
+			_, _ = conn.Write(ldapMessage(3, entry))
+			_, _ = conn.Write(ldapMessage(3, berSequence(ldapSearchDone, berInt(0x0a, 0), berString(0x04, ""),
+				berString(0x04, ""))))
+		default:
+			return
+		}
+	}
+}
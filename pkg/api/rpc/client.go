@@ -0,0 +1,106 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	// Standard
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	netrpc "net/rpc"
+	"net/rpc/jsonrpc"
+	"strings"
+	"time"
+)
+
+// Dial connects to the team server, verifies its certificate has the SHA256 fingerprint, and logs in. It returns the
+// connection and the session ID; the rest of the connection is the console's terminal stream or JSON-RPC messages.
+func Dial(addr string, fingerprint string, hello Hello) (net.Conn, string, error) {
+	fingerprint = strings.ToLower(strings.Replace(fingerprint, ":", "", -1))
+	config := &tls.Config{
+		// The certificate is verified by its fingerprint below because the team server's is usually self-signed
+		InsecureSkipVerify: true, // #nosec G402
+		MinVersion:         tls.VersionTLS12,
+		VerifyPeerCertificate: func(certificates [][]byte, _ [][]*x509.Certificate) error {
+			if len(certificates) == 0 {
+				return errors.New("the team server did not send a certificate")
+			}
+			sum := sha256.Sum256(certificates[0])
+			if fingerprint == "" {
+				return fmt.Errorf("the team server's certificate SHA256 fingerprint is %s; verify it with the "+
+					"server's operator and provide it to connect", hex.EncodeToString(sum[:]))
+			}
+			if hex.EncodeToString(sum[:]) != fingerprint {
+				return fmt.Errorf("the team server's certificate SHA256 fingerprint %s does not match %s",
+					hex.EncodeToString(sum[:]), fingerprint)
+			}
+			return nil
+		},
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: helloTimeout}, "tcp", addr, config)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := json.Marshal(hello)
+	if err == nil {
+		_, err = conn.Write(append(data, '\n'))
+	}
+	var w Welcome
+	if err == nil {
+		w, err = readWelcome(conn)
+	}
+	if err == nil && w.Error != "" {
+		err = errors.New(w.Error)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, "", fmt.Errorf("there was an error logging in to the team server:\r\n%s", err.Error())
+	}
+	return conn, w.Session, nil
+}
+
+// NewClient logs in to the team server and returns a JSON-RPC client for its Agents, Modules, and Operators services
+func NewClient(addr string, fingerprint string, operator string, password string) (*netrpc.Client, error) {
+	conn, _, err := Dial(addr, fingerprint, Hello{Operator: operator, Password: password})
+	if err != nil {
+		return nil, err
+	}
+	return jsonrpc.NewClient(conn), nil
+}
+
+// readWelcome reads the server's reply to the Hello
+func readWelcome(conn net.Conn) (Welcome, error) {
+	var w Welcome
+	if err := conn.SetReadDeadline(time.Now().Add(helloTimeout)); err != nil {
+		return w, err
+	}
+	line, err := readLine(conn)
+	if err != nil {
+		return w, err
+	}
+	if err := json.Unmarshal(line, &w); err != nil {
+		return w, fmt.Errorf("there was an error parsing the welcome message:\r\n%s", err.Error())
+	}
+	return w, conn.SetReadDeadline(time.Time{})
+}
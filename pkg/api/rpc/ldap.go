@@ -0,0 +1,412 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	// Standard
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LDAP message tags
+const (
+	ldapBindRequest      = 0x60 // [APPLICATION 0] constructed
+	ldapBindResponse     = 0x61 // [APPLICATION 1] constructed
+	ldapUnbindRequest    = 0x42 // [APPLICATION 2] primitive
+	ldapSearchRequest    = 0x63 // [APPLICATION 3] constructed
+	ldapSearchResult     = 0x64 // [APPLICATION 4] constructed
+	ldapSearchDone       = 0x65 // [APPLICATION 5] constructed
+	ldapSearchReference  = 0x73 // [APPLICATION 19] constructed
+	ldapExtendedRequest  = 0x77 // [APPLICATION 23] constructed
+	ldapExtendedResponse = 0x78 // [APPLICATION 24] constructed
+	ldapInvalidCredsCode = 49   // The invalidCredentials result code
+)
+
+// ldapStartTLS is the object identifier of the StartTLS extended operation
+const ldapStartTLS = "1.3.6.1.4.1.1466.20037"
+
+// ldapTimeout is how long the LDAP server has to answer each login
+const ldapTimeout = 15 * time.Second
+
+// LDAPConfig authenticates operators by binding to an LDAP directory, such as Active Directory, as the operator and
+// reads their groups from their entry
+type LDAPConfig struct {
+	URL                string `json:"url"`                // ldap://host:389, upgraded with StartTLS, or ldaps://host:636
+	BindDN             string `json:"bindDN"`             // The DN or UPN the operator binds as, where %s is their name (i.e. %s@corp.local)
+	BaseDN             string `json:"baseDN"`             // Where the operator's entry is searched for
+	UserAttribute      string `json:"userAttribute"`      // The attribute the operator's name is in; uid if empty
+	GroupAttribute     string `json:"groupAttribute"`     // The attribute with the operator's group DNs; memberOf if empty
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"` // Do not verify the LDAP server's TLS certificate
+	InsecureNoStartTLS bool   `json:"insecureNoStartTLS"` // Bind to an ldap:// URL without StartTLS, sending passwords in cleartext
+}
+
+// Name returns the backend's name
+func (c *LDAPConfig) Name() string {
+	return "ldap"
+}
+
+// Authenticate binds to the directory as the operator with the password and returns the groups in their entry
+func (c *LDAPConfig) Authenticate(operator string, password string) (Identity, error) {
+	// The name is put into a DN, so characters with a meaning in a DN are refused instead of escaped. An empty
+	// password would be an unauthenticated bind, which succeeds without checking anything.
+	if password == "" || strings.ContainsAny(operator, ",=+<>#;\\\"\x00") {
+		return Identity{}, errDenied
+	}
+	conn, err := c.dial()
+	if err != nil {
+		return Identity{}, err
+	}
+	defer conn.Close() // #nosec G307
+	if err = conn.SetDeadline(time.Now().Add(ldapTimeout)); err != nil {
+		return Identity{}, err
+	}
+	r := bufio.NewReader(conn)
+
+	bind := berSequence(ldapBindRequest, berInt(0x02, 3), berString(0x04, fmt.Sprintf(c.BindDN, operator)),
+		berString(0x80, password))
+	if _, err = conn.Write(ldapMessage(2, bind)); err != nil {
+		return Identity{}, fmt.Errorf("there was an error sending the LDAP bind request:\r\n%s", err.Error())
+	}
+	tag, op, err := readLDAPMessage(r)
+	if err != nil {
+		return Identity{}, err
+	}
+	if tag != ldapBindResponse {
+		return Identity{}, fmt.Errorf("the LDAP server answered the bind request with message 0x%x", tag)
+	}
+	if code, diagnostic, err := ldapResult(op); err != nil {
+		return Identity{}, err
+	} else if code == ldapInvalidCredsCode {
+		return Identity{}, errDenied
+	} else if code != 0 {
+		return Identity{}, fmt.Errorf("the LDAP bind failed with result code %d: %s", code, diagnostic)
+	}
+
+	userAttribute, groupAttribute := c.UserAttribute, c.GroupAttribute
+	if userAttribute == "" {
+		userAttribute = "uid"
+	}
+	if groupAttribute == "" {
+		groupAttribute = "memberOf"
+	}
+	search := berSequence(ldapSearchRequest,
+		berString(0x04, c.BaseDN),
+		berInt(0x0a, 2), // wholeSubtree
+		berInt(0x0a, 0), // neverDerefAliases
+		berInt(0x02, 1), // sizeLimit
+		berInt(0x02, int(ldapTimeout/time.Second)),
+		[]byte{0x01, 0x01, 0x00}, // typesOnly FALSE
+		berSequence(0xa3, berString(0x04, userAttribute), berString(0x04, operator)),
+		berSequence(0x30, berString(0x04, groupAttribute)),
+	)
+	if _, err = conn.Write(ldapMessage(3, search)); err != nil {
+		return Identity{}, fmt.Errorf("there was an error sending the LDAP search request:\r\n%s", err.Error())
+	}
+	identity := Identity{Name: operator}
+	var found bool
+	for done := false; !done; {
+		tag, op, err = readLDAPMessage(r)
+		if err != nil {
+			return Identity{}, err
+		}
+		switch tag {
+		case ldapSearchResult:
+			found = true
+			groups, err := ldapAttribute(op, groupAttribute)
+			if err != nil {
+				return Identity{}, err
+			}
+			identity.Groups = append(identity.Groups, groups...)
+		case ldapSearchReference:
+		case ldapSearchDone:
+			done = true
+		default:
+			return Identity{}, fmt.Errorf("the LDAP server answered the search request with message 0x%x", tag)
+		}
+	}
+	_, _ = conn.Write(ldapMessage(4, []byte{ldapUnbindRequest, 0x00}))
+	if !found {
+		return Identity{}, fmt.Errorf("the LDAP entry where %s is %s was not found under %s", userAttribute, operator,
+			c.BaseDN)
+	}
+	return identity, nil
+}
+
+// dial connects to the LDAP server in the configuration's URL. An ldap:// connection is upgraded with StartTLS unless
+// the configuration opts out, so the operator's password is never sent in cleartext by default.
+func (c *LDAPConfig) dial() (net.Conn, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error parsing the LDAP URL %s:\r\n%s", c.URL, err.Error())
+	}
+	host := u.Host
+	dialer := &net.Dialer{Timeout: ldapTimeout}
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err := dialer.Dial("tcp", host)
+		if err != nil {
+			return nil, fmt.Errorf("there was an error connecting to the LDAP server %s:\r\n%s", host, err.Error())
+		}
+		if c.InsecureNoStartTLS {
+			return conn, nil
+		}
+		tlsConn, err := c.startTLS(conn, u.Hostname())
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		conn, err := tls.DialWithDialer(dialer, "tcp", host, c.tlsConfig(u.Hostname()))
+		if err != nil {
+			return nil, fmt.Errorf("there was an error connecting to the LDAP server %s:\r\n%s", host, err.Error())
+		}
+		return conn, nil
+	}
+	return nil, fmt.Errorf("%s is not an LDAP URL; use ldap:// or ldaps://", c.URL)
+}
+
+// startTLS upgrades a plain LDAP connection to TLS with the StartTLS extended operation
+func (c *LDAPConfig) startTLS(conn net.Conn, serverName string) (net.Conn, error) {
+	if err := conn.SetDeadline(time.Now().Add(ldapTimeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(ldapMessage(1, berSequence(ldapExtendedRequest, berString(0x80, ldapStartTLS)))); err != nil {
+		return nil, fmt.Errorf("there was an error sending the LDAP StartTLS request:\r\n%s", err.Error())
+	}
+	// The server sends nothing after its answer until the client starts the TLS handshake, so the reader does not
+	// buffer any of the handshake
+	tag, op, err := readLDAPMessage(bufio.NewReader(conn))
+	if err != nil {
+		return nil, err
+	}
+	if tag != ldapExtendedResponse {
+		return nil, fmt.Errorf("the LDAP server answered the StartTLS request with message 0x%x", tag)
+	}
+	if code, diagnostic, err := ldapResult(op); err != nil {
+		return nil, err
+	} else if code != 0 {
+		return nil, fmt.Errorf("the LDAP server refused StartTLS with result code %d: %s; use an ldaps:// URL, or set "+
+			"insecureNoStartTLS to send passwords in cleartext", code, diagnostic)
+	}
+	tlsConn := tls.Client(conn, c.tlsConfig(serverName))
+	if err = tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("there was an error starting TLS with the LDAP server:\r\n%s", err.Error())
+	}
+	return tlsConn, nil
+}
+
+// tlsConfig returns the TLS configuration used to connect to the LDAP server with the host name
+func (c *LDAPConfig) tlsConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: c.InsecureSkipVerify, // #nosec G402 - The server's operator chooses to skip verification
+		MinVersion:         tls.VersionTLS12,
+	}
+}
+
+// ldapMessage wraps a protocol operation in an LDAPMessage with the message ID
+func ldapMessage(id int, op []byte) []byte {
+	return berSequence(0x30, berInt(0x02, id), op)
+}
+
+// readLDAPMessage reads an LDAPMessage and returns its protocol operation's tag and contents
+func readLDAPMessage(r *bufio.Reader) (byte, []byte, error) {
+	tag, message, err := berReadFrom(r)
+	if err != nil {
+		return 0, nil, fmt.Errorf("there was an error reading the LDAP server's answer:\r\n%s", err.Error())
+	}
+	if tag != 0x30 {
+		return 0, nil, errors.New("the LDAP server's answer is not an LDAP message")
+	}
+	// Skip the message ID
+	if _, _, message, err = berRead(message); err != nil {
+		return 0, nil, err
+	}
+	tag, op, _, err := berRead(message)
+	return tag, op, err
+}
+
+// ldapResult returns the result code and diagnostic message of an LDAPResult
+func ldapResult(op []byte) (int, string, error) {
+	_, code, rest, err := berRead(op)
+	if err != nil {
+		return 0, "", err
+	}
+	// Skip the matched DN
+	if _, _, rest, err = berRead(rest); err != nil {
+		return 0, "", err
+	}
+	_, diagnostic, _, err := berRead(rest)
+	if err != nil {
+		return 0, "", err
+	}
+	var n int
+	for _, b := range code {
+		n = n<<8 | int(b)
+	}
+	return n, string(diagnostic), nil
+}
+
+// ldapAttribute returns the values of the attribute in a SearchResultEntry, ignoring the case of its name
+func ldapAttribute(entry []byte, name string) ([]string, error) {
+	// Skip the object name
+	_, _, rest, err := berRead(entry)
+	if err != nil {
+		return nil, err
+	}
+	_, attributes, _, err := berRead(rest)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for len(attributes) > 0 {
+		var attribute []byte
+		if _, attribute, attributes, err = berRead(attributes); err != nil {
+			return nil, err
+		}
+		_, attributeType, vals, err := berRead(attribute)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(string(attributeType), name) {
+			continue
+		}
+		if _, vals, _, err = berRead(vals); err != nil {
+			return nil, err
+		}
+		for len(vals) > 0 {
+			var v []byte
+			if _, v, vals, err = berRead(vals); err != nil {
+				return nil, err
+			}
+			values = append(values, string(v))
+		}
+	}
+	return values, nil
+}
+
+// berSequence returns a constructed BER element with the tag containing the elements
+func berSequence(tag byte, elements ...[]byte) []byte {
+	var content []byte
+	for _, e := range elements {
+		content = append(content, e...)
+	}
+	return berElement(tag, content)
+}
+
+// berString returns a BER element with the tag containing the string
+func berString(tag byte, s string) []byte {
+	return berElement(tag, []byte(s))
+}
+
+// berInt returns a BER INTEGER or ENUMERATED element with the tag containing the non-negative number
+func berInt(tag byte, n int) []byte {
+	content := []byte{byte(n)}
+	for n >>= 8; n > 0; n >>= 8 {
+		content = append([]byte{byte(n)}, content...)
+	}
+	// A leading bit that is set would make the number negative
+	if content[0]&0x80 != 0 {
+		content = append([]byte{0}, content...)
+	}
+	return berElement(tag, content)
+}
+
+// berElement returns a BER element with the tag, the length of the content in definite form, and the content
+func berElement(tag byte, content []byte) []byte {
+	element := []byte{tag}
+	if len(content) < 0x80 {
+		element = append(element, byte(len(content)))
+	} else {
+		var length []byte
+		for n := len(content); n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		element = append(element, 0x80|byte(len(length)))
+		element = append(element, length...)
+	}
+	return append(element, content...)
+}
+
+// berRead returns the tag and content of the BER element at the start of the data and the data after it
+func berRead(data []byte) (byte, []byte, []byte, error) {
+	if len(data) < 2 {
+		return 0, nil, nil, errors.New("the BER element is truncated")
+	}
+	tag, length, header := data[0], int(data[1]), 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(data) < 2+n {
+			return 0, nil, nil, errors.New("the BER element has an invalid length")
+		}
+		length = 0
+		for _, b := range data[2 : 2+n] {
+			length = length<<8 | int(b)
+		}
+		header += n
+	}
+	if length < 0 || len(data) < header+length {
+		return 0, nil, nil, errors.New("the BER element is truncated")
+	}
+	return tag, data[header : header+length], data[header+length:], nil
+}
+
+// berReadFrom reads a BER element from the reader and returns its tag and content
+func berReadFrom(r *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return 0, nil, errors.New("the BER element has an invalid length")
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, nil, err
+		}
+		length = 0
+		for _, v := range b {
+			length = length<<8 | int(v)
+		}
+	}
+	if length < 0 || length > 1<<24 {
+		return 0, nil, errors.New("the BER element is too large")
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, nil, err
+	}
+	return header[0], content, nil
+}
@@ -0,0 +1,134 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDCConfig authenticates operators with an OpenID Connect access token, which they enter as their password, by
+// asking the identity provider's userinfo endpoint who the token belongs to and which groups they are in
+type OIDCConfig struct {
+	Issuer        string `json:"issuer"`        // The identity provider's issuer URL, used to discover its userinfo endpoint
+	UserInfo      string `json:"userinfo"`      // The userinfo endpoint; discovered from the issuer if empty
+	UsernameClaim string `json:"usernameClaim"` // The claim that must match the operator's name; preferred_username if empty
+	GroupsClaim   string `json:"groupsClaim"`   // The claim with the operator's groups; groups if empty
+	discovery     sync.Mutex
+}
+
+// oidcClient is the HTTP client used to reach the identity provider
+var oidcClient = &http.Client{Timeout: 15 * time.Second}
+
+// Name returns the backend's name
+func (c *OIDCConfig) Name() string {
+	return "oidc"
+}
+
+// Authenticate asks the identity provider for the claims of the access token and returns the operator's groups if the
+// token belongs to the operator
+func (c *OIDCConfig) Authenticate(operator string, token string) (Identity, error) {
+	endpoint, err := c.userInfo()
+	if err != nil {
+		return Identity{}, err
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return Identity{}, fmt.Errorf("there was an error requesting the OIDC userinfo endpoint:\r\n%s", err.Error())
+	}
+	defer resp.Body.Close() // #nosec G307
+	// A password that is not an access token is rejected the same way as an expired or revoked token
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return Identity{}, errDenied
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Identity{}, fmt.Errorf("the OIDC userinfo endpoint returned %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, 1<<20))
+	if err != nil {
+		return Identity{}, fmt.Errorf("there was an error reading the OIDC userinfo response:\r\n%s", err.Error())
+	}
+	var claims map[string]interface{}
+	if err = json.Unmarshal(data, &claims); err != nil {
+		return Identity{}, fmt.Errorf("there was an error parsing the OIDC userinfo response:\r\n%s", err.Error())
+	}
+
+	usernameClaim, groupsClaim := c.UsernameClaim, c.GroupsClaim
+	if usernameClaim == "" {
+		usernameClaim = "preferred_username"
+	}
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	// Another person's token must not log in as this operator
+	if name, _ := claims[usernameClaim].(string); !strings.EqualFold(name, operator) {
+		return Identity{}, errDenied
+	}
+	identity := Identity{Name: operator}
+	switch groups := claims[groupsClaim].(type) {
+	case string:
+		identity.Groups = strings.Fields(groups)
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, s)
+			}
+		}
+	}
+	return identity, nil
+}
+
+// userInfo returns the userinfo endpoint, discovering it from the issuer's OpenID configuration the first time
+func (c *OIDCConfig) userInfo() (string, error) {
+	c.discovery.Lock()
+	defer c.discovery.Unlock()
+	if c.UserInfo != "" {
+		return c.UserInfo, nil
+	}
+	discovery := strings.TrimSuffix(c.Issuer, "/") + "/.well-known/openid-configuration"
+	resp, err := oidcClient.Get(discovery)
+	if err != nil {
+		return "", fmt.Errorf("there was an error requesting the OpenID configuration %s:\r\n%s", discovery, err.Error())
+	}
+	defer resp.Body.Close() // #nosec G307
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("the OpenID configuration %s returned %s", discovery, resp.Status)
+	}
+	var configuration struct {
+		UserInfo string `json:"userinfo_endpoint"`
+	}
+	if err = json.NewDecoder(http.MaxBytesReader(nil, resp.Body, 1<<20)).Decode(&configuration); err != nil {
+		return "", fmt.Errorf("there was an error parsing the OpenID configuration %s:\r\n%s", discovery, err.Error())
+	}
+	if configuration.UserInfo == "" {
+		return "", fmt.Errorf("the OpenID configuration %s does not have a userinfo endpoint", discovery)
+	}
+	c.UserInfo = configuration.UserInfo
+	return c.UserInfo, nil
+}
@@ -0,0 +1,164 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	// Standard
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	// 3rd Party
+	"golang.org/x/crypto/bcrypt"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+)
+
+// AccountsFile is the file the operator accounts and their bcrypt password hashes are kept in
+var AccountsFile = filepath.Join(core.CurrentDir, "data", "operators.json")
+
+// operatorName is the format of a valid operator name
+var operatorName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// dummyHash is compared against when an unknown operator logs in so the response takes as long as for a known one
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("merlin"), bcrypt.DefaultCost)
+
+// accounts guards reading and writing the accounts file
+var accounts sync.Mutex
+
+// load reads the operator accounts; a missing file has no accounts
+func load() (map[string]string, error) {
+	a := make(map[string]string)
+	data, err := ioutil.ReadFile(AccountsFile) // #nosec G304 - The accounts file is provided by the server
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("there was an error reading the operator accounts file:\r\n%s", err.Error())
+	}
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("there was an error parsing the operator accounts file %s:\r\n%s", AccountsFile,
+			err.Error())
+	}
+	return a, nil
+}
+
+// save writes the operator accounts so only the server's user can read them
+func save(a map[string]string) error {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return fmt.Errorf("there was an error encoding the operator accounts:\r\n%s", err.Error())
+	}
+	if err := os.MkdirAll(filepath.Dir(AccountsFile), 0750); err != nil {
+		return fmt.Errorf("there was an error creating the operator accounts directory:\r\n%s", err.Error())
+	}
+	if err := ioutil.WriteFile(AccountsFile, data, 0600); err != nil {
+		return fmt.Errorf("there was an error writing the operator accounts file:\r\n%s", err.Error())
+	}
+	return nil
+}
+
+// Operators returns the names of the operator accounts
+func Operators() []string {
+	accounts.Lock()
+	defer accounts.Unlock()
+	a, err := load()
+	if err != nil {
+		message("warn", err.Error())
+		return nil
+	}
+	var names []string
+	for name := range a {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddOperator creates an operator account with a new random password and returns the password. An existing account is
+// not changed; use ResetOperator to replace its password. Only the password's bcrypt hash is kept so it can not be shown
+// again.
+func AddOperator(name string) (string, error) {
+	if !operatorName.MatchString(name) {
+		return "", fmt.Errorf("invalid operator name %q; use up to 64 letters, numbers, periods, underscores, "+
+			"and hyphens", name)
+	}
+	return setPassword(name, false)
+}
+
+// ResetOperator replaces the password of an existing operator account with a new random password and returns it
+func ResetOperator(name string) (string, error) {
+	return setPassword(name, true)
+}
+
+// setPassword generates a new random password for the operator and saves its bcrypt hash. The account must already
+// exist to reset its password and must not exist to create it.
+func setPassword(name string, reset bool) (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("there was an error generating the operator's password:\r\n%s", err.Error())
+	}
+	password := base64.RawURLEncoding.EncodeToString(b)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("there was an error hashing the operator's password:\r\n%s", err.Error())
+	}
+
+	accounts.Lock()
+	defer accounts.Unlock()
+	a, err := load()
+	if err != nil {
+		return "", err
+	}
+	if _, ok := a[name]; ok && !reset {
+		return "", fmt.Errorf("%s is already an operator; use \"operators reset %s\" to replace its password", name,
+			name)
+	} else if !ok && reset {
+		return "", fmt.Errorf("%s is not an operator", name)
+	}
+	a[name] = string(hash)
+	return password, save(a)
+}
+
+// RemoveOperator deletes an operator account and disconnects the operator's sessions
+func RemoveOperator(name string) error {
+	accounts.Lock()
+	a, err := load()
+	if err == nil {
+		if _, ok := a[name]; !ok {
+			err = fmt.Errorf("%s is not an operator", name)
+		} else {
+			delete(a, name)
+			err = save(a)
+		}
+	}
+	accounts.Unlock()
+	if err != nil {
+		return err
+	}
+	Disconnect(name)
+	return nil
+}
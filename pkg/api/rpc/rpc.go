@@ -0,0 +1,338 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package rpc is the team server's operator API. Operators connect with TLS and log in with their own credentials; the
+// server tracks a session for each connection and attributes everything it does to the operator that logged in. A
+// connection is either an interactive console, which runs the server's command line interface for the operator, or a
+// JSON-RPC client of the Agents, Modules, and Operators services.
+//
+// Each connection starts with a single line of JSON, the Hello, and the server replies with a single line of JSON, the
+// Welcome. The rest of the connection is the console's terminal stream or JSON-RPC messages.
+//
+// The API is not gRPC. It uses the standard library's net/rpc with its JSON-RPC 1.0 codec, so the server does not
+// depend on gRPC, protocol buffers, or the newer golang.org/x modules they require. A client must send the Hello and
+// read the Welcome before it makes JSON-RPC calls; Go programs can use NewClient, which does both.
+package rpc
+
+import (
+	// Standard
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	netrpc "net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/fatih/color"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// helloSize is the largest Hello line the server reads before the operator has authenticated; it fits an OIDC access
+// token sent as the password
+const helloSize = 16384
+
+// helloTimeout is how long a new connection has to send its Hello
+const helloTimeout = 30 * time.Second
+
+// Hello is the first line a client sends to log in
+type Hello struct {
+	Operator string `json:"operator"` // The operator's name
+	Password string `json:"password"` // The operator's password, or OIDC access token
+	Console  bool   `json:"console"`  // True for an interactive console, false for a JSON-RPC client
+}
+
+// Welcome is the server's reply to a Hello
+type Welcome struct {
+	Session string `json:"session,omitempty"` // The ID of the operator's session if the login succeeded
+	Error   string `json:"error,omitempty"`   // Why the login failed
+}
+
+// Session is an operator's connection to the team server
+type Session struct {
+	ID       string    // The session's unique identifier
+	Operator string    // The authenticated operator the session belongs to
+	Role     string    // What the operator can do, operator or observer
+	Address  string    // The operator's remote address
+	Console  bool      // True if the session is an interactive console
+	Created  time.Time // When the operator logged in
+	LastSeen time.Time // When the operator last sent a command or request
+	conn     net.Conn
+}
+
+// Console runs an interactive command line interface for an authenticated operator over the connection and returns
+// when the operator exits or the connection is closed
+type Console func(session Session, conn net.Conn)
+
+// sessions are the operators currently connected to the team server
+var sessions = struct {
+	sync.Mutex
+	m map[string]*Session
+}{m: make(map[string]*Session)}
+
+// Listen starts the team server's operator listener on the address with the x.509 certificate and key files. An
+// ephemeral certificate is created if the certificate file does not exist. Console sessions are handed to the console
+// function. The listener runs until the server exits.
+func Listen(addr string, certificate string, key string, console Console) error {
+	var cer tls.Certificate
+	if _, err := os.Stat(certificate); os.IsNotExist(err) {
+		message("note", fmt.Sprintf("No certificate found at %s; creating an in-memory x.509 certificate for the "+
+			"team server used for this session only", certificate))
+		c, errC := util.GenerateTLSCert(nil, nil, nil, nil, nil, nil, true)
+		if errC != nil {
+			return fmt.Errorf("there was an error generating the team server's certificate:\r\n%s", errC.Error())
+		}
+		cer = *c
+	} else {
+		cer, err = tls.LoadX509KeyPair(certificate, key)
+		if err != nil {
+			return fmt.Errorf("there was an error loading the team server's certificate:\r\n%s", err.Error())
+		}
+	}
+
+	l, err := tls.Listen("tcp", addr, &tls.Config{Certificates: []tls.Certificate{cer}, MinVersion: tls.VersionTLS12})
+	if err != nil {
+		return fmt.Errorf("there was an error starting the team server listener on %s:\r\n%s", addr, err.Error())
+	}
+
+	fingerprint := sha256.Sum256(cer.Certificate[0])
+	message("info", fmt.Sprintf("Team server listening for operators on %s with certificate SHA256 fingerprint %s",
+		addr, hex.EncodeToString(fingerprint[:])))
+	logging.Server(fmt.Sprintf("Team server listening for operators on %s with certificate SHA256 fingerprint %s",
+		addr, hex.EncodeToString(fingerprint[:])))
+	if len(Operators()) == 0 {
+		message("warn", "There are no operator accounts; use \"operators add <name>\" to create one")
+	}
+
+	go func() {
+		for {
+			conn, errA := l.Accept()
+			if errA != nil {
+				message("warn", fmt.Sprintf("The team server listener stopped:\r\n%s", errA.Error()))
+				return
+			}
+			go handle(conn, console)
+		}
+	}()
+	return nil
+}
+
+// handle logs the operator in and serves their console or JSON-RPC requests until the connection is closed
+func handle(conn net.Conn, console Console) {
+	defer conn.Close() // #nosec G307
+
+	if err := conn.SetDeadline(time.Now().Add(helloTimeout)); err != nil {
+		return
+	}
+	hello, err := readHello(conn)
+	if err != nil {
+		logging.Server(fmt.Sprintf("Team server connection from %s did not log in: %s", conn.RemoteAddr(), err.Error()))
+		return
+	}
+	var w Welcome
+	role, err := authenticate(hello.Operator, hello.Password)
+	if err == nil && hello.Console && role != RoleOperator {
+		err = fmt.Errorf("operator %s is an %s and can not open a console", hello.Operator, role)
+	}
+	if err != nil {
+		// The reason is not sent so a client can not tell an unknown operator from a wrong password
+		w.Error = errDenied.Error()
+		logging.Server(fmt.Sprintf("Failed team server login for operator %q from %s: %s", hello.Operator,
+			conn.RemoteAddr(), err.Error()))
+		message("warn", fmt.Sprintf("Failed team server login for operator %q from %s: %s", hello.Operator,
+			conn.RemoteAddr(), err.Error()))
+		_ = writeWelcome(conn, w)
+		return
+	}
+
+	s := login(hello.Operator, role, conn, hello.Console)
+	defer logout(s.ID)
+	w.Session = s.ID
+	if err := writeWelcome(conn, w); err != nil {
+		return
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return
+	}
+
+	if hello.Console {
+		console(*s, conn)
+		return
+	}
+
+	// Every service is bound to this session so requests are always attributed to the operator that logged in
+	server := netrpc.NewServer()
+	for name, service := range map[string]interface{}{
+		"Agents":    &Agents{session: s.ID},
+		"Modules":   &Modules{session: s.ID},
+		"Operators": &OperatorService{session: s.ID},
+	} {
+		if err := server.RegisterName(name, service); err != nil {
+			message("warn", fmt.Sprintf("There was an error registering the %s service:\r\n%s", name, err.Error()))
+			return
+		}
+	}
+	server.ServeCodec(jsonrpc.NewServerCodec(conn))
+}
+
+// readLine reads a line one byte at a time so nothing after it is consumed from the connection
+func readLine(conn net.Conn) ([]byte, error) {
+	var line bytes.Buffer
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return nil, err
+		}
+		if b[0] == '\n' {
+			return line.Bytes(), nil
+		}
+		if line.Len() >= helloSize {
+			return nil, errors.New("the line is too large")
+		}
+		line.WriteByte(b[0])
+	}
+}
+
+// readHello reads the Hello line a client logs in with
+func readHello(conn net.Conn) (Hello, error) {
+	var hello Hello
+	line, err := readLine(conn)
+	if err != nil {
+		return hello, err
+	}
+	if err := json.Unmarshal(line, &hello); err != nil {
+		return hello, fmt.Errorf("there was an error parsing the hello message:\r\n%s", err.Error())
+	}
+	return hello, nil
+}
+
+// writeWelcome sends the Welcome line to the client
+func writeWelcome(conn net.Conn, w Welcome) error {
+	data, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(data, '\n'))
+	return err
+}
+
+// login creates a session for an authenticated operator with their role
+func login(operator string, role string, conn net.Conn, console bool) *Session {
+	s := &Session{
+		ID:       uuid.NewV4().String(),
+		Operator: operator,
+		Role:     role,
+		Address:  conn.RemoteAddr().String(),
+		Console:  console,
+		Created:  time.Now().UTC(),
+		LastSeen: time.Now().UTC(),
+		conn:     conn,
+	}
+	sessions.Lock()
+	sessions.m[s.ID] = s
+	sessions.Unlock()
+	message("success", fmt.Sprintf("Operator %s logged in to the team server from %s as an %s", operator, s.Address,
+		role))
+	logging.Server(fmt.Sprintf("Operator %s logged in to the team server from %s as an %s with session %s", operator,
+		s.Address, role, s.ID))
+	return s
+}
+
+// logout ends a session
+func logout(id string) {
+	sessions.Lock()
+	s, ok := sessions.m[id]
+	delete(sessions.m, id)
+	sessions.Unlock()
+	if ok {
+		message("note", fmt.Sprintf("Operator %s logged out of the team server", s.Operator))
+		logging.Server(fmt.Sprintf("Operator %s logged out of the team server session %s", s.Operator, s.ID))
+	}
+}
+
+// Seen updates the last time the session's operator sent a command or request and returns the session's operator
+func Seen(id string) string {
+	o, _ := seen(id)
+	return o
+}
+
+// seen updates the last time the session's operator sent a command or request and returns the session's operator and
+// their role
+func seen(id string) (string, string) {
+	sessions.Lock()
+	defer sessions.Unlock()
+	s, ok := sessions.m[id]
+	if !ok {
+		return "", ""
+	}
+	s.LastSeen = time.Now().UTC()
+	return s.Operator, s.Role
+}
+
+// Sessions returns the operators currently connected to the team server, oldest first
+func Sessions() []Session {
+	sessions.Lock()
+	defer sessions.Unlock()
+	var list []Session
+	for _, s := range sessions.m {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+// Disconnect closes every session that belongs to the operator and returns how many were closed
+func Disconnect(operator string) int {
+	sessions.Lock()
+	defer sessions.Unlock()
+	var n int
+	for _, s := range sessions.m {
+		if s.Operator == operator {
+			_ = s.conn.Close()
+			n++
+		}
+	}
+	return n
+}
+
+// message is used to print a message to the command line
+func message(level string, message string) {
+	switch level {
+	case "info":
+		color.Cyan("[i]" + message)
+	case "note":
+		color.Yellow("[-]" + message)
+	case "warn":
+		color.Red("[!]" + message)
+	case "success":
+		color.Green("[+]" + message)
+	default:
+		color.Red("[_-_]Invalid message level: " + message)
+	}
+}
@@ -0,0 +1,119 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	// Standard
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loginPipe sends a Hello over an in-memory connection handled by the server and returns the client's side and the Welcome
+func loginPipe(t *testing.T, hello Hello) (net.Conn, Welcome) {
+	client, server := net.Pipe()
+	go handle(server, func(Session, net.Conn) {})
+	data, err := json.Marshal(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Write(append(data, '\n')); err != nil {
+		t.Fatal(err)
+	}
+	w, err := readWelcome(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, w
+}
+
+// TestLogin verifies only an operator's own password logs them in and their requests are attributed to them
+func TestLogin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merlin-rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // #nosec G104
+	defer func(f string) { AccountsFile = f }(AccountsFile)
+	AccountsFile = filepath.Join(dir, "operators.json")
+
+	alice, err := AddOperator("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = AddOperator("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = AddOperator("../bob"); err == nil {
+		t.Error("an operator with an invalid name was created")
+	}
+	// An existing operator's password is only replaced by a reset
+	if _, err = AddOperator("alice"); err == nil {
+		t.Error("an existing operator was added again")
+	}
+	if _, err = ResetOperator("mallory"); err == nil {
+		t.Error("the password of an unknown operator was reset")
+	}
+	bob, err := ResetOperator("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn, w := loginPipe(t, Hello{Operator: "bob", Password: bob}); w.Error != "" {
+		t.Errorf("bob could not log in with the reset password: %s", w.Error)
+	} else {
+		_ = conn.Close()
+	}
+
+	// Another operator's password or an unknown operator does not log in
+	for _, h := range []Hello{{Operator: "bob", Password: alice}, {Operator: "mallory", Password: alice}} {
+		conn, w := loginPipe(t, h)
+		if w.Error == "" || w.Session != "" {
+			t.Errorf("operator %s logged in with alice's password", h.Operator)
+		}
+		_ = conn.Close()
+	}
+
+	conn, w := loginPipe(t, Hello{Operator: "alice", Password: alice})
+	if w.Error != "" {
+		t.Fatal(w.Error)
+	}
+	c := jsonrpc.NewClient(conn)
+	var list []Session
+	if err = c.Call("Operators.Sessions", struct{}{}, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != w.Session || list[0].Operator != "alice" {
+		t.Errorf("expected alice's session %s, got %+v", w.Session, list)
+	}
+
+	// Removing the operator ends their session
+	if err = RemoveOperator("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Call("Operators.Sessions", struct{}{}, &list); err == nil {
+		t.Error("the session of a removed operator was not ended")
+	}
+	_ = c.Close()
+	if names := Operators(); len(names) != 1 || names[0] != "bob" {
+		t.Errorf("expected only bob to be an operator, got %v", names)
+	}
+}
@@ -0,0 +1,283 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	// Standard
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/audit"
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/modules"
+)
+
+// Agent is an agent as it is returned by the Agents service
+type Agent struct {
+	ID            string
+	Platform      string
+	Architecture  string
+	UserName      string
+	HostName      string
+	Transport     string
+	Status        string
+	StatusCheckIn time.Time
+}
+
+// JobArgs are the arguments to create a job for an agent
+type JobArgs struct {
+	Agent string   // The agent's ID, or "all" for every agent
+	Type  string   // The job type, such as "cmd"
+	Args  []string // The job's arguments
+}
+
+// ModuleArgs are the arguments to run a module
+type ModuleArgs struct {
+	Module  string            // The module's path in the modules directory, such as "windows/x64/powershell/..."
+	Agent   string            // The agent's ID, or "all" for every agent
+	Options map[string]string // The module's option values
+}
+
+// Agents is the JSON-RPC service to list, task, and remove agents
+type Agents struct {
+	session string
+}
+
+// Modules is the JSON-RPC service to list and run modules
+type Modules struct {
+	session string
+}
+
+// OperatorService is the JSON-RPC service, registered as Operators, to list the connected operators
+type OperatorService struct {
+	session string
+}
+
+// operator returns the operator of the session the service is bound to or an error if the session was ended
+func operator(session string) (string, error) {
+	o := Seen(session)
+	if o == "" {
+		return "", errors.New("the session has ended")
+	}
+	return o, nil
+}
+
+// tasker returns the operator of the session the service is bound to or an error if the session was ended or the
+// operator is an observer, who can not change anything
+func tasker(session string) (string, error) {
+	o, role := seen(session)
+	if o == "" {
+		return "", errors.New("the session has ended")
+	}
+	if role != RoleOperator {
+		return "", fmt.Errorf("operator %s is an %s and can only list", o, role)
+	}
+	return o, nil
+}
+
+// List returns every agent
+func (a *Agents) List(_ struct{}, reply *[]Agent) error {
+	if _, err := operator(a.session); err != nil {
+		return err
+	}
+	list := make([]Agent, 0)
+	for id, v := range agents.Agents {
+		list = append(list, Agent{
+			ID:            id.String(),
+			Platform:      v.Platform,
+			Architecture:  v.Architecture,
+			UserName:      v.UserName,
+			HostName:      v.HostName,
+			Transport:     v.Proto,
+			Status:        agents.GetAgentStatus(id),
+			StatusCheckIn: v.StatusCheckIn,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	*reply = list
+	return nil
+}
+
+// AddJob creates a job for an agent and returns the job's ID
+func (a *Agents) AddJob(args JobArgs, reply *string) error {
+	o, err := tasker(a.session)
+	if err != nil {
+		return err
+	}
+	id, err := agentID(args.Agent)
+	if err != nil {
+		return err
+	}
+	record(o, a.session, id, args.Type, args.Args)
+	job, err := agents.AddJob(id, args.Type, args.Args)
+	if err != nil {
+		return err
+	}
+	attribute(o, id, job)
+	*reply = job
+	return nil
+}
+
+// Remove deletes an agent from the server
+func (a *Agents) Remove(agent string, _ *struct{}) error {
+	o, err := tasker(a.session)
+	if err != nil {
+		return err
+	}
+	id, err := uuid.FromString(agent)
+	if err != nil {
+		return fmt.Errorf("invalid agent ID %s", agent)
+	}
+	record(o, a.session, id, "remove", nil)
+	if err := agents.RemoveAgent(id); err != nil {
+		return err
+	}
+	logging.Server(fmt.Sprintf("Operator %s removed agent %s", o, id))
+	return nil
+}
+
+// List returns the path of every module in the modules directory
+func (m *Modules) List(_ struct{}, reply *[]string) error {
+	if _, err := operator(m.session); err != nil {
+		return err
+	}
+	list := modules.GetModuleList()("")
+	sort.Strings(list)
+	*reply = list
+	return nil
+}
+
+// Run sets the module's agent and options, runs it, and returns the ID of the job it created
+func (m *Modules) Run(args ModuleArgs, reply *string) error {
+	o, err := tasker(m.session)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(args.Module, "..") {
+		return fmt.Errorf("invalid module %s", args.Module)
+	}
+	options := []string{args.Module}
+	for name, value := range args.Options {
+		options = append(options, name+"="+value)
+	}
+	sort.Strings(options[1:])
+	id, _ := agentID(args.Agent) // An invalid agent is reported by SetAgent below
+	record(o, m.session, id, "module", options)
+	module, err := modules.Create(path.Join(core.CurrentDir, "data", "modules", args.Module+".json"))
+	if err != nil {
+		return err
+	}
+	if _, err := module.SetAgent(args.Agent); err != nil {
+		return err
+	}
+	for name, value := range args.Options {
+		if _, err := module.SetOption(name, value); err != nil {
+			return err
+		}
+	}
+	r, err := module.Run()
+	if err != nil {
+		return err
+	}
+	if len(r) <= 0 {
+		return fmt.Errorf("the %s module did not return a command to task an agent with", module.Name)
+	}
+	var job string
+	j := module.Job(r)
+	if module.Fanout() {
+		// A module run on every agent, or a list of agents, is only sent to the agents it runs on
+		compatible, _, err := module.RunAgents()
+		if err != nil {
+			return err
+		}
+		ids, failed := agents.AddGroupJobsEach(compatible, []agents.Job{j})
+		for _, id := range compatible {
+			if _, ok := failed[id]; !ok {
+				agents.TrackAttack(ids[id][0], module.Name, module.Attack)
+				if job == "" {
+					job = ids[id][0]
+				}
+			}
+		}
+		if job == "" {
+			return fmt.Errorf("no %s module jobs were created for the %d agents", module.Name, len(compatible))
+		}
+	} else {
+		if job, err = agents.AddJob(module.Agent, j.Type, j.Args); err != nil {
+			return err
+		}
+		agents.TrackAttack(job, module.Name, module.Attack)
+	}
+	if module.Fanout() {
+		module.Agent = uuid.FromStringOrNil("ffffffff-ffff-ffff-ffff-ffffffffffff")
+	}
+	attribute(o, module.Agent, job)
+	*reply = job
+	return nil
+}
+
+// Sessions returns the operators connected to the team server
+func (s *OperatorService) Sessions(_ struct{}, reply *[]Session) error {
+	if _, err := operator(s.session); err != nil {
+		return err
+	}
+	*reply = Sessions()
+	return nil
+}
+
+// agentID parses an agent ID, where "all" is every agent
+func agentID(agent string) (uuid.UUID, error) {
+	if strings.ToLower(agent) == "all" {
+		agent = "ffffffff-ffff-ffff-ffff-ffffffffffff"
+	}
+	id, err := uuid.FromString(agent)
+	if err != nil {
+		return id, fmt.Errorf("invalid agent ID %s", agent)
+	}
+	return id, nil
+}
+
+// record adds the operator's action to the audit log
+func record(operator string, session string, agent uuid.UUID, command string, args []string) {
+	e := audit.Entry{Client: operator, Session: session, Command: command, Args: args}
+	if agent != uuid.Nil {
+		e.Agent = agent.String()
+	}
+	if err := audit.Record(e); err != nil {
+		logging.Server(err.Error())
+		message("warn", err.Error())
+	}
+}
+
+// attribute records the operator that created a job in the agent's log and the server's log
+func attribute(operator string, agent uuid.UUID, job string) {
+	if agent.String() != "ffffffff-ffff-ffff-ffff-ffffffffffff" {
+		agents.Log(agent, fmt.Sprintf("Job %s was created by operator %s", job, operator))
+	}
+	logging.Server(fmt.Sprintf("Operator %s created job %s for agent %s", operator, job, agent))
+}
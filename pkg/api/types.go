@@ -0,0 +1,170 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	// Standard
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// AllAgents is the agent ID that creates a job, or runs a module, for every agent
+var AllAgents = uuid.FromStringOrNil("ffffffff-ffff-ffff-ffff-ffffffffffff")
+
+// Agent is an agent as the REST API lists it
+type Agent struct {
+	ID           uuid.UUID `json:"id"`
+	Platform     string    `json:"platform"`     // The agent's operating system (i.e. windows, linux, or darwin)
+	Architecture string    `json:"architecture"` // The agent's CPU architecture (i.e. amd64)
+	UserName     string    `json:"username"`     // The user the agent runs as
+	HostName     string    `json:"hostname"`
+	Proto        string    `json:"proto"`       // The protocol the agent communicates with (i.e. h2, hq, dns, or tcp)
+	Status       string    `json:"status"`      // Active, Delayed, Dead, or Pending
+	LastCheckIn  time.Time `json:"lastcheckin"` // The time of the agent's last status check in
+}
+
+// AgentDetail is all of an agent's information except its key material
+type AgentDetail struct {
+	Agent
+	UserGUID       string    `json:"userguid"`
+	Pid            int       `json:"pid"`
+	Ips            []string  `json:"ips"`
+	Interpreters   []string  `json:"interpreters"` // The scripting language interpreters found on the agent's host
+	InitialCheckIn time.Time `json:"initialcheckin"`
+	Version        string    `json:"version"`
+	Build          string    `json:"build"`
+	WaitTime       string    `json:"waittime"`               // The agent's sleep between check ins as a duration (i.e. 30s)
+	Skew           int64     `json:"skew"`                   // The most milliseconds added to the agent's sleep at random
+	Jitter         int       `json:"jitter"`                 // The most percent the agent's sleep is randomly shortened or lengthened
+	WorkingHours   string    `json:"workinghours,omitempty"` // The hours, such as 0900-1700 Mon-Fri, the agent only checks in during
+	DelayedAfter   float64   `json:"delayedafter"`           // Check in intervals the agent can miss before it is delayed
+	DeadAfter      float64   `json:"deadafter"`              // Check in intervals the agent can miss before it is dead
+	PaddingMax     int       `json:"paddingmax"`
+	MaxRetry       int       `json:"maxretry"`
+	FailedCheckin  int       `json:"failedcheckin"`
+	KillDate       int64     `json:"killdate"` // The Unix time the agent exits at; 0 if it does not have one
+	Watermark      string    `json:"watermark"`
+	ForkedFrom     uuid.UUID `json:"forkedfrom"` // The agent this agent was cloned from; the nil UUID if it was not
+}
+
+// JobRequest is a job to create for an agent
+type JobRequest struct {
+	Type     string    `json:"type"`               // The job type (i.e. cmd, sleep, or download)
+	Args     []string  `json:"args"`               // The job's arguments (i.e. ["whoami"] for a cmd job)
+	After    time.Time `json:"after"`              // The job is not sent to the agent before this time; zero sends it on the next check in
+	Priority string    `json:"priority,omitempty"` // low, normal, high, or urgent; jobs with a higher priority are sent first
+}
+
+// Job is a job that was created for an agent
+type Job struct {
+	ID    string    `json:"job"`
+	Agent uuid.UUID `json:"agent"`
+}
+
+// Listener is an agent listener
+type Listener struct {
+	ID        uuid.UUID `json:"id"`
+	Interface string    `json:"interface"` // The IP address the listener binds to
+	Port      int       `json:"port"`
+	Protocol  string    `json:"protocol"` // The HTTP protocol agents connect with: h2 or hq
+}
+
+// Module is a module and the options it is run with
+type Module struct {
+	Name        string         `json:"name"`
+	Type        string         `json:"type"` // standard modules run a command; extended modules create another job type
+	Author      []string       `json:"author"`
+	Credits     []string       `json:"credits"`
+	Path        []string       `json:"path"`
+	Platform    string         `json:"platform"` // The platform the module runs on (i.e. Windows, Linux, Darwin, or ALL)
+	Arch        string         `json:"arch"`
+	Lang        string         `json:"lang"`
+	Priv        bool           `json:"privilege"` // The module needs a privileged account such as root or SYSTEM
+	Description string         `json:"description"`
+	Notes       string         `json:"notes"`
+	Options     []ModuleOption `json:"options"`
+}
+
+// ModuleOption is an option a module is run with
+type ModuleOption struct {
+	Name        string `json:"name"`
+	Value       string `json:"value"` // The option's default value
+	Required    bool   `json:"required"`
+	Description string `json:"description"`
+}
+
+// ModuleRun is the agent and options to run a module with
+type ModuleRun struct {
+	Agent   string            `json:"agent"`   // The agent ID, or all for every agent
+	Options map[string]string `json:"options"` // Option values by name; options that are not set keep their default
+	After   time.Time         `json:"after"`   // The job is not sent to the agent before this time
+}
+
+// Message is a server or agent log entry
+type Message struct {
+	ID      uint64    `json:"id"` // Sequence number of the entry; starts at 1 when the server starts
+	Time    time.Time `json:"time"`
+	Agent   string    `json:"agent,omitempty"` // The agent the entry was logged for; empty for server entries
+	Message string    `json:"message"`
+}
+
+// Event is an entry in the server's structured event log, which is kept across restarts
+type Event struct {
+	ID      uint64    `json:"id"`
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`            // The kind of event: agent, checkin, job, or listener
+	Agent   string    `json:"agent,omitempty"` // The agent the event is for; empty for listener events
+	Message string    `json:"message"`
+}
+
+// EventFilter selects events from the event log; empty fields match every event
+type EventFilter struct {
+	Agent string
+	Type  string
+	Since time.Time // Events at or after this time
+	Until time.Time // Events before this time
+	After uint64    // Events with a greater ID, used to only return new events
+}
+
+// LootFile is a file in an agent's directory, such as its log or a file downloaded from it
+type LootFile struct {
+	Agent    string    `json:"agent"`
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// Score is a capture-the-flag score awarded for an agent's job output
+type Score struct {
+	Rule     string    `json:"rule"`
+	Points   int       `json:"points"`
+	Agent    string    `json:"agent"`
+	Host     string    `json:"host"`
+	User     string    `json:"user"`
+	Job      string    `json:"job"`
+	Command  string    `json:"command"`
+	Evidence string    `json:"evidence"` // The output that matched the rule
+	Time     time.Time `json:"time"`
+}
+
+// Result is the outcome of a request that does not return an item
+type Result struct {
+	Result string `json:"result"`
+}
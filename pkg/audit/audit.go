@@ -0,0 +1,224 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package audit records every operator action, with the operator, time, target agent, and arguments, to an
+// append-only log for engagement reporting. Each entry holds the SHA-256 hash of the entry before it, so changing,
+// removing, or reordering an entry breaks the hash chain from that entry on.
+package audit
+
+import (
+	// Standard
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/redact"
+)
+
+// File is the file audit entries are appended to, one JSON object per line
+var File = filepath.Join(core.CurrentDir, "data", "log", "audit.json")
+
+// Client IDs of the actions that were not taken by a team server operator
+const (
+	Console = "console" // A command typed into the server's own console
+	API     = "api"     // A REST API request
+)
+
+// Entry is an action an operator took
+type Entry struct {
+	ID       uint64    `json:"id"` // Sequence number of the entry; starts at 1 and continues across restarts
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`            // The team server operator, Console, or the API that took the action
+	Session  string    `json:"session,omitempty"` // The team server session, or the API client's address
+	Agent    string    `json:"agent,omitempty"`   // The agent the action targeted; empty if it did not target one
+	Menu     string    `json:"menu,omitempty"`    // The menu the command was typed in
+	Command  string    `json:"command"`
+	Args     []string  `json:"args,omitempty"`
+	Previous string    `json:"previous"` // The hash of the entry before this one; empty for the first entry
+	Hash     string    `json:"hash"`     // The SHA-256 hash of this entry with an empty Hash field
+}
+
+// Filter selects entries; empty fields match every entry
+type Filter struct {
+	Client string
+	Agent  string
+	Since  time.Time // Entries at or after this time
+	Until  time.Time // Entries before this time
+}
+
+// log is the open audit file and the ID and hash of the last entry written to it
+var log = struct {
+	sync.Mutex
+	file *os.File
+	last uint64
+	head string
+}{}
+
+// Record appends an action to the audit log. The ID, time, and hashes are set by Record, and secrets in the command
+// line are masked with the redaction patterns.
+func Record(e Entry) error {
+	log.Lock()
+	defer log.Unlock()
+	if log.file == nil {
+		if err := open(); err != nil {
+			return err
+		}
+	}
+	line := strings.Join(append([]string{e.Command}, e.Args...), " ")
+	if masked := redact.String(line); masked != line {
+		e.Args = strings.Fields(strings.TrimPrefix(masked, e.Command))
+	}
+	e.ID, e.Time, e.Previous = log.last+1, time.Now().UTC(), log.head
+	e.Hash = digest(e)
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("there was an error marshalling the audit entry:\r\n%s", err.Error())
+	}
+	if _, err = log.file.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("there was an error writing to the audit log %s:\r\n%s", File, err.Error())
+	}
+	log.last, log.head = e.ID, e.Hash
+	return nil
+}
+
+// digest returns the hex encoded SHA-256 hash of the entry with an empty Hash field
+func digest(e Entry) string {
+	e.Hash = ""
+	b, _ := json.Marshal(e) // #nosec G104 an Entry always marshals
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// open opens the audit file for appending and continues the chain after the last entry in it
+func open() error {
+	if err := os.MkdirAll(filepath.Dir(File), 0750); err != nil {
+		return fmt.Errorf("there was an error creating the audit log directory:\r\n%s", err.Error())
+	}
+	var last *Entry
+	err := scan(func(_ int, e *Entry) error {
+		if e != nil {
+			last = e
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if last != nil {
+		log.last, log.head = last.ID, last.Hash
+	}
+	f, err := os.OpenFile(File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("there was an error opening the audit log %s:\r\n%s", File, err.Error())
+	}
+	log.file = f
+	return nil
+}
+
+// scan calls the function with every line number and entry in the audit file; the entry is nil if the line is not a
+// valid entry. A missing file has no entries.
+func scan(f func(line int, e *Entry) error) error {
+	r, err := os.Open(File) // #nosec G304 the audit file is set by the server
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("there was an error opening the audit log %s:\r\n%s", File, err.Error())
+	}
+	defer r.Close() // #nosec G307
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	var n int
+	for scanner.Scan() {
+		n++
+		var e Entry
+		var entry *Entry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			entry = &e
+		}
+		if err = f(n, entry); err != nil {
+			return err
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("there was an error reading the audit log %s:\r\n%s", File, err.Error())
+	}
+	return nil
+}
+
+// Query returns the entries that match the filter, oldest first
+func Query(f Filter) ([]Entry, error) {
+	log.Lock()
+	defer log.Unlock()
+	list := make([]Entry, 0)
+	err := scan(func(_ int, e *Entry) error {
+		if e != nil && f.Match(*e) {
+			list = append(list, *e)
+		}
+		return nil
+	})
+	return list, err
+}
+
+// Match returns true if the entry is selected by the filter
+func (f Filter) Match(e Entry) bool {
+	switch {
+	case f.Client != "" && f.Client != e.Client:
+		return false
+	case f.Agent != "" && !strings.EqualFold(f.Agent, e.Agent):
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !e.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// Verify checks the hash chain of the audit log and returns the number of entries and the hash of the last one. An
+// error is returned for the first line that is not an entry, was changed, or does not follow the entry before it.
+// Entries removed from the end of the log can only be detected by comparing the hash with one recorded earlier.
+func Verify() (int, string, error) {
+	log.Lock()
+	defer log.Unlock()
+	var count int
+	var previous Entry
+	err := scan(func(line int, e *Entry) error {
+		switch {
+		case e == nil:
+			return fmt.Errorf("line %d of the audit log is not a valid entry", line)
+		case e.Hash != digest(*e):
+			return fmt.Errorf("entry %d on line %d was changed after it was recorded", e.ID, line)
+		case e.ID != previous.ID+1 || e.Previous != previous.Hash:
+			return fmt.Errorf("entry %d on line %d does not follow entry %d; entries were removed, added, or reordered",
+				e.ID, line, previous.ID)
+		}
+		count++
+		previous = *e
+		return nil
+	})
+	return count, previous.Hash, err
+}
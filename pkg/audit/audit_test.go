@@ -0,0 +1,75 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	// Standard
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestVerify verifies entries are chained, secrets are masked, and a changed or removed entry breaks the chain
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	File = filepath.Join(dir, "audit.json")
+
+	for _, e := range []Entry{
+		{Client: Console, Menu: "main", Command: "listeners"},
+		{Client: "alice", Session: "1", Agent: "a", Menu: "agent", Command: "runas", Args: []string{"bob", "Summer2019!", "whoami"}},
+		{Client: "alice", Session: "1", Agent: "a", Menu: "agent", Command: "ls", Args: []string{"C:\\"}},
+	} {
+		if err := Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count, head, err := Verify()
+	if err != nil || count != 3 || head == "" {
+		t.Fatalf("the audit log did not verify with 3 entries: %d %s %v", count, head, err)
+	}
+	list, err := Query(Filter{Client: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || strings.Contains(strings.Join(list[0].Args, " "), "Summer2019!") {
+		t.Fatalf("the query did not return alice's entries with the password masked: %+v", list)
+	}
+
+	data, err := ioutil.ReadFile(File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	for name, tampered := range map[string]string{
+		"changed": lines[0] + strings.Replace(lines[1], `"whoami"`, `"hostname"`, 1) + lines[2],
+		"removed": lines[0] + lines[2],
+	} {
+		if err := ioutil.WriteFile(File, []byte(tampered), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := Verify(); err == nil {
+			t.Errorf("the audit log with a %s entry verified", name)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package backup archives the server's engagement data to an encrypted tarball for disaster recovery
+package backup
+
+import (
+	// Standard
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/config"
+	"github.com/Ne0nd0g/merlin/pkg/core"
+)
+
+// Directories are the data directory folders archived in a backup: the database, agent logs and downloaded files
+// (loot), and server logs
+var Directories = []string{"db", "agents", "log"}
+
+// Remote is the directory or HTTP(S) URL backups are copied to when one is not provided
+var Remote string
+
+// magic identifies an encrypted Merlin backup file and its format version
+var magic = []byte("MBK1")
+
+// chunkSize is the amount of plaintext encrypted in each chunk so large backups are not held in memory
+const chunkSize = 64 * 1024
+
+// schedule is the running scheduled snapshot, if any
+var schedule struct {
+	sync.Mutex
+	interval time.Duration
+	stop     chan struct{}
+}
+
+// Options are the settings used to create a backup
+type Options struct {
+	DataDir string // The Merlin data directory to archive
+	KeyFile string // The file containing the 32 byte encryption key; it is created if it does not exist
+	Remote  string // Optional directory or HTTP(S) URL the backup is copied to with a PUT request
+}
+
+// KeyFile returns the default backup key file. It is outside of the archived directories so a backup never contains the
+// key needed to decrypt it; keep a copy of it somewhere other than the server.
+func KeyFile(dataDir string) string {
+	return filepath.Join(dataDir, "x509", "backup.key")
+}
+
+// Now archives and encrypts the engagement data, writes it to the data directory's backups folder, copies it to the
+// remote storage if one was provided, and returns the backup file path
+func Now(o Options) (string, error) {
+	return NowContext(context.Background(), o)
+}
+
+// NowContext creates a backup like Now; the partial backup is removed if the context is cancelled while the data is
+// archived, and the copy to remote storage stops if it is cancelled while the backup is copied
+func NowContext(ctx context.Context, o Options) (string, error) {
+	key, err := config.Key(o.KeyFile, "backup")
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(o.DataDir, "backups")
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", fmt.Errorf("there was an error creating the backups directory:\r\n%s", err.Error())
+	}
+	name := filepath.Join(dir, fmt.Sprintf("merlin_backup_%s.tar.gz.enc", time.Now().UTC().Format("20060102T150405Z")))
+
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600) // #nosec G304 - The name is created above
+	if err != nil {
+		return "", fmt.Errorf("there was an error creating the backup file:\r\n%s", err.Error())
+	}
+
+	w, err := newWriter(f, key)
+	if err == nil {
+		err = archive(ctx, w, o.DataDir)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		_ = os.Remove(name)
+		return "", fmt.Errorf("there was an error creating the backup:\r\n%s", err.Error())
+	}
+
+	if o.Remote != "" {
+		if err := push(ctx, name, o.Remote); err != nil {
+			return name, fmt.Errorf("the backup %s was created but could not be copied to %s:\r\n%s", name, o.Remote,
+				err.Error())
+		}
+	}
+	return name, nil
+}
+
+// Default returns the options for backing up Merlin's data directory with the default key file and remote storage
+func Default() Options {
+	dataDir := filepath.Join(core.CurrentDir, "data")
+	return Options{DataDir: dataDir, KeyFile: KeyFile(dataDir), Remote: Remote}
+}
+
+// Schedule creates a backup at the provided interval until the schedule is stopped or replaced; an interval of zero
+// stops the current schedule. The result of each backup is passed to the report function.
+func Schedule(interval time.Duration, o Options, report func(file string, err error)) {
+	schedule.Lock()
+	defer schedule.Unlock()
+
+	if schedule.stop != nil {
+		close(schedule.stop)
+		schedule.stop = nil
+	}
+	schedule.interval = interval
+	if interval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	schedule.stop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				report(Now(o))
+			}
+		}
+	}()
+}
+
+// Scheduled returns the interval of the running scheduled snapshot or zero if there is not one
+func Scheduled() time.Duration {
+	schedule.Lock()
+	defer schedule.Unlock()
+	return schedule.interval
+}
+
+// Decrypt decrypts a backup created by Now with the key in the key file and writes the tar.gz archive to the output
+func Decrypt(backup string, keyFile string, output string) error {
+	key, err := config.Key(keyFile, "backup")
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(backup) // #nosec G304 - The backup file is provided by the operator
+	if err != nil {
+		return fmt.Errorf("there was an error opening the backup file:\r\n%s", err.Error())
+	}
+	defer in.Close() // #nosec G307
+
+	out, err := os.OpenFile(output, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600) // #nosec G304 - Provided by the operator
+	if err != nil {
+		return fmt.Errorf("there was an error creating the output file:\r\n%s", err.Error())
+	}
+	err = decrypt(in, out, key)
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		_ = os.Remove(output)
+	}
+	return err
+}
+
+// archive writes the backup directories to a gzip compressed tarball, stopping before the next file if the context is
+// cancelled
+func archive(ctx context.Context, w io.Writer, dataDir string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	for _, d := range Directories {
+		root := filepath.Join(dataDir, d)
+		if _, err := os.Stat(root); os.IsNotExist(err) {
+			continue
+		}
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return errors.New("the backup was cancelled")
+			}
+			if !info.Mode().IsRegular() && !info.IsDir() {
+				return nil
+			}
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dataDir, path)
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(rel)
+			if info.IsDir() {
+				header.Name += "/"
+				return tw.WriteHeader(header)
+			}
+
+			f, err := os.Open(path) // #nosec G304 - Files are found by walking the data directory
+			if err != nil {
+				return err
+			}
+			defer f.Close() // #nosec G307
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			// Files such as logs can grow while they are archived, so only copy the size in the header
+			_, err = io.CopyN(tw, f, header.Size)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// writer encrypts everything written to it with AES-GCM in chunks. Each chunk's nonce contains its sequence number and
+// the last chunk is authenticated differently from the others so a reordered or truncated backup is detected.
+type writer struct {
+	out    io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	count  uint32
+	buf    []byte
+}
+
+// newWriter writes the backup header and returns a writer that encrypts to the output with the key
+func newWriter(out io.Writer, key []byte) (*writer, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	w := &writer{out: out, aead: aead, prefix: make([]byte, aead.NonceSize()-4)}
+	if _, err := io.ReadFull(rand.Reader, w.prefix); err != nil {
+		return nil, err
+	}
+	if _, err := out.Write(append(append([]byte{}, magic...), w.prefix...)); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write buffers the plaintext and encrypts each full chunk
+func (w *writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	// Keep at least one byte buffered so the last chunk is written by Close
+	for len(w.buf) > chunkSize {
+		if err := w.seal(w.buf[:chunkSize], false); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[chunkSize:]
+	}
+	return len(p), nil
+}
+
+// Close encrypts the remaining plaintext as the last chunk
+func (w *writer) Close() error {
+	return w.seal(w.buf, true)
+}
+
+// seal encrypts and writes one length prefixed chunk
+func (w *writer) seal(chunk []byte, last bool) error {
+	ciphertext := w.aead.Seal(nil, nonce(w.prefix, w.count), chunk, additional(last))
+	w.count++
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(ciphertext)))
+	if _, err := w.out.Write(length); err != nil {
+		return err
+	}
+	_, err := w.out.Write(ciphertext)
+	return err
+}
+
+// decrypt reads a backup created with writer and writes the plaintext to the output
+func decrypt(in io.Reader, out io.Writer, key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, len(magic)+aead.NonceSize()-4)
+	if _, err := io.ReadFull(in, header); err != nil || !bytes.Equal(header[:len(magic)], magic) {
+		return errors.New("the file is not a supported Merlin backup")
+	}
+	prefix := header[len(magic):]
+
+	length := make([]byte, 4)
+	for count := uint32(0); ; count++ {
+		if _, err := io.ReadFull(in, length); err != nil {
+			return errors.New("the backup is truncated")
+		}
+		size := binary.BigEndian.Uint32(length)
+		if size > chunkSize+uint32(aead.Overhead()) {
+			return errors.New("the backup is corrupt")
+		}
+		ciphertext := make([]byte, size)
+		if _, err := io.ReadFull(in, ciphertext); err != nil {
+			return errors.New("the backup is truncated")
+		}
+
+		// Try the chunk as an intermediate chunk and then as the last chunk
+		plaintext, err := aead.Open(nil, nonce(prefix, count), ciphertext, additional(false))
+		last := false
+		if err != nil {
+			plaintext, err = aead.Open(nil, nonce(prefix, count), ciphertext, additional(true))
+			if err != nil {
+				return errors.New("the backup could not be decrypted with this key or is corrupt")
+			}
+			last = true
+		}
+		if _, err := out.Write(plaintext); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// nonce returns the nonce for the chunk with the provided sequence number
+func nonce(prefix []byte, count uint32) []byte {
+	n := make([]byte, len(prefix)+4)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[len(prefix):], count)
+	return n
+}
+
+// additional returns the additional authenticated data that marks whether a chunk is the last one
+func additional(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// newAEAD returns an AES-GCM cipher for the key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error creating the AES cipher:\r\n%s", err.Error())
+	}
+	return cipher.NewGCM(block)
+}
+
+// push copies the backup to a directory or uploads it to an HTTP(S) URL with a PUT request. A URL that ends in a slash
+// has the backup's file name appended to it.
+func push(ctx context.Context, backup string, remote string) error {
+	f, err := os.Open(backup) // #nosec G304 - The backup was created by Now
+	if err != nil {
+		return err
+	}
+	defer f.Close() // #nosec G307
+
+	if strings.HasPrefix(remote, "http://") || strings.HasPrefix(remote, "https://") {
+		if strings.HasSuffix(remote, "/") {
+			remote += filepath.Base(backup)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPut, remote, f)
+		if err != nil {
+			return err
+		}
+		req = req.WithContext(ctx)
+		req.ContentLength = info.Size()
+		req.Header.Set("Content-Type", "application/octet-stream")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close() // #nosec G307
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("the remote storage returned %s", resp.Status)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(remote, 0750); err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(filepath.Join(remote, filepath.Base(backup)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, f); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
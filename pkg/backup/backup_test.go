@@ -0,0 +1,123 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package backup
+
+import (
+	// Standard
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestBackup verifies a backup contains the engagement data and decrypts only with the right key
+func TestBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merlin-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // #nosec G104
+
+	// Random loot does not compress, so the backup has more than one encrypted chunk
+	loot := make([]byte, chunkSize*3+7)
+	if _, err := rand.Read(loot); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"agents/1/agent_log.txt":  []byte("agent log"),
+		"agents/1/loot.bin":       loot,
+		"log/merlinServerLog.txt": []byte("server log"),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	o := Options{DataDir: dir, KeyFile: KeyFile(dir), Remote: filepath.Join(dir, "remote")}
+	name, err := Now(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(o.Remote, filepath.Base(name))); err != nil {
+		t.Errorf("the backup was not copied to the remote directory: %s", err)
+	}
+
+	output := filepath.Join(dir, "backup.tar.gz")
+	if err := Decrypt(name, o.KeyFile, output); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() // #nosec G307
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	found := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, ok := files[header.Name]
+		if !ok {
+			continue
+		}
+		found++
+		contents, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(contents, data) {
+			t.Errorf("the contents of %s do not match", header.Name)
+		}
+	}
+	if found != len(files) {
+		t.Errorf("expected %d files in the backup, found %d", len(files), found)
+	}
+
+	// A different key or a truncated backup must not decrypt
+	if err := Decrypt(name, filepath.Join(dir, "other.key"), filepath.Join(dir, "other.tar.gz")); err == nil {
+		t.Error("the backup decrypted with the wrong key")
+	}
+	encrypted, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	key, _ := ioutil.ReadFile(o.KeyFile)
+	if err := decrypt(bytes.NewReader(encrypted[:len(encrypted)-chunkSize]), &out, key); err == nil {
+		t.Error("the truncated backup decrypted without an error")
+	}
+}
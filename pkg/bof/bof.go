@@ -0,0 +1,93 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package bof packs the arguments of a Beacon Object File (BOF) into the buffer its go entry point reads with the
+// BeaconDataParse, BeaconDataInt, BeaconDataShort, and BeaconDataExtract functions
+package bof
+
+import (
+	// Standard
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Usage describes the argument types Pack accepts
+const Usage = "z:<string> Z:<wide string> i:<32-bit integer> s:<16-bit integer> b:<base64 binary data>"
+
+// Pack returns the packed arguments for a BOF. Each argument is prefixed with its type: z for a string, Z for a wide
+// (UTF-16) string, i for a 32-bit integer, s for a 16-bit integer, or b for base64 encoded binary data. Integers are
+// little-endian; strings and binary data are prefixed with their 32-bit length and strings are null terminated. The
+// packed buffer starts with its own length and is empty when there are no arguments.
+func Pack(args []string) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	var b []byte
+	for _, arg := range args {
+		if len(arg) < 2 || arg[1] != ':' {
+			return nil, fmt.Errorf("%s is not a BOF argument; use %s", arg, Usage)
+		}
+		value := arg[2:]
+		switch arg[0] {
+		case 'z':
+			b = appendData(b, append([]byte(value), 0))
+		case 'Z':
+			wide := utf16.Encode([]rune(value + "\x00"))
+			data := make([]byte, len(wide)*2)
+			for i, c := range wide {
+				binary.LittleEndian.PutUint16(data[i*2:], c)
+			}
+			b = appendData(b, data)
+		case 'i':
+			i, err := strconv.ParseInt(value, 0, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%s is not a valid 32-bit integer", value)
+			}
+			b = append(b, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(i))
+		case 's':
+			s, err := strconv.ParseInt(value, 0, 16)
+			if err != nil {
+				return nil, fmt.Errorf("%s is not a valid 16-bit integer", value)
+			}
+			b = append(b, 0, 0)
+			binary.LittleEndian.PutUint16(b[len(b)-2:], uint16(s))
+		case 'b':
+			data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("there was an error decoding the base64 BOF argument:\r\n%s", err.Error())
+			}
+			b = appendData(b, data)
+		default:
+			return nil, fmt.Errorf("%c is not a BOF argument type; use %s", arg[0], Usage)
+		}
+	}
+	packed := make([]byte, 4, 4+len(b))
+	binary.LittleEndian.PutUint32(packed, uint32(len(b)))
+	return append(packed, b...), nil
+}
+
+// appendData appends the data prefixed with its 32-bit length
+func appendData(b []byte, data []byte) []byte {
+	l := make([]byte, 4)
+	binary.LittleEndian.PutUint32(l, uint32(len(data)))
+	return append(append(b, l...), data...)
+}
@@ -0,0 +1,52 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package bof
+
+import (
+	// Standard
+	"bytes"
+	"testing"
+)
+
+// TestPack verifies each argument type is packed the way the Beacon data parsing functions read it
+func TestPack(t *testing.T) {
+	b, err := Pack([]string{"z:hi", "Z:A", "i:-2", "s:0x10", "b:AQI="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		27, 0, 0, 0, // Length of the arguments
+		3, 0, 0, 0, 'h', 'i', 0, // z:hi
+		4, 0, 0, 0, 'A', 0, 0, 0, // Z:A
+		0xfe, 0xff, 0xff, 0xff, // i:-2
+		0x10, 0, // s:0x10
+		2, 0, 0, 0, 1, 2, // b:AQI=
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("the packed arguments were %v instead of %v", b, want)
+	}
+
+	if b, err = Pack(nil); err != nil || b != nil {
+		t.Error("no arguments did not return an empty buffer")
+	}
+	for _, arg := range []string{"hello", "x:1", "i:4294967296", "s:70000", "b:%%"} {
+		if _, err = Pack([]string{arg}); err == nil {
+			t.Errorf("the invalid argument %s was packed", arg)
+		}
+	}
+}
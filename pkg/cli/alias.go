@@ -0,0 +1,230 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+)
+
+// aliasUsage is the syntax of the alias command
+const aliasUsage = "alias list, alias add <name> <command> [<args>], alias remove <name>"
+
+// aliasFile is the dotfile in the home directory of the user running the server that keeps the console's aliases
+var aliasFile = func() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".merlin_aliases"
+	}
+	return filepath.Join(home, ".merlin_aliases")
+}()
+
+// aliasName are the characters allowed in an alias name
+var aliasName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// aliasPlaceholder is an argument placeholder in an alias's command: $1 to $9 are the arguments typed after the alias
+// and $* is all of them
+var aliasPlaceholder = regexp.MustCompile(`\$([1-9*])`)
+
+// aliases are the console's command shortcuts by their name
+var aliases = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// loadAliases reads the aliases from the dotfile; a missing file has no aliases
+func loadAliases() {
+	data, err := ioutil.ReadFile(aliasFile) // #nosec G304 the file is in the user's home directory
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		message("warn", fmt.Sprintf("There was an error reading the alias file:\r\n%s", err.Error()))
+		return
+	}
+	aliases.Lock()
+	defer aliases.Unlock()
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) < 2 || !aliasName.MatchString(fields[0]) {
+			message("warn", fmt.Sprintf("Line %d of the alias file %s is not a valid alias", n+1, aliasFile))
+			continue
+		}
+		aliases.m[fields[0]] = strings.TrimSpace(fields[1])
+	}
+}
+
+// saveAliases writes the aliases to the dotfile sorted by name; the caller holds the aliases lock
+func saveAliases() error {
+	var names []string
+	for name := range aliases.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := []string{"# Merlin console aliases: <name> <command>; $1 to $9 and $* are replaced with the arguments"}
+	for _, name := range names {
+		lines = append(lines, name+" "+aliases.m[name])
+	}
+	return ioutil.WriteFile(aliasFile, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+// expandAlias replaces an alias at the start of the line with its command and the arguments typed after it. It returns
+// false if the line used an argument placeholder that was not provided. Lines typed in an interactive shell are sent to
+// the agent as they are.
+func expandAlias(line string) (string, bool) {
+	fields := strings.Fields(line)
+	if shellMenuContext == "shell" || len(fields) == 0 {
+		return line, true
+	}
+	aliases.Lock()
+	command, ok := aliases.m[fields[0]]
+	aliases.Unlock()
+	if !ok {
+		return line, true
+	}
+	args := fields[1:]
+	if !aliasPlaceholder.MatchString(command) {
+		return strings.Join(append([]string{command}, args...), " "), true
+	}
+	var missing []string
+	expanded := aliasPlaceholder.ReplaceAllStringFunc(command, func(p string) string {
+		if p == "$*" {
+			return strings.Join(args, " ")
+		}
+		n, _ := strconv.Atoi(p[1:])
+		if n > len(args) {
+			missing = append(missing, p)
+			return p
+		}
+		return args[n-1]
+	})
+	if len(missing) > 0 {
+		message("warn", fmt.Sprintf("The %s alias requires an argument for %s: %s", fields[0],
+			strings.Join(missing, ", "), command))
+		return line, false
+	}
+	return expanded, true
+}
+
+// menuAlias lists, adds, or removes the console's command shortcuts, which are kept in a dotfile so they are available
+// in the next session
+func menuAlias(cmd []string) {
+	if len(cmd) < 1 {
+		message("warn", "Invalid 'alias' command")
+		message("info", aliasUsage)
+		return
+	}
+	// The aliases are kept in the home directory of the user running the server and only expanded in its console
+	if shellOperator != "" {
+		message("warn", "Aliases can only be managed from the server's console")
+		return
+	}
+	aliases.Lock()
+	defer aliases.Unlock()
+	switch cmd[0] {
+	case "list":
+		if len(aliases.m) == 0 {
+			message("note", "There are no aliases; use alias add <name> <command>")
+			return
+		}
+		var names []string
+		for name := range aliases.m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Alias", "Command"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, name := range names {
+			table.Append([]string{name, aliases.m[name]})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case "add":
+		if len(cmd) < 3 {
+			message("warn", "Invalid 'alias add' command; use alias add <name> <command> [<args>]")
+			return
+		}
+		if !aliasName.MatchString(cmd[1]) || cmd[1] == "alias" {
+			message("warn", fmt.Sprintf("%s is not a valid alias name", cmd[1]))
+			return
+		}
+		previous, replaced := aliases.m[cmd[1]]
+		aliases.m[cmd[1]] = strings.Join(cmd[2:], " ")
+		if err := saveAliases(); err != nil {
+			if replaced {
+				aliases.m[cmd[1]] = previous
+			} else {
+				delete(aliases.m, cmd[1])
+			}
+			message("warn", fmt.Sprintf("There was an error saving the alias file:\r\n%s", err.Error()))
+			return
+		}
+		message("success", fmt.Sprintf("Added alias %s for '%s'", cmd[1], aliases.m[cmd[1]]))
+	case "remove":
+		if len(cmd) < 2 {
+			message("warn", "Invalid 'alias remove' command; use alias remove <name>")
+			return
+		}
+		command, ok := aliases.m[cmd[1]]
+		if !ok {
+			message("warn", fmt.Sprintf("%s is not an alias", cmd[1]))
+			return
+		}
+		delete(aliases.m, cmd[1])
+		if err := saveAliases(); err != nil {
+			aliases.m[cmd[1]] = command
+			message("warn", fmt.Sprintf("There was an error saving the alias file:\r\n%s", err.Error()))
+			return
+		}
+		message("success", fmt.Sprintf("Removed alias %s", cmd[1]))
+	default:
+		message("warn", "Invalid 'alias' command")
+		message("info", aliasUsage)
+	}
+}
+
+// aliasItems returns the names of the aliases for tab completion
+func aliasItems() func(string) []string {
+	return func(line string) []string {
+		aliases.Lock()
+		defer aliases.Unlock()
+		var names []string
+		for name := range aliases.m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+}
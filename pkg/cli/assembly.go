@@ -0,0 +1,80 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/mattn/go-shellwords"
+)
+
+// executeAssemblyUsage is the syntax of the execute-assembly command
+const executeAssemblyUsage = "execute-assembly [-appdomain <name>] [-amsi] [-etw] <local_assembly_file> [args...]"
+
+// menuExecuteAssembly creates a job for the agent the agent menu is interacting with to run a local .NET assembly in
+// memory. Options must come before the assembly file; everything after it is passed to the assembly.
+func menuExecuteAssembly(cmd []string) {
+	argS, err := shellwords.Parse(strings.Join(cmd, " "))
+	if err != nil {
+		message("warn", fmt.Sprintf("There was an error parsing command line arguments:\r\n%s", err.Error()))
+		return
+	}
+	var appDomain string
+	amsi, etw := "false", "false"
+	for len(argS) > 0 && strings.HasPrefix(argS[0], "-") {
+		switch argS[0] {
+		case "-appdomain":
+			if len(argS) < 2 {
+				message("warn", "The -appdomain option requires a name")
+				return
+			}
+			appDomain = argS[1]
+			argS = argS[1:]
+		case "-amsi":
+			amsi = "true"
+		case "-etw":
+			etw = "true"
+		default:
+			message("warn", fmt.Sprintf("%s is not a valid execute-assembly option", argS[0]))
+			message("info", executeAssemblyUsage)
+			return
+		}
+		argS = argS[1:]
+	}
+	if len(argS) < 1 {
+		message("warn", "Invalid command")
+		message("info", executeAssemblyUsage)
+		return
+	}
+	if _, err = os.Stat(argS[0]); err != nil {
+		message("warn", fmt.Sprintf("There was an error accessing the assembly:\r\n%s", err.Error()))
+		return
+	}
+	m, err := addJob(shellAgent, "execute-assembly", append([]string{argS[0], appDomain, amsi, etw}, argS[1:]...))
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+		m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+}
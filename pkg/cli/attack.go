@@ -0,0 +1,135 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/report"
+)
+
+// attackUsage is the syntax of the attack command
+const attackUsage = "attack coverage [since <time>] [until <time>], attack export <file.json> [since <time>] [until <time>], " +
+	"attack commands"
+
+// menuAttack shows the MITRE ATT&CK techniques used against each host, exports them as an ATT&CK Navigator layer, or
+// lists the techniques of the agent commands
+func menuAttack(cmd []string) {
+	if len(cmd) == 0 {
+		message("warn", "Invalid 'attack' command")
+		message("info", attackUsage)
+		return
+	}
+	switch cmd[0] {
+	case "commands":
+		attackCommands()
+	case "coverage":
+		since, until, err := timeRange(cmd[1:])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		attackCoverage(since, until)
+	case "export":
+		if len(cmd) < 2 {
+			message("warn", "Invalid 'attack export' command")
+			message("info", attackUsage)
+			return
+		}
+		since, until, err := timeRange(cmd[2:])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		layer, err := report.Navigator("Merlin", since, until)
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		if err = report.WriteNavigator(layer, cmd[1]); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Wrote the MITRE ATT&CK Navigator layer of %d techniques to %s", len(layer.Techniques), cmd[1]))
+	default:
+		message("warn", fmt.Sprintf("Invalid 'attack' command: %s", cmd[0]))
+		message("info", attackUsage)
+	}
+}
+
+// attackCoverage shows the MITRE ATT&CK techniques jobs used in the time range and the hosts they were used on
+func attackCoverage(since time.Time, until time.Time) {
+	coverage, err := agents.Coverage(since, until)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	if len(coverage) == 0 {
+		message("note", "No MITRE ATT&CK techniques have been used")
+		return
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Technique", "Name", "Hosts", "Jobs", "Used By", "Last Used"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	hosts := make(map[string]bool)
+	for _, c := range coverage {
+		table.Append([]string{c.ID, c.Name, strings.Join(c.Hosts, ", "), strconv.Itoa(c.Jobs), strings.Join(c.Sources, ", "),
+			c.Last.Format(time.RFC3339)})
+		for _, h := range c.Hosts {
+			hosts[h] = true
+		}
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+	message("info", fmt.Sprintf("%d techniques were used against %d hosts", len(coverage), len(hosts)))
+}
+
+// attackCommands lists the agent commands and the MITRE ATT&CK techniques they use
+func attackCommands() {
+	commands := agents.AttackCommands()
+	var names []string
+	for command := range commands {
+		names = append(names, command)
+	}
+	sort.Strings(names)
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Command", "Technique", "Name"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAutoMergeCells(true)
+	for _, command := range names {
+		for _, id := range commands[command] {
+			table.Append([]string{command, id, agents.Techniques[id]})
+		}
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+	message("info", "cmd and shell jobs use T1059.003 on Windows agents and T1059.004 on the others; modules list their techniques in 'info'")
+}
@@ -0,0 +1,168 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/chzyer/readline"
+	"github.com/olekukonko/tablewriter"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/audit"
+	"github.com/Ne0nd0g/merlin/pkg/events"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+)
+
+// auditLimit is the number of most recent entries shown when the auditlog command is not given a limit
+const auditLimit = 50
+
+// shellSession is the team server session whose command line is being executed; it is empty for the server's console
+var shellSession string
+
+// auditLine records the command line an operator is executing in the audit log with the agent it targets
+func auditLine(line string) {
+	e := audit.Entry{Client: shellOperator, Session: shellSession, Menu: shellMenuContext}
+	if e.Client == "" {
+		e.Client = audit.Console
+	}
+	cmd := strings.Fields(line)
+	switch shellMenuContext {
+	case "shell":
+		// Lines typed in an interactive shell are sent to the agent as they are
+		e.Command, e.Args = "shell", []string{line}
+	default:
+		e.Command, e.Args = cmd[0], cmd[1:]
+	}
+	switch shellMenuContext {
+	case "agent", "shell":
+		e.Agent = shellAgent.String()
+	case "module":
+		if shellModule.Agent != uuid.Nil {
+			e.Agent = shellModule.Agent.String()
+		}
+	default:
+		// Main menu commands, such as interact or remove, take the agent as an argument
+		for _, arg := range e.Args {
+			if id, err := uuid.FromString(arg); err == nil {
+				e.Agent = id.String()
+				break
+			}
+		}
+	}
+	if err := audit.Record(e); err != nil {
+		message("warn", err.Error())
+		logging.Server(err.Error())
+	}
+}
+
+// menuAuditLog lists the operator actions recorded in the audit log filtered by client, agent, and time range, or
+// verifies the log's hash chain
+func menuAuditLog(cmd []string) {
+	if len(cmd) > 0 && cmd[0] == "verify" {
+		count, head, err := audit.Verify()
+		if err != nil {
+			message("warn", fmt.Sprintf("The audit log failed verification after %d entries: %s", count, err.Error()))
+			return
+		}
+		message("success", fmt.Sprintf("The audit log's %d entries are intact; the last entry's hash is %s", count, head))
+		return
+	}
+	var f audit.Filter
+	limit := auditLimit
+	now := time.Now().UTC()
+	for i := 0; i < len(cmd); i += 2 {
+		if i+1 >= len(cmd) {
+			message("warn", fmt.Sprintf("The %s filter is missing its value", cmd[i]))
+			return
+		}
+		v := cmd[i+1]
+		var err error
+		switch strings.ToLower(cmd[i]) {
+		case "client":
+			f.Client = v
+		case "agent":
+			if _, err = uuid.FromString(v); err != nil {
+				message("warn", fmt.Sprintf("%s is not a valid agent ID", v))
+				return
+			}
+			f.Agent = v
+		case "since":
+			if f.Since, err = events.ParseTime(v, now); err != nil {
+				message("warn", err.Error())
+				return
+			}
+		case "until":
+			if f.Until, err = events.ParseTime(v, now); err != nil {
+				message("warn", err.Error())
+				return
+			}
+		case "limit":
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+				message("warn", fmt.Sprintf("%s is not a valid limit", v))
+				return
+			}
+		default:
+			message("warn", "Invalid 'auditlog' command; use verify, or [client <name>] [agent <id>] [since <time>] [until <time>] [limit <n>]")
+			return
+		}
+	}
+	list, err := audit.Query(f)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	if len(list) == 0 {
+		message("info", "There are no audit log entries that match")
+		return
+	}
+	if len(list) > limit {
+		message("note", fmt.Sprintf("Showing the last %d of %d entries; use limit <n> to show more", limit, len(list)))
+		list = list[len(list)-limit:]
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"ID", "Time", "Client", "Agent", "Menu", "Command"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, e := range list {
+		table.Append([]string{strconv.FormatUint(e.ID, 10), e.Time.Format(time.RFC3339), e.Client, e.Agent, e.Menu,
+			strings.Join(append([]string{e.Command}, e.Args...), " ")})
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
+
+// auditItems returns the filters completed for the auditlog command
+func auditItems() []readline.PrefixCompleterInterface {
+	return []readline.PrefixCompleterInterface{
+		readline.PcItem("verify"),
+		readline.PcItem("client"),
+		readline.PcItem("agent", readline.PcItemDynamic(agents.GetAgentList())),
+		readline.PcItem("since"),
+		readline.PcItem("until"),
+		readline.PcItem("limit"),
+	}
+}
@@ -0,0 +1,65 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/mattn/go-shellwords"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/bof"
+)
+
+// bofUsage is the syntax of the bof command
+const bofUsage = "bof <local_object_file> [" + bof.Usage + "]..."
+
+// menuBOF creates a job for the agent the agent menu is interacting with to run a local Beacon Object File. The
+// arguments are checked here and packed by the server when the job is sent.
+func menuBOF(cmd []string) {
+	argS, err := shellwords.Parse(strings.Join(cmd, " "))
+	if err != nil {
+		message("warn", fmt.Sprintf("There was an error parsing command line arguments:\r\n%s", err.Error()))
+		return
+	}
+	if len(argS) < 1 {
+		message("warn", "Invalid command")
+		message("info", bofUsage)
+		return
+	}
+	if _, err = os.Stat(argS[0]); err != nil {
+		message("warn", fmt.Sprintf("There was an error accessing the BOF:\r\n%s", err.Error()))
+		return
+	}
+	if _, err = bof.Pack(argS[1:]); err != nil {
+		message("warn", err.Error())
+		return
+	}
+	m, err := addJob(shellAgent, "bof", argS)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+		m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+}
@@ -0,0 +1,148 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+)
+
+// broadcastUsage is the syntax of the broadcast command
+const broadcastUsage = "broadcast <all|<agent>|tag:<tag>|<field>=<value>|<field>!=<value>|<field>~<text>[,...]> <command>, " +
+	"broadcast list, broadcast report <id>"
+
+// menuBroadcast runs a command on every agent that matches a filter and shows all of their results in one table once
+// every agent has returned them, or lists the broadcasts and shows a broadcast's results so far
+func menuBroadcast(cmd []string) {
+	if len(cmd) < 1 {
+		message("warn", "Invalid 'broadcast' command")
+		message("info", broadcastUsage)
+		return
+	}
+	switch {
+	case cmd[0] == "list" && len(cmd) == 1:
+		list := agents.GetBroadcasts()
+		if len(list) == 0 {
+			message("info", "There are no broadcasts")
+			return
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ID", "Command", "Filter", "Created", "Agents", "Returned", "Completed"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, b := range list {
+			var completed string
+			if !b.Completed.IsZero() {
+				completed = b.Completed.Format(time.RFC3339)
+			}
+			table.Append([]string{b.ID, strings.Join(b.Args, " "), b.Filter, b.Created.Format(time.RFC3339),
+				fmt.Sprintf("%d", len(b.Jobs)), fmt.Sprintf("%d", len(b.Results)), completed})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case cmd[0] == "report" && len(cmd) == 2:
+		agents.ShowBroadcast(cmd[1])
+	case len(cmd) >= 2:
+		ids, err := broadcastAgents(cmd[0])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		if len(ids) == 0 {
+			message("warn", fmt.Sprintf("There are no agents that match %s", cmd[0]))
+			return
+		}
+		b, err := agents.AddBroadcast(ids, cmd[0], agents.Job{Type: "cmd", Args: cmd[1:], After: embargo, Priority: priority})
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		if skipped := len(ids) - len(b.Jobs); skipped > 0 {
+			message("note", fmt.Sprintf("Skipped %d agents that are dead or pending", skipped))
+		}
+		message("note", fmt.Sprintf("Created broadcast %s with a job for each of %d agents at %s", b.ID, len(b.Jobs),
+			time.Now().UTC().Format(time.RFC3339)))
+		if t := agents.GetThrottle(); t.Max > 0 || t.Stagger > 0 {
+			message("info", fmt.Sprintf("The jobs are released to at most %d agents at once, %s apart", t.Max, t.Stagger))
+		}
+		message("info", fmt.Sprintf("The results are shown together when every agent returns them; use 'broadcast report %s' to view them sooner", b.ID))
+	default:
+		message("warn", "Invalid 'broadcast' command")
+		message("info", broadcastUsage)
+	}
+}
+
+// broadcastAgents returns the agents a broadcast filter selects. The filter is a comma separated list of agent IDs and
+// tags, or all, along with sessions field expressions that every selected agent must also match. Without IDs or tags,
+// the expressions are matched against every agent.
+func broadcastAgents(filter string) ([]uuid.UUID, error) {
+	var targets []string
+	var filters []sessionFilterExpression
+	for _, item := range strings.Split(filter, ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "all":
+		case sessionFilter.MatchString(item):
+			f, err := parseSessionFilter(item)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, f)
+		default:
+			targets = append(targets, item)
+		}
+	}
+	selected := make(map[uuid.UUID]bool)
+	if len(targets) > 0 {
+		ids, err := agents.Targets(strings.Join(targets, ","))
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			selected[id] = true
+		}
+	}
+	var ids []uuid.UUID
+	for n, id := range sessionList() {
+		if (len(targets) == 0 || selected[id]) && sessionMatch(filters, n+1, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// broadcastIDs returns the IDs of the broadcasts
+func broadcastIDs() func(string) []string {
+	return func(line string) []string {
+		var ids []string
+		for _, b := range agents.GetBroadcasts() {
+			ids = append(ids, b.ID)
+		}
+		return ids
+	}
+}
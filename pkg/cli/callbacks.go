@@ -0,0 +1,91 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+)
+
+// menuCallbacks lists the callback URLs the agent the agent menu is interacting with rotates its check ins across, or
+// creates a job to add or remove a URL or to change how long the agent uses each URL. The agent does not report a
+// failed change, so the arguments are validated here and the list is updated when the agent next sends its info.
+func menuCallbacks(cmd []string) {
+	if len(cmd) == 0 {
+		a, ok := agents.Agents[shellAgent]
+		if !ok || len(a.Callbacks) == 0 {
+			message("info", fmt.Sprintf("Agent %s has not reported its callback URLs", shellAgent))
+			return
+		}
+		message("info", fmt.Sprintf("Agent %s rotates its check ins every %s across:", shellAgent, a.Rotate))
+		for i, u := range a.Callbacks {
+			fmt.Printf("\t%d. %s\r\n", i+1, u)
+		}
+		return
+	}
+	if len(cmd) != 2 {
+		message("warn", "Invalid 'callbacks' command; use add <url>, remove <url>, or rotate <duration>")
+		return
+	}
+	var callbacks []string
+	if a, ok := agents.Agents[shellAgent]; ok {
+		callbacks = a.Callbacks
+	}
+	switch cmd[0] {
+	case "add":
+		u, err := url.Parse(cmd[1])
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			message("warn", fmt.Sprintf("%s is not a valid URL; use the same scheme as the agent's other callbacks", cmd[1]))
+			return
+		}
+		if inSlice(cmd[1], callbacks) {
+			message("warn", fmt.Sprintf("%s is already a callback URL for agent %s", cmd[1], shellAgent))
+			return
+		}
+	case "remove":
+		if len(callbacks) > 0 && !inSlice(cmd[1], callbacks) {
+			message("warn", fmt.Sprintf("%s is not a callback URL for agent %s", cmd[1], shellAgent))
+			return
+		}
+		if len(callbacks) == 1 {
+			message("warn", "The agent's last callback URL can not be removed")
+			return
+		}
+	case "rotate":
+		if d, err := time.ParseDuration(cmd[1]); err != nil || d < 0 {
+			message("warn", fmt.Sprintf("%s is not a valid duration; use 0 to rotate on every check in or a duration such as 30m or 4h", cmd[1]))
+			return
+		}
+	default:
+		message("warn", fmt.Sprintf("%s is not a valid 'callbacks' command; use add, remove, or rotate", cmd[0]))
+		return
+	}
+	m, err := addJob(shellAgent, "callbacks", []string{"callbacks", strings.Join(cmd, " ")})
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+		m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+}
@@ -0,0 +1,138 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/mtls"
+)
+
+// menuCerts handles the listeners menu certs command to issue, list, and revoke the client certificates agents
+// present to listeners that require them
+func menuCerts(cmd []string) {
+	if len(cmd) < 2 {
+		message("warn", "Invalid 'certs' command; use certs list, certs issue <name>, or certs revoke <name|agent ID>")
+		return
+	}
+	switch cmd[1] {
+	case "list":
+		certs, err := mtls.Certificates()
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Name", "Status", "Issued", "Expires", "Agents", "Fingerprint"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, c := range certs {
+			status := "valid"
+			if !c.Revoked.IsZero() {
+				status = "revoked " + c.Revoked.Format(time.RFC3339)
+			} else if time.Now().After(c.Expires) {
+				status = "expired"
+			}
+			var ids []string
+			for _, id := range c.Agents {
+				ids = append(ids, id.String())
+			}
+			table.Append([]string{c.Name, status, c.Issued.Format(time.RFC3339), c.Expires.Format(time.RFC3339),
+				strings.Join(ids, "\n"), c.Fingerprint[:16]})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case "issue":
+		if len(cmd) < 3 {
+			message("warn", "Invalid 'certs issue' command; use certs issue <name>")
+			return
+		}
+		cert, err := mtls.Issue(cmd[2])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		file := filepath.Join(mtls.Dir, cmd[2]+".pem")
+		if err = ioutil.WriteFile(file, []byte(cert), 0600); err != nil {
+			message("warn", fmt.Sprintf("there was an error writing agent certificate %s:\r\n%s", cmd[2], err.Error()))
+			return
+		}
+		message("success", fmt.Sprintf("Issued agent certificate %s; the certificate and key are in %s", cmd[2], file))
+		message("info", "Set the generate menu's ClientCert option to true to embed a new certificate in an agent instead")
+	case "revoke":
+		if len(cmd) < 3 {
+			message("warn", "Invalid 'certs revoke' command; use certs revoke <name|agent ID>")
+			return
+		}
+		if err := mtls.Revoke(cmd[2]); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Revoked the agent certificate for %s", cmd[2]))
+	default:
+		message("warn", fmt.Sprintf("Invalid 'certs' command: %s; use list, issue, or revoke", cmd[1]))
+	}
+}
+
+// agentCertNames returns a function that lists the agent certificates that are not revoked for tab completion
+func agentCertNames() func(string) []string {
+	return func(line string) []string {
+		certs, err := mtls.Certificates()
+		if err != nil {
+			return nil
+		}
+		var names []string
+		for _, c := range certs {
+			if c.Revoked.IsZero() {
+				names = append(names, c.Name)
+			}
+		}
+		return names
+	}
+}
+
+// issueAgentCert issues a client certificate for an agent being generated, named after its platform and the time
+func issueAgentCert(goos string, arch string) (string, string, error) {
+	name := fmt.Sprintf("%s-%s-%s", goos, arch, time.Now().UTC().Format("20060102150405"))
+	cert, err := mtls.Issue(name)
+	return name, cert, err
+}
+
+// withClientCert turns on the generate menu's ClientCert option for agents of a listener that requires client
+// certificates
+func withClientCert(options []listenerOption, clientCerts string) []listenerOption {
+	if clientCerts != "true" {
+		return options
+	}
+	for i, o := range options {
+		if o.Name == "ClientCert" {
+			options[i].Value = "true"
+		}
+	}
+	return options
+}
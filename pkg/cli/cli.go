@@ -18,13 +18,15 @@
 package cli
 
 import (
-	"bufio"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -39,11 +41,19 @@ import (
 	// Merlin
 	"github.com/Ne0nd0g/merlin/pkg"
 	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/api/rpc"
+	"github.com/Ne0nd0g/merlin/pkg/backup"
 	"github.com/Ne0nd0g/merlin/pkg/banner"
 	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/hosts"
 	"github.com/Ne0nd0g/merlin/pkg/logging"
 	"github.com/Ne0nd0g/merlin/pkg/modules"
 	"github.com/Ne0nd0g/merlin/pkg/modules/shellcode"
+	"github.com/Ne0nd0g/merlin/pkg/redact"
+	"github.com/Ne0nd0g/merlin/pkg/schedule"
+	"github.com/Ne0nd0g/merlin/pkg/targets"
+	"github.com/Ne0nd0g/merlin/pkg/tools"
+	"github.com/Ne0nd0g/merlin/pkg/tunnel"
 )
 
 // Global Variables
@@ -57,6 +67,7 @@ var shellMenuContext = "main"
 func Shell() {
 
 	shellCompleter = getCompleter("main")
+	loadAliases()
 
 	p, err := readline.NewEx(&readline.Config{
 		Prompt:              "\033[31mMerlin»\033[0m ",
@@ -66,6 +77,8 @@ func Shell() {
 		EOFPrompt:           "exit",
 		HistorySearchFold:   true,
 		FuncFilterInputRune: filterInput,
+		// Lines are saved to the history by the loop below after their secrets are redacted
+		DisableAutoSaveHistory: true,
 	})
 
 	if err != nil {
@@ -73,452 +86,926 @@ func Shell() {
 		color.Red(err.Error())
 	}
 	prompt = p
+	addNotifier("", p.Stdout(), operatorProfile)
 
 	defer func() {
-		err := prompt.Close()
+		err := p.Close()
 		if err != nil {
 			log.Fatal(err)
 		}
 	}()
 
 	log.SetOutput(prompt.Stderr())
+	// Messages printed by other goroutines, such as agent check ins, are written above the prompt instead of through it
+	callLocal(func() { localOutput(p.Stdout()) })
 
 	for {
-		line, err := prompt.Readline()
+		// The prompt variable is swapped while a remote operator's command runs, so the server's own is used here
+		line, err := p.Readline()
 		if err == readline.ErrInterrupt {
+			// ctrl-c in an interactive shell returns to the agent menu instead of exiting
+			var closed bool
+			callLocal(func() {
+				if shellMenuContext == "shell" {
+					menuShellExit()
+					closed = true
+				}
+			})
+			if closed {
+				continue
+			}
 			if len(line) == 0 {
 				break
 			} else {
 				continue
 			}
 		} else if err == io.EOF {
-			exit()
+			exit(nil)
 		}
 
-		line = strings.TrimSpace(line)
-		cmd := strings.Fields(line)
+		if strings.TrimSpace(line) != "" {
+			_ = p.SaveHistory(redact.String(line))
+		}
+		recordInput(line)
+		executeLocal(line)
+	}
+}
 
-		if len(cmd) > 0 {
-			switch shellMenuContext {
-			case "main":
-				switch cmd[0] {
-				case "agent":
-					if len(cmd) > 1 {
-						menuAgent(cmd[1:])
-					}
-				case "banner":
-					color.Blue(banner.MerlinBanner1)
-					color.Blue("\t\t   Version: %s", merlin.Version)
-				case "help":
-					menuHelpMain()
-				case "?":
-					menuHelpMain()
-				case "exit", "quit":
-					exit()
-				case "interact":
-					if len(cmd) > 1 {
-						i := []string{"interact"}
-						i = append(i, cmd[1])
-						menuAgent(i)
-					}
-				case "remove":
-					if len(cmd) > 1 {
-						i := []string{"remove"}
-						i = append(i, cmd[1])
-						menuAgent(i)
-					}
-				case "sessions":
-					menuAgent([]string{"list"})
-				case "use":
-					menuUse(cmd[1:])
-				case "version":
-					color.Blue(fmt.Sprintf("Merlin version: %s", merlin.Version))
-				case "":
-				default:
-					message("info", "Executing system command...")
-					if len(cmd) > 1 {
-						executeCommand(cmd[0], cmd[1:])
-					} else {
-						var x []string
-						executeCommand(cmd[0], x)
-					}
+// embargo is the time the jobs created by the command line being executed are embargoed until; zero if they are not
+var embargo time.Time
+
+// priority is the priority of the jobs created by the command line being executed, provided with --priority
+var priority = agents.PriorityNormal
+
+// addJob creates a job for the agent that is embargoed until the time provided with --after, if there was one, with
+// the priority provided with --priority
+func addJob(agentID uuid.UUID, jobType string, jobArgs []string) (string, error) {
+	job, err := agents.AddJobPriority(agentID, jobType, jobArgs, embargo, priority)
+	if err == nil && !embargo.IsZero() {
+		message("note", fmt.Sprintf("Job %s will not be sent before %s", job, embargo.Format(time.RFC3339)))
+	}
+	return job, err
+}
+
+// parseEmbargo returns the time from an --after value: a local time of day (i.e. 02:00), which is the next time the
+// clock reads that time, a duration from now (i.e. 90m), or an RFC 3339 timestamp
+func parseEmbargo(after string) (time.Time, error) {
+	now := time.Now()
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.ParseInLocation(layout, after, time.Local); err == nil {
+			t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
+			if !t.After(now) {
+				t = t.AddDate(0, 0, 1)
+			}
+			return t, nil
+		}
+	}
+	if d, err := time.ParseDuration(after); err == nil && d > 0 {
+		return now.Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339, after)
+	if err != nil {
+		return t, fmt.Errorf("%s is not a valid --after time; use a time of day (i.e. 02:00), a duration (i.e. 90m), or an RFC 3339 timestamp", after)
+	}
+	if !t.After(now) {
+		return t, fmt.Errorf("the --after time %s has already passed", after)
+	}
+	return t, nil
+}
+
+// executeLine runs a command line in the current menu context
+func executeLine(line string) {
+	var err error
+	if strings.TrimSpace(line) != "" {
+		auditLine(line)
+	}
+	// Lines typed in an interactive shell are sent to the agent as they are
+	if shellMenuContext == "shell" {
+		menuShell(line)
+		return
+	}
+	line = strings.TrimSpace(line)
+	cmd := strings.Fields(line)
+
+	// A trailing --after <time> embargoes the jobs the command creates until that time and a trailing
+	// --priority <level> sends them ahead of, or after, the agent's other queued jobs; either can come first
+	embargo, priority = time.Time{}, agents.PriorityNormal
+	for len(cmd) > 2 && (cmd[len(cmd)-2] == "--after" || cmd[len(cmd)-2] == "--priority") {
+		if cmd[len(cmd)-2] == "--after" {
+			after, errAfter := parseEmbargo(cmd[len(cmd)-1])
+			if errAfter != nil {
+				message("warn", errAfter.Error())
+				return
+			}
+			embargo = after
+		} else {
+			p, errPriority := agents.ParsePriority(cmd[len(cmd)-1])
+			if errPriority != nil {
+				message("warn", errPriority.Error())
+				return
+			}
+			priority = p
+		}
+		cmd = cmd[:len(cmd)-2]
+	}
+
+	if len(cmd) > 0 {
+		switch shellMenuContext {
+		case "main":
+			switch cmd[0] {
+			case "accept":
+				if len(cmd) < 2 {
+					message("warn", "Invalid 'accept' command; use accept <agent>")
+					break
 				}
-			case "module":
-				switch cmd[0] {
-				case "show":
-					if len(cmd) > 1 {
-						switch cmd[1] {
-						case "info":
-							shellModule.ShowInfo()
-						case "options":
-							shellModule.ShowOptions()
-						}
+				i, errUUID := uuid.FromString(cmd[1])
+				if errUUID != nil {
+					message("warn", fmt.Sprintf("%s is not a valid agent ID", cmd[1]))
+					break
+				}
+				if err := agents.Accept(i); err != nil {
+					message("warn", err.Error())
+					break
+				}
+				message("success", fmt.Sprintf("Accepted agent %s; it can now be tasked", i))
+			case "agent":
+				if len(cmd) > 1 {
+					menuAgent(cmd[1:])
+				}
+			case "alias":
+				menuAlias(cmd[1:])
+			case "approval":
+				menuApproval(cmd[1:])
+			case "attack":
+				menuAttack(cmd[1:])
+			case "auditlog":
+				menuAuditLog(cmd[1:])
+			case "backup":
+				menuBackup(cmd[1:])
+			case "barrier":
+				menuBarrier(cmd[1:])
+			case "broadcast":
+				menuBroadcast(cmd[1:])
+			case "banner":
+				color.Blue(banner.MerlinBanner1)
+				color.Blue("\t\t   Version: %s", merlin.Version)
+			case "help":
+				menuHelpMain()
+			case "?":
+				menuHelpMain()
+			case "creds":
+				menuCreds(cmd[1:])
+			case "hosts":
+				menuHosts(cmd[1:])
+			case "confirm":
+				menuConfirm(cmd[1:])
+			case "diagnose":
+				if len(cmd) > 1 {
+					i := []string{"diagnose"}
+					i = append(i, cmd[1])
+					menuAgent(i)
+				}
+			case "events":
+				menuEvents(cmd[1:])
+			case "exit", "quit":
+				exit(cmd[1:])
+			case "generate":
+				if format, ok := generateFormat(cmd[0], cmd[1:]); ok {
+					menuSetGenerate(generateOptions(serverProtocol, serverURL, listenerPSK, serverProfile, format), "main")
+				}
+			case "hunt":
+				menuHunt(cmd[1:])
+			case "jobs":
+				menuJobs(uuid.Nil, cmd[1:])
+			case "interact":
+				if len(cmd) > 1 {
+					i := []string{"interact"}
+					i = append(i, cmd[1])
+					menuAgent(i)
+				}
+			case "listeners":
+				menuSetListeners()
+			case "loot":
+				menuLoot(cmd[1:])
+			case "modules":
+				menuModules(cmd[1:])
+			case "notifications":
+				menuNotifications(cmd[1:])
+			case "notify":
+				menuNotify(cmd[1:])
+			case "operators":
+				menuOperators(cmd[1:])
+			case "queue":
+				menuQueue(cmd[1:])
+			case "quickstart":
+				menuQuickstart(cmd[1:])
+			case "record":
+				menuRecord(cmd[1:])
+			case "redact":
+				menuRedact(cmd[1:])
+			case "replay":
+				menuReplay(cmd[1:])
+			case "report":
+				menuReport(cmd[1:])
+			case "resource":
+				menuResource(cmd[1:])
+			case "rotate":
+				menuRotate(cmd[1:])
+			case "remove":
+				ok, args := confirmCommand("remove", "Are you sure you want to remove the agent?", cmd[1:])
+				if len(args) > 0 && ok {
+					menuAgent([]string{"remove", args[0]})
+				}
+			case "search":
+				menuSearch(cmd[1:])
+			case "sessions":
+				if len(cmd) > 1 && cmd[1] == "copy" {
+					menuSessionsCopy(cmd[2:])
+					break
+				}
+				menuAgent(append([]string{"list"}, cmd[1:]...))
+			case "targets":
+				menuTargets(cmd[1:])
+			case "throttle":
+				menuThrottle(cmd[1:])
+			case "tools":
+				menuTools(cmd[1:])
+			case "use":
+				menuUse(cmd[1:])
+			case "version":
+				color.Blue(fmt.Sprintf("Merlin version: %s", merlin.Version))
+			case "":
+			default:
+				message("info", "Executing system command...")
+				if len(cmd) > 1 {
+					executeCommand(cmd[0], cmd[1:])
+				} else {
+					var x []string
+					executeCommand(cmd[0], x)
+				}
+			}
+		case "listeners":
+			menuListeners(cmd)
+		case "listener":
+			menuListener(cmd)
+		case "generate":
+			menuGenerate(cmd)
+		case "module":
+			switch cmd[0] {
+			case "show":
+				if len(cmd) > 1 {
+					switch cmd[1] {
+					case "info":
+						shellModule.ShowInfo()
+					case "options":
+						shellModule.ShowOptions()
 					}
-				case "info":
-					shellModule.ShowInfo()
-				case "set":
-					if len(cmd) > 2 {
-						if cmd[1] == "Agent" {
-							s, err := shellModule.SetAgent(cmd[2])
-							if err != nil {
-								message("warn", err.Error())
-							} else {
-								message("success", s)
-							}
+				}
+			case "info":
+				shellModule.ShowInfo()
+			case "set":
+				if len(cmd) > 2 {
+					if cmd[1] == "Agent" {
+						s, err := shellModule.SetAgent(cmd[2])
+						if err != nil {
+							message("warn", err.Error())
 						} else {
-							s, err := shellModule.SetOption(cmd[1], cmd[2])
-							if err != nil {
-								message("warn", err.Error())
-							} else {
-								message("success", s)
-							}
+							message("success", s)
 						}
-					}
-				case "reload":
-					menuSetModule(strings.TrimSuffix(strings.Join(shellModule.Path, "/"), ".json"))
-				case "run":
-					var m string
-					r, err := shellModule.Run()
-					if err != nil {
-						message("warn", err.Error())
-						break
-					}
-					if len(r) <= 0 {
-						message("warn", fmt.Sprintf("The %s module did not return a command to task an"+
-							" agent with", shellModule.Name))
-						break
-					}
-					if strings.ToLower(shellModule.Type) == "standard" {
-						m, err = agents.AddJob(shellModule.Agent, "cmd", r)
 					} else {
-						m, err = agents.AddJob(shellModule.Agent, r[0], r[1:])
-					}
-
-					if err != nil {
-						message("warn", "There was an error adding the job to the specified agent")
-						message("warn", err.Error())
-					} else {
-						message("note", fmt.Sprintf("Created job %s for agent %s at %s",
-							m, shellModule.Agent, time.Now().UTC().Format(time.RFC3339)))
-					}
-
-				case "back", "main":
-					menuSetMain()
-				case "exit", "quit":
-					exit()
-				case "?", "help":
-					menuHelpModule()
-				default:
-					message("info", "Executing system command...")
-					if len(cmd) > 1 {
-						executeCommand(cmd[0], cmd[1:])
-					} else {
-						var x []string
-						executeCommand(cmd[0], x)
-					}
-				}
-			case "agent":
-				switch cmd[0] {
-				case "back":
-					menuSetMain()
-				case "cmd":
-					if len(cmd) > 1 {
-						m, err := agents.AddJob(shellAgent, "cmd", cmd[1:])
+						s, err := shellModule.SetOption(cmd[1], cmd[2])
 						if err != nil {
 							message("warn", err.Error())
 						} else {
-							message("note", fmt.Sprintf("Created job %s for agent %s at %s",
-								m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+							message("success", s)
 						}
 					}
-				case "download":
-					if len(cmd) >= 2 {
-						arg := strings.Join(cmd[1:], " ")
-						argS, errS := shellwords.Parse(arg)
-						if errS != nil {
-							message("warn", fmt.Sprintf("There was an error parsing command line "+
-								"argments: %s\r\n%s", line, errS.Error()))
-							break
-						}
-						if len(argS) >= 1 {
-							m, err := agents.AddJob(shellAgent, "download", argS[0:1])
-							if err != nil {
-								message("warn", err.Error())
-								break
-							} else {
-								message("note", fmt.Sprintf("Created job %s for agent %s at %s",
-									m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
-							}
-						}
+				}
+			case "reload":
+				menuSetModule(strings.TrimSuffix(strings.Join(shellModule.Path, "/"), ".json"))
+			case "run":
+				runModule(false)
+			case "search":
+				menuSearch(cmd[1:])
+			case "rerun":
+				if len(cmd) > 1 && cmd[1] != "--diff" {
+					message("warn", "Invalid 'rerun' command; use rerun [--diff]")
+					break
+				}
+				runModule(len(cmd) > 1)
+			case "back", "main":
+				menuSetMain()
+			case "exit", "quit":
+				exit(cmd[1:])
+			case "?", "help":
+				menuHelpModule()
+			default:
+				message("info", "Executing system command...")
+				if len(cmd) > 1 {
+					executeCommand(cmd[0], cmd[1:])
+				} else {
+					var x []string
+					executeCommand(cmd[0], x)
+				}
+			}
+		case "agent":
+			switch cmd[0] {
+			case "back":
+				menuSetMain()
+			case "cmd":
+				if len(cmd) > 1 {
+					m, err := addJob(shellAgent, "cmd", cmd[1:])
+					if err != nil {
+						message("warn", err.Error())
 					} else {
-						message("warn", "Invalid command")
-						message("info", "download <remote_file_path>")
+						message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+							m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
 					}
-				case "execute-shellcode":
-					if len(cmd) > 2 {
-						options := make(map[string]string)
-						switch strings.ToLower(cmd[1]) {
-						case "self":
-							options["method"] = "self"
-							options["pid"] = ""
-							options["shellcode"] = strings.Join(cmd[2:], " ")
-						case "remote":
-							if len(cmd) > 3 {
-								options["method"] = "remote"
-								options["pid"] = cmd[2]
-								options["shellcode"] = strings.Join(cmd[3:], " ")
-							} else {
-								message("warn", "Not enough arguments. Try using the help command")
-								message("info", "execute-shellcode remote <pid> <shellcode>")
-								break
-							}
-						case "rtlcreateuserthread":
-							if len(cmd) > 3 {
-								options["method"] = "rtlcreateuserthread"
-								options["pid"] = cmd[2]
-								options["shellcode"] = strings.Join(cmd[3:], " ")
-							} else {
-								message("warn", "Not enough arguments. Try using the help command")
-								message("info", "execute-shellcode RtlCreateUserThread <pid> <shellcode>")
-								break
-							}
-						case "userapc":
-							if len(cmd) > 3 {
-								options["method"] = "userapc"
-								options["pid"] = cmd[2]
-								options["shellcode"] = strings.Join(cmd[3:], " ")
-							} else {
-								message("warn", "Not enough arguments. Try using the help command")
-								message("info", "execute-shellcode UserAPC <pid> <shellcode>")
-								break
-							}
-						default:
-							message("warn", "invalid method provided")
-						}
-						if len(options) > 0 {
-							sh, errSh := shellcode.Parse(options)
-							if errSh != nil {
-								message("warn", fmt.Sprintf("there was an error parsing the shellcode:\r\n%s", errSh.Error()))
-								break
-							}
-							m, err := agents.AddJob(shellAgent, sh[0], sh[1:])
-							if err != nil {
-								message("warn", err.Error())
-								break
-							} else {
-								message("note", fmt.Sprintf("Created job %s for agent %s at %s",
-									m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
-							}
-						}
-					} else {
-						message("warn", "not enough arguments were provided")
-						message("info", "execute-shellcode self <shellcode>")
-						message("info", "execute-shellcode remote <pid> <shellcode>")
-						message("info", "execute-shellcode RtlCreateUserThread <pid> <shellcode>")
+				}
+			case "download":
+				if len(cmd) >= 2 {
+					arg := strings.Join(cmd[1:], " ")
+					argS, errS := shellwords.Parse(arg)
+					if errS != nil {
+						message("warn", fmt.Sprintf("There was an error parsing command line "+
+							"argments: %s\r\n%s", line, errS.Error()))
 						break
 					}
-				case "exit", "quit":
-					exit()
-				case "?", "help":
-					menuHelpAgent()
-				case "info":
-					agents.ShowInfo(shellAgent)
-				case "kill":
-					if len(cmd) > 0 {
-						m, err := agents.AddJob(shellAgent, "kill", cmd[0:])
-						menuSetMain()
+					if len(argS) >= 1 {
+						m, err := addJob(shellAgent, "download", argS[0:1])
 						if err != nil {
 							message("warn", err.Error())
+							break
 						} else {
 							message("note", fmt.Sprintf("Created job %s for agent %s at %s",
 								m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
 						}
 					}
-				case "ls":
-					var m string
-					if len(cmd) > 1 {
-						arg := strings.Join(cmd[0:], " ")
-						argS, errS := shellwords.Parse(arg)
-						if errS != nil {
-							message("warn", fmt.Sprintf("There was an error parsing command line "+
-								"argments: %s\r\n%s", line, errS.Error()))
+				} else {
+					message("warn", "Invalid command")
+					message("info", "download <remote_file_path>")
+				}
+			case "execute-shellcode":
+				if len(cmd) > 2 {
+					options := make(map[string]string)
+					switch strings.ToLower(cmd[1]) {
+					case "self":
+						options["method"] = "self"
+						options["pid"] = ""
+						options["shellcode"] = strings.Join(cmd[2:], " ")
+					case "remote":
+						if len(cmd) > 3 {
+							options["method"] = "remote"
+							options["pid"] = cmd[2]
+							options["shellcode"] = strings.Join(cmd[3:], " ")
+						} else {
+							message("warn", "Not enough arguments. Try using the help command")
+							message("info", "execute-shellcode remote <pid> <shellcode>")
 							break
 						}
-						m, err = agents.AddJob(shellAgent, "ls", argS)
-						if err != nil {
-							message("warn", err.Error())
+					case "rtlcreateuserthread":
+						if len(cmd) > 3 {
+							options["method"] = "rtlcreateuserthread"
+							options["pid"] = cmd[2]
+							options["shellcode"] = strings.Join(cmd[3:], " ")
+						} else {
+							message("warn", "Not enough arguments. Try using the help command")
+							message("info", "execute-shellcode RtlCreateUserThread <pid> <shellcode>")
 							break
 						}
-					} else {
-						m, err = agents.AddJob(shellAgent, cmd[0], cmd)
-						if err != nil {
-							message("warn", err.Error())
+					case "userapc":
+						if len(cmd) > 3 {
+							options["method"] = "userapc"
+							options["pid"] = cmd[2]
+							options["shellcode"] = strings.Join(cmd[3:], " ")
+						} else {
+							message("warn", "Not enough arguments. Try using the help command")
+							message("info", "execute-shellcode UserAPC <pid> <shellcode>")
 							break
 						}
+					default:
+						message("warn", "invalid method provided")
 					}
-					message("note", fmt.Sprintf("Created job %s for agent %s at %s",
-						m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
-				case "cd":
-					var m string
-					if len(cmd) > 1 {
-						arg := strings.Join(cmd[0:], " ")
-						argS, errS := shellwords.Parse(arg)
-						if errS != nil {
-							message("warn", fmt.Sprintf("There was an error parsing command line argments: %s\r\n%s", line, errS.Error()))
+					if len(options) > 0 {
+						sh, errSh := shellcode.Parse(options)
+						if errSh != nil {
+							message("warn", fmt.Sprintf("there was an error parsing the shellcode:\r\n%s", errSh.Error()))
 							break
 						}
-						m, err = agents.AddJob(shellAgent, "cd", argS)
+						m, err := addJob(shellAgent, sh[0], sh[1:])
 						if err != nil {
 							message("warn", err.Error())
 							break
+						} else {
+							message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+								m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
 						}
+					}
+				} else {
+					message("warn", "not enough arguments were provided")
+					message("info", "execute-shellcode self <shellcode>")
+					message("info", "execute-shellcode remote <pid> <shellcode>")
+					message("info", "execute-shellcode RtlCreateUserThread <pid> <shellcode>")
+					break
+				}
+			case "diagnose":
+				agents.Diagnose(shellAgent)
+			case "crashes":
+				var n int
+				if len(cmd) > 1 {
+					var err error
+					if n, err = strconv.Atoi(cmd[1]); err != nil {
+						message("warn", fmt.Sprintf("%s is not a valid crash report number", cmd[1]))
+						message("info", "crashes [<number>]")
+						break
+					}
+				}
+				agents.ShowCrashes(shellAgent, n)
+			case "exit", "quit":
+				exit(cmd[1:])
+			case "?", "help":
+				menuHelpAgent()
+			case "info":
+				if len(cmd) > 1 && cmd[1] == "--health" {
+					agents.ShowHealth(shellAgent)
+					break
+				}
+				agents.ShowInfo(shellAgent)
+			case "jobs":
+				menuJobs(shellAgent, cmd[1:])
+			case "kill":
+				if ok, _ := confirmCommand("kill", fmt.Sprintf("Are you sure you want to kill agent %s?", shellAgent), cmd[1:]); ok {
+					m, err := addJob(shellAgent, "kill", cmd[:1])
+					menuSetMain()
+					if err != nil {
+						message("warn", err.Error())
 					} else {
-						m, err = agents.AddJob(shellAgent, "cd", cmd)
-						if err != nil {
-							message("warn", err.Error())
-							break
-						}
+						message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+							m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
 					}
-					message("note", fmt.Sprintf("Created job %s for agent %s at %s",
-						m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
-				case "pwd":
-					var m string
-					m, err = agents.AddJob(shellAgent, "pwd", cmd)
+				}
+			case "selfdestruct":
+				question := fmt.Sprintf("Are you sure you want agent %s to remove its persistence and files and exit?", shellAgent)
+				if ok, _ := confirmCommand("selfdestruct", question, cmd[1:]); ok {
+					m, err := addJob(shellAgent, "selfdestruct", cmd[:1])
 					if err != nil {
 						message("warn", err.Error())
+					} else {
+						message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+							m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+					}
+				}
+			case "ls":
+				var m string
+				if len(cmd) > 1 {
+					arg := strings.Join(cmd[0:], " ")
+					argS, errS := shellwords.Parse(arg)
+					if errS != nil {
+						message("warn", fmt.Sprintf("There was an error parsing command line "+
+							"argments: %s\r\n%s", line, errS.Error()))
 						break
 					}
-					message("note", fmt.Sprintf("Created job %s for agent %s at %s",
-						m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
-				case "main":
-					menuSetMain()
-				case "set":
-					if len(cmd) > 1 {
-						switch cmd[1] {
-						case "killdate":
-							if len(cmd) > 2 {
-								_, errU := strconv.ParseInt(cmd[2], 10, 64)
-								if errU != nil {
-									message("warn", fmt.Sprintf("There was an error converting %s to an"+
-										" int64", cmd[2]))
-									message("info", "Kill date takes in a UNIX epoch timestamp such as"+
-										" 811123200 for September 15, 1995")
-									break
-								}
-								m, err := agents.AddJob(shellAgent, "killdate", cmd[1:])
-								if err != nil {
-									message("warn", fmt.Sprintf("There was an error adding a killdate "+
-										"agent control message:\r\n%s", err.Error()))
-								} else {
-									message("note", fmt.Sprintf("Created job %s for agent %s at %s",
-										m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
-								}
-							}
-						case "maxretry":
-							if len(cmd) > 2 {
-								m, err := agents.AddJob(shellAgent, "maxretry", cmd[1:])
-								if err != nil {
-									message("warn", err.Error())
-								} else {
-									message("note", fmt.Sprintf("Created job %s for agent %s at %s",
-										m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
-								}
-							}
-						case "padding":
-							if len(cmd) > 2 {
-								m, err := agents.AddJob(shellAgent, "padding", cmd[1:])
-								if err != nil {
-									message("warn", err.Error())
-								} else {
-									message("note", fmt.Sprintf("Created job %s for agent %s at %s",
-										m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
-								}
+					m, err = addJob(shellAgent, "ls", argS)
+					if err != nil {
+						message("warn", err.Error())
+						break
+					}
+				} else {
+					m, err = addJob(shellAgent, cmd[0], cmd)
+					if err != nil {
+						message("warn", err.Error())
+						break
+					}
+				}
+				message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+					m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+			case "cd":
+				var m string
+				if len(cmd) > 1 {
+					arg := strings.Join(cmd[0:], " ")
+					argS, errS := shellwords.Parse(arg)
+					if errS != nil {
+						message("warn", fmt.Sprintf("There was an error parsing command line argments: %s\r\n%s", line, errS.Error()))
+						break
+					}
+					m, err = addJob(shellAgent, "cd", argS)
+					if err != nil {
+						message("warn", err.Error())
+						break
+					}
+				} else {
+					m, err = addJob(shellAgent, "cd", cmd)
+					if err != nil {
+						message("warn", err.Error())
+						break
+					}
+				}
+				message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+					m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+			case "results":
+				menuResults(cmd)
+			case "execute-assembly":
+				menuExecuteAssembly(cmd[1:])
+			case "bof":
+				menuBOF(cmd[1:])
+			case "firewall":
+				menuFirewall(cmd[1:])
+			case "move":
+				menuMove(cmd[1:])
+			case "persist":
+				menuPersist(cmd[1:])
+			case "pin":
+				menuPin(cmd[1:])
+			case "callbacks":
+				menuCallbacks(cmd[1:])
+			case "exec":
+				menuExec(cmd[1:])
+			case "schedule":
+				menuSchedule(cmd[1:])
+			case "powershell", "powerpick":
+				menuPowerShell(cmd)
+			case "screenshot":
+				m, err := addJob(shellAgent, "screenshot", nil)
+				if err != nil {
+					message("warn", err.Error())
+					break
+				}
+				message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+					m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+			case "watch":
+				menuWatch(cmd[1:])
+			case "keylog":
+				menuKeylog(cmd[1:])
+			case "pwd":
+				var m string
+				m, err = addJob(shellAgent, "pwd", cmd)
+				if err != nil {
+					message("warn", err.Error())
+					break
+				}
+				message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+					m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+			case "cleanup":
+				entries, errC := agents.CleanupManifest(shellAgent)
+				if errC != nil {
+					message("warn", fmt.Sprintf("There was an error reading the cleanup manifest:\r\n%s", errC.Error()))
+					break
+				}
+				if len(entries) == 0 {
+					message("info", "Jobs have not created any files or directories on the agent's host")
+					break
+				}
+				table := tablewriter.NewWriter(os.Stdout)
+				table.SetHeader([]string{"Created", "Job", "Path"})
+				table.SetAlignment(tablewriter.ALIGN_LEFT)
+				table.AppendBulk(entries)
+				fmt.Println()
+				table.Render()
+				fmt.Println()
+			case "zip", "unzip":
+				argS, errS := shellwords.Parse(strings.Join(cmd[1:], " "))
+				if errS != nil {
+					message("warn", fmt.Sprintf("There was an error parsing command line "+
+						"argments: %s\r\n%s", line, errS.Error()))
+					break
+				}
+				if _, errP := agents.ParseArchive(cmd[0], argS); errP != nil {
+					message("warn", "Invalid command")
+					message("info", errP.Error())
+					break
+				}
+				m, err := addJob(shellAgent, cmd[0], argS)
+				if err != nil {
+					message("warn", err.Error())
+					break
+				}
+				message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+					m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+			case "portfwd":
+				menuPortForward(cmd[1:])
+			case "socks":
+				menuSocks(cmd[1:])
+			case "fetch-tool":
+				argS, errS := shellwords.Parse(strings.Join(cmd[1:], " "))
+				if errS != nil {
+					message("warn", fmt.Sprintf("There was an error parsing command line "+
+						"argments: %s\r\n%s", line, errS.Error()))
+					break
+				}
+				if len(argS) < 1 || len(argS) > 2 {
+					message("warn", "Invalid command")
+					message("info", "fetch-tool <tool> [<remote_file>]")
+					break
+				}
+				platform, _ := agents.GetAgentFieldValue(shellAgent, "platform")
+				if _, errT := tools.Find(argS[0], platform); errT != nil {
+					message("warn", errT.Error())
+					break
+				}
+				m, err := addJob(shellAgent, "fetch-tool", argS)
+				if err != nil {
+					message("warn", err.Error())
+					break
+				}
+				message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+					m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+			case "find", "grep":
+				argS, errS := shellwords.Parse(strings.Join(cmd[1:], " "))
+				if errS != nil {
+					message("warn", fmt.Sprintf("There was an error parsing command line "+
+						"argments: %s\r\n%s", line, errS.Error()))
+					break
+				}
+				// Parse the arguments now so mistakes are reported before the job is sent
+				if _, errP := agents.ParseSearch(cmd[0], argS); errP != nil {
+					message("warn", "Invalid command")
+					message("info", errP.Error())
+					break
+				}
+				m, err := addJob(shellAgent, cmd[0], argS)
+				if err != nil {
+					message("warn", err.Error())
+					break
+				}
+				message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+					m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+			case "loggedon", "sessions-enum":
+				// Optional arguments are the remote host followed by the user name and password to authenticate with
+				argS, errS := shellwords.Parse(strings.Join(cmd[1:], " "))
+				if errS != nil {
+					message("warn", fmt.Sprintf("There was an error parsing command line "+
+						"argments: %s\r\n%s", line, errS.Error()))
+					break
+				}
+				if len(argS) > 3 || len(argS) == 2 {
+					message("warn", "Invalid command")
+					message("info", fmt.Sprintf("%s [<host> [<DOMAIN\\user> <password>]]", cmd[0]))
+					break
+				}
+				m, err := addJob(shellAgent, cmd[0], argS)
+				if err != nil {
+					message("warn", err.Error())
+					break
+				}
+				message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+					m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+			case "main":
+				menuSetMain()
+			case "node", "osascript", "python":
+				// The script is either a file on the server or inline code that is the value of the -c flag;
+				// inline code with spaces must be quoted like it would be in a shell
+				argS, errS := shellwords.Parse(strings.Join(cmd[1:], " "))
+				if errS != nil {
+					message("warn", fmt.Sprintf("There was an error parsing command line argments: %s", line))
+					break
+				}
+				if len(argS) != 1 && (len(argS) != 2 || argS[0] != "-c") {
+					message("warn", "Invalid command")
+					message("info", fmt.Sprintf("%s <local_script_file> OR %s -c \"<inline_script>\"", cmd[0], cmd[0]))
+					break
+				}
+				var args []string
+				if argS[0] == "-c" {
+					args = []string{"inline", argS[1]}
+				} else {
+					if _, errF := os.Stat(argS[0]); errF != nil {
+						message("warn", fmt.Sprintf("There was an error accessing the script file:\r\n%s", errF.Error()))
+						break
+					}
+					args = []string{"file", argS[0]}
+				}
+				if a, ok := agents.Agents[shellAgent]; ok && a.Pid != 0 && !inSlice(cmd[0], a.Interpreters) {
+					message("warn", fmt.Sprintf("A %s interpreter was not found on the agent's host during its "+
+						"initial check in", cmd[0]))
+				}
+				m, err := addJob(shellAgent, cmd[0], args)
+				if err != nil {
+					message("warn", err.Error())
+					break
+				}
+				message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+					m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+			case "set":
+				if len(cmd) > 1 {
+					switch cmd[1] {
+					case "killdate":
+						if len(cmd) > 2 {
+							epoch, errU := agents.ParseKillDate(shellAgent, strings.Join(cmd[2:], " "))
+							if errU != nil {
+								message("warn", errU.Error())
+								message("info", "Kill date takes a UNIX epoch timestamp such as 811123200, an RFC3339 "+
+									"time, a date in a time zone such as 2025-12-01 18:00 CET, or +3d; dates without "+
+									"a time zone are in the agent's time zone")
+								break
 							}
-						case "sleep":
-							if len(cmd) > 2 {
-								m, err := agents.AddJob(shellAgent, "sleep", cmd[1:])
-								if err != nil {
-									message("warn", err.Error())
+							m, err := addJob(shellAgent, "killdate", []string{"killdate", strconv.FormatInt(epoch, 10)})
+							if err != nil {
+								message("warn", fmt.Sprintf("There was an error adding a killdate "+
+									"agent control message:\r\n%s", err.Error()))
+							} else {
+								message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+									m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+								if epoch == 0 {
+									message("info", "The agent's killdate will be removed")
 								} else {
-									message("note", fmt.Sprintf("Created job %s for agent %s at %s",
-										m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+									message("info", fmt.Sprintf("The agent will exit at %s",
+										time.Unix(epoch, 0).UTC().Format(time.RFC3339)))
 								}
-							}
-						case "skew":
-							if len(cmd) > 2 {
-								m, err := agents.AddJob(shellAgent, "skew", cmd[1:])
-								if err != nil {
-									message("warn", err.Error())
-								} else {
-									message("note", fmt.Sprintf("Created job %s for agent %s at %s",
-										m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+								if agents.KillDateImminent(epoch) {
+									message("warn", fmt.Sprintf("The killdate is in %s; the agent exits then",
+										time.Until(time.Unix(epoch, 0)).Round(time.Minute)))
 								}
 							}
 						}
-					}
-				case "shell":
-					if len(cmd) > 1 {
-						m, err := agents.AddJob(shellAgent, "cmd", cmd[1:])
-						if err != nil {
-							message("warn", err.Error())
-						} else {
-							message("note", fmt.Sprintf("Created job %s for agent %s at %s",
-								m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+					case "maxretry":
+						if len(cmd) > 2 {
+							m, err := addJob(shellAgent, "maxretry", cmd[1:])
+							if err != nil {
+								message("warn", err.Error())
+							} else {
+								message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+									m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+							}
 						}
-					}
-				case "status":
-					status := agents.GetAgentStatus(shellAgent)
-					if status == "Active" {
-						color.Green("Active")
-					} else if status == "Delayed" {
-						color.Yellow("Delayed")
-					} else if status == "Dead" {
-						color.Red("Dead")
-					} else {
-						color.Blue(status)
-					}
-				case "upload":
-					if len(cmd) >= 3 {
-						arg := strings.Join(cmd[1:], " ")
-						argS, errS := shellwords.Parse(arg)
-						if errS != nil {
-							message("warn", fmt.Sprintf("There was an error parsing command line "+
-								""+
-								"argments: %s\r\n%s", line, errS.Error()))
-							break
+					case "padding":
+						if len(cmd) > 2 {
+							m, err := addJob(shellAgent, "padding", cmd[1:])
+							if err != nil {
+								message("warn", err.Error())
+							} else {
+								message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+									m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+							}
 						}
-						if len(argS) >= 2 {
-							_, errF := os.Stat(argS[0])
-							if errF != nil {
-								message("warn", fmt.Sprintf("There was an error accessing the source "+
-									"upload file:\r\n%s", errF.Error()))
-								break
+					case "sleep":
+						if len(cmd) > 2 {
+							m, err := addJob(shellAgent, "sleep", cmd[1:])
+							if err != nil {
+								message("warn", err.Error())
+							} else {
+								message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+									m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
 							}
-							m, err := agents.AddJob(shellAgent, "upload", argS[0:2])
+						}
+					case "skew":
+						if len(cmd) > 2 {
+							m, err := addJob(shellAgent, "skew", cmd[1:])
 							if err != nil {
 								message("warn", err.Error())
-								break
 							} else {
 								message("note", fmt.Sprintf("Created job %s for agent %s at %s",
 									m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
 							}
 						}
+					}
+				}
+			case "jitter":
+				if len(cmd) != 2 {
+					message("warn", "Invalid command")
+					message("info", "jitter <percent>")
+					break
+				}
+				if j, err := strconv.Atoi(cmd[1]); err != nil || j < 0 || j > 100 {
+					message("warn", fmt.Sprintf("%s is not a percent between 0 and 100", cmd[1]))
+					break
+				}
+				m, err := addJob(shellAgent, "jitter", []string{"jitter", cmd[1]})
+				if err != nil {
+					message("warn", err.Error())
+					break
+				}
+				message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+					m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+			case "workinghours":
+				if len(cmd) < 2 {
+					message("warn", "Invalid command")
+					message("info", "workinghours <HHMM-HHMM> [<days>], workinghours off (i.e. workinghours 0900-1700 Mon-Fri)")
+					break
+				}
+				// Check the working hours before they are sent so a typo does not wait for the agent to check in
+				hours := strings.Join(cmd[1:], " ")
+				if _, err := schedule.Parse(hours); err != nil {
+					message("warn", err.Error())
+					break
+				}
+				m, err := addJob(shellAgent, "workinghours", []string{"workinghours", hours})
+				if err != nil {
+					message("warn", err.Error())
+					break
+				}
+				message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+					m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+			case "shell":
+				if len(cmd) == 1 {
+					menuSetShell()
+				} else {
+					m, err := addJob(shellAgent, "cmd", cmd[1:])
+					if err != nil {
+						message("warn", err.Error())
 					} else {
-						message("warn", "Invalid command")
-						message("info", "upload local_file_path remote_file_path")
+						message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+							m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+					}
+				}
+			case "status":
+				if len(cmd) > 1 {
+					menuAgentStatus(cmd[1:])
+					break
+				}
+				status := agents.GetAgentStatus(shellAgent)
+				if status == "Active" {
+					color.Green("Active")
+				} else if status == "Delayed" {
+					color.Yellow("Delayed")
+				} else if status == "Dead" {
+					color.Red("Dead")
+				} else {
+					color.Blue(status)
+				}
+			case "use":
+				menuAgentUse(cmd[1:])
+			case "upload":
+				if len(cmd) >= 3 {
+					arg := strings.Join(cmd[1:], " ")
+					argS, errS := shellwords.Parse(arg)
+					if errS != nil {
+						message("warn", fmt.Sprintf("There was an error parsing command line "+
+							""+
+							"argments: %s\r\n%s", line, errS.Error()))
+						break
 					}
-				default:
-					message("info", "Executing system command...")
-					if len(cmd) > 1 {
-						executeCommand(cmd[0], cmd[1:])
+					if len(argS) >= 2 {
+						_, errF := os.Stat(argS[0])
+						if errF != nil {
+							message("warn", fmt.Sprintf("There was an error accessing the source "+
+								"upload file:\r\n%s", errF.Error()))
+							break
+						}
+						m, err := addJob(shellAgent, "upload", argS[0:2])
+						if err != nil {
+							message("warn", err.Error())
+							break
+						} else {
+							message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+								m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+						}
+					}
+				} else {
+					message("warn", "Invalid command")
+					message("info", "upload local_file_path remote_file_path")
+				}
+			case "wasm":
+				argS, errS := shellwords.Parse(strings.Join(cmd[1:], " "))
+				if errS != nil {
+					message("warn", fmt.Sprintf("There was an error parsing command line "+
+						"argments: %s\r\n%s", line, errS.Error()))
+					break
+				}
+				// No host API capability is granted unless the operator allows it with -allow
+				capabilities := ""
+				timeout := "0s"
+				for len(argS) > 1 && (argS[0] == "-allow" || argS[0] == "-timeout") {
+					if argS[0] == "-allow" {
+						capabilities = argS[1]
 					} else {
-						var x []string
-						executeCommand(cmd[0], x)
+						timeout = argS[1]
 					}
+					argS = argS[2:]
+				}
+				if len(argS) < 1 || strings.HasPrefix(argS[0], "-") {
+					message("warn", "Invalid command")
+					message("info", "wasm [-allow file,process,net] [-timeout 5m] <local_wasm_file> [args...]")
+					message("info", "The module's file, process, and network host functions are denied unless granted with -allow")
+					break
+				}
+				if _, errT := time.ParseDuration(timeout); errT != nil {
+					message("warn", fmt.Sprintf("There was an error parsing the timeout:\r\n%s", errT.Error()))
+					break
+				}
+				if capabilities == "none" {
+					capabilities = ""
+				}
+				if _, errF := os.Stat(argS[0]); errF != nil {
+					message("warn", fmt.Sprintf("There was an error accessing the WebAssembly module:\r\n%s", errF.Error()))
+					break
+				}
+				m, err := addJob(shellAgent, "wasm", append([]string{argS[0], capabilities, timeout}, argS[1:]...))
+				if err != nil {
+					message("warn", err.Error())
+					break
+				}
+				message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+					m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+			default:
+				message("info", "Executing system command...")
+				if len(cmd) > 1 {
+					executeCommand(cmd[0], cmd[1:])
+				} else {
+					var x []string
+					executeCommand(cmd[0], x)
 				}
 			}
 		}
-
 	}
 }
 
@@ -535,34 +1022,481 @@ func menuUse(cmd []string) {
 		default:
 			color.Yellow("[-]Invalid 'use' command")
 		}
-	} else {
-		color.Yellow("[-]Invalid 'use' command")
+	} else {
+		color.Yellow("[-]Invalid 'use' command")
+	}
+}
+
+// menuModules lists the modules in the module index or installs one after verifying its signature, or installs a module
+// pack from a git repository or path
+func menuModules(cmd []string) {
+	if len(cmd) == 0 {
+		message("warn", "Invalid 'modules' command; use list or install <module>")
+		return
+	}
+	switch cmd[0] {
+	case "list":
+		entries, err := modules.GetIndex()
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		installed := modules.GetModuleList()("")
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Module", "Author", "Installed", "Description"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, e := range entries {
+			table.Append([]string{e.Name, e.Author, strconv.FormatBool(inSlice(e.Name, installed)), e.Description})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case "install":
+		if len(cmd) < 2 {
+			message("warn", "Invalid 'modules install' command; use modules install <module|git URL|path>")
+			return
+		}
+		if modules.IsPack(cmd[1]) {
+			// Module packs are read from the server's file system unless they are cloned from a git repository
+			if shellOperator != "" && !modules.IsGitURL(cmd[1]) {
+				message("warn", "Module packs can only be installed from a path from the server's console")
+				return
+			}
+			installPack(cmd[1])
+			return
+		}
+		author, err := modules.Install(cmd[1])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Installed the %s module signed by %s; use it with 'use module %s'", cmd[1], author, cmd[1]))
+	default:
+		message("warn", fmt.Sprintf("Invalid 'modules' command: %s", cmd[0]))
+	}
+}
+
+// installPack installs the modules in a git repository, directory, or module file and refreshes the completer so the
+// new modules can be used right away
+func installPack(source string) {
+	if modules.IsGitURL(source) {
+		message("info", fmt.Sprintf("Cloning %s...", source))
+	}
+	ctx, stop := interruptible()
+	result, err := modules.InstallPack(ctx, source)
+	stop()
+	var skipped []string
+	for file := range result.Invalid {
+		skipped = append(skipped, file)
+	}
+	sort.Strings(skipped)
+	for _, file := range skipped {
+		message("warn", fmt.Sprintf("Skipped %s: %s", file, result.Invalid[file]))
+	}
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	prompt.Config.AutoComplete = getCompleter(shellMenuContext)
+	for _, name := range result.Installed {
+		message("success", fmt.Sprintf("Installed the %s module", name))
+	}
+	for _, name := range result.Updated {
+		message("success", fmt.Sprintf("Updated the %s module", name))
+	}
+	message("info", fmt.Sprintf("Installed %d, updated %d, and skipped %d modules from %s",
+		len(result.Installed), len(result.Updated), len(result.Invalid), source))
+}
+
+// menuSocks starts, stops, or shows the status of the SOCKS5 proxy tunneling through the current agent
+func menuSocks(cmd []string) {
+	if len(cmd) == 0 {
+		message("warn", "Invalid 'socks' command; use start [[<interface>:]<port>], stop, or status")
+		return
+	}
+	switch cmd[0] {
+	case "start":
+		addr := "127.0.0.1:1080"
+		if len(cmd) > 1 {
+			addr = cmd[1]
+			if !strings.Contains(addr, ":") {
+				addr = "127.0.0.1:" + addr
+			}
+		}
+		m, err := agents.StartSocks(shellAgent, addr)
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		if m != "" {
+			message("note", fmt.Sprintf("Created job %s for agent %s at %s", m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+		}
+		message("success", fmt.Sprintf("Started a SOCKS5 proxy on %s; connections are tunneled once the agent starts its tunnel", addr))
+	case "stop":
+		if err := tunnel.StopSocks(shellAgent); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Stopped the SOCKS5 proxy for agent %s", shellAgent))
+	case "status":
+		status := tunnel.GetSocks()
+		if len(status) == 0 {
+			message("info", "There are no SOCKS5 proxies running")
+			return
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Agent", "Address", "Started", "Connections", "Sent", "Received"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, st := range status {
+			table.Append([]string{st.AgentID.String(), st.Address, st.Started.Format(time.RFC3339),
+				strconv.Itoa(st.Connections), strconv.FormatUint(st.Sent, 10), strconv.FormatUint(st.Received, 10)})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	default:
+		message("warn", fmt.Sprintf("Invalid 'socks' command: %s", cmd[0]))
+	}
+}
+
+// menuPortForward adds, lists, or removes port forwards through the current agent
+func menuPortForward(cmd []string) {
+	if len(cmd) == 0 {
+		message("warn", "Invalid 'portfwd' command; use add, list, or remove")
+		return
+	}
+	switch cmd[0] {
+	case "add":
+		if len(cmd) != 4 || (cmd[1] != "local" && cmd[1] != "reverse") {
+			message("warn", "Invalid 'portfwd add' command")
+			message("info", "portfwd add local [<interface>:]<server port> <target host>:<port>")
+			message("info", "portfwd add reverse [<interface>:]<agent port> <server side host>:<port>")
+			return
+		}
+		listen := cmd[2]
+		if !strings.Contains(listen, ":") {
+			listen = "127.0.0.1:" + listen
+			// A reverse port forward listens on every IPv4 and IPv6 interface of the agent's host
+			if cmd[1] == "reverse" {
+				listen = ":" + cmd[2]
+			}
+		}
+		f, m, err := agents.AddPortForward(shellAgent, cmd[1], listen, cmd[3])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		if m != "" {
+			message("note", fmt.Sprintf("Created job %s for agent %s at %s", m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+		}
+		if f.Type == "local" {
+			message("success", fmt.Sprintf("Added port forward %d; connections to %s on the server are forwarded through the agent to %s",
+				f.ID, f.Listen, f.Target))
+		} else {
+			message("success", fmt.Sprintf("Added port forward %d; connections to %s on the agent's host are forwarded to %s from the server",
+				f.ID, f.Listen, f.Target))
+		}
+	case "list":
+		forwards := tunnel.GetForwards(shellAgent)
+		if len(forwards) == 0 {
+			message("info", fmt.Sprintf("Agent %s does not have any port forwards", shellAgent))
+			return
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ID", "Type", "Listen", "Target", "Connections", "Sent", "Received", "Error"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, f := range forwards {
+			table.Append([]string{strconv.Itoa(int(f.ID)), f.Type, f.Listen, f.Target, strconv.Itoa(f.Connections),
+				strconv.FormatUint(f.Sent, 10), strconv.FormatUint(f.Received, 10), f.Error})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case "remove":
+		if len(cmd) != 2 {
+			message("warn", "Invalid 'portfwd remove' command; use portfwd remove <id>")
+			return
+		}
+		id, err := strconv.ParseUint(cmd[1], 10, 32)
+		if err != nil {
+			message("warn", fmt.Sprintf("%s is not a valid port forward ID", cmd[1]))
+			return
+		}
+		if err = tunnel.RemoveForward(shellAgent, uint32(id)); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Removed port forward %d", id))
+	default:
+		message("warn", fmt.Sprintf("Invalid 'portfwd' command: %s", cmd[0]))
+	}
+}
+
+// menuThrottle shows or sets the limits for jobs created for more than one agent
+func menuThrottle(cmd []string) {
+	switch {
+	case len(cmd) == 0:
+	case len(cmd) == 1 && cmd[0] == "off":
+		_ = agents.SetThrottle(0, 0)
+	case len(cmd) == 2:
+		max, err := strconv.Atoi(cmd[0])
+		if err != nil {
+			message("warn", fmt.Sprintf("%s is not a valid number of agents", cmd[0]))
+			return
+		}
+		stagger, err := time.ParseDuration(cmd[1])
+		if err != nil {
+			message("warn", fmt.Sprintf("%s is not a valid duration (i.e. 30s)", cmd[1]))
+			return
+		}
+		if err = agents.SetThrottle(max, stagger); err != nil {
+			message("warn", err.Error())
+			return
+		}
+	default:
+		message("warn", "Invalid 'throttle' command; use throttle [<max agents> <stagger>|off]")
+		return
+	}
+	t := agents.GetThrottle()
+	if t.Max == 0 && t.Stagger == 0 {
+		message("info", "Jobs for more than one agent are released to every agent at once")
+		return
+	}
+	max := "unlimited"
+	if t.Max > 0 {
+		max = strconv.Itoa(t.Max)
+	}
+	message("info", fmt.Sprintf("Jobs for more than one agent are released to %s agents at once, %s apart", max, t.Stagger))
+}
+
+// menuApproval turns registration approval on or off and lists the agents waiting to be accepted
+func menuApproval(cmd []string) {
+	switch {
+	case len(cmd) == 0:
+	case len(cmd) == 1 && (cmd[0] == "on" || cmd[0] == "off"):
+		agents.SetApproval(cmd[0] == "on")
+	default:
+		message("warn", "Invalid 'approval' command; use approval [on|off]")
+		return
+	}
+	if !agents.GetApproval() {
+		message("info", "Agents that register can be tasked immediately")
+	} else {
+		message("info", "Agents that register are pending until they are accepted with 'accept <agent>'")
+	}
+	pending := agents.GetPendingAgents()
+	if len(pending) == 0 {
+		return
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Agent", "Platform", "User", "Host", "IPs", "Initial Check In", "Last Check In"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, id := range pending {
+		a := agents.Agents[id]
+		table.Append([]string{id.String(), a.Platform + "/" + a.Architecture, a.UserName, a.HostName,
+			strings.Join(a.Ips, "\n"), a.InitialCheckIn.Format(time.RFC3339), a.StatusCheckIn.Format(time.RFC3339)})
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
+
+// menuBarrier lists or cancels barriers, or creates a barrier that runs a command on every agent at the same time once
+// they have all checked in
+func menuBarrier(cmd []string) {
+	if len(cmd) == 0 {
+		message("warn", "Invalid 'barrier' command; use barrier <agent>|tag:<tag>[,...]|all <command> [<args>], list, "+
+			"or cancel <barrier ID>")
+		return
+	}
+	switch cmd[0] {
+	case "list":
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Barrier", "Created", "Agents", "Ready", "Waiting For", "Release"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		list := agents.GetBarriers()
+		for _, b := range list {
+			var waiting []string
+			for a := range b.Jobs {
+				if _, ok := b.Ready[a]; !ok {
+					waiting = append(waiting, a.String())
+				}
+			}
+			sort.Strings(waiting)
+			release := "when every agent is ready"
+			if !b.Release.IsZero() {
+				release = b.Release.Format(time.RFC3339)
+			}
+			table.Append([]string{b.ID, b.Created.Format(time.RFC3339), strconv.Itoa(len(b.Jobs)),
+				strconv.Itoa(len(b.Ready)), strings.Join(waiting, "\n"), release})
+		}
+		if len(list) == 0 {
+			message("note", "There are no barriers with jobs waiting to be sent")
+			return
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case "cancel":
+		if len(cmd) < 2 {
+			message("warn", "Invalid 'barrier cancel' command; use barrier cancel <barrier ID>")
+			return
+		}
+		if err := agents.CancelBarrier(cmd[1]); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Canceled barrier %s and removed its jobs that were not sent", cmd[1]))
+	default:
+		if len(cmd) < 2 {
+			message("warn", "Invalid 'barrier' command; use barrier <agent>|tag:<tag>[,...]|all <command> [<args>]")
+			return
+		}
+		var barrierAgents []uuid.UUID
+		if cmd[0] == "all" {
+			for k := range agents.Agents {
+				if status := agents.GetAgentStatus(k); status != "Dead" && status != "Pending" {
+					barrierAgents = append(barrierAgents, k)
+				}
+			}
+		} else {
+			var err error
+			if barrierAgents, err = agents.Targets(cmd[0]); err != nil {
+				message("warn", err.Error())
+				return
+			}
+		}
+		b, err := agents.AddBarrierJobs(barrierAgents, agents.Job{Type: "cmd", Args: cmd[1:], After: embargo})
+		if err != nil {
+			message("warn", fmt.Sprintf("There was an error creating the barrier:\r\n%s", err.Error()))
+			return
+		}
+		for a, job := range b.Jobs {
+			message("note", fmt.Sprintf("Created job %s for agent %s at %s", job, a, b.Created.Format(time.RFC3339)))
+		}
+		message("info", fmt.Sprintf("Barrier %s holds the jobs until all %d agents check in; use 'barrier list' to "+
+			"view the agents it is waiting for", b.ID, len(b.Jobs)))
+	}
+}
+
+// menuJobs lists the jobs waiting for the agent, or every agent if the ID is uuid.Nil, to check in with their priority
+// and risk score. The risk argument sorts them from highest to lowest risk and an optional minimum score hides the
+// rest; cancel and priority change a queued job.
+func menuJobs(agentID uuid.UUID, cmd []string) {
+	var byRisk bool
+	var min int
+	if len(cmd) > 0 && (cmd[0] == "cancel" || cmd[0] == "priority") {
+		menuJob(agentID, cmd)
+		return
+	}
+	if len(cmd) > 0 {
+		if cmd[0] != "risk" || len(cmd) > 2 {
+			message("warn", "Invalid 'jobs' command")
+			message("info", jobsUsage)
+			return
+		}
+		byRisk = true
+		if len(cmd) > 1 {
+			var err error
+			min, err = strconv.Atoi(cmd[1])
+			if err != nil || min < 0 || min > 100 {
+				message("warn", fmt.Sprintf("%s is not a valid risk score between 0 and 100", cmd[1]))
+				return
+			}
+		}
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Agent", "Job", "Type", "Arguments", "Created", "Embargoed Until", "Priority", "Risk", "Reasons"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	var count int
+	for _, q := range agents.GetQueuedJobs(agentID, byRisk) {
+		if q.Risk.Score < min {
+			continue
+		}
+		args := redact.String(strings.Join(q.Job.Args, " "))
+		if len(args) > 50 {
+			args = args[:47] + "..."
+		}
+		var after string
+		if !q.Job.After.IsZero() {
+			after = q.Job.After.Format(time.RFC3339)
+		}
+		table.Append([]string{q.Agent.String(), q.Job.ID, q.Job.Type, args, q.Job.Created.Format(time.RFC3339), after,
+			agents.PriorityName(q.Job.Priority), fmt.Sprintf("%d %s", q.Risk.Score, q.Risk.Level), strings.Join(q.Risk.Reasons, ", ")})
+		count++
+	}
+	if count == 0 {
+		message("note", "There are no queued jobs waiting for an agent to check in")
+		return
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
+
+// menuTools lists the tool repository's index or adds a file to it
+func menuTools(cmd []string) {
+	if len(cmd) == 0 {
+		message("warn", "Invalid 'tools' command; use list or add <file> <name> <platform> [<description>]")
+		return
+	}
+	switch cmd[0] {
+	case "list":
+		index, err := tools.GetIndex()
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		if len(index) == 0 {
+			message("info", fmt.Sprintf("The tool repository at %s is empty", tools.Directory()))
+			return
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Tool", "Platform", "SHA-256", "Description"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, t := range index {
+			table.Append([]string{t.Name, t.Platform, t.SHA256, t.Description})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case "add":
+		args, err := shellwords.Parse(strings.Join(cmd[1:], " "))
+		if err != nil || len(args) < 3 {
+			message("warn", "Invalid 'tools add' command; use tools add <file> <name> <platform> [<description>]")
+			return
+		}
+		t, err := tools.Add(args[0], args[1], args[2], strings.Join(args[3:], " "))
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Added the %s tool for %s with SHA-256 %s", t.Name, t.Platform, t.SHA256))
+	default:
+		message("warn", fmt.Sprintf("Invalid 'tools' command: %s", cmd[0]))
 	}
 }
 
 func menuAgent(cmd []string) {
 	switch cmd[0] {
+	case "group":
+		menuAgentGroup(cmd)
+	case "tag", "untag":
+		menuAgentTag(cmd)
+	case "killall":
+		menuKillAll(cmd[1:])
 	case "list":
-		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{"Agent GUID", "Platform", "User", "Host", "Transport", "Status"})
-		table.SetAlignment(tablewriter.ALIGN_CENTER)
-		for k, v := range agents.Agents {
-			// Convert proto (i.e. h2 or hq) to user friendly string
-			var proto string
-			if v.Proto == "https" {
-				proto = "HTTP/1.1 (https)"
-			} else if v.Proto == "h2" {
-				proto = "HTTP/2 (h2)"
-			} else if v.Proto == "hq" {
-				proto = "QUIC (hq)"
+		listSessions(cmd[1:])
+	case "diagnose":
+		if len(cmd) > 1 {
+			i, errUUID := uuid.FromString(cmd[1])
+			if errUUID != nil {
+				message("warn", fmt.Sprintf("There was an error interacting with agent %s", cmd[1]))
+			} else {
+				agents.Diagnose(i)
 			}
-
-			table.Append([]string{k.String(), v.Platform + "/" + v.Architecture, v.UserName,
-				v.HostName, proto, agents.GetAgentStatus(k)})
 		}
-		fmt.Println()
-		table.Render()
-		fmt.Println()
 	case "interact":
 		if len(cmd) > 1 {
 			i, errUUID := uuid.FromString(cmd[1])
@@ -590,17 +1524,410 @@ func menuAgent(cmd []string) {
 	}
 }
 
+// sessionList returns the agents in the order they first checked in, which is the order they are numbered in the
+// sessions list
+func sessionList() []uuid.UUID {
+	var ids []uuid.UUID
+	for k := range agents.Agents {
+		ids = append(ids, k)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := agents.Agents[ids[i]].InitialCheckIn, agents.Agents[ids[j]].InitialCheckIn
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return ids[i].String() < ids[j].String()
+	})
+	return ids
+}
+
+// menuSessionsCopy copies the ID of the agent numbered n in the sessions list to the clipboard
+func menuSessionsCopy(cmd []string) {
+	if len(cmd) < 1 {
+		message("warn", "Invalid 'sessions copy' command; use sessions copy <n>")
+		return
+	}
+	ids := sessionList()
+	n, err := strconv.Atoi(cmd[0])
+	if err != nil || n < 1 || n > len(ids) {
+		message("warn", fmt.Sprintf("%s is not a session number from the sessions list", cmd[0]))
+		return
+	}
+	copyText(ids[n-1].String(), fmt.Sprintf("agent ID %s", ids[n-1]))
+}
+
+func menuBackup(cmd []string) {
+	if len(cmd) < 1 {
+		interval := "off"
+		if backup.Scheduled() > 0 {
+			interval = backup.Scheduled().String()
+		}
+		o := backup.Default()
+		message("info", fmt.Sprintf("Scheduled backup: %s", interval))
+		message("info", fmt.Sprintf("Remote storage: %s", o.Remote))
+		message("info", fmt.Sprintf("Backup key: %s", o.KeyFile))
+		return
+	}
+
+	switch cmd[0] {
+	case "now":
+		ctx, stop := interruptible()
+		backupReport(backup.NowContext(ctx, backup.Default()))
+		stop()
+	case "schedule":
+		if len(cmd) < 2 {
+			message("warn", "Not enough arguments provided")
+			message("info", "backup schedule <interval|off>")
+			return
+		}
+		if cmd[1] == "off" {
+			ScheduleBackup(0)
+			return
+		}
+		interval, err := time.ParseDuration(cmd[1])
+		if err != nil || interval < time.Minute {
+			message("warn", fmt.Sprintf("%s is not a valid interval of at least 1m (i.e. 30m or 4h)", cmd[1]))
+			return
+		}
+		ScheduleBackup(interval)
+	case "remote":
+		if len(cmd) < 2 {
+			backup.Remote = ""
+			message("info", "Backups will not be copied to remote storage")
+			return
+		}
+		backup.Remote = cmd[1]
+		message("info", fmt.Sprintf("Backups will be copied to %s", backup.Remote))
+	case "decrypt":
+		if len(cmd) < 2 {
+			message("warn", "Not enough arguments provided")
+			message("info", "backup decrypt <backup file>")
+			return
+		}
+		output := strings.TrimSuffix(cmd[1], ".enc")
+		if output == cmd[1] {
+			output += ".tar.gz"
+		}
+		if err := backup.Decrypt(cmd[1], backup.Default().KeyFile, output); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Decrypted backup to %s", output))
+	default:
+		message("warn", "Invalid 'backup' command")
+		message("info", "backup [now | schedule <interval|off> | remote [<directory|URL>] | decrypt <backup file>]")
+	}
+}
+
+// ScheduleBackup starts, replaces, or with an interval of zero stops, the scheduled backup of the engagement data
+func ScheduleBackup(interval time.Duration) {
+	backup.Schedule(interval, backup.Default(), backupReport)
+	if interval <= 0 {
+		message("info", "Scheduled backups are off")
+		return
+	}
+	m := fmt.Sprintf("Backing up engagement data every %s", interval)
+	message("info", m)
+	logging.Server(m)
+}
+
+// backupReport displays and logs the result of a backup
+func backupReport(name string, err error) {
+	if err != nil {
+		m := fmt.Sprintf("There was an error creating the backup:\r\n%s", err.Error())
+		message("warn", m)
+		logging.Server(m)
+		return
+	}
+	m := fmt.Sprintf("Created encrypted backup %s", name)
+	message("success", m)
+	logging.Server(m)
+}
+
+// resourceDepth is the number of resource files being executed, used to stop a file that runs itself
+var resourceDepth int
+
+// resourceVariable matches a ${NAME} variable in a resource file
+var resourceVariable = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// resourceAssignment matches a NAME=value line that sets a variable in a resource file
+var resourceAssignment = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
+
+// menuResource executes the commands in a resource file line by line as if they were typed at the prompt. Lines that
+// start with # are comments, NAME=value lines set a variable, and ${NAME} is replaced with the variable's value or the
+// environment variable of the same name. Variables can also be set after the file name.
+func menuResource(cmd []string) {
+	if len(cmd) < 1 {
+		message("warn", "Invalid command")
+		message("info", "resource <file> [NAME=value ...]")
+		return
+	}
+	// Resource files are read from the server's file system and each line is displayed as it is executed
+	if shellOperator != "" {
+		message("warn", "Resource files can only be executed from the server's console")
+		return
+	}
+	if resourceDepth >= 10 {
+		message("warn", fmt.Sprintf("Resource file %s was not executed because resource files are nested too deeply", cmd[0]))
+		return
+	}
+	data, err := ioutil.ReadFile(cmd[0]) // #nosec G304 - The file is provided by the operator
+	if err != nil {
+		message("warn", fmt.Sprintf("There was an error reading the resource file:\r\n%s", err.Error()))
+		return
+	}
+
+	variables := make(map[string]string)
+	for _, v := range cmd[1:] {
+		a := resourceAssignment.FindStringSubmatch(v)
+		if a == nil {
+			message("warn", fmt.Sprintf("%s is not a valid NAME=value variable", v))
+			return
+		}
+		variables[a[1]] = a[2]
+	}
+
+	resourceDepth++
+	defer func() { resourceDepth-- }()
+	logging.Server(fmt.Sprintf("Executing resource file %s", cmd[0]))
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var undefined []string
+		line = resourceVariable.ReplaceAllStringFunc(line, func(v string) string {
+			name := resourceVariable.FindStringSubmatch(v)[1]
+			if value, ok := variables[name]; ok {
+				return value
+			}
+			if value, ok := os.LookupEnv(name); ok {
+				return value
+			}
+			undefined = append(undefined, name)
+			return v
+		})
+		if len(undefined) > 0 {
+			message("warn", fmt.Sprintf("Stopped executing %s at line %d because %s is not defined", cmd[0], n+1,
+				strings.Join(undefined, ", ")))
+			return
+		}
+		if a := resourceAssignment.FindStringSubmatch(line); a != nil {
+			variables[a[1]] = a[2]
+			continue
+		}
+		message("note", fmt.Sprintf("%s:%d» %s", path.Base(cmd[0]), n+1, redact.String(line)))
+		executeLine(line)
+	}
+}
+
+func menuTargets(cmd []string) {
+	if len(cmd) < 1 || cmd[0] != "users" {
+		message("warn", "Invalid 'targets' command")
+		message("info", "targets users [<user name>]")
+		return
+	}
+
+	var users []string
+	if len(cmd) > 1 {
+		users = []string{cmd[1]}
+	} else {
+		users = targets.GetUsers()
+	}
+	showUserLocations(users)
+}
+
+// showUserLocations prints a table of every location the provided users were observed
+func showUserLocations(users []string) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"User", "Host", "Session", "State", "Client", "Method", "Agent", "Last Seen"})
+	table.SetAlignment(tablewriter.ALIGN_CENTER)
+	var count int
+	for _, user := range users {
+		for _, l := range targets.GetUserLocations(user) {
+			name := l.UserName
+			if l.Domain != "" {
+				name = l.Domain + "\\" + l.UserName
+			}
+			table.Append([]string{name, l.Host, strconv.FormatUint(uint64(l.SessionID), 10), l.State, l.Client,
+				l.Method, l.AgentID.String(), l.Seen.Format(time.RFC3339)})
+			count++
+		}
+	}
+	if count == 0 {
+		message("note", "No user locations found; use the sessions-enum or loggedon agent commands to gather them")
+		return
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
+
+func menuHunt(cmd []string) {
+	if len(cmd) < 2 || (cmd[0] != "user" && cmd[0] != "status") {
+		message("warn", "Invalid 'hunt' command")
+		message("info", "hunt user <user name> [<agent>|tag:<tag>[,...]]")
+		message("info", "hunt status <user name>")
+		return
+	}
+
+	if cmd[0] == "status" {
+		h, ok := targets.GetHunt(cmd[1])
+		if !ok {
+			message("warn", fmt.Sprintf("%s is not being hunted", cmd[1]))
+			return
+		}
+		hosts := targets.GetHuntedHosts(cmd[1])
+		message("info", fmt.Sprintf("Hunt for %s started at %s tasked %d agents and found %d hosts: %s",
+			h.User, h.Started.Format(time.RFC3339), len(h.Agents), len(hosts), strings.Join(hosts, ", ")))
+		showUserLocations([]string{h.User})
+		return
+	}
+
+	// Use the provided agents, otherwise hunt with every active Windows agent
+	var huntAgents []uuid.UUID
+	if len(cmd) > 2 {
+		var err error
+		if huntAgents, err = agents.Targets(cmd[2]); err != nil {
+			message("warn", err.Error())
+			return
+		}
+	} else {
+		for k, v := range agents.Agents {
+			if v.Platform == "windows" && agents.GetAgentStatus(k) == "Active" {
+				huntAgents = append(huntAgents, k)
+			}
+		}
+	}
+	if len(huntAgents) == 0 {
+		message("warn", "There are no active Windows agents to hunt with")
+		return
+	}
+
+	ids, err := agents.AddGroupJobs(huntAgents, []agents.Job{{Type: "sessions-enum", After: embargo}, {Type: "loggedon", After: embargo}})
+	if err != nil {
+		message("warn", fmt.Sprintf("There was an error creating the hunt jobs:\r\n%s", err.Error()))
+		return
+	}
+	var tasked []uuid.UUID
+	for _, a := range huntAgents {
+		message("note", fmt.Sprintf("Created jobs %s for agent %s at %s",
+			strings.Join(ids[a], ", "), a, time.Now().UTC().Format(time.RFC3339)))
+		tasked = append(tasked, a)
+	}
+	if t := agents.GetThrottle(); t.Max > 0 || t.Stagger > 0 {
+		message("info", fmt.Sprintf("The jobs are released to at most %d agents at once, %s apart", t.Max, t.Stagger))
+	}
+	targets.AddHunt(cmd[1], tasked)
+	message("info", fmt.Sprintf("Hunting for %s with %d agents; use 'hunt status %s' to view the results", cmd[1], len(tasked), cmd[1]))
+}
+
 func menuSetAgent(agentID uuid.UUID) {
 	for k := range agents.Agents {
 		if agentID == agents.Agents[k].ID {
 			shellAgent = agentID
 			prompt.Config.AutoComplete = getCompleter("agent")
-			prompt.SetPrompt("\033[31mMerlin[\033[32magent\033[31m][\033[33m" + shellAgent.String() + "\033[31m]»\033[0m ")
+			setPrompt("\033[31mMerlin[\033[32magent\033[31m][\033[33m" + shellAgent.String() + "\033[31m]»\033[0m ")
 			shellMenuContext = "agent"
 		}
 	}
 }
 
+// moduleAgents returns the tags, prefixed with tag:, and the agents the module being used runs on for tab completion
+func moduleAgents() func(string) []string {
+	return func(line string) []string {
+		var ids []string
+		for tag := range agents.Groups() {
+			ids = append(ids, agents.TagPrefix+tag)
+		}
+		sort.Strings(ids)
+		for _, id := range shellModule.CompatibleAgents() {
+			ids = append(ids, id.String())
+		}
+		return ids
+	}
+}
+
+// runModule tasks the module's agent with the module and caches the results; with diff, the results are compared with
+// the module's previous results on the agent when they are returned
+func runModule(diff bool) {
+	if diff {
+		if shellModule.Fanout() {
+			message("warn", "Results can only be compared for a single agent; set the module's Agent option to one agent")
+			return
+		}
+		if _, ok := agents.LastModuleResult(shellModule.Agent, shellModule.Name); !ok {
+			message("note", fmt.Sprintf("There are no previous %s module results for agent %s to compare with; "+
+				"these results will be cached", shellModule.Name, shellModule.Agent))
+		}
+	}
+	var m string
+	ctx, stop := interruptible()
+	r, err := shellModule.RunContext(ctx)
+	stop()
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	if len(r) <= 0 {
+		message("warn", fmt.Sprintf("The %s module did not return a command to task an"+
+			" agent with", shellModule.Name))
+		return
+	}
+	job := shellModule.Job(r)
+	job.After, job.Priority = embargo, priority
+	if shellModule.Fanout() {
+		runModuleAgents(job)
+		return
+	}
+	m, err = addJob(shellModule.Agent, job.Type, job.Args)
+
+	if err != nil {
+		message("warn", "There was an error adding the job to the specified agent")
+		message("warn", err.Error())
+		return
+	}
+	agents.TrackModuleJob(m, shellModule.Name, diff)
+	agents.TrackAttack(m, shellModule.Name, shellModule.Attack)
+	message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+		m, shellModule.Agent, time.Now().UTC().Format(time.RFC3339)))
+}
+
+// runModuleAgents sends the module's job to every agent its Agent option refers to that it runs on and reports which
+// agents were tasked and why the others were not
+func runModuleAgents(job agents.Job) {
+	ids, failed, err := shellModule.RunAgents()
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	jobs, errs := agents.AddGroupJobsEach(ids, []agents.Job{job})
+	for id, e := range errs {
+		failed[id] = e
+	}
+	var created int
+	for _, id := range ids {
+		if _, ok := failed[id]; ok {
+			continue
+		}
+		created++
+		agents.TrackModuleJob(jobs[id][0], shellModule.Name, false)
+		agents.TrackAttack(jobs[id][0], shellModule.Name, shellModule.Attack)
+		message("note", fmt.Sprintf("Created job %s for agent %s at %s", jobs[id][0], id,
+			time.Now().UTC().Format(time.RFC3339)))
+	}
+	var skipped []uuid.UUID
+	for id := range failed {
+		skipped = append(skipped, id)
+	}
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i].String() < skipped[j].String() })
+	for _, id := range skipped {
+		message("warn", fmt.Sprintf("Skipped agent %s: %s", id, failed[id]))
+	}
+	message("info", fmt.Sprintf("Created %s module jobs for %d of %d agents", shellModule.Name, created,
+		created+len(failed)))
+}
+
 func menuSetModule(cmd string) {
 	if len(cmd) > 0 {
 		var mPath = path.Join(core.CurrentDir, "data", "modules", cmd+".json")
@@ -610,37 +1937,231 @@ func menuSetModule(cmd string) {
 		} else {
 			shellModule = s
 			prompt.Config.AutoComplete = getCompleter("module")
-			prompt.SetPrompt("\033[31mMerlin[\033[32mmodule\033[31m][\033[33m" + shellModule.Name + "\033[31m]»\033[0m ")
+			setPrompt("\033[31mMerlin[\033[32mmodule\033[31m][\033[33m" + shellModule.Name + "\033[31m]»\033[0m ")
 			shellMenuContext = "module"
 		}
 	}
 }
 
+// menuAgentUse uses a module with its Agent option set to the agent the agent menu is interacting with, which is refused
+// if the module does not run on the agent's platform and architecture
+func menuAgentUse(cmd []string) {
+	if len(cmd) != 2 || cmd[0] != "module" {
+		message("warn", "Invalid 'use' command")
+		message("info", "use module <module>")
+		return
+	}
+	m, err := modules.Create(path.Join(core.CurrentDir, "data", "modules", cmd[1]+".json"))
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	if _, err = m.SetAgent(shellAgent.String()); err != nil {
+		message("warn", err.Error())
+		return
+	}
+	agentID := shellAgent
+	menuSetModule(cmd[1])
+	if _, err = shellModule.SetAgent(agentID.String()); err != nil {
+		message("warn", err.Error())
+	}
+}
+
 func menuSetMain() {
 	prompt.Config.AutoComplete = getCompleter("main")
-	prompt.SetPrompt("\033[31mMerlin»\033[0m ")
+	setPrompt("\033[31mMerlin»\033[0m ")
 	shellMenuContext = "main"
 }
 
+// setPrompt changes the command line's prompt and keeps it in the prompt's configuration so it can be restored after a
+// question is asked
+func setPrompt(s string) {
+	prompt.Config.Prompt = s
+	prompt.SetPrompt(s)
+}
+
 func getCompleter(completer string) *readline.PrefixCompleter {
 
 	// Main Menu Completer
 	var main = readline.NewPrefixCompleter(
+		readline.PcItem("accept",
+			readline.PcItemDynamic(agents.GetPendingList()),
+		),
 		readline.PcItem("agent",
+			readline.PcItem("diagnose",
+				readline.PcItemDynamic(agents.GetAgentList()),
+			),
+			readline.PcItem("group",
+				readline.PcItem("list"),
+			),
+			readline.PcItem("killall"),
 			readline.PcItem("list"),
 			readline.PcItem("interact",
 				readline.PcItemDynamic(agents.GetAgentList()),
 			),
+			readline.PcItem("tag",
+				readline.PcItemDynamic(agents.GetAgentList()),
+			),
+			readline.PcItem("untag",
+				readline.PcItemDynamic(agents.GetAgentList()),
+			),
+		),
+		readline.PcItem("alias",
+			readline.PcItem("add"),
+			readline.PcItem("list"),
+			readline.PcItem("remove",
+				readline.PcItemDynamic(aliasItems()),
+			),
+		),
+		readline.PcItem("approval",
+			readline.PcItem("off"),
+			readline.PcItem("on"),
+		),
+		readline.PcItem("backup",
+			readline.PcItem("decrypt"),
+			readline.PcItem("now"),
+			readline.PcItem("remote"),
+			readline.PcItem("schedule",
+				readline.PcItem("off"),
+			),
 		),
 		readline.PcItem("banner"),
+		readline.PcItem("barrier",
+			readline.PcItemDynamic(targetItems()),
+			readline.PcItem("all"),
+			readline.PcItem("cancel"),
+			readline.PcItem("list"),
+		),
+		readline.PcItem("broadcast",
+			readline.PcItemDynamic(targetItems()),
+			readline.PcItem("all"),
+			readline.PcItem("list"),
+			readline.PcItem("report",
+				readline.PcItemDynamic(broadcastIDs()),
+			),
+		),
+		readline.PcItem("creds",
+			readline.PcItem("add"),
+			readline.PcItem("export"),
+			readline.PcItem("list",
+				readline.PcItemDynamic(agents.GetAgentList()),
+			),
+			readline.PcItem("search"),
+		),
+		readline.PcItem("hosts",
+			readline.PcItem("export"),
+			readline.PcItem("info",
+				readline.PcItemDynamic(hosts.GetAddressList()),
+			),
+			readline.PcItem("list"),
+			readline.PcItem("subnets"),
+		),
+		readline.PcItem("confirm", confirmItems()...),
+		readline.PcItem("diagnose",
+			readline.PcItemDynamic(agents.GetAgentList()),
+		),
+		readline.PcItem("attack",
+			readline.PcItem("commands"),
+			readline.PcItem("coverage",
+				readline.PcItem("since"),
+				readline.PcItem("until"),
+			),
+			readline.PcItem("export"),
+		),
+		readline.PcItem("auditlog", auditItems()...),
+		readline.PcItem("events", eventsItems()...),
+		readline.PcItem("generate",
+			readline.PcItem("-f", formatItems()...),
+		),
 		readline.PcItem("help"),
+		readline.PcItem("hunt",
+			readline.PcItem("status",
+				readline.PcItemDynamic(targets.GetUserList()),
+			),
+			readline.PcItem("user"),
+		),
 		readline.PcItem("interact",
 			readline.PcItemDynamic(agents.GetAgentList()),
 		),
+		readline.PcItem("jobs", jobsItems(false)...),
+		readline.PcItem("listeners"),
+		readline.PcItem("loot",
+			readline.PcItem("copy-path",
+				readline.PcItemDynamic(lootItems()),
+			),
+			readline.PcItem("gallery",
+				readline.PcItemDynamic(agents.GetAgentList()),
+			),
+			readline.PcItem("list",
+				readline.PcItemDynamic(agents.GetAgentList()),
+			),
+			readline.PcItem("view",
+				readline.PcItemDynamic(lootItems()),
+			),
+		),
+		readline.PcItem("modules",
+			readline.PcItem("install"),
+			readline.PcItem("list"),
+		),
+		readline.PcItem("notifications", notificationsItems()...),
+		readline.PcItem("notify", notifyItems()...),
+		readline.PcItem("operators",
+			readline.PcItem("add"),
+			readline.PcItem("list"),
+			readline.PcItem("remove",
+				readline.PcItemDynamic(func(string) []string { return rpc.Operators() }),
+			),
+			readline.PcItem("reset",
+				readline.PcItemDynamic(func(string) []string { return rpc.Operators() }),
+			),
+			readline.PcItem("sessions"),
+		),
+		readline.PcItem("queue",
+			readline.PcItemDynamic(targetItems()),
+		),
+		readline.PcItem("quickstart"),
+		readline.PcItem("record",
+			readline.PcItem("start"),
+			readline.PcItem("stop"),
+		),
+		readline.PcItem("redact",
+			readline.PcItem("add"),
+			readline.PcItem("list"),
+			readline.PcItem("remove",
+				readline.PcItemDynamic(func(string) []string { return redact.GetPatterns() }),
+			),
+		),
 		readline.PcItem("remove",
 			readline.PcItemDynamic(agents.GetAgentList()),
 		),
-		readline.PcItem("sessions"),
+		readline.PcItem("replay",
+			readline.PcItem("-speed"),
+			readline.PcItem("-idle"),
+		),
+		readline.PcItem("report"),
+		readline.PcItem("resource"),
+		readline.PcItem("rotate",
+			readline.PcItem("now"),
+			readline.PcItem("schedule",
+				readline.PcItem("off"),
+			),
+		),
+		readline.PcItem("search"),
+		readline.PcItem("sessions",
+			readline.PcItem("copy"),
+		),
+		readline.PcItem("targets",
+			readline.PcItem("users",
+				readline.PcItemDynamic(targets.GetUserList()),
+			),
+		),
+		readline.PcItem("throttle",
+			readline.PcItem("off"),
+		),
+		readline.PcItem("tools",
+			readline.PcItem("add"),
+			readline.PcItem("list"),
+		),
 		readline.PcItem("use",
 			readline.PcItem("module",
 				readline.PcItemDynamic(modules.GetModuleList()),
@@ -656,7 +2177,11 @@ func getCompleter(completer string) *readline.PrefixCompleter {
 		readline.PcItem("info"),
 		readline.PcItem("main"),
 		readline.PcItem("reload"),
+		readline.PcItem("rerun",
+			readline.PcItem("--diff"),
+		),
 		readline.PcItem("run"),
+		readline.PcItem("search"),
 		readline.PcItem("show",
 			readline.PcItem("options"),
 			readline.PcItem("info"),
@@ -664,9 +2189,11 @@ func getCompleter(completer string) *readline.PrefixCompleter {
 		readline.PcItem("set",
 			readline.PcItem("Agent",
 				readline.PcItem("all"),
-				readline.PcItemDynamic(agents.GetAgentList()),
+				readline.PcItemDynamic(moduleAgents()),
+			),
+			readline.PcItemDynamic(shellModule.GetOptionsList(),
+				readline.PcItemDynamic(shellModule.GetOptionValues()),
 			),
-			readline.PcItemDynamic(shellModule.GetOptionsList()),
 		),
 	)
 
@@ -674,19 +2201,118 @@ func getCompleter(completer string) *readline.PrefixCompleter {
 	var agent = readline.NewPrefixCompleter(
 		readline.PcItem("cmd"),
 		readline.PcItem("back"),
+		readline.PcItem("bof"),
+		readline.PcItem("cleanup"),
+		readline.PcItem("crashes"),
+		readline.PcItem("diagnose"),
 		readline.PcItem("download"),
+		readline.PcItem("exec",
+			readline.PcItem("-at"),
+			readline.PcItem("-every"),
+		),
+		readline.PcItem("execute-assembly",
+			readline.PcItem("-appdomain"),
+			readline.PcItem("-amsi"),
+			readline.PcItem("-etw"),
+		),
 		readline.PcItem("execute-shellcode",
 			readline.PcItem("self"),
 			readline.PcItem("remote"),
 			readline.PcItem("RtlCreateUserThread"),
 		),
+		readline.PcItem("fetch-tool",
+			readline.PcItemDynamic(tools.GetToolNames()),
+		),
+		readline.PcItem("find",
+			readline.PcItem("-name"),
+			readline.PcItem("-iname"),
+			readline.PcItem("-mtime"),
+			readline.PcItem("-limit"),
+		),
+		readline.PcItem("firewall",
+			readline.PcItem("add"),
+			readline.PcItem("delete",
+				readline.PcItemDynamic(firewallNames()),
+			),
+			readline.PcItem("list"),
+			readline.PcItem("rules"),
+		),
+		readline.PcItem("grep",
+			readline.PcItem("-i"),
+			readline.PcItem("-name"),
+			readline.PcItem("-limit"),
+		),
 		readline.PcItem("help"),
-		readline.PcItem("info"),
+		readline.PcItem("info",
+			readline.PcItem("--health"),
+		),
+		readline.PcItem("jitter"),
+		readline.PcItem("jobs", jobsItems(true)...),
+		readline.PcItem("keylog",
+			readline.PcItem("dump"),
+			readline.PcItem("replay",
+				readline.PcItem("raw"),
+			),
+			readline.PcItem("start"),
+			readline.PcItem("stop"),
+		),
 		readline.PcItem("kill"),
+		readline.PcItem("loggedon"),
 		readline.PcItem("ls"),
+		readline.PcItem("callbacks",
+			readline.PcItem("add"),
+			readline.PcItem("remove"),
+			readline.PcItem("rotate"),
+		),
+		readline.PcItem("pin",
+			readline.PcItem("rotate"),
+			readline.PcItem("clear"),
+		),
 		readline.PcItem("cd"),
 		readline.PcItem("pwd"),
+		readline.PcItem("results"),
 		readline.PcItem("main"),
+		readline.PcItem("move",
+			readline.PcItemDynamic(moveMethods),
+		),
+		readline.PcItem("node"),
+		readline.PcItem("osascript"),
+		readline.PcItem("portfwd",
+			readline.PcItem("add",
+				readline.PcItem("local"),
+				readline.PcItem("reverse"),
+			),
+			readline.PcItem("list"),
+			readline.PcItem("remove"),
+		),
+		readline.PcItem("persist",
+			readline.PcItem("add",
+				readline.PcItemDynamic(persistMethods),
+			),
+			readline.PcItem("list"),
+			readline.PcItem("remove",
+				readline.PcItemDynamic(persistNames()),
+			),
+		),
+		readline.PcItem("powerpick",
+			readline.PcItem("-ppid"),
+			readline.PcItem("-c"),
+		),
+		readline.PcItem("powershell",
+			readline.PcItem("-amsi"),
+			readline.PcItem("-etw"),
+			readline.PcItem("-c"),
+		),
+		readline.PcItem("python"),
+		readline.PcItem("schedule",
+			readline.PcItem("list"),
+			readline.PcItem("remove",
+				readline.PcItemDynamic(scheduleIDs()),
+			),
+		),
+		readline.PcItem("screenshot"),
+		readline.PcItem("selfdestruct"),
+		readline.PcItem("sessions-enum"),
 		readline.PcItem("shell"),
 		readline.PcItem("set",
 			readline.PcItem("killdate"),
@@ -695,13 +2321,52 @@ func getCompleter(completer string) *readline.PrefixCompleter {
 			readline.PcItem("skew"),
 			readline.PcItem("sleep"),
 		),
-		readline.PcItem("status"),
+		readline.PcItem("socks",
+			readline.PcItem("start"),
+			readline.PcItem("status"),
+			readline.PcItem("stop"),
+		),
+		readline.PcItem("status",
+			readline.PcItem("thresholds",
+				readline.PcItem("default"),
+			),
+		),
+		readline.PcItem("unzip",
+			readline.PcItem("-p"),
+		),
 		readline.PcItem("upload"),
+		readline.PcItem("use",
+			readline.PcItem("module",
+				readline.PcItemDynamic(modules.GetCompatibleModuleList(func() uuid.UUID { return shellAgent })),
+			),
+		),
+		readline.PcItem("watch",
+			readline.PcItem("off"),
+		),
+		readline.PcItem("wasm",
+			readline.PcItem("-allow"),
+			readline.PcItem("-timeout"),
+		),
+		readline.PcItem("workinghours",
+			readline.PcItem("0900-1700", readline.PcItem("Mon-Fri")),
+			readline.PcItem("off"),
+		),
+		readline.PcItem("zip",
+			readline.PcItem("-p"),
+		),
 	)
 
+	listeners, listener := listenerCompleters()
+
 	switch completer {
 	case "main":
 		return main
+	case "listeners":
+		return listeners
+	case "listener":
+		return listener
+	case "generate":
+		return generateCompleter()
 	case "module":
 		return module
 	case "agent":
@@ -720,13 +2385,47 @@ func menuHelpMain() {
 	table.SetHeader([]string{"Command", "Description", "Options"})
 
 	data := [][]string{
-		{"agent", "Interact with agents or list agents", "interact, list"},
+		{"accept", "Approve an agent that registered while approval was required so it can be tasked", "<agent>"},
+		{"agent", "Interact with agents, list agents, tag agents to put them in groups that commands can target with tag:<tag>, or task every agent to remove its persistence and files and exit with killall", "diagnose, interact, list " + sessionsUsage + ", tag <agent> <tag>, untag <agent> <tag>, group list, killall [-y]"},
+		{"alias", "List, add, or remove command shortcuts kept in ~/.merlin_aliases and expanded in every menu; $1 to $9 and $* in the command are replaced with the arguments typed after the alias, which are otherwise appended", aliasUsage},
+		{"approval", "Require new agents to be accepted before they can be tasked and list the agents waiting", "[on|off]"},
+		{"attack", "Show the MITRE ATT&CK techniques jobs used against each host, export them as an ATT&CK Navigator layer, or list the techniques of the agent commands", attackUsage},
+		{"auditlog", "List the operator commands recorded in the tamper-evident audit log, or verify its hash chain", "verify, or [client <name>] [agent <id>] [since <time>] [until <time>] [limit <n>]"},
+		{"backup", "Archive the database, agent loot and logs, and server logs to an encrypted tarball", "now, schedule <interval|off>, remote [<directory|URL>], decrypt <backup file>"},
 		{"banner", "Print the Merlin banner", ""},
-		{"exit", "Exit and close the Merlin server", ""},
+		{"barrier", "Hold a command for several agents until every agent checks in, then run it on all of them at the same time", "<agent>|tag:<tag>[,...]|all <command> [<args>], list, cancel <barrier ID>"},
+		{"broadcast", "Run a command on every agent that matches the filter and show all of their results in one table once they have returned; field expressions are the sessions fields (i.e. broadcast platform=windows whoami /groups)", broadcastUsage},
+		{"confirm", "List or set which commands ask 'Are you sure' before they run and the words that answer yes, saved in your operator profile", "[<exit|kill|remove> <always|never|default>] OR yes <word>... OR yes default"},
+		{"creds", "Manage the credentials parsed from agent output, such as mimikatz results, hash dumps, and shadow files", "add <[domain\\]user> <password|hash> [<host>], list [<agent>], search <term>, export <file.json|file.csv>"},
+		{"diagnose", "Summarize an agent's transport health and recommend sleep, skew, and retry settings", "<agent>"},
+		{"events", "List agent check ins, new agents, completed jobs, and listener changes from the event log", "[agent <id>] [type <agent|checkin|job|listener|dead|status>] [since <time>] [until <time>] [limit <n>]"},
+		{"exit", "Exit and close the Merlin server; -y skips the confirmation", "[-y]"},
+		{"generate", "Build an agent pre-configured to connect to the main listener as an executable, DLL, or shellcode", "[-f exe|dll|shellcode]"},
+		{"hosts", "List the hosts and subnets discovered from agent check ins and ipconfig, arp, and nmap output, show which agents can reach a host, or export the inventory as a table or Graphviz graph", hostsUsage},
+		{"hunt", "Task active Windows agents to find the hosts a user is logged on to", "user <user name> [<agent>|tag:<tag>[,...]], status <user name>"},
 		{"interact", "Interact with an agent. Alias for Empire users", ""},
-		{"quit", "Exit and close the Merlin server", ""},
-		{"remove", "Remove or delete a DEAD agent from the server"},
-		{"sessions", "List all agents session information. Alias for MSF users", ""},
+		{"jobs", "List the jobs waiting for agents to check in with their priority and a risk score from their technique, target process, and size, cancel a queued job, or change its priority", jobsUsage},
+		{"listeners", "Configure and start additional listeners, such as a DNS or TCP listener", ""},
+		{"loot", "List the files downloaded from agents, view one as text or a paged hex dump, or write HTML galleries of agent screenshots", "list [<agent>], view <id> [<page>], copy-path <id>, gallery [<agent>]"},
+		{"modules", "List or install signed modules from the module index, or install module packs from a git repository or path", "list, install <module|git URL|path>"},
+		{"notifications", "Manage the Slack, Discord, or generic webhooks sent a notification when an agent registers, an agent dies, or job output contains a keyword", notificationsUsage},
+		{"notify", "List or change the events, such as a completed job, a new agent, or an agent that died, shown as a highlighted notification in every menu", notifyUsage},
+		{"operators", "List the operators connected to the team server or manage their accounts from the server's console", "sessions, list, add <name>, reset <name>, remove <name>"},
+		{"queue", "Run an agent menu command for each agent, or every agent in a group, as if interacting with each one", queueUsage},
+		{"quickstart", "Start an HTTPS listener on a random high port with a self-signed certificate and generated PSK, and print a matching agent build command", "[<interface>]"},
+		{"quit", "Exit and close the Merlin server; -y skips the confirmation", "[-y]"},
+		{"record", "Record the terminal session, its output and command lines with their timing and secrets masked, to an asciinema file in data/recordings or the file provided; without arguments shows the recording status", "[start [<file>]|stop]"},
+		{"redact", "List, add, or remove the regular expressions of secrets masked in the console, command history, and logs", "list, add <regex>, remove <regex>"},
+		{"remove", "Remove or delete a DEAD agent from the server; -y skips the confirmation", "<agent> [-y]"},
+		{"replay", "Play back a session recording with its original timing; pauses are shortened to the idle time, 2s by default, and Ctrl-C stops it", "<file> [-speed <n>] [-idle <duration>]"},
+		{"report", "Write an engagement report with a timeline of the agents, operator commands, file transfers, and captured credentials, without their secrets, as Markdown, HTML, or JSON by the file's extension", "<file> [since <time>] [until <time>]"},
+		{"resource", "Execute the commands in a file; # starts a comment and NAME=value sets a variable used as ${NAME}", "<file> [NAME=value ...]"},
+		{"rotate", "Rotate the HTTP listeners' certificate and PSK with the agents' callback URIs, sleep, skew, and JA3 together; every change is reversed if an agent does not confirm it before the timeout, 5m by default", "[now [<timeout>] | schedule <interval|off> [<timeout>]]"},
+		{"search", "Search the modules' names, descriptions, authors, and MITRE ATT&CK IDs; every keyword must match", "<keyword> [<keyword> ...]"},
+		{"sessions", "List all agents session information, filtered, sorted, and with the columns selected by field expressions, or copy the numbered agent's ID to the clipboard. Alias for MSF users", sessionsUsage + ", copy <n>"},
+		{"targets", "Show where users have been observed by sessions-enum and loggedon", "users [<user name>]"},
+		{"throttle", "Limit how many agents run a job created for more than one agent at once and the time between agents", "[<max agents> <stagger>|off] (i.e. throttle 10 30s)"},
+		{"tools", "List or add files in the tool repository agents fetch with fetch-tool", "list, add <file> <name> <platform> [<description>]"},
 		{"use", "Use a function of Merlin", "module"},
 		{"version", "Print the Merlin server version", ""},
 		{"*", "Anything else will be execute on the host operating system", ""},
@@ -752,8 +2451,10 @@ func menuHelpModule() {
 		{"info", "Show information about a module"},
 		{"main", "Return to the main menu", ""},
 		{"reload", "Reloads the module to a fresh clean state"},
+		{"rerun", "Run the module again; with --diff, show how its results differ from the previous run on the agent", "[--diff]"},
 		{"run", "Run or execute the module", ""},
-		{"set", "Set the value for one of the module's options", "<option name> <option value>"},
+		{"search", "Search the modules' names, descriptions, authors, and MITRE ATT&CK IDs; every keyword must match", "<keyword> [<keyword> ...]"},
+		{"set", "Set the value for one of the module's options; the Agent option can be all or a comma separated list of agents and tag:<tag>", "<option name> <option value>"},
 		{"show", "Show information about a module or its options", "info, options"},
 	}
 
@@ -773,26 +2474,64 @@ func menuHelpAgent() {
 	table.SetHeader([]string{"Command", "Description", "Options"})
 
 	data := [][]string{
+		{"callbacks", "List the URLs the agent rotates its check ins across, or change them on the live agent", "add <url>, remove <url>, rotate <duration>"},
 		{"cd", "Change directories", "cd ../../ OR cd c:\\\\Users"},
 		{"cmd", "Execute a command on the agent (DEPRECIATED)", "cmd ping -c 3 8.8.8.8"},
 		{"back", "Return to the main menu", ""},
+		{"bof", "Run a local Beacon Object File (COFF) in the agent's process with typed arguments and return the output it sends with the Beacon API; a BOF that crashes ends the agent (Windows only)", bofUsage},
+		{"cleanup", "List the files and directories jobs created on the agent's host", ""},
+		{"crashes", "List the panics the agent recovered from and reported, or show one with its sanitized stack trace", "crashes [<number>]"},
+		{"diagnose", "Summarize the agent's transport health and recommend sleep, skew, and retry settings", ""},
 		{"download", "Download a file from the agent", "download <remote_file>"},
+		{"exec", "Execute a command on the agent now, or schedule the server to queue it at a time and again every interval; a recurring job is skipped while its last job is waiting for the agent", execUsage},
+		{"execute-assembly", "Run a local .NET assembly in memory on the agent and return its console output; -amsi and -etw patch AMSI and ETW first, and an assembly that calls Environment.Exit ends the agent (Windows only)", executeAssemblyUsage},
 		{"execute-shellcode", "Execute shellcode", "self, remote <pid>, RtlCreateUserThread <pid>"},
-		{"info", "Display all information about the agent", ""},
-		{"kill", "Instruct the agent to die or quit", ""},
+		{"fetch-tool", "Send a tool from the server's tool repository to the agent, which verifies its SHA-256 hash", "fetch-tool <tool> [<remote_file>]"},
+		{"find", "Find files by name or modification time on the agent without a shell", "find <path> [-name|-iname <glob>] [-mtime N] [-limit N]"},
+		{"firewall", "List the Windows Firewall, iptables, or nftables rules on the agent's host, or add or delete an allow rule such as a pivot port; the server records added rules so they can be listed and deleted", firewallUsage},
+		{"grep", "Search the contents of files on the agent for a regular expression without a shell", "grep [-i] [-name <glob>] [-limit N] <pattern> <path>"},
+		{"info", "Display all information about the agent, or with --health the resource usage and error counters it reports", "[--health]"},
+		{"jitter", "Randomly shorten or lengthen each of the agent's sleeps by up to the percent so its check ins are not evenly spaced", "jitter <percent>"},
+		{"jobs", "List the jobs waiting for the agent to check in with their priority and a risk score from their technique, target process, and size, cancel a queued job, or change its priority", jobsUsage},
+		{"keylog", "Capture keystrokes on the agent, dumped every interval (default 5m), or replay the keystrokes stored for it (Windows only)", "start [<dump interval>], stop, dump, replay [raw] [<since>]"},
+		{"kill", "Instruct the agent to die or quit; -y skips the confirmation", "[-y]"},
+		{"loggedon", "List users logged on to the agent's host or a remote host (Windows only)", "loggedon [<host> [<user> <password>]]"},
 		{"ls", "List directory contents", "ls /etc OR ls C:\\\\Users"},
 		{"main", "Return to the main menu", ""},
+		{"move", "Copy a payload to a remote host and start it, or run a command on it, with psexec, wmiexec, winrm (Windows only), or ssh and a password from the credential store; the new agent's ID is reported when it checks in", moveUsage},
+		{"node", "Pipe a JavaScript file or inline code to Node.js on the agent", "node <local_script_file> OR node -c \"<code>\""},
+		{"osascript", "Pipe an AppleScript file or inline code to osascript on the agent (macOS)", "osascript <local_script_file> OR osascript -c \"<code>\""},
+		{"portfwd", "Forward a server port through the agent to a host, or an agent port back to a host the server reaches", "add <local|reverse> [<interface>:]<port> <host>:<port>, list, remove <id>"},
 		{"pwd", "Display the current working directory", "pwd"},
+		{"pin", "List the certificate public key pins the agent accepts from its listeners, or push a new set to the live agent ahead of a certificate change", pinUsage},
+		{"persist", "Add persistence that runs the agent's command line, or a command, with a scheduled task or run key (Windows), systemd unit (Linux), launchd property list (macOS), or cron; the server records what is installed so it can be listed and removed", persistUsage},
+		{"powerpick", "Run a PowerShell file or command in powershell.exe started under a spoofed parent process, explorer.exe by default (Windows only)", powerShellUsage["powerpick"]},
+		{"powershell", "Run a PowerShell file or command in a runspace hosted by the CLR in the agent's process, without powershell.exe; -amsi and -etw patch AMSI and ETW first (Windows only)", powerShellUsage["powershell"]},
+		{"python", "Pipe a Python file or inline code to Python on the agent", "python <local_script_file> OR python -c \"<code>\""},
+		{"results", "Display a job's full results with folded sections expanded (not available with memory storage)", "results <job ID>"},
+		{"schedule", "List the jobs scheduled for the agent with exec, or remove one; jobs already queued are not removed", scheduleUsage},
+		{"screenshot", "Capture the agent's desktop as a PNG image saved with its loot (Windows only)", "screenshot"},
+		{"selfdestruct", "Instruct the agent to remove its recorded persistence, overwrite and delete the files in its cleanup manifest and its own executable, report what it removed, and exit; -y skips the confirmation", "[-y]"},
+		{"sessions-enum", "List RDP and console sessions on the agent's host or a remote host (Windows only)", "sessions-enum [<host> [<user> <password>]]"},
 		{"set", "Set the value for one of the agent's options", "killdate, maxretry, padding, skew, sleep"},
-		{"shell", "Execute a command on the agent", "shell ping -c 3 8.8.8.8"},
-		{"status", "Print the current status of the agent", ""},
+		{"shell", "Execute a command on the agent, or open an interactive shell without a command; ctrl-c returns to the agent menu", "shell, shell ping -c 3 8.8.8.8"},
+		{"socks", "Run a SOCKS5 proxy on the server that tunnels connections through the agent", "start [[<interface>:]<port>], stop, status"},
+		{"status", "Print the current status of the agent, or change how many check in intervals, with its jitter and working hours, it can miss before it is delayed and dead", statusUsage},
+		{"unzip", "Extract a .zip, .tar.gz, or .tgz archive on the agent", "unzip [-p <password>] <archive> [<directory>]"},
 		{"upload", "Upload a file to the agent", "upload <local_file> <remote_file>"},
+		{"use", "Use a module with its Agent option set to this agent; only modules that run on the agent's platform and architecture are completed", "module <module>"},
+		{"watch", "Take a screenshot now and again every interval until stopped; a capture is skipped while the last one is pending", "watch [<interval>|off]"},
+		{"wasm", "Execute a WebAssembly task module on the agent; its file, process, and network host functions are denied unless granted with -allow", "wasm [-allow file,process,net] [-timeout 5m] <local_wasm_file> [args...]"},
+		{"workinghours", "Only check in during the working hours in the agent host's time zone; outside of them the agent sleeps until they open and is not counted as delayed or dead", "workinghours <HHMM-HHMM> [Mon-Fri], workinghours off"},
+		{"zip", "Create a .zip, .tar.gz, or .tgz archive on the agent; -p encrypts a zip archive", "zip [-p <password>] <archive> <path> [<path>...]"},
 	}
 
 	table.AppendBulk(data)
 	fmt.Println()
 	table.Render()
 	fmt.Println()
+	message("info", "End a command with --after <02:00|90m|RFC 3339 time> to embargo its job until then")
+	message("info", "End a command with --priority <low|normal|high|urgent> to send its job before or after the agent's other queued jobs")
 	message("info", "Visit the wiki for additional information "+
 		"https://github.com/Ne0nd0g/merlin/wiki/Merlin-Server-Agent-Menu")
 }
@@ -824,43 +2563,39 @@ func message(level string, message string) {
 	}
 }
 
-// confirm reads in string and returns true if the string is y or yes but does not provide the prompt question
-func confirm(response string) bool {
-
-	response = strings.ToLower(response)
-	response = strings.Trim(response, "\r\n")
-	yes := []string{"y", "yes"}
-
-	for _, match := range yes {
-		if response == match {
+// inSlice returns true if the provided string is an element of the provided slice
+func inSlice(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
 			return true
 		}
 	}
-
 	return false
 }
 
-// exit will prompt the user to confirm if they want to exit
-func exit() {
-
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print("Are you sure you want to exit? [yes/NO]: ")
-	response, err := reader.ReadString('\n')
-	if err != nil {
-		message("warn", fmt.Sprintf("There was an error reading the input:\r\n%s", err.Error()))
-	}
-	response = strings.ToLower(response)
-	response = strings.Trim(response, "\r\n")
-
-	if confirm(response) {
+// exit will prompt the user to confirm if they want to exit, unless -y is provided or the operator's confirmation
+// policy does not ask
+func exit(args []string) {
+	if ok, _ := confirmCommand("exit", "Are you sure you want to exit?", args); ok {
 		color.Red("[!]Quitting")
+		if session.recorder != nil {
+			if path, err := stopRecording(); err == nil {
+				message("info", fmt.Sprintf("Saved the session recording to %s", path))
+			}
+		}
 		logging.Server("Shutting down Merlin Server due to user input")
 		os.Exit(0)
 	}
 }
 
 func executeCommand(name string, arg []string) {
-	cmd := exec.Command(name, arg...) // #nosec G204 Users can execute any arbitrary command by design
+	if shellOperator != "" {
+		message("warn", "System commands can only be executed from the server's console")
+		return
+	}
+	ctx, stop := interruptible()
+	defer stop()
+	cmd := exec.CommandContext(ctx, name, arg...) // #nosec G204 Users can execute any arbitrary command by design
 
 	out, err := cmd.CombinedOutput()
 
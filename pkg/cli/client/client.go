@@ -0,0 +1,47 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package client is the operator's side of a remote console to a Merlin team server. The server runs the command line
+// interface; the client only logs in and connects the operator's terminal to it.
+package client
+
+import (
+	// Standard
+	"fmt"
+
+	// 3rd Party
+	"github.com/chzyer/readline"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api/rpc"
+)
+
+// Console logs in to the team server at the address, after verifying its certificate has the SHA256 fingerprint, and
+// connects the terminal to the operator's console until they exit or the connection is closed
+func Console(addr string, fingerprint string, operator string, password string) error {
+	conn, _, err := rpc.Dial(addr, fingerprint, rpc.Hello{Operator: operator, Password: password, Console: true})
+	if err != nil {
+		return err
+	}
+	defer conn.Close() // #nosec G307
+
+	remote, err := readline.NewRemoteCli(conn)
+	if err != nil {
+		return fmt.Errorf("there was an error starting the remote console:\r\n%s", err.Error())
+	}
+	return remote.Serve()
+}
@@ -0,0 +1,47 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"errors"
+	"fmt"
+	"os"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/clipboard"
+)
+
+// copyText places the text on the operator's clipboard and reports what was copied. A remote operator's clipboard is on
+// their own host, so the text is sent to their terminal instead of the server's clipboard program.
+func copyText(text string, what string) {
+	copy := clipboard.Copy
+	if shellOperator != "" {
+		copy = func(text string) error {
+			if clipboard.Disabled {
+				return errors.New("the clipboard is disabled; restart the server without -no-clipboard to use it")
+			}
+			return clipboard.CopyTerminal(os.Stdout, text)
+		}
+	}
+	if err := copy(text); err != nil {
+		message("warn", err.Error())
+		return
+	}
+	message("success", fmt.Sprintf("Copied %s to the clipboard", what))
+}
@@ -0,0 +1,176 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	// 3rd Party
+	"github.com/chzyer/readline"
+	"github.com/olekukonko/tablewriter"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/operator"
+)
+
+// operatorProfile holds the console preferences of the operator using the CLI
+var operatorProfile = &operator.Profile{Name: "operator"}
+
+// SetOperator loads the profile with the console preferences, such as the confirmation policy, of the operator using
+// the CLI
+func SetOperator(name string) error {
+	p, err := operator.Load(name)
+	if err != nil {
+		return err
+	}
+	operatorProfile = p
+	return nil
+}
+
+// confirmCommand returns true if the command can run: it was run with -y, the operator's policy does not ask for
+// confirmation, or the operator answered yes to the question. Team server consoles are not asked and must use -y. The
+// arguments are returned without -y.
+func confirmCommand(command string, question string, args []string) (bool, []string) {
+	var rest []string
+	yes := false
+	for _, arg := range args {
+		if arg == "-y" {
+			yes = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	if yes || !operatorProfile.ConfirmRequired(command) {
+		return true, rest
+	}
+	// A remote console's command line runs while every other console waits, so it can not stop to read an answer
+	if shellOperator != "" {
+		message("warn", fmt.Sprintf("%s Team server consoles can not answer a confirmation; run the command again "+
+			"with -y", question))
+		return false, rest
+	}
+	response, err := ask(fmt.Sprintf("%s [%s/NO]: ", question, strings.Join(operatorProfile.Words(), "/")))
+	if err != nil && err != readline.ErrInterrupt && err != io.EOF {
+		message("warn", fmt.Sprintf("There was an error reading the input:\r\n%s", err.Error()))
+	}
+	return confirm(response), rest
+}
+
+// confirm returns true if the response is one of the operator's words that confirm a command
+func confirm(response string) bool {
+	return operatorProfile.Yes(response)
+}
+
+// ask shows the question as the command line prompt and returns the operator's answer. Tab completion is off while
+// the question is asked and the menu's prompt and completion are restored afterwards. Ctrl-C or Ctrl-D returns an
+// empty answer with the error.
+func ask(question string) (string, error) {
+	if prompt == nil {
+		fmt.Print(question)
+		return bufio.NewReader(os.Stdin).ReadString('\n')
+	}
+	previous, completer := prompt.Config.Prompt, prompt.Config.AutoComplete
+	prompt.Config.AutoComplete = nil
+	prompt.SetPrompt(question)
+	defer func() {
+		prompt.SetPrompt(previous)
+		prompt.Config.AutoComplete = completer
+	}()
+	answer, err := prompt.Readline()
+	if err != nil {
+		return "", err
+	}
+	return answer, nil
+}
+
+// confirmUsage is the warning shown for an invalid confirm command
+var confirmUsage = fmt.Sprintf("Invalid 'confirm' command; use confirm [<%s> <always|never|default>] OR "+
+	"confirm yes <word>... OR confirm yes default", strings.Join(operator.Commands(), "|"))
+
+// menuConfirm lists the commands that can ask for confirmation and the operator's policy for each, sets the policy for
+// one of them, or sets the words that answer yes, in the operator's profile
+func menuConfirm(cmd []string) {
+	switch len(cmd) {
+	case 0:
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Command", "Policy", "Default"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.SetCaption(true, fmt.Sprintf("Confirmation policy for operator %s; -y skips the confirmation and %s confirms it",
+			operatorProfile.Name, strings.Join(operatorProfile.Words(), ", ")))
+		for _, c := range operator.Commands() {
+			table.Append([]string{c, operatorProfile.Policy(c), operator.Confirmable[c]})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case 1:
+		message("warn", confirmUsage)
+	default:
+		if cmd[0] == "yes" {
+			words := cmd[1:]
+			if len(words) == 1 && strings.EqualFold(words[0], operator.Default) {
+				words = nil
+			}
+			if err := operatorProfile.SetWords(words); err != nil {
+				message("warn", err.Error())
+				return
+			}
+			if err := operatorProfile.Save(); err != nil {
+				message("warn", err.Error())
+				return
+			}
+			message("success", fmt.Sprintf("Confirmations accept %s", strings.Join(operatorProfile.Words(), ", ")))
+			return
+		}
+		if len(cmd) != 2 {
+			message("warn", confirmUsage)
+			return
+		}
+		if err := operatorProfile.SetPolicy(cmd[0], strings.ToLower(cmd[1])); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		if err := operatorProfile.Save(); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		if operatorProfile.ConfirmRequired(cmd[0]) {
+			message("success", fmt.Sprintf("The %s command will ask for confirmation", cmd[0]))
+		} else {
+			message("success", fmt.Sprintf("The %s command will run without asking for confirmation", cmd[0]))
+		}
+	}
+}
+
+// confirmItems returns the tab completion items for the confirm command's commands and policies
+func confirmItems() []readline.PrefixCompleterInterface {
+	var items []readline.PrefixCompleterInterface
+	for _, c := range operator.Commands() {
+		items = append(items, readline.PcItem(c,
+			readline.PcItem(operator.Always),
+			readline.PcItem(operator.Never),
+			readline.PcItem(operator.Default),
+		))
+	}
+	return append(items, readline.PcItem("yes", readline.PcItem(operator.Default)))
+}
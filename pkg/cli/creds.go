@@ -0,0 +1,122 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/creds"
+)
+
+// menuCreds adds, lists, searches, and exports the credentials in the credential store
+func menuCreds(cmd []string) {
+	if len(cmd) == 0 {
+		message("warn", "Invalid 'creds' command; use add <[domain\\]user> <password|hash> [<host>], list [<agent>], search <term>, or export <file>")
+		return
+	}
+	switch cmd[0] {
+	case "add":
+		if len(cmd) < 3 {
+			message("warn", "Invalid 'creds add' command; use creds add <[domain\\]user> <password|hash> [<host>]")
+			return
+		}
+		c := creds.Credential{User: cmd[1], Secret: cmd[2], Type: creds.Type(cmd[2])}
+		if i := strings.Index(c.User, "\\"); i > 0 {
+			c.Domain, c.User = c.User[:i], c.User[i+1:]
+		}
+		if len(cmd) > 3 {
+			c.Host = cmd[3]
+		}
+		added, err := creds.Add("", "", []creds.Credential{c})
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		if len(added) == 0 {
+			message("note", "The credential is already in the credential store")
+			return
+		}
+		message("success", fmt.Sprintf("Added %s credential %d for %s", added[0].Type, added[0].ID, cmd[1]))
+	case "list":
+		var agent string
+		if len(cmd) > 1 {
+			if _, err := uuid.FromString(cmd[1]); err != nil {
+				message("warn", fmt.Sprintf("%s is not a valid agent ID", cmd[1]))
+				return
+			}
+			agent = cmd[1]
+		}
+		list, err := creds.List(agent)
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		credsTable(list)
+	case "search":
+		if len(cmd) < 2 {
+			message("warn", "Invalid 'creds search' command; use creds search <term>")
+			return
+		}
+		list, err := creds.Search(strings.Join(cmd[1:], " "))
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		credsTable(list)
+	case "export":
+		if len(cmd) < 2 {
+			message("warn", "Invalid 'creds export' command; use creds export <file.json|file.csv>")
+			return
+		}
+		if err := creds.Export(cmd[1]); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Exported the credential store to %s", cmd[1]))
+	default:
+		message("warn", fmt.Sprintf("Invalid 'creds' command: %s", cmd[0]))
+	}
+}
+
+// credsTable prints the credentials as a table
+func credsTable(list []creds.Credential) {
+	if len(list) == 0 {
+		message("info", "There are no credentials")
+		return
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"ID", "Type", "Domain", "User", "Secret", "Host", "Source", "Agent", "Time"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, c := range list {
+		table.Append([]string{strconv.Itoa(c.ID), c.Type, c.Domain, c.User, c.Secret, c.Host, c.Source, c.Agent,
+			c.Time.Format(time.RFC3339)})
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
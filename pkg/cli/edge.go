@@ -0,0 +1,110 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/servers/edge"
+)
+
+// menuEdge handles the listeners menu edge command to enroll, list, and revoke the edge nodes that relay agents to an
+// edge listener
+func menuEdge(cmd []string) {
+	if len(cmd) < 2 {
+		message("warn", "Invalid 'edge' command; use edge list, edge enroll <name>, or edge revoke <name>")
+		return
+	}
+	switch cmd[1] {
+	case "list":
+		nodes, err := edge.Nodes()
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Name", "Status", "Address", "Last Seen", "Requests", "Enrolled", "Fingerprint"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, n := range nodes {
+			status := "enrolled"
+			if n.Revoked {
+				status = "revoked"
+			} else if n.Online() {
+				status = "online"
+			}
+			var lastSeen string
+			if !n.LastSeen.IsZero() {
+				lastSeen = n.LastSeen.Format(time.RFC3339)
+			}
+			table.Append([]string{n.Name, status, n.Address, lastSeen, strconv.Itoa(n.Requests),
+				n.Enrolled.Format(time.RFC3339), n.Fingerprint[:16]})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case "enroll":
+		if len(cmd) < 3 {
+			message("warn", "Invalid 'edge enroll' command; use edge enroll <name>")
+			return
+		}
+		dir, err := edge.Enroll(cmd[2])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Enrolled edge node %s; its credentials are in %s", cmd[2], dir))
+		message("info", "Copy the directory to the node and run merlinedge -central https://<edge listener address> -creds <directory>")
+	case "revoke":
+		if len(cmd) < 3 {
+			message("warn", "Invalid 'edge revoke' command; use edge revoke <name>")
+			return
+		}
+		if err := edge.Revoke(cmd[2]); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Revoked edge node %s", cmd[2]))
+	default:
+		message("warn", fmt.Sprintf("Invalid 'edge' command: %s; use list, enroll, or revoke", cmd[1]))
+	}
+}
+
+// edgeNodeNames returns a function that lists the enrolled edge nodes that are not revoked for tab completion
+func edgeNodeNames() func(string) []string {
+	return func(line string) []string {
+		nodes, err := edge.Nodes()
+		if err != nil {
+			return nil
+		}
+		var names []string
+		for _, n := range nodes {
+			if !n.Revoked {
+				names = append(names, n.Name)
+			}
+		}
+		return names
+	}
+}
@@ -0,0 +1,141 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/chzyer/readline"
+	"github.com/olekukonko/tablewriter"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/events"
+)
+
+// eventsLimit is the number of most recent events shown when the events command is not given a limit
+const eventsLimit = 50
+
+// menuEvents lists the recorded server events filtered by agent, type, and time range
+func menuEvents(cmd []string) {
+	var f events.Filter
+	limit := eventsLimit
+	now := time.Now().UTC()
+	for i := 0; i < len(cmd); i += 2 {
+		if i+1 >= len(cmd) {
+			message("warn", fmt.Sprintf("The %s filter is missing its value", cmd[i]))
+			return
+		}
+		v := cmd[i+1]
+		var err error
+		switch strings.ToLower(cmd[i]) {
+		case "agent":
+			if _, err = uuid.FromString(v); err != nil {
+				message("warn", fmt.Sprintf("%s is not a valid agent ID", v))
+				return
+			}
+			f.Agent = v
+		case "type":
+			if !inSlice(v, events.Types()) {
+				message("warn", fmt.Sprintf("%s is not a valid event type; use %s", v, strings.Join(events.Types(), ", ")))
+				return
+			}
+			f.Type = v
+		case "since":
+			if f.Since, err = events.ParseTime(v, now); err != nil {
+				message("warn", err.Error())
+				return
+			}
+		case "until":
+			if f.Until, err = events.ParseTime(v, now); err != nil {
+				message("warn", err.Error())
+				return
+			}
+		case "limit":
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+				message("warn", fmt.Sprintf("%s is not a valid limit", v))
+				return
+			}
+		default:
+			message("warn", "Invalid 'events' command; use [agent <id>] [type <type>] [since <time>] [until <time>] [limit <n>]")
+			return
+		}
+	}
+	ctx, stop := interruptible()
+	list, err := events.QueryContext(ctx, f)
+	stop()
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	if len(list) == 0 {
+		message("info", "There are no events that match")
+		return
+	}
+	if len(list) > limit {
+		message("note", fmt.Sprintf("Showing the last %d of %d events; use limit <n> to show more", limit, len(list)))
+		list = list[len(list)-limit:]
+	}
+	// Events shared on an event bus show the server that published them
+	var nodes bool
+	for _, e := range list {
+		if e.Node != "" {
+			nodes = true
+			break
+		}
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	if nodes {
+		table.SetHeader([]string{"ID", "Time", "Node", "Type", "Agent", "Message"})
+	} else {
+		table.SetHeader([]string{"ID", "Time", "Type", "Agent", "Message"})
+	}
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, e := range list {
+		row := []string{strconv.FormatUint(e.ID, 10), e.Time.Format(time.RFC3339), e.Type, e.Agent, e.Message}
+		if nodes {
+			row = append(row[:2], append([]string{e.Node}, row[2:]...)...)
+		}
+		table.Append(row)
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
+
+// eventsItems returns the filters and event types completed for the events command
+func eventsItems() []readline.PrefixCompleterInterface {
+	types := make([]readline.PrefixCompleterInterface, 0)
+	for _, t := range events.Types() {
+		types = append(types, readline.PcItem(t))
+	}
+	return []readline.PrefixCompleterInterface{
+		readline.PcItem("agent", readline.PcItemDynamic(agents.GetAgentList())),
+		readline.PcItem("type", types...),
+		readline.PcItem("since"),
+		readline.PcItem("until"),
+		readline.PcItem("limit"),
+	}
+}
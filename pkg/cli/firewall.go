@@ -0,0 +1,123 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"time"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+)
+
+// firewallUsage is the syntax of the firewall command
+const firewallUsage = "firewall list [<name>], firewall add <name> <tcp|udp> <port> [in|out] [<remote address>], " +
+	"firewall delete <name|all>, firewall rules"
+
+// menuFirewall lists the host firewall rules on the host of the agent the agent menu is interacting with, adds or
+// deletes a rule, or shows the rules the server recorded as added so they can be cleaned up
+func menuFirewall(cmd []string) {
+	if len(cmd) < 1 {
+		message("warn", "Invalid command")
+		message("info", firewallUsage)
+		return
+	}
+	switch cmd[0] {
+	case "list", "add":
+		addFirewallJob(cmd)
+	case "delete":
+		if len(cmd) != 2 {
+			message("warn", "Invalid command")
+			message("info", firewallUsage)
+			return
+		}
+		if cmd[1] != "all" {
+			addFirewallJob(cmd)
+			return
+		}
+		rules, err := agents.FirewallRules(shellAgent)
+		if err != nil {
+			message("warn", fmt.Sprintf("There was an error reading the agent's firewall rules:\r\n%s", err.Error()))
+			return
+		}
+		if len(rules) == 0 {
+			message("info", "The server has not recorded any firewall rules added by this agent")
+		}
+		for _, r := range rules {
+			addFirewallJob([]string{"delete", r.Name})
+		}
+	case "rules":
+		rules, err := agents.FirewallRules(shellAgent)
+		if err != nil {
+			message("warn", fmt.Sprintf("There was an error reading the agent's firewall rules:\r\n%s", err.Error()))
+			return
+		}
+		if len(rules) == 0 {
+			message("info", "The server has not recorded any firewall rules added by this agent")
+			return
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Name", "Direction", "Protocol", "Port", "Remote", "Job", "Added"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, r := range rules {
+			remote := r.Remote
+			if remote == "" {
+				remote = "any"
+			}
+			table.Append([]string{r.Name, r.Direction, r.Protocol, r.Port, remote, r.Job, r.Added.Format(time.RFC3339)})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	default:
+		message("warn", fmt.Sprintf("%s is not a valid firewall action", cmd[0]))
+		message("info", firewallUsage)
+	}
+}
+
+// addFirewallJob checks the arguments of a firewall job and creates it for the agent the agent menu is interacting with
+func addFirewallJob(args []string) {
+	if _, err := agents.ParseFirewall(shellAgent, args); err != nil {
+		message("warn", err.Error())
+		return
+	}
+	m, err := addJob(shellAgent, "firewall", args)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+		m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+}
+
+// firewallNames returns the names of the firewall rules recorded for the agent the agent menu is interacting with
+func firewallNames() func(string) []string {
+	return func(line string) []string {
+		names := []string{"all"}
+		rules, _ := agents.FirewallRules(shellAgent)
+		for _, r := range rules {
+			names = append(names, r.Name)
+		}
+		return names
+	}
+}
@@ -0,0 +1,331 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	// 3rd Party
+	"github.com/chzyer/readline"
+	"github.com/olekukonko/tablewriter"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/config"
+	"github.com/Ne0nd0g/merlin/pkg/generate"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/mtls"
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// serverID, serverURL, serverProtocol, and serverProfile are the main listener's ID, URL, protocol, and HTTP profile
+// file that agents generated from the main menu use by default
+var serverID uuid.UUID
+var serverURL = "https://127.0.0.1:443"
+var serverProtocol = "h2"
+var serverProfile string
+
+// shellGenerate holds the options for the agent being generated in the generate menu
+var shellGenerate listenerConfig
+
+// generateReturn is the menu context the generate menu's back command returns to
+var generateReturn = "main"
+
+// SetServer sets the main listener's ID, URL, protocol, and HTTP profile file that agents generated from the main menu
+// use by default
+func SetServer(id uuid.UUID, url string, protocol string, profile string) {
+	serverID = id
+	serverURL = url
+	serverProtocol = protocol
+	serverProfile = profile
+}
+
+// generateOptions returns the configurable options, with their default values, for an agent that connects to the URL
+func generateOptions(protocol string, url string, psk string, profile string, format string) []listenerOption {
+	return []listenerOption{
+		{"OS", "windows", "The operating system the agent is built for: darwin, linux, or windows"},
+		{"Arch", "amd64", "The architecture the agent is built for; " + strings.Join(generate.GetPlatforms(), ", ")},
+		{"Format", format, "The agent file format: exe, or dll and shellcode for Windows; dll and shellcode require MinGW-w64"},
+		{"URL", url, "The URL the agent connects to; separate several URLs with commas to rotate check ins across them"},
+		{"Protocol", protocol, "The protocol the agent connects with: " + strings.Join(generate.GetProtocols(), ", ")},
+		{"PSK", psk, "The pre-shared key the agent uses to encrypt its initial messages"},
+		{"Proxy", "", "Hardcoded proxy for http/1.1 traffic only"},
+		{"Host", "", "The HTTP Host header"},
+		{"UserAgent", "", "The HTTP User-Agent header; empty uses the agent's default"},
+		{"Sleep", "30s", "The time the agent sleeps between check ins"},
+		{"Rotate", "0s", "How long the agent uses one of several URLs before moving to the next; 0s rotates every check in"},
+		{"Pins", "", "Certificate public key pins or PEM certificate files, separated by commas, the agent requires its listeners to match; empty accepts any certificate"},
+		{"ClientCert", "false", "Issue a client certificate for the agent and embed it; listeners with ClientCerts set to true require one"},
+		{"CrashReports", "false", "Send the server a sanitized stack trace when the agent recovers from a panic"},
+		{"Profile", profile, "The HTTP profile file that shapes the agent's messages; it must match the listener's profile"},
+		{"Engagement", "", "The engagement ID to watermark the agent with"},
+		{"Operator", "", "The operator to watermark the agent with"},
+		{"Output", generate.Output, "The directory the agent is written to"},
+	}
+}
+
+// menuSetGenerate enters the generate menu with the options and returns to the back menu context when done
+func menuSetGenerate(options []listenerOption, back string) {
+	shellGenerate = listenerConfig{Protocol: "generate", Options: options}
+	generateReturn = back
+	prompt.Config.AutoComplete = getCompleter("generate")
+	setPrompt("\033[31mMerlin[\033[32mgenerate\033[31m]»\033[0m ")
+	shellMenuContext = "generate"
+}
+
+// menuGenerate handles commands in the menu that configures and generates an agent
+func menuGenerate(cmd []string) {
+	switch cmd[0] {
+	case "back":
+		switch generateReturn {
+		case "listener":
+			prompt.Config.AutoComplete = getCompleter("listener")
+			setPrompt("\033[31mMerlin[\033[32mlisteners\033[31m][\033[33m" + shellListener.Protocol + "\033[31m]»\033[0m ")
+			shellMenuContext = "listener"
+		case "listeners":
+			menuSetListeners()
+		default:
+			menuSetMain()
+		}
+	case "main":
+		menuSetMain()
+	case "exit", "quit":
+		exit(cmd[1:])
+	case "?", "help":
+		menuHelpGenerate()
+	case "info":
+		shellGenerate.showOptions()
+	case "show":
+		if len(cmd) > 1 && (cmd[1] == "options" || cmd[1] == "info") {
+			shellGenerate.showOptions()
+		}
+	case "set":
+		if len(cmd) < 3 {
+			message("warn", "Invalid 'set' command; use set <option> <value>")
+			return
+		}
+		for i, o := range shellGenerate.Options {
+			if strings.EqualFold(o.Name, cmd[1]) {
+				shellGenerate.Options[i].Value = strings.Join(cmd[2:], " ")
+				message("success", fmt.Sprintf("%s set to %s", o.Name, shellGenerate.Options[i].Value))
+				return
+			}
+		}
+		message("warn", fmt.Sprintf("%s is not a valid option for generating an agent", cmd[1]))
+	case "run", "generate":
+		format, ok := generateFormat(cmd[0], cmd[1:])
+		if !ok {
+			return
+		}
+		if format != "" {
+			for i, o := range shellGenerate.Options {
+				if o.Name == "Format" {
+					shellGenerate.Options[i].Value = format
+				}
+			}
+		}
+		pins, err := agents.ParsePins([]string{shellGenerate.option("Pins")})
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		crash, err := strconv.ParseBool(shellGenerate.option("CrashReports"))
+		if err != nil {
+			message("warn", fmt.Sprintf("%s is not a valid CrashReports value; use true or false", shellGenerate.option("CrashReports")))
+			return
+		}
+		clientCert, err := strconv.ParseBool(shellGenerate.option("ClientCert"))
+		if err != nil {
+			message("warn", fmt.Sprintf("%s is not a valid ClientCert value; use true or false", shellGenerate.option("ClientCert")))
+			return
+		}
+		o := generate.Options{
+			Config: config.Config{
+				URL:       shellGenerate.option("URL"),
+				PSK:       shellGenerate.option("PSK"),
+				Proxy:     shellGenerate.option("Proxy"),
+				Host:      shellGenerate.option("Host"),
+				Protocol:  shellGenerate.option("Protocol"),
+				UserAgent: shellGenerate.option("UserAgent"),
+				Sleep:     shellGenerate.option("Sleep"),
+				Rotate:    shellGenerate.option("Rotate"),
+				Pins:      pins,
+				Crash:     crash,
+			},
+			OS:         shellGenerate.option("OS"),
+			Arch:       shellGenerate.option("Arch"),
+			Format:     shellGenerate.option("Format"),
+			Engagement: shellGenerate.option("Engagement"),
+			Operator:   shellGenerate.option("Operator"),
+			Output:     shellGenerate.option("Output"),
+			Profile:    shellGenerate.option("Profile"),
+		}
+		var certName string
+		if clientCert {
+			certName, o.Config.ClientCert, err = issueAgentCert(o.OS, o.Arch)
+			if err != nil {
+				message("warn", err.Error())
+				return
+			}
+		}
+		message("info", fmt.Sprintf("Building the %s/%s %s agent for %s; this can take a minute, press Ctrl-C to cancel...", o.OS, o.Arch, o.Format, o.Config.URL))
+		ctx, stop := interruptible()
+		file, err := generate.AgentContext(ctx, o)
+		stop()
+		if err != nil {
+			message("warn", err.Error())
+			if certName != "" {
+				if errRevoke := mtls.Revoke(certName); errRevoke != nil {
+					message("warn", errRevoke.Error())
+				}
+			}
+			return
+		}
+		m := fmt.Sprintf("Generated a %s/%s %s agent that connects to %s over %s at %s", o.OS, o.Arch, o.Format, o.Config.URL, o.Config.Protocol, file)
+		logging.Server(m)
+		message("success", m)
+		if certName != "" {
+			message("info", fmt.Sprintf("The agent presents client certificate %s; revoke it with the listeners menu certs revoke command", certName))
+		}
+	default:
+		message("warn", fmt.Sprintf("Invalid generate command: %s", cmd[0]))
+	}
+}
+
+// generateFormat returns the agent format from a command's optional -f <format> argument and false if the arguments
+// are not valid
+func generateFormat(command string, args []string) (string, bool) {
+	if len(args) == 0 {
+		return "", true
+	}
+	if len(args) != 2 || args[0] != "-f" {
+		message("warn", fmt.Sprintf("Invalid '%s' command; use %s [-f %s]", command, command, strings.Join(generate.GetFormats(), "|")))
+		return "", false
+	}
+	format := strings.ToLower(args[1])
+	for _, f := range generate.GetFormats() {
+		if f == format {
+			return format, true
+		}
+	}
+	message("warn", fmt.Sprintf("%s is not a valid agent format; use %s", args[1], strings.Join(generate.GetFormats(), ", ")))
+	return "", false
+}
+
+// agentURL returns the protocol and URL an agent uses to connect to the listener. The listener's interface is used as
+// the host, so the URL must be changed when the listener binds to all interfaces or is reached through a redirector.
+func (l *listenerConfig) agentURL() (string, string) {
+	host := util.JoinHostPort(l.option("Interface"), l.option("Port"))
+	q := url.Values{}
+	switch l.Protocol {
+	case "dns":
+		q.Set("type", l.option("RecordType"))
+		u := url.URL{Scheme: "dns", Host: host, Path: "/" + l.option("Domain"), RawQuery: q.Encode()}
+		return "dns", u.String()
+	case "tcp":
+		if l.option("Mode") == "bind" {
+			// The agent listens on every IPv4 and IPv6 interface for the bind listener to connect to
+			_, port, _ := net.SplitHostPort(l.option("Address"))
+			host = net.JoinHostPort("", port)
+			q.Set("bind", "true")
+		}
+		if useTLS, _ := strconv.ParseBool(l.option("TLS")); useTLS {
+			q.Set("tls", "true")
+		}
+		u := url.URL{Scheme: "tcp", Host: host, RawQuery: q.Encode()}
+		return "tcp", u.String()
+	case "http":
+		u := url.URL{Scheme: "https", Host: host, Path: "/"}
+		return l.option("Protocol"), u.String()
+	case "ws":
+		scheme := "ws"
+		if useTLS, _ := strconv.ParseBool(l.option("TLS")); useTLS {
+			scheme = "wss"
+		}
+		if s := l.option("Subprotocol"); s != "" {
+			q.Set("subprotocol", s)
+		}
+		if o := l.option("Origin"); o != "" {
+			q.Set("origin", o)
+		}
+		u := url.URL{Scheme: scheme, Host: host, Path: l.option("Path"), RawQuery: q.Encode()}
+		return scheme, u.String()
+	}
+	return l.Protocol, ""
+}
+
+// generateCompleter returns the tab completer for the generate menu
+func generateCompleter() *readline.PrefixCompleter {
+	return readline.NewPrefixCompleter(
+		readline.PcItem("back"),
+		readline.PcItem("generate",
+			readline.PcItem("-f", formatItems()...),
+		),
+		readline.PcItem("help"),
+		readline.PcItem("info"),
+		readline.PcItem("main"),
+		readline.PcItem("run",
+			readline.PcItem("-f", formatItems()...),
+		),
+		readline.PcItem("set",
+			readline.PcItemDynamic(shellGenerate.getOptionsList()),
+		),
+		readline.PcItem("show",
+			readline.PcItem("options"),
+		),
+	)
+}
+
+// formatItems returns the agent formats for tab completion after -f
+func formatItems() []readline.PrefixCompleterInterface {
+	var items []readline.PrefixCompleterInterface
+	for _, f := range generate.GetFormats() {
+		items = append(items, readline.PcItem(f))
+	}
+	return items
+}
+
+// The help menu while generating an agent
+func menuHelpGenerate() {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetBorder(false)
+	table.SetCaption(true, "Generate Menu Help")
+	table.SetHeader([]string{"Command", "Description", "Options"})
+
+	data := [][]string{
+		{"back", "Return to the previous menu", ""},
+		{"info", "Show the agent's options", ""},
+		{"main", "Return to the main menu", ""},
+		{"run", "Build the agent and write it to the output directory; the Go toolchain and Merlin source are required", "[-f exe|dll|shellcode]"},
+		{"set", "Set the value for one of the agent's options", "<option name> <option value>"},
+		{"show", "Show the agent's options", "options"},
+	}
+
+	table.AppendBulk(data)
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
@@ -0,0 +1,155 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/hosts"
+)
+
+// hostsUsage is the syntax of the hosts command
+const hostsUsage = "hosts list [<subnet>], hosts subnets, hosts info <ip>, hosts export <file.json|file.csv|file.dot>"
+
+// menuHosts lists and exports the host inventory built from agent check ins and from ipconfig, arp, and nmap output
+// in job results
+func menuHosts(cmd []string) {
+	if len(cmd) == 0 {
+		message("warn", "Invalid 'hosts' command")
+		message("info", hostsUsage)
+		return
+	}
+	switch cmd[0] {
+	case "list":
+		var subnet string
+		if len(cmd) > 1 {
+			subnet = cmd[1]
+		}
+		list, err := hosts.List(subnet)
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		hostsTable(list)
+	case "subnets":
+		list, err := hosts.Subnets()
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		if len(list) == 0 {
+			message("info", "There are no subnets in the host inventory")
+			return
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Subnet", "Hosts", "Agents"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, s := range list {
+			table.Append([]string{s.Subnet, strconv.Itoa(s.Hosts), strings.Join(s.Agents, "\n")})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case "info":
+		if len(cmd) < 2 {
+			message("warn", "Invalid 'hosts info' command; use hosts info <ip>")
+			return
+		}
+		showHost(cmd[1])
+	case "export":
+		if len(cmd) < 2 {
+			message("warn", "Invalid 'hosts export' command; use hosts export <file.json|file.csv|file.dot>")
+			return
+		}
+		if err := hosts.Export(cmd[1]); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Exported the host inventory to %s", cmd[1]))
+	default:
+		message("warn", fmt.Sprintf("Invalid 'hosts' command: %s", cmd[0]))
+		message("info", hostsUsage)
+	}
+}
+
+// hostsTable prints the hosts as a table
+func hostsTable(list []hosts.Host) {
+	if len(list) == 0 {
+		message("info", "There are no hosts in the host inventory")
+		return
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Address", "Names", "Subnet", "MAC", "Open Ports", "Agents On Host", "Reported By", "Last Seen"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, h := range list {
+		var reported []string
+		for _, r := range h.Reach {
+			reported = append(reported, fmt.Sprintf("%s (%s)", r.Agent, r.Source))
+		}
+		table.Append([]string{h.Address, strings.Join(h.Names, ", "), h.Subnet, h.MAC, strings.Join(h.Ports, ", "),
+			strings.Join(h.Agents, "\n"), strings.Join(reported, "\n"), h.Last.Format(time.RFC3339)})
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
+
+// showHost prints everything known about the host and every agent that can reach it
+func showHost(address string) {
+	h, err := hosts.Get(address)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	reach, err := hosts.Reachable(h)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	var reachable []string
+	for _, r := range reach {
+		reachable = append(reachable, fmt.Sprintf("%s (%s)", r.Agent, r.Source))
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	data := [][]string{
+		{"Address", h.Address},
+		{"Names", strings.Join(h.Names, ", ")},
+		{"Subnet", h.Subnet},
+		{"MAC", h.MAC},
+		{"Open Ports", strings.Join(h.Ports, ", ")},
+		{"Agents On Host", strings.Join(h.Agents, "\n")},
+		{"Reachable By", strings.Join(reachable, "\n")},
+		{"First Seen", h.First.Format(time.RFC3339)},
+		{"Last Seen", h.Last.Format(time.RFC3339)},
+	}
+	table.AppendBulk(data)
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
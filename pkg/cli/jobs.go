@@ -0,0 +1,104 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+
+	// 3rd Party
+	"github.com/chzyer/readline"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+)
+
+// jobsUsage is the syntax of the jobs command
+const jobsUsage = "jobs [risk [<minimum score>]], jobs cancel <job ID>, jobs priority <job ID> <low|normal|high|urgent>"
+
+// menuJob cancels a queued job, or changes its priority, for the agent, or for whichever agent it was queued for if
+// the ID is uuid.Nil
+func menuJob(agentID uuid.UUID, cmd []string) {
+	switch {
+	case cmd[0] == "cancel" && len(cmd) == 2:
+		id, err := agents.CancelJob(agentID, cmd[1])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Canceled job %s for agent %s", cmd[1], id))
+	case cmd[0] == "priority" && len(cmd) == 3:
+		p, err := agents.ParsePriority(cmd[2])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		if agentID == uuid.Nil {
+			agentID = jobAgent(cmd[1])
+		}
+		if err = agents.SetJobPriority(agentID, cmd[1], p); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Changed the priority of job %s for agent %s to %s", cmd[1], agentID,
+			agents.PriorityName(p)))
+	default:
+		message("warn", "Invalid 'jobs' command")
+		message("info", jobsUsage)
+	}
+}
+
+// jobAgent returns the agent the job is queued for, or uuid.Nil if it is not a queued job
+func jobAgent(jobID string) uuid.UUID {
+	for _, q := range agents.GetQueuedJobs(uuid.Nil, false) {
+		if q.Job.ID == jobID {
+			return q.Agent
+		}
+	}
+	return uuid.Nil
+}
+
+// jobsItems returns the completer items for the jobs command in the agent menu, or the main menu when agent is false
+func jobsItems(agent bool) []readline.PrefixCompleterInterface {
+	ids := func(line string) []string {
+		agentID := uuid.Nil
+		if agent {
+			agentID = shellAgent
+		}
+		var list []string
+		for _, q := range agents.GetQueuedJobs(agentID, false) {
+			list = append(list, q.Job.ID)
+		}
+		return list
+	}
+	return []readline.PrefixCompleterInterface{
+		readline.PcItem("cancel",
+			readline.PcItemDynamic(ids),
+		),
+		readline.PcItem("priority",
+			readline.PcItemDynamic(ids,
+				readline.PcItem("low"),
+				readline.PcItem("normal"),
+				readline.PcItem("high"),
+				readline.PcItem("urgent"),
+			),
+		),
+		readline.PcItem("risk"),
+	}
+}
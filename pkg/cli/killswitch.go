@@ -0,0 +1,107 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"sort"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/audit"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+)
+
+// menuKillAll tasks every agent to remove its persistence and files and exit, for end-of-engagement cleanup
+func menuKillAll(args []string) {
+	var ids []uuid.UUID
+	for id := range agents.Agents {
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		message("info", "There are no agents to kill")
+		return
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	question := fmt.Sprintf("Are you sure you want all %d agents to remove their persistence and files and exit?", len(ids))
+	if ok, rest := confirmCommand("killall", question, args); !ok || len(rest) > 0 {
+		if len(rest) > 0 {
+			message("warn", "Invalid 'agent killall' command; use agent killall [-y]")
+		}
+		return
+	}
+	killSwitch(ids, fmt.Sprintf("killall%s", by()))
+}
+
+// menuKillSwitch tasks every agent that last checked in through the listener to remove its persistence and files and
+// exit
+func menuKillSwitch(cmd []string) {
+	if len(cmd) < 2 {
+		message("warn", "Invalid 'killswitch' command; use killswitch <listener ID|main> [-y]")
+		return
+	}
+	listener, err := stagerListener(cmd[1])
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	ids := agents.ListenerAgents(listener)
+	if len(ids) == 0 {
+		message("info", fmt.Sprintf("No agents have checked in through listener %s since the server started", cmd[1]))
+		return
+	}
+	question := fmt.Sprintf("Are you sure you want the %d agents on listener %s to remove their persistence and files and exit?",
+		len(ids), stagerListenerName(listener))
+	if ok, rest := confirmCommand("killswitch", question, cmd[2:]); !ok || len(rest) > 0 {
+		if len(rest) > 0 {
+			message("warn", "Invalid 'killswitch' command; use killswitch <listener ID|main> [-y]")
+		}
+		return
+	}
+	killSwitch(ids, fmt.Sprintf("for listener %s%s", stagerListenerName(listener), by()))
+}
+
+// killSwitch creates a selfdestruct job for each agent that is not dead or pending, records each one in the audit log
+// with the agent it targets, and reports the agents that were skipped
+func killSwitch(ids []uuid.UUID, reason string) {
+	jobs, skipped := agents.KillSwitch(ids, reason)
+	for _, id := range ids {
+		job, ok := jobs[id]
+		if !ok {
+			continue
+		}
+		e := audit.Entry{Client: shellOperator, Session: shellSession, Menu: shellMenuContext, Agent: id.String(),
+			Command: "selfdestruct", Args: []string{"kill switch", job}}
+		if e.Client == "" {
+			e.Client = audit.Console
+		}
+		if err := audit.Record(e); err != nil {
+			message("warn", err.Error())
+			logging.Server(err.Error())
+		}
+		message("note", fmt.Sprintf("Created selfdestruct job %s for agent %s", job, id))
+	}
+	for _, id := range skipped {
+		message("note", fmt.Sprintf("Skipped agent %s because it is %s", id, agents.GetAgentStatus(id)))
+	}
+	message("success", fmt.Sprintf("Created selfdestruct jobs for %d agents; each one exits after it reports what it removed", len(jobs)))
+}
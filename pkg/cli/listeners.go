@@ -0,0 +1,697 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	// 3rd Party
+	"github.com/chzyer/readline"
+	"github.com/olekukonko/tablewriter"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/acme"
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/api"
+	"github.com/Ne0nd0g/merlin/pkg/api/rest"
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/profiles"
+	"github.com/Ne0nd0g/merlin/pkg/servers/dns"
+	"github.com/Ne0nd0g/merlin/pkg/servers/edge"
+	"github.com/Ne0nd0g/merlin/pkg/servers/http2"
+	"github.com/Ne0nd0g/merlin/pkg/servers/tcp"
+	"github.com/Ne0nd0g/merlin/pkg/servers/unix"
+	"github.com/Ne0nd0g/merlin/pkg/servers/ws"
+	"github.com/Ne0nd0g/merlin/pkg/stager"
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// listenerPSK is the default pre-shared key for listeners started from the listeners menu
+var listenerPSK = "merlin"
+
+// shellListener is the listener being configured in the listener menu
+var shellListener listenerConfig
+
+// listeners holds the listeners started from the listeners menu
+var listeners = struct {
+	sync.Mutex
+	servers []listenerInfo
+}{}
+
+// listenerInfo describes a listener started from the listeners menu
+type listenerInfo struct {
+	ID        uuid.UUID
+	Protocol  string
+	Interface string
+	Port      int
+	Details   string         // Protocol specific settings (i.e. the DNS domain or the TCP mode)
+	config    listenerConfig // The options the listener was started with; used to generate agents for it
+}
+
+// listenerOption is a configurable listener option
+type listenerOption struct {
+	Name        string
+	Value       string
+	Description string
+}
+
+// listenerConfig is a listener type and its options before it is started
+type listenerConfig struct {
+	Protocol string
+	Options  []listenerOption
+}
+
+// GetListenerTypes returns the listener types that can be configured and started from the listeners menu
+func GetListenerTypes() []string {
+	return []string{"dns", "edge", "http", "tcp", "unix", "ws"}
+}
+
+// listenerOptions returns the configurable options, with their default values, for a listener type
+func listenerOptions(protocol string) []listenerOption {
+	switch protocol {
+	case "dns":
+		return []listenerOption{
+			{"Interface", "127.0.0.1", "The IPv4 or IPv6 address the listener binds to; :: binds to every IPv4 and IPv6 interface"},
+			{"Port", "53", "The UDP and TCP port the listener binds to"},
+			{"Domain", "", "The domain delegated to this server; agents query its subdomains"},
+			{"RecordType", "txt", "The record type, txt or a, used to answer agents; it must match the agent's type"},
+			{"ChunkSize", "", "The response bytes returned in one answer; empty fits an answer in one UDP response"},
+			{"PSK", listenerPSK, "The pre-shared key agents use to encrypt their initial messages"},
+		}
+	case "edge":
+		return []listenerOption{
+			{"Interface", "0.0.0.0", "The IPv4 or IPv6 address edge nodes connect to; :: binds to every IPv4 and IPv6 interface"},
+			{"Port", "7443", "The port edge nodes connect to with mutual TLS"},
+			{"PSK", listenerPSK, "The pre-shared key agents use to encrypt their initial messages"},
+		}
+	case "http":
+		return []listenerOption{
+			{"Interface", "127.0.0.1", "The IPv4 or IPv6 address the listener binds to; :: binds to every IPv4 and IPv6 interface"},
+			{"Port", "8443", "The port the listener binds to"},
+			{"Protocol", "h2", "The HTTP protocol agents connect with: h2 or hq"},
+			{"Certificate", filepath.Join(core.CurrentDir, "data", "x509", "server.crt"), "The x.509 public key file; an ephemeral certificate is used if it does not exist"},
+			{"Key", filepath.Join(core.CurrentDir, "data", "x509", "server.key"), "The x.509 private key file"},
+			{"Profile", "", "The HTTP profile file that shapes agent messages (i.e. data/profiles/jquery.json); empty uses Merlin's default messages"},
+			{"ACMEDomain", "", "Obtain and renew the certificate for this domain with ACME; the listener must be reachable on port 443 of the domain (h2 only)"},
+			{"ACMEEmail", "", "The contact email address registered with the ACME certificate authority"},
+			{"ACMEDirectory", acme.LetsEncrypt, "The ACME certificate authority's directory URL"},
+			{"ClientCerts", "false", "Reject agent messages without a client certificate issued with the certs command or the generate menu's ClientCert option (h2 only)"},
+			{"PSK", listenerPSK, "The pre-shared key agents use to encrypt their initial messages"},
+		}
+	case "tcp":
+		return []listenerOption{
+			{"Mode", "reverse", "reverse to listen for agents or bind to connect to an agent that is listening"},
+			{"Interface", "127.0.0.1", "The IPv4 or IPv6 address the listener binds to in reverse mode; :: binds to every IPv4 and IPv6 interface"},
+			{"Port", "4444", "The port the listener binds to in reverse mode"},
+			{"Address", "", "The agent's host:port to connect to in bind mode"},
+			{"TLS", "false", "Wrap the TCP connection in TLS; it must match the agent's tls setting"},
+			{"PSK", listenerPSK, "The pre-shared key agents use to encrypt their initial messages"},
+		}
+	case "unix":
+		return []listenerOption{
+			{"Path", filepath.Join(core.CurrentDir, "data", "merlin.sock"), "The Unix domain socket file the listener binds to; an old socket file is replaced"},
+			{"Transport", "http", "http for a redirector that proxies agents' HTTP requests or tcp for a relay of tcp agents' messages"},
+			{"Permissions", "0600", "The octal file mode of the socket, which controls the local users that can connect to it"},
+			{"PSK", listenerPSK, "The pre-shared key agents use to encrypt their initial messages"},
+		}
+	case "ws":
+		return []listenerOption{
+			{"Interface", "127.0.0.1", "The IPv4 or IPv6 address the listener binds to; :: binds to every IPv4 and IPv6 interface"},
+			{"Port", "8080", "The port the listener binds to"},
+			{"TLS", "false", "Serve wss instead of ws; agents must use the matching URL scheme"},
+			{"Path", "/", "The URL path upgraded to a WebSocket connection"},
+			{"Subprotocol", "", "The Sec-WebSocket-Protocol agents must request; empty accepts any"},
+			{"Origin", "", "The Origin header agents must send; empty accepts any"},
+			{"Certificate", "", "The x.509 public key file for wss; empty uses an ephemeral certificate"},
+			{"Key", "", "The x.509 private key file for wss"},
+			{"PSK", listenerPSK, "The pre-shared key agents use to encrypt their initial messages"},
+		}
+	}
+	return nil
+}
+
+// SetPSK sets the pre-shared key used by default for listeners started from the listeners menu
+func SetPSK(psk string) {
+	listenerPSK = psk
+}
+
+// menuListeners handles commands in the listeners menu
+func menuListeners(cmd []string) {
+	switch cmd[0] {
+	case "back", "main":
+		menuSetMain()
+	case "exit", "quit":
+		exit(cmd[1:])
+	case "?", "help":
+		menuHelpListeners()
+	case "list":
+		listeners.Lock()
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ID", "Protocol", "Interface", "Port", "Details"})
+		table.SetAlignment(tablewriter.ALIGN_CENTER)
+		for _, s := range listeners.servers {
+			table.Append([]string{s.ID.String(), s.Protocol, s.Interface, strconv.Itoa(s.Port), s.Details + pskDetails(s.ID)})
+		}
+		listeners.Unlock()
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case "generate":
+		if len(cmd) < 2 {
+			message("warn", "Invalid 'generate' command; use generate <listener ID> [-f exe|dll|shellcode]")
+			return
+		}
+		format, ok := generateFormat(cmd[0], cmd[2:])
+		if !ok {
+			return
+		}
+		listeners.Lock()
+		var l *listenerConfig
+		for _, s := range listeners.servers {
+			if s.ID.String() == cmd[1] {
+				l = &s.config
+				break
+			}
+		}
+		listeners.Unlock()
+		if l == nil {
+			message("warn", fmt.Sprintf("%s is not a listener started from the listeners menu", cmd[1]))
+			return
+		}
+		protocol, u := l.agentURL()
+		if u == "" {
+			message("warn", fmt.Sprintf("Agents do not connect to a %s listener directly; use the generate menu with the URL agents connect to", l.Protocol))
+			return
+		}
+		menuSetGenerate(withClientCert(generateOptions(protocol, u, l.option("PSK"), l.option("Profile"), format),
+			l.option("ClientCerts")), "listeners")
+	case "certs":
+		menuCerts(cmd)
+	case "edge":
+		menuEdge(cmd)
+	case "killswitch":
+		menuKillSwitch(cmd)
+	case "requests":
+		menuRequests(cmd)
+	case "set":
+		menuListenerPSK(cmd[1:])
+	case "stager":
+		menuStager(cmd)
+	case "use":
+		if len(cmd) < 2 {
+			message("warn", fmt.Sprintf("Invalid 'use' command; use %s, or a template", strings.Join(GetListenerTypes(), ", ")))
+			return
+		}
+		protocol := strings.ToLower(cmd[1])
+		if inSlice(protocol, GetListenerTypes()) {
+			useListener(listenerConfig{Protocol: protocol, Options: listenerOptions(protocol)}, protocol)
+			return
+		}
+		l, err := loadListenerTemplate(cmd[1])
+		if err != nil {
+			message("warn", fmt.Sprintf("%s is not a listener type that can be started from the listeners menu or a listener template", cmd[1]))
+			return
+		}
+		useListener(l, cmd[1])
+	case "clone":
+		menuListenerClone(cmd[1:])
+	case "templates":
+		menuListenerTemplates(cmd[1:])
+	default:
+		message("warn", fmt.Sprintf("Invalid listeners command: %s", cmd[0]))
+	}
+}
+
+// menuListener handles commands in the menu that configures and starts a listener
+func menuListener(cmd []string) {
+	switch cmd[0] {
+	case "back":
+		menuSetListeners()
+	case "main":
+		menuSetMain()
+	case "exit", "quit":
+		exit(cmd[1:])
+	case "?", "help":
+		menuHelpListener()
+	case "info":
+		shellListener.showOptions()
+	case "show":
+		if len(cmd) > 1 && (cmd[1] == "options" || cmd[1] == "info") {
+			shellListener.showOptions()
+		}
+	case "set":
+		if len(cmd) < 3 {
+			message("warn", "Invalid 'set' command; use set <option> <value>")
+			return
+		}
+		for i, o := range shellListener.Options {
+			if strings.EqualFold(o.Name, cmd[1]) {
+				shellListener.Options[i].Value = strings.Join(cmd[2:], " ")
+				message("success", fmt.Sprintf("%s set to %s", o.Name, shellListener.Options[i].Value))
+				return
+			}
+		}
+		message("warn", fmt.Sprintf("%s is not a valid option for the %s listener", cmd[1], shellListener.Protocol))
+	case "save":
+		if len(cmd) != 3 || cmd[1] != "template" {
+			message("warn", "Invalid 'save' command; use save template <name>")
+			return
+		}
+		if err := saveListenerTemplate(cmd[2], shellListener); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Saved the %s listener's options as the %s template; configure another with 'use %s' "+
+			"in the listeners menu", shellListener.Protocol, cmd[2], cmd[2]))
+	case "start", "run":
+		s, err := shellListener.start()
+		if err != nil {
+			message("warn", err.Error())
+			if api.Is(err, api.ListenerExists) {
+				message("info", "Use 'back' and 'list' to see the running listeners or change the listener's address")
+			}
+			return
+		}
+		listeners.Lock()
+		listeners.servers = append(listeners.servers, s)
+		listeners.Unlock()
+		message("success", fmt.Sprintf("Started %s listener %s", s.Protocol, s.ID.String()))
+		menuSetListeners()
+	case "generate":
+		format, ok := generateFormat(cmd[0], cmd[1:])
+		if !ok {
+			return
+		}
+		protocol, u := shellListener.agentURL()
+		if u == "" {
+			message("warn", fmt.Sprintf("Agents do not connect to a %s listener directly; use the generate menu with the URL agents connect to", shellListener.Protocol))
+			return
+		}
+		menuSetGenerate(withClientCert(generateOptions(protocol, u, shellListener.option("PSK"), shellListener.option("Profile"), format),
+			shellListener.option("ClientCerts")), "listener")
+	default:
+		message("warn", fmt.Sprintf("Invalid listener command: %s", cmd[0]))
+	}
+}
+
+// useListener configures the listener in the listener menu; the name is its type or the template it was loaded from
+func useListener(l listenerConfig, name string) {
+	shellListener = l
+	prompt.Config.AutoComplete = getCompleter("listener")
+	setPrompt("\033[31mMerlin[\033[32mlisteners\033[31m][\033[33m" + name + "\033[31m]»\033[0m ")
+	shellMenuContext = "listener"
+}
+
+// option returns the value of the listener option
+func (l *listenerConfig) option(name string) string {
+	for _, o := range l.Options {
+		if o.Name == name {
+			return o.Value
+		}
+	}
+	return ""
+}
+
+// start creates and runs the listener from its options
+func (l *listenerConfig) start() (listenerInfo, error) {
+	var info listenerInfo
+	if l.Protocol == "unix" {
+		permissions, err := strconv.ParseUint(l.option("Permissions"), 8, 32)
+		if err != nil || permissions > 0777 {
+			return info, api.Errorf(api.InvalidOption, "%s is not a valid octal file mode", l.option("Permissions"))
+		}
+		if e, ok := listenerInUse(l.Protocol, l.option("Path"), 0); ok {
+			return info, api.Errorf(api.ListenerExists, "listener %s is already using %s", e.ID, e.Interface)
+		}
+		s, err := unix.New(l.option("Path"), l.option("Transport"), os.FileMode(permissions), l.option("PSK"))
+		if err != nil {
+			return info, err
+		}
+		if err = s.Run(); err != nil {
+			return info, api.Wrap(api.TransportFailure, err)
+		}
+		agents.RegisterKeys(s.ID, s)
+		return listenerInfo{s.ID, s.Protocol, s.Path, 0, fmt.Sprintf("%s transport, mode %04o", s.Transport, s.Permissions), *l}, nil
+	}
+	port, err := strconv.Atoi(l.option("Port"))
+	if err != nil || port < 0 || port > 65535 {
+		return info, api.Errorf(api.InvalidOption, "%s is not a valid port", l.option("Port"))
+	}
+	if e, ok := listenerInUse(l.Protocol, l.option("Interface"), port); ok && l.option("Mode") != "bind" {
+		return info, api.Errorf(api.ListenerExists, "%s listener %s is already using %s", e.Protocol, e.ID,
+			util.JoinHostPort(e.Interface, strconv.Itoa(e.Port)))
+	}
+	switch l.Protocol {
+	case "dns":
+		var chunk int
+		if c := l.option("ChunkSize"); c != "" {
+			chunk, err = strconv.Atoi(c)
+			if err != nil {
+				return info, api.Errorf(api.InvalidOption, "%s is not a valid chunk size", c)
+			}
+		}
+		s, err := dns.New(l.option("Interface"), port, l.option("Domain"), l.option("RecordType"), chunk, l.option("PSK"))
+		if err != nil {
+			return info, err
+		}
+		if err = s.Run(); err != nil {
+			return info, api.Wrap(api.TransportFailure, err)
+		}
+		agents.RegisterKeys(s.ID, s)
+		return listenerInfo{s.ID, s.Protocol, s.Interface, s.Port,
+			fmt.Sprintf("%s domain, %s records, %d byte chunks", s.Domain, s.RecordType, s.ChunkSize), *l}, nil
+	case "edge":
+		s, err := edge.New(l.option("Interface"), port, l.option("PSK"))
+		if err != nil {
+			return info, err
+		}
+		if err = s.Run(); err != nil {
+			return info, api.Wrap(api.TransportFailure, err)
+		}
+		agents.RegisterKeys(s.ID, s)
+		return listenerInfo{s.ID, s.Protocol, s.Interface, s.Port, "mutual TLS relay for enrolled edge nodes", *l}, nil
+	case "http":
+		var profile *profiles.Profile
+		if f := l.option("Profile"); f != "" {
+			profile, err = profiles.Load(f)
+			if err != nil {
+				return info, err
+			}
+		}
+		s, err := http2.NewWithProfile(l.option("Interface"), port, l.option("Protocol"), l.option("Key"),
+			l.option("Certificate"), l.option("PSK"), profile)
+		if err != nil {
+			return info, err
+		}
+		details := "default messages"
+		if profile != nil {
+			details = profile.Name + " profile"
+		}
+		if domain := l.option("ACMEDomain"); domain != "" {
+			m, err := acme.New(domain, l.option("ACMEEmail"), l.option("ACMEDirectory"),
+				filepath.Join(core.CurrentDir, "data", "x509", "acme"))
+			if err != nil {
+				return info, api.Wrap(api.InvalidOption, err)
+			}
+			if err = s.UseACME(m); err != nil {
+				return info, api.Wrap(api.InvalidOption, err)
+			}
+			details += ", ACME certificate for " + m.Domain
+		}
+		if clientCerts, err := strconv.ParseBool(l.option("ClientCerts")); err != nil {
+			return info, api.Errorf(api.InvalidOption, "%s is not a valid ClientCerts value; use true or false", l.option("ClientCerts"))
+		} else if clientCerts {
+			if err = s.RequireClientCerts(); err != nil {
+				return info, api.Wrap(api.InvalidOption, err)
+			}
+			details += ", client certificates required"
+		}
+		agents.RegisterListener(s.ID, &s)
+		rest.AddListener(&s)
+		// The HTTP server's Run blocks until the listener stops
+		go func() {
+			if err := s.Run(); err != nil {
+				message("warn", err.Error())
+			}
+		}()
+		return listenerInfo{s.ID, s.Protocol, s.Interface, s.Port, details, *l}, nil
+	case "tcp":
+		useTLS, err := strconv.ParseBool(l.option("TLS"))
+		if err != nil {
+			return info, api.Errorf(api.InvalidOption, "%s is not a valid TLS value; use true or false", l.option("TLS"))
+		}
+		s, err := tcp.New(l.option("Interface"), port, l.option("Mode"), l.option("Address"), useTLS, l.option("PSK"))
+		if err != nil {
+			return info, err
+		}
+		if err = s.Run(); err != nil {
+			return info, api.Wrap(api.TransportFailure, err)
+		}
+		agents.RegisterKeys(s.ID, s)
+		details := s.Mode
+		if s.Mode == "bind" {
+			details = "bind to " + s.Address
+		}
+		if s.TLS {
+			details += " with TLS"
+		}
+		return listenerInfo{s.ID, s.Protocol, s.Interface, s.Port, details, *l}, nil
+	case "ws":
+		useTLS, err := strconv.ParseBool(l.option("TLS"))
+		if err != nil {
+			return info, api.Errorf(api.InvalidOption, "%s is not a valid TLS value; use true or false", l.option("TLS"))
+		}
+		protocol := "ws"
+		if useTLS {
+			protocol = "wss"
+		}
+		s, err := ws.New(l.option("Interface"), port, protocol, l.option("Path"), l.option("Subprotocol"),
+			l.option("Origin"), l.option("Certificate"), l.option("Key"), l.option("PSK"))
+		if err != nil {
+			return info, err
+		}
+		if err = s.Run(); err != nil {
+			return info, api.Wrap(api.TransportFailure, err)
+		}
+		agents.RegisterKeys(s.ID, s)
+		details := "path " + s.Path
+		if s.Subprotocol != "" {
+			details += ", subprotocol " + s.Subprotocol
+		}
+		if s.Origin != "" {
+			details += ", origin " + s.Origin
+		}
+		return listenerInfo{s.ID, s.Protocol, s.Interface, s.Port, details, *l}, nil
+	}
+	return info, api.Errorf(api.InvalidOption, "%s is not a valid listener type", l.Protocol)
+}
+
+// listenerInUse returns the listener started from the listeners menu that already uses the interface and port, or the
+// path for a Unix domain socket, of a new listener. TCP listeners in bind mode connect to an agent and do not use one.
+func listenerInUse(protocol string, iface string, port int) (listenerInfo, bool) {
+	listeners.Lock()
+	defer listeners.Unlock()
+	for _, s := range listeners.servers {
+		if (s.Protocol == "unix") != (protocol == "unix") || s.config.option("Mode") == "bind" {
+			continue
+		}
+		if s.Interface == iface && s.Port == port {
+			return s, true
+		}
+	}
+	return listenerInfo{}, false
+}
+
+// showOptions displays the listener's configurable options
+func (l *listenerConfig) showOptions() {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Name", "Value", "Description"})
+	table.SetBorder(false)
+	for _, o := range l.Options {
+		table.Append([]string{o.Name, o.Value, o.Description})
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
+
+// getOptionsList returns the listener's configurable options; used with tab completion
+func (l *listenerConfig) getOptionsList() func(string) []string {
+	return func(line string) []string {
+		o := make([]string, 0)
+		for _, v := range l.Options {
+			o = append(o, v.Name)
+		}
+		return o
+	}
+}
+
+// getListenerIDs returns the IDs of the listeners started from the listeners menu; used with tab completion
+func getListenerIDs() func(string) []string {
+	return func(line string) []string {
+		listeners.Lock()
+		defer listeners.Unlock()
+		var ids []string
+		for _, s := range listeners.servers {
+			ids = append(ids, s.ID.String())
+		}
+		return ids
+	}
+}
+
+// stagerItems returns the tab completion items for the listeners menu stager command
+func stagerItems() []readline.PrefixCompleterInterface {
+	listenerIDs := func(line string) []string { return append(getListenerIDs()(line), "main") }
+	items := []readline.PrefixCompleterInterface{
+		readline.PcItem("copy", readline.PcItemDynamic(listenerIDs)),
+		readline.PcItem("list"),
+		readline.PcItem("remove", readline.PcItemDynamic(listenerIDs)),
+	}
+	for _, t := range stager.GetTypes() {
+		items = append(items, readline.PcItem(t, readline.PcItemDynamic(listenerIDs)))
+	}
+	return items
+}
+
+func menuSetListeners() {
+	prompt.Config.AutoComplete = getCompleter("listeners")
+	setPrompt("\033[31mMerlin[\033[32mlisteners\033[31m]»\033[0m ")
+	shellMenuContext = "listeners"
+}
+
+// listenerCompleters returns the tab completers for the listeners menu and the listener menu
+func listenerCompleters() (*readline.PrefixCompleter, *readline.PrefixCompleter) {
+	var listenersMenu = readline.NewPrefixCompleter(
+		readline.PcItem("back"),
+		readline.PcItem("certs",
+			readline.PcItem("issue"),
+			readline.PcItem("list"),
+			readline.PcItem("revoke", readline.PcItemDynamic(agentCertNames())),
+		),
+		readline.PcItem("clone",
+			readline.PcItemDynamic(func(line string) []string { return append(getListenerIDs()(line), listenerTemplates()...) }),
+		),
+		readline.PcItem("generate",
+			readline.PcItemDynamic(getListenerIDs(),
+				readline.PcItem("-f", formatItems()...),
+			),
+		),
+		readline.PcItem("edge",
+			readline.PcItem("enroll"),
+			readline.PcItem("list"),
+			readline.PcItem("revoke", readline.PcItemDynamic(edgeNodeNames())),
+		),
+		readline.PcItem("help"),
+		readline.PcItem("killswitch",
+			readline.PcItemDynamic(func(line string) []string { return append(getListenerIDs()(line), "main") }),
+		),
+		readline.PcItem("list"),
+		readline.PcItem("main"),
+		readline.PcItem("requests",
+			readline.PcItemDynamic(func(line string) []string { return append(getListenerIDs()(line), "main") },
+				readline.PcItem("anomalies"),
+			),
+		),
+		readline.PcItem("set",
+			readline.PcItemDynamic(func(line string) []string { return append(getListenerIDs()(line), "main") },
+				readline.PcItem("PSK",
+					readline.PcItem("rotate"),
+				),
+			),
+		),
+		readline.PcItem("stager", stagerItems()...),
+		readline.PcItem("templates",
+			readline.PcItem("remove",
+				readline.PcItemDynamic(func(string) []string { return listenerTemplates() }),
+			),
+		),
+		readline.PcItem("use",
+			readline.PcItemDynamic(func(string) []string { return append(GetListenerTypes(), listenerTemplates()...) }),
+		),
+	)
+	var listenerMenu = readline.NewPrefixCompleter(
+		readline.PcItem("back"),
+		readline.PcItem("generate",
+			readline.PcItem("-f", formatItems()...),
+		),
+		readline.PcItem("help"),
+		readline.PcItem("info"),
+		readline.PcItem("main"),
+		readline.PcItem("save",
+			readline.PcItem("template"),
+		),
+		readline.PcItem("set",
+			readline.PcItemDynamic(shellListener.getOptionsList()),
+		),
+		readline.PcItem("show",
+			readline.PcItem("options"),
+		),
+		readline.PcItem("start"),
+	)
+	return listenersMenu, listenerMenu
+}
+
+// The help menu while in the listeners menu
+func menuHelpListeners() {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetBorder(false)
+	table.SetCaption(true, "Listeners Menu Help")
+	table.SetHeader([]string{"Command", "Description", "Options"})
+
+	data := [][]string{
+		{"back", "Return to the main menu", ""},
+		{"certs issue", "Issue a client certificate for an agent built outside the generate menu; the generate menu's ClientCert option issues and embeds one", "<name>"},
+		{"certs list", "List the issued agent client certificates with the agents that checked in with them", ""},
+		{"certs revoke", "Stop listeners that require client certificates from accepting an agent's certificate, by its name or the agent's ID", "<name|agent ID>"},
+		{"clone", "Save a listener started from this menu, or a template, as a new template and configure a listener with it", "<listener ID|template> <new template>"},
+		{"generate", "Build an agent pre-configured to connect to a listener started from this menu", "<listener ID> [-f exe|dll|shellcode]"},
+		{"edge enroll", "Create the credentials for a new edge node that relays agents to an edge listener; copy them to the node for merlinedge -creds", "<name>"},
+		{"edge list", "List the enrolled edge nodes with their last address and relayed requests", ""},
+		{"edge revoke", "Stop accepting an edge node's connections and relayed requests", "<name>"},
+		{"killswitch", "Task every agent that checked in through the listener to remove its persistence and files and exit; -y skips the confirmation", "<listener ID|main> [-y]"},
+		{"list", "List the listeners started from this menu", ""},
+		{"main", "Return to the main menu", ""},
+		{"requests", "List the recent requests an HTTP listener received with the agent they matched and flagged anomalies such as scanners and TLS certificate rejections", "<listener ID|main> [anomalies] [<count>]"},
+		{"set PSK", "Replace a running listener's PSK with a key or a random one and task the agents that check in through it to re-key; the previous PSK is accepted for the grace period (default 1h)", "<listener ID|main> PSK <key|rotate> [<grace period>]"},
+		{"stager", "Host a stager and the agent it downloads on the main or a ws listener and print its one-liner",
+			"<" + strings.Join(stager.GetTypes(), "|") + "> <listener ID|main> <agent file> [<uri>]"},
+		{"stager copy", "Copy a hosted stager's one-liner, or an agent's URL, to the clipboard", "<listener ID|main> <uri>"},
+		{"stager list", "List the hosted stagers and agents with their download counts", ""},
+		{"stager remove", "Stop hosting a stager or agent", "<listener ID|main> <uri>"},
+		{"templates", "List the saved listener templates or remove one", "[remove <template>]"},
+		{"use", "Configure a new listener, or one with a template's options", strings.Join(GetListenerTypes(), ", ") + ", or <template>"},
+	}
+
+	table.AppendBulk(data)
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
+
+// The help menu while configuring a listener
+func menuHelpListener() {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetBorder(false)
+	table.SetCaption(true, "Listener Menu Help")
+	table.SetHeader([]string{"Command", "Description", "Options"})
+
+	data := [][]string{
+		{"back", "Return to the listeners menu", ""},
+		{"generate", "Build an agent pre-configured to connect to this listener", "[-f exe|dll|shellcode]"},
+		{"info", "Show the listener's options", ""},
+		{"main", "Return to the main menu", ""},
+		{"save template", "Save the listener's options, including its PSK, as a template to configure listeners like it with 'use <template>'", "<name>"},
+		{"set", "Set the value for one of the listener's options", "<option name> <option value>"},
+		{"show", "Show the listener's options", "options"},
+		{"start", "Start the listener", ""},
+	}
+
+	table.AppendBulk(data)
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
@@ -0,0 +1,138 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/loot"
+)
+
+// menuLoot lists the files in the agents' directories or views one as text or a paged hex dump
+func menuLoot(cmd []string) {
+	if len(cmd) == 0 {
+		message("warn", "Invalid 'loot' command; use list [<agent>], view <id> [<page>], copy-path <id>, or gallery [<agent>]")
+		return
+	}
+	switch cmd[0] {
+	case "list":
+		var agentID uuid.UUID
+		if len(cmd) > 1 {
+			id, err := uuid.FromString(cmd[1])
+			if err != nil {
+				message("warn", fmt.Sprintf("%s is not a valid agent ID", cmd[1]))
+				return
+			}
+			agentID = id
+		}
+		list, err := loot.List(agentID)
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		if len(list) == 0 {
+			message("info", "There are no loot files")
+			return
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ID", "Agent", "File", "Size", "Modified"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, f := range list {
+			table.Append([]string{f.ID, f.Agent.String(), f.Name, strconv.FormatInt(f.Size, 10),
+				f.Modified.UTC().Format(time.RFC3339)})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case "copy-path":
+		if len(cmd) < 2 {
+			message("warn", "Invalid 'loot copy-path' command; use loot copy-path <id>")
+			return
+		}
+		f, err := loot.Find(cmd[1])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		copyText(f.Path, f.Path)
+	case "view":
+		if len(cmd) < 2 {
+			message("warn", "Invalid 'loot view' command; use loot view <id> [<page>]")
+			return
+		}
+		page := 1
+		if len(cmd) > 2 {
+			p, err := strconv.Atoi(cmd[2])
+			if err != nil {
+				message("warn", fmt.Sprintf("%s is not a valid page number", cmd[2]))
+				return
+			}
+			page = p
+		}
+		f, err := loot.Find(cmd[1])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		view, pages, err := loot.View(f, page)
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("info", fmt.Sprintf("%s from agent %s, %d bytes, page %d of %d", f.Name, f.Agent, f.Size, page, pages))
+		fmt.Println()
+		fmt.Println(view)
+		if page < pages {
+			message("note", fmt.Sprintf("Use 'loot view %s %d' for the next page", f.ID, page+1))
+		}
+	case "gallery":
+		var agentID uuid.UUID
+		if len(cmd) > 1 {
+			id, err := uuid.FromString(cmd[1])
+			if err != nil {
+				message("warn", fmt.Sprintf("%s is not a valid agent ID", cmd[1]))
+				return
+			}
+			agentID = id
+		}
+		lootGallery(agentID)
+	default:
+		message("warn", fmt.Sprintf("Invalid 'loot' command: %s", cmd[0]))
+	}
+}
+
+// lootItems returns the IDs of the loot files for tab completion
+func lootItems() func(string) []string {
+	return func(line string) []string {
+		var ids []string
+		list, _ := loot.List(uuid.Nil)
+		for _, f := range list {
+			ids = append(ids, f.ID)
+		}
+		return ids
+	}
+}
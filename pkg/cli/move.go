@@ -0,0 +1,121 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/mattn/go-shellwords"
+	"github.com/olekukonko/tablewriter"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+)
+
+// moveUsage is the syntax of the move command
+const moveUsage = "move <psexec|wmiexec|winrm|ssh> <host> <credential ID> <local_payload_file> [<remote_path>], " +
+	"move <psexec|wmiexec|winrm|ssh> <host> <credential ID> -c \"<command>\", move list"
+
+// menuMove creates a job for the agent the agent menu is interacting with to copy a payload to a remote host and start
+// it, or to run a command such as a stager one-liner on it, with a password from the credential store. The server
+// reports the new agent's ID when an agent checks in from the remote host.
+func menuMove(cmd []string) {
+	argS, err := shellwords.Parse(strings.Join(cmd, " "))
+	if err != nil {
+		message("warn", fmt.Sprintf("There was an error parsing command line arguments:\r\n%s", err.Error()))
+		return
+	}
+	if len(argS) == 1 && argS[0] == "list" {
+		listMovements()
+		return
+	}
+	if len(argS) < 4 || len(argS) > 5 {
+		message("warn", "Invalid command")
+		message("info", moveUsage)
+		return
+	}
+	args := []string{argS[0], argS[1], argS[2]}
+	if argS[3] == "-c" {
+		if len(argS) != 5 {
+			message("warn", "The -c option requires a command")
+			return
+		}
+		args = append(args, "command", argS[4])
+	} else {
+		if _, err = os.Stat(argS[3]); err != nil {
+			message("warn", fmt.Sprintf("There was an error accessing the payload:\r\n%s", err.Error()))
+			return
+		}
+		// An empty remote path lets the agent choose a random file name in a temporary directory
+		path := ""
+		if len(argS) == 5 {
+			path = argS[4]
+		}
+		args = append(args, "file", argS[3], path)
+	}
+	if _, err = agents.ParseMove(shellAgent, args); err != nil {
+		message("warn", err.Error())
+		return
+	}
+	m, err := addJob(shellAgent, "move", args)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+		m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+}
+
+// listMovements displays the move jobs sent to agents and the agents that checked in from their remote hosts
+func listMovements() {
+	list := agents.GetMovements()
+	if len(list) == 0 {
+		message("info", "No move jobs have been sent to agents")
+		return
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Job", "Agent", "Method", "Host", "User", "Sent", "Status", "New Agent"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, m := range list {
+		newAgent := ""
+		if m.Status == "completed" {
+			newAgent = m.NewAgent.String()
+		}
+		table.Append([]string{m.Job, m.Agent.String(), m.Method, m.Host, m.User, m.Sent.Format(time.RFC3339), m.Status,
+			newAgent})
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
+
+// moveMethods returns the lateral movement methods for the command line completer
+func moveMethods(line string) []string {
+	methods := []string{"list"}
+	for method := range agents.MoveMethods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
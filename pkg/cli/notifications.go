@@ -0,0 +1,127 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	// 3rd Party
+	"github.com/chzyer/readline"
+	"github.com/olekukonko/tablewriter"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/webhook"
+)
+
+// notificationsUsage is the syntax of the notifications command
+const notificationsUsage = "notifications list, add <name> <url> <slack|discord|generic> <event>[,<event>...] [<keyword> ...], remove <name>, test <name>"
+
+// menuNotifications lists, adds, removes, and tests the webhooks sent notifications of new agents, dead agents, and
+// job output that contains a keyword
+func menuNotifications(cmd []string) {
+	if len(cmd) < 1 {
+		cmd = []string{"list"}
+	}
+	switch cmd[0] {
+	case "list":
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Name", "URL", "Format", "Events", "Keywords", "Signed"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, w := range webhook.Webhooks() {
+			signed := "no"
+			if w.Secret != "" {
+				signed = "yes"
+			}
+			table.Append([]string{w.Name, webhookHost(w.URL), w.Format, strings.Join(w.Events, ","), strings.Join(w.Keywords, ","), signed})
+		}
+		table.SetCaption(true, fmt.Sprintf("Webhooks are kept in %s", webhook.File))
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case "add":
+		if len(cmd) < 5 {
+			message("warn", "Invalid 'notifications add' command")
+			message("info", notificationsUsage)
+			return
+		}
+		w := webhook.Webhook{Name: cmd[1], URL: cmd[2], Format: cmd[3], Events: strings.Split(cmd[4], ","), Keywords: cmd[5:]}
+		if err := webhook.Add(w); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Added the %s webhook for %s", w.Name, strings.Join(w.Events, ", ")))
+		logging.Server(fmt.Sprintf("Webhook %s was added%s", w.Name, by()))
+	case "remove":
+		if len(cmd) != 2 {
+			message("warn", "Invalid 'notifications remove' command")
+			message("info", notificationsUsage)
+			return
+		}
+		if err := webhook.Remove(cmd[1]); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Removed the %s webhook", cmd[1]))
+		logging.Server(fmt.Sprintf("Webhook %s was removed%s", cmd[1], by()))
+	case "test":
+		if len(cmd) != 2 {
+			message("warn", "Invalid 'notifications test' command")
+			message("info", notificationsUsage)
+			return
+		}
+		if err := webhook.Test(cmd[1]); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("The %s webhook accepted the test notification", cmd[1]))
+	default:
+		message("warn", "Invalid 'notifications' command")
+		message("info", notificationsUsage)
+	}
+}
+
+// webhookHost returns the scheme and host of a webhook URL because its path is the credential for Slack and Discord
+func webhookHost(u string) string {
+	p, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s://%s/...", p.Scheme, p.Host)
+}
+
+// notificationsItems completes the notifications command's webhook names, formats, and events
+func notificationsItems() []readline.PrefixCompleterInterface {
+	names := func(string) []string {
+		var n []string
+		for _, w := range webhook.Webhooks() {
+			n = append(n, w.Name)
+		}
+		return n
+	}
+	return []readline.PrefixCompleterInterface{
+		readline.PcItem("add"),
+		readline.PcItem("list"),
+		readline.PcItem("remove", readline.PcItemDynamic(names)),
+		readline.PcItem("test", readline.PcItemDynamic(names)),
+	}
+}
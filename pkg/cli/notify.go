@@ -0,0 +1,168 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	// 3rd Party
+	"github.com/chzyer/readline"
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/events"
+	"github.com/Ne0nd0g/merlin/pkg/operator"
+)
+
+// notifyUsage is the syntax of the notify command
+const notifyUsage = "notify, notify set <event> <on|off>"
+
+// notifyLabels are the headings of the notifications for each event
+var notifyLabels = map[string]string{
+	events.NewAgent: "New agent",
+	events.Job:      "Job completed",
+	events.Dead:     "Agent dead",
+	events.Status:   "Agent status",
+	events.CheckIn:  "Check in",
+	events.Listener: "Listener",
+}
+
+// notifier is a console that is shown notifications and the events it is shown them for
+type notifier struct {
+	out      io.Writer
+	settings map[string]bool
+}
+
+// notifiers are the consoles shown notifications keyed by their team server session; the server's console has an
+// empty session
+var notifiers = struct {
+	sync.Mutex
+	m map[string]notifier
+}{m: make(map[string]notifier)}
+
+// notifications are the events waiting to be shown; events are dropped when the consoles fall behind so publishing an
+// event never waits for a console
+var notifications = make(chan events.Event, 100)
+
+// notifyStart subscribes to the server's events once
+var notifyStart sync.Once
+
+// addNotifier shows the console notifications for the events the operator's profile turns on. The first console to
+// be added starts the notifications.
+func addNotifier(session string, out io.Writer, p *operator.Profile) {
+	notifyStart.Do(func() {
+		events.Subscribe(func(e events.Event) {
+			select {
+			case notifications <- e:
+			default:
+			}
+		})
+		go func() {
+			for e := range notifications {
+				notify(e)
+			}
+		}()
+	})
+	settings := make(map[string]bool)
+	for _, e := range operator.NotifyEvents() {
+		settings[e] = p.Notify(e)
+	}
+	notifiers.Lock()
+	notifiers.m[session] = notifier{out: out, settings: settings}
+	notifiers.Unlock()
+}
+
+// removeNotifier stops showing the console notifications
+func removeNotifier(session string) {
+	notifiers.Lock()
+	delete(notifiers.m, session)
+	notifiers.Unlock()
+}
+
+// notify writes a highlighted notification for the event to every console that is shown notifications for it. The
+// notification is written above the prompt of whichever menu the operator is in.
+func notify(e events.Event) {
+	text := fmt.Sprintf("[*]%s %s", e.Time.Local().Format("15:04:05"), notifyLabels[e.Type])
+	if e.Agent != "" {
+		text += fmt.Sprintf(" (agent %s)", e.Agent)
+	}
+	text += ": " + e.Message
+	if !color.NoColor {
+		text = "\033[1;30;43m" + text + "\033[0m"
+	}
+	notifiers.Lock()
+	defer notifiers.Unlock()
+	for _, n := range notifiers.m {
+		if n.settings[e.Type] {
+			_, _ = fmt.Fprintln(n.out, text)
+		}
+	}
+}
+
+// menuNotify lists the events the operator is notified about or turns the notifications for an event on or off
+func menuNotify(cmd []string) {
+	if len(cmd) == 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Event", "Notify", "Default"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.SetCaption(true, fmt.Sprintf("Notifications for operator %s", operatorProfile.Name))
+		onOff := map[bool]string{true: "on", false: "off"}
+		for _, e := range operator.NotifyEvents() {
+			table.Append([]string{e, onOff[operatorProfile.Notify(e)], onOff[operator.Notifiable[e]]})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+		return
+	}
+	if len(cmd) != 3 || cmd[0] != "set" || (cmd[2] != "on" && cmd[2] != "off") {
+		message("warn", "Invalid 'notify' command")
+		message("info", notifyUsage)
+		return
+	}
+	on := cmd[2] == "on"
+	if err := operatorProfile.SetNotify(cmd[1], on); err != nil {
+		message("warn", err.Error())
+		return
+	}
+	if err := operatorProfile.Save(); err != nil {
+		message("warn", err.Error())
+		return
+	}
+	notifiers.Lock()
+	if n, ok := notifiers.m[shellSession]; ok {
+		n.settings[cmd[1]] = on
+	}
+	notifiers.Unlock()
+	message("success", fmt.Sprintf("Turned %s notifications %s", cmd[1], strings.ToLower(cmd[2])))
+}
+
+// notifyItems returns the tab completion items for the notify command's events and settings
+func notifyItems() []readline.PrefixCompleterInterface {
+	var items []readline.PrefixCompleterInterface
+	for _, e := range operator.NotifyEvents() {
+		items = append(items, readline.PcItem(e, readline.PcItem("on"), readline.PcItem("off")))
+	}
+	return []readline.PrefixCompleterInterface{readline.PcItem("set", items...)}
+}
@@ -0,0 +1,157 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/mattn/go-shellwords"
+	"github.com/olekukonko/tablewriter"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+)
+
+// persistUsage is the syntax of the persist command
+const persistUsage = "persist add <schtask|runkey|systemd|launchd|cron> <name> [<command>], persist list, " +
+	"persist remove <name|all>"
+
+// menuPersist adds persistence to the host of the agent the agent menu is interacting with, lists the persistence the
+// server recorded as installed, or removes it. Without a command, add persists the agent's own command line.
+func menuPersist(cmd []string) {
+	argS, err := shellwords.Parse(strings.Join(cmd, " "))
+	if err != nil {
+		message("warn", fmt.Sprintf("There was an error parsing command line arguments:\r\n%s", err.Error()))
+		return
+	}
+	if len(argS) < 1 {
+		message("warn", "Invalid command")
+		message("info", persistUsage)
+		return
+	}
+	switch argS[0] {
+	case "add":
+		if len(argS) < 3 {
+			message("warn", "Invalid command")
+			message("info", persistUsage)
+			return
+		}
+		// Keep the command as a single argument so the server does not split it again
+		args := []string{"add", argS[1], argS[2]}
+		if len(argS) > 3 {
+			args = append(args, strings.Join(argS[3:], " "))
+		}
+		addPersistJob(args)
+	case "list":
+		installed, err := agents.InstalledPersistence(shellAgent)
+		if err != nil {
+			message("warn", fmt.Sprintf("There was an error reading the agent's persistence:\r\n%s", err.Error()))
+			return
+		}
+		if len(installed) == 0 {
+			message("info", "The server has not recorded any persistence installed by this agent")
+			return
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Name", "Method", "Command", "Job", "Installed"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, i := range installed {
+			command := i.Command
+			if command == "" {
+				command = "(agent)"
+			}
+			table.Append([]string{i.Name, i.Method, command, i.Job, i.Installed.Format(time.RFC3339)})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case "remove":
+		if len(argS) != 2 {
+			message("warn", "Invalid command")
+			message("info", persistUsage)
+			return
+		}
+		if argS[1] != "all" {
+			addPersistJob([]string{"remove", argS[1]})
+			return
+		}
+		installed, err := agents.InstalledPersistence(shellAgent)
+		if err != nil {
+			message("warn", fmt.Sprintf("There was an error reading the agent's persistence:\r\n%s", err.Error()))
+			return
+		}
+		if len(installed) == 0 {
+			message("info", "The server has not recorded any persistence installed by this agent")
+		}
+		for _, i := range installed {
+			addPersistJob([]string{"remove", i.Name, i.Method})
+		}
+	default:
+		message("warn", fmt.Sprintf("%s is not a valid persist action", argS[0]))
+		message("info", persistUsage)
+	}
+}
+
+// addPersistJob checks the arguments of a persist job and creates it for the agent the agent menu is interacting with
+func addPersistJob(args []string) {
+	if _, err := agents.ParsePersist(shellAgent, args); err != nil {
+		message("warn", err.Error())
+		return
+	}
+	m, err := addJob(shellAgent, "persist", args)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+		m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+}
+
+// persistNames returns the names of the persistence recorded for the agent the agent menu is interacting with
+func persistNames() func(string) []string {
+	return func(line string) []string {
+		names := []string{"all"}
+		installed, _ := agents.InstalledPersistence(shellAgent)
+		for _, i := range installed {
+			names = append(names, i.Name)
+		}
+		return names
+	}
+}
+
+// persistMethods returns every persistence method across the agent platforms for the command line completer
+func persistMethods(line string) []string {
+	var methods []string
+	seen := make(map[string]bool)
+	for _, platform := range agents.PersistMethods {
+		for _, method := range platform {
+			if !seen[method] {
+				seen[method] = true
+				methods = append(methods, method)
+			}
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
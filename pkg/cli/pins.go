@@ -0,0 +1,86 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+)
+
+// pinUsage is the syntax of the pin command
+const pinUsage = "pin, pin rotate <pin|certificate_file> [<pin|certificate_file>...], pin clear"
+
+// menuPin lists the certificate public key pins the agent the agent menu is interacting with accepts from its
+// listeners, or creates a job to replace them. Before a listener's certificate is replaced with one that has a new key,
+// rotate the agent to a set that holds the pins of both the current and the new certificate so the agent accepts the
+// listener before and after the change; rotate again to drop the old pin once it is done.
+func menuPin(cmd []string) {
+	if len(cmd) == 0 {
+		a, ok := agents.Agents[shellAgent]
+		if !ok || len(a.Pins) == 0 {
+			message("info", fmt.Sprintf("Agent %s does not pin its listener's certificate", shellAgent))
+			return
+		}
+		message("info", fmt.Sprintf("Agent %s accepts listener certificates with the public key pins:", shellAgent))
+		for i, p := range a.Pins {
+			fmt.Printf("\t%d. %s\r\n", i+1, p)
+		}
+		return
+	}
+	var pins []string
+	switch cmd[0] {
+	case "rotate":
+		if len(cmd) < 2 {
+			message("warn", "Invalid 'pin' command")
+			message("info", pinUsage)
+			return
+		}
+		var err error
+		pins, err = agents.ParsePins(cmd[1:])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+	case "clear":
+		if len(cmd) != 1 {
+			message("warn", "Invalid 'pin' command")
+			message("info", pinUsage)
+			return
+		}
+	default:
+		message("warn", fmt.Sprintf("%s is not a valid 'pin' command", cmd[0]))
+		message("info", pinUsage)
+		return
+	}
+	m, err := addJob(shellAgent, "pins", []string{"pins", strings.Join(pins, ",")})
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+		m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+	if len(pins) > 0 {
+		message("info", "The agent only accepts listeners whose certificate matches one of these pins; include the "+
+			"current certificate's pin until every listener the agent uses presents the new certificate")
+	}
+}
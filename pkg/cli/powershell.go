@@ -0,0 +1,96 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/mattn/go-shellwords"
+)
+
+// powerShellUsage is the syntax of the powershell and powerpick commands
+var powerShellUsage = map[string]string{
+	"powershell": "powershell [-amsi] [-etw] <local_script_file> OR powershell [-amsi] [-etw] -c \"<command>\"",
+	"powerpick":  "powerpick [-ppid <pid>] <local_script_file> OR powerpick [-ppid <pid>] -c \"<command>\"",
+}
+
+// menuPowerShell creates a powershell or powerpick job for the agent the agent menu is interacting with. The script is
+// either a file on the server, read when the job is sent, or the inline command that is the value of the -c flag; an
+// inline command with spaces must be quoted like it would be in a shell.
+func menuPowerShell(cmd []string) {
+	usage := powerShellUsage[cmd[0]]
+	argS, err := shellwords.Parse(strings.Join(cmd[1:], " "))
+	if err != nil {
+		message("warn", fmt.Sprintf("There was an error parsing command line arguments:\r\n%s", err.Error()))
+		return
+	}
+	amsi, etw, ppid := "false", "false", "0"
+	for len(argS) > 0 && strings.HasPrefix(argS[0], "-") && argS[0] != "-c" {
+		switch {
+		case argS[0] == "-amsi" && cmd[0] == "powershell":
+			amsi = "true"
+		case argS[0] == "-etw" && cmd[0] == "powershell":
+			etw = "true"
+		case argS[0] == "-ppid" && cmd[0] == "powerpick":
+			if len(argS) < 2 {
+				message("warn", "The -ppid option requires a process ID")
+				return
+			}
+			if _, errP := strconv.ParseUint(argS[1], 10, 32); errP != nil {
+				message("warn", fmt.Sprintf("%s is not a valid process ID", argS[1]))
+				return
+			}
+			ppid = argS[1]
+			argS = argS[1:]
+		default:
+			message("warn", fmt.Sprintf("%s is not a valid %s option", argS[0], cmd[0]))
+			message("info", usage)
+			return
+		}
+		argS = argS[1:]
+	}
+	if len(argS) != 1 && (len(argS) != 2 || argS[0] != "-c") {
+		message("warn", "Invalid command")
+		message("info", usage)
+		return
+	}
+	script := []string{"file", argS[0]}
+	if argS[0] == "-c" {
+		script = []string{"inline", argS[1]}
+	} else if _, err = os.Stat(argS[0]); err != nil {
+		message("warn", fmt.Sprintf("There was an error accessing the script file:\r\n%s", err.Error()))
+		return
+	}
+	args := append([]string{amsi, etw}, script...)
+	if cmd[0] == "powerpick" {
+		args = append([]string{ppid}, script...)
+	}
+	m, err := addJob(shellAgent, cmd[0], args)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+		m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+}
@@ -0,0 +1,106 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+)
+
+// pskUsage is the syntax of the listeners menu command that replaces a listener's pre-shared key
+const pskUsage = "set <listener ID|main> PSK <key|rotate> [<grace period>]"
+
+// pskGrace is how long a listener accepts its previous pre-shared keys when a grace period is not given
+const pskGrace = time.Hour
+
+// menuListenerPSK replaces a running listener's pre-shared key with a given or random key and tasks the agents that
+// check in through it to re-key. The listener accepts its previous keys until the grace period ends.
+func menuListenerPSK(cmd []string) {
+	if len(cmd) < 3 || len(cmd) > 4 || !strings.EqualFold(cmd[1], "PSK") {
+		message("warn", "Invalid 'set' command")
+		message("info", pskUsage)
+		return
+	}
+	id, err := stagerListener(cmd[0])
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	psk := cmd[2]
+	if strings.EqualFold(psk, "rotate") {
+		psk = ""
+	}
+	grace := pskGrace
+	if len(cmd) == 4 {
+		grace, err = time.ParseDuration(cmd[3])
+		if err != nil || grace < 0 {
+			message("warn", fmt.Sprintf("%s is not a valid grace period (i.e. 30m)", cmd[3]))
+			return
+		}
+	}
+
+	r, err := agents.RotatePSK(id, psk, grace)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	// Agents generated for the listener from now on use its new key
+	listeners.Lock()
+	for i, s := range listeners.servers {
+		if s.ID != id {
+			continue
+		}
+		for j, o := range s.config.Options {
+			if o.Name == "PSK" {
+				listeners.servers[i].config.Options[j].Value = r.PSK
+			}
+		}
+	}
+	listeners.Unlock()
+	if id == serverID {
+		SetPSK(r.PSK)
+	}
+
+	m := fmt.Sprintf("Replaced the PSK of listener %s%s and tasked %d agents with it", stagerListenerName(id), by(), len(r.Agents))
+	message("success", m)
+	logging.Server(m)
+	if psk == "" {
+		message("info", fmt.Sprintf("The new PSK is %s", r.PSK))
+	}
+	if grace > 0 {
+		message("info", fmt.Sprintf("The listener accepts its previous PSK until %s", r.Expires.Local().Format(time.RFC3339)))
+	}
+}
+
+// pskDetails describes the previous pre-shared keys a listener still accepts for the listeners list
+func pskDetails(id uuid.UUID) string {
+	count, expires := agents.GracePeriod(id)
+	if count == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", %d previous PSKs until %s", count, expires.Local().Format("15:04:05"))
+}
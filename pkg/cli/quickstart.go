@@ -0,0 +1,107 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+
+	// 3rd Party
+	"github.com/fatih/color"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// menuQuickstart starts an HTTPS listener for lab testing on a random high port with an in-memory self-signed
+// certificate and a generated pre-shared key, then prints the commands to build and run a matching agent
+func menuQuickstart(cmd []string) {
+	iface := "127.0.0.1"
+	if len(cmd) > 0 {
+		if net.ParseIP(cmd[0]) == nil {
+			message("warn", fmt.Sprintf("%s is not a valid IP address; use quickstart [interface]", cmd[0]))
+			return
+		}
+		iface = cmd[0]
+	}
+	port, err := quickstartPort(iface)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	psk := make([]byte, 16)
+	if _, err = rand.Read(psk); err != nil {
+		message("warn", fmt.Sprintf("there was an error generating the pre-shared key:\r\n%s", err.Error()))
+		return
+	}
+
+	// An empty certificate file does not exist, so the listener creates an in-memory certificate
+	l := listenerConfig{Protocol: "http", Options: listenerOptions("http")}
+	for i, o := range l.Options {
+		switch o.Name {
+		case "Interface":
+			l.Options[i].Value = iface
+		case "Port":
+			l.Options[i].Value = strconv.Itoa(port)
+		case "Certificate", "Key":
+			l.Options[i].Value = ""
+		case "PSK":
+			l.Options[i].Value = hex.EncodeToString(psk)
+		}
+	}
+	s, err := l.start()
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	listeners.Lock()
+	listeners.servers = append(listeners.servers, s)
+	listeners.Unlock()
+	message("success", fmt.Sprintf("Started %s listener %s on %s", s.Protocol, s.ID.String(),
+		util.JoinHostPort(s.Interface, strconv.Itoa(s.Port))))
+
+	protocol, u := l.agentURL()
+	message("info", "Build a matching agent with:")
+	color.Yellow(fmt.Sprintf("\tmake agent-linux URL=%s PSK=%s PROTO=%s", u, l.option("PSK"), protocol))
+	message("info", "Or run one from the Merlin source directory with:")
+	color.Yellow(fmt.Sprintf("\tgo run cmd/merlinagent/main.go -url %s -psk %s -proto %s", u, l.option("PSK"), protocol))
+	message("note", fmt.Sprintf("Use 'listeners' then 'generate %s' to build an agent for another platform", s.ID.String()))
+}
+
+// quickstartPort returns a random high TCP port that is not in use on the interface
+func quickstartPort(iface string) (int, error) {
+	for i := 0; i < 10; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(65535-49152))
+		if err != nil {
+			return 0, fmt.Errorf("there was an error picking a random port:\r\n%s", err.Error())
+		}
+		port := 49152 + int(n.Int64())
+		ln, err := net.Listen("tcp", util.JoinHostPort(iface, strconv.Itoa(port)))
+		if err != nil {
+			continue
+		}
+		ln.Close()
+		return port, nil
+	}
+	return 0, fmt.Errorf("an unused port could not be found on %s", iface)
+}
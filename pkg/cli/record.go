@@ -0,0 +1,225 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	// 3rd Party
+	"github.com/chzyer/readline"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/recording"
+	"github.com/Ne0nd0g/merlin/pkg/redact"
+)
+
+// recordingsDir is the directory recordings are saved to when a file is not provided
+var recordingsDir = filepath.Join(core.CurrentDir, "data", "recordings")
+
+// session is the recording of the operator's terminal session, if one is being recorded
+var session struct {
+	recorder *recording.Recorder
+	stdout   *os.File      // The terminal's standard output, restored when the recording stops
+	pipe     *os.File      // Replaces standard output while recording so everything written to it is recorded
+	done     chan struct{} // Closed when everything written to the pipe was copied
+}
+
+// redactWriter masks the secrets in the output written to a recording
+type redactWriter struct {
+	w io.Writer
+}
+
+func (r redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, redact.String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// menuRecord starts or stops recording the operator's terminal session, or shows the recording's status
+func menuRecord(cmd []string) {
+	// The recording is of the server's terminal
+	if shellOperator != "" {
+		message("warn", "Sessions can only be recorded from the server's console")
+		return
+	}
+	if len(cmd) == 0 {
+		if session.recorder == nil {
+			message("info", "The session is not being recorded")
+			return
+		}
+		message("info", fmt.Sprintf("Recording the session to %s for %s", session.recorder.Name(),
+			session.recorder.Duration().Round(time.Second)))
+		return
+	}
+	switch cmd[0] {
+	case "start":
+		path := filepath.Join(recordingsDir, time.Now().UTC().Format("20060102T150405Z")+recording.Extension)
+		if len(cmd) > 1 {
+			path = cmd[1]
+		} else if err := os.MkdirAll(recordingsDir, 0700); err != nil {
+			message("warn", fmt.Sprintf("There was an error creating the recordings directory:\r\n%s", err.Error()))
+			return
+		}
+		if err := startRecording(path); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Recording the session to %s", path))
+	case "stop":
+		path, err := stopRecording()
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Saved the session recording to %s; play it with replay %s", path, path))
+	default:
+		message("warn", "Invalid 'record' command; use record [start [<file>]|stop]")
+	}
+}
+
+// startRecording records everything written to standard output, including the prompt and the operator's typing that
+// it echoes, and the command lines the operator enters, with their timing
+func startRecording(path string) error {
+	if session.recorder != nil {
+		return fmt.Errorf("the session is already being recorded to %s", session.recorder.Name())
+	}
+	width, height, err := readline.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	r, err := recording.Create(path, width, height, "Merlin")
+	if err != nil {
+		return fmt.Errorf("there was an error creating the recording:\r\n%s", err.Error())
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		_ = r.Close()
+		return fmt.Errorf("there was an error creating a pipe for the recording:\r\n%s", err.Error())
+	}
+	session.recorder, session.stdout, session.pipe, session.done = r, os.Stdout, writer, make(chan struct{})
+	go func(out io.Writer, done chan struct{}) {
+		_, _ = io.Copy(io.MultiWriter(out, redactWriter{r}), reader)
+		_ = reader.Close()
+		close(done)
+	}(os.Stdout, session.done)
+	setStdout(writer)
+	return nil
+}
+
+// stopRecording restores standard output, waits for the output already written to be recorded, and closes the
+// recording, returning its path
+func stopRecording() (string, error) {
+	if session.recorder == nil {
+		return "", fmt.Errorf("the session is not being recorded")
+	}
+	setStdout(session.stdout)
+	_ = session.pipe.Close()
+	<-session.done
+	path := session.recorder.Name()
+	err := session.recorder.Close()
+	session.recorder = nil
+	return path, err
+}
+
+// setStdout makes the prompt, colored messages, and everything else written to standard output use the file
+func setStdout(f *os.File) {
+	os.Stdout = f
+	if prompt != nil {
+		prompt.Config.Stdout = f
+		localOutput(prompt.Stdout())
+	} else {
+		localOutput(f)
+	}
+}
+
+// recordInput records a command line the operator entered, with its secrets masked, if the session is being recorded
+func recordInput(line string) {
+	if session.recorder != nil {
+		session.recorder.Input(redact.String(line))
+	}
+}
+
+// menuReplay plays back a session recording in the terminal; Ctrl-C stops it
+func menuReplay(cmd []string) {
+	// A replay is stopped with Ctrl-C in the server's terminal
+	if shellOperator != "" {
+		message("warn", "Recordings can only be replayed from the server's console")
+		return
+	}
+	if len(cmd) < 1 {
+		message("warn", "Invalid 'replay' command; use replay <file> [-speed <n>] [-idle <duration>]")
+		return
+	}
+	speed, idle := 1.0, 2*time.Second
+	for i := 1; i < len(cmd); i += 2 {
+		if i+1 >= len(cmd) {
+			message("warn", fmt.Sprintf("The %s option is missing its value", cmd[i]))
+			return
+		}
+		var err error
+		switch cmd[i] {
+		case "-speed":
+			speed, err = strconv.ParseFloat(cmd[i+1], 64)
+			if err == nil && speed <= 0 {
+				err = fmt.Errorf("the speed must be greater than zero")
+			}
+		case "-idle":
+			idle, err = time.ParseDuration(cmd[i+1])
+		default:
+			err = fmt.Errorf("%s is not a valid replay option", cmd[i])
+		}
+		if err != nil {
+			message("warn", fmt.Sprintf("Invalid %s value %s:\r\n%s", cmd[i], cmd[i+1], err.Error()))
+			return
+		}
+	}
+	f, err := os.Open(cmd[0]) // #nosec G304 the operator chose the file
+	if err != nil {
+		message("warn", fmt.Sprintf("There was an error opening the recording:\r\n%s", err.Error()))
+		return
+	}
+	header, events, err := recording.Read(f)
+	_ = f.Close()
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	recorded := time.Unix(header.Timestamp, 0).UTC().Format(time.RFC3339)
+	message("info", fmt.Sprintf("Replaying the %dx%d session recorded at %s; press Ctrl-C to stop",
+		header.Width, header.Height, recorded))
+	ctx, stop := interruptible()
+	defer stop()
+	err = recording.Play(ctx, events, os.Stdout, speed, idle)
+	fmt.Println()
+	switch {
+	case ctx.Err() != nil:
+		message("info", "Stopped replaying the recording")
+	case err != nil:
+		message("warn", fmt.Sprintf("There was an error replaying the recording:\r\n%s", err.Error()))
+	default:
+		message("info", "Finished replaying the recording")
+	}
+}
@@ -0,0 +1,73 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strings"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/redact"
+)
+
+// menuRedact lists, adds, or removes the regular expressions of secrets that are masked in the console, the command
+// history, and the logs
+func menuRedact(cmd []string) {
+	if len(cmd) == 0 {
+		cmd = []string{"list"}
+	}
+	switch cmd[0] {
+	case "list":
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Pattern"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.SetAutoWrapText(false)
+		for _, p := range redact.GetPatterns() {
+			table.Append([]string{p})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case "add", "remove":
+		if len(cmd) < 2 {
+			message("warn", fmt.Sprintf("Invalid 'redact %s' command; use redact %s <regex>", cmd[0], cmd[0]))
+			return
+		}
+		pattern := strings.Join(cmd[1:], " ")
+		if cmd[0] == "remove" {
+			if err := redact.Remove(pattern); err != nil {
+				message("warn", err.Error())
+				return
+			}
+			message("success", fmt.Sprintf("Removed redaction pattern %s", pattern))
+			return
+		}
+		if err := redact.Add(pattern); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Added redaction pattern %s; add it to data/redact.txt to keep it after a restart", pattern))
+	default:
+		message("warn", fmt.Sprintf("Invalid 'redact' command: %s", cmd[0]))
+	}
+}
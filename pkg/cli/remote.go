@@ -0,0 +1,435 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	// 3rd Party
+	"github.com/chzyer/readline"
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api/rpc"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/modules"
+	"github.com/Ne0nd0g/merlin/pkg/operator"
+	"github.com/Ne0nd0g/merlin/pkg/redact"
+)
+
+// shellOperator is the team server operator whose command line is being executed; it is empty for the server's console
+var shellOperator string
+
+// execute is held while a command line runs because the menus are kept in the package's global variables
+var execute sync.Mutex
+
+// remoteWriteTimeout is how long a write to a remote console can take before its connection is closed
+const remoteWriteTimeout = 10 * time.Second
+
+// consoles are the output writers of the operators' remote consoles that server and agent messages are copied to
+var consoles = struct {
+	sync.Mutex
+	m         map[string]io.Writer
+	broadcast *broadcast // Set when the first operator logs in; the execute mutex guards it
+}{m: make(map[string]io.Writer)}
+
+// console is the menu state of a remote operator's command line interface. It is swapped with the package's global
+// variables while one of the operator's command lines runs.
+type console struct {
+	prompt      *readline.Instance
+	menuContext string
+	agent       uuid.UUID
+	module      modules.Module
+	listener    listenerConfig
+	operator    string
+	session     string
+	profile     *operator.Profile
+}
+
+// swap exchanges the console's menu state with the package's global variables
+func (c *console) swap() {
+	prompt, c.prompt = c.prompt, prompt
+	shellMenuContext, c.menuContext = c.menuContext, shellMenuContext
+	shellAgent, c.agent = c.agent, shellAgent
+	shellModule, c.module = c.module, shellModule
+	shellListener, c.listener = c.listener, shellListener
+	shellOperator, c.operator = c.operator, shellOperator
+	shellSession, c.session = c.session, shellSession
+	operatorProfile, c.profile = c.profile, operatorProfile
+}
+
+// run executes a command line with the console's menu state and writes everything it prints to the operator
+func (c *console) run(line string, out io.Writer) {
+	c.call(func() { executeLine(line) }, out)
+}
+
+// call calls the function with the console's menu state and writes everything it prints to the operator
+func (c *console) call(f func(), out io.Writer) {
+	execute.Lock()
+	defer execute.Unlock()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		_, _ = fmt.Fprintf(out, "[!]There was an error running the command:\r\n%s\n", err.Error())
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		// Keep reading after a failed write so the command never blocks on a full pipe
+		if _, errC := io.Copy(out, r); errC != nil {
+			_, _ = io.Copy(ioutil.Discard, r)
+		}
+		close(done)
+	}()
+
+	stdout, output := os.Stdout, color.Output
+	os.Stdout, color.Output = w, w
+	c.swap()
+	f()
+	c.swap()
+	os.Stdout, color.Output = stdout, output
+
+	_ = w.Close()
+	<-done
+	_ = r.Close()
+}
+
+// executeLocal runs a command line from the server's console after expanding its alias. Only the server's console is
+// shown what it prints.
+func executeLocal(line string) {
+	callLocal(func() {
+		// Aliases are expanded before the line is dispatched; the history keeps the alias as it was typed
+		if expanded, ok := expandAlias(line); ok {
+			executeLine(expanded)
+		}
+	})
+}
+
+// callLocal calls the function with the server console's menu state. Only the server's console is shown what it prints.
+func callLocal(f func()) {
+	execute.Lock()
+	defer execute.Unlock()
+	if b := consoles.broadcast; b != nil {
+		color.Output = b.local
+		defer func() { color.Output = b }()
+	}
+	f()
+}
+
+// Remote runs the command line interface for an operator connected to the team server. The operator's menus are their
+// own while messages from the server and agents are shown to every operator.
+func Remote(session rpc.Session, conn net.Conn) {
+	t, err := newTerminal(conn)
+	if err != nil {
+		logging.Server(fmt.Sprintf("There was an error starting the console for operator %s:\r\n%s",
+			session.Operator, err.Error()))
+		return
+	}
+	defer t.Close() // #nosec G307
+
+	config := &readline.Config{
+		Prompt:              "\033[31mMerlin»\033[0m ",
+		AutoComplete:        getCompleter("main"),
+		InterruptPrompt:     "^C",
+		EOFPrompt:           "exit",
+		HistorySearchFold:   true,
+		FuncFilterInputRune: filterInput,
+		// Lines are saved to the history by the loop below after their secrets are redacted
+		DisableAutoSaveHistory: true,
+	}
+	t.configure(config)
+	p, err := readline.NewEx(config)
+	if err != nil {
+		logging.Server(fmt.Sprintf("There was an error starting the console for operator %s:\r\n%s",
+			session.Operator, err.Error()))
+		return
+	}
+	defer p.Close() // #nosec G307
+
+	// Copy the messages written by other goroutines, such as agent check ins, to every console
+	execute.Lock()
+	if consoles.broadcast == nil {
+		consoles.broadcast = &broadcast{local: color.Output}
+		color.Output = consoles.broadcast
+	}
+	execute.Unlock()
+	consoles.Lock()
+	consoles.m[session.ID] = p.Stdout()
+	consoles.Unlock()
+	defer func() {
+		consoles.Lock()
+		delete(consoles.m, session.ID)
+		consoles.Unlock()
+	}()
+
+	c := &console{prompt: p, menuContext: "main", operator: session.Operator, session: session.ID}
+	_, _ = fmt.Fprintf(p.Stdout(), "\033[32m[+]Logged in to the Merlin team server as %s\033[0m\n", session.Operator)
+	c.profile, err = operator.Load(session.Operator)
+	if err != nil {
+		_, _ = fmt.Fprintf(p.Stdout(), "\033[31m[!]%s\033[0m\n", err.Error())
+		c.profile = &operator.Profile{Name: session.Operator}
+	}
+	addNotifier(session.ID, p.Stdout(), c.profile)
+	defer removeNotifier(session.ID)
+	for {
+		line, err := p.Readline()
+		if err == readline.ErrInterrupt {
+			// ctrl-c in an interactive shell returns to the agent menu instead of disconnecting
+			if c.menuContext == "shell" {
+				c.call(menuShellExit, p.Stdout())
+				continue
+			}
+			if len(line) == 0 {
+				return
+			}
+			continue
+		} else if err != nil {
+			return
+		}
+		rpc.Seen(session.ID)
+		if strings.TrimSpace(line) != "" {
+			_ = p.SaveHistory(redact.String(line))
+		}
+
+		// Exiting a remote console disconnects the operator instead of stopping the server
+		cmd := strings.Fields(line)
+		if len(cmd) > 0 && (cmd[0] == "exit" || cmd[0] == "quit") {
+			return
+		}
+		c.run(line, p.Stdout())
+	}
+}
+
+// broadcast writes the server's messages to its own console and every operator's remote console
+type broadcast struct {
+	local io.Writer
+}
+
+// Write copies the message to each console
+func (b *broadcast) Write(p []byte) (int, error) {
+	consoles.Lock()
+	for _, w := range consoles.m {
+		_, _ = w.Write(p)
+	}
+	local := b.local
+	consoles.Unlock()
+	return local.Write(p)
+}
+
+// localOutput sets where the server's console writes colored messages without removing the copy of the messages
+// printed by other goroutines to the remote consoles. It is called with the server console's menu state.
+func localOutput(w io.Writer) {
+	if b := consoles.broadcast; b != nil {
+		consoles.Lock()
+		b.local = w
+		consoles.Unlock()
+	}
+	color.Output = w
+}
+
+// terminal is the server side of a remote console's terminal. It speaks readline's remote terminal protocol, which the
+// client serves with readline.RemoteCli, but never blocks once the operator's connection is gone.
+type terminal struct {
+	conn    net.Conn
+	input   *io.PipeReader
+	width   int32
+	tty     bool
+	write   sync.Mutex
+	changed func()
+}
+
+// newTerminal reads the operator's terminal type and width and starts reading their input from the connection
+func newTerminal(conn net.Conn) (*terminal, error) {
+	t := &terminal{conn: conn}
+	buf := bufio.NewReader(conn)
+	for _, expected := range []readline.MsgType{readline.T_ISTTY_REPORT, readline.T_WIDTH_REPORT} {
+		m, err := readline.ReadMessage(buf)
+		if err != nil {
+			return nil, err
+		}
+		if m.Type != expected || len(m.Data) < 2 {
+			return nil, errors.New("the client did not report its terminal")
+		}
+		if expected == readline.T_ISTTY_REPORT {
+			t.tty = binary.BigEndian.Uint16(m.Data) != 0
+		} else {
+			t.width = int32(binary.BigEndian.Uint16(m.Data))
+		}
+	}
+
+	r, w := io.Pipe()
+	t.input = r
+	go func() {
+		for {
+			m, err := readline.ReadMessage(buf)
+			if err != nil || m.Type == readline.T_EOF {
+				_ = w.CloseWithError(io.EOF)
+				return
+			}
+			switch m.Type {
+			case readline.T_DATA:
+				if _, err := w.Write(m.Data); err != nil {
+					return
+				}
+			case readline.T_WIDTH_REPORT:
+				if len(m.Data) >= 2 {
+					atomic.StoreInt32(&t.width, int32(binary.BigEndian.Uint16(m.Data)))
+					if t.changed != nil {
+						t.changed()
+					}
+				}
+			}
+		}
+	}()
+	return t, nil
+}
+
+// configure makes readline use the remote terminal instead of the server's
+func (t *terminal) configure(config *readline.Config) {
+	config.Stdin = t.input
+	config.Stdout = t
+	config.Stderr = t
+	config.FuncIsTerminal = func() bool { return t.tty }
+	config.FuncGetWidth = func() int { return int(atomic.LoadInt32(&t.width)) }
+	config.FuncMakeRaw = func() error { return t.send(readline.T_RAW, nil) }
+	config.FuncExitRaw = func() error { return t.send(readline.T_ERAW, nil) }
+	config.FuncOnWidthChanged = func(f func()) { t.changed = f }
+}
+
+// send writes a message to the operator and closes the connection if it can not be written in time
+func (t *terminal) send(kind readline.MsgType, data []byte) error {
+	t.write.Lock()
+	defer t.write.Unlock()
+	if err := t.conn.SetWriteDeadline(time.Now().Add(remoteWriteTimeout)); err != nil {
+		return err
+	}
+	if _, err := readline.NewMessage(kind, data).WriteTo(t.conn); err != nil {
+		_ = t.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// Write sends output to the operator's terminal
+func (t *terminal) Write(p []byte) (int, error) {
+	if err := t.send(readline.T_DATA, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the operator's connection
+func (t *terminal) Close() error {
+	return t.conn.Close()
+}
+
+// menuOperators lists the operators connected to the team server or manages their accounts
+func menuOperators(cmd []string) {
+	// Operators can not manage each other's accounts
+	if shellOperator != "" {
+		message("warn", "Operators can only be managed from the server's console")
+		return
+	}
+	if len(cmd) == 0 {
+		cmd = []string{"sessions"}
+	}
+	switch cmd[0] {
+	case "sessions":
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Operator", "Role", "Address", "Type", "Logged In", "Last Seen"})
+		table.SetAlignment(tablewriter.ALIGN_CENTER)
+		for _, s := range rpc.Sessions() {
+			kind := "API"
+			if s.Console {
+				kind = "Console"
+			}
+			table.Append([]string{s.Operator, s.Role, s.Address, kind, s.Created.Format(time.RFC3339),
+				s.LastSeen.Format(time.RFC3339)})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case "list":
+		for _, name := range rpc.Operators() {
+			message("info", name)
+		}
+	case "add":
+		if len(cmd) != 2 {
+			message("warn", "Invalid command")
+			message("info", "operators add <name>")
+			return
+		}
+		password, err := rpc.AddOperator(cmd[1])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Operator %s's password is %s; it will not be shown again", cmd[1], password))
+		logging.Server(fmt.Sprintf("Operator account %s was created%s", cmd[1], by()))
+	case "reset":
+		if len(cmd) != 2 {
+			message("warn", "Invalid command")
+			message("info", "operators reset <name>")
+			return
+		}
+		password, err := rpc.ResetOperator(cmd[1])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Operator %s's new password is %s; it will not be shown again", cmd[1], password))
+		logging.Server(fmt.Sprintf("Operator account %s's password was reset%s", cmd[1], by()))
+	case "remove":
+		if len(cmd) != 2 {
+			message("warn", "Invalid command")
+			message("info", "operators remove <name>")
+			return
+		}
+		if err := rpc.RemoveOperator(cmd[1]); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Removed operator %s and disconnected their sessions", cmd[1]))
+		logging.Server(fmt.Sprintf("Operator account %s was removed%s", cmd[1], by()))
+	default:
+		message("warn", "Invalid command")
+		message("info", "operators [sessions|list|add <name>|reset <name>|remove <name>]")
+	}
+}
+
+// by describes who ran the command line being executed for the server log
+func by() string {
+	if shellOperator == "" {
+		return " from the server's console"
+	}
+	return fmt.Sprintf(" by operator %s", shellOperator)
+}
@@ -0,0 +1,79 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/events"
+	"github.com/Ne0nd0g/merlin/pkg/report"
+)
+
+// reportUsage is the usage of the report command
+const reportUsage = "report <file.md|file.html|file.json> [since <time>] [until <time>]"
+
+// menuReport writes the engagement report for a time range to a Markdown, HTML, or JSON file
+func menuReport(cmd []string) {
+	if len(cmd) < 1 || len(cmd)%2 != 1 {
+		message("warn", "Invalid 'report' command")
+		message("info", reportUsage)
+		return
+	}
+	since, until, err := timeRange(cmd[1:])
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	r, err := report.Build(since, until)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	if err = report.Write(r, cmd[0]); err != nil {
+		message("warn", err.Error())
+		return
+	}
+	message("success", fmt.Sprintf("Wrote the report of %d agents, %d timeline items, %d file transfers, and %d "+
+		"credentials to %s", len(r.Agents), len(r.Timeline), len(r.Files), len(r.Credentials), cmd[0]))
+}
+
+// timeRange parses since <time> and until <time> pairs into a time range; a missing since or until is zero
+func timeRange(args []string) (since time.Time, until time.Time, err error) {
+	if len(args)%2 != 0 {
+		return since, until, fmt.Errorf("invalid time range; use since <time> and until <time>")
+	}
+	now := time.Now().UTC()
+	for i := 0; i < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "since":
+			since, err = events.ParseTime(args[i+1], now)
+		case "until":
+			until, err = events.ParseTime(args[i+1], now)
+		default:
+			err = fmt.Errorf("%s is not a valid time range filter; use since or until", args[i])
+		}
+		if err != nil {
+			return since, until, err
+		}
+	}
+	return since, until, nil
+}
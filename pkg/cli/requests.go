@@ -0,0 +1,93 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/servers/requests"
+)
+
+// requestsLimit is the number of most recent requests shown when the requests command is not given a count
+const requestsLimit = 50
+
+// menuRequests lists the recent requests received by an HTTP listener, or only the ones flagged as anomalies
+func menuRequests(cmd []string) {
+	if len(cmd) < 2 {
+		message("warn", "Invalid 'requests' command; use requests <listener ID|main> [anomalies] [<count>]")
+		return
+	}
+	id, err := stagerListener(cmd[1])
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	anomalies := false
+	limit := requestsLimit
+	for _, arg := range cmd[2:] {
+		if arg == "anomalies" {
+			anomalies = true
+			continue
+		}
+		if limit, err = strconv.Atoi(arg); err != nil || limit < 1 {
+			message("warn", fmt.Sprintf("%s is not anomalies or a valid count", arg))
+			return
+		}
+	}
+	list := requests.Query(id, anomalies)
+	if len(list) == 0 {
+		message("info", fmt.Sprintf("There are no requests for listener %s; only HTTP listeners record requests", cmd[1]))
+		return
+	}
+	if len(list) > limit {
+		message("note", fmt.Sprintf("Showing the last %d of %d requests", limit, len(list)))
+		list = list[len(list)-limit:]
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Time", "Source", "Request", "User Agent", "Status", "Agent", "Flags"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, r := range list {
+		request := strings.TrimSpace(r.Method + " " + r.Path)
+		status := ""
+		if r.Status != 0 {
+			status = strconv.Itoa(r.Status)
+		}
+		agent := ""
+		if r.Agent != uuid.Nil {
+			agent = r.Agent.String()
+		}
+		flags := strings.Join(r.Flags, ", ")
+		if r.Detail != "" {
+			flags += ": " + r.Detail
+		}
+		table.Append([]string{r.Time.Format(time.RFC3339), r.Source, request, r.UserAgent, status, agent, flags})
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
@@ -0,0 +1,68 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"time"
+
+	// 3rd Party
+	"github.com/fatih/color"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/highlight"
+	"github.com/Ne0nd0g/merlin/pkg/storage"
+)
+
+// menuResults displays the full, unfolded output a job returned for the agent from the store
+func menuResults(cmd []string) {
+	if len(cmd) < 2 {
+		message("warn", "Invalid 'results' command; use results <job ID>")
+		return
+	}
+	if storage.Current().Name() == "memory" {
+		message("warn", "Job results are not saved with memory storage; view them in the agent's log file")
+		return
+	}
+	results, err := storage.Current().Results(shellAgent)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	for _, r := range results {
+		if r.Job != cmd[1] {
+			continue
+		}
+		message("success", fmt.Sprintf("Results for job %s at %s", r.Job, r.Time.Format(time.RFC3339)))
+		fmt.Println()
+		if len(r.Stdout) > 0 {
+			if highlight.Format(r.Stdout) != "" {
+				out, _ := highlight.Render(r.Stdout, 0)
+				fmt.Println(out)
+			} else {
+				color.Green(r.Stdout)
+			}
+		}
+		if len(r.Stderr) > 0 {
+			color.Red(r.Stderr)
+		}
+		return
+	}
+	message("warn", fmt.Sprintf("There are no results for job %s", cmd[1]))
+}
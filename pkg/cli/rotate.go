@@ -0,0 +1,169 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+)
+
+// rotateUsage is the usage of the rotate command
+const rotateUsage = "rotate [now [<timeout>] | schedule <interval|off> [<timeout>]]"
+
+// rotateTimeout is how long a rotation waits for the agents to confirm their changes when a timeout is not given
+const rotateTimeout = 5 * time.Minute
+
+// menuRotate shows the rotation schedule and the outcome of the last rotation, starts a rotation, or schedules them.
+// A rotation replaces the HTTP listeners' certificate and PSK and the active HTTP agents' callback URIs, sleep, skew,
+// and TLS Client Hello together, and reverses every change if any agent does not confirm its changes before the timeout.
+func menuRotate(cmd []string) {
+	if len(cmd) < 1 {
+		interval, timeout := agents.ScheduledRotation()
+		schedule := "off"
+		if interval > 0 {
+			schedule = fmt.Sprintf("every %s with a %s timeout", interval, timeout)
+		}
+		message("info", fmt.Sprintf("Scheduled rotation: %s", schedule))
+		r, ok := agents.LastRotation()
+		if !ok {
+			message("info", "There has not been a rotation")
+			return
+		}
+		outcome := "completed"
+		if r.RolledBack {
+			outcome = "rolled back"
+		}
+		message("info", fmt.Sprintf("Last rotation %s at %s: %d listeners, %d agents confirmed, %d agents pending, "+
+			"%d agents skipped", outcome, r.Finished.Format(time.RFC3339), len(r.Listeners), len(r.Agents),
+			len(r.Pending), len(r.Skipped)))
+		return
+	}
+
+	timeout := rotateTimeout
+	parseTimeout := func(i int) bool {
+		if len(cmd) <= i {
+			return true
+		}
+		t, err := time.ParseDuration(cmd[i])
+		if err != nil || t <= 0 {
+			message("warn", fmt.Sprintf("%s is not a valid timeout (i.e. 5m)", cmd[i]))
+			return false
+		}
+		timeout = t
+		return true
+	}
+
+	switch cmd[0] {
+	case "now":
+		if !parseTimeout(1) {
+			return
+		}
+		message("info", fmt.Sprintf("Rotation started; waiting up to %s for the agents to confirm their changes", timeout))
+		go func() {
+			rotateReport(agents.Rotate(timeout))
+		}()
+	case "schedule":
+		if len(cmd) < 2 {
+			message("warn", "Not enough arguments provided")
+			message("info", "rotate schedule <interval|off> [<timeout>]")
+			return
+		}
+		if cmd[1] == "off" {
+			ScheduleRotation(0, 0)
+			return
+		}
+		interval, err := time.ParseDuration(cmd[1])
+		if err != nil || interval < time.Hour {
+			message("warn", fmt.Sprintf("%s is not a valid interval of at least 1h (i.e. 12h)", cmd[1]))
+			return
+		}
+		if !parseTimeout(2) {
+			return
+		}
+		if timeout >= interval {
+			message("warn", "The timeout must be shorter than the interval")
+			return
+		}
+		ScheduleRotation(interval, timeout)
+	default:
+		message("warn", "Invalid 'rotate' command")
+		message("info", rotateUsage)
+	}
+}
+
+// ScheduleRotation starts, replaces, or with an interval of zero stops, the scheduled rotation
+func ScheduleRotation(interval time.Duration, timeout time.Duration) {
+	agents.ScheduleRotation(interval, timeout, rotateReport)
+	if interval <= 0 {
+		message("info", "Scheduled rotations are off")
+		return
+	}
+	m := fmt.Sprintf("Rotating listeners and agents every %s", interval)
+	message("info", m)
+	logging.Server(m)
+}
+
+// rotateReport displays and logs the outcome of a rotation and, when it completed, makes the rotated listeners' new PSK
+// the one agents are generated with
+func rotateReport(r agents.Rotation, err error) {
+	if err != nil {
+		m := fmt.Sprintf("There was an error rotating the listeners and agents:\r\n%s", err.Error())
+		if r.RolledBack {
+			m += "\r\nEvery listener was restored and the agents were tasked with their previous settings"
+		}
+		message("warn", m)
+		logging.Server(m)
+		return
+	}
+
+	listeners.Lock()
+	for i, s := range listeners.servers {
+		for _, id := range r.Listeners {
+			if s.ID != id {
+				continue
+			}
+			for j, o := range s.config.Options {
+				if o.Name == "PSK" {
+					listeners.servers[i].config.Options[j].Value = r.PSK
+				}
+			}
+		}
+	}
+	listeners.Unlock()
+	for _, id := range r.Listeners {
+		if id == serverID {
+			SetPSK(r.PSK)
+		}
+	}
+
+	m := fmt.Sprintf("Rotated %d listeners and %d agents", len(r.Listeners), len(r.Agents))
+	if r.Pin != "" {
+		m += fmt.Sprintf("; the new certificate's public key pin is %s", r.Pin)
+	}
+	message("success", m)
+	logging.Server(m)
+	if len(r.Skipped) > 0 {
+		message("warn", fmt.Sprintf("%d HTTP agents were not active, or can not be rotated, and kept their old "+
+			"settings; they can not authenticate with the listeners' new PSK", len(r.Skipped)))
+	}
+}
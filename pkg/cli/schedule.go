@@ -0,0 +1,155 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+)
+
+// execUsage is the syntax of the agent menu's exec command
+const execUsage = "exec [-at <02:00|90m|RFC 3339 time>] [-every <interval>] <command>"
+
+// scheduleUsage is the syntax of the agent menu's schedule command
+const scheduleUsage = "schedule list, schedule remove <id>"
+
+// menuExec executes a command on the agent the agent menu is interacting with now, or schedules the server to queue it
+// at a time and, with -every, again every interval after
+func menuExec(cmd []string) {
+	var at time.Time
+	var every time.Duration
+	for len(cmd) > 1 && (cmd[0] == "-at" || cmd[0] == "-every") {
+		switch cmd[0] {
+		case "-at":
+			t, err := parseEmbargo(cmd[1])
+			if err != nil {
+				message("warn", strings.Replace(err.Error(), "--after", "-at", 1))
+				return
+			}
+			at = t
+		case "-every":
+			d, err := time.ParseDuration(cmd[1])
+			if err != nil || d < time.Minute {
+				message("warn", fmt.Sprintf("%s is not an interval of at least one minute", cmd[1]))
+				return
+			}
+			every = d
+		}
+		cmd = cmd[2:]
+	}
+	if len(cmd) < 1 || strings.HasPrefix(cmd[0], "-") {
+		message("warn", "Invalid 'exec' command")
+		message("info", execUsage)
+		return
+	}
+	// A trailing --after is the first time the scheduled job is queued when -at was not provided
+	if at.IsZero() && !embargo.IsZero() && every > 0 {
+		at = embargo
+	}
+	if at.IsZero() && every == 0 {
+		m, err := addJob(shellAgent, "cmd", cmd)
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("note", fmt.Sprintf("Created job %s for agent %s at %s",
+			m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+		return
+	}
+	s, err := agents.Schedule(shellAgent, "cmd", cmd, at, every)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	message("note", fmt.Sprintf("Scheduled job %s for agent %s at %s%s", s.ID, shellAgent,
+		s.Next.Local().Format(time.RFC3339), scheduleEvery(s)))
+}
+
+// menuSchedule lists or removes the jobs scheduled for the agent the agent menu is interacting with
+func menuSchedule(cmd []string) {
+	if len(cmd) < 1 {
+		message("warn", "Invalid 'schedule' command")
+		message("info", scheduleUsage)
+		return
+	}
+	switch cmd[0] {
+	case "list":
+		list := agents.Schedules(shellAgent)
+		if len(list) == 0 {
+			message("info", "There are no jobs scheduled for this agent")
+			return
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ID", "Type", "Arguments", "Next", "Every", "Runs", "Last Job"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, s := range list {
+			every := "once"
+			if s.Every > 0 {
+				every = s.Every.String()
+			}
+			table.Append([]string{s.ID, s.Type, strings.Join(s.Args, " "), s.Next.Local().Format(time.RFC3339), every,
+				strconv.Itoa(s.Runs), s.Last})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case "remove":
+		if len(cmd) != 2 {
+			message("warn", "Invalid 'schedule' command")
+			message("info", scheduleUsage)
+			return
+		}
+		if err := agents.Unschedule(shellAgent, cmd[1]); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Removed scheduled job %s from agent %s", cmd[1], shellAgent))
+	default:
+		message("warn", fmt.Sprintf("%s is not a valid schedule action", cmd[0]))
+		message("info", scheduleUsage)
+	}
+}
+
+// scheduleEvery describes how often a scheduled job is queued after its first time
+func scheduleEvery(s agents.ScheduledJob) string {
+	if s.Every == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" and every %s after", s.Every)
+}
+
+// scheduleIDs returns the IDs of the jobs scheduled for the agent the agent menu is interacting with
+func scheduleIDs() func(string) []string {
+	return func(line string) []string {
+		var ids []string
+		for _, s := range agents.Schedules(shellAgent) {
+			ids = append(ids, s.ID)
+		}
+		return ids
+	}
+}
@@ -0,0 +1,108 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/loot"
+)
+
+// menuWatch shows, starts, or stops the periodic screenshots of the agent the agent menu is interacting with
+func menuWatch(cmd []string) {
+	if len(cmd) == 0 {
+		if interval := agents.Watching(shellAgent); interval > 0 {
+			message("info", fmt.Sprintf("Taking a screenshot of agent %s every %s", shellAgent, interval))
+		} else {
+			message("info", fmt.Sprintf("Agent %s's screen is not being watched", shellAgent))
+		}
+		return
+	}
+	if cmd[0] == "off" {
+		if agents.Watching(shellAgent) == 0 {
+			message("info", fmt.Sprintf("Agent %s's screen is not being watched", shellAgent))
+			return
+		}
+		if err := agents.Watch(shellAgent, 0); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("info", fmt.Sprintf("Stopped watching agent %s's screen", shellAgent))
+		return
+	}
+	interval, err := time.ParseDuration(cmd[0])
+	if err != nil || interval < time.Second {
+		message("warn", fmt.Sprintf("%s is not a valid interval; use a duration of at least 1s (i.e. 30s or 5m)", cmd[0]))
+		return
+	}
+	if err = agents.Watch(shellAgent, interval); err != nil {
+		message("warn", err.Error())
+		return
+	}
+	message("note", fmt.Sprintf("Taking a screenshot of agent %s now and every %s; use 'watch off' to stop", shellAgent, interval))
+	message("info", "Use 'loot gallery' in the main menu to view the screenshots")
+}
+
+// lootGallery writes the screenshot gallery of the agent, or of every agent with screenshots when agentID is nil, and
+// lists where they were written
+func lootGallery(agentID uuid.UUID) {
+	screenshots, err := loot.Screenshots(agentID)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	if len(screenshots) == 0 {
+		message("info", "There are no screenshots; use the screenshot or watch agent commands to take them")
+		return
+	}
+
+	// The screenshots are sorted by agent
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Agent", "Screenshots", "Latest", "Gallery"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for i := 0; i < len(screenshots); {
+		j := i
+		latest := screenshots[i]
+		for ; j < len(screenshots) && screenshots[j].Agent == screenshots[i].Agent; j++ {
+			if screenshots[j].Name > latest.Name {
+				latest = screenshots[j]
+			}
+		}
+		page, err := loot.Gallery(screenshots[i].Agent)
+		if err != nil {
+			message("warn", err.Error())
+			page = ""
+		}
+		table.Append([]string{screenshots[i].Agent.String(), strconv.Itoa(j - i), latest.Name, page})
+		i = j
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+	message("info", "Open a gallery in a browser to view the agent's screenshots, newest first")
+}
@@ -0,0 +1,56 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/modules"
+)
+
+// menuSearch shows the modules that match every keyword, best matches first
+func menuSearch(cmd []string) {
+	if len(cmd) == 0 {
+		message("warn", "Invalid 'search' command; use search <keyword> [<keyword> ...]")
+		return
+	}
+	results := modules.Search(cmd)
+	if len(results) == 0 {
+		message("note", fmt.Sprintf("There are no modules that match %s", strings.Join(cmd, " ")))
+		return
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Score", "Module", "Platform", "ATT&CK", "Description"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, r := range results {
+		table.Append([]string{strconv.Itoa(r.Score), r.Name, strings.ToLower(r.Module.Platform + "/" + r.Module.Arch),
+			strings.Join(r.Module.Attack, ", "), r.Module.Description})
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+	message("info", fmt.Sprintf("%d modules matched; use one with 'use module <module>' from the main menu", len(results)))
+}
@@ -0,0 +1,243 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+)
+
+// sessionsUsage is the syntax of the sessions and agent list commands
+const sessionsUsage = "[<field>=<value>|<field>!=<value>|<field>~<text> ...] [columns=<field>,...] [sort=[-]<field>]"
+
+// sessionField is a field of the sessions table that can be filtered on, sorted by, and shown as a column
+type sessionField struct {
+	name   string
+	header string
+	value  func(n int, id uuid.UUID) string // The value filters and sorting use
+	show   func(n int, id uuid.UUID) string // The value shown in the table when it differs from the value
+}
+
+// sessionFields are the fields of the sessions table in the order they are listed in help messages
+var sessionFields = []sessionField{
+	{name: "#", header: "#", value: func(n int, id uuid.UUID) string { return strconv.Itoa(n) }},
+	{name: "id", header: "Agent GUID", value: func(n int, id uuid.UUID) string { return id.String() }},
+	{name: "platform", header: "Platform",
+		value: func(n int, id uuid.UUID) string { return agents.Agents[id].Platform },
+		show: func(n int, id uuid.UUID) string {
+			return agents.Agents[id].Platform + "/" + agents.Agents[id].Architecture
+		}},
+	{name: "arch", header: "Architecture", value: func(n int, id uuid.UUID) string { return agents.Agents[id].Architecture }},
+	{name: "user", header: "User", value: func(n int, id uuid.UUID) string { return agents.Agents[id].UserName }},
+	{name: "host", header: "Host", value: func(n int, id uuid.UUID) string { return agents.Agents[id].HostName }},
+	{name: "ip", header: "IP", value: func(n int, id uuid.UUID) string { return strings.Join(agents.Agents[id].Ips, "\n") }},
+	{name: "pid", header: "PID", value: func(n int, id uuid.UUID) string { return strconv.Itoa(agents.Agents[id].Pid) }},
+	{name: "transport", header: "Transport",
+		value: func(n int, id uuid.UUID) string { return agents.Agents[id].Proto },
+		show:  func(n int, id uuid.UUID) string { return transportName(agents.Agents[id].Proto) }},
+	{name: "status", header: "Status", value: func(n int, id uuid.UUID) string { return agents.GetAgentStatus(id) }},
+	{name: "lastseen", header: "Last Check In", value: func(n int, id uuid.UUID) string {
+		return agents.Agents[id].StatusCheckIn.Format(time.RFC3339)
+	}},
+	{name: "version", header: "Version", value: func(n int, id uuid.UUID) string { return agents.Agents[id].Version }},
+	{name: "tags", header: "Tags", value: func(n int, id uuid.UUID) string { return strings.Join(agents.Agents[id].Tags, "\n") }},
+	{name: "resources", header: "Resources", value: func(n int, id uuid.UUID) string { return agents.GetUsage(id) }},
+}
+
+// sessionColumns are the columns shown when no columns are selected
+var sessionColumns = []string{"#", "id", "platform", "user", "host", "transport", "status", "resources"}
+
+// sessionFilter is a field=value, field!=value, or field~text expression
+var sessionFilter = regexp.MustCompile(`^([#a-z]+)(=|!=|~)(.*)$`)
+
+// sessionFilterExpression is a parsed filter expression that selects agents by one of their sessions fields
+type sessionFilterExpression struct {
+	field    sessionField
+	operator string
+	value    string
+}
+
+// parseSessionFilter parses a field=value, field!=value, or field~text filter expression
+func parseSessionFilter(arg string) (sessionFilterExpression, error) {
+	expression := sessionFilter.FindStringSubmatch(arg)
+	if expression == nil {
+		return sessionFilterExpression{}, fmt.Errorf("%s is not a valid filter expression", arg)
+	}
+	f, err := getSessionField(expression[1])
+	if err != nil {
+		return sessionFilterExpression{}, err
+	}
+	return sessionFilterExpression{field: f, operator: expression[2], value: strings.ToLower(expression[3])}, nil
+}
+
+// sessionMatch returns true if the agent, numbered n in the sessions list, matches every filter expression. Values are
+// compared without case and multi-value fields, such as ip and tags, match when any value does.
+func sessionMatch(filters []sessionFilterExpression, n int, id uuid.UUID) bool {
+	for _, f := range filters {
+		var found bool
+		for _, v := range strings.Split(strings.ToLower(f.field.value(n, id)), "\n") {
+			if (f.operator == "~" && strings.Contains(v, f.value)) || (f.operator != "~" && v == f.value) {
+				found = true
+				break
+			}
+		}
+		if found == (f.operator == "!=") {
+			return false
+		}
+	}
+	return true
+}
+
+// transportName converts a protocol (i.e. h2 or hq) to a user friendly string
+func transportName(proto string) string {
+	switch proto {
+	case "https":
+		return "HTTP/1.1 (https)"
+	case "h2":
+		return "HTTP/2 (h2)"
+	case "hq":
+		return "QUIC (hq)"
+	}
+	return proto
+}
+
+// getSessionField returns the sessions table field by its name
+func getSessionField(name string) (sessionField, error) {
+	for _, f := range sessionFields {
+		if f.name == name {
+			return f, nil
+		}
+	}
+	var names []string
+	for _, f := range sessionFields {
+		names = append(names, f.name)
+	}
+	return sessionField{}, fmt.Errorf("%s is not a sessions field; use one of: %s", name, strings.Join(names, ", "))
+}
+
+// listSessions shows the agents that match every filter expression in a table with the selected columns, sorted by a
+// field. Values are compared without case and multi-value fields, such as ip and tags, match when any value does.
+// Agents keep the number they have in the full list so the number can be used with sessions copy.
+func listSessions(args []string) {
+	columns := sessionColumns
+	var sortBy *sessionField
+	var descending bool
+	var filters []sessionFilterExpression
+	for _, arg := range args {
+		expression := sessionFilter.FindStringSubmatch(arg)
+		if expression == nil {
+			message("warn", fmt.Sprintf("%s is not a valid filter expression", arg))
+			message("info", sessionsUsage)
+			return
+		}
+		switch {
+		case expression[1] == "columns" && expression[2] == "=":
+			columns = strings.Split(expression[3], ",")
+			for _, c := range columns {
+				if _, err := getSessionField(c); err != nil {
+					message("warn", err.Error())
+					return
+				}
+			}
+		case expression[1] == "sort" && expression[2] == "=":
+			name := strings.TrimPrefix(expression[3], "-")
+			f, err := getSessionField(name)
+			if err != nil {
+				message("warn", err.Error())
+				return
+			}
+			sortBy, descending = &f, strings.HasPrefix(expression[3], "-")
+		default:
+			f, err := parseSessionFilter(arg)
+			if err != nil {
+				message("warn", err.Error())
+				return
+			}
+			filters = append(filters, f)
+		}
+	}
+
+	type row struct {
+		n  int
+		id uuid.UUID
+	}
+	var rows []row
+	for n, id := range sessionList() {
+		if sessionMatch(filters, n+1, id) {
+			rows = append(rows, row{n: n + 1, id: id})
+		}
+	}
+	if sortBy != nil {
+		less := func(i, j int) bool {
+			a, b := sortBy.value(rows[i].n, rows[i].id), sortBy.value(rows[j].n, rows[j].id)
+			x, errA := strconv.Atoi(a)
+			y, errB := strconv.Atoi(b)
+			if errA == nil && errB == nil {
+				return x < y
+			}
+			return strings.ToLower(a) < strings.ToLower(b)
+		}
+		sort.SliceStable(rows, func(i, j int) bool {
+			if descending {
+				return less(j, i)
+			}
+			return less(i, j)
+		})
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	var fields []sessionField
+	var headers []string
+	for _, c := range columns {
+		f, _ := getSessionField(c)
+		fields = append(fields, f)
+		headers = append(headers, f.header)
+	}
+	table.SetHeader(headers)
+	table.SetAlignment(tablewriter.ALIGN_CENTER)
+	for _, r := range rows {
+		var values []string
+		for _, f := range fields {
+			if f.show != nil {
+				values = append(values, f.show(r.n, r.id))
+			} else {
+				values = append(values, f.value(r.n, r.id))
+			}
+		}
+		table.Append(values)
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+	if len(filters) > 0 {
+		message("info", fmt.Sprintf("%d of %d agents matched the filters", len(rows), len(agents.Agents)))
+	}
+}
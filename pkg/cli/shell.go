@@ -0,0 +1,64 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"time"
+
+	// 3rd Party
+	"github.com/chzyer/readline"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+)
+
+// menuSetShell starts an interactive shell on the current agent and enters the shell menu context, where every line
+// typed is sent to the agent's shell instead of being run as a command
+func menuSetShell() {
+	m, err := agents.StartShell(shellAgent)
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	message("note", fmt.Sprintf("Created job %s for agent %s at %s", m, shellAgent, time.Now().UTC().Format(time.RFC3339)))
+	message("info", "Lines are sent to the shell once the agent checks in; press ctrl-c to return to the agent menu")
+	prompt.Config.AutoComplete = readline.NewPrefixCompleter()
+	setPrompt("\033[31mMerlin[\033[32magent\033[31m][\033[33m" + shellAgent.String() + "\033[31m][\033[32mshell\033[31m]»\033[0m ")
+	shellMenuContext = "shell"
+}
+
+// menuShell sends a line to the current agent's interactive shell
+func menuShell(line string) {
+	if !agents.ShellRunning(shellAgent) {
+		message("note", "The interactive shell is closed")
+		menuSetAgent(shellAgent)
+		return
+	}
+	if err := agents.ShellInput(shellAgent, line); err != nil {
+		message("warn", err.Error())
+	}
+}
+
+// menuShellExit stops the current agent's interactive shell, leaving the agent running, and returns to the agent menu
+func menuShellExit() {
+	agents.StopShell(shellAgent)
+	message("note", "Closing the interactive shell; the agent kills it on its next exchange")
+	menuSetAgent(shellAgent)
+}
@@ -0,0 +1,208 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/stager"
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// menuStager handles the listeners menu stager command to host stagers and the agents they download on a listener
+func menuStager(cmd []string) {
+	if len(cmd) < 2 {
+		message("warn", "Invalid 'stager' command; use stager <type> <listener ID|main> <agent file> [<uri>], "+
+			"stager list, stager copy <listener ID|main> <uri>, or stager remove <listener ID|main> <uri>")
+		return
+	}
+	switch strings.ToLower(cmd[1]) {
+	case "list":
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Listener", "URI", "Type", "Hits", "Command"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.SetAutoWrapText(false)
+		for _, s := range stager.GetStages() {
+			var command string
+			if s.Type == "agent" {
+				command = s.File
+			} else if base, err := stagerBaseURL(s.Listener); err == nil {
+				command, _ = stager.OneLiner(s.Type, base+s.URI)
+			}
+			table.Append([]string{stagerListenerName(s.Listener), s.URI, s.Type, strconv.Itoa(s.Hits), command})
+		}
+		fmt.Println()
+		table.Render()
+		fmt.Println()
+	case "remove":
+		if len(cmd) < 4 {
+			message("warn", "Invalid 'stager remove' command; use stager remove <listener ID|main> <uri>")
+			return
+		}
+		id, err := stagerListener(cmd[2])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		if err := stager.Remove(id, cmd[3]); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		m := fmt.Sprintf("Removed the stage at %s on listener %s", cmd[3], stagerListenerName(id))
+		logging.Server(m)
+		message("success", m)
+	case "copy":
+		if len(cmd) < 4 {
+			message("warn", "Invalid 'stager copy' command; use stager copy <listener ID|main> <uri>")
+			return
+		}
+		id, err := stagerListener(cmd[2])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		base, err := stagerBaseURL(id)
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		for _, s := range stager.GetStages() {
+			if s.Listener != id || s.URI != cmd[3] {
+				continue
+			}
+			// An agent stage has no one-liner so its URL is copied
+			if s.Type == "agent" {
+				copyText(base+s.URI, "the agent URL "+base+s.URI)
+				return
+			}
+			oneLiner, err := stager.OneLiner(s.Type, base+s.URI)
+			if err != nil {
+				message("warn", err.Error())
+				return
+			}
+			copyText(oneLiner, fmt.Sprintf("the %s one-liner", s.Type))
+			return
+		}
+		message("warn", fmt.Sprintf("there is not a stage at %s on listener %s", cmd[3], stagerListenerName(id)))
+	default:
+		if len(cmd) < 4 {
+			message("warn", "Invalid 'stager' command; use stager <type> <listener ID|main> <agent file> [<uri>]")
+			return
+		}
+		stagerType := strings.ToLower(cmd[1])
+		if !inSlice(stagerType, stager.GetTypes()) {
+			message("warn", fmt.Sprintf("%s is not a valid stager type; use %s", cmd[1], strings.Join(stager.GetTypes(), ", ")))
+			return
+		}
+		id, err := stagerListener(cmd[2])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		base, err := stagerBaseURL(id)
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		uri := stager.RandomURI()
+		if len(cmd) > 4 {
+			uri = cmd[4]
+		}
+		agent, err := stager.HostAgent(id, stager.RandomURI(), cmd[3])
+		if err != nil {
+			message("warn", err.Error())
+			return
+		}
+		s, err := stager.HostStager(id, uri, stagerType, base+agent.URI)
+		if err != nil {
+			_ = stager.Remove(id, agent.URI)
+			message("warn", err.Error())
+			return
+		}
+		oneLiner, _ := stager.OneLiner(s.Type, base+s.URI)
+		m := fmt.Sprintf("Hosting the %s stager at %s%s and the agent %s at %s%s", s.Type, base, s.URI, agent.File,
+			base, agent.URI)
+		logging.Server(m)
+		message("success", m)
+		message("info", oneLiner)
+	}
+}
+
+// stagerListener returns the ID of the main listener, or a listener started from the listeners menu, that hosts stages
+func stagerListener(name string) (uuid.UUID, error) {
+	if strings.EqualFold(name, "main") {
+		return serverID, nil
+	}
+	id, err := uuid.FromString(name)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s is not main or a listener ID", name)
+	}
+	return id, nil
+}
+
+// stagerListenerName returns main for the main listener or the listener's ID
+func stagerListenerName(id uuid.UUID) string {
+	if id == serverID {
+		return "main"
+	}
+	return id.String()
+}
+
+// stagerBaseURL returns the scheme and host stagers use to download from the listener; only listeners that serve HTTP
+// over TCP can host stages
+func stagerBaseURL(id uuid.UUID) (string, error) {
+	if id == serverID {
+		if serverProtocol != "h2" {
+			return "", fmt.Errorf("the main listener's %s protocol can not host stagers", serverProtocol)
+		}
+		u, err := url.Parse(serverURL)
+		if err != nil {
+			return "", fmt.Errorf("there was an error parsing the main listener's URL:\r\n%s", err.Error())
+		}
+		return u.Scheme + "://" + u.Host, nil
+	}
+	listeners.Lock()
+	defer listeners.Unlock()
+	for _, s := range listeners.servers {
+		if s.ID != id {
+			continue
+		}
+		var scheme string
+		switch s.Protocol {
+		case "ws":
+			scheme = "http"
+		case "wss", "h2":
+			scheme = "https"
+		default:
+			return "", fmt.Errorf("%s listeners can not host stagers; use the main listener or an h2 or ws listener", s.Protocol)
+		}
+		return scheme + "://" + util.JoinHostPort(s.Interface, strconv.Itoa(s.Port)), nil
+	}
+	return "", fmt.Errorf("%s is not a listener started from the listeners menu", id.String())
+}
@@ -0,0 +1,112 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// startupListener is a listener in a listeners file: its type and the options changed from their defaults, named as
+// they are in the listeners menu (i.e. {"protocol": "dns", "options": {"Domain": "example.com"}})
+type startupListener struct {
+	Protocol string            `json:"protocol"`
+	Options  map[string]string `json:"options"`
+}
+
+// loadListeners reads a JSON listeners file and returns the configuration of each listener in it
+func loadListeners(file string) ([]listenerConfig, error) {
+	data, err := ioutil.ReadFile(file) // #nosec G304 - The file is provided by the operator
+	if err != nil {
+		return nil, fmt.Errorf("there was an error reading the listeners file:\r\n%s", err.Error())
+	}
+	var list []startupListener
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("there was an error parsing the listeners file %s:\r\n%s", file, err.Error())
+	}
+	var configs []listenerConfig
+	for i, s := range list {
+		l := listenerConfig{Protocol: strings.ToLower(s.Protocol), Options: listenerOptions(strings.ToLower(s.Protocol))}
+		if l.Options == nil {
+			return nil, api.Errorf(api.InvalidOption, "listener %d in %s has the invalid type %q; use one of %s", i+1, file,
+				s.Protocol, strings.Join(GetListenerTypes(), ", "))
+		}
+		for name, value := range s.Options {
+			found := false
+			for j, o := range l.Options {
+				if strings.EqualFold(o.Name, name) {
+					l.Options[j].Value = value
+					found = true
+				}
+			}
+			if !found {
+				return nil, api.Errorf(api.InvalidOption, "%s is not a valid option for listener %d, a %s listener, in %s",
+					name, i+1, l.Protocol, file)
+			}
+		}
+		configs = append(configs, l)
+	}
+	return configs, nil
+}
+
+// ValidateListeners checks a listeners file without starting its listeners and returns the number of listeners in it
+func ValidateListeners(file string) (int, error) {
+	configs, err := loadListeners(file)
+	return len(configs), err
+}
+
+// StartListeners starts the listeners in a JSON listeners file, such as when the server is started headless without the
+// command line interface. The listeners are added to the listeners menu as if they were started from it. Every listener
+// is attempted; the number started is returned with an error for the listeners that were not.
+func StartListeners(file string) (int, error) {
+	configs, err := loadListeners(file)
+	if err != nil {
+		return 0, err
+	}
+	var started int
+	var failed []string
+	for i := range configs {
+		s, err := configs[i].start()
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("listener %d, a %s listener: %s", i+1, configs[i].Protocol, err.Error()))
+			continue
+		}
+		listeners.Lock()
+		listeners.servers = append(listeners.servers, s)
+		listeners.Unlock()
+		started++
+		addr := s.Interface
+		if s.Port > 0 {
+			addr = util.JoinHostPort(s.Interface, strconv.Itoa(s.Port))
+		}
+		message("success", fmt.Sprintf("Started %s listener %s on %s", s.Protocol, s.ID, addr))
+	}
+	if len(failed) > 0 {
+		return started, fmt.Errorf("there was an error starting %d listeners from %s:\r\n%s", len(failed), file,
+			strings.Join(failed, "\r\n"))
+	}
+	return started, nil
+}
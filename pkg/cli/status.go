@@ -0,0 +1,61 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"strconv"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+)
+
+// statusUsage is the syntax of the agent menu's status command
+const statusUsage = "status, status thresholds [<delayed intervals> <dead intervals>|default]"
+
+// menuAgentStatus shows or changes how many check in intervals the agent can miss before it is delayed and dead
+func menuAgentStatus(cmd []string) {
+	if cmd[0] != "thresholds" || (len(cmd) != 1 && len(cmd) != 2 && len(cmd) != 3) || (len(cmd) == 2 && cmd[1] != "default") {
+		message("warn", "Invalid 'status' command")
+		message("info", statusUsage)
+		return
+	}
+	if len(cmd) > 1 {
+		var delayed, dead float64
+		if len(cmd) == 3 {
+			var err error
+			if delayed, err = strconv.ParseFloat(cmd[1], 64); err != nil {
+				message("warn", fmt.Sprintf("%s is not a number of check in intervals", cmd[1]))
+				return
+			}
+			if dead, err = strconv.ParseFloat(cmd[2], 64); err != nil {
+				message("warn", fmt.Sprintf("%s is not a number of check in intervals", cmd[2]))
+				return
+			}
+		}
+		if err := agents.SetThresholds(shellAgent, delayed, dead); err != nil {
+			message("warn", err.Error())
+			return
+		}
+	}
+	delayed, dead := agents.Thresholds(shellAgent)
+	message("info", fmt.Sprintf("Agent %s is delayed after it misses %s check in intervals and dead after it misses %s; "+
+		"each interval is its sleep with its jitter and skew, and waits for its working hours to open", shellAgent,
+		strconv.FormatFloat(delayed, 'f', -1, 64), strconv.FormatFloat(dead, 'f', -1, 64)))
+}
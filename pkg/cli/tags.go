@@ -0,0 +1,148 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+)
+
+// queueUsage is the syntax of the queue command
+const queueUsage = "queue <agent>|tag:<tag>[,...] <agent command> [<args>]"
+
+// queueExcluded are the agent menu commands that change menus or the server instead of tasking an agent
+var queueExcluded = map[string]bool{"?": true, "back": true, "exit": true, "help": true, "main": true, "quit": true}
+
+// menuAgentTag adds an agent to or removes it from the group named by a tag, or lists the groups
+func menuAgentTag(cmd []string) {
+	if len(cmd) < 3 {
+		message("warn", fmt.Sprintf("Invalid 'agent %s' command; use agent %s <agent> <tag>", cmd[0], cmd[0]))
+		return
+	}
+	i, errUUID := uuid.FromString(cmd[1])
+	if errUUID != nil {
+		message("warn", fmt.Sprintf("%s is not a valid agent ID", cmd[1]))
+		return
+	}
+	if cmd[0] == "untag" {
+		if err := agents.Untag(i, cmd[2]); err != nil {
+			message("warn", err.Error())
+			return
+		}
+		message("success", fmt.Sprintf("Removed agent %s from group %s", i, cmd[2]))
+		return
+	}
+	if err := agents.Tag(i, cmd[2]); err != nil {
+		message("warn", err.Error())
+		return
+	}
+	message("success", fmt.Sprintf("Added agent %s to group %s; target the group with %s%s", i, cmd[2],
+		agents.TagPrefix, cmd[2]))
+}
+
+// menuAgentGroup lists the groups made by tagging agents and the agents in each one
+func menuAgentGroup(cmd []string) {
+	if len(cmd) < 2 || cmd[1] != "list" {
+		message("warn", "Invalid 'agent group' command; use agent group list")
+		return
+	}
+	groups := agents.Groups()
+	if len(groups) == 0 {
+		message("note", "There are no tagged agents; use agent tag <agent> <tag>")
+		return
+	}
+	var tags []string
+	for tag := range groups {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Tag", "Agents", "Active", "Agent GUIDs"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, tag := range tags {
+		var active int
+		var ids []string
+		for _, id := range groups[tag] {
+			if agents.GetAgentStatus(id) == "Active" {
+				active++
+			}
+			ids = append(ids, id.String())
+		}
+		table.Append([]string{tag, strconv.Itoa(len(ids)), strconv.Itoa(active), strings.Join(ids, "\n")})
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
+
+// menuQueue runs an agent menu command for every agent the target refers to, as if the operator interacted with each
+// agent in turn and typed the command
+func menuQueue(cmd []string) {
+	if len(cmd) < 2 {
+		message("warn", "Invalid 'queue' command")
+		message("info", queueUsage)
+		return
+	}
+	if queueExcluded[cmd[1]] || (cmd[1] == "shell" && len(cmd) == 2) {
+		message("warn", fmt.Sprintf("The %s command can not be queued for a group of agents", cmd[1]))
+		return
+	}
+	ids, err := agents.Targets(cmd[0])
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	line := strings.Join(cmd[1:], " ")
+	if !embargo.IsZero() {
+		line += " --after " + embargo.Format(time.RFC3339)
+	}
+	agent, context := shellAgent, shellMenuContext
+	for _, id := range ids {
+		if status := agents.GetAgentStatus(id); status == "Dead" || status == "Pending" {
+			message("note", fmt.Sprintf("Skipped agent %s because it is %s", id, strings.ToLower(status)))
+			continue
+		}
+		shellAgent, shellMenuContext = id, "agent"
+		executeLine(line)
+	}
+	shellAgent, shellMenuContext = agent, context
+}
+
+// targetItems returns the tags, prefixed with tag:, and agent IDs a command can target
+func targetItems() func(string) []string {
+	return func(line string) []string {
+		var items []string
+		for tag := range agents.Groups() {
+			items = append(items, agents.TagPrefix+tag)
+		}
+		sort.Strings(items)
+		return append(items, agents.GetAgentList()(line)...)
+	}
+}
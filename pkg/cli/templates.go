@@ -0,0 +1,215 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	// 3rd Party
+	"github.com/olekukonko/tablewriter"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+)
+
+// listenerTemplateDir is the directory listener templates are saved in, one JSON file per template
+var listenerTemplateDir = filepath.Join(core.CurrentDir, "data", "listeners")
+
+// listenerTemplateName are the characters allowed in a listener template's name so it can be used as a file name
+var listenerTemplateName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// listenerTemplate is a listener's type and option values saved to configure another listener like it later
+type listenerTemplate struct {
+	Protocol string            `json:"protocol"`
+	Options  map[string]string `json:"options"`
+}
+
+// saveListenerTemplate saves the listener's options as a template with the name
+func saveListenerTemplate(name string, l listenerConfig) error {
+	if !listenerTemplateName.MatchString(name) {
+		return fmt.Errorf("%q is not a valid template name; use letters, numbers, '.', '_', and '-'", name)
+	}
+	if inSlice(strings.ToLower(name), GetListenerTypes()) {
+		return fmt.Errorf("%s is a listener type and can not be used as a template name", name)
+	}
+	t := listenerTemplate{Protocol: l.Protocol, Options: make(map[string]string)}
+	for _, o := range l.Options {
+		t.Options[o.Name] = o.Value
+	}
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return fmt.Errorf("there was an error marshalling the %s listener template:\r\n%s", name, err.Error())
+	}
+	if err = os.MkdirAll(listenerTemplateDir, 0750); err != nil {
+		return fmt.Errorf("there was an error creating the listener template directory:\r\n%s", err.Error())
+	}
+	// The template holds the listener's PSK
+	if err = ioutil.WriteFile(filepath.Join(listenerTemplateDir, name+".json"), append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("there was an error writing the %s listener template:\r\n%s", name, err.Error())
+	}
+	return nil
+}
+
+// loadListenerTemplate returns the listener configured with the template's options; options the template does not
+// have keep their default values
+func loadListenerTemplate(name string) (listenerConfig, error) {
+	if !listenerTemplateName.MatchString(name) {
+		return listenerConfig{}, fmt.Errorf("%q is not a valid template name", name)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(listenerTemplateDir, name+".json")) // #nosec G304 the name can not leave the template directory
+	if os.IsNotExist(err) {
+		return listenerConfig{}, fmt.Errorf("there is no %s listener template", name)
+	} else if err != nil {
+		return listenerConfig{}, fmt.Errorf("there was an error reading the %s listener template:\r\n%s", name, err.Error())
+	}
+	var t listenerTemplate
+	if err = json.Unmarshal(data, &t); err != nil {
+		return listenerConfig{}, fmt.Errorf("there was an error reading the %s listener template:\r\n%s", name, err.Error())
+	}
+	if !inSlice(t.Protocol, GetListenerTypes()) {
+		return listenerConfig{}, fmt.Errorf("the %s listener template has an invalid listener type: %s", name, t.Protocol)
+	}
+	l := listenerConfig{Protocol: t.Protocol, Options: listenerOptions(t.Protocol)}
+	for option, value := range t.Options {
+		found := false
+		for i, o := range l.Options {
+			if o.Name == option {
+				l.Options[i].Value, found = value, true
+				break
+			}
+		}
+		if !found {
+			return listenerConfig{}, fmt.Errorf("the %s listener template has an option the %s listener does not: %s", name, t.Protocol, option)
+		}
+	}
+	return l, nil
+}
+
+// listenerTemplates returns the names of the saved listener templates, sorted
+func listenerTemplates() []string {
+	files, err := ioutil.ReadDir(listenerTemplateDir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, f := range files {
+		if name := strings.TrimSuffix(f.Name(), ".json"); !f.IsDir() && name != f.Name() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateListenerTemplates loads every saved listener template and returns the number of templates checked with the
+// error for each template that could not be loaded, by its file path
+func ValidateListenerTemplates() (int, map[string]error) {
+	errs := make(map[string]error)
+	names := listenerTemplates()
+	for _, name := range names {
+		if _, err := loadListenerTemplate(name); err != nil {
+			errs[filepath.Join(listenerTemplateDir, name+".json")] = err
+		}
+	}
+	return len(names), errs
+}
+
+// listenerSource returns the options of a listener started from the listeners menu by its ID, or of a template by its name
+func listenerSource(name string) (listenerConfig, error) {
+	listeners.Lock()
+	for _, s := range listeners.servers {
+		if s.ID.String() == name {
+			listeners.Unlock()
+			l := listenerConfig{Protocol: s.config.Protocol, Options: append([]listenerOption(nil), s.config.Options...)}
+			return l, nil
+		}
+	}
+	listeners.Unlock()
+	return loadListenerTemplate(name)
+}
+
+// menuListenerClone saves the options of a listener or template as a new template and configures a listener with it
+func menuListenerClone(cmd []string) {
+	if len(cmd) != 2 {
+		message("warn", "Invalid 'clone' command; use clone <listener ID|template> <new template>")
+		return
+	}
+	l, err := listenerSource(cmd[0])
+	if err != nil {
+		message("warn", err.Error())
+		return
+	}
+	if inSlice(cmd[1], listenerTemplates()) {
+		message("warn", fmt.Sprintf("The %s listener template already exists; use 'templates remove %s' first", cmd[1], cmd[1]))
+		return
+	}
+	if err = saveListenerTemplate(cmd[1], l); err != nil {
+		message("warn", err.Error())
+		return
+	}
+	message("success", fmt.Sprintf("Saved the %s listener template from %s", cmd[1], cmd[0]))
+	useListener(l, cmd[1])
+}
+
+// menuListenerTemplates lists the saved listener templates or removes one
+func menuListenerTemplates(cmd []string) {
+	if len(cmd) > 0 && cmd[0] == "remove" {
+		if len(cmd) != 2 || !listenerTemplateName.MatchString(cmd[1]) {
+			message("warn", "Invalid 'templates remove' command; use templates remove <template>")
+			return
+		}
+		if err := os.Remove(filepath.Join(listenerTemplateDir, cmd[1]+".json")); err != nil {
+			if os.IsNotExist(err) {
+				message("warn", fmt.Sprintf("There is no %s listener template", cmd[1]))
+			} else {
+				message("warn", fmt.Sprintf("There was an error removing the %s listener template:\r\n%s", cmd[1], err.Error()))
+			}
+			return
+		}
+		message("success", fmt.Sprintf("Removed the %s listener template", cmd[1]))
+		return
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Template", "Type", "Options"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, name := range listenerTemplates() {
+		l, err := loadListenerTemplate(name)
+		if err != nil {
+			message("warn", err.Error())
+			continue
+		}
+		var options []string
+		for _, o := range l.Options {
+			if o.Name != "PSK" && o.Value != "" {
+				options = append(options, o.Name+"="+o.Value)
+			}
+		}
+		table.Append([]string{name, l.Protocol, strings.Join(options, " ")})
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
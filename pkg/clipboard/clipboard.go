@@ -0,0 +1,72 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package clipboard places text, such as agent IDs, file paths, and stager one-liners, on the operator's clipboard
+package clipboard
+
+import (
+	// Standard
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Disabled prevents anything from being copied to the clipboard, such as on hardened operator hosts
+var Disabled = false
+
+// tools are the clipboard programs tried in order for each operating system
+var tools = map[string][][]string{
+	"darwin":  {{"pbcopy"}},
+	"linux":   {{"wl-copy"}, {"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}},
+	"windows": {{"clip.exe"}},
+}
+
+// Copy places the text on the clipboard with the operating system's clipboard program. When one is not installed, such
+// as over SSH, the text is sent to the terminal with an OSC 52 escape sequence that most terminal emulators support.
+func Copy(text string) error {
+	if Disabled {
+		return errors.New("the clipboard is disabled; restart the server without -no-clipboard to use it")
+	}
+	for _, tool := range tools[runtime.GOOS] {
+		if tool[0] == "wl-copy" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			continue
+		}
+		path, err := exec.LookPath(tool[0])
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(path, tool[1:]...) // #nosec G204
+		cmd.Stdin = strings.NewReader(text)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("there was an error copying to the clipboard with %s:\r\n%s %s", tool[0], err.Error(), out)
+		}
+		return nil
+	}
+	return CopyTerminal(os.Stdout, text)
+}
+
+// CopyTerminal sends the text to the terminal written to by w with an OSC 52 escape sequence so that it is placed on
+// the clipboard of the host the terminal is running on
+func CopyTerminal(w io.Writer, text string) error {
+	_, err := fmt.Fprintf(w, "\033]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(text)))
+	return err
+}
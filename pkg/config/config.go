@@ -0,0 +1,171 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package config obfuscates the configuration that is embedded in a generated agent so the C2 URL, PSK, and other
+// settings are not stored as plain text strings, and extracts it again from an agent file for bookkeeping. The
+// configuration is encrypted with AES-GCM, but the key is embedded with it, so this is obfuscation only: it does not
+// keep the settings secret from anyone who has the agent.
+package config
+
+import (
+	// Standard
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/profiles"
+)
+
+// version identifies the format of an encrypted configuration
+const version = 1
+
+// headerSize is the size of the version and ciphertext length that begin an encrypted configuration; it is a
+// multiple of 3 so the header is always the first 4 base64 characters
+const headerSize = 3
+
+// keySize is the size of the AES-256 key stored in an encrypted configuration
+const keySize = 32
+
+// Config is the configuration embedded in a generated agent
+type Config struct {
+	URL        string            `json:"url"`
+	PSK        string            `json:"psk"`
+	Proxy      string            `json:"proxy,omitempty"`
+	Host       string            `json:"host,omitempty"`
+	Protocol   string            `json:"protocol,omitempty"`
+	UserAgent  string            `json:"useragent,omitempty"`
+	Sleep      string            `json:"sleep,omitempty"`      // The time the agent sleeps between check ins (i.e. 30s)
+	Rotate     string            `json:"rotate,omitempty"`     // How long the agent uses one of its comma separated URLs
+	Watermark  string            `json:"watermark,omitempty"`  // The Watermark encrypted with the server's watermark key
+	Profile    *profiles.Profile `json:"profile,omitempty"`    // Shapes the agent's HTTP messages to match its listener
+	Pins       []string          `json:"pins,omitempty"`       // Certificate public key pins the agent accepts from listeners
+	Crash      bool              `json:"crash,omitempty"`      // Send a crash report when the agent recovers from a panic
+	ClientCert string            `json:"clientcert,omitempty"` // The PEM client certificate and key the agent presents to listeners
+}
+
+// Encrypt returns the configuration encrypted with a new random AES-256-GCM key as a base64 string. The string holds
+// the version, ciphertext length, key, nonce, and ciphertext so an agent can decrypt it at runtime without any other
+// input. Because the key is in the same string, this only keeps the values out of a static strings listing and
+// signatures written for them; anyone with the agent can decrypt them the same way Extract does.
+func Encrypt(c Config) (string, error) {
+	plaintext, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("there was an error marshaling the agent configuration:\r\n%s", err.Error())
+	}
+
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", fmt.Errorf("there was an error generating the configuration key:\r\n%s", err.Error())
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("there was an error generating the configuration nonce:\r\n%s", err.Error())
+	}
+	ciphertext := aead.Seal(nil, nonce, plaintext, nil)
+	if len(ciphertext) > 0xFFFF {
+		return "", fmt.Errorf("the agent configuration is too large to embed at %d bytes", len(ciphertext))
+	}
+
+	blob := make([]byte, headerSize, headerSize+len(key)+len(nonce)+len(ciphertext))
+	blob[0] = version
+	binary.BigEndian.PutUint16(blob[1:], uint16(len(ciphertext)))
+	blob = append(blob, key...)
+	blob = append(blob, nonce...)
+	blob = append(blob, ciphertext...)
+	return base64.StdEncoding.EncodeToString(blob), nil
+}
+
+// Decrypt returns the configuration from a string created by Encrypt
+func Decrypt(encrypted string) (Config, error) {
+	var c Config
+	blob, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return c, fmt.Errorf("there was an error decoding the agent configuration:\r\n%s", err.Error())
+	}
+	if len(blob) < headerSize+keySize || blob[0] != version {
+		return c, errors.New("the agent configuration is not a supported format")
+	}
+
+	aead, err := newAEAD(blob[headerSize : headerSize+keySize])
+	if err != nil {
+		return c, err
+	}
+	nonce := blob[headerSize+keySize:]
+	if len(nonce) < aead.NonceSize() {
+		return c, errors.New("the agent configuration is not a supported format")
+	}
+	ciphertext := nonce[aead.NonceSize():]
+	nonce = nonce[:aead.NonceSize()]
+	if len(ciphertext) != int(binary.BigEndian.Uint16(blob[1:headerSize])) {
+		return c, errors.New("the agent configuration length does not match its header")
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return c, fmt.Errorf("there was an error decrypting the agent configuration:\r\n%s", err.Error())
+	}
+	if err := json.Unmarshal(plaintext, &c); err != nil {
+		return c, fmt.Errorf("there was an error unmarshaling the agent configuration:\r\n%s", err.Error())
+	}
+	return c, nil
+}
+
+// Extract searches the contents of a generated agent file for an encrypted configuration and returns it. Go stores
+// string constants next to each other without a terminator, so every offset is checked for a valid header.
+func Extract(data []byte) (Config, error) {
+	header := make([]byte, headerSize)
+	for i := 0; i+4 <= len(data); i++ {
+		if n, err := base64.StdEncoding.Decode(header, data[i:i+4]); err != nil || n != headerSize || header[0] != version {
+			continue
+		}
+		ciphertextSize := int(binary.BigEndian.Uint16(header[1:]))
+		// The size of the GCM nonce and tag are both fixed at 12 and 16 bytes
+		size := base64.StdEncoding.EncodedLen(headerSize + keySize + 12 + ciphertextSize)
+		if ciphertextSize < 16 || i+size > len(data) {
+			continue
+		}
+		c, err := Decrypt(string(data[i : i+size]))
+		if err == nil {
+			return c, nil
+		}
+	}
+	return Config{}, errors.New("an encrypted agent configuration was not found")
+}
+
+// newAEAD returns an AES-GCM cipher for the provided key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error creating the configuration cipher:\r\n%s", err.Error())
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error creating the configuration cipher:\r\n%s", err.Error())
+	}
+	return aead, nil
+}
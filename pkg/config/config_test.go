@@ -0,0 +1,115 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	// Standard
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestEncryptDecrypt verifies an encrypted configuration decrypts to the original values
+func TestEncryptDecrypt(t *testing.T) {
+	c := Config{URL: "https://127.0.0.1:443", PSK: "merlin", Protocol: "h2", UserAgent: "Mozilla/5.0",
+		Pins: []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}}
+	encrypted, err := Encrypt(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := Decrypt(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decrypted, c) {
+		t.Errorf("the decrypted configuration %+v does not match %+v", decrypted, c)
+	}
+}
+
+// TestExtract verifies an encrypted configuration is found when it is surrounded by other string data
+func TestExtract(t *testing.T) {
+	c := Config{URL: "https://192.168.1.100:8443", PSK: "hackThePlanet", Protocol: "https"}
+	encrypted, err := Encrypt(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("\x00\x01runtimeGetVersionAAAA+/" + encrypted + "ws2_32.dllTypeAssert\x00")
+	extracted, err := Extract(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(extracted, c) {
+		t.Errorf("the extracted configuration %+v does not match %+v", extracted, c)
+	}
+
+	if _, err := Extract([]byte("no configuration here" + encrypted[:40])); err == nil {
+		t.Error("a configuration was extracted from data that does not contain a complete one")
+	}
+}
+
+// TestWatermark verifies a watermark can only be decrypted with the key it was encrypted with
+func TestWatermark(t *testing.T) {
+	key := bytes.Repeat([]byte{0x41}, keySize)
+	w := Watermark{Engagement: "OP-42", Operator: "Russel", Timestamp: time.Now().UTC().Truncate(time.Second)}
+	encrypted, err := EncryptWatermark(w, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := DecryptWatermark(encrypted, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted != w {
+		t.Errorf("the decrypted watermark %+v does not match %+v", decrypted, w)
+	}
+	if _, err := DecryptWatermark(encrypted, bytes.Repeat([]byte{0x42}, keySize)); err == nil {
+		t.Error("the watermark was decrypted with the wrong key")
+	}
+}
+
+// TestWatermarkKey verifies the server fails closed when no watermark key exists and only a build creates one
+func TestWatermarkKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // #nosec G104
+	path := filepath.Join(dir, "watermark.key")
+
+	if _, err := WatermarkKey(path); err == nil {
+		t.Fatal("a watermark key was returned when none was configured")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("reading a missing watermark key created the key file")
+	}
+	key, err := NewWatermarkKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := WatermarkKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, read) {
+		t.Error("the watermark key read from the file does not match the key that was created")
+	}
+}
@@ -0,0 +1,135 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	// Standard
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Watermark attributes a generated agent to the engagement and operator that built it. It is encrypted with the
+// server's watermark key, so the agent carries it without being able to read it and only the server can attribute it.
+type Watermark struct {
+	Engagement string    `json:"engagement"`
+	Operator   string    `json:"operator"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// String returns the watermark as a single line for tables and logs
+func (w Watermark) String() string {
+	return fmt.Sprintf("engagement: %s, operator: %s, built: %s", w.Engagement, w.Operator, w.Timestamp.UTC().Format(time.RFC3339))
+}
+
+// EncryptWatermark returns the watermark encrypted with AES-256-GCM and the provided key as a base64 string
+func EncryptWatermark(w Watermark, key []byte) (string, error) {
+	plaintext, err := json.Marshal(w)
+	if err != nil {
+		return "", fmt.Errorf("there was an error marshaling the watermark:\r\n%s", err.Error())
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("there was an error generating the watermark nonce:\r\n%s", err.Error())
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// DecryptWatermark returns the watermark from a string created by EncryptWatermark with the same key
+func DecryptWatermark(encrypted string, key []byte) (Watermark, error) {
+	var w Watermark
+	blob, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return w, fmt.Errorf("there was an error decoding the watermark:\r\n%s", err.Error())
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return w, err
+	}
+	if len(blob) < aead.NonceSize() {
+		return w, errors.New("the watermark is not a supported format")
+	}
+	plaintext, err := aead.Open(nil, blob[:aead.NonceSize()], blob[aead.NonceSize():], nil)
+	if err != nil {
+		return w, errors.New("the watermark could not be decrypted with this server's watermark key")
+	}
+	if err := json.Unmarshal(plaintext, &w); err != nil {
+		return w, fmt.Errorf("there was an error unmarshaling the watermark:\r\n%s", err.Error())
+	}
+	return w, nil
+}
+
+// WatermarkKey reads the watermark key from the provided file. The server only reads the key and never creates it so
+// that a missing key leaves agent watermarks unattributed instead of silently trusting a new key.
+func WatermarkKey(path string) ([]byte, error) {
+	key, err := ioutil.ReadFile(path) // #nosec G304 - The key file is provided by the operator
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no watermark key is configured at %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("there was an error reading the watermark key %s:\r\n%s", path, err.Error())
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("the watermark key %s is %d bytes instead of %d", path, len(key), keySize)
+	}
+	return key, nil
+}
+
+// NewWatermarkKey reads the watermark key from the provided file and creates the file with a new random key if it
+// does not exist. It is only used when building agents; the Make file and the server must use the same key file.
+func NewWatermarkKey(path string) ([]byte, error) {
+	return Key(path, "watermark")
+}
+
+// Key reads a 32 byte key from the provided file and creates the file with a new random key if it does not exist. The
+// name describes what the key is used for in error messages.
+func Key(path string, name string) ([]byte, error) {
+	key, err := ioutil.ReadFile(path) // #nosec G304 - The key file is provided by the operator
+	if err == nil {
+		if len(key) != keySize {
+			return nil, fmt.Errorf("the %s key %s is %d bytes instead of %d", name, path, len(key), keySize)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("there was an error reading the %s key %s:\r\n%s", name, path, err.Error())
+	}
+
+	key = make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("there was an error generating the %s key:\r\n%s", name, err.Error())
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, fmt.Errorf("there was an error creating the %s key directory:\r\n%s", name, err.Error())
+	}
+	if err := ioutil.WriteFile(path, key, 0600); err != nil {
+		return nil, fmt.Errorf("there was an error writing the %s key %s:\r\n%s", name, path, err.Error())
+	}
+	return key, nil
+}
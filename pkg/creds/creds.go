@@ -0,0 +1,305 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package creds parses credentials, such as mimikatz output, hash dumps, and /etc/shadow files, from agent job results
+// into a credential store that is saved to disk so the operator can list, search, and export them
+package creds
+
+import (
+	// Standard
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+)
+
+// Credential types
+const (
+	Password = "password" // A plaintext password
+	NTLM     = "ntlm"     // A Windows NT hash
+	Crypt    = "crypt"    // A Unix crypt(3) hash from a shadow file
+)
+
+// emptyNTLM is the NT hash of an empty password; it is not stored because it can not be used
+const emptyNTLM = "31d6cfe0d16ae931b73c59d7e0c089c0"
+
+// File is the file the credential store is saved to; it is kept with the database so backups include it
+var File = filepath.Join(core.CurrentDir, "data", "db", "creds.json")
+
+// Credential is a user's password or hash and where it was found
+type Credential struct {
+	ID     int       `json:"id"`
+	Type   string    `json:"type"`
+	Domain string    `json:"domain,omitempty"`
+	User   string    `json:"user"`
+	Secret string    `json:"secret"`
+	Host   string    `json:"host,omitempty"`  // The host the credential was harvested from
+	Agent  string    `json:"agent,omitempty"` // The agent that returned the credential; empty if it was added manually
+	Source string    `json:"source"`          // What the credential was parsed from (i.e. mimikatz, hashdump, shadow, or manual)
+	Time   time.Time `json:"time"`
+}
+
+var (
+	mimikatzField = regexp.MustCompile(`^\s*\*\s*(Username|Domain|Password|NTLM)\s*:\s*(.*?)\s*$`)
+	samUser       = regexp.MustCompile(`^User\s*:\s*(.+?)\s*$`)
+	samHash       = regexp.MustCompile(`^\s*Hash NTLM\s*:\s*([0-9a-fA-F]{32})\s*$`)
+	hashdump      = regexp.MustCompile(`^([^:\s]+):\d+:[0-9a-fA-F]{32}:([0-9a-fA-F]{32}):::`)
+	shadow        = regexp.MustCompile(`^([a-z_][a-zA-Z0-9_.-]*\$?):(\$[^:\s]+):`)
+)
+
+// store is the credential store and the last ID given to a credential
+var store = struct {
+	sync.Mutex
+	loaded bool
+	last   int
+	list   []Credential
+}{}
+
+// Parse returns the credentials found in job output: mimikatz sekurlsa::logonpasswords and lsadump::sam output, pwdump
+// style hash dumps, and /etc/shadow entries with a password hash
+func Parse(output string) []Credential {
+	var list []Credential
+	var user, domain, samName string
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if m := mimikatzField.FindStringSubmatch(line); m != nil {
+			switch m[1] {
+			case "Username":
+				user, domain = m[2], ""
+			case "Domain":
+				domain = m[2]
+			case "Password":
+				if user != "" && user != "(null)" && m[2] != "" && m[2] != "(null)" {
+					list = append(list, Credential{Type: Password, Domain: domain, User: user, Secret: m[2], Source: "mimikatz"})
+				}
+			case "NTLM":
+				if user != "" && user != "(null)" && !strings.EqualFold(m[2], emptyNTLM) {
+					list = append(list, Credential{Type: NTLM, Domain: domain, User: user, Secret: strings.ToLower(m[2]), Source: "mimikatz"})
+				}
+			}
+			continue
+		}
+		if m := samUser.FindStringSubmatch(line); m != nil {
+			samName = m[1]
+			continue
+		}
+		if m := samHash.FindStringSubmatch(line); m != nil {
+			if samName != "" && !strings.EqualFold(m[1], emptyNTLM) {
+				list = append(list, Credential{Type: NTLM, User: samName, Secret: strings.ToLower(m[1]), Source: "mimikatz"})
+			}
+			continue
+		}
+		if m := hashdump.FindStringSubmatch(line); m != nil {
+			if !strings.EqualFold(m[2], emptyNTLM) {
+				list = append(list, Credential{Type: NTLM, User: m[1], Secret: strings.ToLower(m[2]), Source: "hashdump"})
+			}
+			continue
+		}
+		if m := shadow.FindStringSubmatch(line); m != nil {
+			list = append(list, Credential{Type: Crypt, User: m[1], Secret: m[2], Source: "shadow"})
+		}
+	}
+	return list
+}
+
+// Add adds the credentials that are not already in the store, tagging them with the agent, host, and the current time,
+// saves the store, and returns the credentials that were added
+func Add(agent string, host string, list []Credential) ([]Credential, error) {
+	store.Lock()
+	defer store.Unlock()
+	if err := load(); err != nil {
+		return nil, err
+	}
+	var added []Credential
+	now := time.Now().UTC()
+	for _, c := range list {
+		if exists(c) {
+			continue
+		}
+		store.last++
+		c.ID = store.last
+		c.Agent = agent
+		if c.Host == "" {
+			c.Host = host
+		}
+		if c.Source == "" {
+			c.Source = "manual"
+		}
+		c.Time = now
+		store.list = append(store.list, c)
+		added = append(added, c)
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+	return added, save()
+}
+
+// exists returns true if the store already has the credential's user and secret
+func exists(c Credential) bool {
+	for _, s := range store.list {
+		if s.Type == c.Type && s.Secret == c.Secret && strings.EqualFold(s.User, c.User) &&
+			strings.EqualFold(s.Domain, c.Domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Type returns the credential type of a secret entered by the operator: an NT hash, a crypt hash, or a password
+func Type(secret string) string {
+	switch {
+	case len(secret) == 32 && strings.Trim(strings.ToLower(secret), "0123456789abcdef") == "":
+		return NTLM
+	case strings.HasPrefix(secret, "$") && strings.Count(secret, "$") >= 3:
+		return Crypt
+	}
+	return Password
+}
+
+// List returns every credential in the store, or only those from the agent if it is not empty
+func List(agent string) ([]Credential, error) {
+	store.Lock()
+	defer store.Unlock()
+	if err := load(); err != nil {
+		return nil, err
+	}
+	list := make([]Credential, 0)
+	for _, c := range store.list {
+		if agent == "" || strings.EqualFold(c.Agent, agent) {
+			list = append(list, c)
+		}
+	}
+	return list, nil
+}
+
+// Get returns the credential with the ID
+func Get(id int) (Credential, error) {
+	store.Lock()
+	defer store.Unlock()
+	if err := load(); err != nil {
+		return Credential{}, err
+	}
+	for _, c := range store.list {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return Credential{}, fmt.Errorf("there is no credential with ID %d in the credential store", id)
+}
+
+// Search returns the credentials whose user, domain, host, type, source, or agent contains the term, ignoring case
+func Search(term string) ([]Credential, error) {
+	store.Lock()
+	defer store.Unlock()
+	if err := load(); err != nil {
+		return nil, err
+	}
+	term = strings.ToLower(term)
+	list := make([]Credential, 0)
+	for _, c := range store.list {
+		for _, field := range []string{c.User, c.Domain, c.Host, c.Type, c.Source, c.Agent} {
+			if strings.Contains(strings.ToLower(field), term) {
+				list = append(list, c)
+				break
+			}
+		}
+	}
+	return list, nil
+}
+
+// Export writes every credential to the file as CSV if it has a .csv extension or as JSON otherwise
+func Export(file string) error {
+	list, err := List("")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600) // #nosec G304 the operator chooses the file
+	if err != nil {
+		return fmt.Errorf("there was an error creating the export file %s:\r\n%s", file, err.Error())
+	}
+	defer f.Close() // #nosec G307
+	if strings.EqualFold(filepath.Ext(file), ".csv") {
+		w := csv.NewWriter(f)
+		records := [][]string{{"id", "type", "domain", "user", "secret", "host", "agent", "source", "time"}}
+		for _, c := range list {
+			records = append(records, []string{strconv.Itoa(c.ID), c.Type, c.Domain, c.User, c.Secret, c.Host, c.Agent,
+				c.Source, c.Time.Format(time.RFC3339)})
+		}
+		if err = w.WriteAll(records); err != nil {
+			return fmt.Errorf("there was an error writing the export file %s:\r\n%s", file, err.Error())
+		}
+		return nil
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(list); err != nil {
+		return fmt.Errorf("there was an error writing the export file %s:\r\n%s", file, err.Error())
+	}
+	return nil
+}
+
+// load reads the store from its file the first time it is used; a missing file is an empty store
+func load() error {
+	if store.loaded {
+		return nil
+	}
+	data, err := ioutil.ReadFile(File)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("there was an error reading the credential store %s:\r\n%s", File, err.Error())
+	}
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &store.list); err != nil {
+			return fmt.Errorf("there was an error parsing the credential store %s:\r\n%s", File, err.Error())
+		}
+	}
+	for _, c := range store.list {
+		if c.ID > store.last {
+			store.last = c.ID
+		}
+	}
+	store.loaded = true
+	return nil
+}
+
+// save writes the store to its file
+func save() error {
+	data, err := json.MarshalIndent(store.list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("there was an error marshalling the credential store:\r\n%s", err.Error())
+	}
+	if err = os.MkdirAll(filepath.Dir(File), 0750); err != nil {
+		return fmt.Errorf("there was an error creating the credential store directory:\r\n%s", err.Error())
+	}
+	if err = ioutil.WriteFile(File, data, 0600); err != nil {
+		return fmt.Errorf("there was an error writing the credential store %s:\r\n%s", File, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package creds
+
+import (
+	// Standard
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestParse verifies credentials are parsed from mimikatz output, hash dumps, and shadow files, stored once, and searched
+func TestParse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "creds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	File = filepath.Join(dir, "creds.json")
+
+	output := `Authentication Id : 0 ; 515764 (00000000:0007deb4)
+User Name         : Gentil Kiwi
+	msv :
+	 [00000003] Primary
+	 * Username : Gentil Kiwi
+	 * Domain   : vm-w7-ult-x
+	 * LM       : d0e9aee149655a6075e4540af1f22d3b
+	 * NTLM     : cc36cf7a8514893efccd332446158b1a
+	wdigest :
+	 * Username : Gentil Kiwi
+	 * Domain   : vm-w7-ult-x
+	 * Password : waza1234/
+	kerberos :
+	 * Username : (null)
+	 * Domain   : (null)
+	 * Password : (null)
+RID  : 000001f5 (501)
+User : Guest
+  Hash NTLM: 31d6cfe0d16ae931b73c59d7e0c089c0
+Administrator:500:aad3b435b51404eeaad3b435b51404ee:8846f7eaee8fb117ad06bdd830b7586c:::
+root:$6$salt$IxDD3jeSOb5eB1CX5LBsqZFVkJdido3OUILO5Ifz5iwMuTS4XMS130MTSuDDl3aCI6WouIL9AjRbLCelDCy.g.:18000:0:99999:7:::
+daemon:*:18000:0:99999:7:::`
+
+	list := Parse(output)
+	want := []Credential{
+		{Type: NTLM, Domain: "vm-w7-ult-x", User: "Gentil Kiwi", Secret: "cc36cf7a8514893efccd332446158b1a", Source: "mimikatz"},
+		{Type: Password, Domain: "vm-w7-ult-x", User: "Gentil Kiwi", Secret: "waza1234/", Source: "mimikatz"},
+		{Type: NTLM, User: "Administrator", Secret: "8846f7eaee8fb117ad06bdd830b7586c", Source: "hashdump"},
+		{Type: Crypt, User: "root", Secret: "$6$salt$IxDD3jeSOb5eB1CX5LBsqZFVkJdido3OUILO5Ifz5iwMuTS4XMS130MTSuDDl3aCI6WouIL9AjRbLCelDCy.g.", Source: "shadow"},
+	}
+	if len(list) != len(want) {
+		t.Fatalf("parsed %d credentials instead of %d: %+v", len(list), len(want), list)
+	}
+	for i, c := range list {
+		if c != want[i] {
+			t.Errorf("parsed %+v instead of %+v", c, want[i])
+		}
+	}
+
+	agent := "c1090dbc-f2f7-4d90-a241-86e0c0217786"
+	added, err := Add(agent, "WKSTN-1", list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != len(want) || added[0].ID != 1 || added[0].Host != "WKSTN-1" {
+		t.Errorf("added %+v", added)
+	}
+	if added, err = Add(agent, "WKSTN-1", list[:1]); err != nil || len(added) != 0 {
+		t.Errorf("a duplicate credential was added: %+v %v", added, err)
+	}
+
+	// Load the store from its file again
+	store.loaded, store.list, store.last = false, nil, 0
+	found, err := Search("kiwi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Errorf("found %d credentials for kiwi instead of 2", len(found))
+	}
+	if added, err = Add("", "", []Credential{{Type: Type("Summer2019!"), User: "bob", Secret: "Summer2019!"}}); err != nil || len(added) != 1 || added[0].ID != 5 || added[0].Source != "manual" {
+		t.Errorf("the manual credential was not added after the last ID: %+v %v", added, err)
+	}
+}
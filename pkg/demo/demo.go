@@ -0,0 +1,513 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package demo runs simulated agents inside the server that respond to common commands with canned output so operators
+// can learn the command line interface and instructors can demonstrate workflows without real hosts or network traffic
+package demo
+
+import (
+	// Standard
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/fatih/color"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg"
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+	"github.com/Ne0nd0g/merlin/pkg/schedule"
+)
+
+// host is the fake system information a simulated agent reports
+type host struct {
+	name         string
+	platform     string
+	user         string
+	guid         string
+	ips          []string
+	interpreters []string
+	home         string
+}
+
+// hosts are the fake hosts assigned to simulated agents in order
+var hosts = []host{
+	{"DEMO-WS01", "windows", `CORP\alice`, "S-1-5-21-1004336348-1177238915-682003330-1104",
+		[]string{"10.10.20.11"}, nil, `C:\Users\alice`},
+	{"demo-web01", "linux", "www-data", "33",
+		[]string{"10.10.30.5", "172.17.0.1"}, []string{"python", "node"}, "/var/www"},
+	{"demo-mac01", "darwin", "carol", "501",
+		[]string{"10.10.20.40"}, []string{"python", "osascript"}, "/Users/carol"},
+	{"DEMO-DC01", "windows", `CORP\svc_backup`, "S-1-5-21-1004336348-1177238915-682003330-1109",
+		[]string{"10.10.10.2"}, nil, `C:\Windows\system32`},
+	{"demo-db01", "linux", "postgres", "113",
+		[]string{"10.10.30.12"}, []string{"python"}, "/var/lib/postgresql"},
+}
+
+// agent is a simulated agent and the configuration the server can change
+type agent struct {
+	id         uuid.UUID
+	host       host
+	pid        int
+	cwd        string
+	waitTime   time.Duration
+	skew       int64
+	jitter     int
+	hours      *schedule.Hours
+	paddingMax int
+	maxRetry   int
+	killDate   int64
+	started    time.Time
+}
+
+// Start creates the number of simulated agents, each checking in with the server at the interval, and returns their IDs
+func Start(count int, interval time.Duration) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	for i := 0; i < count; i++ {
+		h := hosts[i%len(hosts)]
+		if i >= len(hosts) {
+			h.name = fmt.Sprintf("%s-%d", h.name, i/len(hosts)+1)
+		}
+		a := &agent{
+			id:         uuid.NewV4(),
+			host:       h,
+			pid:        1000 + rand.Intn(8000), // #nosec G404 - Fake process IDs do not need to be secure
+			cwd:        h.home,
+			waitTime:   interval,
+			skew:       0,
+			paddingMax: 4096,
+			maxRetry:   7,
+			started:    time.Now(),
+		}
+		if err := agents.NewSimulated(a.id); err != nil {
+			return ids, fmt.Errorf("there was an error creating simulated agent %d:\r\n%s", i+1, err.Error())
+		}
+		agents.Log(a.id, "Simulated demo mode agent; it is not running on a real host")
+		a.send(a.info())
+		ids = append(ids, a.id)
+		go a.run()
+	}
+	return ids, nil
+}
+
+// run checks in with the server and responds to jobs until the agent is killed
+func (a *agent) run() {
+	for {
+		time.Sleep(a.sleepTime())
+		m, err := agents.StatusCheckIn(messages.Base{Version: 1.0, ID: a.id, Type: "StatusCheckIn", Payload: a.health()})
+		if err != nil {
+			message("warn", fmt.Sprintf("simulated agent %s check in error: %s", a.id, err.Error()))
+			continue
+		}
+		if m.Type == "AgentControl" && m.Payload.(messages.AgentControl).Command == "kill" {
+			if err := agents.RemoveAgent(a.id); err != nil {
+				message("warn", err.Error())
+			}
+			message("info", fmt.Sprintf("Simulated agent %s was removed from the server", a.id))
+			return
+		}
+		if m.Type != "ServerOk" {
+			a.send(a.handle(m))
+		}
+		if m.Type == "SelfDestruct" {
+			message("info", fmt.Sprintf("Simulated agent %s self-destructed and stopped checking in", a.id))
+			return
+		}
+	}
+}
+
+// send delivers the agent's message to the server the same way the listener does after decrypting it
+func (a *agent) send(m messages.Base) {
+	var err error
+	switch m.Type {
+	case "AgentInfo":
+		err = agents.UpdateInfo(m)
+	case "CmdResults":
+		err = agents.JobResults(m)
+	case "FileTransfer":
+		err = agents.FileTransfer(m)
+	case "UserSessions":
+		err = agents.UserSessions(m)
+	}
+	if err != nil {
+		message("warn", fmt.Sprintf("simulated agent %s message error: %s", a.id, err.Error()))
+	}
+}
+
+// health returns the fake resource usage the agent reports with its status check ins
+func (a *agent) health() messages.Health {
+	return messages.Health{
+		CPU:        rand.Float64(),                    // #nosec G404 - Fake usage does not need to be secure
+		Memory:     uint64(11<<20 + rand.Intn(2<<20)), // #nosec G404 - Fake usage does not need to be secure
+		Heap:       uint64(3<<20 + rand.Intn(1<<20)),  // #nosec G404 - Fake usage does not need to be secure
+		Goroutines: 8,
+		Uptime:     time.Since(a.started).Round(time.Second).String(),
+	}
+}
+
+// info returns the agent's AgentInfo message
+func (a *agent) info() messages.Base {
+	_, offset := time.Now().Zone()
+	return messages.Base{
+		Version: 1.0,
+		ID:      a.id,
+		Type:    "AgentInfo",
+		Payload: messages.AgentInfo{
+			Version:      merlin.Version,
+			Build:        "demo",
+			WaitTime:     a.waitTime.String(),
+			PaddingMax:   a.paddingMax,
+			MaxRetry:     a.maxRetry,
+			Skew:         a.skew,
+			Jitter:       a.jitter,
+			Proto:        "demo",
+			KillDate:     a.killDate,
+			WorkingHours: a.hours.String(),
+			UTCOffset:    offset,
+			SysInfo: messages.SysInfo{
+				Platform:     a.host.platform,
+				Architecture: "amd64",
+				UserName:     a.host.user,
+				UserGUID:     a.host.guid,
+				HostName:     a.host.name,
+				Pid:          a.pid,
+				Ips:          a.host.ips,
+				Interpreters: a.host.interpreters,
+			},
+		},
+	}
+}
+
+// handle returns the agent's response to a job message from the server
+func (a *agent) handle(m messages.Base) messages.Base {
+	var c messages.CmdResults
+	switch m.Type {
+	case "CmdPayload":
+		p := m.Payload.(messages.CmdPayload)
+		c.Job = p.Job
+		c.Stdout, c.Stderr = a.command(p.Command, p.Args)
+	case "NativeCmd":
+		p := m.Payload.(messages.NativeCmd)
+		c.Job = p.Job
+		switch p.Command {
+		case "ls":
+			c.Stdout = a.list(p.Args)
+		case "cd":
+			a.cwd = a.join(p.Args)
+			c.Stdout = fmt.Sprintf("Changed working directory to %s", a.cwd)
+		case "pwd":
+			c.Stdout = fmt.Sprintf("Current working directory: %s", a.cwd)
+		default:
+			c.Stderr = fmt.Sprintf("%s is not a valid NativeCMD type", p.Command)
+		}
+	case "AgentControl":
+		p := m.Payload.(messages.AgentControl)
+		a.control(p)
+		return a.info()
+	case "FileTransfer":
+		p := m.Payload.(messages.FileTransfer)
+		c.Job = p.Job
+		if p.IsDownload {
+			c.Stdout = fmt.Sprintf("Successfully uploaded file to %s on agent %s", p.FileLocation, a.id)
+			break
+		}
+		data := fmt.Sprintf("Simulated contents of %s from %s in Merlin demo mode\n", p.FileLocation, a.host.name)
+		return messages.Base{
+			Version: 1.0,
+			ID:      a.id,
+			Type:    "FileTransfer",
+			Payload: messages.FileTransfer{
+				FileLocation: p.FileLocation,
+				FileBlob:     base64.StdEncoding.EncodeToString([]byte(data)),
+				IsDownload:   true,
+				Job:          p.Job,
+			},
+		}
+	case "Module":
+		p := m.Payload.(messages.Module)
+		if p.Command == "sessions-enum" || p.Command == "loggedon" {
+			return a.sessions(p)
+		}
+		c.Job = p.Job
+		c.Stderr = fmt.Sprintf("the %s module is not available in demo mode", p.Command)
+	case "Shellcode":
+		c.Job = m.Payload.(messages.Shellcode).Job
+		c.Stdout = "Shellcode module executed without errors"
+	case "Script":
+		p := m.Payload.(messages.Script)
+		c.Job = p.Job
+		c.Stdout = fmt.Sprintf("Simulated %s script output from %s", p.Interpreter, a.host.name)
+	case "Archive":
+		p := m.Payload.(messages.Archive)
+		c.Job = p.Job
+		path := a.join(p.Archive)
+		if p.Method == "zip" {
+			c.Stdout = fmt.Sprintf("Added %d files to %s", 2*len(p.Paths), path)
+			c.Created = []string{path}
+		} else {
+			c.Stdout = fmt.Sprintf("Extracted 0 files and directories from %s to %s", path, a.join(p.Dest))
+		}
+	case "Search":
+		p := m.Payload.(messages.Search)
+		c.Job = p.Job
+		c.Stdout = a.search(p)
+	case "WasmTask":
+		c.Job = m.Payload.(messages.WasmTask).Job
+		c.Stdout = "Simulated WebAssembly module output"
+	case "BOF":
+		c.Job = m.Payload.(messages.BOF).Job
+		c.Stdout = fmt.Sprintf("Simulated BOF output from %s", a.host.name)
+	case "Move":
+		p := m.Payload.(messages.Move)
+		c.Job = p.Job
+		c.Stdout = fmt.Sprintf("Simulated %s to %s as %s from %s", p.Method, p.Host, p.User, a.host.name)
+	case "Firewall":
+		f := m.Payload.(messages.Firewall)
+		c.Job = f.Job
+		c.Stdout = fmt.Sprintf("Simulated firewall %s of rule %s on %s", f.Action, f.Name, a.host.name)
+	case "Persist":
+		p := m.Payload.(messages.Persist)
+		c.Job = p.Job
+		c.Stdout = fmt.Sprintf("Simulated %s of %s persistence %s on %s", p.Action, p.Method, p.Name, a.host.name)
+	case "SelfDestruct":
+		p := m.Payload.(messages.SelfDestruct)
+		c.Job = p.Job
+		for _, persist := range p.Persistence {
+			c.Removed = append(c.Removed, persist.Name)
+		}
+		c.Removed = append(c.Removed, p.Paths...)
+		c.Stdout = fmt.Sprintf("Simulated removal of %d persistence mechanisms and %d files from %s",
+			len(p.Persistence), len(p.Paths), a.host.name)
+	case "PowerShell":
+		c.Job = m.Payload.(messages.PowerShell).Job
+		c.Stdout = fmt.Sprintf("Simulated PowerShell output from %s", a.host.name)
+	case "Assembly":
+		c.Job = m.Payload.(messages.Assembly).Job
+		c.Stdout = "Simulated .NET assembly output"
+	default:
+		c.Stderr = fmt.Sprintf("%s is not a valid message type for a simulated agent", m.Type)
+	}
+	return messages.Base{Version: 1.0, ID: a.id, Type: "CmdResults", Payload: c}
+}
+
+// control applies an AgentControl message to the agent's configuration
+func (a *agent) control(p messages.AgentControl) {
+	switch p.Command {
+	case "sleep":
+		if t, err := time.ParseDuration(p.Args); err == nil && t > 0 {
+			a.waitTime = t
+		}
+	case "skew":
+		if t, err := strconv.ParseInt(p.Args, 10, 64); err == nil {
+			a.skew = t
+		}
+	case "padding":
+		if t, err := strconv.Atoi(p.Args); err == nil {
+			a.paddingMax = t
+		}
+	case "maxretry":
+		if t, err := strconv.Atoi(p.Args); err == nil {
+			a.maxRetry = t
+		}
+	case "killdate":
+		if d, err := strconv.Atoi(p.Args); err == nil {
+			a.killDate = int64(d)
+		}
+	case "jitter":
+		if t, err := strconv.Atoi(p.Args); err == nil && t >= 0 && t <= 100 {
+			a.jitter = t
+		}
+	case "workinghours":
+		if h, err := schedule.Parse(p.Args); err == nil {
+			a.hours = h
+		}
+	}
+}
+
+// sleepTime returns the time until the agent's next check in with its jitter and working hours, like a real agent
+func (a *agent) sleepTime() time.Duration {
+	sleep := a.waitTime
+	if spread := int64(a.waitTime) * int64(a.jitter) / 100; spread > 0 {
+		sleep += time.Duration(rand.Int63n(2*spread+1) - spread) // #nosec G404 - Simulated timing does not need to be secure
+	}
+	return time.Until(a.hours.Next(time.Now().Add(sleep)))
+}
+
+// sessions returns a UserSessions message with the domain's fake logged on users
+func (a *agent) sessions(p messages.Module) messages.Base {
+	target := a.host.name
+	if len(p.Args) > 0 && p.Args[0] != "" {
+		target = p.Args[0]
+	}
+	return messages.Base{
+		Version: 1.0,
+		ID:      a.id,
+		Type:    "UserSessions",
+		Payload: messages.UserSessions{
+			Job:    p.Job,
+			Method: p.Command,
+			Host:   target,
+			Sessions: []messages.UserSession{
+				{UserName: "alice", Domain: "CORP", SessionID: 1, Station: "Console", State: "Active", Server: "DEMO-DC01"},
+				{UserName: "bob", Domain: "CORP", SessionID: 2, Station: "RDP-Tcp#0", State: "Disconnected", Client: "DEMO-WS02", Server: "DEMO-DC01"},
+			},
+		},
+	}
+}
+
+// windows returns true if the agent is simulating a Windows host
+func (a *agent) windows() bool {
+	return a.host.platform == "windows"
+}
+
+// join returns the directory resolved against the agent's working directory
+func (a *agent) join(dir string) string {
+	if a.windows() {
+		if dir == "" || len(dir) > 1 && dir[1] == ':' {
+			return dir
+		}
+		return strings.TrimSuffix(a.cwd, `\`) + `\` + dir
+	}
+	if strings.HasPrefix(dir, "/") {
+		return path.Clean(dir)
+	}
+	return path.Join(a.cwd, dir)
+}
+
+// list returns a fake directory listing in the same format as the agent's ls command
+func (a *agent) list(dir string) string {
+	if dir == "" || dir == "./" {
+		dir = a.cwd
+	} else {
+		dir = a.join(dir)
+	}
+	modified := time.Now().Add(-72 * time.Hour).Format("2006-01-02 15:04:05")
+	files := []string{"drwxr-xr-x\t" + modified + "\t4096\tDocuments",
+		"drwxr-xr-x\t" + modified + "\t4096\tDownloads",
+		"-rw-r--r--\t" + modified + "\t2314\tnotes.txt",
+		"-rw-------\t" + modified + "\t18722\tpasswords.xlsx",
+	}
+	return fmt.Sprintf("Directory listing for: %s\r\n\r\n%s\n", dir, strings.Join(files, "\n"))
+}
+
+// search returns canned find or grep results from the fake notes and password files
+func (a *agent) search(p messages.Search) string {
+	modified := time.Now().Add(-72 * time.Hour).Format(time.RFC3339)
+	dir := strings.TrimRight(a.join(p.Path), `/\`)
+	sep := "/"
+	if a.windows() {
+		sep = `\`
+	}
+	if p.Method == "grep" {
+		return fmt.Sprintf("%s%snotes.txt:3:the vpn password is in passwords.xlsx", dir, sep)
+	}
+	return fmt.Sprintf("%s\t2314\t%s%snotes.txt\n%s\t18722\t%s%spasswords.xlsx", modified, dir, sep, modified, dir, sep)
+}
+
+// command returns the canned stdout and stderr for a shell command
+func (a *agent) command(command string, args string) (string, string) {
+	name := strings.ToLower(strings.TrimSuffix(path.Base(strings.Replace(command, `\`, "/", -1)), ".exe"))
+	// Unwrap commands run through a shell such as "cmd.exe /c whoami" or "bash -c id"
+	if (name == "cmd" || name == "powershell" || name == "bash" || name == "sh") && args != "" {
+		fields := strings.Fields(args)
+		if strings.ToLower(fields[0]) == "/c" || fields[0] == "-c" || strings.ToLower(fields[0]) == "-command" {
+			fields = fields[1:]
+		}
+		if len(fields) > 0 {
+			return a.command(fields[0], strings.Join(fields[1:], " "))
+		}
+	}
+	switch name {
+	case "whoami":
+		return strings.ToLower(a.host.user) + "\n", ""
+	case "hostname":
+		return a.host.name + "\n", ""
+	case "id":
+		if a.windows() {
+			break
+		}
+		return fmt.Sprintf("uid=%s(%s) gid=%s(%s) groups=%s(%s)\n", a.host.guid, a.host.user, a.host.guid,
+			a.host.user, a.host.guid, a.host.user), ""
+	case "uname":
+		if a.windows() {
+			break
+		}
+		if a.host.platform == "darwin" {
+			return fmt.Sprintf("Darwin %s 19.6.0 Darwin Kernel Version 19.6.0 x86_64\n", a.host.name), ""
+		}
+		return fmt.Sprintf("Linux %s 5.4.0-91-generic #102-Ubuntu SMP x86_64 GNU/Linux\n", a.host.name), ""
+	case "ipconfig", "ifconfig", "ip":
+		var out string
+		for i, ip := range a.host.ips {
+			if a.windows() {
+				out += fmt.Sprintf("Ethernet adapter Ethernet%d:\n   IPv4 Address. . . . . . . . . . . : %s\n"+
+					"   Subnet Mask . . . . . . . . . . . : 255.255.255.0\n\n", i, ip)
+			} else {
+				out += fmt.Sprintf("eth%d: flags=4163<UP,BROADCAST,RUNNING,MULTICAST>  mtu 1500\n"+
+					"        inet %s  netmask 255.255.255.0\n\n", i, ip)
+			}
+		}
+		return out, ""
+	case "ps", "tasklist":
+		if a.windows() {
+			return fmt.Sprintf("Image Name                     PID\n========================= ========\n"+
+				"System                           4\nlsass.exe                      652\nexplorer.exe                  3120\n"+
+				"merlin.exe                    %5d\n", a.pid), ""
+		}
+		return fmt.Sprintf("  PID TTY          TIME CMD\n    1 ?        00:00:04 systemd\n  812 ?        00:00:00 sshd\n"+
+			"%5d ?        00:00:00 merlin\n", a.pid), ""
+	case "netstat":
+		return "Proto  Local Address          Foreign Address        State\n" +
+			"tcp    0.0.0.0:22             0.0.0.0:*              LISTEN\n" +
+			"tcp    " + a.host.ips[0] + ":49822      10.10.0.1:443          ESTABLISHED\n", ""
+	case "net":
+		if a.windows() {
+			return "User accounts for \\\\" + a.host.name + "\n\n-------------------------------------------------------\n" +
+				"Administrator            alice                    bob\nGuest                    svc_backup\n" +
+				"The command completed successfully.\n", ""
+		}
+	case "echo":
+		return args + "\n", ""
+	}
+	return "", fmt.Sprintf("%s is not a command the demo mode agent simulates; try whoami, hostname, ipconfig, ps, or netstat",
+		command)
+}
+
+// message is used to print a message to the command line
+func message(level string, message string) {
+	switch level {
+	case "info":
+		color.Cyan("[i]" + message)
+	case "note":
+		color.Yellow("[-]" + message)
+	case "warn":
+		color.Red("[!]" + message)
+	case "debug":
+		color.Red("[DEBUG]" + message)
+	case "success":
+		color.Green("[+]" + message)
+	default:
+		color.Red("[_-_]Invalid message level: " + message)
+	}
+}
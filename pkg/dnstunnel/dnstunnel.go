@@ -0,0 +1,282 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package dnstunnel encodes the agent's HTTP messages into DNS queries and answers so the agent can communicate with a
+// DNS listener when only DNS egress is allowed.
+//
+// The agent uploads a message in A record queries named <data>.<index>-<total>.<session>.u.<domain> where the data is
+// lower case base32 split into labels. The server acknowledges each query with an A record and, after the last one,
+// processes the message and answers with the number of chunks and the length of its response. The agent downloads each
+// response chunk with a query named <index>.<session>.d.<domain> answered with TXT records containing base64 or with A
+// records that each hold an index byte followed by three data bytes.
+package dnstunnel
+
+import (
+	// Standard
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Query types that follow the session label
+const (
+	Upload   = "u"
+	Download = "d"
+)
+
+// The first byte of an A record sent in response to an upload query
+const (
+	ackPartial  = 0 // More chunks are needed
+	ackChunks   = 1 // The response has this many chunks
+	ackLength   = 2 // The response is this many bytes long
+	ackRejected = 3 // The server rejected the message
+)
+
+// MaxLength is the longest response that can be downloaded
+const MaxLength = 1<<24 - 1
+
+// maxName is the longest DNS name, without the trailing dot, that is used for a query
+const maxName = 253
+
+// encoding is the base32 encoding used for upload query labels because DNS names are not case sensitive
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ErrRejected is returned when the server did not accept an uploaded message
+var ErrRejected = errors.New("the DNS listener rejected the message")
+
+// Pack combines the message's Authorization header and body into the data uploaded to the server
+func Pack(token string, body []byte) []byte {
+	data := make([]byte, 2, 2+len(token)+len(body))
+	binary.BigEndian.PutUint16(data, uint16(len(token)))
+	return append(append(data, token...), body...)
+}
+
+// Unpack returns the Authorization header and body from uploaded data
+func Unpack(data []byte) (string, []byte, error) {
+	if len(data) < 2 {
+		return "", nil, errors.New("the uploaded message is too short")
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return "", nil, errors.New("the uploaded message is shorter than its token")
+	}
+	return string(data[2 : 2+n]), data[2+n:], nil
+}
+
+// UploadNames returns the query names that upload the data to the server. Chunk is the most data bytes sent in one
+// query; zero or a value larger than fits in a DNS name uses the largest that fits.
+func UploadNames(session string, data []byte, domain string, chunk int) []string {
+	domain = strings.Trim(domain, ".")
+	// The longest index-total label is used so every name fits
+	suffix := fmt.Sprintf(".%d-%d.%s.%s.%s", 9999999, 9999999, session, Upload, domain)
+	chars := maxName - len(suffix)
+	chars -= chars / 64 // A dot after every 63 character label
+	max := chars * 5 / 8
+	if chunk <= 0 || chunk > max {
+		chunk = max
+	}
+	total := (len(data) + chunk - 1) / chunk
+	if total == 0 {
+		total = 1
+	}
+	names := make([]string, 0, total)
+	for i := 0; i < total; i++ {
+		end := (i + 1) * chunk
+		if end > len(data) {
+			end = len(data)
+		}
+		encoded := strings.ToLower(encoding.EncodeToString(data[i*chunk : end]))
+		var labels []string
+		for len(encoded) > 63 {
+			labels = append(labels, encoded[:63])
+			encoded = encoded[63:]
+		}
+		// An empty message is sent as a single "0" label that decodes to no data
+		if encoded == "" && len(labels) == 0 {
+			encoded = "0"
+		}
+		labels = append(labels, encoded)
+		names = append(names, fmt.Sprintf("%s.%d-%d.%s.%s.%s", strings.Join(labels, "."), i, total, session, Upload, domain))
+	}
+	return names
+}
+
+// DownloadName returns the query name that downloads the response chunk from the server
+func DownloadName(session string, index int, domain string) string {
+	return fmt.Sprintf("%d.%s.%s.%s", index, session, Download, strings.Trim(domain, "."))
+}
+
+// Query is a parsed tunnel query name
+type Query struct {
+	Type    string // Upload or Download
+	Session string
+	Index   int
+	Total   int    // The number of upload chunks
+	Data    []byte // The upload chunk's data
+}
+
+// ParseName parses a tunnel query name for the domain
+func ParseName(name string, domain string) (Query, error) {
+	var q Query
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	domain = strings.ToLower(strings.Trim(domain, "."))
+	if !strings.HasSuffix(name, "."+domain) {
+		return q, fmt.Errorf("%s is not in the %s domain", name, domain)
+	}
+	labels := strings.Split(strings.TrimSuffix(name, "."+domain), ".")
+	if len(labels) < 3 {
+		return q, fmt.Errorf("%s is not a tunnel query", name)
+	}
+	q.Type = labels[len(labels)-1]
+	q.Session = labels[len(labels)-2]
+	var err error
+	switch q.Type {
+	case Download:
+		if len(labels) != 3 {
+			return q, fmt.Errorf("%s is not a valid download query", name)
+		}
+		q.Index, err = strconv.Atoi(labels[0])
+	case Upload:
+		if len(labels) < 4 {
+			return q, fmt.Errorf("%s is not a valid upload query", name)
+		}
+		position := strings.Split(labels[len(labels)-3], "-")
+		if len(position) != 2 {
+			return q, fmt.Errorf("%s does not have a valid chunk position", name)
+		}
+		if q.Index, err = strconv.Atoi(position[0]); err != nil {
+			return q, err
+		}
+		if q.Total, err = strconv.Atoi(position[1]); err != nil {
+			return q, err
+		}
+		if q.Index >= q.Total {
+			return q, fmt.Errorf("chunk %d is beyond the total of %d", q.Index, q.Total)
+		}
+		data := strings.Join(labels[:len(labels)-3], "")
+		if data != "0" {
+			q.Data, err = encoding.DecodeString(strings.ToUpper(data))
+		}
+	default:
+		return q, fmt.Errorf("%s is not a valid tunnel query type", q.Type)
+	}
+	if err != nil {
+		return q, fmt.Errorf("there was an error parsing %s:\r\n%s", name, err.Error())
+	}
+	if q.Index < 0 {
+		return q, fmt.Errorf("%s has a negative index", name)
+	}
+	return q, nil
+}
+
+// Ack returns the A record acknowledging an upload chunk when more chunks are needed
+func Ack() [][4]byte {
+	return [][4]byte{{ackPartial}}
+}
+
+// Complete returns the A records sent after the last upload chunk with the response's chunk count and length
+func Complete(chunks int, length int) [][4]byte {
+	return [][4]byte{
+		{ackChunks, byte(chunks >> 16), byte(chunks >> 8), byte(chunks)},
+		{ackLength, byte(length >> 16), byte(length >> 8), byte(length)},
+	}
+}
+
+// Rejected returns the A record sent after the last upload chunk when the server did not accept the message
+func Rejected() [][4]byte {
+	return [][4]byte{{ackRejected}}
+}
+
+// ParseAck returns true with the response's chunk count and length if the records completed the upload
+func ParseAck(records [][4]byte) (bool, int, int, error) {
+	var chunks, length int
+	var complete bool
+	for _, r := range records {
+		n := int(r[1])<<16 | int(r[2])<<8 | int(r[3])
+		switch r[0] {
+		case ackPartial:
+		case ackChunks:
+			chunks = n
+			complete = true
+		case ackLength:
+			length = n
+		case ackRejected:
+			return false, 0, 0, ErrRejected
+		default:
+			return false, 0, 0, fmt.Errorf("%d is not a valid upload acknowledgement", r[0])
+		}
+	}
+	return complete, chunks, length, nil
+}
+
+// ChunkSize returns the number of response bytes sent in one answer for the record type; A record chunks are a
+// multiple of the three data bytes each record holds and are limited by the one byte record index
+func ChunkSize(recordType string, size int) int {
+	if strings.ToLower(recordType) == "a" {
+		if size > 255*3 {
+			size = 255 * 3
+		}
+		size -= size % 3
+	}
+	if size < 3 {
+		size = 3
+	}
+	return size
+}
+
+// PackA returns the A records that hold the chunk
+func PackA(chunk []byte) [][4]byte {
+	records := make([][4]byte, 0, (len(chunk)+2)/3)
+	for i := 0; i < len(chunk); i += 3 {
+		r := [4]byte{byte(i / 3)}
+		copy(r[1:], chunk[i:])
+		records = append(records, r)
+	}
+	return records
+}
+
+// UnpackA returns the chunk held by the A records, which may have been reordered by a resolver; the last record is
+// padded with zeros
+func UnpackA(records [][4]byte) []byte {
+	sort.Slice(records, func(i, j int) bool { return records[i][0] < records[j][0] })
+	chunk := make([]byte, 0, len(records)*3)
+	for _, r := range records {
+		chunk = append(chunk, r[1:]...)
+	}
+	return chunk
+}
+
+// PackTXT returns the TXT record strings that hold the chunk
+func PackTXT(chunk []byte) []string {
+	encoded := base64.StdEncoding.EncodeToString(chunk)
+	var txt []string
+	for len(encoded) > 255 {
+		txt = append(txt, encoded[:255])
+		encoded = encoded[255:]
+	}
+	return append(txt, encoded)
+}
+
+// UnpackTXT returns the chunk held by the TXT record strings
+func UnpackTXT(txt []string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(txt, ""))
+}
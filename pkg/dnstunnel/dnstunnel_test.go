@@ -0,0 +1,80 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package dnstunnel
+
+import (
+	// Standard
+	"bytes"
+	"testing"
+)
+
+// TestUpload verifies a message split into upload names is reassembled from the parsed queries
+func TestUpload(t *testing.T) {
+	data := Pack("Bearer token", bytes.Repeat([]byte("merlin"), 200))
+	names := UploadNames("0a1b2c3d", data, "c2.example.com", 0)
+	if len(names) < 2 {
+		t.Fatalf("expected the message to be split into more than one query, got %d", len(names))
+	}
+	var uploaded []byte
+	for i, name := range names {
+		if len(name) > maxName {
+			t.Errorf("query name %d is %d characters long", i, len(name))
+		}
+		q, err := ParseName(name+".", "c2.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Type != Upload || q.Session != "0a1b2c3d" || q.Index != i || q.Total != len(names) {
+			t.Fatalf("unexpected query %+v for %s", q, name)
+		}
+		uploaded = append(uploaded, q.Data...)
+	}
+	token, body, err := Unpack(uploaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "Bearer token" || !bytes.Equal(body, bytes.Repeat([]byte("merlin"), 200)) {
+		t.Error("the reassembled message does not match the uploaded message")
+	}
+}
+
+// TestRecords verifies a response chunk survives A and TXT record packing and that acknowledgements are parsed
+func TestRecords(t *testing.T) {
+	chunk := []byte("a response chunk")
+	records := PackA(chunk)
+	// Resolvers may return the records in any order
+	records[0], records[len(records)-1] = records[len(records)-1], records[0]
+	if a := UnpackA(records); !bytes.Equal(a[:len(chunk)], chunk) {
+		t.Errorf("A records returned %q", a)
+	}
+	txt, err := UnpackTXT(PackTXT(chunk))
+	if err != nil || !bytes.Equal(txt, chunk) {
+		t.Errorf("TXT records returned %q, %v", txt, err)
+	}
+
+	complete, chunks, length, err := ParseAck(Complete(70000, 1<<20))
+	if err != nil || !complete || chunks != 70000 || length != 1<<20 {
+		t.Errorf("unexpected acknowledgement %t %d %d %v", complete, chunks, length, err)
+	}
+	if complete, _, _, _ = ParseAck(Ack()); complete {
+		t.Error("a partial acknowledgement was parsed as complete")
+	}
+	if _, _, _, err = ParseAck(Rejected()); err != ErrRejected {
+		t.Errorf("expected ErrRejected, got %v", err)
+	}
+}
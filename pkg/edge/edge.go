@@ -0,0 +1,220 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package edge is a lightweight listener node deployed near targets. It terminates the agents' HTTPS connections and
+// relays their requests to the central server's edge listener over mutual TLS so agents never connect to the central
+// server directly. A node only needs the credentials created by the central server's listeners menu edge enroll command.
+package edge
+
+import (
+	// Standard
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// The files in a node's credentials directory created when the node is enrolled
+const (
+	CertificateFile = "node.crt" // The node's client certificate signed by the edge certificate authority
+	KeyFile         = "node.key" // The node's private key
+	CAFile          = "ca.crt"   // The edge certificate authority that signed the central server's certificate
+)
+
+// HeartbeatPath is the URL path a node requests on the central server to report that it is online
+const HeartbeatPath = "/.edge/heartbeat"
+
+// Heartbeat is how often a node reports to the central server that it is online
+var Heartbeat = 30 * time.Second
+
+// Relay is an edge listener node that relays agent requests to the central server
+type Relay struct {
+	Central   string // The central server's edge listener URL (i.e. https://10.0.0.5:7443)
+	Interface string // The network adapter interface agents connect to
+	Port      int    // The port agents connect to
+	central   *url.URL
+	client    *http.Client
+	server    *http.Server
+}
+
+// New returns an edge node that relays the requests agents send to the interface and port to the central server.
+// The credentials directory holds the files created when the node was enrolled. The agent facing TLS certificate is
+// read from the certificate and key files; an ephemeral certificate is used if they do not exist.
+func New(central string, credentials string, iface string, port int, certificate string, key string) (*Relay, error) {
+	u, err := url.Parse(central)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("%s is not a valid central server URL; use https://<host>:<port>", central)
+	}
+	if port < 1 || port > 65535 {
+		return nil, fmt.Errorf("%d is not a valid port", port)
+	}
+	config, err := ClientConfig(credentials)
+	if err != nil {
+		return nil, err
+	}
+	cer, err := agentCertificate(certificate, key)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		TLSClientConfig:   config,
+		ForceAttemptHTTP2: true,
+		IdleConnTimeout:   90 * time.Second,
+	}
+	r := &Relay{
+		Central:   central,
+		Interface: iface,
+		Port:      port,
+		central:   u,
+		client:    &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			// The reverse proxy adds the agent's address to the X-Forwarded-For header
+			req.URL.Scheme = u.Scheme
+			req.URL.Host = u.Host
+			req.Host = u.Host
+		},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			log.Printf("there was an error relaying the request from %s to the central server:\r\n%s", req.RemoteAddr, err.Error())
+			w.WriteHeader(http.StatusNotFound)
+		},
+	}
+	r.server = &http.Server{
+		Addr:              util.JoinHostPort(iface, strconv.Itoa(port)),
+		Handler:           proxy,
+		TLSConfig:         &tls.Config{Certificates: []tls.Certificate{*cer}, MinVersion: tls.VersionTLS12},
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	return r, nil
+}
+
+// Run binds to the interface and port and relays agent requests until the listener stops
+func (r *Relay) Run() error {
+	l, err := net.Listen("tcp", r.server.Addr)
+	if err != nil {
+		return fmt.Errorf("there was an error starting the edge node:\r\n%s", err.Error())
+	}
+	go r.heartbeat()
+	return r.server.ServeTLS(l, "", "")
+}
+
+// Close stops the edge node
+func (r *Relay) Close() error {
+	return r.server.Close()
+}
+
+// Ping reports to the central server that the node is online and returns an error if the central server rejected it
+func (r *Relay) Ping() error {
+	resp, err := r.client.Get(r.central.Scheme + "://" + r.central.Host + HeartbeatPath)
+	if err != nil {
+		return fmt.Errorf("there was an error connecting to the central server:\r\n%s", err.Error())
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("the central server rejected the edge node with %s", resp.Status)
+	}
+	return nil
+}
+
+// heartbeat reports to the central server that the node is online every Heartbeat
+func (r *Relay) heartbeat() {
+	for {
+		if err := r.Ping(); err != nil {
+			log.Println(err.Error())
+		}
+		time.Sleep(Heartbeat)
+	}
+}
+
+// ClientConfig returns the mutual TLS configuration a node uses to connect to the central server from the files in its
+// credentials directory. The central server's certificate must be signed by the edge certificate authority; its host
+// name is not verified because nodes often reach the central server through an address it does not know.
+func ClientConfig(credentials string) (*tls.Config, error) {
+	cer, err := tls.LoadX509KeyPair(filepath.Join(credentials, CertificateFile), filepath.Join(credentials, KeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("there was an error loading the edge node's certificate from %s:\r\n%s", credentials, err.Error())
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(credentials, CAFile)) // #nosec G304 - The credentials directory is provided by the operator
+	if err != nil {
+		return nil, fmt.Errorf("there was an error reading the edge certificate authority:\r\n%s", err.Error())
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("%s does not contain a PEM encoded certificate", filepath.Join(credentials, CAFile))
+	}
+	return &tls.Config{
+		Certificates:       []tls.Certificate{cer},
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true, // #nosec G402 - The central server's certificate chain is verified below
+		VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+			if len(raw) == 0 {
+				return fmt.Errorf("the central server did not send a certificate")
+			}
+			certs := make([]*x509.Certificate, len(raw))
+			for i, r := range raw {
+				c, err := x509.ParseCertificate(r)
+				if err != nil {
+					return err
+				}
+				certs[i] = c
+			}
+			intermediates := x509.NewCertPool()
+			for _, c := range certs[1:] {
+				intermediates.AddCert(c)
+			}
+			_, err := certs[0].Verify(x509.VerifyOptions{
+				Roots:         roots,
+				Intermediates: intermediates,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			})
+			return err
+		},
+	}, nil
+}
+
+// agentCertificate loads the TLS certificate agents are presented, or generates an ephemeral one if the files do not
+// exist. Agents do not verify the certificate, the same as the HTTP listeners.
+func agentCertificate(certificate string, key string) (*tls.Certificate, error) {
+	if certificate != "" && key != "" {
+		if _, err := os.Stat(certificate); err == nil {
+			cer, err := tls.LoadX509KeyPair(certificate, key)
+			if err != nil {
+				return nil, fmt.Errorf("there was an error loading the TLS certificate %s:\r\n%s", certificate, err.Error())
+			}
+			return &cer, nil
+		}
+	}
+	cer, err := util.GenerateTLSCert(nil, nil, nil, nil, nil, nil, true)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error generating the TLS certificate:\r\n%s", err.Error())
+	}
+	return cer, nil
+}
@@ -0,0 +1,222 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package events
+
+import (
+	// Standard
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// broker is a fake message broker that sends every published message to the subscribed connections
+type broker struct {
+	sync.Mutex
+	listener net.Listener
+	subs     map[net.Conn]string // Subscribed connections and their NATS subscription ID
+}
+
+// newBroker starts a fake broker that handles each connection with the function
+func newBroker(t *testing.T, handle func(*broker, net.Conn)) *broker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &broker{listener: l, subs: make(map[net.Conn]string)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handle(b, conn)
+		}
+	}()
+	return b
+}
+
+// natsBroker handles a NATS client connection
+func natsBroker(b *broker, conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	_, _ = io.WriteString(conn, "INFO {}\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "SUB":
+			b.Lock()
+			b.subs[conn] = fields[2]
+			b.Unlock()
+		case "PING":
+			_, _ = io.WriteString(conn, "PONG\r\n")
+		case "PUB":
+			size, _ := strconv.Atoi(fields[2])
+			payload := make([]byte, size+2)
+			if _, err = io.ReadFull(r, payload); err != nil {
+				return
+			}
+			b.Lock()
+			for c, sid := range b.subs {
+				_, _ = fmt.Fprintf(c, "MSG %s %s %d\r\n%s", fields[1], sid, size, payload)
+			}
+			b.Unlock()
+		}
+	}
+}
+
+// redisBroker handles a Redis client connection
+func redisBroker(b *broker, conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	bulk := func(s string) string { return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n" }
+	for {
+		v, err := redisValue(r)
+		if err != nil {
+			return
+		}
+		args := v.([]interface{})
+		switch args[0].(string) {
+		case "AUTH":
+			if args[len(args)-1].(string) != "secret" {
+				_, _ = io.WriteString(conn, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+			_, _ = io.WriteString(conn, "+OK\r\n")
+		case "SUBSCRIBE":
+			b.Lock()
+			b.subs[conn] = ""
+			b.Unlock()
+			_, _ = io.WriteString(conn, "*3\r\n"+bulk("subscribe")+bulk(args[1].(string))+":1\r\n")
+		case "PUBLISH":
+			b.Lock()
+			for c := range b.subs {
+				_, _ = io.WriteString(c, "*3\r\n"+bulk("message")+bulk(args[1].(string))+bulk(args[2].(string)))
+			}
+			n := len(b.subs)
+			b.Unlock()
+			_, _ = io.WriteString(conn, ":"+strconv.Itoa(n)+"\r\n")
+		}
+	}
+}
+
+// TestBus verifies events published by one server are recorded and delivered by another server on the same NATS or
+// Redis broker, and that a server ignores its own events echoed back by the broker
+func TestBus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	File = filepath.Join(dir, "log", "events.json")
+	defer func() {
+		_ = log.file.Close()
+		log.file, log.last = nil, 0
+	}()
+
+	tests := []struct {
+		scheme string
+		handle func(*broker, net.Conn)
+		user   string
+	}{
+		{"nats", natsBroker, ""},
+		{"redis", redisBroker, ":secret@"},
+	}
+	for _, test := range tests {
+		b := newBroker(t, test.handle)
+		u, err := url.Parse(fmt.Sprintf("%s://%s%s/merlin.test", test.scheme, test.user, b.listener.Addr()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		connect := func(node string) (*remote, chan Event) {
+			var tr transport = newNATS(u, "merlin.test")
+			if test.scheme == "redis" {
+				tr = newRedis(u, "merlin.test")
+			}
+			r := &remote{local: &local{}, transport: tr, node: node, url: u.Redacted(), done: make(chan struct{})}
+			received := make(chan Event, 10)
+			r.Subscribe(func(e Event) { received <- e })
+			go r.run()
+			return r, received
+		}
+		api, apiEvents := connect("api")
+		edge, edgeEvents := connect("edge")
+
+		// Wait for both servers to subscribe
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			api.mu.Lock()
+			edge.mu.Lock()
+			ready := api.connected && edge.connected
+			edge.mu.Unlock()
+			api.mu.Unlock()
+			if ready {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("the %s buses did not connect to the broker", test.scheme)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		e, err := record(Event{Type: NewAgent, Agent: "c1090dbc-f2f7-4d90-a241-86e0c0217786", Message: "new agent", Node: "edge"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = edge.Publish(e); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-edgeEvents; got.ID != e.ID {
+			t.Errorf("%s: the publishing server delivered event %d instead of %d", test.scheme, got.ID, e.ID)
+		}
+		select {
+		case got := <-apiEvents:
+			if got.Node != "edge" || got.Type != NewAgent || got.ID == e.ID || !got.Time.Equal(e.Time) {
+				t.Errorf("%s: the other server received %+v for %+v", test.scheme, got, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: the other server did not receive the event", test.scheme)
+		}
+		select {
+		case got := <-edgeEvents:
+			t.Errorf("%s: the publishing server received its own event again: %+v", test.scheme, got)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		_ = api.Close()
+		_ = edge.Close()
+		_ = b.listener.Close()
+	}
+
+	if err = Connect("amqp://127.0.0.1:5672", "api"); err == nil {
+		t.Error("an unsupported event bus was connected")
+	}
+}
@@ -0,0 +1,228 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package events records structured server events, such as agent check ins, new agents, completed jobs, and listener
+// state changes, to a file so operators and the API can query them by agent, type, and time range
+package events
+
+import (
+	// Standard
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/redact"
+)
+
+// Event types
+const (
+	CheckIn  = "checkin"  // An agent's status check in
+	NewAgent = "agent"    // An agent registered with the server
+	Job      = "job"      // An agent returned the results of a job
+	Listener = "listener" // A listener started or stopped
+	Dead     = "dead"     // An agent missed more check ins than its retries allow
+	Status   = "status"   // An agent became delayed, or active again after it was delayed or dead
+	KillDate = "killdate" // An agent's killdate is less than a day away
+)
+
+// File is the file events are appended to, one JSON object per line
+var File = filepath.Join(core.CurrentDir, "data", "log", "events.json")
+
+// Event is something that happened on the server
+type Event struct {
+	ID      uint64    `json:"id"` // Sequence number of the event; starts at 1 and continues across restarts
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Agent   string    `json:"agent,omitempty"` // The agent the event is for; empty for listener events
+	Message string    `json:"message"`
+	Node    string    `json:"node,omitempty"` // The server that published the event when servers share an event bus
+}
+
+// Filter selects events; empty fields match every event
+type Filter struct {
+	Agent string
+	Type  string
+	Since time.Time // Events at or after this time
+	Until time.Time // Events before this time
+	After uint64    // Events with a greater ID
+}
+
+// log is the open event file and the last event ID written to it
+var log = struct {
+	sync.Mutex
+	file *os.File
+	last uint64
+}{}
+
+// Types returns the event types
+func Types() []string {
+	return []string{NewAgent, CheckIn, Job, Listener, Dead, Status, KillDate}
+}
+
+// Subscribe calls the function with every event published after it is recorded, including the events published by
+// other servers on a connected event bus. The function is called by the goroutine publishing or receiving the event,
+// so it must return quickly.
+func Subscribe(f func(Event)) {
+	b, _ := current()
+	b.Subscribe(f)
+}
+
+// Publish records an event and delivers it to the subscribers with the event bus; the agent is empty for events that
+// are not about an agent
+func Publish(eventType string, agent string, msg string) error {
+	b, node := current()
+	e, err := record(Event{Type: eventType, Agent: agent, Message: msg, Node: node})
+	if err != nil {
+		return err
+	}
+	return b.Publish(e)
+}
+
+// record appends the event to the event file and returns it with its ID; the time is set to now unless the event
+// already has one, such as an event received from another server
+func record(e Event) (Event, error) {
+	log.Lock()
+	defer log.Unlock()
+	if log.file == nil {
+		if err := open(); err != nil {
+			return Event{}, err
+		}
+	}
+	log.last++
+	e.ID, e.Message = log.last, redact.String(e.Message)
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return e, fmt.Errorf("there was an error marshalling the %s event:\r\n%s", e.Type, err.Error())
+	}
+	if _, err = log.file.Write(append(b, '\n')); err != nil {
+		return e, fmt.Errorf("there was an error writing to the event log %s:\r\n%s", File, err.Error())
+	}
+	return e, nil
+}
+
+// open opens the event file for appending and continues numbering events after the last one in it
+func open() error {
+	if err := os.MkdirAll(filepath.Dir(File), 0750); err != nil {
+		return fmt.Errorf("there was an error creating the event log directory:\r\n%s", err.Error())
+	}
+	list, err := read(context.Background(), File, Filter{})
+	if err != nil {
+		return err
+	}
+	if len(list) > 0 {
+		log.last = list[len(list)-1].ID
+	}
+	f, err := os.OpenFile(File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("there was an error opening the event log %s:\r\n%s", File, err.Error())
+	}
+	log.file = f
+	return nil
+}
+
+// Query returns the recorded events that match the filter, oldest first
+func Query(f Filter) ([]Event, error) {
+	return QueryContext(context.Background(), f)
+}
+
+// QueryContext returns the events that match the filter like Query and stops reading the event log if the context is
+// cancelled
+func QueryContext(ctx context.Context, f Filter) ([]Event, error) {
+	log.Lock()
+	defer log.Unlock()
+	return read(ctx, File, f)
+}
+
+// read returns the events in the file that match the filter; a missing file has no events
+func read(ctx context.Context, file string, f Filter) ([]Event, error) {
+	list := make([]Event, 0)
+	r, err := os.Open(file) // #nosec G304 the event file is set by the server
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("there was an error opening the event log %s:\r\n%s", file, err.Error())
+	}
+	defer r.Close() // #nosec G307
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("reading the event log %s was cancelled", file)
+		}
+		var e Event
+		// Skip a line left partially written when the server stopped
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		if f.Match(e) {
+			list = append(list, e)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("there was an error reading the event log %s:\r\n%s", file, err.Error())
+	}
+	return list, nil
+}
+
+// Match returns true if the event is selected by the filter
+func (f Filter) Match(e Event) bool {
+	switch {
+	case f.Agent != "" && !strings.EqualFold(f.Agent, e.Agent):
+		return false
+	case f.Type != "" && f.Type != e.Type:
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !e.Time.Before(f.Until):
+		return false
+	case e.ID <= f.After:
+		return false
+	}
+	return true
+}
+
+// ParseTime parses an RFC 3339 time, a date (i.e. 2019-10-31), or a duration before now (i.e. 2h30m or 7d)
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && days >= 0 {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%s is not a valid time; use an RFC 3339 time, a date, or a duration such as 2h or 7d", s)
+}
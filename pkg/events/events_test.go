@@ -0,0 +1,101 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package events
+
+import (
+	// Standard
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestQuery verifies published events are filtered by agent, type, and time, and are numbered across restarts
+func TestQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	File = filepath.Join(dir, "log", "events.json")
+	agent := "c1090dbc-f2f7-4d90-a241-86e0c0217786"
+
+	var published []uint64
+	Subscribe(func(e Event) { published = append(published, e.ID) })
+	start := time.Now().UTC().Add(-time.Second)
+	for _, e := range []Event{{Type: Listener}, {Type: NewAgent, Agent: agent}, {Type: CheckIn, Agent: agent}} {
+		if err = Publish(e.Type, e.Agent, e.Type+" event"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Simulate a restart so numbering continues from the file
+	_ = log.file.Close()
+	log.file, log.last = nil, 0
+	if err = Publish(Job, agent, "job event"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(published) != 4 || published[3] != 4 {
+		t.Errorf("the subscriber was called with events %v", published)
+	}
+
+	tests := []struct {
+		filter Filter
+		ids    []uint64
+	}{
+		{Filter{}, []uint64{1, 2, 3, 4}},
+		{Filter{Agent: agent}, []uint64{2, 3, 4}},
+		{Filter{Type: Listener}, []uint64{1}},
+		{Filter{Agent: agent, After: 2}, []uint64{3, 4}},
+		{Filter{Since: start}, []uint64{1, 2, 3, 4}},
+		{Filter{Until: start}, nil},
+	}
+	for _, test := range tests {
+		list, err := Query(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != len(test.ids) {
+			t.Fatalf("filter %+v returned %d events instead of %d", test.filter, len(list), len(test.ids))
+		}
+		for i, e := range list {
+			if e.ID != test.ids[i] {
+				t.Errorf("filter %+v returned event %d instead of %d", test.filter, e.ID, test.ids[i])
+			}
+		}
+	}
+
+	now := time.Date(2019, 10, 31, 12, 0, 0, 0, time.UTC)
+	for s, want := range map[string]time.Time{
+		"2h":                   now.Add(-2 * time.Hour),
+		"7d":                   now.AddDate(0, 0, -7),
+		"2019-10-01":           time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC),
+		"2019-10-31T08:00:00Z": time.Date(2019, 10, 31, 8, 0, 0, 0, time.UTC),
+	} {
+		got, err := ParseTime(s, now)
+		if err != nil {
+			t.Error(err)
+		} else if !got.Equal(want) {
+			t.Errorf("%s parsed as %s instead of %s", s, got, want)
+		}
+	}
+	if _, err = ParseTime("yesterday", now); err == nil {
+		t.Error("an invalid time was parsed without an error")
+	}
+}
@@ -0,0 +1,170 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package events
+
+import (
+	// Standard
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dialTimeout is how long a transport waits to connect to its broker
+const dialTimeout = 10 * time.Second
+
+// errClosed is returned by a transport's listen when the transport was closed while it was connecting
+var errClosed = errors.New("the event bus was closed")
+
+// nats is a transport that publishes and subscribes to a subject on a NATS server with the NATS client protocol
+type nats struct {
+	address string
+	subject string
+	user    string
+	pass    string
+	token   string // Used when the URL has a user name without a password
+	mu      sync.Mutex
+	conn    net.Conn
+	closed  bool
+}
+
+// newNATS returns a NATS transport for the server in the URL
+func newNATS(u *url.URL, subject string) *nats {
+	n := &nats{address: u.Host, subject: subject}
+	if u.Port() == "" {
+		n.address = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			n.user, n.pass = u.User.Username(), pass
+		} else {
+			n.token = u.User.Username()
+		}
+	}
+	return n
+}
+
+// listen connects to the NATS server, subscribes to the subject, and reads messages until the connection fails
+func (n *nats) listen(subscribed func(), receive func([]byte)) error {
+	conn, err := net.DialTimeout("tcp", n.address, dialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close() // #nosec G307
+	r := bufio.NewReader(conn)
+	// The server sends its INFO first
+	if _, err = r.ReadString('\n'); err != nil {
+		return err
+	}
+	options, err := json.Marshal(map[string]interface{}{
+		"verbose": false, "pedantic": false, "name": "merlin", "lang": "go",
+		"user": n.user, "pass": n.pass, "auth_token": n.token,
+	})
+	if err != nil {
+		return err
+	}
+	// The PING is answered with a PONG only after the CONNECT was accepted
+	if _, err = fmt.Fprintf(conn, "CONNECT %s\r\nSUB %s 1\r\nPING\r\n", options, n.subject); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return errClosed
+	}
+	n.conn = conn
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		n.conn = nil
+		n.mu.Unlock()
+	}()
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "PING":
+			if err = n.write("PONG\r\n"); err != nil {
+				return err
+			}
+		case "PONG":
+			if subscribed != nil {
+				subscribed()
+				subscribed = nil
+			}
+		case "-ERR":
+			return fmt.Errorf("the NATS server returned an error: %s", strings.TrimPrefix(line, fields[0]+" "))
+		case "MSG":
+			// MSG <subject> <sid> [reply-to] <size>
+			if len(fields) < 4 {
+				return fmt.Errorf("the NATS server sent an invalid message: %s", line)
+			}
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || size < 0 {
+				return fmt.Errorf("the NATS server sent an invalid message size: %s", line)
+			}
+			payload := make([]byte, size+2)
+			if _, err = io.ReadFull(r, payload); err != nil {
+				return err
+			}
+			receive(payload[:size])
+		}
+	}
+}
+
+// send publishes the message on the subject
+func (n *nats) send(b []byte) error {
+	return n.write(fmt.Sprintf("PUB %s %d\r\n%s\r\n", n.subject, len(b), b))
+}
+
+// write writes to the connection to the NATS server
+func (n *nats) write(s string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		return fmt.Errorf("not connected to the NATS server at %s", n.address)
+	}
+	_, err := io.WriteString(n.conn, s)
+	return err
+}
+
+// close closes the connection to the NATS server
+func (n *nats) close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closed = true
+	if n.conn == nil {
+		return nil
+	}
+	return n.conn.Close()
+}
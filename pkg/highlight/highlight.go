@@ -0,0 +1,312 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package highlight renders structured job output, such as JSON, XML, and Windows registry output, with syntax
+// highlighting and folds long sections so large results are readable in the command line interface
+package highlight
+
+import (
+	// Standard
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	// 3rd Party
+	"github.com/fatih/color"
+)
+
+// Fold is the most lines a section is shown with before the rest of it is folded; zero disables folding
+var Fold = 40
+
+var (
+	keyColor    = color.New(color.FgCyan)
+	stringColor = color.New(color.FgGreen)
+	numberColor = color.New(color.FgYellow)
+	literColor  = color.New(color.FgMagenta)
+	tagColor    = color.New(color.FgBlue)
+	foldColor   = color.New(color.FgHiBlack)
+)
+
+// registry output from reg query (i.e. "    Path    REG_SZ    C:\Windows") and .reg files (i.e. "Path"="C:\\Windows")
+var (
+	regQueryValue = regexp.MustCompile(`^(\s+)(.*?)(\s{4})(REG_[A-Z_]+)(\s{4}|$)(.*)$`)
+	regFileValue  = regexp.MustCompile(`^("(?:[^"\\]|\\.)*"|@)=(.*)$`)
+)
+
+// line is a rendered line and its nesting depth; a section is a line followed by deeper lines
+type line struct {
+	depth int
+	text  string
+}
+
+// Format returns the structured format of the output: json, xml, or registry, or an empty string if it is not one
+func Format(output string) string {
+	s := strings.TrimSpace(output)
+	switch {
+	case s == "":
+		return ""
+	case (s[0] == '{' || s[0] == '[') && json.Valid([]byte(s)):
+		return "json"
+	case s[0] == '<':
+		if _, err := xmlLines(s); err == nil {
+			return "xml"
+		}
+	case strings.HasPrefix(s, "HKEY_") || strings.HasPrefix(s, "[HKEY_") ||
+		strings.HasPrefix(s, "Windows Registry Editor"):
+		return "registry"
+	}
+	return ""
+}
+
+// Render returns the output with syntax highlighting, folding sections longer than fold lines, and if any section was
+// folded. Output that is not JSON, XML, or registry output is returned as it is.
+func Render(output string, fold int) (string, bool) {
+	var lines []line
+	var err error
+	switch Format(output) {
+	case "json":
+		lines, err = jsonLines(strings.TrimSpace(output))
+	case "xml":
+		lines, err = xmlLines(strings.TrimSpace(output))
+	case "registry":
+		lines = registryLines(output)
+	default:
+		return output, false
+	}
+	if err != nil {
+		return output, false
+	}
+	out, folded := block(lines, 0, len(lines), fold)
+	return strings.Join(out, "\n"), folded
+}
+
+// block renders the lines from start to end, folding the sections longer than fold lines whose own sections were not
+// folded
+func block(lines []line, start int, end int, fold int) ([]string, bool) {
+	var out []string
+	folded := false
+	for i := start; i < end; {
+		out = append(out, lines[i].text)
+		j := i + 1
+		for j < end && lines[j].depth > lines[i].depth {
+			j++
+		}
+		if j > i+1 {
+			body, f := block(lines, i+1, j, fold)
+			folded = folded || f
+			// A section is not folded again once one of its own sections was folded
+			if fold > 0 && !f && len(body) > fold {
+				marker := fmt.Sprintf("... %d more lines folded ...", len(body)-fold)
+				body = append(body[:fold], strings.Repeat("  ", lines[i+1].depth)+foldColor.Sprint(marker))
+				folded = true
+			}
+			out = append(out, body...)
+		}
+		i = j
+	}
+	return out, folded
+}
+
+// jsonLines indents the JSON and highlights its keys, strings, numbers, and literals
+func jsonLines(s string) ([]line, error) {
+	var b bytes.Buffer
+	if err := json.Indent(&b, []byte(s), "", "  "); err != nil {
+		return nil, err
+	}
+	var lines []line
+	for _, l := range strings.Split(b.String(), "\n") {
+		text := strings.TrimLeft(l, " ")
+		indent := len(l) - len(text)
+		lines = append(lines, line{depth: indent / 2, text: l[:indent] + jsonColor(text)})
+	}
+	return lines, nil
+}
+
+// jsonColor highlights one line of indented JSON
+func jsonColor(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				j = len(s) - 1
+			}
+			if strings.HasPrefix(strings.TrimLeft(s[j+1:], " "), ":") {
+				b.WriteString(keyColor.Sprint(s[i : j+1]))
+			} else {
+				b.WriteString(stringColor.Sprint(s[i : j+1]))
+			}
+			i = j + 1
+		case c == '-' || (c >= '0' && c <= '9'):
+			j := i
+			for j < len(s) && strings.IndexByte("+-.0123456789eE", s[j]) >= 0 {
+				j++
+			}
+			b.WriteString(numberColor.Sprint(s[i:j]))
+			i = j
+		case strings.HasPrefix(s[i:], "true") || strings.HasPrefix(s[i:], "null"):
+			b.WriteString(literColor.Sprint(s[i : i+4]))
+			i += 4
+		case strings.HasPrefix(s[i:], "false"):
+			b.WriteString(literColor.Sprint(s[i : i+5]))
+			i += 5
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// xmlLines indents the XML and highlights its tags, attributes, and values. An element that only contains text is
+// kept on one line.
+func xmlLines(s string) ([]line, error) {
+	d := xml.NewDecoder(strings.NewReader(s))
+	d.Strict = false
+	var tokens []xml.Token
+	elements := 0
+	for {
+		t, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if cd, ok := t.(xml.CharData); ok && len(bytes.TrimSpace(cd)) == 0 {
+			continue
+		}
+		if _, ok := t.(xml.StartElement); ok {
+			elements++
+		}
+		tokens = append(tokens, xml.CopyToken(t))
+	}
+	if elements == 0 {
+		return nil, fmt.Errorf("the output does not contain an XML element")
+	}
+
+	var lines []line
+	depth := 0
+	for i := 0; i < len(tokens); i++ {
+		indent := strings.Repeat("  ", depth)
+		switch t := tokens[i].(type) {
+		case xml.StartElement:
+			text := xmlStart(t)
+			// <name></name>
+			if i+1 < len(tokens) {
+				if end, ok := tokens[i+1].(xml.EndElement); ok && end.Name == t.Name {
+					lines = append(lines, line{depth, indent + text + xmlEnd(end)})
+					i++
+					continue
+				}
+			}
+			// <name>text</name>
+			if i+2 < len(tokens) {
+				cd, isText := tokens[i+1].(xml.CharData)
+				end, isEnd := tokens[i+2].(xml.EndElement)
+				if isText && isEnd && end.Name == t.Name {
+					lines = append(lines, line{depth, indent + text + xmlEscape(string(bytes.TrimSpace(cd))) + xmlEnd(end)})
+					i += 2
+					continue
+				}
+			}
+			lines = append(lines, line{depth, indent + text})
+			depth++
+		case xml.EndElement:
+			if depth > 0 {
+				depth--
+			}
+			lines = append(lines, line{depth, strings.Repeat("  ", depth) + xmlEnd(t)})
+		case xml.CharData:
+			lines = append(lines, line{depth, indent + xmlEscape(string(bytes.TrimSpace(t)))})
+		case xml.Comment:
+			lines = append(lines, line{depth, indent + foldColor.Sprint("<!--"+string(t)+"-->")})
+		case xml.ProcInst:
+			lines = append(lines, line{depth, indent + tagColor.Sprint("<?"+t.Target+" "+string(t.Inst)+"?>")})
+		case xml.Directive:
+			lines = append(lines, line{depth, indent + tagColor.Sprint("<!"+string(t)+">")})
+		}
+	}
+	return lines, nil
+}
+
+// xmlStart returns the highlighted start tag
+func xmlStart(t xml.StartElement) string {
+	var b strings.Builder
+	b.WriteString(tagColor.Sprint("<" + xmlName(t.Name)))
+	for _, a := range t.Attr {
+		b.WriteString(" " + keyColor.Sprint(xmlName(a.Name)) + "=" + stringColor.Sprint(`"`+xmlEscape(a.Value)+`"`))
+	}
+	b.WriteString(tagColor.Sprint(">"))
+	return b.String()
+}
+
+// xmlEnd returns the highlighted end tag
+func xmlEnd(t xml.EndElement) string {
+	return tagColor.Sprint("</" + xmlName(t.Name) + ">")
+}
+
+// xmlName returns the name with its namespace prefix
+func xmlName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+// xmlEscape escapes the text for display in XML
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// registryLines highlights registry keys, value names, types, and data; each key's values are a section
+func registryLines(s string) []line {
+	var lines []line
+	for _, l := range strings.Split(strings.TrimRight(s, "\r\n"), "\n") {
+		l = strings.TrimRight(l, "\r")
+		switch {
+		case strings.HasPrefix(l, "HKEY_") || strings.HasPrefix(l, "[HKEY_") || strings.HasPrefix(l, "[-HKEY_"):
+			lines = append(lines, line{0, tagColor.Sprint(l)})
+		case regQueryValue.MatchString(l):
+			m := regQueryValue.FindStringSubmatch(l)
+			lines = append(lines, line{1, m[1] + keyColor.Sprint(m[2]) + m[3] + numberColor.Sprint(m[4]) + m[5] +
+				stringColor.Sprint(m[6])})
+		case regFileValue.MatchString(l):
+			m := regFileValue.FindStringSubmatch(l)
+			lines = append(lines, line{1, keyColor.Sprint(m[1]) + "=" + stringColor.Sprint(m[2])})
+		case strings.TrimSpace(l) == "":
+			lines = append(lines, line{0, l})
+		default:
+			lines = append(lines, line{1, l})
+		}
+	}
+	return lines
+}
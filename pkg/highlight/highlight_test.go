@@ -0,0 +1,78 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package highlight
+
+import (
+	// Standard
+	"fmt"
+	"strings"
+	"testing"
+
+	// 3rd Party
+	"github.com/fatih/color"
+)
+
+// TestRender verifies structured output is detected, re-indented, and long sections are folded
+func TestRender(t *testing.T) {
+	color.NoColor = true
+	cases := map[string]string{
+		`{"a":1}`:                       "json",
+		"<root><a x=\"1\">b</a></root>": "xml",
+		"HKEY_LOCAL_MACHINE\\SOFTWARE\\Microsoft\\Windows\\CurrentVersion\r\n    ProgramFilesDir    REG_SZ    C:\\Program Files\r\n": "registry",
+		"total 0\ndrwxr-xr-x 2 root root 40 .": "",
+		"[not json":                            "",
+	}
+	for output, want := range cases {
+		if got := Format(output); got != want {
+			t.Errorf("Format(%q) = %q, want %q", output, got, want)
+		}
+	}
+
+	out, folded := Render("<root><a x=\"1\">b</a><c/></root>", Fold)
+	if folded || out != "<root>\n  <a x=\"1\">b</a>\n  <c></c>\n</root>" {
+		t.Errorf("the XML was rendered as %q", out)
+	}
+
+	var items []string
+	for i := 0; i < 100; i++ {
+		items = append(items, fmt.Sprintf(`{"id":%d}`, i))
+	}
+	out, folded = Render(`{"items":[`+strings.Join(items, ",")+`],"count":100}`, 10)
+	if !folded {
+		t.Fatal("the long JSON array was not folded")
+	}
+	lines := strings.Split(out, "\n")
+	if len(lines) != 16 || !strings.Contains(out, "... 290 more lines folded ...") || lines[14] != `  "count": 100` {
+		t.Errorf("the JSON was rendered as:\n%s", out)
+	}
+}
+
+// TestDiff verifies changed lines are shown with their context and unchanged runs are collapsed
+func TestDiff(t *testing.T) {
+	color.NoColor = true
+	before := "a\nb\nc\nd\ne\nf\ng\nh\r\n"
+	after := "a\nb\nc\nd\nE\nf\ng\nh\ni\n"
+	out, changes := Diff(before, after)
+	want := "... 2 unchanged lines ...\n  c\n  d\n- e\n+ E\n  f\n  g\n  h\n+ i"
+	if changes != 3 || out != want {
+		t.Errorf("the diff had %d changes and was rendered as:\n%s", changes, out)
+	}
+	if out, changes = Diff(before, before); changes != 0 || out != "" {
+		t.Errorf("identical output had %d changes: %q", changes, out)
+	}
+}
@@ -0,0 +1,623 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package hosts builds an inventory of the hosts and subnets discovered from agent check ins and from ipconfig,
+// ifconfig, ip, arp, and nmap output in agent job results, and tracks which agents can reach which hosts. The
+// inventory is saved to disk so the operator can list it and export it as a table or a graph.
+package hosts
+
+import (
+	// Standard
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+)
+
+// Observation sources; Interface is an address of the agent's own host, the others are hosts the agent can reach
+const (
+	Interface = "interface" // An address assigned to one of the agent's host's interfaces
+	Gateway   = "gateway"   // The agent's host's default gateway
+	DNS       = "dns"       // One of the agent's host's DNS servers
+	ARP       = "arp"       // An entry in the agent's host's ARP or neighbor table
+	Scan      = "scan"      // A host found by a scan the agent ran
+	Subnet    = "subnet"    // A host on the same subnet as one of the agent's host's interfaces
+)
+
+// File is the file the host inventory is saved to; it is kept with the database so backups include it
+var File = filepath.Join(core.CurrentDir, "data", "db", "hosts.json")
+
+// Observation is a single fact about a host parsed from an agent's information or job output
+type Observation struct {
+	Address string // The host's IP address
+	Name    string // The host's name, if known
+	MAC     string // The host's hardware address, if known
+	Subnet  string // The host's subnet in CIDR notation, if known
+	Port    string // An open port (i.e. 445/tcp), if known
+	Source  string // How the host was observed; one of the source constants
+}
+
+// Reach is an agent that can reach a host and how that is known
+type Reach struct {
+	Agent  string `json:"agent"`
+	Source string `json:"source"`
+}
+
+// Host is a discovered host and the agents that run on it or reported it
+type Host struct {
+	Address string    `json:"address"`
+	Names   []string  `json:"names,omitempty"`
+	MAC     string    `json:"mac,omitempty"`
+	Subnet  string    `json:"subnet,omitempty"` // The subnet in CIDR notation, if known
+	Ports   []string  `json:"ports,omitempty"`  // Open ports found by scans (i.e. 445/tcp)
+	Agents  []string  `json:"agents,omitempty"` // The agents running on the host
+	Reach   []Reach   `json:"reach,omitempty"`  // The agents that reported the host from another host
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+}
+
+// SubnetInfo is a discovered subnet, the number of hosts on it, and the agents with an interface on it
+type SubnetInfo struct {
+	Subnet string
+	Hosts  int
+	Agents []string
+}
+
+var (
+	ipconfigAddress = regexp.MustCompile(`^\s*(?:IPv4 Address|IP Address|Autoconfiguration IPv4 Address)[ .]*:\s*([0-9.]+)`)
+	ipconfigMask    = regexp.MustCompile(`^\s*Subnet Mask[ .]*:\s*([0-9.]+)`)
+	ipconfigField   = regexp.MustCompile(`^\s*(Default Gateway|DNS Servers)[ .]*:\s*(\S*)`)
+	ipconfigNext    = regexp.MustCompile(`^\s+([0-9]+\.[0-9]+\.[0-9]+\.[0-9]+)\s*$`)
+	ifconfigInet    = regexp.MustCompile(`^\s*inet (?:addr:)?([0-9]+\.[0-9]+\.[0-9]+\.[0-9]+)(?:/([0-9]+))?(?:\s|$)`)
+	ifconfigMask    = regexp.MustCompile(`(?:netmask |Mask:)(0x[0-9a-fA-F]{8}|[0-9]+\.[0-9]+\.[0-9]+\.[0-9]+)`)
+	arpWindows      = regexp.MustCompile(`^\s*([0-9]+\.[0-9]+\.[0-9]+\.[0-9]+)\s+([0-9a-fA-F]{2}(?:-[0-9a-fA-F]{2}){5})\s+(?:dynamic|static)`)
+	arpUnix         = regexp.MustCompile(`^(\S+) \(([0-9.]+)\) at ([0-9a-fA-F]{1,2}(?::[0-9a-fA-F]{1,2}){5})`)
+	ipNeighbor      = regexp.MustCompile(`^([0-9a-fA-F.:]+) dev \S+ lladdr ([0-9a-fA-F]{2}(?::[0-9a-fA-F]{2}){5})`)
+	nmapReport      = regexp.MustCompile(`^Nmap scan report for (?:(\S+) \(([0-9a-fA-F.:]+)\)|([0-9a-fA-F.:]+))\s*$`)
+	nmapPort        = regexp.MustCompile(`^([0-9]+/(?:tcp|udp))\s+open\s`)
+	nmapGrepable    = regexp.MustCompile(`^Host: ([0-9a-fA-F.:]+) \(([^)]*)\)\s+Ports: (.*)$`)
+)
+
+// inventory is the host inventory keyed by address
+var inventory = struct {
+	sync.Mutex
+	loaded bool
+	hosts  map[string]*Host
+}{hosts: make(map[string]*Host)}
+
+// Parse returns the observations found in job output: Windows ipconfig, ifconfig, and ip addr interface addresses,
+// ipconfig default gateways and DNS servers, Windows and Unix arp tables, ip neigh entries, and nmap normal and
+// grepable output. Interface addresses are the addresses of the host the output came from.
+func Parse(output string) []Observation {
+	var list []Observation
+	var field, nmapHost string
+	var pending *Observation // The ipconfig address waiting for the subnet mask on the line after it
+	flush := func(mask string) {
+		if pending != nil {
+			pending.Subnet = subnet(pending.Address, mask)
+			list = append(list, *pending)
+			pending = nil
+		}
+	}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if m := ipconfigAddress.FindStringSubmatch(line); m != nil {
+			flush("")
+			pending, field = &Observation{Address: m[1], Source: Interface}, ""
+			continue
+		}
+		if m := ipconfigMask.FindStringSubmatch(line); m != nil {
+			flush(m[1])
+			continue
+		}
+		if m := ipconfigField.FindStringSubmatch(line); m != nil {
+			field = Gateway
+			if m[1] == "DNS Servers" {
+				field = DNS
+			}
+			list = append(list, Observation{Address: m[2], Source: field})
+			continue
+		}
+		// ipconfig lists additional gateways and DNS servers on the lines that follow the first one
+		if m := ipconfigNext.FindStringSubmatch(line); m != nil && field != "" {
+			list = append(list, Observation{Address: m[1], Source: field})
+			continue
+		}
+		field = ""
+		if m := ifconfigInet.FindStringSubmatch(line); m != nil {
+			o := Observation{Address: m[1], Source: Interface}
+			if m[2] != "" {
+				o.Subnet = subnet(m[1], m[2])
+			} else if mask := ifconfigMask.FindStringSubmatch(line); mask != nil {
+				o.Subnet = subnet(m[1], mask[1])
+			}
+			list = append(list, o)
+			continue
+		}
+		if m := arpWindows.FindStringSubmatch(line); m != nil {
+			list = append(list, Observation{Address: m[1], MAC: strings.Replace(m[2], "-", ":", -1), Source: ARP})
+			continue
+		}
+		if m := arpUnix.FindStringSubmatch(line); m != nil {
+			o := Observation{Address: m[2], MAC: m[3], Source: ARP}
+			if m[1] != "?" {
+				o.Name = m[1]
+			}
+			list = append(list, o)
+			continue
+		}
+		if m := ipNeighbor.FindStringSubmatch(line); m != nil {
+			list = append(list, Observation{Address: m[1], MAC: m[2], Source: ARP})
+			continue
+		}
+		if m := nmapReport.FindStringSubmatch(line); m != nil {
+			o := Observation{Address: m[2], Name: m[1], Source: Scan}
+			if m[3] != "" {
+				o = Observation{Address: m[3], Source: Scan}
+			}
+			nmapHost = o.Address
+			list = append(list, o)
+			continue
+		}
+		if m := nmapPort.FindStringSubmatch(line); m != nil && nmapHost != "" {
+			list = append(list, Observation{Address: nmapHost, Port: m[1], Source: Scan})
+			continue
+		}
+		if m := nmapGrepable.FindStringSubmatch(line); m != nil {
+			list = append(list, Observation{Address: m[1], Name: m[2], Source: Scan})
+			for _, p := range strings.Split(m[3], ",") {
+				// Each port is port/state/protocol/owner/service/rpc/version
+				f := strings.Split(strings.TrimSpace(p), "/")
+				if len(f) > 2 && f[1] == "open" {
+					list = append(list, Observation{Address: m[1], Port: f[0] + "/" + f[2], Source: Scan})
+				}
+			}
+		}
+	}
+	flush("")
+
+	// Drop loopback, link-local, multicast, and broadcast addresses that do not identify a host
+	var valid []Observation
+	for _, o := range list {
+		ip := net.ParseIP(o.Address)
+		if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsUnspecified() ||
+			ip.Equal(net.IPv4bcast) || strings.EqualFold(o.MAC, "ff:ff:ff:ff:ff:ff") {
+			continue
+		}
+		o.Address = ip.String()
+		o.MAC = strings.ToLower(o.MAC)
+		valid = append(valid, o)
+	}
+	return valid
+}
+
+// subnet returns the subnet in CIDR notation for an IPv4 address and a dotted, hexadecimal, or prefix length mask
+func subnet(address string, mask string) string {
+	ip := net.ParseIP(address).To4()
+	if ip == nil || mask == "" {
+		return ""
+	}
+	var m net.IPMask
+	switch {
+	case strings.HasPrefix(mask, "0x"):
+		v, err := strconv.ParseUint(mask[2:], 16, 32)
+		if err != nil {
+			return ""
+		}
+		m = net.IPv4Mask(byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	case strings.Contains(mask, "."):
+		parsed := net.ParseIP(mask).To4()
+		if parsed == nil {
+			return ""
+		}
+		m = net.IPMask(parsed)
+	default:
+		bits, err := strconv.Atoi(mask)
+		if err != nil || bits < 0 || bits > 32 {
+			return ""
+		}
+		m = net.CIDRMask(bits, 32)
+	}
+	if ones, bits := m.Size(); bits == 0 || ones == 0 {
+		return ""
+	}
+	return (&net.IPNet{IP: ip.Mask(m), Mask: m}).String()
+}
+
+// AddAgent records the host an agent runs on from the host name and the interface addresses, usually in CIDR
+// notation, the agent reports when it checks in
+func AddAgent(agent string, hostName string, ips []string) error {
+	var list []Observation
+	for _, address := range ips {
+		o := Observation{Name: hostName, Source: Interface}
+		ip, network, err := net.ParseCIDR(address)
+		if err == nil {
+			o.Subnet = network.String()
+		} else {
+			ip = net.ParseIP(address)
+		}
+		if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		o.Address = ip.String()
+		list = append(list, o)
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	_, err := Add(agent, list)
+	return err
+}
+
+// Add merges the observations an agent reported into the inventory, saves it, and returns the hosts that were added
+func Add(agent string, list []Observation) ([]Host, error) {
+	inventory.Lock()
+	defer inventory.Unlock()
+	if err := load(); err != nil {
+		return nil, err
+	}
+	var added []*Host
+	now := time.Now().UTC()
+	for _, o := range list {
+		h, ok := inventory.hosts[o.Address]
+		if !ok {
+			h = &Host{Address: o.Address, First: now}
+			inventory.hosts[o.Address] = h
+			added = append(added, h)
+		}
+		h.Last = now
+		if o.Name != "" && !contains(h.Names, o.Name) {
+			h.Names = append(h.Names, o.Name)
+		}
+		if o.MAC != "" {
+			h.MAC = o.MAC
+		}
+		if o.Subnet != "" {
+			h.Subnet = o.Subnet
+		}
+		if o.Port != "" && !contains(h.Ports, o.Port) {
+			h.Ports = append(h.Ports, o.Port)
+			sort.Strings(h.Ports)
+		}
+		switch {
+		case agent == "":
+		case o.Source == Interface:
+			if !contains(h.Agents, agent) {
+				h.Agents = append(h.Agents, agent)
+			}
+		case !reaches(h.Reach, agent, o.Source):
+			h.Reach = append(h.Reach, Reach{Agent: agent, Source: o.Source})
+		}
+	}
+	// Place hosts whose subnet is not known in the subnet of an agent's interface that contains them
+	for _, h := range inventory.hosts {
+		if h.Subnet == "" {
+			h.Subnet = knownSubnet(h.Address)
+		}
+	}
+	hosts := make([]Host, 0, len(added))
+	for _, h := range added {
+		hosts = append(hosts, *h)
+	}
+	return hosts, save()
+}
+
+// reaches returns true if the list records that the agent reached a host from the source
+func reaches(list []Reach, agent string, source string) bool {
+	for _, r := range list {
+		if r.Agent == agent && r.Source == source {
+			return true
+		}
+	}
+	return false
+}
+
+// knownSubnet returns the subnet of an agent's interface that contains the address, or an empty string
+func knownSubnet(address string) string {
+	ip := net.ParseIP(address)
+	for _, h := range inventory.hosts {
+		if len(h.Agents) == 0 || h.Subnet == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(h.Subnet); err == nil && network.Contains(ip) {
+			return h.Subnet
+		}
+	}
+	return ""
+}
+
+// List returns a copy of every host in the inventory, or only those in the subnet if it is not empty, sorted by
+// address
+func List(subnet string) ([]Host, error) {
+	var network *net.IPNet
+	if subnet != "" {
+		var err error
+		if _, network, err = net.ParseCIDR(subnet); err != nil {
+			return nil, fmt.Errorf("%s is not a subnet in CIDR notation", subnet)
+		}
+	}
+	inventory.Lock()
+	defer inventory.Unlock()
+	if err := load(); err != nil {
+		return nil, err
+	}
+	list := make([]Host, 0, len(inventory.hosts))
+	for _, h := range inventory.hosts {
+		if network == nil || network.Contains(net.ParseIP(h.Address)) {
+			list = append(list, *h)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return lessAddress(list[i].Address, list[j].Address) })
+	return list, nil
+}
+
+// Get returns the host with the address
+func Get(address string) (Host, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return Host{}, fmt.Errorf("%s is not a valid IP address", address)
+	}
+	inventory.Lock()
+	defer inventory.Unlock()
+	if err := load(); err != nil {
+		return Host{}, err
+	}
+	h, ok := inventory.hosts[ip.String()]
+	if !ok {
+		return Host{}, fmt.Errorf("%s is not in the host inventory", address)
+	}
+	return *h, nil
+}
+
+// GetAddressList returns the addresses in the host inventory and is used for command line tab completion
+func GetAddressList() func(string) []string {
+	return func(line string) []string {
+		list, err := List("")
+		if err != nil {
+			return nil
+		}
+		addresses := make([]string, 0, len(list))
+		for _, h := range list {
+			addresses = append(addresses, h.Address)
+		}
+		return addresses
+	}
+}
+
+// Subnets returns every subnet in the inventory with its number of hosts and the agents with an interface on it
+func Subnets() ([]SubnetInfo, error) {
+	list, err := List("")
+	if err != nil {
+		return nil, err
+	}
+	return subnets(list), nil
+}
+
+// subnets returns the subnets of the hosts
+func subnets(list []Host) []SubnetInfo {
+	found := make(map[string]*SubnetInfo)
+	for _, h := range list {
+		if h.Subnet == "" {
+			continue
+		}
+		s, ok := found[h.Subnet]
+		if !ok {
+			s = &SubnetInfo{Subnet: h.Subnet}
+			found[h.Subnet] = s
+		}
+		s.Hosts++
+		for _, a := range h.Agents {
+			if !contains(s.Agents, a) {
+				s.Agents = append(s.Agents, a)
+			}
+		}
+	}
+	info := make([]SubnetInfo, 0, len(found))
+	for _, s := range found {
+		info = append(info, *s)
+	}
+	sort.Slice(info, func(i, j int) bool { return lessAddress(info[i].Subnet, info[j].Subnet) })
+	return info
+}
+
+// Reachable returns the agents that can reach the host: the agents that reported it from another host and the agents
+// with an interface on its subnet. Agents running on the host are not included.
+func Reachable(h Host) ([]Reach, error) {
+	info, err := Subnets()
+	if err != nil {
+		return nil, err
+	}
+	return reachable(h, info), nil
+}
+
+// reachable returns the agents that can reach the host using the subnets already read from the inventory
+func reachable(h Host, info []SubnetInfo) []Reach {
+	list := append([]Reach(nil), h.Reach...)
+	for _, s := range info {
+		if s.Subnet != h.Subnet {
+			continue
+		}
+		for _, a := range s.Agents {
+			if !contains(h.Agents, a) && !reaches(list, a, Subnet) {
+				list = append(list, Reach{Agent: a, Source: Subnet})
+			}
+		}
+	}
+	return list
+}
+
+// Export writes the inventory to the file as CSV, with a row for each agent that can reach a host, if it has a .csv
+// extension, as a Graphviz graph of subnets, hosts, and agents if it has a .dot extension, or as JSON otherwise
+func Export(file string) error {
+	list, err := List("")
+	if err != nil {
+		return err
+	}
+	info := subnets(list)
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600) // #nosec G304 the operator chooses the file
+	if err != nil {
+		return fmt.Errorf("there was an error creating the export file %s:\r\n%s", file, err.Error())
+	}
+	defer f.Close() // #nosec G307
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".csv":
+		w := csv.NewWriter(f)
+		records := [][]string{{"address", "names", "mac", "subnet", "ports", "agents", "reachable_by", "source", "first", "last"}}
+		for _, h := range list {
+			reach := reachable(h, info)
+			if len(reach) == 0 {
+				reach = []Reach{{}}
+			}
+			for _, r := range reach {
+				records = append(records, []string{h.Address, strings.Join(h.Names, " "), h.MAC, h.Subnet,
+					strings.Join(h.Ports, " "), strings.Join(h.Agents, " "), r.Agent, r.Source,
+					h.First.Format(time.RFC3339), h.Last.Format(time.RFC3339)})
+			}
+		}
+		err = w.WriteAll(records)
+	case ".dot":
+		err = writeGraph(f, list, info)
+	default:
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(list)
+	}
+	if err != nil {
+		return fmt.Errorf("there was an error writing the export file %s:\r\n%s", file, err.Error())
+	}
+	return nil
+}
+
+// writeGraph writes a Graphviz graph with an edge from each subnet to its hosts, from each agent to the host it runs
+// on, and from each agent to the hosts it can reach labeled with how that is known
+func writeGraph(w io.Writer, list []Host, info []SubnetInfo) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "digraph merlin {")
+	fmt.Fprintln(b, "\trankdir=LR;")
+	for _, s := range info {
+		fmt.Fprintf(b, "\t%q [shape=ellipse style=dashed];\n", s.Subnet)
+	}
+	agents := make(map[string]bool)
+	for _, h := range list {
+		label := h.Address
+		if len(h.Names) > 0 {
+			label += "\n" + h.Names[0]
+		}
+		fmt.Fprintf(b, "\t%q [shape=box label=%q];\n", h.Address, label)
+		if h.Subnet != "" {
+			fmt.Fprintf(b, "\t%q -> %q [style=dashed arrowhead=none];\n", h.Subnet, h.Address)
+		}
+		for _, a := range h.Agents {
+			agents[a] = true
+			fmt.Fprintf(b, "\t%q -> %q [label=\"runs on\" style=bold];\n", a, h.Address)
+		}
+		for _, r := range reachable(h, info) {
+			agents[r.Agent] = true
+			fmt.Fprintf(b, "\t%q -> %q [label=%q];\n", r.Agent, h.Address, r.Source)
+		}
+	}
+	ids := make([]string, 0, len(agents))
+	for a := range agents {
+		ids = append(ids, a)
+	}
+	sort.Strings(ids)
+	for _, a := range ids {
+		fmt.Fprintf(b, "\t%q [shape=component color=red];\n", a)
+	}
+	fmt.Fprintln(b, "}")
+	return b.Flush()
+}
+
+// lessAddress orders IP addresses and subnets numerically with IPv4 before IPv6
+func lessAddress(a string, b string) bool {
+	ipA, ipB := net.ParseIP(strings.SplitN(a, "/", 2)[0]), net.ParseIP(strings.SplitN(b, "/", 2)[0])
+	if ipA == nil || ipB == nil {
+		return a < b
+	}
+	if v4A, v4B := ipA.To4(), ipB.To4(); (v4A == nil) != (v4B == nil) {
+		return v4A != nil
+	}
+	return string(ipA.To16()) < string(ipB.To16())
+}
+
+// contains returns true if the list has the string
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// load reads the inventory from its file the first time it is used; a missing file is an empty inventory
+func load() error {
+	if inventory.loaded {
+		return nil
+	}
+	data, err := ioutil.ReadFile(File)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("there was an error reading the host inventory %s:\r\n%s", File, err.Error())
+	}
+	if len(data) > 0 {
+		var list []Host
+		if err = json.Unmarshal(data, &list); err != nil {
+			return fmt.Errorf("there was an error parsing the host inventory %s:\r\n%s", File, err.Error())
+		}
+		for i := range list {
+			inventory.hosts[list[i].Address] = &list[i]
+		}
+	}
+	inventory.loaded = true
+	return nil
+}
+
+// save writes the inventory to its file sorted by address
+func save() error {
+	list := make([]*Host, 0, len(inventory.hosts))
+	for _, h := range inventory.hosts {
+		list = append(list, h)
+	}
+	sort.Slice(list, func(i, j int) bool { return lessAddress(list[i].Address, list[j].Address) })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("there was an error marshalling the host inventory:\r\n%s", err.Error())
+	}
+	if err = os.MkdirAll(filepath.Dir(File), 0750); err != nil {
+		return fmt.Errorf("there was an error creating the host inventory directory:\r\n%s", err.Error())
+	}
+	if err = ioutil.WriteFile(File, data, 0600); err != nil {
+		return fmt.Errorf("there was an error writing the host inventory %s:\r\n%s", File, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package hosts
+
+import (
+	// Standard
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestParse verifies interfaces, gateways, DNS servers, ARP entries, and scan results are parsed from job output
+func TestParse(t *testing.T) {
+	output := `Ethernet adapter Ethernet0:
+
+   IPv4 Address. . . . . . . . . . . : 10.0.0.5(Preferred)
+   Subnet Mask . . . . . . . . . . . : 255.255.255.0
+   Default Gateway . . . . . . . . . : fe80::1%12
+                                       10.0.0.1
+   DNS Servers . . . . . . . . . . . : 10.0.0.2
+                                       10.0.0.3
+eth0: flags=4163<UP,BROADCAST,RUNNING,MULTICAST>  mtu 1500
+        inet 172.16.4.20  netmask 255.255.0.0  broadcast 172.16.255.255
+en0: flags=8863<UP,BROADCAST,SMART,RUNNING,SIMPLEX,MULTICAST> mtu 1500
+	inet 192.168.1.8 netmask 0xffffff00 broadcast 192.168.1.255
+    inet 127.0.0.1/8 scope host lo
+  Internet Address      Physical Address      Type
+  10.0.0.9              00-11-22-33-44-55     dynamic
+  10.0.0.255            ff-ff-ff-ff-ff-ff     static
+gw.corp.local (172.16.0.1) at 00:aa:bb:cc:dd:ee [ether] on eth0
+Nmap scan report for dc01.corp.local (10.0.1.10)
+445/tcp open  microsoft-ds
+88/tcp  open  kerberos-sec
+Host: 10.0.1.11 ()	Ports: 22/open/tcp//ssh///, 80/closed/tcp//http///`
+
+	want := []Observation{
+		{Address: "10.0.0.1", Source: Gateway},
+		{Address: "10.0.0.2", Source: DNS},
+		{Address: "10.0.0.3", Source: DNS},
+		{Address: "10.0.0.5", Subnet: "10.0.0.0/24", Source: Interface},
+		{Address: "172.16.4.20", Subnet: "172.16.0.0/16", Source: Interface},
+		{Address: "192.168.1.8", Subnet: "192.168.1.0/24", Source: Interface},
+		{Address: "10.0.0.9", MAC: "00:11:22:33:44:55", Source: ARP},
+		{Address: "172.16.0.1", Name: "gw.corp.local", MAC: "00:aa:bb:cc:dd:ee", Source: ARP},
+		{Address: "10.0.1.10", Name: "dc01.corp.local", Source: Scan},
+		{Address: "10.0.1.10", Port: "445/tcp", Source: Scan},
+		{Address: "10.0.1.10", Port: "88/tcp", Source: Scan},
+		{Address: "10.0.1.11", Source: Scan},
+		{Address: "10.0.1.11", Port: "22/tcp", Source: Scan},
+	}
+	got := Parse(output)
+	if len(got) != len(want) {
+		t.Fatalf("parsed %d observations but expected %d: %+v", len(got), len(want), got)
+	}
+	for _, w := range want {
+		var found bool
+		for _, g := range got {
+			found = found || g == w
+		}
+		if !found {
+			t.Errorf("the observation %+v was not parsed", w)
+		}
+	}
+}
+
+// TestReachable verifies agents on a host's subnet and agents that reported the host can reach it and are exported
+func TestReachable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	File = filepath.Join(dir, "hosts.json")
+
+	if err := AddAgent("agent1", "ws01", []string{"10.0.0.5/24", "127.0.0.1/8"}); err != nil {
+		t.Fatal(err)
+	}
+	added, err := Add("agent2", []Observation{{Address: "10.0.0.9", MAC: "00:11:22:33:44:55", Source: ARP}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 1 || added[0].Subnet != "10.0.0.0/24" {
+		t.Fatalf("the ARP host was not added to the agent's subnet: %+v", added)
+	}
+
+	h, err := Get("10.0.0.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reach, err := Reachable(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reaches(reach, "agent1", Subnet) || !reaches(reach, "agent2", ARP) || len(reach) != 2 {
+		t.Errorf("the host should be reachable by agent1 on its subnet and agent2 from its ARP table: %+v", reach)
+	}
+
+	export := filepath.Join(dir, "hosts.dot")
+	if err := Export(export); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, edge := range []string{`"agent1" -> "10.0.0.5" [label="runs on"`, `"agent2" -> "10.0.0.9" [label="arp"]`} {
+		if !strings.Contains(string(data), edge) {
+			t.Errorf("the graph does not have the edge %s:\n%s", edge, data)
+		}
+	}
+}
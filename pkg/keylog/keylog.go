@@ -0,0 +1,146 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package keylog stores the keystrokes captured by agents' keyloggers in each agent's directory so operators can replay
+// what was typed
+package keylog
+
+import (
+	// Standard
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// FileName is the file in an agent's directory the keystrokes are appended to, one JSON object per line
+const FileName = "keylog.json"
+
+// Dir is the directory that holds a directory of files for each agent
+var Dir = filepath.Join(core.CurrentDir, "data", "agents")
+
+// Append adds the keystrokes to the agent's keylog file
+func Append(agentID uuid.UUID, keystrokes []messages.Keystrokes) error {
+	if len(keystrokes) == 0 {
+		return nil
+	}
+	file := filepath.Join(Dir, agentID.String(), FileName)
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600) // #nosec G304 - The file is in the agent's directory
+	if err != nil {
+		return fmt.Errorf("there was an error opening the keylog file %s:\r\n%s", file, err.Error())
+	}
+	enc := json.NewEncoder(f)
+	for _, k := range keystrokes {
+		if err = enc.Encode(k); err != nil {
+			break
+		}
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return fmt.Errorf("there was an error writing to the keylog file %s:\r\n%s", file, err.Error())
+	}
+	return nil
+}
+
+// Read returns the agent's stored keystrokes captured at or after the since time; a zero time returns all of them
+func Read(agentID uuid.UUID, since time.Time) ([]messages.Keystrokes, error) {
+	file := filepath.Join(Dir, agentID.String(), FileName)
+	f, err := os.Open(file) // #nosec G304 - The file is in the agent's directory
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("there was an error opening the keylog file %s:\r\n%s", file, err.Error())
+	}
+	defer f.Close() // #nosec G307
+
+	var keystrokes []messages.Keystrokes
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var k messages.Keystrokes
+		if err = json.Unmarshal(scanner.Bytes(), &k); err != nil {
+			continue
+		}
+		if !k.Time.Before(since) {
+			keystrokes = append(keystrokes, k)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return keystrokes, fmt.Errorf("there was an error reading the keylog file %s:\r\n%s", file, err.Error())
+	}
+	return keystrokes, nil
+}
+
+// Replay renders the keystrokes under the time and title of the window they were typed into. Unless raw is true,
+// the text is shown as it was typed: a backspace removes the character before it instead of being shown as [BS].
+func Replay(keystrokes []messages.Keystrokes, raw bool) string {
+	var b strings.Builder
+	for i := 0; i < len(keystrokes); {
+		// Keystrokes split across dumps are joined so a backspace can remove a key from the previous dump
+		var keys string
+		j := i
+		for ; j < len(keystrokes) && keystrokes[j].Window == keystrokes[i].Window; j++ {
+			keys += keystrokes[j].Keys
+		}
+		window := keystrokes[i].Window
+		if window == "" {
+			window = "no window title"
+		}
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "[%s] %s\n", keystrokes[i].Time.UTC().Format(time.RFC3339), window)
+		if !raw {
+			keys = typed(keys)
+		}
+		b.WriteString(keys)
+		i = j
+	}
+	return b.String()
+}
+
+// typed applies the backspaces in the keys to return the text that was left
+func typed(keys string) string {
+	var out []byte
+	for len(keys) > 0 {
+		if strings.HasPrefix(keys, "[BS]") {
+			if len(out) > 0 {
+				_, size := utf8.DecodeLastRune(out)
+				out = out[:len(out)-size]
+			}
+			keys = keys[len("[BS]"):]
+			continue
+		}
+		out = append(out, keys[0])
+		keys = keys[1:]
+	}
+	return string(out)
+}
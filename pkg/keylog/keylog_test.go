@@ -0,0 +1,76 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package keylog
+
+import (
+	// Standard
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// TestReplay verifies stored keystrokes are read back from a time and replayed as typed or raw
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	Dir = dir
+	agent := uuid.NewV4()
+	if err = os.MkdirAll(filepath.Join(dir, agent.String()), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	keystrokes := []messages.Keystrokes{
+		{Time: start, Window: "Notepad", Keys: "old"},
+		{Time: start.Add(time.Minute), Window: "Sign in", Keys: "admim[BS]n\tPäss[BS][BS][BS]assword"},
+		{Time: start.Add(2 * time.Minute), Window: "Sign in", Keys: "1[BS]2\n"},
+	}
+	if err = Append(agent, keystrokes[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err = Append(agent, keystrokes[1:]); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := Read(agent, start.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 2 {
+		t.Fatalf("read %d keystrokes since the time instead of 2", len(read))
+	}
+	want := "[2020-01-01T12:01:00Z] Sign in\nadmin\tPassword2\n"
+	if got := Replay(read, false); got != want {
+		t.Errorf("the keystrokes were replayed as %q instead of %q", got, want)
+	}
+	want = "[2020-01-01T12:01:00Z] Sign in\nadmim[BS]n\tPäss[BS][BS][BS]assword1[BS]2\n"
+	if got := Replay(read, true); got != want {
+		t.Errorf("the raw keystrokes were replayed as %q instead of %q", got, want)
+	}
+}
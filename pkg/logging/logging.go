@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	// 3rd Party
@@ -29,10 +30,29 @@ import (
 
 	// Merlin
 	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/redact"
 )
 
 var serverLog *os.File
 
+// maxEvents is the number of recent events kept in memory for observers
+const maxEvents = 1000
+
+// Event is a server or agent log entry made available to observers
+type Event struct {
+	ID      uint64    `json:"id"`              // Sequence number of the event; starts at 1
+	Time    time.Time `json:"time"`            // When the event happened
+	Agent   string    `json:"agent,omitempty"` // The agent the event was logged for; empty for server events
+	Message string    `json:"message"`
+}
+
+// events are the most recent log entries
+var events = struct {
+	sync.Mutex
+	last uint64
+	list []Event
+}{}
+
 func init() {
 
 	// Server Logging
@@ -66,12 +86,39 @@ func init() {
 
 // Server writes a log entry into the server's log file
 func Server(logMessage string) {
+	logMessage = redact.String(logMessage)
+	AddEvent("", logMessage)
 	_, err := serverLog.WriteString(fmt.Sprintf("[%s]%s\r\n", time.Now().UTC().Format(time.RFC3339), logMessage))
 	if err != nil {
 		message("warn", "there was an error writing to the Merlin Server log file")
 	}
 }
 
+// AddEvent records a log entry for observers; the agent is empty for server log entries
+func AddEvent(agent string, logMessage string) {
+	logMessage = redact.String(logMessage)
+	events.Lock()
+	defer events.Unlock()
+	events.last++
+	events.list = append(events.list, Event{ID: events.last, Time: time.Now().UTC(), Agent: agent, Message: logMessage})
+	if len(events.list) > maxEvents {
+		events.list = events.list[len(events.list)-maxEvents:]
+	}
+}
+
+// Events returns the recent log entries with an ID greater than since
+func Events(since uint64) []Event {
+	events.Lock()
+	defer events.Unlock()
+	list := make([]Event, 0)
+	for _, e := range events.list {
+		if e.ID > since {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
 // Message is used to print a message to the command line
 func message(level string, message string) {
 	switch level {
@@ -0,0 +1,107 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package loot
+
+import (
+	// Standard
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// GalleryName is the name of the HTML gallery written to an agent's directory
+const GalleryName = "gallery.html"
+
+// gallery is the HTML page that shows an agent's screenshots, newest first, from the files beside it
+var gallery = template.Must(template.New("gallery").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Merlin agent {{.Agent}} screenshots</title>
+<style>
+body { background: #222; color: #ddd; font-family: sans-serif; }
+figure { margin: 2em 0; }
+img { max-width: 100%; border: 1px solid #555; }
+</style>
+</head>
+<body>
+<h1>Agent {{.Agent}}</h1>
+<p>{{len .Screenshots}} screenshots</p>
+{{range .Screenshots}}<figure>
+<a href="{{.Name}}"><img src="{{.Name}}" alt="{{.Name}}"></a>
+<figcaption>{{.Name}} ({{.Size}} bytes)</figcaption>
+</figure>
+{{end}}</body>
+</html>
+`))
+
+// IsScreenshot returns true if the file name is a screenshot an agent took
+func IsScreenshot(name string) bool {
+	return strings.HasPrefix(name, "screenshot_") && strings.EqualFold(filepath.Ext(name), ".png")
+}
+
+// Screenshots returns the screenshots in every agent's directory, or only the agent's directory when agentID is not
+// nil, sorted by agent and then by the time they were taken
+func Screenshots(agentID uuid.UUID) ([]File, error) {
+	list, err := List(agentID)
+	if err != nil {
+		return nil, err
+	}
+	var screenshots []File
+	for _, f := range list {
+		if IsScreenshot(f.Name) {
+			screenshots = append(screenshots, f)
+		}
+	}
+	return screenshots, nil
+}
+
+// Gallery writes an HTML page to the agent's directory that shows its screenshots, newest first, and returns the page's
+// path. The page refers to the screenshots beside it so it can be opened in a browser or copied with them.
+func Gallery(agentID uuid.UUID) (string, error) {
+	screenshots, err := Screenshots(agentID)
+	if err != nil {
+		return "", err
+	}
+	if len(screenshots) == 0 {
+		return "", fmt.Errorf("agent %s does not have any screenshots", agentID)
+	}
+	// The screenshot file names start with the UTC time they were taken
+	sort.Slice(screenshots, func(i, j int) bool { return screenshots[i].Name > screenshots[j].Name })
+
+	var b bytes.Buffer
+	err = gallery.Execute(&b, struct {
+		Agent       uuid.UUID
+		Screenshots []File
+	}{agentID, screenshots})
+	if err != nil {
+		return "", fmt.Errorf("there was an error rendering the screenshot gallery:\r\n%s", err.Error())
+	}
+	page := filepath.Join(Dir, agentID.String(), GalleryName)
+	if err = ioutil.WriteFile(page, b.Bytes(), 0600); err != nil {
+		return "", fmt.Errorf("there was an error writing the screenshot gallery %s:\r\n%s", page, err.Error())
+	}
+	return page, nil
+}
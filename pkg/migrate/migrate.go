@@ -0,0 +1,183 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package migrate upgrades the server's persistent data, such as the database and configuration files in the data
+// directory, from the format used by an earlier release to the current one so engagement state is kept across upgrades
+package migrate
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Migration is a single, ordered change to the server's persistent data
+type Migration struct {
+	Version     int                        // Schema version the data is at after the migration is applied
+	Description string                     // A short description of what the migration changes
+	Up          func(dataDir string) error // Function that upgrades the data in the passed in data directory
+}
+
+// Status is the state of a single migration for a data directory
+type Status struct {
+	Version     int
+	Description string
+	Applied     time.Time // When the migration was applied; zero if it is pending
+}
+
+// applied is the JSON record of the migrations that have been applied to a data directory
+type applied struct {
+	Version    int               `json:"version"`    // The current schema version
+	Migrations map[int]time.Time `json:"migrations"` // The time each migration version was applied
+}
+
+// migrations is the ordered list of every migration; new migrations are added with Register using the next version
+// number and must also succeed on a new, empty data directory
+var migrations []Migration
+
+// Register adds a migration to the ordered list. Packages that own persistent data register their migrations from an
+// init function so that they are known before the data is opened. Register panics if the migration's version is not
+// the next one because the data could otherwise be upgraded out of order.
+func Register(m Migration) {
+	if m.Version != Latest()+1 {
+		panic(fmt.Sprintf("migration %d (%s) was registered after version %d; migrations must be registered in order",
+			m.Version, m.Description, Latest()))
+	}
+	if m.Up == nil {
+		panic(fmt.Sprintf("migration %d (%s) does not have an Up function", m.Version, m.Description))
+	}
+	migrations = append(migrations, m)
+}
+
+// stateFile returns the path of the file that records the migrations applied to the data directory
+func stateFile(dataDir string) string {
+	return filepath.Join(dataDir, "db", "migrations.json")
+}
+
+// load reads the record of applied migrations for the data directory. A data directory without a record has not had
+// any migrations applied.
+func load(dataDir string) (applied, error) {
+	a := applied{Migrations: make(map[int]time.Time)}
+	data, err := ioutil.ReadFile(stateFile(dataDir)) // #nosec G304 - The data directory is provided by the server
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return a, fmt.Errorf("there was an error reading the migration state file:\r\n%s", err.Error())
+	}
+	if err := json.Unmarshal(data, &a); err != nil {
+		return a, fmt.Errorf("there was an error parsing the migration state file %s:\r\n%s", stateFile(dataDir), err.Error())
+	}
+	if a.Migrations == nil {
+		a.Migrations = make(map[int]time.Time)
+	}
+	return a, nil
+}
+
+// save writes the record of applied migrations for the data directory
+func save(dataDir string, a applied) error {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return fmt.Errorf("there was an error encoding the migration state:\r\n%s", err.Error())
+	}
+	if err := os.MkdirAll(filepath.Dir(stateFile(dataDir)), 0750); err != nil {
+		return fmt.Errorf("there was an error creating the database directory:\r\n%s", err.Error())
+	}
+	// Write to a temporary file first so an interrupted write does not lose the record
+	tmp := stateFile(dataDir) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("there was an error writing the migration state file:\r\n%s", err.Error())
+	}
+	return os.Rename(tmp, stateFile(dataDir))
+}
+
+// Latest returns the schema version the current release expects
+func Latest() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// Current returns the schema version of the data directory
+func Current(dataDir string) (int, error) {
+	a, err := load(dataDir)
+	return a.Version, err
+}
+
+// GetStatus returns the state of every migration for the data directory
+func GetStatus(dataDir string) ([]Status, error) {
+	a, err := load(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var s []Status
+	for _, m := range migrations {
+		s = append(s, Status{Version: m.Version, Description: m.Description, Applied: a.Migrations[m.Version]})
+	}
+	return s, nil
+}
+
+// Pending returns the migrations that have not been applied to the data directory
+func Pending(dataDir string) ([]Migration, error) {
+	a, err := load(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var p []Migration
+	for _, m := range migrations {
+		if m.Version > a.Version {
+			p = append(p, m)
+		}
+	}
+	return p, nil
+}
+
+// Up applies all pending migrations to the data directory in order and returns the ones that were applied. The record
+// is saved after each migration so a failed migration can be fixed and Up run again without repeating earlier ones.
+func Up(dataDir string) ([]Migration, error) {
+	a, err := load(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if a.Version > Latest() {
+		return nil, fmt.Errorf("the data directory is at schema version %d, which is newer than this release's "+
+			"version %d; use a newer server", a.Version, Latest())
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if m.Version <= a.Version {
+			continue
+		}
+		if err := m.Up(dataDir); err != nil {
+			return done, fmt.Errorf("there was an error applying migration %d (%s):\r\n%s", m.Version,
+				m.Description, err.Error())
+		}
+		a.Version = m.Version
+		a.Migrations[m.Version] = time.Now().UTC()
+		if err := save(dataDir, a); err != nil {
+			return done, err
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
@@ -0,0 +1,113 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package migrate
+
+import (
+	// Standard
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestUp verifies pending migrations are applied in order, recorded, and not applied again
+func TestUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merlin-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // #nosec G104
+
+	var order []int
+	fail := true
+	defer func(m []Migration) { migrations = m }(migrations)
+	migrations = []Migration{
+		{Version: 1, Description: "first", Up: func(string) error { order = append(order, 1); return nil }},
+		{Version: 2, Description: "second", Up: func(string) error {
+			if fail {
+				return errors.New("failed")
+			}
+			order = append(order, 2)
+			return nil
+		}},
+	}
+
+	// The first migration is recorded even though the second one fails
+	done, err := Up(dir)
+	if err == nil {
+		t.Error("expected an error from the failed migration")
+	}
+	if len(done) != 1 {
+		t.Errorf("expected 1 applied migration, got %d", len(done))
+	}
+	if v, _ := Current(dir); v != 1 {
+		t.Errorf("expected schema version 1 after the failed migration, got %d", v)
+	}
+
+	fail = false
+	done, err = Up(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 1 || done[0].Version != 2 {
+		t.Errorf("expected only migration 2 to be applied, got %v", done)
+	}
+	if len(order) != 2 || order[0] != 1 || order[1] != 2 {
+		t.Errorf("expected migrations to run in order once each, got %v", order)
+	}
+
+	pending, err := Pending(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("expected no pending migrations, got %d", len(pending))
+	}
+	status, err := GetStatus(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if s.Applied.IsZero() {
+			t.Errorf("migration %d was not recorded as applied", s.Version)
+		}
+	}
+
+	// A data directory from a newer release is not changed
+	migrations = migrations[:1]
+	if _, err = Up(dir); err == nil {
+		t.Error("expected an error for a data directory newer than the release")
+	}
+}
+
+// TestRegister verifies migrations can only be registered in version order
+func TestRegister(t *testing.T) {
+	defer func(m []Migration) { migrations = m }(migrations)
+	migrations = nil
+
+	Register(Migration{Version: 1, Description: "first", Up: func(string) error { return nil }})
+	if Latest() != 1 {
+		t.Errorf("expected the latest version to be 1, got %d", Latest())
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a migration registered out of order")
+		}
+	}()
+	Register(Migration{Version: 3, Description: "third", Up: func(string) error { return nil }})
+}
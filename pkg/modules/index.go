@@ -0,0 +1,184 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package modules
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+)
+
+// Index is the URL or file path of the module index that modules are installed from
+var Index = ""
+
+// maxModuleSize is the largest module or index file that will be downloaded
+const maxModuleSize = 10 << 20
+
+// validName matches module names that are a relative path in the module directory
+var validName = regexp.MustCompile(`^[A-Za-z0-9_\-]+(/[A-Za-z0-9_\-]+)*$`)
+
+// IndexEntry is a module available from the module index
+type IndexEntry struct {
+	Name        string `json:"name"`        // The module's path in the module directory (i.e. windows/x64/powershell/recon/Foo)
+	Description string `json:"description"` // A description of what the module does
+	Author      string `json:"author"`      // The name of the author that signed the module
+	URL         string `json:"url"`         // The module's JSON file; relative URLs are resolved against the index
+	Signature   string `json:"signature"`   // Base64 encoded Ed25519 signature of the module's JSON file
+}
+
+// GetIndex returns the modules available from the configured module index
+func GetIndex() ([]IndexEntry, error) {
+	if Index == "" {
+		return nil, fmt.Errorf("a module index is not configured; start the server with -module-index")
+	}
+	data, err := fetch(Index)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error getting the module index:\r\n%s", err.Error())
+	}
+	var index struct {
+		Modules []IndexEntry `json:"modules"`
+	}
+	if err = json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("there was an error decoding the module index:\r\n%s", err.Error())
+	}
+	return index.Modules, nil
+}
+
+// Install downloads the named module from the module index, verifies its signature against the trusted keys, and
+// writes it and its signature to the module directory. It returns the signing author.
+func Install(name string) (string, error) {
+	entries, err := GetIndex()
+	if err != nil {
+		return "", err
+	}
+	var entry *IndexEntry
+	for i := range entries {
+		if entries[i].Name == name {
+			entry = &entries[i]
+			break
+		}
+	}
+	if entry == nil {
+		return "", fmt.Errorf("the %s module is not in the module index", name)
+	}
+	if !validName.MatchString(entry.Name) {
+		return "", fmt.Errorf("%s is not a valid module name", entry.Name)
+	}
+	if entry.Signature == "" {
+		return "", fmt.Errorf("the %s module is not signed and can not be installed", name)
+	}
+
+	location, err := resolve(Index, entry.URL)
+	if err != nil {
+		return "", err
+	}
+	data, err := fetch(location)
+	if err != nil {
+		return "", fmt.Errorf("there was an error downloading the %s module:\r\n%s", name, err.Error())
+	}
+	sig := Signature{Author: entry.Author, Signature: entry.Signature}
+	author, err := verifySignature(data, sig)
+	if err != nil {
+		return "", err
+	}
+	signature, err := json.MarshalIndent(sig, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	// Load the module from a temporary file before replacing any installed version
+	modulePath := filepath.Join(core.CurrentDir, "data", "modules", filepath.FromSlash(entry.Name)+".json")
+	if err = os.MkdirAll(filepath.Dir(modulePath), 0750); err != nil {
+		return "", fmt.Errorf("there was an error creating the module directory:\r\n%s", err.Error())
+	}
+	tmp := modulePath + ".download"
+	defer os.Remove(tmp)                      // #nosec G104
+	defer os.Remove(tmp + signatureExtension) // #nosec G104
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return "", fmt.Errorf("there was an error writing the %s module:\r\n%s", name, err.Error())
+	}
+	if err = ioutil.WriteFile(tmp+signatureExtension, signature, 0600); err != nil {
+		return "", fmt.Errorf("there was an error writing the %s module's signature:\r\n%s", name, err.Error())
+	}
+	if _, err = Create(tmp); err != nil {
+		return "", fmt.Errorf("the %s module is not valid:\r\n%s", name, err.Error())
+	}
+	if err = os.Rename(tmp+signatureExtension, modulePath+signatureExtension); err != nil {
+		return "", err
+	}
+	if err = os.Rename(tmp, modulePath); err != nil {
+		return "", err
+	}
+	return author, nil
+}
+
+// resolve returns the location of a module's JSON file relative to the module index
+func resolve(index string, location string) (string, error) {
+	if location == "" {
+		return "", fmt.Errorf("the module index entry does not have a url")
+	}
+	base, err := url.Parse(index)
+	if err != nil {
+		return "", err
+	}
+	if base.Scheme == "http" || base.Scheme == "https" {
+		ref, err := url.Parse(location)
+		if err != nil {
+			return "", err
+		}
+		return base.ResolveReference(ref).String(), nil
+	}
+	if strings.Contains(location, "://") || filepath.IsAbs(location) {
+		return location, nil
+	}
+	return filepath.Join(filepath.Dir(index), filepath.FromSlash(location)), nil
+}
+
+// fetch returns the contents of a HTTP(S) URL or a local file
+func fetch(location string) ([]byte, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		f, err := os.Open(location) // #nosec G304 - User should be able to read in any file
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close() // #nosec G307
+		return ioutil.ReadAll(io.LimitReader(f, maxModuleSize))
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(location) // #nosec G107 - The module index is configured by the operator
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // #nosec G307
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", location, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxModuleSize))
+}
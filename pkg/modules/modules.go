@@ -19,6 +19,8 @@ package modules
 
 import (
 	// Standard
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -37,6 +39,7 @@ import (
 
 	// Merlin
 	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/api"
 	"github.com/Ne0nd0g/merlin/pkg/core"
 	"github.com/Ne0nd0g/merlin/pkg/modules/shellcode"
 	"github.com/Ne0nd0g/merlin/pkg/modules/srdi"
@@ -45,8 +48,9 @@ import (
 // Module is a structure containing the base information or template for modules
 type Module struct {
 	Agent        uuid.UUID   // The Agent that will later be associated with this module prior to execution
+	Targets      string      `json:"-"`                    // The comma separated agent IDs and tags the module runs on instead of Agent
 	Name         string      `json:"name"`                 // Name of the module
-	Type         string      `json:"type"`                 // Type of module (i.e. standard or extended)
+	Type         string      `json:"type"`                 // Type of module (i.e. standard, extended, bof, assembly, or script)
 	Author       []string    `json:"author"`               // A list of module authors
 	Credits      []string    `json:"credits"`              // A list of people to credit for underlying tool or techniques
 	Path         []string    `json:"path"`                 // Path to the module (i.e. data/modules/powershell/powerview)
@@ -56,20 +60,30 @@ type Module struct {
 	Priv         bool        `json:"privilege"`            // Does this module required a privileged level account like root or SYSTEM?
 	Description  string      `json:"description"`          // A description of what the module does
 	Notes        string      `json:"notes"`                // Additional information or notes about the module
-	Commands     []string    `json:"commands"`             // A list of commands to be run on the agent
+	Commands     []string    `json:"commands"`             // A list of commands to be run on the agent, or the arguments of a bof, assembly, or script module's file
 	SourceRemote string      `json:"remote"`               // Online or remote source code for a module (i.e. https://raw.githubusercontent.com/PowerShellMafia/PowerSploit/master/Exfiltration/Invoke-Mimikatz.ps1)
-	SourceLocal  []string    `json:"local"`                // The local file path to the script or payload
+	SourceLocal  []string    `json:"local"`                // The local file path to the script or payload; the file a bof, assembly, or script module delivers
 	Options      []Option    `json:"options"`              // A list of configurable options/arguments for the module
+	Attack       []string    `json:"attack,omitempty"`     // The MITRE ATT&CK technique IDs the module uses (i.e. T1003.001)
 	Powershell   interface{} `json:"powershell,omitempty"` // An option json object containing commands and configuration items specific to PowerShell
 }
 
+// extended maps the lower case name of each "extended" module to the Parse function of its associated module package
+var extended = map[string]func(map[string]string) ([]string, error){
+	"minidump":           minidump.Parse,
+	"shellcodeinjection": shellcode.Parse,
+	"srdi":               srdi.Parse,
+}
+
 // Option is a structure containing the keys for the object
 type Option struct {
-	Name        string `json:"name"`        // Name of the option
-	Value       string `json:"value"`       // Value of the option
-	Required    bool   `json:"required"`    // Is this a required option?
-	Flag        string `json:"flag"`        // The command line flag used for the option
-	Description string `json:"description"` // A description of the option
+	Name        string   `json:"name"`             // Name of the option
+	Value       string   `json:"value"`            // Value of the option
+	Required    bool     `json:"required"`         // Is this a required option?
+	Flag        string   `json:"flag"`             // The command line flag used for the option
+	Description string   `json:"description"`      // A description of the option
+	Type        string   `json:"type,omitempty"`   // The type of the option's value (i.e. string, int, bool, path, uuid, or enum)
+	Values      []string `json:"values,omitempty"` // The values an enum option can be set to
 }
 
 // PowerShell structure is used to describe additional PowerShell features for modules that leverage PowerShell
@@ -81,17 +95,28 @@ type PowerShell struct {
 
 // Run function returns an array of commands to execute the module on an agent
 func (m *Module) Run() ([]string, error) {
-	if m.Agent == uuid.FromStringOrNil("00000000-0000-0000-0000-000000000000") {
-		return nil, errors.New("agent not set for module")
-	}
+	return m.RunContext(context.Background())
+}
 
-	platform, platformError := agents.GetAgentFieldValue(m.Agent, "platform")
-	if platformError != nil {
-		return nil, platformError
+// RunContext returns the module's commands like Run. An extended module's command, which can take a while to build
+// (i.e. converting a DLL to shellcode), is abandoned if the context is cancelled first.
+func (m *Module) RunContext(ctx context.Context) ([]string, error) {
+	if m.Agent == uuid.FromStringOrNil("00000000-0000-0000-0000-000000000000") && m.Targets == "" {
+		return nil, errors.New("agent not set for module")
 	}
 
-	if !strings.EqualFold(m.Platform, platform) {
-		return nil, fmt.Errorf("the %s module is only compatible with %s platform. The agent's platform is %s", m.Name, m.Platform, platform)
+	// A module run on every agent, or a list of agents, is only sent to the agents it runs on
+	if m.Fanout() {
+		ids, _, err := m.RunAgents()
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, api.Errorf(api.InvalidOption, "there are no agents the %s module runs on; it only runs on %s/%s agents",
+				m.Name, strings.ToLower(m.Platform), strings.ToLower(m.Arch))
+		}
+	} else if err := m.CompatibleWith(m.Agent); err != nil {
+		return nil, err
 	}
 
 	// Check every 'required' option to make sure it isn't null
@@ -104,11 +129,21 @@ func (m *Module) Run() ([]string, error) {
 	}
 
 	if strings.ToLower(m.Type) == "extended" {
-		extendedCommand, err := getExtendedCommand(m)
-		if err != nil {
-			return nil, err
+		type result struct {
+			command []string
+			err     error
+		}
+		done := make(chan result, 1)
+		go func() {
+			command, err := getExtendedCommand(m)
+			done <- result{command, err}
+		}()
+		select {
+		case r := <-done:
+			return r.command, r.err
+		case <-ctx.Done():
+			return nil, fmt.Errorf("the %s module was cancelled", m.Name)
 		}
-		return extendedCommand, nil
 	}
 
 	// Fill in or remove options values
@@ -141,21 +176,41 @@ func (m *Module) Run() ([]string, error) {
 			}
 		}
 	}
+	// A BOF, assembly, or script module's commands are the arguments of the file it delivers
+	switch strings.ToLower(m.Type) {
+	case "bof", "assembly", "script":
+		return getPayloadCommand(m, command)
+	}
 	return command, nil
 }
 
+// Job returns the job that runs the command the module returned on an agent; a standard module's command is run with
+// a cmd job and the command of every other module type starts with its job type
+func (m *Module) Job(command []string) agents.Job {
+	if strings.ToLower(m.Type) == "standard" {
+		return agents.Job{Type: "cmd", Args: command}
+	}
+	return agents.Job{Type: command[0], Args: command[1:]}
+}
+
 // ShowOptions function is used to display only a module's configurable options
 func (m *Module) ShowOptions() {
-	color.Cyan(fmt.Sprintf("\r\nAgent: %s\r\n", m.Agent.String()))
+	color.Cyan(fmt.Sprintf("\r\nAgent: %s\r\n", m.AgentOption()))
 	color.Yellow("\r\nModule options(" + m.Name + ")\r\n\r\n")
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Name", "Value", "Required", "Description"})
+	table.SetHeader([]string{"Name", "Value", "Required", "Type", "Description"})
 	// TODO update the tablewriter to the newest version and use the SetColMinWidth for the Description column
 	table.SetBorder(false)
 	// TODO add option for agent alias here
-	table.Append([]string{"Agent", m.Agent.String(), "true", "Agent on which to run module " + m.Name})
+	table.Append([]string{"Agent", m.AgentOption(), "true", "uuid", "Agent on which to run module " + m.Name + "; all, or a comma separated list of agents and tags"})
 	for _, v := range m.Options {
-		table.Append([]string{v.Name, v.Value, strconv.FormatBool(v.Required), v.Description})
+		optionType := strings.ToLower(v.Type)
+		if optionType == "" {
+			optionType = "string"
+		} else if optionType == "enum" {
+			optionType = strings.Join(v.Values, "|")
+		}
+		table.Append([]string{v.Name, v.Value, strconv.FormatBool(v.Required), optionType, v.Description})
 	}
 	table.Render()
 }
@@ -202,11 +257,20 @@ func GetModuleList() func(string) []string {
 	}
 }
 
+// modulePath returns the path of the module's JSON file from its name in the module list
+func modulePath(name string) string {
+	return filepath.Join(core.CurrentDir, "data", "modules", filepath.FromSlash(name)+".json")
+}
+
 // SetOption is used to change the passed in module option's value. Used when a user is configuring a module
 func (m *Module) SetOption(option string, value string) (string, error) {
 	// Verify this option exists
 	for k, v := range m.Options {
 		if option == v.Name {
+			value, err := v.validateValue(value)
+			if err != nil {
+				return "", err
+			}
 			m.Options[k].Value = value
 			return fmt.Sprintf("%s set to %s", v.Name, m.Options[k].Value), nil
 		}
@@ -214,8 +278,12 @@ func (m *Module) SetOption(option string, value string) (string, error) {
 	return "", fmt.Errorf("invalid module option: %s", option)
 }
 
-// SetAgent is used to set the agent associated with the module.
+// SetAgent is used to set the agent associated with the module. The agent can be all agents, or a comma separated list
+// of agent IDs and tags prefixed with tag:.
 func (m *Module) SetAgent(agentUUID string) (string, error) {
+	if isTargets(agentUUID) {
+		return m.setTargets(agentUUID)
+	}
 	if strings.ToLower(agentUUID) == "all" {
 		agentUUID = "ffffffff-ffff-ffff-ffff-ffffffffffff"
 	}
@@ -223,7 +291,13 @@ func (m *Module) SetAgent(agentUUID string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("invalid UUID")
 	}
-	m.Agent = i
+	// An agent the module does not run on is refused now instead of when the module is run
+	if _, ok := agents.Agents[i]; ok {
+		if err = m.CompatibleWith(i); err != nil {
+			return "", err
+		}
+	}
+	m.Agent, m.Targets = i, ""
 	return fmt.Sprintf("agent set to %s", m.Agent.String()), nil
 }
 
@@ -240,6 +314,9 @@ func (m *Module) ShowInfo() {
 		color.Yellow("\t%s", m.Credits[c])
 	}
 	color.Yellow("Description:\r\n\t%s", m.Description)
+	if len(m.Attack) > 0 {
+		color.Yellow("MITRE ATT&CK:\r\n\t%s", strings.Join(m.Attack, ", "))
+	}
 	m.ShowOptions()
 	fmt.Println()
 	color.Yellow("Notes: %s", m.Notes)
@@ -255,6 +332,11 @@ func Create(modulePath string) (Module, error) {
 		return m, err
 	}
 
+	// Verify the module's signature before using anything in it
+	if _, err = verify(modulePath, f); err != nil {
+		return m, err
+	}
+
 	// Unmarshal module's JSON message
 	var moduleJSON map[string]*json.RawMessage
 	errModule := json.Unmarshal(f, &moduleJSON)
@@ -307,30 +389,119 @@ func Create(modulePath string) (Module, error) {
 	return m, nil
 }
 
+// Validate loads every module in Merlin's module directory, the same way it is loaded when used, and checks its JSON
+// for unknown keys, options, and extended module functions. It returns the number of modules checked and any errors
+// keyed by the module's file path
+func Validate() (int, map[string]error) {
+	moduleDir := filepath.Join(core.CurrentDir, "data", "modules")
+	errs := make(map[string]error)
+	var count int
+
+	err := filepath.Walk(moduleDir, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() && f.Name() == "templates" {
+			return filepath.SkipDir
+		}
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			return nil
+		}
+		count++
+		if errFile := validateFile(path); errFile != nil {
+			errs[path] = errFile
+		}
+		return nil
+	})
+	if err != nil {
+		errs[moduleDir] = fmt.Errorf("there was an error walking the module directory:\r\n%s", err.Error())
+	}
+	return count, errs
+}
+
+// validateFile checks a module's JSON file for the errors that would otherwise only be found when the module is used
+func validateFile(modulePath string) error {
+	m, err := Create(modulePath)
+	if err != nil {
+		return err
+	}
+
+	// Create ignores keys it does not know about, so check for misspelled message types and fields
+	f, err := ioutil.ReadFile(modulePath) // #nosec G304 - User should be able to read in any file
+	if err != nil {
+		return err
+	}
+	var moduleJSON map[string]*json.RawMessage
+	if err = json.Unmarshal(f, &moduleJSON); err != nil {
+		return err
+	}
+	for k := range moduleJSON {
+		// The additionalInstructions message type is for the operator and is not used by Merlin
+		if k != "base" && k != "powershell" && k != "additionalInstructions" {
+			return fmt.Errorf("unknown message type '%s' in the module's JSON file", k)
+		}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(*moduleJSON["base"]))
+	decoder.DisallowUnknownFields()
+	var base Module
+	if err = decoder.Decode(&base); err != nil {
+		return fmt.Errorf("there was an error decoding the module's base message:\r\n%s", err.Error())
+	}
+
+	names := make(map[string]bool)
+	for _, o := range m.Options {
+		if o.Name == "" {
+			return errors.New("a module option is missing its 'name' value")
+		}
+		if names[o.Name] {
+			return fmt.Errorf("the '%s' option is defined more than once", o.Name)
+		}
+		names[o.Name] = true
+	}
+
+	switch strings.ToUpper(m.Type) {
+	case "STANDARD":
+		if len(m.Commands) == 0 {
+			return errors.New("standard module does not contain any 'commands'")
+		}
+	case "EXTENDED":
+		if _, ok := extended[strings.ToLower(m.Name)]; !ok {
+			return fmt.Errorf("the %s module's extended command function was not found", m.Name)
+		}
+	}
+	return nil
+}
+
 // validateModule function is used to check a module's configuration for errors
 func validateModule(m Module) (bool, error) {
 
-	// Validate Platform
-	switch strings.ToUpper(m.Platform) {
-	case "WINDOWS":
-	case "LINUX":
-	case "DARWIN":
-	default:
-		return false, errors.New("invalid or missing 'platform' value in the module's JSON file")
+	// Validate Platform and Architecture
+	if strings.TrimSpace(m.Platform) == "" {
+		return false, errors.New("missing 'platform' value in the module's JSON file")
 	}
-
-	// Validate Architecture
-	switch strings.ToUpper(m.Arch) {
-	case "X64":
-	case "X32":
-	default:
-		return false, errors.New("invalid or missing 'arch' value in the module's JSON file")
+	if strings.TrimSpace(m.Arch) == "" {
+		return false, errors.New("missing 'arch' value in the module's JSON file")
+	}
+	if err := validatePlatform(m); err != nil {
+		return false, err
+	}
+	if err := validateAttack(m); err != nil {
+		return false, err
+	}
+	for _, o := range m.Options {
+		if err := validateOption(o); err != nil {
+			return false, err
+		}
 	}
 
 	// Validate Type
 	switch strings.ToUpper(m.Type) {
 	case "STANDARD":
 	case "EXTENDED":
+	case "BOF", "ASSEMBLY", "SCRIPT":
+		if err := validatePayload(m); err != nil {
+			return false, err
+		}
 	default:
 		return false, errors.New("invalid or missing `type` value in the module's JSON file")
 	}
@@ -351,19 +522,11 @@ func marshalMessage(m interface{}) []byte {
 // name to a the Parse function of its associated module package
 func getExtendedCommand(m *Module) ([]string, error) {
 	// TODO document that every extended module must have a parse function as its entry point
-	var extendedCommand []string
-	var err error
-	switch strings.ToLower(m.Name) {
-	case "minidump":
-		extendedCommand, err = minidump.Parse(m.getMapFromOptions())
-	case "shellcodeinjection":
-		extendedCommand, err = shellcode.Parse(m.getMapFromOptions())
-	case "srdi":
-		extendedCommand, err = srdi.Parse(m.getMapFromOptions())
-	default:
+	parse, ok := extended[strings.ToLower(m.Name)]
+	if !ok {
 		return nil, fmt.Errorf("the %s module's extended command function was not found", m.Name)
 	}
-	return extendedCommand, err
+	return parse(m.getMapFromOptions())
 }
 
 // getMapFromOptions is used to generate a map containing module option names and values to be used with other functions
@@ -0,0 +1,123 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package modules
+
+import (
+	// Standard
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	// 3rd Party
+	uuid "github.com/satori/go.uuid"
+)
+
+// optionTypes are the types a module option's value can be declared as; an option without a type is a string
+var optionTypes = []string{"string", "int", "bool", "path", "uuid", "enum"}
+
+// validateValue returns the value the option will be set to, or an error if the value is not of the option's type.
+// An empty value clears the option and is always valid; required options are checked when the module is run.
+func (o Option) validateValue(value string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	switch strings.ToLower(o.Type) {
+	case "", "string":
+	case "int":
+		if _, err := strconv.Atoi(value); err != nil {
+			return "", fmt.Errorf("the %s option must be an integer: %s", o.Name, value)
+		}
+	case "bool":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("the %s option must be true or false: %s", o.Name, value)
+		}
+		return strconv.FormatBool(b), nil
+	case "path":
+		f, err := os.Stat(value)
+		if err != nil {
+			return "", fmt.Errorf("the %s option must be a file on the server: %s", o.Name, err.Error())
+		}
+		if f.IsDir() {
+			return "", fmt.Errorf("the %s option must be a file on the server, not a directory: %s", o.Name, value)
+		}
+	case "uuid":
+		if _, err := uuid.FromString(value); err != nil {
+			return "", fmt.Errorf("the %s option must be a UUID: %s", o.Name, value)
+		}
+	case "enum":
+		// The value is matched regardless of case and set to the module's spelling of it
+		for _, v := range o.Values {
+			if strings.EqualFold(v, value) {
+				return v, nil
+			}
+		}
+		return "", fmt.Errorf("the %s option must be one of %s: %s", o.Name, strings.Join(o.Values, ", "), value)
+	default:
+		return "", fmt.Errorf("the %s option has an unknown type: %s", o.Name, o.Type)
+	}
+	return value, nil
+}
+
+// validateOption checks an option's type declaration when the module is loaded. A default value that is a server
+// path is not checked because the file might not exist until the option is set.
+func validateOption(o Option) error {
+	if !contains(optionTypes, strings.ToLower(o.Type)) && o.Type != "" {
+		return fmt.Errorf("the '%s' option has an invalid type '%s'; use %s", o.Name, o.Type, strings.Join(optionTypes, ", "))
+	}
+	switch strings.ToLower(o.Type) {
+	case "enum":
+		if len(o.Values) == 0 {
+			return fmt.Errorf("the '%s' enum option is missing its 'values'", o.Name)
+		}
+	case "path":
+		return nil
+	default:
+		if len(o.Values) > 0 {
+			return fmt.Errorf("the '%s' option has 'values' but is not an enum option", o.Name)
+		}
+	}
+	if _, err := o.validateValue(o.Value); err != nil {
+		return fmt.Errorf("the '%s' option's default value is invalid:\r\n%s", o.Name, err.Error())
+	}
+	return nil
+}
+
+// GetOptionValues returns the values of the option being set for tab completion; only bool and enum options have a
+// set of values to complete
+func (m *Module) GetOptionValues() func(string) []string {
+	return func(line string) []string {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil
+		}
+		for _, o := range m.Options {
+			if o.Name != fields[1] {
+				continue
+			}
+			switch strings.ToLower(o.Type) {
+			case "bool":
+				return []string{"true", "false"}
+			case "enum":
+				return o.Values
+			}
+		}
+		return nil
+	}
+}
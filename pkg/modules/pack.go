@@ -0,0 +1,212 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package modules
+
+import (
+	// Standard
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+)
+
+// cloneTimeout is how long cloning a module pack's git repository can take
+const cloneTimeout = 5 * time.Minute
+
+// PackResult is what installing a module pack did with each module it found
+type PackResult struct {
+	Installed []string         // The names of the modules that were added to the module directory
+	Updated   []string         // The names of the modules that replaced an installed module
+	Invalid   map[string]error // The errors of the module files that were not installed, keyed by their path in the pack
+}
+
+// IsPack returns true if the source is a git repository URL or a local directory or module file rather than the name
+// of a module in the module index
+func IsPack(source string) bool {
+	if IsGitURL(source) {
+		return true
+	}
+	_, err := os.Stat(source)
+	return err == nil
+}
+
+// IsGitURL returns true if the source is the URL of a git repository
+func IsGitURL(source string) bool {
+	for _, prefix := range []string{"https://", "http://", "ssh://", "git://", "git@"} {
+		if strings.HasPrefix(source, prefix) {
+			return true
+		}
+	}
+	return strings.HasSuffix(source, ".git")
+}
+
+// InstallPack clones a git repository of modules, or reads a local directory of modules or a single module file, and
+// copies every module that loads, with its signature, into the module directory. A module is placed at its declared
+// path, or at its path in the pack if it does not declare one. Each module's signature is verified before it is
+// installed, so unsigned modules are refused when signed modules are required, and an unsigned module can not replace
+// a signed one.
+func InstallPack(ctx context.Context, source string) (PackResult, error) {
+	result := PackResult{Invalid: make(map[string]error)}
+	dir := source
+	if IsGitURL(source) {
+		tmp, err := ioutil.TempDir("", "merlin-modules")
+		if err != nil {
+			return result, fmt.Errorf("there was an error creating a directory to clone the module pack into:\r\n%s", err.Error())
+		}
+		defer os.RemoveAll(tmp) // #nosec G104
+		if err = clone(ctx, source, tmp); err != nil {
+			return result, err
+		}
+		dir = tmp
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return result, fmt.Errorf("there was an error accessing the module pack:\r\n%s", err.Error())
+	}
+	files := make(map[string]string) // Module file path to its path relative to the pack
+	if info.IsDir() {
+		// A pack can keep its modules in a modules directory laid out like the module directory
+		if sub, errSub := os.Stat(filepath.Join(dir, "modules")); errSub == nil && sub.IsDir() {
+			dir = filepath.Join(dir, "modules")
+		}
+		err = filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if f.IsDir() && (f.Name() == ".git" || f.Name() == "templates") {
+				return filepath.SkipDir
+			}
+			if !f.IsDir() && strings.HasSuffix(f.Name(), ".json") {
+				rel, errRel := filepath.Rel(dir, path)
+				if errRel != nil {
+					return errRel
+				}
+				files[path] = filepath.ToSlash(rel)
+			}
+			return nil
+		})
+		if err != nil {
+			return result, fmt.Errorf("there was an error reading the module pack:\r\n%s", err.Error())
+		}
+	} else {
+		files[dir] = filepath.Base(dir)
+	}
+
+	for file, rel := range files {
+		name, err := installModule(file, rel)
+		if err != nil {
+			result.Invalid[rel] = err
+			continue
+		}
+		if name.updated {
+			result.Updated = append(result.Updated, name.name)
+		} else {
+			result.Installed = append(result.Installed, name.name)
+		}
+	}
+	sort.Strings(result.Installed)
+	sort.Strings(result.Updated)
+	if len(files) == 0 {
+		return result, fmt.Errorf("there are no module files in %s", source)
+	}
+	return result, nil
+}
+
+// installedModule is the name a module was installed as and if it replaced an installed module
+type installedModule struct {
+	name    string
+	updated bool
+}
+
+// installModule validates the module file and its signature and copies them into the module directory
+func installModule(file string, rel string) (installedModule, error) {
+	if err := validateFile(file); err != nil {
+		return installedModule{}, err
+	}
+	data, err := ioutil.ReadFile(file) // #nosec G304 the file is in the module pack being installed
+	if err != nil {
+		return installedModule{}, err
+	}
+	// The signature is verified before anything is written so a bad pack can not replace a working module
+	author, err := verify(file, data)
+	if err != nil {
+		return installedModule{}, err
+	}
+	m, err := Create(file)
+	if err != nil {
+		return installedModule{}, err
+	}
+	name := strings.TrimSuffix(rel, ".json")
+	if len(m.Path) > 0 && strings.HasSuffix(m.Path[len(m.Path)-1], ".json") {
+		name = strings.TrimSuffix(strings.Join(m.Path, "/"), ".json")
+	}
+	if !validName.MatchString(name) {
+		return installedModule{}, fmt.Errorf("%s is not a valid module name", name)
+	}
+
+	destination := modulePath(name)
+	_, errExists := os.Stat(destination)
+	if author == "" {
+		if _, errSig := os.Stat(destination + signatureExtension); errSig == nil {
+			return installedModule{}, fmt.Errorf("the installed %s module is signed and can not be replaced by an "+
+				"unsigned module", name)
+		}
+	}
+	if err = os.MkdirAll(filepath.Dir(destination), 0750); err != nil {
+		return installedModule{}, fmt.Errorf("there was an error creating the module directory:\r\n%s", err.Error())
+	}
+	if author != "" {
+		signature, errSig := ioutil.ReadFile(file + signatureExtension) // #nosec G304 the signature is next to the module
+		if errSig != nil {
+			return installedModule{}, errSig
+		}
+		if err = ioutil.WriteFile(destination+signatureExtension, signature, 0600); err != nil {
+			return installedModule{}, fmt.Errorf("there was an error writing the %s module's signature:\r\n%s", name, err.Error())
+		}
+	}
+	if err = ioutil.WriteFile(destination, data, 0600); err != nil {
+		return installedModule{}, fmt.Errorf("there was an error writing the %s module:\r\n%s", name, err.Error())
+	}
+	return installedModule{name: name, updated: errExists == nil}, nil
+}
+
+// clone makes a shallow clone of the git repository into the directory without prompting for credentials
+func clone(ctx context.Context, url string, dir string) error {
+	git, err := exec.LookPath("git")
+	if err != nil {
+		return fmt.Errorf("git was not found to clone the module pack:\r\n%s", err.Error())
+	}
+	ctx, cancel := context.WithTimeout(ctx, cloneTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, git, "clone", "--depth", "1", "--", url, dir) // #nosec G204 the operator provides the repository
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Dir = core.CurrentDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("there was an error cloning %s:\r\n%s", url, strings.TrimSpace(string(out)+" "+err.Error()))
+	}
+	return nil
+}
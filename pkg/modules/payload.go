@@ -0,0 +1,98 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package modules
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/bof"
+	"github.com/Ne0nd0g/merlin/pkg/core"
+)
+
+// scriptJobs map the languages a script module can be written in to the job that runs its script
+var scriptJobs = map[string]string{
+	"powershell": "powershell",
+	"python":     "python",
+}
+
+// payloadFile returns the path of the file a bof, assembly, or script module delivers from its local path
+func payloadFile(m *Module) string {
+	return filepath.Join(append([]string{core.CurrentDir}, m.SourceLocal...)...)
+}
+
+// getPayloadCommand returns the job type and arguments that deliver a bof, assembly, or script module's file with the
+// arguments filled in from its commands. A BOF's arguments are typed like the bof command's, an assembly's are passed
+// to its entry point, a PowerShell script's are a line run after the script is loaded, such as a call to a function it
+// defines, and a Python script's are its sys.argv.
+func getPayloadCommand(m *Module, args []string) ([]string, error) {
+	file := payloadFile(m)
+	if _, err := os.Stat(file); err != nil {
+		return nil, fmt.Errorf("there was an error accessing the %s module's file:\r\n%s", m.Name, err.Error())
+	}
+	switch strings.ToLower(m.Type) {
+	case "bof":
+		if _, err := bof.Pack(args); err != nil {
+			return nil, fmt.Errorf("there was an error with the %s module's BOF arguments:\r\n%s", m.Name, err.Error())
+		}
+		return append([]string{"bof", file}, args...), nil
+	case "assembly":
+		// The AppDomain is the agent's default and AMSI and ETW are not patched
+		return append([]string{"execute-assembly", file, "", "false", "false"}, args...), nil
+	}
+
+	script, err := ioutil.ReadFile(file) // #nosec G304 the path is the module's local file
+	if err != nil {
+		return nil, fmt.Errorf("there was an error reading the %s module's script:\r\n%s", m.Name, err.Error())
+	}
+	switch scriptJobs[strings.ToLower(m.Lang)] {
+	case "powershell":
+		code := string(script)
+		if len(args) > 0 {
+			code += "\n" + strings.Join(args, " ")
+		}
+		return []string{"powershell", "false", "false", "inline", code}, nil
+	case "python":
+		argv, err := json.Marshal(append([]string{filepath.Base(file)}, args...))
+		if err != nil {
+			return nil, fmt.Errorf("there was an error encoding the %s module's arguments:\r\n%s", m.Name, err.Error())
+		}
+		// A JSON array of strings is a Python list literal
+		return []string{"python", "inline", "import sys\nsys.argv = " + string(argv) + "\n" + string(script)}, nil
+	}
+	return nil, fmt.Errorf("%s is not a script module language; use powershell or python", m.Lang)
+}
+
+// validatePayload checks a bof, assembly, or script module declares the file it delivers and a script module's language
+func validatePayload(m Module) error {
+	if len(m.SourceLocal) == 0 || strings.TrimSpace(strings.Join(m.SourceLocal, "")) == "" {
+		return fmt.Errorf("a %s module requires the 'local' path of the file it delivers", strings.ToLower(m.Type))
+	}
+	if strings.EqualFold(m.Type, "script") {
+		if _, ok := scriptJobs[strings.ToLower(m.Lang)]; !ok {
+			return fmt.Errorf("invalid 'lang' value %s for a script module; use powershell or python", m.Lang)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package modules
+
+import (
+	// Standard
+	"fmt"
+	"sort"
+	"strings"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/api"
+)
+
+// platforms are the operating systems a module's platform can list
+var platforms = []string{"windows", "linux", "darwin", "freebsd"}
+
+// architectures map the names a module's arch can list to the architecture agents report from Go's GOARCH
+var architectures = map[string]string{
+	"x64":   "amd64",
+	"amd64": "amd64",
+	"x32":   "386",
+	"x86":   "386",
+	"386":   "386",
+	"arm":   "arm",
+	"arm64": "arm64",
+	"mips":  "mips",
+}
+
+// Platforms returns the platforms the module runs on from its comma separated platform value; nil means every platform
+func (m *Module) Platforms() []string {
+	return list(m.Platform)
+}
+
+// Architectures returns the architectures, as agents report them, the module runs on from its comma separated arch
+// value; nil means every architecture
+func (m *Module) Architectures() []string {
+	var archs []string
+	for _, a := range list(m.Arch) {
+		archs = append(archs, architectures[a])
+	}
+	return archs
+}
+
+// Supports returns an error that names what the module runs on if it does not run on the platform and architecture
+func (m *Module) Supports(platform string, arch string) error {
+	ps, archs := m.Platforms(), m.Architectures()
+	if (ps == nil || contains(ps, strings.ToLower(platform))) && (archs == nil || contains(archs, strings.ToLower(arch))) {
+		return nil
+	}
+	return api.Errorf(api.InvalidOption, "the %s module only runs on %s/%s agents, not %s/%s", m.Name,
+		strings.ToLower(m.Platform), strings.ToLower(m.Arch), platform, arch)
+}
+
+// CompatibleWith returns an error if the agent is not known or the module does not run on its platform and architecture
+func (m *Module) CompatibleWith(agentID uuid.UUID) error {
+	platform, err := agents.GetAgentFieldValue(agentID, "platform")
+	if err != nil {
+		return err
+	}
+	arch, err := agents.GetAgentFieldValue(agentID, "architecture")
+	if err != nil {
+		return err
+	}
+	return m.Supports(platform, arch)
+}
+
+// CompatibleAgents returns the accepted agents the module runs on, sorted by their ID
+func (m *Module) CompatibleAgents() []uuid.UUID {
+	var ids []uuid.UUID
+	for id, a := range agents.Agents {
+		if !a.Pending && m.Supports(a.Platform, a.Architecture) == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	return ids
+}
+
+// GetCompatibleModuleList returns the modules in Merlin's module directory that run on the agent's platform and
+// architecture for tab completion. Every module is returned if the agent is not known.
+func GetCompatibleModuleList(agentID func() uuid.UUID) func(string) []string {
+	return func(line string) []string {
+		all := GetModuleList()(line)
+		id := agentID()
+		if _, ok := agents.Agents[id]; !ok {
+			return all
+		}
+		var compatible []string
+		for _, name := range all {
+			m, err := Create(modulePath(name))
+			if err == nil && m.CompatibleWith(id) == nil {
+				compatible = append(compatible, name)
+			}
+		}
+		return compatible
+	}
+}
+
+// validatePlatform returns an error if the module's platform or arch value lists a name that is not known
+func validatePlatform(m Module) error {
+	for _, p := range list(m.Platform) {
+		if !contains(platforms, p) {
+			return fmt.Errorf("invalid 'platform' value %s in the module's JSON file; use all or a comma separated list of %s",
+				p, strings.Join(platforms, ", "))
+		}
+	}
+	for _, a := range list(m.Arch) {
+		if _, ok := architectures[a]; !ok {
+			return fmt.Errorf("invalid 'arch' value %s in the module's JSON file; use all or a comma separated list of x64, x32, arm, arm64, or mips", a)
+		}
+	}
+	return nil
+}
+
+// list splits a comma separated platform or arch value into its lower case names; all returns nil
+func list(value string) []string {
+	var names []string
+	for _, v := range strings.Split(strings.ToLower(value), ",") {
+		if v = strings.TrimSpace(v); v == "all" {
+			return nil
+		} else if v != "" {
+			names = append(names, v)
+		}
+	}
+	return names
+}
+
+// contains returns true if the name is in the list
+func contains(names []string, name string) bool {
+	for _, v := range names {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package modules
+
+import (
+	// Standard
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// validAttack matches a MITRE ATT&CK technique or sub-technique ID
+var validAttack = regexp.MustCompile(`^T\d{4}(\.\d{3})?$`)
+
+// SearchResult is a module that matched every search keyword and how well it matched
+type SearchResult struct {
+	Name   string // The module's name in the module directory, which is used with 'use module'
+	Score  int    // Higher scores matched more of the keywords in the module's name
+	Module Module // The module
+}
+
+// Search returns the modules whose name, description, authors, credits, or ATT&CK IDs match every keyword, ignoring
+// case, with the best matches first. Modules that do not load are not returned.
+func Search(keywords []string) []SearchResult {
+	var results []SearchResult
+	for _, name := range GetModuleList()("") {
+		m, err := Create(modulePath(name))
+		if err != nil {
+			continue
+		}
+		total := 0
+		for _, k := range keywords {
+			score := searchScore(name, m, strings.ToLower(k))
+			if score == 0 {
+				total = 0
+				break
+			}
+			total += score
+		}
+		if total > 0 {
+			results = append(results, SearchResult{Name: name, Score: total, Module: m})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Name < results[j].Name
+	})
+	return results
+}
+
+// searchScore returns how well the lower case keyword matches the module; a match in the module's name counts the most
+func searchScore(name string, m Module, keyword string) int {
+	score := 0
+	switch {
+	case strings.ToLower(m.Name) == keyword:
+		score += 100
+	case strings.Contains(strings.ToLower(m.Name), keyword):
+		score += 50
+	case strings.Contains(strings.ToLower(name), keyword):
+		score += 30
+	}
+	for _, id := range m.Attack {
+		// A technique ID matches its sub-techniques, i.e. T1003 matches T1003.001
+		if id = strings.ToLower(id); id == keyword || strings.HasPrefix(id, keyword+".") {
+			score += 40
+			break
+		}
+	}
+	if strings.Contains(strings.ToLower(m.Description), keyword) {
+		score += 20
+	}
+	for _, person := range append(append([]string{}, m.Author...), m.Credits...) {
+		if strings.Contains(strings.ToLower(person), keyword) {
+			score += 10
+			break
+		}
+	}
+	return score
+}
+
+// validateAttack returns an error if the module lists an ATT&CK ID that is not a technique or sub-technique ID
+func validateAttack(m Module) error {
+	for _, id := range m.Attack {
+		if !validAttack.MatchString(id) {
+			return fmt.Errorf("invalid 'attack' value %s in the module's JSON file; use a technique ID like T1003 or T1003.001", id)
+		}
+	}
+	return nil
+}
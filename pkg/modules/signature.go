@@ -0,0 +1,194 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package modules
+
+import (
+	// Standard
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+)
+
+// RequireSigned refuses to load modules that do not have a signature from a trusted key
+var RequireSigned = false
+
+// signatureExtension is appended to a module's JSON file name for the file holding its signature
+const signatureExtension = ".sig"
+
+// Signature is a module author's signature of a module's JSON file
+type Signature struct {
+	Author    string `json:"author"`    // The name of the author that signed the module
+	Signature string `json:"signature"` // Base64 encoded Ed25519 signature of the module's JSON file
+}
+
+// TrustedKey is a public key whose module signatures are trusted
+type TrustedKey struct {
+	Author string
+	Key    ed25519.PublicKey
+}
+
+// trustedKeysFile returns the path to the file of trusted module signing keys
+func trustedKeysFile() string {
+	return filepath.Join(core.CurrentDir, "data", "modules", "trusted.keys")
+}
+
+// TrustedKeys returns the module signing keys from data/modules/trusted.keys. Each line is a base64 encoded Ed25519
+// public key followed by the author's name; blank lines and lines starting with # are ignored.
+func TrustedKeys() ([]TrustedKey, error) {
+	var keys []TrustedKey
+	f, err := os.Open(trustedKeysFile())
+	if os.IsNotExist(err) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("there was an error opening the trusted module keys file:\r\n%s", err.Error())
+	}
+	defer f.Close() // #nosec G307
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.SplitN(text, " ", 2)
+		key, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("line %d of the trusted module keys file is not a valid Ed25519 public key", line)
+		}
+		k := TrustedKey{Key: key}
+		if len(fields) > 1 {
+			k.Author = strings.TrimSpace(fields[1])
+		}
+		keys = append(keys, k)
+	}
+	return keys, scanner.Err()
+}
+
+// Verify checks the module file's signature against the trusted keys and returns the signing author. It returns an
+// empty author and no error for an unsigned module unless RequireSigned is set.
+func Verify(modulePath string) (string, error) {
+	data, err := ioutil.ReadFile(modulePath) // #nosec G304 - User should be able to read in any file
+	if err != nil {
+		return "", err
+	}
+	return verify(modulePath, data)
+}
+
+// verify checks the signature file next to the module path against the module's data and returns the signing author
+func verify(modulePath string, data []byte) (string, error) {
+	s, err := ioutil.ReadFile(modulePath + signatureExtension) // #nosec G304 - The signature is next to the module
+	if os.IsNotExist(err) {
+		if RequireSigned {
+			return "", fmt.Errorf("the %s module is not signed and unsigned modules are not allowed", filepath.Base(modulePath))
+		}
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var sig Signature
+	if err = json.Unmarshal(s, &sig); err != nil {
+		return "", fmt.Errorf("there was an error decoding the module's signature file:\r\n%s", err.Error())
+	}
+	return verifySignature(data, sig)
+}
+
+// verifySignature checks the signature of the data against the trusted keys and returns the signing author
+func verifySignature(data []byte, sig Signature) (string, error) {
+	signature, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil {
+		return "", fmt.Errorf("there was an error decoding the module's signature:\r\n%s", err.Error())
+	}
+	keys, err := TrustedKeys()
+	if err != nil {
+		return "", err
+	}
+	for _, k := range keys {
+		if ed25519.Verify(k.Key, data, signature) {
+			if k.Author != "" {
+				return k.Author, nil
+			}
+			return sig.Author, nil
+		}
+	}
+	return "", fmt.Errorf("the module's signature by %s is not from a trusted key or the module was modified", sig.Author)
+}
+
+// Sign signs the module file with the Ed25519 private key in keyFile and writes the signature, naming the module's
+// authors, next to the module. If keyFile does not exist, a new key is created in it. It returns the base64 encoded
+// public key to add to the trusted keys file.
+func Sign(modulePath string, keyFile string) (string, error) {
+	var key ed25519.PrivateKey
+	k, err := ioutil.ReadFile(keyFile) // #nosec G304 - User should be able to read in any file
+	switch {
+	case os.IsNotExist(err):
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", fmt.Errorf("there was an error generating the module signing key:\r\n%s", err.Error())
+		}
+		encoded := base64.StdEncoding.EncodeToString(key.Seed())
+		if err = ioutil.WriteFile(keyFile, []byte(encoded+"\n"), 0600); err != nil {
+			return "", fmt.Errorf("there was an error writing the module signing key:\r\n%s", err.Error())
+		}
+	case err != nil:
+		return "", err
+	default:
+		seed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(k)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return "", errors.New("the module signing key file does not contain a valid Ed25519 key")
+		}
+		key = ed25519.NewKeyFromSeed(seed)
+	}
+
+	data, err := ioutil.ReadFile(modulePath) // #nosec G304 - User should be able to read in any file
+	if err != nil {
+		return "", err
+	}
+	var m struct {
+		Base Module `json:"base"`
+	}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return "", fmt.Errorf("there was an error decoding the module:\r\n%s", err.Error())
+	}
+	sig, err := json.MarshalIndent(Signature{
+		Author:    strings.Join(m.Base.Author, ", "),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)),
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err = ioutil.WriteFile(modulePath+signatureExtension, sig, 0600); err != nil {
+		return "", fmt.Errorf("there was an error writing the module's signature:\r\n%s", err.Error())
+	}
+	return base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)), nil
+}
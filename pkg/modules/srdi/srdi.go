@@ -101,6 +101,12 @@ func Parse(options map[string]string) ([]string, error) {
 	return command, nil
 }
 
+// DLLToShellcode converts the Windows DLL file to position independent shellcode with a reflective loader that calls
+// the named export, if one is provided, after DllMain
+func DLLToShellcode(dllPath string, functionName string, clearHeader bool, userData string) ([]byte, error) {
+	return dllToReflectiveShellcode(dllPath, functionName, clearHeader, userData)
+}
+
 // dllToReflectiveShellcode will convert an existing Windows DLL to position independent shellcode that contains a reflective loader to load and execute the shellcode in-memory.
 // The function code is adapted from the work by Leo Loobeek at:
 // https://gist.githubusercontent.com/leoloobeek/c726719d25d7e7953d4121bd93dd2ed3/raw/05f20bae7aa6cd21e20a52034b9547a19e211c5e/ShellcodeRDI.go
@@ -0,0 +1,104 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package modules
+
+import (
+	// Standard
+	"fmt"
+	"sort"
+	"strings"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/api"
+)
+
+// isTargets returns true if the Agent option is a comma separated list of agent IDs and tags instead of one agent
+func isTargets(agent string) bool {
+	return strings.Contains(agent, ",") || strings.HasPrefix(agent, agents.TagPrefix)
+}
+
+// setTargets sets the module to run on the agents a comma separated list of agent IDs and tags refers to. The list is
+// kept and resolved again when the module is run, so agents tagged after it was set are included.
+func (m *Module) setTargets(targets string) (string, error) {
+	ids, err := agents.Targets(targets)
+	if err != nil {
+		return "", err
+	}
+	var compatible int
+	for _, id := range ids {
+		if _, ok := agents.Agents[id]; !ok {
+			return "", api.Errorf(api.AgentNotFound, "%s is not a known agent", id)
+		}
+		if m.CompatibleWith(id) == nil {
+			compatible++
+		}
+	}
+	if compatible == 0 {
+		return "", api.Errorf(api.InvalidOption, "none of the %d agents run the %s module; it only runs on %s/%s agents",
+			len(ids), m.Name, strings.ToLower(m.Platform), strings.ToLower(m.Arch))
+	}
+	m.Agent, m.Targets = uuid.Nil, targets
+	return fmt.Sprintf("agent set to %s (%d of %d agents run the module)", targets, compatible, len(ids)), nil
+}
+
+// Fanout returns true if the module's Agent option is all agents or a list of agents and tags
+func (m *Module) Fanout() bool {
+	return m.Targets != "" || m.Agent == api.AllAgents
+}
+
+// AgentOption returns the module's Agent option as it was set
+func (m *Module) AgentOption() string {
+	if m.Targets != "" {
+		return m.Targets
+	}
+	return m.Agent.String()
+}
+
+// RunAgents returns the agents a module run on more than one agent is sent to and why each of the other agents its
+// Agent option refers to is skipped. All agents are sorted by their ID; a list keeps its order.
+func (m *Module) RunAgents() ([]uuid.UUID, map[uuid.UUID]error, error) {
+	var ids []uuid.UUID
+	if m.Targets != "" {
+		var err error
+		if ids, err = agents.Targets(m.Targets); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		for id := range agents.Agents {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	}
+
+	var run []uuid.UUID
+	skipped := make(map[uuid.UUID]error)
+	for _, id := range ids {
+		if a, ok := agents.Agents[id]; ok && a.Pending {
+			skipped[id] = fmt.Errorf("agent %s is pending", id)
+		} else if err := m.CompatibleWith(id); err != nil {
+			skipped[id] = err
+		} else {
+			run = append(run, id)
+		}
+	}
+	return run, skipped, nil
+}
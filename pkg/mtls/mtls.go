@@ -0,0 +1,405 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package mtls is the agent certificate authority for mutual TLS listeners. A client certificate is issued for each
+// generated agent and embedded in its configuration; listeners that require client certificates reject agent messages
+// from connections without a certificate issued here, or with one that was revoked.
+package mtls
+
+import (
+	// Standard
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+)
+
+// Dir is the directory that holds the agent certificate authority and the registry of issued certificates
+var Dir = filepath.Join(core.CurrentDir, "data", "x509", "agents")
+
+// Validity is how long an issued agent certificate is valid
+var Validity = 365 * 24 * time.Hour
+
+// validName matches certificate names
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Certificate is an agent client certificate issued by the agent certificate authority
+type Certificate struct {
+	Name        string      `json:"name"`
+	Fingerprint string      `json:"fingerprint"` // The SHA256 fingerprint of the certificate
+	Issued      time.Time   `json:"issued"`
+	Expires     time.Time   `json:"expires"`
+	Revoked     time.Time   `json:"revoked,omitempty"` // When the certificate was revoked; zero if it was not
+	Agents      []uuid.UUID `json:"agents,omitempty"`  // The agents that checked in with the certificate
+}
+
+// registry is the issued certificates keyed by their fingerprint; it is read from Dir the first time it is used
+var registry = struct {
+	sync.Mutex
+	loaded bool
+	dir    string
+	m      map[string]*Certificate
+}{}
+
+// ca is the agent certificate authority once it was read from or created in Dir
+var ca = struct {
+	sync.Mutex
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}{}
+
+// Issue creates a new client certificate and key signed by the agent certificate authority and returns them PEM
+// encoded, the certificate first, for an agent's configuration
+func Issue(name string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("%s is not a valid certificate name; use letters, numbers, dots, dashes, and underscores", name)
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if err := load(); err != nil {
+		return "", err
+	}
+	for _, c := range registry.m {
+		if c.Name == name && c.Revoked.IsZero() {
+			return "", fmt.Errorf("agent certificate %s was already issued; revoke it or use another name", name)
+		}
+	}
+	caCert, caKey, err := authority()
+	if err != nil {
+		return "", err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("there was an error generating the key for agent certificate %s:\r\n%s", name, err.Error())
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(Validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return "", fmt.Errorf("there was an error signing agent certificate %s:\r\n%s", name, err.Error())
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", fmt.Errorf("there was an error encoding the key for agent certificate %s:\r\n%s", name, err.Error())
+	}
+	c := &Certificate{Name: name, Fingerprint: fingerprint(der), Issued: now, Expires: template.NotAfter}
+	registry.m[c.Fingerprint] = c
+	if err = save(); err != nil {
+		delete(registry.m, c.Fingerprint)
+		return "", err
+	}
+	logging.Server(fmt.Sprintf("Issued agent certificate %s with fingerprint %s", name, c.Fingerprint))
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})) +
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})), nil
+}
+
+// Revoke stops listeners from accepting the agent certificate with the name, or the certificate an agent checked in
+// with when the name is an agent ID, including on connections that are already open
+func Revoke(name string) error {
+	registry.Lock()
+	defer registry.Unlock()
+	if err := load(); err != nil {
+		return err
+	}
+	c := find(name)
+	if c == nil {
+		return fmt.Errorf("%s is not an issued agent certificate or an agent that checked in with one", name)
+	}
+	if !c.Revoked.IsZero() {
+		return fmt.Errorf("agent certificate %s is already revoked", c.Name)
+	}
+	c.Revoked = time.Now().UTC()
+	if err := save(); err != nil {
+		c.Revoked = time.Time{}
+		return err
+	}
+	logging.Server(fmt.Sprintf("Revoked agent certificate %s", c.Name))
+	return nil
+}
+
+// Certificates returns the issued agent certificates sorted by name and issue time
+func Certificates() ([]Certificate, error) {
+	registry.Lock()
+	defer registry.Unlock()
+	if err := load(); err != nil {
+		return nil, err
+	}
+	var list []Certificate
+	for _, c := range registry.m {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].Issued.Before(list[j].Issued)
+	})
+	return list, nil
+}
+
+// Verify returns the name of the agent certificate a TLS connection's client presented or an error if it did not
+// present one or it was not issued by the agent certificate authority, expired, or was revoked
+func Verify(state *tls.ConnectionState) (string, error) {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return "", fmt.Errorf("the client did not present a certificate")
+	}
+	cert := state.PeerCertificates[0]
+	pool, err := Pool()
+	if err != nil {
+		return "", err
+	}
+	opts := x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	if _, err = cert.Verify(opts); err != nil {
+		return "", fmt.Errorf("the certificate for %s is not a valid agent certificate: %s", cert.Subject.CommonName, err.Error())
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if err = load(); err != nil {
+		return "", err
+	}
+	c, ok := registry.m[fingerprint(cert.Raw)]
+	if !ok {
+		return "", fmt.Errorf("the certificate for %s was not issued to an agent", cert.Subject.CommonName)
+	}
+	if !c.Revoked.IsZero() {
+		return "", fmt.Errorf("agent certificate %s is revoked", c.Name)
+	}
+	return c.Name, nil
+}
+
+// Seen records that the agent checked in with the certificate a TLS connection's client presented so the certificate
+// can be revoked by the agent's ID
+func Seen(state *tls.ConnectionState, agentID uuid.UUID) {
+	if state == nil || len(state.PeerCertificates) == 0 || agentID == uuid.Nil {
+		return
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if load() != nil {
+		return
+	}
+	c, ok := registry.m[fingerprint(state.PeerCertificates[0].Raw)]
+	if !ok {
+		return
+	}
+	for _, a := range c.Agents {
+		if a == agentID {
+			return
+		}
+	}
+	c.Agents = append(c.Agents, agentID)
+	if err := save(); err != nil {
+		logging.Server(err.Error())
+	}
+}
+
+// Pool returns a certificate pool with the agent certificate authority for verifying agents' client certificates,
+// creating the authority the first time it is used
+func Pool() (*x509.CertPool, error) {
+	caCert, _, err := authority()
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	return pool, nil
+}
+
+// find returns the certificate with the name, or that an agent with the ID checked in with, preferring one that is
+// not revoked and then the latest issued; the caller holds the lock
+func find(name string) *Certificate {
+	id, errID := uuid.FromString(name)
+	var found *Certificate
+	for _, c := range registry.m {
+		match := c.Name == name
+		if errID == nil {
+			for _, a := range c.Agents {
+				match = match || a == id
+			}
+		}
+		if !match {
+			continue
+		}
+		if found == nil || (c.Revoked.IsZero() && !found.Revoked.IsZero()) ||
+			(c.Revoked.IsZero() == found.Revoked.IsZero() && c.Issued.After(found.Issued)) {
+			found = c
+		}
+	}
+	return found
+}
+
+// load reads the certificate registry from Dir the first time it is used or when Dir changed; the caller holds the lock
+func load() error {
+	if registry.loaded && registry.dir == Dir {
+		return nil
+	}
+	m := make(map[string]*Certificate)
+	data, err := ioutil.ReadFile(filepath.Join(Dir, "certificates.json")) // #nosec G304 - The file is in Merlin's data directory
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("there was an error reading the agent certificate registry:\r\n%s", err.Error())
+	}
+	if err == nil {
+		var list []*Certificate
+		if err = json.Unmarshal(data, &list); err != nil {
+			return fmt.Errorf("there was an error parsing the agent certificate registry:\r\n%s", err.Error())
+		}
+		for _, c := range list {
+			m[c.Fingerprint] = c
+		}
+	}
+	registry.m = m
+	registry.dir = Dir
+	registry.loaded = true
+	return nil
+}
+
+// save writes the certificate registry to Dir; the caller holds the lock
+func save() error {
+	var list []*Certificate
+	for _, c := range registry.m {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Issued.Before(list[j].Issued) })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("there was an error encoding the agent certificate registry:\r\n%s", err.Error())
+	}
+	if err = os.MkdirAll(Dir, 0700); err != nil {
+		return fmt.Errorf("there was an error creating the agent certificate directory %s:\r\n%s", Dir, err.Error())
+	}
+	if err = ioutil.WriteFile(filepath.Join(Dir, "certificates.json"), data, 0600); err != nil {
+		return fmt.Errorf("there was an error writing the agent certificate registry:\r\n%s", err.Error())
+	}
+	return nil
+}
+
+// authority returns the agent certificate authority, reading it from Dir or creating it the first time it is used
+func authority() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	ca.Lock()
+	defer ca.Unlock()
+	if ca.cert != nil && ca.dir == Dir {
+		return ca.cert, ca.key, nil
+	}
+	caCert, caKey, err := readAuthority()
+	if err != nil {
+		return nil, nil, err
+	}
+	ca.dir, ca.cert, ca.key = Dir, caCert, caKey
+	return caCert, caKey, nil
+}
+
+// readAuthority reads the agent certificate authority from Dir and creates it if it does not exist
+func readAuthority() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certFile := filepath.Join(Dir, "ca.crt")
+	keyFile := filepath.Join(Dir, "ca.key")
+	if _, err := os.Stat(certFile); err == nil {
+		cer, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("there was an error loading the agent certificate authority:\r\n%s", err.Error())
+		}
+		caCert, err := x509.ParseCertificate(cer.Certificate[0])
+		if err != nil {
+			return nil, nil, fmt.Errorf("there was an error parsing the agent certificate authority:\r\n%s", err.Error())
+		}
+		caKey, ok := cer.PrivateKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, nil, fmt.Errorf("the agent certificate authority's key is not an ECDSA key")
+		}
+		return caCert, caKey, nil
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("there was an error generating the agent certificate authority's key:\r\n%s", err.Error())
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Merlin agent certificate authority"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("there was an error creating the agent certificate authority:\r\n%s", err.Error())
+	}
+	key, err := x509.MarshalECPrivateKey(caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("there was an error encoding the agent certificate authority's key:\r\n%s", err.Error())
+	}
+	if err = os.MkdirAll(Dir, 0700); err != nil {
+		return nil, nil, fmt.Errorf("there was an error creating the agent certificate directory %s:\r\n%s", Dir, err.Error())
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		return nil, nil, fmt.Errorf("there was an error writing the agent certificate authority's key:\r\n%s", err.Error())
+	}
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		return nil, nil, fmt.Errorf("there was an error writing the agent certificate authority:\r\n%s", err.Error())
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	logging.Server(fmt.Sprintf("Created the agent certificate authority %s", certFile))
+	return caCert, caKey, nil
+}
+
+// fingerprint returns the hex encoded SHA256 hash of a DER encoded certificate
+func fingerprint(der []byte) string {
+	hash := sha256.Sum256(der)
+	return hex.EncodeToString(hash[:])
+}
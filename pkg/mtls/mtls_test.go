@@ -0,0 +1,106 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package mtls
+
+import (
+	// Standard
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// TestClientCertificates verifies a listener accepts an issued agent certificate over mutual TLS, records the agent
+// that used it, and rejects connections without one or with a certificate that was revoked by the agent's ID
+func TestClientCertificates(t *testing.T) {
+	Dir = t.TempDir()
+	certPEM, err := Issue("lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Issue("lab"); err == nil {
+		t.Error("a certificate was issued again for a name that is not revoked")
+	}
+	if _, err = Issue("../lab"); err == nil {
+		t.Error("a certificate name with a path separator was issued")
+	}
+	pool, err := Pool()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agentID := uuid.NewV4()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, err := Verify(r.TLS)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		Seen(r.TLS, agentID)
+		fmt.Fprint(w, name)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: pool}
+	server.StartTLS()
+	defer server.Close()
+
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(certPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(certs []tls.Certificate) (int, string) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: certs}, // #nosec G402
+		}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, body := get([]tls.Certificate{cert}); code != http.StatusOK || body != "lab" {
+		t.Errorf("the issued certificate returned %d %q", code, body)
+	}
+	if code, _ := get(nil); code != http.StatusNotFound {
+		t.Errorf("a connection without a certificate returned %d", code)
+	}
+	list, err := Certificates()
+	if err != nil || len(list) != 1 || len(list[0].Agents) != 1 || list[0].Agents[0] != agentID {
+		t.Fatalf("the certificate registry was %+v: %v", list, err)
+	}
+
+	if err = Revoke(agentID.String()); err != nil {
+		t.Fatal(err)
+	}
+	if err = Revoke("lab"); err == nil {
+		t.Error("a revoked certificate was revoked again")
+	}
+	if code, _ := get([]tls.Certificate{cert}); code != http.StatusNotFound {
+		t.Errorf("a revoked certificate returned %d", code)
+	}
+	if _, err = Issue("lab"); err != nil {
+		t.Errorf("a certificate could not be issued for the name of a revoked one: %s", err)
+	}
+}
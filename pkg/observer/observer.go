@@ -0,0 +1,224 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package observer mirrors the agents, loot, and events of a primary Merlin server through its REST API, read-only, for
+// trainers or white-cell observers who must watch an engagement without the ability to task agents
+package observer
+
+import (
+	// Standard
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/api"
+)
+
+// Observer polls a primary server's REST API and mirrors what it finds
+type Observer struct {
+	URL      string               // The primary server's REST API base URL (i.e. https://10.0.0.5:50050)
+	Dir      string               // The directory loot is mirrored to
+	Interval time.Duration        // How often the primary server is polled
+	client   *api.Client          // The primary server's REST API client
+	since    uint64               // The ID of the last event received
+	agents   map[string]string    // The last known status of each agent
+	loot     map[string]time.Time // The modification time of each mirrored loot file
+}
+
+// New returns an observer for the primary server's REST API using a read-only observer token. If pin is not empty, the
+// server's certificate must have that SHA256 fingerprint instead of being signed by a trusted certificate authority.
+func New(apiURL string, token string, pin string, dir string, interval time.Duration) (*Observer, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("%s is not a valid https URL for the primary server's REST API", apiURL)
+	}
+	if token == "" {
+		return nil, errors.New("an observer token for the primary server's REST API is required")
+	}
+	if interval <= 0 {
+		return nil, errors.New("the poll interval must be greater than zero")
+	}
+
+	return &Observer{
+		URL:      strings.TrimSuffix(u.String(), "/"),
+		Dir:      dir,
+		Interval: interval,
+		client:   api.NewClient(u.String(), token, pin),
+		agents:   make(map[string]string),
+		loot:     make(map[string]time.Time),
+	}, nil
+}
+
+// maxBackoff is the longest the observer waits to poll the primary server again after consecutive errors
+const maxBackoff = 5 * time.Minute
+
+// Run polls the primary server until the context is canceled. When a poll fails, such as while the primary server is
+// restarting or the network is down, it is retried with an exponential backoff up to maxBackoff.
+func (o *Observer) Run(ctx context.Context) error {
+	message("note", fmt.Sprintf("Observing %s every %s; loot is mirrored to %s", o.URL, o.Interval, o.Dir))
+	var failures int
+	for {
+		wait := o.Interval
+		if err := o.poll(ctx); err != nil {
+			failures++
+			wait = backoff(o.Interval, failures)
+			message("warn", fmt.Sprintf("There was an error polling the primary server (attempt %d); retrying in "+
+				"%s:\r\n%s", failures, wait, err.Error()))
+		} else if failures > 0 {
+			message("success", fmt.Sprintf("Reconnected to %s after %d failed attempts", o.URL, failures))
+			failures = 0
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// backoff returns how long to wait after the number of consecutive failed polls: the interval doubled for each
+// failure, up to maxBackoff
+func backoff(interval time.Duration, failures int) time.Duration {
+	wait := interval
+	for i := 0; i < failures && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
+
+// poll mirrors the primary server's events, agents, and loot once
+func (o *Observer) poll(ctx context.Context) error {
+	events, err := o.client.Messages(ctx, o.since)
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		if e.Agent != "" {
+			color.White(fmt.Sprintf("[%s][%s]%s", e.Time.Format(time.RFC3339), e.Agent, e.Message))
+		} else {
+			color.White(fmt.Sprintf("[%s]%s", e.Time.Format(time.RFC3339), e.Message))
+		}
+		o.since = e.ID
+	}
+
+	agents, err := o.client.Agents(ctx)
+	if err != nil {
+		return err
+	}
+	changed := len(agents) != len(o.agents)
+	for _, a := range agents {
+		if o.agents[a.ID.String()] != a.Status {
+			changed = true
+			o.agents[a.ID.String()] = a.Status
+		}
+	}
+	if changed {
+		showAgents(agents)
+	}
+
+	loot, err := o.client.Loot(ctx)
+	if err != nil {
+		return err
+	}
+	for _, l := range loot {
+		key := l.Agent + "/" + l.Name
+		if modified, ok := o.loot[key]; ok && modified.Equal(l.Modified) {
+			continue
+		}
+		if err := o.download(ctx, l); err != nil {
+			message("warn", err.Error())
+			continue
+		}
+		o.loot[key] = l.Modified
+	}
+	return nil
+}
+
+// download mirrors a loot file to the observer's directory
+func (o *Observer) download(ctx context.Context, l api.LootFile) error {
+	// Only use the base names so the primary server can not write outside of the loot directory
+	agentDir, name := filepath.Base(l.Agent), filepath.Base(l.Name)
+	if agentDir == "." || agentDir == ".." || name == "." || name == ".." {
+		return fmt.Errorf("skipping invalid loot file %s/%s", l.Agent, l.Name)
+	}
+	dir := filepath.Join(o.Dir, agentDir)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("there was an error creating the loot directory:\r\n%s", err.Error())
+	}
+	// Download to a temporary file so a failed download does not replace an earlier copy
+	tmp := filepath.Join(dir, name+".part")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600) // #nosec G304 - The names are base names
+	if err != nil {
+		return err
+	}
+	err = o.client.DownloadLoot(ctx, agentDir, name, f)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("there was an error downloading %s/%s:\r\n%s", agentDir, name, err.Error())
+	}
+	return os.Rename(tmp, filepath.Join(dir, name))
+}
+
+// showAgents displays the primary server's agents in a table
+func showAgents(agents []api.Agent) {
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ID.String() < agents[j].ID.String() })
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Agent GUID", "Platform", "User", "Host", "Transport", "Status", "Last Check In"})
+	table.SetAlignment(tablewriter.ALIGN_CENTER)
+	for _, a := range agents {
+		table.Append([]string{a.ID.String(), a.Platform + "/" + a.Architecture, a.UserName, a.HostName, a.Proto, a.Status,
+			a.LastCheckIn.Format(time.RFC3339)})
+	}
+	fmt.Println()
+	table.Render()
+	fmt.Println()
+}
+
+// message is used to print a message to the command line
+func message(level string, message string) {
+	switch level {
+	case "info":
+		color.Cyan("[i]" + message)
+	case "note":
+		color.Yellow("[-]" + message)
+	case "warn":
+		color.Red("[!]" + message)
+	case "debug":
+		color.Red("[DEBUG]" + message)
+	case "success":
+		color.Green("[+]" + message)
+	default:
+		color.Red("[_-_]Invalid message level: " + message)
+	}
+}
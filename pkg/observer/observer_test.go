@@ -0,0 +1,51 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package observer
+
+import (
+	// Standard
+	"context"
+	"testing"
+	"time"
+)
+
+// TestBackoff verifies the wait after failed polls doubles and is capped
+func TestBackoff(t *testing.T) {
+	if w := backoff(time.Second, 1); w != 2*time.Second {
+		t.Errorf("expected 2s after the first failure, got %s", w)
+	}
+	if w := backoff(time.Second, 3); w != 8*time.Second {
+		t.Errorf("expected 8s after the third failure, got %s", w)
+	}
+	if w := backoff(time.Second, 100); w != maxBackoff {
+		t.Errorf("expected the backoff to be capped at %s, got %s", maxBackoff, w)
+	}
+}
+
+// TestRunRetries verifies the observer keeps retrying an unreachable primary server until it is canceled
+func TestRunRetries(t *testing.T) {
+	o, err := New("https://127.0.0.1:1", "token", "", t.Name(), time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := o.Run(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected the observer to run until the context was canceled, got %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package operator keeps each operator's console preferences, such as which commands ask for confirmation before they
+// run, in a profile file so they persist across server restarts
+package operator
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/events"
+)
+
+const (
+	// Always asks the operator to confirm the command unless it is run with -y
+	Always = "always"
+	// Never runs the command without asking
+	Never = "never"
+	// Default removes the operator's choice so the command's default policy is used
+	Default = "default"
+)
+
+// Confirmable are the commands that can ask for confirmation and their default policy
+var Confirmable = map[string]string{
+	"exit":         Always, // Exit and close the Merlin server; quit is the same command
+	"kill":         Never,  // Instruct an agent to die
+	"killall":      Always, // Instruct every agent to remove its persistence and artifacts and then exit
+	"killswitch":   Always, // Instruct every agent on a listener to remove its persistence and artifacts and then exit
+	"remove":       Never,  // Remove a dead agent from the server
+	"selfdestruct": Always, // Instruct an agent to remove its persistence and artifacts and then exit
+}
+
+// Notifiable are the events that can be shown to the operator as notifications and whether they are shown by default
+var Notifiable = map[string]bool{
+	events.NewAgent: true,  // A new agent registered
+	events.Job:      true,  // An agent returned the results of a job
+	events.Dead:     true,  // An agent missed more check ins than its retries allow
+	events.Status:   true,  // An agent became delayed, or active again
+	events.CheckIn:  false, // An agent's status check in
+	events.Listener: false, // A listener started or stopped
+}
+
+// YesWords are the answers that confirm a command when an operator has not chosen their own
+var YesWords = []string{"y", "yes"}
+
+// Dir is the directory the operator profiles are stored in
+var Dir = filepath.Join(core.CurrentDir, "data", "operators")
+
+// validName are the characters allowed in an operator name so it can be used as a file name
+var validName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Profile is an operator's console preferences
+type Profile struct {
+	Name          string            `json:"name"`
+	Confirmations map[string]string `json:"confirmations,omitempty"` // Policies that replace the defaults, keyed by command
+	YesWords      []string          `json:"yeswords,omitempty"`      // Answers that confirm a command, such as "ja" or "sí"
+	Notifications map[string]bool   `json:"notifications,omitempty"` // Notification settings that replace the defaults, keyed by event
+}
+
+// Load returns the operator's profile, or a profile with the default preferences if the operator does not have one
+func Load(name string) (*Profile, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("%s is not a valid operator name; use letters, numbers, dots, dashes, and underscores", name)
+	}
+	p := &Profile{Name: name}
+	data, err := ioutil.ReadFile(p.file())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return p, nil
+		}
+		return nil, fmt.Errorf("there was an error reading the operator profile %s:\r\n%s", p.file(), err.Error())
+	}
+	if err = json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("there was an error parsing the operator profile %s:\r\n%s", p.file(), err.Error())
+	}
+	p.Name = name
+	return p, nil
+}
+
+// Save writes the profile to the operator's profile file
+func (p *Profile) Save() error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("there was an error encoding the operator profile:\r\n%s", err.Error())
+	}
+	if err = os.MkdirAll(Dir, 0700); err != nil {
+		return fmt.Errorf("there was an error creating the operator profile directory %s:\r\n%s", Dir, err.Error())
+	}
+	if err = ioutil.WriteFile(p.file(), data, 0600); err != nil {
+		return fmt.Errorf("there was an error writing the operator profile %s:\r\n%s", p.file(), err.Error())
+	}
+	return nil
+}
+
+// Policy returns the confirmation policy, always or never, the operator uses for the command
+func (p *Profile) Policy(command string) string {
+	if policy, ok := p.Confirmations[command]; ok {
+		return policy
+	}
+	return Confirmable[command]
+}
+
+// ConfirmRequired returns true if the operator must confirm the command before it runs
+func (p *Profile) ConfirmRequired(command string) bool {
+	return p.Policy(command) == Always
+}
+
+// SetPolicy sets the confirmation policy for the command to always, never, or back to its default
+func (p *Profile) SetPolicy(command string, policy string) error {
+	if _, ok := Confirmable[command]; !ok {
+		return fmt.Errorf("%s is not a command that asks for confirmation; use %s", command, strings.Join(Commands(), ", "))
+	}
+	switch policy {
+	case Always, Never:
+		if p.Confirmations == nil {
+			p.Confirmations = make(map[string]string)
+		}
+		p.Confirmations[command] = policy
+	case Default:
+		delete(p.Confirmations, command)
+	default:
+		return fmt.Errorf("%s is not a valid confirmation policy; use %s, %s, or %s", policy, Always, Never, Default)
+	}
+	return nil
+}
+
+// Words returns the answers that confirm a command for the operator
+func (p *Profile) Words() []string {
+	if len(p.YesWords) > 0 {
+		return p.YesWords
+	}
+	return YesWords
+}
+
+// SetWords sets the answers that confirm a command; no words restores the defaults
+func (p *Profile) SetWords(words []string) error {
+	for _, w := range words {
+		if strings.TrimSpace(w) == "" || strings.ContainsAny(w, " \t") {
+			return fmt.Errorf("%q is not a valid answer; answers are single words", w)
+		}
+	}
+	p.YesWords = words
+	return nil
+}
+
+// Yes returns true if the answer is one of the operator's words that confirm a command. Case and surrounding white
+// space are ignored in any language, so "JA" matches "ja" and "SÍ" matches "sí".
+func (p *Profile) Yes(answer string) bool {
+	answer = strings.TrimSpace(answer)
+	for _, w := range p.Words() {
+		if answer != "" && strings.EqualFold(answer, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify returns true if the operator is shown a notification for the event
+func (p *Profile) Notify(event string) bool {
+	if on, ok := p.Notifications[event]; ok {
+		return on
+	}
+	return Notifiable[event]
+}
+
+// SetNotify turns the notifications for the event on or off
+func (p *Profile) SetNotify(event string, on bool) error {
+	if _, ok := Notifiable[event]; !ok {
+		return fmt.Errorf("%s is not an event with notifications; use %s", event, strings.Join(NotifyEvents(), ", "))
+	}
+	if p.Notifications == nil {
+		p.Notifications = make(map[string]bool)
+	}
+	p.Notifications[event] = on
+	return nil
+}
+
+// NotifyEvents returns the events that can be shown as notifications in alphabetical order
+func NotifyEvents() []string {
+	var list []string
+	for e := range Notifiable {
+		list = append(list, e)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Commands returns the commands that can ask for confirmation in alphabetical order
+func Commands() []string {
+	var commands []string
+	for c := range Confirmable {
+		commands = append(commands, c)
+	}
+	sort.Strings(commands)
+	return commands
+}
+
+// file returns the path of the operator's profile file
+func (p *Profile) file() string {
+	return filepath.Join(Dir, p.Name+".json")
+}
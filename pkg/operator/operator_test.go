@@ -0,0 +1,100 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package operator
+
+import (
+	// Standard
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestProfile verifies confirmation policies and notification settings replace the defaults and persist in the
+// operator's profile
+func TestProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "operators")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // #nosec G104
+	Dir = dir
+
+	if _, err = Load("../root"); err == nil {
+		t.Error("an operator name with a path separator was accepted")
+	}
+	p, err := Load("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.ConfirmRequired("exit") || p.ConfirmRequired("kill") {
+		t.Error("a new profile did not use the default confirmation policies")
+	}
+	if err = p.SetPolicy("exit", Never); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetPolicy("kill", Always); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetPolicy("ls", Always); err == nil {
+		t.Error("a policy was set for a command that does not ask for confirmation")
+	}
+	if err = p.SetPolicy("remove", "sometimes"); err == nil {
+		t.Error("an invalid policy was accepted")
+	}
+	if !p.Yes(" YES\r\n") || p.Yes("ja") {
+		t.Error("a new profile did not use the default yes words")
+	}
+	if err = p.SetWords([]string{"ja", "sí"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetWords([]string{"of course"}); err == nil {
+		t.Error("a yes word with a space was accepted")
+	}
+	if !p.Notify("job") || p.Notify("checkin") {
+		t.Error("a new profile did not use the default notification settings")
+	}
+	if err = p.SetNotify("job", false); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetNotify("ls", true); err == nil {
+		t.Error("a notification was set for an event that does not have notifications")
+	}
+	if err = p.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = Load("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ConfirmRequired("exit") || !p.ConfirmRequired("kill") {
+		t.Errorf("the saved policies were not loaded: %v", p.Confirmations)
+	}
+	if !p.Yes("SÍ") || !p.Yes("ja") || p.Yes("yes") {
+		t.Errorf("the saved yes words were not used: %v", p.YesWords)
+	}
+	if p.Notify("job") || !p.Notify("dead") {
+		t.Errorf("the saved notification settings were not loaded: %v", p.Notifications)
+	}
+	if err = p.SetPolicy("exit", Default); err != nil || !p.ConfirmRequired("exit") {
+		t.Error("resetting a policy did not restore the default")
+	}
+	if other, err := Load("bob"); err != nil || other.ConfirmRequired("kill") {
+		t.Error("one operator's policies were used for another operator")
+	}
+}
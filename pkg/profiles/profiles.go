@@ -0,0 +1,258 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package profiles shapes the HTTP requests agents send and the responses listeners return so agent traffic resembles
+// another application. A profile is a JSON file that sets the URIs agents post to, the request and response headers,
+// the cookie that carries the agent's JSON Web Token, and the transforms applied to message bodies. The same profile
+// is loaded by the listener and embedded in the agents generated for it.
+package profiles
+
+import (
+	// Standard
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Profile controls how agent messages look on the wire over HTTP
+type Profile struct {
+	Name      string   `json:"name"`
+	URIs      []string `json:"uris"`                // URL paths agents post to; one is picked at random for each message
+	UserAgent string   `json:"useragent,omitempty"` // Replaces the agent's User-Agent header
+	Cookie    string   `json:"cookie,omitempty"`    // Cookie that carries the agent's JWT instead of the Authorization header
+	Client    Message  `json:"client"`              // How agents send messages
+	Server    Message  `json:"server"`              // How the listener answers
+}
+
+// Message is the headers and body transforms for one direction of traffic
+type Message struct {
+	Headers map[string]string `json:"headers,omitempty"`
+	// Transforms are applied to the body in order when it is sent and in reverse when it is received: base64,
+	// base64url, hex, gzip, prepend:<text>, and append:<text>
+	Transforms []string `json:"transforms,omitempty"`
+}
+
+// Load reads and validates the profile in the JSON file
+func Load(file string) (*Profile, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error reading the profile %s:\r\n%s", file, err.Error())
+	}
+	var p Profile
+	if err = json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("there was an error parsing the profile %s:\r\n%s", file, err.Error())
+	}
+	if err = p.Validate(); err != nil {
+		return nil, fmt.Errorf("the profile %s is not valid:\r\n%s", file, err.Error())
+	}
+	return &p, nil
+}
+
+// Validate returns an error if the profile has no URIs, a URI is not an absolute URL path, or a transform is unknown
+func (p *Profile) Validate() error {
+	if len(p.URIs) == 0 {
+		return errors.New("the profile does not have any URIs")
+	}
+	for _, u := range p.URIs {
+		if u == "" || u[0] != '/' || strings.ContainsAny(u, "?#") {
+			return fmt.Errorf("%s is not a valid URI; it must be an absolute URL path (i.e. /api/v2/update)", u)
+		}
+	}
+	for _, m := range []Message{p.Client, p.Server} {
+		for _, t := range m.Transforms {
+			if _, err := transform(t, nil, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateDirectory loads every profile JSON file in the directory and returns the number of profiles checked with the
+// error for each profile that could not be loaded, by its file path. A directory that does not exist has no profiles.
+func ValidateDirectory(dir string) (int, map[string]error) {
+	errs := make(map[string]error)
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, errs
+	} else if err != nil {
+		errs[dir] = fmt.Errorf("there was an error reading the profile directory:\r\n%s", err.Error())
+		return 0, errs
+	}
+	var count int
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		count++
+		file := filepath.Join(dir, f.Name())
+		if _, err := Load(file); err != nil {
+			errs[file] = err
+		}
+	}
+	return count, errs
+}
+
+// URL returns the agent's URL with its path replaced by one of the profile's URIs picked at random
+func (p *Profile) URL(agentURL string) (string, error) {
+	u, err := url.Parse(agentURL)
+	if err != nil {
+		return "", fmt.Errorf("there was an error parsing the URL %s:\r\n%s", agentURL, err.Error())
+	}
+	// #nosec G404 -- Picking a URI does not need a cryptographically secure random number
+	u.Path = p.URIs[rand.Intn(len(p.URIs))]
+	return u.String(), nil
+}
+
+// HasURI returns true if the URL path is one of the profile's URIs
+func (p *Profile) HasURI(path string) bool {
+	for _, u := range p.URIs {
+		if u == path {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRequest adds the profile's client headers, User-Agent, and the agent's JWT to the request
+func (p *Profile) SetRequest(req *http.Request, jwt string) {
+	for k, v := range p.Client.Headers {
+		req.Header.Set(k, v)
+	}
+	if p.UserAgent != "" {
+		req.Header.Set("User-Agent", p.UserAgent)
+	}
+	if p.Cookie == "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", jwt))
+		return
+	}
+	req.AddCookie(&http.Cookie{Name: p.Cookie, Value: jwt})
+}
+
+// Token returns the agent's JWT from the request as an Authorization header value (i.e. Bearer <JWT>)
+func (p *Profile) Token(r *http.Request) string {
+	if p.Cookie != "" {
+		if c, err := r.Cookie(p.Cookie); err == nil {
+			return "Bearer " + c.Value
+		}
+	}
+	return r.Header.Get("Authorization")
+}
+
+// Encode applies the transforms to the data in order
+func (m Message) Encode(data []byte) ([]byte, error) {
+	var err error
+	for _, t := range m.Transforms {
+		if data, err = transform(t, data, true); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// Decode reverses the transforms on the data in reverse order
+func (m Message) Decode(data []byte) ([]byte, error) {
+	var err error
+	for i := len(m.Transforms) - 1; i >= 0; i-- {
+		if data, err = transform(m.Transforms[i], data, false); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// transform encodes the data with the named transform, or decodes it when encode is false
+func transform(name string, data []byte, encode bool) ([]byte, error) {
+	switch {
+	case name == "base64":
+		return base64Transform(base64.StdEncoding, data, encode)
+	case name == "base64url":
+		return base64Transform(base64.RawURLEncoding, data, encode)
+	case name == "hex":
+		if encode {
+			return []byte(hex.EncodeToString(data)), nil
+		}
+		b, err := hex.DecodeString(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("there was an error decoding the hex body:\r\n%s", err.Error())
+		}
+		return b, nil
+	case name == "gzip":
+		if encode {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			if _, err := zw.Write(data); err != nil {
+				return nil, fmt.Errorf("there was an error compressing the body:\r\n%s", err.Error())
+			}
+			if err := zw.Close(); err != nil {
+				return nil, fmt.Errorf("there was an error compressing the body:\r\n%s", err.Error())
+			}
+			return buf.Bytes(), nil
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("there was an error decompressing the body:\r\n%s", err.Error())
+		}
+		b, err := ioutil.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("there was an error decompressing the body:\r\n%s", err.Error())
+		}
+		return b, nil
+	case strings.HasPrefix(name, "prepend:"):
+		text := []byte(strings.TrimPrefix(name, "prepend:"))
+		if encode {
+			return append(append([]byte{}, text...), data...), nil
+		}
+		if !bytes.HasPrefix(data, text) {
+			return nil, errors.New("the body does not start with the profile's prepended text")
+		}
+		return data[len(text):], nil
+	case strings.HasPrefix(name, "append:"):
+		text := []byte(strings.TrimPrefix(name, "append:"))
+		if encode {
+			return append(append([]byte{}, data...), text...), nil
+		}
+		if !bytes.HasSuffix(data, text) {
+			return nil, errors.New("the body does not end with the profile's appended text")
+		}
+		return data[:len(data)-len(text)], nil
+	}
+	return nil, fmt.Errorf("%s is not a valid transform; use base64, base64url, hex, gzip, prepend:<text>, or append:<text>", name)
+}
+
+// base64Transform encodes or decodes the data with the base64 encoding
+func base64Transform(encoding *base64.Encoding, data []byte, encode bool) ([]byte, error) {
+	if encode {
+		return []byte(encoding.EncodeToString(data)), nil
+	}
+	b, err := encoding.DecodeString(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("there was an error decoding the base64 body:\r\n%s", err.Error())
+	}
+	return b, nil
+}
@@ -0,0 +1,100 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package profiles
+
+import (
+	// Standard
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+// TestProfile verifies the example profile loads, its transforms round trip, and the JWT is carried in its cookie
+func TestProfile(t *testing.T) {
+	p, err := Load(filepath.Join("..", "..", "data", "profiles", "jquery.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("merlin message")
+	for _, m := range []Message{p.Client, p.Server} {
+		encoded, err := m.Encode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := m.Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Errorf("the decoded message %q does not match %q", decoded, data)
+		}
+	}
+
+	u, err := p.URL("https://127.0.0.1:443/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.HasURI(req.URL.Path) {
+		t.Errorf("%s is not one of the profile's URIs", req.URL.Path)
+	}
+	p.SetRequest(req, "jwt")
+	if req.Header.Get("Authorization") != "" {
+		t.Error("the JWT was sent in the Authorization header instead of the profile's cookie")
+	}
+	if token := p.Token(req); token != "Bearer jwt" {
+		t.Errorf("the token %q was not read from the profile's cookie", token)
+	}
+
+	bad := Profile{URIs: []string{"/a"}, Client: Message{Transforms: []string{"rot13"}}}
+	if bad.Validate() == nil {
+		t.Error("a profile with an unknown transform was valid")
+	}
+}
+
+// TestValidateDirectory verifies every profile in a directory is loaded and the broken ones are reported by file
+func TestValidateDirectory(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"good.json":      `{"name": "good", "uris": ["/a"]}`,
+		"invalid.json":   `{"name": "invalid", "uris": ["a"]}`,
+		"malformed.json": `{"name": "malformed",`,
+		"README.MD":      "not a profile",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, errs := ValidateDirectory(dir)
+	if count != 3 {
+		t.Errorf("%d profiles were checked instead of 3", count)
+	}
+	if len(errs) != 2 || errs[filepath.Join(dir, "invalid.json")] == nil || errs[filepath.Join(dir, "malformed.json")] == nil {
+		t.Errorf("the broken profiles were not reported: %v", errs)
+	}
+	if count, errs = ValidateDirectory(filepath.Join(dir, "missing")); count != 0 || len(errs) != 0 {
+		t.Errorf("a missing directory had %d profiles and errors %v", count, errs)
+	}
+}
@@ -0,0 +1,215 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package recording records operator terminal sessions in the asciinema cast v2 format, the input and output of the
+// session with their timing, and plays them back
+package recording
+
+import (
+	// Standard
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Extension is the file extension of a recording
+const Extension = ".cast"
+
+// Header is the first line of a recording and describes the terminal it was recorded in
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Event is one line of a recording after the header: the seconds since the recording started, the type of event, "o"
+// for output or "i" for input, and its data
+type Event struct {
+	Time float64
+	Type string
+	Data string
+}
+
+// Recorder writes a recording's events to its file as they happen
+type Recorder struct {
+	sync.Mutex
+	file  *os.File
+	start time.Time
+	err   error
+}
+
+// Create creates a recording file, writes its header, and returns the Recorder that writes its events
+func Create(path string, width, height int, title string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600) // #nosec G304 the operator chose the path
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{file: f, start: time.Now()}
+	header := Header{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": os.Getenv("TERM"), "SHELL": os.Getenv("SHELL")},
+	}
+	line, err := json.Marshal(header)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if _, err = fmt.Fprintf(f, "%s\n", line); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write records terminal output. Line feeds are written as a carriage return and line feed, as a terminal displays
+// them, so the recording plays back the same in any player.
+func (r *Recorder) Write(p []byte) (int, error) {
+	data := strings.Replace(strings.Replace(string(p), "\r\n", "\n", -1), "\n", "\r\n", -1)
+	r.event("o", data)
+	return len(p), nil
+}
+
+// Input records a line of operator input
+func (r *Recorder) Input(line string) {
+	r.event("i", line+"\r")
+}
+
+// event writes an event to the recording; the first error stops the recording and is returned by Close
+func (r *Recorder) event(t, data string) {
+	r.Lock()
+	defer r.Unlock()
+	if r.err != nil || r.file == nil {
+		return
+	}
+	line, err := json.Marshal([]interface{}{time.Since(r.start).Seconds(), t, data})
+	if err == nil {
+		_, err = fmt.Fprintf(r.file, "%s\n", line)
+	}
+	r.err = err
+}
+
+// Name returns the path of the recording file
+func (r *Recorder) Name() string {
+	return r.file.Name()
+}
+
+// Duration returns how long the recording has been recording
+func (r *Recorder) Duration() time.Duration {
+	return time.Since(r.start)
+}
+
+// Close stops the recording and closes its file
+func (r *Recorder) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	if r.file == nil {
+		return errors.New("the recording is already closed")
+	}
+	err := r.file.Close()
+	r.file = nil
+	if r.err != nil {
+		return fmt.Errorf("there was an error writing the recording:\r\n%s", r.err.Error())
+	}
+	return err
+}
+
+// Read reads a recording's header and events
+func Read(reader io.Reader) (Header, []Event, error) {
+	var header Header
+	var events []Event
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		if scanner.Err() != nil {
+			return header, nil, scanner.Err()
+		}
+		return header, nil, errors.New("the recording is empty")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return header, nil, fmt.Errorf("there was an error parsing the recording header:\r\n%s", err.Error())
+	}
+	if header.Version != 2 {
+		return header, nil, fmt.Errorf("version %d recordings are not supported", header.Version)
+	}
+	for n := 2; scanner.Scan(); n++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var fields []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			return header, nil, fmt.Errorf("there was an error parsing line %d of the recording:\r\n%s", n, err.Error())
+		}
+		if len(fields) != 3 {
+			return header, nil, fmt.Errorf("line %d of the recording is not an event", n)
+		}
+		seconds, okTime := fields[0].(float64)
+		t, okType := fields[1].(string)
+		data, okData := fields[2].(string)
+		if !okTime || !okType || !okData {
+			return header, nil, fmt.Errorf("line %d of the recording is not an event", n)
+		}
+		events = append(events, Event{Time: seconds, Type: t, Data: data})
+	}
+	return header, events, scanner.Err()
+}
+
+// Play writes the output events of a recording to the writer with their original timing divided by the speed. Pauses
+// longer than the idle limit are shortened to it unless it is zero. Play returns when the recording ends or the context
+// is cancelled.
+func Play(ctx context.Context, events []Event, w io.Writer, speed float64, idle time.Duration) error {
+	if speed <= 0 {
+		return fmt.Errorf("%g is not a valid playback speed", speed)
+	}
+	var last float64
+	for _, e := range events {
+		if e.Type != "o" {
+			continue
+		}
+		wait := time.Duration((e.Time - last) / speed * float64(time.Second))
+		if idle > 0 && wait > idle {
+			wait = idle
+		}
+		last = e.Time
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if _, err := io.WriteString(w, e.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
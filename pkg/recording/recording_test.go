@@ -0,0 +1,88 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package recording
+
+import (
+	// Standard
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRecordAndPlay verifies a recording is read back with its header and events and plays only its output
+func TestRecordAndPlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merlin-recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // #nosec G104
+	path := filepath.Join(dir, "session"+Extension)
+
+	r, err := Create(path, 120, 40, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Write([]byte("Merlin» ")); err != nil {
+		t.Fatal(err)
+	}
+	r.Input("sessions")
+	if _, err = r.Write([]byte("one\ntwo\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Create(path, 120, 40, "test"); err == nil {
+		t.Error("an existing recording was overwritten")
+	}
+
+	f, err := os.Open(path) // #nosec G304
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() // #nosec G307
+	header, events, err := Read(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 2 || header.Width != 120 || header.Height != 40 || header.Title != "test" {
+		t.Errorf("the header was %+v", header)
+	}
+	if len(events) != 3 || events[1].Type != "i" || events[1].Data != "sessions\r" {
+		t.Fatalf("the events were %+v", events)
+	}
+	if events[2].Data != "one\r\ntwo\r\n" {
+		t.Errorf("the output line feeds were recorded as %q", events[2].Data)
+	}
+
+	var out bytes.Buffer
+	if err = Play(context.Background(), events, &out, 1000, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "Merlin» one\r\ntwo\r\n" {
+		t.Errorf("the recording played %q", out.String())
+	}
+	if _, _, err = Read(strings.NewReader(`{"version": 1}`)); err == nil {
+		t.Error("a version 1 recording was read")
+	}
+}
@@ -0,0 +1,150 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package redact masks secrets, such as passwords and tokens typed into commands, before they are echoed to the
+// console, saved to the command history, or written to the logs. Jobs still use the original values.
+package redact
+
+import (
+	// Standard
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Mask replaces a redacted secret
+const Mask = "********"
+
+// defaults are the patterns used when no others are added: key=value secrets, secret command line flags, the password
+// argument of runas, make_token, and creds add commands, and the password in a URL
+var defaults = []string{
+	`(?i)(?:password|passwd|pwd|secret|token|api[_-]?key)\s*[=:]\s*([^\s;&"']+)`,
+	`(?i)(?:^|\s)--?(?:password|passwd|pass|pw|secret|token|hashes)(?:\s+|=)(\S+)`,
+	`(?i)\b(?:runas|make_token)\s+\S+\s+(\S+)`,
+	`(?i)\bcreds\s+add\s+\S+\s+(\S+)`,
+	`://[^/\s:@]+:([^/\s@]+)@`,
+}
+
+// patterns are the regular expressions of the secrets to mask
+var patterns = struct {
+	sync.RWMutex
+	list []*regexp.Regexp
+}{}
+
+func init() {
+	for _, p := range defaults {
+		patterns.list = append(patterns.list, regexp.MustCompile(p))
+	}
+}
+
+// Add adds a regular expression for a secret to mask. The text matched by each capture group is masked, or the whole
+// match if the expression does not have a capture group.
+func Add(pattern string) error {
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid regular expression:\r\n%s", pattern, err.Error())
+	}
+	patterns.Lock()
+	defer patterns.Unlock()
+	for _, p := range patterns.list {
+		if p.String() == pattern {
+			return fmt.Errorf("%s is already a redaction pattern", pattern)
+		}
+	}
+	patterns.list = append(patterns.list, r)
+	return nil
+}
+
+// Remove removes a redaction pattern
+func Remove(pattern string) error {
+	patterns.Lock()
+	defer patterns.Unlock()
+	for i, p := range patterns.list {
+		if p.String() == pattern {
+			patterns.list = append(patterns.list[:i], patterns.list[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a redaction pattern", pattern)
+}
+
+// GetPatterns returns the redaction patterns
+func GetPatterns() []string {
+	patterns.RLock()
+	defer patterns.RUnlock()
+	var list []string
+	for _, p := range patterns.list {
+		list = append(list, p.String())
+	}
+	return list
+}
+
+// Load adds the patterns in the file, one per line; empty lines and lines starting with # are ignored
+func Load(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("there was an error opening the redaction patterns file %s:\r\n%s", file, err.Error())
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err = Add(line); err != nil && !strings.Contains(err.Error(), "already a redaction pattern") {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// String returns the text with every secret matched by the redaction patterns masked
+func String(s string) string {
+	patterns.RLock()
+	defer patterns.RUnlock()
+	for _, p := range patterns.list {
+		matches := p.FindAllStringSubmatchIndex(s, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		var b strings.Builder
+		last := 0
+		for _, m := range matches {
+			spans := [][2]int{{m[0], m[1]}}
+			if len(m) > 2 {
+				spans = nil
+				for g := 2; g < len(m); g += 2 {
+					if m[g] >= 0 && m[g] >= last {
+						spans = append(spans, [2]int{m[g], m[g+1]})
+					}
+				}
+			}
+			for _, span := range spans {
+				b.WriteString(s[last:span[0]])
+				b.WriteString(Mask)
+				last = span[1]
+			}
+		}
+		b.WriteString(s[last:])
+		s = b.String()
+	}
+	return s
+}
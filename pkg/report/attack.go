@@ -0,0 +1,125 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	// Standard
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+)
+
+// Layer is a MITRE ATT&CK Navigator layer of the techniques used during the operation, scored by the number of hosts
+// each technique was used on
+type Layer struct {
+	Name        string            `json:"name"`
+	Versions    map[string]string `json:"versions"`
+	Domain      string            `json:"domain"`
+	Description string            `json:"description"`
+	Techniques  []LayerTechnique  `json:"techniques"`
+	Gradient    LayerGradient     `json:"gradient"`
+}
+
+// LayerTechnique is a technique in a MITRE ATT&CK Navigator layer
+type LayerTechnique struct {
+	TechniqueID       string          `json:"techniqueID"`
+	Score             int             `json:"score,omitempty"`
+	Comment           string          `json:"comment,omitempty"`
+	Enabled           bool            `json:"enabled"`
+	ShowSubtechniques bool            `json:"showSubtechniques"`
+	Metadata          []LayerMetadata `json:"metadata,omitempty"`
+}
+
+// LayerMetadata is a name and value shown with a technique in a MITRE ATT&CK Navigator layer
+type LayerMetadata struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// LayerGradient colors a MITRE ATT&CK Navigator layer's techniques by their score
+type LayerGradient struct {
+	Colors   []string `json:"colors"`
+	MinValue int      `json:"minValue"`
+	MaxValue int      `json:"maxValue"`
+}
+
+// Navigator returns the MITRE ATT&CK Navigator layer of the techniques jobs used in the time range; a zero since
+// starts at the beginning of the operation and a zero until ends now
+func Navigator(name string, since time.Time, until time.Time) (Layer, error) {
+	layer := Layer{
+		Name:     name,
+		Versions: map[string]string{"attack": "15", "navigator": "5.0.0", "layer": "4.5"},
+		Domain:   "enterprise-attack",
+		Gradient: LayerGradient{Colors: []string{"#ffe766ff", "#ff6666ff"}, MinValue: 0, MaxValue: 1},
+	}
+	span := Report{Since: since, Until: until}
+	if until.IsZero() {
+		span.Until = time.Now().UTC()
+	}
+	layer.Description = fmt.Sprintf("Techniques Merlin jobs used for %s, scored by the number of hosts", period(span))
+
+	coverage, err := agents.Coverage(since, until)
+	if err != nil {
+		return layer, err
+	}
+	parents := make(map[string]bool)
+	for _, c := range coverage {
+		t := LayerTechnique{TechniqueID: c.ID, Score: len(c.Hosts), Enabled: true,
+			Comment: fmt.Sprintf("%d jobs from %s", c.Jobs, strings.Join(c.Sources, ", "))}
+		for _, h := range c.Hosts {
+			t.Metadata = append(t.Metadata, LayerMetadata{Name: "host", Value: h})
+		}
+		layer.Techniques = append(layer.Techniques, t)
+		if len(c.Hosts) > layer.Gradient.MaxValue {
+			layer.Gradient.MaxValue = len(c.Hosts)
+		}
+		if i := strings.Index(c.ID, "."); i > 0 {
+			parents[c.ID[:i]] = true
+		}
+	}
+	// The Navigator only shows a sub-technique when its parent technique is expanded
+	for i, t := range layer.Techniques {
+		if parents[t.TechniqueID] {
+			layer.Techniques[i].ShowSubtechniques = true
+			delete(parents, t.TechniqueID)
+		}
+	}
+	for id := range parents {
+		layer.Techniques = append(layer.Techniques, LayerTechnique{TechniqueID: id, Enabled: true, ShowSubtechniques: true})
+	}
+	sort.Slice(layer.Techniques, func(i, j int) bool { return layer.Techniques[i].TechniqueID < layer.Techniques[j].TechniqueID })
+	return layer, nil
+}
+
+// WriteNavigator writes the MITRE ATT&CK Navigator layer to the file as JSON
+func WriteNavigator(layer Layer, file string) error {
+	data, err := json.MarshalIndent(layer, "", "  ")
+	if err != nil {
+		return fmt.Errorf("there was an error rendering the MITRE ATT&CK Navigator layer:\r\n%s", err.Error())
+	}
+	if err = ioutil.WriteFile(file, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("there was an error writing the MITRE ATT&CK Navigator layer %s:\r\n%s", file, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	// Standard
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+)
+
+// TestNavigator verifies techniques are scored by their hosts and sub-techniques are shown under their parent
+func TestNavigator(t *testing.T) {
+	agents.AttackFile = filepath.Join(t.TempDir(), "attack.json")
+	lines := `{"time":"2026-01-02T10:00:00Z","technique":"T1003.001","agent":"a","host":"ws01","job":"j1","source":"Invoke-Mimikatz"}
+{"time":"2026-01-02T11:00:00Z","technique":"T1003.001","agent":"b","host":"ws02","job":"j2","source":"minidump"}
+{"time":"2026-01-02T12:00:00Z","technique":"T1083","agent":"b","host":"ws02","job":"j3","source":"ls"}
+{"time":"2026-01-03T12:00:00Z","technique":"T1113","agent":"b","host":"ws02","job":"j4","source":"screenshot"}
+`
+	if err := ioutil.WriteFile(agents.AttackFile, []byte(lines), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	layer, err := Navigator("op", time.Time{}, time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scores := make(map[string]LayerTechnique)
+	for _, technique := range layer.Techniques {
+		scores[technique.TechniqueID] = technique
+	}
+	if len(scores) != 3 {
+		t.Fatalf("expected T1003, T1003.001, and T1083, got %v", layer.Techniques)
+	}
+	if s := scores["T1003.001"]; s.Score != 2 || len(s.Metadata) != 2 {
+		t.Errorf("expected T1003.001 to be scored for 2 hosts, got %+v", s)
+	}
+	if s := scores["T1003"]; !s.ShowSubtechniques || s.Score != 0 {
+		t.Errorf("expected T1003 to show its sub-techniques without a score, got %+v", s)
+	}
+	if s := scores["T1083"]; s.Score != 1 {
+		t.Errorf("expected T1083 to be scored for 1 host, got %+v", s)
+	}
+	if layer.Gradient.MaxValue != 2 {
+		t.Errorf("expected a gradient up to 2 hosts, got %d", layer.Gradient.MaxValue)
+	}
+}
@@ -0,0 +1,343 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package report builds an engagement report from the server's agents, the operator audit log, the event log, and the
+// credential store: a timeline of the agents that checked in, the commands operators ran, the files transferred, and
+// the credentials captured, written as Markdown, HTML, or JSON.
+package report
+
+import (
+	// Standard
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/agents"
+	"github.com/Ne0nd0g/merlin/pkg/audit"
+	"github.com/Ne0nd0g/merlin/pkg/creds"
+	"github.com/Ne0nd0g/merlin/pkg/events"
+)
+
+// Timeline item kinds
+const (
+	Agent      = "agent"      // An agent checked in for the first time
+	Command    = "command"    // An operator ran a command against an agent
+	File       = "file"       // A file was uploaded to or downloaded from an agent
+	Credential = "credential" // A credential was captured
+)
+
+// Report is an engagement report for a time range
+type Report struct {
+	Generated   time.Time      `json:"generated"`
+	Since       time.Time      `json:"since,omitempty"` // The start of the report's time range; zero for the start of the operation
+	Until       time.Time      `json:"until"`
+	Agents      []AgentInfo    `json:"agents"`
+	Timeline    []Item         `json:"timeline"`
+	Files       []Item         `json:"files"`
+	Credentials []CredInfo     `json:"credentials"`
+	Operators   map[string]int `json:"operators"` // The number of commands each operator ran
+}
+
+// AgentInfo is an agent that was active during the report's time range
+type AgentInfo struct {
+	ID           string    `json:"id"`
+	HostName     string    `json:"hostname"`
+	UserName     string    `json:"username"`
+	Platform     string    `json:"platform"`
+	Architecture string    `json:"architecture"`
+	Transport    string    `json:"transport"`
+	First        time.Time `json:"first"` // The agent's initial check in
+	Last         time.Time `json:"last"`  // The agent's most recent check in
+}
+
+// Item is something that happened during the operation
+type Item struct {
+	Time        time.Time `json:"time"`
+	Kind        string    `json:"kind"`
+	Agent       string    `json:"agent,omitempty"`
+	Actor       string    `json:"actor,omitempty"` // The operator that ran the command; empty for items the agents caused
+	Description string    `json:"description"`
+}
+
+// CredInfo is a captured credential without its secret, which is kept out of the report
+type CredInfo struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Domain string    `json:"domain,omitempty"`
+	User   string    `json:"user"`
+	Host   string    `json:"host,omitempty"`
+	Agent  string    `json:"agent,omitempty"`
+	Source string    `json:"source"`
+}
+
+// navigation are the commands that only change menus or show information on the server; they are left out of the
+// timeline
+var navigation = map[string]bool{
+	"?": true, "help": true, "back": true, "main": true, "info": true, "interact": true, "show": true, "jobs": true,
+	"crashes": true, "diagnose": true, "cleanup": true, "note": true, "set": true,
+}
+
+// downloaded matches the event published when an agent returns a file
+var downloaded = regexp.MustCompile(`^Job (\S+) downloaded (.+)$`)
+
+// Build returns the report for the time range; a zero since starts at the beginning of the operation and a zero until
+// ends now
+func Build(since time.Time, until time.Time) (Report, error) {
+	now := time.Now().UTC()
+	if until.IsZero() {
+		until = now
+	}
+	r := Report{Generated: now, Since: since, Until: until, Operators: make(map[string]int)}
+	in := func(t time.Time) bool { return !t.Before(since) && t.Before(until) }
+
+	for id, a := range agents.Agents {
+		if a.InitialCheckIn.IsZero() || !a.InitialCheckIn.Before(until) || a.StatusCheckIn.Before(since) {
+			continue
+		}
+		info := AgentInfo{
+			ID:           id.String(),
+			HostName:     a.HostName,
+			UserName:     a.UserName,
+			Platform:     a.Platform,
+			Architecture: a.Architecture,
+			Transport:    a.Proto,
+			First:        a.InitialCheckIn,
+			Last:         a.StatusCheckIn,
+		}
+		r.Agents = append(r.Agents, info)
+		if in(a.InitialCheckIn) {
+			r.Timeline = append(r.Timeline, Item{Time: a.InitialCheckIn, Kind: Agent, Agent: info.ID,
+				Description: fmt.Sprintf("Agent checked in from %s as %s (%s/%s)", a.HostName, a.UserName, a.Platform,
+					a.Architecture)})
+		}
+	}
+	sort.Slice(r.Agents, func(i, j int) bool { return r.Agents[i].First.Before(r.Agents[j].First) })
+
+	entries, err := audit.Query(audit.Filter{Since: since, Until: until})
+	if err != nil {
+		return r, err
+	}
+	for _, e := range entries {
+		if e.Agent == "" || navigation[e.Command] {
+			continue
+		}
+		line := strings.TrimSpace(e.Command + " " + strings.Join(e.Args, " "))
+		r.Timeline = append(r.Timeline, Item{Time: e.Time, Kind: Command, Agent: e.Agent, Actor: e.Client, Description: line})
+		r.Operators[e.Client]++
+		if e.Command == "upload" && len(e.Args) >= 2 {
+			r.Files = append(r.Files, Item{Time: e.Time, Kind: File, Agent: e.Agent, Actor: e.Client,
+				Description: fmt.Sprintf("Uploaded %s to %s", e.Args[0], e.Args[1])})
+		}
+	}
+
+	list, err := events.Query(events.Filter{Type: events.Job, Since: since, Until: until})
+	if err != nil {
+		return r, err
+	}
+	for _, e := range list {
+		if m := downloaded.FindStringSubmatch(e.Message); m != nil {
+			r.Files = append(r.Files, Item{Time: e.Time, Kind: File, Agent: e.Agent,
+				Description: fmt.Sprintf("Downloaded %s with job %s", m[2], m[1])})
+		}
+	}
+
+	credentials, err := creds.List("")
+	if err != nil {
+		return r, err
+	}
+	for _, c := range credentials {
+		if !in(c.Time) {
+			continue
+		}
+		r.Credentials = append(r.Credentials, CredInfo{Time: c.Time, Type: c.Type, Domain: c.Domain, User: c.User,
+			Host: c.Host, Agent: c.Agent, Source: c.Source})
+		user := c.User
+		if c.Domain != "" {
+			user = c.Domain + `\` + c.User
+		}
+		r.Timeline = append(r.Timeline, Item{Time: c.Time, Kind: Credential, Agent: c.Agent,
+			Description: fmt.Sprintf("Captured the %s of %s from %s", c.Type, user, c.Source)})
+	}
+
+	sort.SliceStable(r.Files, func(i, j int) bool { return r.Files[i].Time.Before(r.Files[j].Time) })
+	for _, f := range r.Files {
+		if f.Actor == "" {
+			// Uploads are already in the timeline as the command that sent them
+			r.Timeline = append(r.Timeline, f)
+		}
+	}
+	sort.SliceStable(r.Timeline, func(i, j int) bool { return r.Timeline[i].Time.Before(r.Timeline[j].Time) })
+	return r, nil
+}
+
+// Write writes the report to the file as Markdown if it has a .md extension, HTML if it has a .html extension, or JSON
+// if it has a .json extension
+func Write(r Report, file string) error {
+	var b bytes.Buffer
+	var err error
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".md", ".markdown":
+		writeMarkdown(&b, r)
+	case ".html", ".htm":
+		err = page.Execute(&b, r)
+	case ".json":
+		var data []byte
+		if data, err = json.MarshalIndent(r, "", "  "); err == nil {
+			b.Write(append(data, '\n'))
+		}
+	default:
+		return fmt.Errorf("%s is not a valid report file; use a .md, .html, or .json extension", file)
+	}
+	if err != nil {
+		return fmt.Errorf("there was an error rendering the report:\r\n%s", err.Error())
+	}
+	if err = ioutil.WriteFile(file, b.Bytes(), 0600); err != nil {
+		return fmt.Errorf("there was an error writing the report %s:\r\n%s", file, err.Error())
+	}
+	return nil
+}
+
+// writeMarkdown writes the report as Markdown with a table for each section
+func writeMarkdown(b *bytes.Buffer, r Report) {
+	fmt.Fprintf(b, "# Merlin Engagement Report\n\n")
+	fmt.Fprintf(b, "Generated %s for %s\n\n", r.Generated.Format(time.RFC3339), period(r))
+	fmt.Fprintf(b, "%d agents, %d commands, %d file transfers, and %d credentials\n", len(r.Agents), commands(r),
+		len(r.Files), len(r.Credentials))
+
+	fmt.Fprintf(b, "\n## Agents\n\n| Agent | Host | User | Platform | Transport | First Check In | Last Check In |\n")
+	fmt.Fprintf(b, "|---|---|---|---|---|---|---|\n")
+	for _, a := range r.Agents {
+		row(b, a.ID, a.HostName, a.UserName, a.Platform+"/"+a.Architecture, a.Transport, a.First.Format(time.RFC3339),
+			a.Last.Format(time.RFC3339))
+	}
+
+	fmt.Fprintf(b, "\n## Operators\n\n| Operator | Commands |\n|---|---|\n")
+	for _, o := range operators(r) {
+		row(b, o, fmt.Sprint(r.Operators[o]))
+	}
+
+	fmt.Fprintf(b, "\n## Timeline\n\n| Time | Kind | Agent | Operator | Description |\n|---|---|---|---|---|\n")
+	for _, i := range r.Timeline {
+		row(b, i.Time.Format(time.RFC3339), i.Kind, i.Agent, i.Actor, i.Description)
+	}
+
+	fmt.Fprintf(b, "\n## File Transfers\n\n| Time | Agent | Operator | Description |\n|---|---|---|---|\n")
+	for _, i := range r.Files {
+		row(b, i.Time.Format(time.RFC3339), i.Agent, i.Actor, i.Description)
+	}
+
+	fmt.Fprintf(b, "\n## Credentials\n\n| Time | Type | Domain | User | Host | Agent | Source |\n|---|---|---|---|---|---|---|\n")
+	for _, c := range r.Credentials {
+		row(b, c.Time.Format(time.RFC3339), c.Type, c.Domain, c.User, c.Host, c.Agent, c.Source)
+	}
+}
+
+// row writes a Markdown table row with the pipes and line breaks in its cells escaped
+func row(b *bytes.Buffer, cells ...string) {
+	r := strings.NewReplacer("|", `\|`, "\r", "", "\n", " ")
+	for i := range cells {
+		cells[i] = r.Replace(cells[i])
+	}
+	fmt.Fprintf(b, "| %s |\n", strings.Join(cells, " | "))
+}
+
+// period returns the report's time range as text
+func period(r Report) string {
+	if r.Since.IsZero() {
+		return "the operation until " + r.Until.Format(time.RFC3339)
+	}
+	return r.Since.Format(time.RFC3339) + " to " + r.Until.Format(time.RFC3339)
+}
+
+// commands returns the number of commands operators ran
+func commands(r Report) int {
+	var n int
+	for _, c := range r.Operators {
+		n += c
+	}
+	return n
+}
+
+// operators returns the operators that ran commands sorted by name
+func operators(r Report) []string {
+	var list []string
+	for o := range r.Operators {
+		list = append(list, o)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// page is the HTML report
+var page = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time":      func(t time.Time) string { return t.Format(time.RFC3339) },
+	"period":    period,
+	"commands":  commands,
+	"operators": operators,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Merlin Engagement Report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #999; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { background: #eee; }
+td.kind-agent { color: #06c; } td.kind-command { color: #333; } td.kind-file { color: #080; } td.kind-credential { color: #c00; }
+</style>
+</head>
+<body>
+<h1>Merlin Engagement Report</h1>
+<p>Generated {{time .Generated}} for {{period .}}</p>
+<p>{{len .Agents}} agents, {{commands .}} commands, {{len .Files}} file transfers, and {{len .Credentials}} credentials</p>
+<h2>Agents</h2>
+<table>
+<tr><th>Agent</th><th>Host</th><th>User</th><th>Platform</th><th>Transport</th><th>First Check In</th><th>Last Check In</th></tr>
+{{range .Agents}}<tr><td>{{.ID}}</td><td>{{.HostName}}</td><td>{{.UserName}}</td><td>{{.Platform}}/{{.Architecture}}</td><td>{{.Transport}}</td><td>{{time .First}}</td><td>{{time .Last}}</td></tr>
+{{end}}</table>
+<h2>Operators</h2>
+<table>
+<tr><th>Operator</th><th>Commands</th></tr>
+{{$r := .}}{{range operators .}}<tr><td>{{.}}</td><td>{{index $r.Operators .}}</td></tr>
+{{end}}</table>
+<h2>Timeline</h2>
+<table>
+<tr><th>Time</th><th>Kind</th><th>Agent</th><th>Operator</th><th>Description</th></tr>
+{{range .Timeline}}<tr><td>{{time .Time}}</td><td class="kind-{{.Kind}}">{{.Kind}}</td><td>{{.Agent}}</td><td>{{.Actor}}</td><td>{{.Description}}</td></tr>
+{{end}}</table>
+<h2>File Transfers</h2>
+<table>
+<tr><th>Time</th><th>Agent</th><th>Operator</th><th>Description</th></tr>
+{{range .Files}}<tr><td>{{time .Time}}</td><td>{{.Agent}}</td><td>{{.Actor}}</td><td>{{.Description}}</td></tr>
+{{end}}</table>
+<h2>Credentials</h2>
+<table>
+<tr><th>Time</th><th>Type</th><th>Domain</th><th>User</th><th>Host</th><th>Agent</th><th>Source</th></tr>
+{{range .Credentials}}<tr><td>{{time .Time}}</td><td>{{.Type}}</td><td>{{.Domain}}</td><td>{{.User}}</td><td>{{.Host}}</td><td>{{.Agent}}</td><td>{{.Source}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,91 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	// Standard
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/audit"
+	"github.com/Ne0nd0g/merlin/pkg/creds"
+	"github.com/Ne0nd0g/merlin/pkg/events"
+)
+
+// TestReport verifies commands, file transfers, and credentials are in the timeline and written without secrets
+func TestReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	audit.File = filepath.Join(dir, "audit.json")
+	events.File = filepath.Join(dir, "events.json")
+	creds.File = filepath.Join(dir, "creds.json")
+
+	agent := "0d4b1e5c-6f1a-4c53-9d3f-2b1a7e1f9c10"
+	for _, e := range []audit.Entry{
+		{Client: "alice", Agent: agent, Menu: "agent", Command: "help"},
+		{Client: "alice", Agent: agent, Menu: "agent", Command: "upload", Args: []string{"/tmp/a|b.exe", `C:\a.exe`}},
+		{Client: "bob", Agent: agent, Menu: "agent", Command: "ls"},
+		{Client: audit.Console, Menu: "main", Command: "listeners"},
+	} {
+		if err := audit.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := events.Publish(events.Job, agent, `Job abc downloaded C:\secret.docx`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := creds.Add(agent, "ws01", []creds.Credential{{Type: creds.Password, Domain: "CORP", User: "bob", Secret: "Summer2019!", Source: "mimikatz"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Build(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Timeline) != 4 || r.Operators["alice"] != 1 || r.Operators["bob"] != 1 {
+		t.Errorf("the timeline should have 2 commands, a download, and a credential: %+v %+v", r.Timeline, r.Operators)
+	}
+	if len(r.Files) != 2 || len(r.Credentials) != 1 {
+		t.Errorf("the report should have an upload, a download, and a credential: %+v %+v", r.Files, r.Credentials)
+	}
+
+	for _, name := range []string{"report.md", "report.html", "report.json"} {
+		file := filepath.Join(dir, name)
+		if err := Write(r, file); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "Summer2019!") || !strings.Contains(string(data), `secret.docx`) {
+			t.Errorf("%s has the credential's secret or is missing the download:\n%s", name, data)
+		}
+	}
+	if err := Write(r, filepath.Join(dir, "report.txt")); err == nil {
+		t.Error("the report was written to a file without a report extension")
+	}
+}
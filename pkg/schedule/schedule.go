@@ -0,0 +1,176 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package schedule parses the working hours an agent only checks in during, such as 0900-1700 Mon-Fri, and finds the
+// next time inside them. The agent and the server share it so the server expects check ins when the agent makes them.
+package schedule
+
+import (
+	// Standard
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// days are the abbreviations of the days of the week in the order of time.Weekday
+var days = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Hours is a daily window, such as 0900-1700, on the selected days of the week. A window that ends before it starts,
+// such as 2200-0600, runs past midnight into the next day.
+type Hours struct {
+	Start int     // Minutes after midnight the window opens
+	End   int     // Minutes after midnight the window closes
+	Days  [7]bool // The days of the week, indexed by time.Weekday, the window opens on
+}
+
+// Parse reads working hours written as HHMM-HHMM followed by optional days of the week, such as 0900-1700 Mon-Fri or
+// 2200-0600 Sat,Sun; every day is used if the days are left out. An empty string or off returns nil, no working hours.
+func Parse(s string) (*Hours, error) {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 0 || (len(fields) == 1 && fields[0] == "off") {
+		return nil, nil
+	}
+	if len(fields) > 2 {
+		return nil, fmt.Errorf("%q is not valid working hours; use HHMM-HHMM [Mon-Fri]", s)
+	}
+	span := strings.Split(strings.Replace(fields[0], ":", "", -1), "-")
+	if len(span) != 2 {
+		return nil, fmt.Errorf("%q is not a valid time range; use HHMM-HHMM such as 0900-1700", fields[0])
+	}
+	var h Hours
+	var err error
+	if h.Start, err = minutes(span[0]); err != nil {
+		return nil, err
+	}
+	if h.End, err = minutes(span[1]); err != nil {
+		return nil, err
+	}
+	if h.Start == h.End {
+		return nil, fmt.Errorf("the working hours %s start and end at the same time", fields[0])
+	}
+	if len(fields) == 1 {
+		h.Days = [7]bool{true, true, true, true, true, true, true}
+		return &h, nil
+	}
+	for _, d := range strings.Split(fields[1], ",") {
+		r := strings.Split(d, "-")
+		if len(r) > 2 {
+			return nil, fmt.Errorf("%q is not a valid day range; use Mon-Fri", d)
+		}
+		first, err := day(r[0])
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if len(r) == 2 {
+			if last, err = day(r[1]); err != nil {
+				return nil, err
+			}
+		}
+		// A range such as Fri-Mon wraps around the end of the week
+		for i := first; ; i = (i + 1) % 7 {
+			h.Days[i] = true
+			if i == last {
+				break
+			}
+		}
+	}
+	return &h, nil
+}
+
+// String returns the working hours in the form Parse reads
+func (h *Hours) String() string {
+	if h == nil {
+		return ""
+	}
+	s := fmt.Sprintf("%02d%02d-%02d%02d", h.Start/60, h.Start%60, h.End/60, h.End%60)
+	var selected []string
+	for i := 0; i < 7; i++ {
+		if !h.Days[i] {
+			continue
+		}
+		// Collapse consecutive days into a range
+		j := i
+		for j+1 < 7 && h.Days[j+1] {
+			j++
+		}
+		name := strings.Title(days[i])
+		if j > i {
+			name += "-" + strings.Title(days[j])
+		}
+		selected = append(selected, name)
+		i = j
+	}
+	if len(selected) == 1 && selected[0] == "Sun-Sat" {
+		return s
+	}
+	return s + " " + strings.Join(selected, ",")
+}
+
+// Within returns true if the time is inside the working hours in the time's location
+func (h *Hours) Within(t time.Time) bool {
+	if h == nil {
+		return true
+	}
+	m := t.Hour()*60 + t.Minute()
+	d := int(t.Weekday())
+	if h.Start < h.End {
+		return h.Days[d] && m >= h.Start && m < h.End
+	}
+	// The window runs past midnight and belongs to the day it opened on
+	return (h.Days[d] && m >= h.Start) || (h.Days[(d+6)%7] && m < h.End)
+}
+
+// Next returns the time if it is inside the working hours, otherwise the time the working hours next open
+func (h *Hours) Next(t time.Time) time.Time {
+	if h == nil || h.Within(t) {
+		return t
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for i := 0; i <= 7; i++ {
+		open := midnight.AddDate(0, 0, i).Add(time.Duration(h.Start) * time.Minute)
+		if open.After(t) && h.Days[open.Weekday()] {
+			return open
+		}
+	}
+	return t
+}
+
+// minutes converts HHMM to the minutes after midnight
+func minutes(s string) (int, error) {
+	if len(s) != 4 {
+		return 0, fmt.Errorf("%q is not a time; use HHMM such as 0900", s)
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n/100 > 23 || n%100 > 59 {
+		return 0, fmt.Errorf("%q is not a time; use HHMM such as 0900", s)
+	}
+	return n/100*60 + n%100, nil
+}
+
+// day converts the abbreviation of a day of the week to its time.Weekday
+func day(s string) (int, error) {
+	if len(s) >= 3 {
+		for i, d := range days {
+			if strings.HasPrefix(s, d) {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("%q is not a day of the week; use Mon, Tue, Wed, Thu, Fri, Sat, or Sun", s)
+}
@@ -0,0 +1,78 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package schedule
+
+import (
+	// Standard
+	"testing"
+	"time"
+)
+
+// TestParse verifies working hours are read, written back, and rejected when they are not valid
+func TestParse(t *testing.T) {
+	cases := map[string]string{
+		"0900-1700 Mon-Fri": "0900-1700 Mon-Fri",
+		"09:00-17:00":       "0900-1700",
+		"2200-0600 fri-mon": "2200-0600 Sun-Mon,Fri-Sat",
+		"0800-1200 Mon,Wed": "0800-1200 Mon,Wed",
+	}
+	for in, want := range cases {
+		h, err := Parse(in)
+		if err != nil {
+			t.Errorf("%s: %s", in, err)
+			continue
+		}
+		if h.String() != want {
+			t.Errorf("expected %s to be written as %s, got %s", in, want, h.String())
+		}
+	}
+	if h, err := Parse("off"); h != nil || err != nil {
+		t.Errorf("expected off to be no working hours, got %v %v", h, err)
+	}
+	for _, bad := range []string{"0900", "2500-1700", "0900-0900", "0900-1700 Mon-Funday", "0900-1700 Mon Fri"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+// TestNext verifies times outside the working hours move to when they next open, including over midnight and weekends
+func TestNext(t *testing.T) {
+	office, _ := Parse("0900-1700 Mon-Fri")
+	night, _ := Parse("2200-0600 Fri")
+	// 2021-03-05 is a Friday
+	at := func(day, hour, minute int) time.Time { return time.Date(2021, 3, day, hour, minute, 0, 0, time.UTC) }
+	cases := []struct {
+		h    *Hours
+		in   time.Time
+		want time.Time
+	}{
+		{office, at(5, 10, 0), at(5, 10, 0)},
+		{office, at(5, 17, 0), at(8, 9, 0)},
+		{office, at(4, 7, 30), at(4, 9, 0)},
+		{office, at(6, 12, 0), at(8, 9, 0)},
+		{night, at(6, 3, 0), at(6, 3, 0)},
+		{night, at(6, 7, 0), at(12, 22, 0)},
+		{nil, at(6, 7, 0), at(6, 7, 0)},
+	}
+	for _, c := range cases {
+		if got := c.h.Next(c.in); !got.Equal(c.want) {
+			t.Errorf("%s: expected %s to move to %s, got %s", c.h.String(), c.in, c.want, got)
+		}
+	}
+}
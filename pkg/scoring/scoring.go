@@ -0,0 +1,233 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package scoring awards points when agent jobs succeed on designated hosts, such as reading a flag file, and notifies
+// a training range's scoreboard with a webhook so Merlin can be the attacker component of a capture-the-flag exercise
+package scoring
+
+import (
+	// Standard
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule awards points the first time a job that matches it succeeds on a host
+type Rule struct {
+	Name    string `json:"name"`
+	Points  int    `json:"points"`
+	Host    string `json:"host,omitempty"`    // Regular expression matched against the agent's host name; empty matches any host
+	Job     string `json:"job,omitempty"`     // The job type, such as cmd or download; empty matches any type
+	Command string `json:"command,omitempty"` // Regular expression matched against the job's arguments
+	Output  string `json:"output,omitempty"`  // Regular expression matched against the job's output, such as the flag format
+	host    *regexp.Regexp
+	command *regexp.Regexp
+	output  *regexp.Regexp
+}
+
+// Config is the scoring configuration file
+type Config struct {
+	Webhook string `json:"webhook,omitempty"` // URL the scores are sent to with a POST request; empty to only record them
+	Secret  string `json:"secret,omitempty"`  // Signs each webhook request with HMAC-SHA256 in the X-Merlin-Signature header
+	Rules   []Rule `json:"rules"`
+}
+
+// Result is a job that succeeded on an agent
+type Result struct {
+	Agent  string
+	Host   string
+	User   string
+	Job    string
+	Type   string
+	Args   []string
+	Output string
+}
+
+// Score is a rule that was satisfied by a job
+type Score struct {
+	Rule     string    `json:"rule"`
+	Points   int       `json:"points"`
+	Agent    string    `json:"agent"`
+	Host     string    `json:"host"`
+	User     string    `json:"user"`
+	Job      string    `json:"job"`
+	Command  string    `json:"command"`
+	Evidence string    `json:"evidence"` // The output that matched the rule
+	Time     time.Time `json:"time"`
+}
+
+// maxEvidence is the number of output characters kept as evidence when a rule does not have an output expression
+const maxEvidence = 256
+
+var mutex sync.Mutex
+var config *Config
+var scores []Score
+var scored = make(map[string]bool)
+var report func(Score, error)
+
+// Load reads the scoring configuration file and enables scoring. The report function is called after each score's
+// webhook is sent with any error that occurred.
+func Load(file string, reportFunc func(Score, error)) error {
+	data, err := ioutil.ReadFile(file) // #nosec G304 - The file is provided by the operator
+	if err != nil {
+		return fmt.Errorf("there was an error reading the scoring file %s:\r\n%s", file, err.Error())
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return fmt.Errorf("there was an error decoding the scoring file %s:\r\n%s", file, err.Error())
+	}
+	for i, r := range c.Rules {
+		if r.Name == "" {
+			return fmt.Errorf("scoring rule %d does not have a name", i+1)
+		}
+		if c.Rules[i].host, err = compile(r.Host); err != nil {
+			return fmt.Errorf("scoring rule %s has an invalid host expression:\r\n%s", r.Name, err.Error())
+		}
+		if c.Rules[i].command, err = compile(r.Command); err != nil {
+			return fmt.Errorf("scoring rule %s has an invalid command expression:\r\n%s", r.Name, err.Error())
+		}
+		if c.Rules[i].output, err = compile(r.Output); err != nil {
+			return fmt.Errorf("scoring rule %s has an invalid output expression:\r\n%s", r.Name, err.Error())
+		}
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	config = &c
+	report = reportFunc
+	return nil
+}
+
+// Enabled returns true if a scoring configuration was loaded
+func Enabled() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return config != nil
+}
+
+// Check awards the points for every rule the result satisfies that was not already scored on the host, sends them to
+// the webhook, and returns them
+func Check(r Result) []Score {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if config == nil {
+		return nil
+	}
+	var awarded []Score
+	command := strings.Join(r.Args, " ")
+	for _, rule := range config.Rules {
+		key := rule.Name + "\x00" + strings.ToLower(r.Host)
+		if scored[key] {
+			continue
+		}
+		if rule.Job != "" && !strings.EqualFold(rule.Job, r.Type) {
+			continue
+		}
+		if rule.host != nil && !rule.host.MatchString(r.Host) {
+			continue
+		}
+		if rule.command != nil && !rule.command.MatchString(command) {
+			continue
+		}
+		evidence := r.Output
+		if rule.output != nil {
+			evidence = rule.output.FindString(r.Output)
+			if evidence == "" {
+				continue
+			}
+		} else if len(evidence) > maxEvidence {
+			evidence = evidence[:maxEvidence]
+		}
+		s := Score{
+			Rule:     rule.Name,
+			Points:   rule.Points,
+			Agent:    r.Agent,
+			Host:     r.Host,
+			User:     r.User,
+			Job:      r.Job,
+			Command:  command,
+			Evidence: evidence,
+			Time:     time.Now().UTC(),
+		}
+		scored[key] = true
+		scores = append(scores, s)
+		awarded = append(awarded, s)
+		if config.Webhook != "" {
+			go send(config.Webhook, config.Secret, s, report)
+		}
+	}
+	return awarded
+}
+
+// Scores returns every score awarded since the server started
+func Scores() []Score {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return append([]Score{}, scores...)
+}
+
+// send posts the score to the webhook as JSON
+func send(webhook string, secret string, s Score, report func(Score, error)) {
+	err := post(webhook, secret, s)
+	if report != nil {
+		report(s, err)
+	}
+}
+
+// post sends the score to the webhook and returns an error if it was not accepted
+func post(webhook string, secret string, s Score) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("there was an error encoding the score:\r\n%s", err.Error())
+	}
+	req, err := http.NewRequest(http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("there was an error creating the scoring webhook request:\r\n%s", err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		_, _ = mac.Write(body)
+		req.Header.Set("X-Merlin-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("there was an error sending the score to the webhook:\r\n%s", err.Error())
+	}
+	defer resp.Body.Close() // #nosec G307
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the scoring webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// compile returns the compiled case-insensitive regular expression or nil if it is empty
+func compile(expression string) (*regexp.Regexp, error) {
+	if expression == "" {
+		return nil, nil
+	}
+	return regexp.Compile("(?i)" + expression)
+}
@@ -0,0 +1,90 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package scoring
+
+import (
+	// Standard
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestCheck verifies a matching job is scored once per host and delivered to the webhook with a valid signature
+func TestCheck(t *testing.T) {
+	received := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		_, _ = mac.Write(body)
+		if r.Header.Get("X-Merlin-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		received <- string(body)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "merlin-scoring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // #nosec G104
+	file := filepath.Join(dir, "scoring.json")
+	rules := `{"webhook":"` + srv.URL + `","secret":"secret","rules":[` +
+		`{"name":"dc-flag","points":100,"host":"^dc01$","job":"cmd","command":"flag\\.txt","output":"FLAG\\{[^}]+\\}"}]}`
+	if err := ioutil.WriteFile(file, []byte(rules), 0600); err != nil {
+		t.Fatal(err)
+	}
+	reports := make(chan error, 2)
+	if err := Load(file, func(s Score, err error) { reports <- err }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wrong host, missing flag, then a match
+	if s := Check(Result{Host: "ws01", Type: "cmd", Args: []string{"type", "flag.txt"}, Output: "FLAG{a}"}); len(s) != 0 {
+		t.Errorf("expected no score for another host, got %v", s)
+	}
+	if s := Check(Result{Host: "DC01", Type: "cmd", Args: []string{"type", "flag.txt"}, Output: "access denied"}); len(s) != 0 {
+		t.Errorf("expected no score without the flag, got %v", s)
+	}
+	s := Check(Result{Host: "DC01", Type: "cmd", Args: []string{"type", "flag.txt"}, Output: "the FLAG{d0m41n} is here"})
+	if len(s) != 1 || s[0].Points != 100 || s[0].Evidence != "FLAG{d0m41n}" {
+		t.Fatalf("expected the dc-flag score with the flag as evidence, got %v", s)
+	}
+	if s := Check(Result{Host: "dc01", Type: "cmd", Args: []string{"type", "flag.txt"}, Output: "FLAG{d0m41n}"}); len(s) != 0 {
+		t.Errorf("expected the rule to only be scored once per host, got %v", s)
+	}
+
+	select {
+	case err := <-reports:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the webhook was not called")
+	}
+	if len(Scores()) != 1 {
+		t.Errorf("expected 1 recorded score, got %d", len(Scores()))
+	}
+}
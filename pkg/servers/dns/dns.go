@@ -0,0 +1,379 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package dns is an agent listener that receives the agent's messages in DNS queries and returns the server's responses
+// in TXT or A record answers for networks where HTTP egress is blocked but DNS is allowed
+package dns
+
+import (
+	// Standard
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/fatih/color"
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/dns/dnsmessage"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/dnstunnel"
+	"github.com/Ne0nd0g/merlin/pkg/events"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/servers/http2"
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// maxUDP is the largest response sent over UDP; larger responses are truncated so the resolver retries over TCP
+const maxUDP = 512
+
+// defaultChunkSize is the number of response bytes per answer, by record type, that fits in a UDP response
+var defaultChunkSize = map[string]int{"txt": 180, "a": 84}
+
+// sessionTimeout is how long an uploaded message and its response are kept for retried queries
+const sessionTimeout = 10 * time.Minute
+
+// Server is a DNS listener for agents
+type Server struct {
+	ID         uuid.UUID // Unique identifier for the Server object
+	Interface  string    // The network adapter interface the server will listen on
+	Port       int       // The UDP and TCP port the server will listen on
+	Protocol   string    // Always dns
+	Domain     string    // The domain the server is authoritative for; agent queries are sent to its subdomains
+	RecordType string    // The record type, txt or a, used to return responses to the agent
+	ChunkSize  int       // The number of response bytes returned in one answer
+	handler    *http2.Handler
+	sessions   map[string]*session
+	cleaned    time.Time
+	sync.Mutex
+}
+
+// session is an agent message being uploaded and the server's response to it
+type session struct {
+	chunks   map[int][]byte
+	total    int
+	response []byte
+	rejected bool
+	done     bool
+	created  time.Time
+}
+
+// New returns a DNS listener for the domain that answers agents with the record type, txt or a, and chunk size; a
+// chunk size of zero uses the largest that keeps an answer within a single UDP response
+func New(iface string, port int, domain string, recordType string, chunkSize int, psk string) (*Server, error) {
+	domain = strings.ToLower(strings.Trim(domain, "."))
+	if domain == "" {
+		return nil, fmt.Errorf("a domain is required for the DNS listener")
+	}
+	recordType = strings.ToLower(recordType)
+	if recordType != "txt" && recordType != "a" {
+		return nil, fmt.Errorf("%s is not a valid DNS record type; use txt or a", recordType)
+	}
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize[recordType]
+	}
+	id := uuid.NewV4()
+	handler, err := http2.NewHandler(id, psk)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		ID:         id,
+		Interface:  iface,
+		Port:       port,
+		Protocol:   "dns",
+		Domain:     domain,
+		RecordType: recordType,
+		ChunkSize:  dnstunnel.ChunkSize(recordType, chunkSize),
+		handler:    handler,
+		sessions:   make(map[string]*session),
+	}, nil
+}
+
+// Run starts the UDP and TCP listeners and returns once they are bound
+func (s *Server) Run() error {
+	addr := util.JoinHostPort(s.Interface, strconv.Itoa(s.Port))
+	logging.Server(fmt.Sprintf("Starting dns Listener at %s for %s", addr, s.Domain))
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("there was an error starting the DNS UDP listener:\r\n%s", err.Error())
+	}
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		_ = udp.Close()
+		return fmt.Errorf("there was an error starting the DNS TCP listener:\r\n%s", err.Error())
+	}
+	message("note", fmt.Sprintf("Starting dns listener on %s for %s with %s records", addr, s.Domain, s.RecordType))
+	if err := events.Publish(events.Listener, "", fmt.Sprintf("Started dns listener %s at %s for %s", s.ID, addr, s.Domain)); err != nil {
+		message("warn", err.Error())
+	}
+	go s.serveUDP(udp)
+	go s.serveTCP(tcp)
+	return nil
+}
+
+// serveUDP answers queries received over UDP
+func (s *Server) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			logging.Server(fmt.Sprintf("The DNS UDP listener stopped:\r\n%s", err.Error()))
+			if err := events.Publish(events.Listener, "", fmt.Sprintf("Stopped dns listener %s: %s", s.ID, err.Error())); err != nil {
+				message("warn", err.Error())
+			}
+			return
+		}
+		query := append([]byte{}, buf[:n]...)
+		go func() {
+			if response := s.answer(query, addr.String(), true); response != nil {
+				_, _ = conn.WriteTo(response, addr)
+			}
+		}()
+	}
+}
+
+// serveTCP answers queries received over TCP, which are prefixed with their two byte length
+func (s *Server) serveTCP(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			logging.Server(fmt.Sprintf("The DNS TCP listener stopped:\r\n%s", err.Error()))
+			return
+		}
+		go func() {
+			defer conn.Close() // #nosec G307
+			for {
+				_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+				var length uint16
+				if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+					return
+				}
+				query := make([]byte, length)
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				response := s.answer(query, conn.RemoteAddr().String(), false)
+				if response == nil {
+					return
+				}
+				if err := binary.Write(conn, binary.BigEndian, uint16(len(response))); err != nil {
+					return
+				}
+				if _, err := conn.Write(response); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// answer returns the response to a DNS query or nil if it could not be parsed
+func (s *Server) answer(query []byte, remoteAddr string, udp bool) []byte {
+	var p dnsmessage.Parser
+	header, err := p.Start(query)
+	if err != nil {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+
+	header.Response = true
+	header.Authoritative = true
+	header.RecursionAvailable = false
+	name := strings.ToLower(q.Name.String())
+	var a [][4]byte
+	var txt []string
+	if name != s.Domain+"." && !strings.HasSuffix(name, "."+s.Domain+".") {
+		header.RCode = dnsmessage.RCodeRefused
+	} else if t, err := dnstunnel.ParseName(name, s.Domain); err == nil {
+		a, txt = s.tunnel(t, q.Type, remoteAddr)
+	} else if name != s.Domain+"." {
+		if core.Verbose {
+			message("warn", fmt.Sprintf("Invalid DNS query from %s: %s", remoteAddr, err.Error()))
+		}
+		header.RCode = dnsmessage.RCodeNameError
+	}
+
+	response, err := build(header, q, a, txt)
+	if err != nil {
+		message("warn", fmt.Sprintf("there was an error building the DNS response:\r\n%s", err.Error()))
+		return nil
+	}
+	if udp && len(response) > maxUDP {
+		header.Truncated = true
+		response, err = build(header, q, nil, nil)
+		if err != nil {
+			return nil
+		}
+	}
+	return response
+}
+
+// tunnel handles an upload or download query and returns the A records or TXT strings to answer it with
+func (s *Server) tunnel(t dnstunnel.Query, qtype dnsmessage.Type, remoteAddr string) ([][4]byte, []string) {
+	s.Lock()
+	defer s.Unlock()
+	s.clean()
+
+	switch t.Type {
+	case dnstunnel.Upload:
+		// Resolvers may also ask for AAAA records; only A queries carry the upload
+		if qtype != dnsmessage.TypeA {
+			return nil, nil
+		}
+		ses, ok := s.sessions[t.Session]
+		if !ok {
+			ses = &session{chunks: make(map[int][]byte), total: t.Total, created: time.Now()}
+			s.sessions[t.Session] = ses
+		}
+		if t.Total != ses.total {
+			return dnstunnel.Rejected(), nil
+		}
+		ses.chunks[t.Index] = t.Data
+		if len(ses.chunks) < ses.total {
+			return dnstunnel.Ack(), nil
+		}
+		if !ses.done {
+			s.process(ses, remoteAddr)
+		}
+		if ses.rejected {
+			return dnstunnel.Rejected(), nil
+		}
+		return dnstunnel.Complete((len(ses.response)+s.ChunkSize-1)/s.ChunkSize, len(ses.response)), nil
+	case dnstunnel.Download:
+		ses, ok := s.sessions[t.Session]
+		if !ok || !ses.done || ses.rejected {
+			return nil, nil
+		}
+		start := t.Index * s.ChunkSize
+		if start >= len(ses.response) {
+			return nil, nil
+		}
+		end := start + s.ChunkSize
+		if end > len(ses.response) {
+			end = len(ses.response)
+		}
+		chunk := ses.response[start:end]
+		if s.RecordType == "a" && qtype == dnsmessage.TypeA {
+			return dnstunnel.PackA(chunk), nil
+		}
+		if s.RecordType == "txt" && qtype == dnsmessage.TypeTXT {
+			return nil, dnstunnel.PackTXT(chunk)
+		}
+	}
+	return nil, nil
+}
+
+// process sends the uploaded message to the agent message handler and keeps its response for the agent to download
+func (s *Server) process(ses *session, remoteAddr string) {
+	ses.done = true
+	var data []byte
+	for i := 0; i < ses.total; i++ {
+		data = append(data, ses.chunks[i]...)
+	}
+	ses.chunks = nil
+	token, body, err := dnstunnel.Unpack(data)
+	if err != nil {
+		message("warn", fmt.Sprintf("there was an error unpacking a DNS message from %s:\r\n%s", remoteAddr, err.Error()))
+		ses.rejected = true
+		return
+	}
+	status, response := http2.Serve(s.handler, "DNS", remoteAddr, token, body)
+	if status != http.StatusOK || len(response) == 0 || len(response) > dnstunnel.MaxLength {
+		ses.rejected = true
+		return
+	}
+	ses.response = response
+}
+
+// clean removes sessions that have expired
+func (s *Server) clean() {
+	if time.Since(s.cleaned) < time.Minute {
+		return
+	}
+	s.cleaned = time.Now()
+	for id, ses := range s.sessions {
+		if time.Since(ses.created) > sessionTimeout {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// build returns a DNS response with the A records or TXT strings as its answer
+func build(header dnsmessage.Header, q dnsmessage.Question, a [][4]byte, txt []string) ([]byte, error) {
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), header)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 0}
+	for _, r := range a {
+		if err := b.AResource(rh, dnsmessage.AResource{A: r}); err != nil {
+			return nil, err
+		}
+	}
+	if len(txt) > 0 {
+		if err := b.TXTResource(rh, dnsmessage.TXTResource{TXT: txt}); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// message is used to print a message to the command line
+func message(level string, message string) {
+	switch level {
+	case "info":
+		color.Cyan("[i]" + message)
+	case "note":
+		color.Yellow("[-]" + message)
+	case "warn":
+		color.Red("[!]" + message)
+	case "debug":
+		color.Red("[DEBUG]" + message)
+	case "success":
+		color.Green("[+]" + message)
+	default:
+		color.Red("[_-_]Invalid message level: " + message)
+	}
+}
+
+// PSKs returns the pre-shared keys the listener accepts, starting with its own
+func (s *Server) PSKs() []string {
+	return s.handler.PSKs()
+}
+
+// SetPSKs replaces the pre-shared keys the listener accepts. The first key becomes the listener's own.
+func (s *Server) SetPSKs(psks []string) error {
+	return s.handler.SetPSKs(psks)
+}
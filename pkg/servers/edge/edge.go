@@ -0,0 +1,479 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package edge is the central server's listener for edge nodes. Edge nodes are deployed near targets, terminate the
+// agents' HTTPS connections, and relay their requests to this listener over mutual TLS. Each node is enrolled with a
+// client certificate signed by the edge certificate authority and can be revoked without affecting the other nodes.
+package edge
+
+import (
+	// Standard
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/fatih/color"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/edge"
+	"github.com/Ne0nd0g/merlin/pkg/events"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/servers/http2"
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// Dir is the directory that holds the edge certificate authority, the node registry, and the enrolled nodes'
+// credentials
+var Dir = filepath.Join(core.CurrentDir, "data", "edge")
+
+// validName matches node names, which are also the names of their credentials directories
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Node is an enrolled edge node
+type Node struct {
+	Name        string    `json:"name"`
+	Fingerprint string    `json:"fingerprint"` // The SHA256 fingerprint of the node's client certificate
+	Enrolled    time.Time `json:"enrolled"`
+	Revoked     bool      `json:"revoked"`
+	Address     string    `json:"-"` // The address the node last connected from
+	LastSeen    time.Time `json:"-"` // The last time the node relayed a request or sent a heartbeat
+	Requests    int       `json:"-"` // The number of agent requests the node relayed since the server started
+}
+
+// nodes is the registry of enrolled edge nodes; it is read from Dir the first time it is used
+var nodes = struct {
+	sync.Mutex
+	loaded bool
+	dir    string
+	m      map[string]*Node
+}{}
+
+// Online returns true if the node sent a heartbeat or relayed a request within the last three heartbeats
+func (n Node) Online() bool {
+	return !n.Revoked && !n.LastSeen.IsZero() && time.Since(n.LastSeen) < 3*edge.Heartbeat
+}
+
+// Server is the central server's listener for edge nodes
+type Server struct {
+	ID        uuid.UUID // Unique identifier for the Server object
+	Interface string    // The network adapter interface the server will listen on
+	Port      int       // The port the server will listen on
+	Protocol  string    // Always edge
+	handler   http.Handler
+	keys      *http2.Handler // The agent message handler whose pre-shared keys are rotated
+	server    *http.Server
+}
+
+// New returns a listener that accepts agent requests relayed by enrolled edge nodes on the interface and port
+func New(iface string, port int, psk string) (*Server, error) {
+	if port < 1 || port > 65535 {
+		return nil, fmt.Errorf("%d is not a valid port", port)
+	}
+	id := uuid.NewV4()
+	handler, err := http2.NewHandler(id, psk)
+	if err != nil {
+		return nil, err
+	}
+	caCert, caKey, err := authority()
+	if err != nil {
+		return nil, err
+	}
+	cer, err := sign(caCert, caKey, "Merlin edge listener", x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	s := &Server{
+		ID:        id,
+		Interface: iface,
+		Port:      port,
+		Protocol:  "edge",
+		handler:   handler,
+		keys:      handler,
+	}
+	s.server = &http.Server{
+		Addr:    util.JoinHostPort(iface, strconv.Itoa(port)),
+		Handler: s,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{*cer},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    roots,
+			MinVersion:   tls.VersionTLS12,
+			VerifyPeerCertificate: func(_ [][]byte, chains [][]*x509.Certificate) error {
+				if len(chains) == 0 || len(chains[0]) == 0 {
+					return fmt.Errorf("the edge node did not send a certificate")
+				}
+				_, err := authorize(chains[0][0])
+				return err
+			},
+		},
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	return s, nil
+}
+
+// Run starts the listener and returns once the port is bound
+func (s *Server) Run() error {
+	logging.Server(fmt.Sprintf("Starting edge Listener at %s", s.server.Addr))
+	l, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return fmt.Errorf("there was an error starting the edge listener:\r\n%s", err.Error())
+	}
+	message("note", fmt.Sprintf("Starting edge listener on %s", s.server.Addr))
+	if err := events.Publish(events.Listener, "", fmt.Sprintf("Started edge listener %s at %s", s.ID, s.server.Addr)); err != nil {
+		message("warn", err.Error())
+	}
+	go func() {
+		err := s.server.ServeTLS(l, "", "")
+		logging.Server(fmt.Sprintf("The edge listener at %s stopped:\r\n%s", s.server.Addr, err.Error()))
+		if err := events.Publish(events.Listener, "", fmt.Sprintf("Stopped edge listener %s at %s: %s", s.ID, s.server.Addr, err.Error())); err != nil {
+			message("warn", err.Error())
+		}
+	}()
+	return nil
+}
+
+// ServeHTTP answers the heartbeats and relayed agent requests of enrolled edge nodes. The node is checked again on
+// every request so revoking it also stops the connections it already has open.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	node, err := authorize(r.TLS.PeerCertificates[0])
+	if err != nil {
+		logging.Server(fmt.Sprintf("Rejected an edge node request from %s: %s", r.RemoteAddr, err.Error()))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	nodes.Lock()
+	if n, ok := nodes.m[node]; ok {
+		n.Address = r.RemoteAddr
+		n.LastSeen = time.Now().UTC()
+		if r.URL.Path != edge.HeartbeatPath {
+			n.Requests++
+		}
+	}
+	nodes.Unlock()
+	if r.URL.Path == edge.HeartbeatPath {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	// The node appends the agent's address to the X-Forwarded-For header; only enrolled nodes reach this point so
+	// the header is trusted
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		addrs := strings.Split(xff, ",")
+		r.RemoteAddr = strings.TrimSpace(addrs[len(addrs)-1]) + " via edge node " + node
+	}
+	s.handler.ServeHTTP(w, r)
+}
+
+// Enroll creates the credentials for a new edge node and returns the directory they were written to. The directory's
+// files are copied to the node and passed to merlinedge with its -creds flag.
+func Enroll(name string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("%s is not a valid edge node name; use letters, numbers, dots, dashes, and underscores", name)
+	}
+	nodes.Lock()
+	defer nodes.Unlock()
+	if err := load(); err != nil {
+		return "", err
+	}
+	if n, ok := nodes.m[name]; ok && !n.Revoked {
+		return "", fmt.Errorf("edge node %s is already enrolled; revoke it before enrolling it again", name)
+	}
+	caCert, caKey, err := authority()
+	if err != nil {
+		return "", err
+	}
+	cer, err := sign(caCert, caKey, name, x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(Dir, name)
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("there was an error creating the edge node directory %s:\r\n%s", dir, err.Error())
+	}
+	key, err := x509.MarshalECPrivateKey(cer.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		return "", fmt.Errorf("there was an error encoding the edge node's private key:\r\n%s", err.Error())
+	}
+	files := map[string][]byte{
+		edge.CertificateFile: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cer.Certificate[0]}),
+		edge.KeyFile:         pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}),
+		edge.CAFile:          pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}),
+	}
+	for f, data := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, f), data, 0600); err != nil {
+			return "", fmt.Errorf("there was an error writing the edge node file %s:\r\n%s", f, err.Error())
+		}
+	}
+	nodes.m[name] = &Node{Name: name, Fingerprint: fingerprint(cer.Certificate[0]), Enrolled: time.Now().UTC()}
+	if err = save(); err != nil {
+		return "", err
+	}
+	logging.Server(fmt.Sprintf("Enrolled edge node %s with credentials in %s", name, dir))
+	return dir, nil
+}
+
+// Revoke stops accepting requests from the edge node, including those on connections it already has open
+func Revoke(name string) error {
+	nodes.Lock()
+	defer nodes.Unlock()
+	if err := load(); err != nil {
+		return err
+	}
+	n, ok := nodes.m[name]
+	if !ok {
+		return fmt.Errorf("%s is not an enrolled edge node", name)
+	}
+	if n.Revoked {
+		return fmt.Errorf("edge node %s is already revoked", name)
+	}
+	n.Revoked = true
+	if err := save(); err != nil {
+		return err
+	}
+	logging.Server(fmt.Sprintf("Revoked edge node %s", name))
+	return nil
+}
+
+// Nodes returns the enrolled edge nodes sorted by name
+func Nodes() ([]Node, error) {
+	nodes.Lock()
+	defer nodes.Unlock()
+	if err := load(); err != nil {
+		return nil, err
+	}
+	var list []Node
+	for _, n := range nodes.m {
+		list = append(list, *n)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// authorize returns the name of the enrolled edge node the certificate belongs to or an error if the node is unknown
+// or revoked. The certificate chain was already verified against the edge certificate authority.
+func authorize(cert *x509.Certificate) (string, error) {
+	nodes.Lock()
+	defer nodes.Unlock()
+	if err := load(); err != nil {
+		return "", err
+	}
+	f := fingerprint(cert.Raw)
+	for _, n := range nodes.m {
+		if n.Fingerprint != f {
+			continue
+		}
+		if n.Revoked {
+			return "", fmt.Errorf("edge node %s is revoked", n.Name)
+		}
+		return n.Name, nil
+	}
+	return "", fmt.Errorf("the certificate for %s does not belong to an enrolled edge node", cert.Subject.CommonName)
+}
+
+// load reads the node registry from Dir the first time it is used or when Dir changed; the caller holds the lock
+func load() error {
+	if nodes.loaded && nodes.dir == Dir {
+		return nil
+	}
+	m := make(map[string]*Node)
+	data, err := ioutil.ReadFile(filepath.Join(Dir, "nodes.json")) // #nosec G304 - The file is in Merlin's data directory
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("there was an error reading the edge node registry:\r\n%s", err.Error())
+	}
+	if err == nil {
+		var list []*Node
+		if err = json.Unmarshal(data, &list); err != nil {
+			return fmt.Errorf("there was an error parsing the edge node registry:\r\n%s", err.Error())
+		}
+		for _, n := range list {
+			m[n.Name] = n
+		}
+	}
+	nodes.m = m
+	nodes.dir = Dir
+	nodes.loaded = true
+	return nil
+}
+
+// save writes the node registry to Dir; the caller holds the lock
+func save() error {
+	var list []*Node
+	for _, n := range nodes.m {
+		list = append(list, n)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("there was an error encoding the edge node registry:\r\n%s", err.Error())
+	}
+	if err = os.MkdirAll(Dir, 0700); err != nil {
+		return fmt.Errorf("there was an error creating the edge directory %s:\r\n%s", Dir, err.Error())
+	}
+	if err = ioutil.WriteFile(filepath.Join(Dir, "nodes.json"), data, 0600); err != nil {
+		return fmt.Errorf("there was an error writing the edge node registry:\r\n%s", err.Error())
+	}
+	return nil
+}
+
+// authority reads the edge certificate authority from Dir and creates it the first time it is used
+func authority() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certFile := filepath.Join(Dir, "ca.crt")
+	keyFile := filepath.Join(Dir, "ca.key")
+	if _, err := os.Stat(certFile); err == nil {
+		cer, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("there was an error loading the edge certificate authority:\r\n%s", err.Error())
+		}
+		caCert, err := x509.ParseCertificate(cer.Certificate[0])
+		if err != nil {
+			return nil, nil, fmt.Errorf("there was an error parsing the edge certificate authority:\r\n%s", err.Error())
+		}
+		caKey, ok := cer.PrivateKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, nil, fmt.Errorf("the edge certificate authority's key is not an ECDSA key")
+		}
+		return caCert, caKey, nil
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("there was an error generating the edge certificate authority's key:\r\n%s", err.Error())
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Merlin edge certificate authority"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("there was an error creating the edge certificate authority:\r\n%s", err.Error())
+	}
+	key, err := x509.MarshalECPrivateKey(caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("there was an error encoding the edge certificate authority's key:\r\n%s", err.Error())
+	}
+	if err = os.MkdirAll(Dir, 0700); err != nil {
+		return nil, nil, fmt.Errorf("there was an error creating the edge directory %s:\r\n%s", Dir, err.Error())
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		return nil, nil, fmt.Errorf("there was an error writing the edge certificate authority's key:\r\n%s", err.Error())
+	}
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		return nil, nil, fmt.Errorf("there was an error writing the edge certificate authority:\r\n%s", err.Error())
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	logging.Server(fmt.Sprintf("Created the edge certificate authority %s", certFile))
+	return caCert, caKey, nil
+}
+
+// sign returns a new certificate and ECDSA key signed by the edge certificate authority
+func sign(caCert *x509.Certificate, caKey *ecdsa.PrivateKey, name string, usage x509.ExtKeyUsage) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error generating the key for %s:\r\n%s", name, err.Error())
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(2, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error signing the certificate for %s:\r\n%s", name, err.Error())
+	}
+	return &tls.Certificate{Certificate: [][]byte{der, caCert.Raw}, PrivateKey: key}, nil
+}
+
+// fingerprint returns the hex encoded SHA256 hash of a DER encoded certificate
+func fingerprint(der []byte) string {
+	hash := sha256.Sum256(der)
+	return hex.EncodeToString(hash[:])
+}
+
+// message is used to print a message to the command line
+func message(level string, message string) {
+	switch level {
+	case "info":
+		color.Cyan("[i]" + message)
+	case "note":
+		color.Yellow("[-]" + message)
+	case "warn":
+		color.Red("[!]" + message)
+	case "debug":
+		color.Red("[DEBUG]" + message)
+	case "success":
+		color.Green("[+]" + message)
+	default:
+		color.Red("[_-_]Invalid message level: " + message)
+	}
+}
+
+// PSKs returns the pre-shared keys the listener accepts, starting with its own
+func (s *Server) PSKs() []string {
+	return s.keys.PSKs()
+}
+
+// SetPSKs replaces the pre-shared keys the listener accepts. The first key becomes the listener's own.
+func (s *Server) SetPSKs(psks []string) error {
+	return s.keys.SetPSKs(psks)
+}
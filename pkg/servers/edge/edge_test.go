@@ -0,0 +1,124 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package edge
+
+import (
+	// Standard
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/edge"
+)
+
+// freePort returns a local TCP port that is not in use
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// TestRelay verifies an enrolled edge node relays agent requests to the central listener over mutual TLS with the
+// agent's address and stops being accepted once it is revoked
+func TestRelay(t *testing.T) {
+	Dir = t.TempDir()
+	creds, err := Enroll("lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Enroll("lab"); err == nil {
+		t.Error("an enrolled node was enrolled again")
+	}
+	if _, err = Enroll("../lab"); err == nil {
+		t.Error("a node name with a path separator was enrolled")
+	}
+
+	central, err := New("127.0.0.1", freePort(t), "merlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := make(chan string, 10)
+	central.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote <- r.RemoteAddr
+		fmt.Fprint(w, "agent")
+	})
+	if err = central.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer central.server.Close()
+
+	port := freePort(t)
+	node, err := edge.New(fmt.Sprintf("https://127.0.0.1:%d", central.Port), creds, "127.0.0.1", port, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go node.Run()
+	defer node.Close()
+
+	agent := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}} // #nosec G402
+	url := fmt.Sprintf("https://127.0.0.1:%d/", port)
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = agent.Post(url, "text/plain", strings.NewReader("message")); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "agent" {
+		t.Fatalf("the relayed request returned %s %q", resp.Status, body)
+	}
+	if addr := <-remote; !strings.HasPrefix(addr, "127.0.0.1 via edge node lab") {
+		t.Errorf("the agent's address was %s", addr)
+	}
+	if err = node.Ping(); err != nil {
+		t.Error(err)
+	}
+	list, err := Nodes()
+	if err != nil || len(list) != 1 || list[0].Requests != 1 || list[0].LastSeen.IsZero() {
+		t.Errorf("the node registry was %+v: %v", list, err)
+	}
+
+	if err = Revoke("lab"); err != nil {
+		t.Fatal(err)
+	}
+	if err = node.Ping(); err == nil {
+		t.Error("the central listener accepted a revoked node")
+	}
+	resp, err = agent.Post(url, "text/plain", strings.NewReader("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Error("a revoked node relayed an agent request")
+	}
+}
@@ -0,0 +1,97 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package http2
+
+import (
+	// Standard
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/acme"
+	"github.com/Ne0nd0g/merlin/pkg/events"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+)
+
+// acmeCheck is how often a listener checks whether its ACME certificate needs to be renewed
+var acmeCheck = 12 * time.Hour
+
+// acmeRetry is how long a listener waits to order its ACME certificate again after the order failed
+var acmeRetry = time.Hour
+
+// UseACME obtains the listener's certificate from an ACME certificate authority and renews it before it expires. The
+// listener answers the tls-alpn-01 challenge itself so it must be reachable on port 443 of the domain. A certificate
+// obtained earlier is used right away; until the first one is issued the listener keeps its current certificate.
+func (s *Server) UseACME(m *acme.Manager) error {
+	if s.Protocol != "h2" {
+		return fmt.Errorf("the %s protocol does not support ACME certificates", s.Protocol)
+	}
+	server := s.Server.(*http.Server)
+	server.TLSConfig.NextProtos = append(server.TLSConfig.NextProtos, acme.ALPNProto)
+	s.creds.Lock()
+	s.creds.acme = m
+	s.creds.Unlock()
+	if cert, err := m.Cached(); err == nil {
+		s.setACMECertificate(*cert)
+		logging.Server(fmt.Sprintf("Listener %s is using the ACME certificate for %s that expires %s", s.ID, m.Domain,
+			cert.Leaf.NotAfter.Format(time.RFC3339)))
+	}
+	go s.renewACME(m)
+	return nil
+}
+
+// renewACME orders the listener's certificate when it has none from the certificate authority or it is about to
+// expire, then checks again every acmeCheck
+func (s *Server) renewACME(m *acme.Manager) {
+	for {
+		wait := acmeCheck
+		if cert, err := m.Cached(); err != nil || acme.NeedsRenewal(cert) {
+			// Give the listener time to start so it can answer the challenge
+			time.Sleep(time.Second)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			cert, err = m.Obtain(ctx)
+			cancel()
+			if err != nil {
+				warn := fmt.Sprintf("there was an error obtaining the ACME certificate for %s on listener %s:\r\n%s", m.Domain, s.ID, err.Error())
+				logging.Server(warn)
+				message("warn", warn)
+				wait = acmeRetry
+			} else {
+				s.setACMECertificate(*cert)
+				note := fmt.Sprintf("Listener %s is using a new ACME certificate for %s that expires %s", s.ID, m.Domain,
+					cert.Leaf.NotAfter.Format(time.RFC3339))
+				logging.Server(note)
+				message("success", note)
+				if err = events.Publish(events.Listener, "", note); err != nil {
+					message("warn", err.Error())
+				}
+			}
+		}
+		time.Sleep(wait)
+	}
+}
+
+// setACMECertificate replaces the certificate the listener presents with one issued by the ACME certificate authority
+func (s *Server) setACMECertificate(cert tls.Certificate) {
+	s.creds.Lock()
+	s.creds.certificate = cert
+	s.creds.Unlock()
+}
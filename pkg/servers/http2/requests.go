@@ -0,0 +1,103 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package http2
+
+import (
+	// Standard
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/servers/requests"
+)
+
+// requestKey is the request context key for the request being recorded
+type requestKey struct{}
+
+// statusWriter keeps the status code written to the client
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests records every request the listener receives and reports the anomalies found in them
+func logRequests(listener uuid.UUID, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &requests.Request{
+			Time:      time.Now().UTC(),
+			Listener:  listener,
+			Source:    r.RemoteAddr,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			UserAgent: r.UserAgent(),
+			Proto:     r.Proto,
+		}
+		req.Flags, req.Detail = requests.Check(req.UserAgent, req.Path)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), requestKey{}, req)))
+		req.Status = sw.status
+		requests.Add(*req)
+		if len(req.Flags) > 0 {
+			m := fmt.Sprintf("Flagged %s request for %s from %s as %s: %s", req.Method, req.Path, req.Source,
+				strings.Join(req.Flags, ", "), req.Detail)
+			logging.Server(m)
+			if core.Verbose {
+				message("warn", m)
+			}
+		}
+	})
+}
+
+// matchAgent records that the request being handled was a message from the agent
+func matchAgent(r *http.Request, agentID uuid.UUID) {
+	if req, ok := r.Context().Value(requestKey{}).(*requests.Request); ok {
+		req.Agent = agentID
+	}
+}
+
+// errorLog receives the listener's HTTP server errors; failed TLS handshakes are recorded as flagged requests
+type errorLog struct {
+	listener uuid.UUID
+}
+
+func (e errorLog) Write(p []byte) (int, error) {
+	if req, ok := requests.HandshakeError(e.listener, string(p)); ok {
+		requests.Add(req)
+		m := fmt.Sprintf("TLS handshake from %s failed: %s", req.Source, req.Detail)
+		logging.Server(m)
+		if core.Verbose {
+			message("warn", m)
+		}
+		return len(p), nil
+	}
+	logging.Server(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
@@ -0,0 +1,107 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package http2
+
+import (
+	// Standard
+	"crypto/tls"
+	"fmt"
+	"sync"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/acme"
+)
+
+// credentials holds the certificate and pre-shared keys of a listener behind a lock so they can be rotated while
+// the listener is serving agents. Every copy of a Server shares the same credentials.
+type credentials struct {
+	sync.RWMutex
+	certificate tls.Certificate
+	psks        []string      // The first key is the listener's own; any others are still accepted during a rotation
+	acme        *acme.Manager // Answers the ACME tls-alpn-01 challenges when the certificate is obtained with ACME
+	clientCerts bool          // Agents must present a client certificate issued by the agent certificate authority
+}
+
+// getCertificate is used as the tls.Config GetCertificate callback
+func (c *credentials) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.RLock()
+	defer c.RUnlock()
+	if c.acme != nil {
+		if cert, ok := c.acme.ChallengeCertificate(hello); ok {
+			return cert, nil
+		}
+	}
+	cert := c.certificate
+	return &cert, nil
+}
+
+// keys returns a copy of the accepted pre-shared keys
+func (c *credentials) keys() []string {
+	c.RLock()
+	defer c.RUnlock()
+	return append([]string{}, c.psks...)
+}
+
+// TLSCertificate returns the certificate the listener presents to agents
+func (s *Server) TLSCertificate() tls.Certificate {
+	s.creds.RLock()
+	defer s.creds.RUnlock()
+	return s.creds.certificate
+}
+
+// SetCertificate replaces the certificate the listener presents to new connections.
+// Only HTTP/2 listeners load their certificate per connection; HTTP/3 listeners read it from disk at start up.
+// A certificate obtained with ACME is renewed by the listener and can not be replaced.
+func (s *Server) SetCertificate(cert tls.Certificate) error {
+	if s.Protocol != "h2" {
+		return fmt.Errorf("the %s protocol does not support rotating the certificate of a running listener", s.Protocol)
+	}
+	s.creds.Lock()
+	defer s.creds.Unlock()
+	if s.creds.acme != nil {
+		return fmt.Errorf("the listener's certificate for %s is managed with ACME", s.creds.acme.Domain)
+	}
+	s.creds.certificate = cert
+	return nil
+}
+
+// PSKs returns the pre-shared keys the listener accepts, starting with its own
+func (s *Server) PSKs() []string {
+	return s.creds.keys()
+}
+
+// SetPSKs replaces the pre-shared keys the listener accepts. The first key becomes the listener's own.
+func (s *Server) SetPSKs(psks []string) error {
+	if len(psks) == 0 {
+		return fmt.Errorf("at least one pre-shared key is required")
+	}
+	for _, psk := range psks {
+		if psk == "" {
+			return fmt.Errorf("a pre-shared key can not be empty")
+		}
+	}
+	s.creds.Lock()
+	s.creds.psks = append([]string{}, psks...)
+	s.creds.Unlock()
+	return nil
+}
+
+// AnyURI returns true when the listener accepts agent messages on any URI; a profile limits them to its URIs
+func (s *Server) AnyURI() bool {
+	return s.Profile == nil
+}
@@ -0,0 +1,147 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package requests keeps the recent HTTP requests and TLS handshake failures received by each listener and flags the
+// anomalies, such as scanners and clients that reject the listener's certificate, for the operator to review
+package requests
+
+import (
+	// Standard
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// Anomaly flags
+const (
+	Scanner     = "scanner"     // The user agent or path matches a known scanner
+	Fingerprint = "fingerprint" // The request matches a tool that fingerprints Merlin servers (i.e. PRISM)
+	TLS         = "tls"         // The TLS handshake failed, such as a client whose pinned certificate did not match
+)
+
+// maxRequests is the number of recent requests kept for each listener
+const maxRequests = 1000
+
+// prismUserAgent is the user agent sent by PRISM when it fingerprints a Merlin server
+const prismUserAgent = "Mozilla/5.0 (Windows NT 6.1; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/40.0.2214.85 Safari/537.36 "
+
+// scannerAgents are lower case substrings of the user agents sent by common scanners
+var scannerAgents = []string{"nmap", "nikto", "sqlmap", "masscan", "zgrab", "gobuster", "dirbuster", "dirb/", "wpscan",
+	"nuclei", "censysinspect", "shodan", "expanse", "l9explore", "wfuzz", "ffuf", "feroxbuster", "acunetix", "nessus",
+	"openvas", "qualys", "burp"}
+
+// scannerPaths are lower case path prefixes commonly requested by scanners and never by agents
+var scannerPaths = []string{"/.git", "/.env", "/wp-login.php", "/wp-admin", "/phpmyadmin", "/cgi-bin/", "/actuator",
+	"/server-status", "/.well-known/security.txt", "/admin", "/manager/html", "/solr/", "/hnap1", "/boaform"}
+
+// Request is an HTTP request, or a failed TLS handshake, received by a listener
+type Request struct {
+	Time      time.Time
+	Listener  uuid.UUID
+	Source    string // The client's address
+	Method    string // Empty for a failed TLS handshake
+	Path      string
+	UserAgent string
+	Proto     string
+	Status    int       // The HTTP status code returned to the client
+	Agent     uuid.UUID // The agent whose JWT was valid; uuid.Nil if the request did not match an agent
+	Flags     []string  // The anomalies found in the request
+	Detail    string    // Why the request was flagged, such as the TLS handshake error
+}
+
+// log is the recent requests of each listener
+var log = struct {
+	sync.Mutex
+	listeners map[uuid.UUID][]Request
+}{listeners: make(map[uuid.UUID][]Request)}
+
+// Check returns the anomaly flags for the request's user agent and path and the reason they were flagged
+func Check(userAgent string, path string) ([]string, string) {
+	var flags []string
+	var details []string
+	if userAgent == prismUserAgent {
+		flags = append(flags, Fingerprint)
+		details = append(details, "PRISM user agent")
+	}
+	ua := strings.ToLower(userAgent)
+	for _, s := range scannerAgents {
+		if strings.Contains(ua, s) {
+			flags = append(flags, Scanner)
+			details = append(details, "scanner user agent "+s)
+			break
+		}
+	}
+	p := strings.ToLower(path)
+	for _, s := range scannerPaths {
+		if strings.HasPrefix(p, s) {
+			if len(flags) == 0 || flags[len(flags)-1] != Scanner {
+				flags = append(flags, Scanner)
+			}
+			details = append(details, "scanner path "+s)
+			break
+		}
+	}
+	return flags, strings.Join(details, ", ")
+}
+
+// Add records a request for its listener, dropping the listener's oldest request once it has too many
+func Add(r Request) {
+	log.Lock()
+	defer log.Unlock()
+	list := append(log.listeners[r.Listener], r)
+	if len(list) > maxRequests {
+		list = list[len(list)-maxRequests:]
+	}
+	log.listeners[r.Listener] = list
+}
+
+// Query returns the listener's recent requests, oldest first, or only those with an anomaly flag
+func Query(listener uuid.UUID, anomalies bool) []Request {
+	log.Lock()
+	defer log.Unlock()
+	list := make([]Request, 0)
+	for _, r := range log.listeners[listener] {
+		if !anomalies || len(r.Flags) > 0 {
+			list = append(list, r)
+		}
+	}
+	return list
+}
+
+// HandshakeError returns the failed TLS handshake from a line written by an HTTP server's error log (i.e. "http: TLS
+// handshake error from 10.0.0.5:51234: remote error: tls: bad certificate") and if the line was one
+func HandshakeError(listener uuid.UUID, line string) (Request, bool) {
+	const prefix = "http: TLS handshake error from "
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, prefix) {
+		return Request{}, false
+	}
+	line = strings.TrimPrefix(line, prefix)
+	// The client's address may be an IPv6 address, so the error follows the first ": "
+	i := strings.Index(line, ": ")
+	if i < 0 {
+		return Request{}, false
+	}
+	r := Request{Time: time.Now().UTC(), Listener: listener, Source: line[:i], Flags: []string{TLS}, Detail: line[i+2:]}
+	if strings.Contains(r.Detail, "bad certificate") || strings.Contains(r.Detail, "unknown certificate") {
+		r.Detail = "the client rejected the certificate, such as a pinned certificate that did not match: " + r.Detail
+	}
+	return r, true
+}
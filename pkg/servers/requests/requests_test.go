@@ -0,0 +1,69 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package requests
+
+import (
+	// Standard
+	"strings"
+	"testing"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// TestQuery verifies requests are flagged, handshake failures are parsed, and each listener keeps its recent requests
+func TestQuery(t *testing.T) {
+	cases := []struct {
+		userAgent string
+		path      string
+		flags     string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64)", "/", ""},
+		{"Mozilla/5.0 zgrab/0.x", "/", Scanner},
+		{"curl/7.64.0", "/.git/config", Scanner},
+		{"Nikto/2.1.6", "/wp-login.php", Scanner},
+		{prismUserAgent, "/", Fingerprint},
+	}
+	for _, c := range cases {
+		flags, _ := Check(c.userAgent, c.path)
+		if strings.Join(flags, ",") != c.flags {
+			t.Errorf("%s %s was flagged %v instead of %s", c.userAgent, c.path, flags, c.flags)
+		}
+	}
+
+	listener := uuid.NewV4()
+	r, ok := HandshakeError(listener, "http: TLS handshake error from [::1]:51234: remote error: tls: bad certificate\n")
+	if !ok || r.Source != "[::1]:51234" || r.Flags[0] != TLS || !strings.Contains(r.Detail, "pinned certificate") {
+		t.Errorf("the handshake error was parsed as %+v", r)
+	}
+	if _, ok = HandshakeError(listener, "http: panic serving 10.0.0.5:1234"); ok {
+		t.Error("a line that is not a handshake error was parsed")
+	}
+
+	Add(r)
+	for i := 0; i < maxRequests; i++ {
+		Add(Request{Listener: listener, Method: "POST", Path: "/"})
+	}
+	Add(Request{Listener: uuid.NewV4(), Method: "GET", Path: "/"})
+	if n := len(Query(listener, false)); n != maxRequests {
+		t.Errorf("the listener kept %d requests instead of %d", n, maxRequests)
+	}
+	if n := len(Query(listener, true)); n != 0 {
+		t.Errorf("%d anomalies were kept after they were the oldest requests", n)
+	}
+}
@@ -0,0 +1,219 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package tcp is an agent listener that exchanges length prefixed messages over a raw TCP connection, optionally
+// wrapped in TLS, for networks where HTTP traffic stands out. In reverse mode agents connect to the listener; in bind
+// mode the agent listens and the server connects to it.
+package tcp
+
+import (
+	// Standard
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/fatih/color"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/events"
+	"github.com/Ne0nd0g/merlin/pkg/framing"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/servers/http2"
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// reconnect is how long a bind listener waits before connecting to the agent again
+const reconnect = 10 * time.Second
+
+// Server is a raw TCP listener for agents
+type Server struct {
+	ID        uuid.UUID // Unique identifier for the Server object
+	Interface string    // The network adapter interface the server will listen on in reverse mode
+	Port      int       // The port the server will listen on in reverse mode
+	Protocol  string    // Always tcp
+	Mode      string    // reverse when agents connect to the server or bind when the server connects to the agent
+	Address   string    // The agent's host:port the server connects to in bind mode
+	TLS       bool      // Wrap the connection in TLS
+	handler   *http2.Handler
+	tlsConfig *tls.Config
+}
+
+// New returns a TCP listener. In reverse mode it listens on the interface and port; in bind mode it connects to the
+// agent listening at address.
+func New(iface string, port int, mode string, address string, useTLS bool, psk string) (*Server, error) {
+	mode = strings.ToLower(mode)
+	switch mode {
+	case "reverse":
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("%d is not a valid port", port)
+		}
+	case "bind":
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, fmt.Errorf("%s is not a valid agent host:port address for a bind listener", address)
+		}
+	default:
+		return nil, fmt.Errorf("%s is not a valid TCP listener mode; use reverse or bind", mode)
+	}
+	id := uuid.NewV4()
+	handler, err := http2.NewHandler(id, psk)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		ID:        id,
+		Interface: iface,
+		Port:      port,
+		Protocol:  "tcp",
+		Mode:      mode,
+		Address:   address,
+		TLS:       useTLS,
+		handler:   handler,
+	}
+	if useTLS {
+		// The agent does not verify the certificate, the same as the HTTP listeners, so an ephemeral one is used
+		cer, err := util.GenerateTLSCert(nil, nil, nil, nil, nil, nil, true)
+		if err != nil {
+			return nil, fmt.Errorf("there was an error generating the TLS certificate:\r\n%s", err.Error())
+		}
+		s.tlsConfig = &tls.Config{
+			Certificates:       []tls.Certificate{*cer},
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: true, // #nosec G402 - bind mode agents use an ephemeral certificate
+		}
+	}
+	return s, nil
+}
+
+// Run starts the listener; in reverse mode it returns once the port is bound
+func (s *Server) Run() error {
+	if s.Mode == "bind" {
+		logging.Server(fmt.Sprintf("Starting tcp bind Listener for the agent at %s", s.Address))
+		message("note", fmt.Sprintf("Starting tcp bind listener for the agent at %s", s.Address))
+		if err := events.Publish(events.Listener, "", fmt.Sprintf("Started tcp bind listener %s for the agent at %s", s.ID, s.Address)); err != nil {
+			message("warn", err.Error())
+		}
+		go s.bind()
+		return nil
+	}
+
+	addr := util.JoinHostPort(s.Interface, strconv.Itoa(s.Port))
+	logging.Server(fmt.Sprintf("Starting tcp Listener at %s", addr))
+	var l net.Listener
+	var err error
+	if s.TLS {
+		l, err = tls.Listen("tcp", addr, s.tlsConfig)
+	} else {
+		l, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("there was an error starting the TCP listener:\r\n%s", err.Error())
+	}
+	message("note", fmt.Sprintf("Starting tcp listener on %s", addr))
+	if err := events.Publish(events.Listener, "", fmt.Sprintf("Started tcp listener %s at %s", s.ID, addr)); err != nil {
+		message("warn", err.Error())
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				logging.Server(fmt.Sprintf("The TCP listener at %s stopped:\r\n%s", addr, err.Error()))
+				if err := events.Publish(events.Listener, "", fmt.Sprintf("Stopped tcp listener %s at %s: %s", s.ID, addr, err.Error())); err != nil {
+					message("warn", err.Error())
+				}
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return nil
+}
+
+// bind connects to the agent's listener and connects again whenever the connection closes
+func (s *Server) bind() {
+	d := &net.Dialer{Timeout: 30 * time.Second}
+	for {
+		var conn net.Conn
+		var err error
+		if s.TLS {
+			conn, err = tls.DialWithDialer(d, "tcp", s.Address, s.tlsConfig)
+		} else {
+			conn, err = d.Dial("tcp", s.Address)
+		}
+		if err != nil {
+			logging.Server(fmt.Sprintf("There was an error connecting to the bind agent at %s:\r\n%s", s.Address, err.Error()))
+			time.Sleep(reconnect)
+			continue
+		}
+		logging.Server(fmt.Sprintf("Connected to the bind agent at %s", s.Address))
+		s.serve(conn)
+		time.Sleep(reconnect)
+	}
+}
+
+// serve answers the agent's request frames on the connection until it is closed
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close() // #nosec G307
+	remoteAddr := conn.RemoteAddr().String()
+	for {
+		data, err := framing.Read(conn)
+		if err != nil {
+			return
+		}
+		token, body, err := framing.UnpackRequest(data)
+		if err != nil {
+			message("warn", fmt.Sprintf("there was an error unpacking a TCP message from %s:\r\n%s", remoteAddr, err.Error()))
+			return
+		}
+		status, response := http2.Serve(s.handler, "TCP", remoteAddr, token, body)
+		if err = framing.Write(conn, framing.PackResponse(status, response)); err != nil {
+			return
+		}
+	}
+}
+
+// message is used to print a message to the command line
+func message(level string, message string) {
+	switch level {
+	case "info":
+		color.Cyan("[i]" + message)
+	case "note":
+		color.Yellow("[-]" + message)
+	case "warn":
+		color.Red("[!]" + message)
+	case "debug":
+		color.Red("[DEBUG]" + message)
+	case "success":
+		color.Green("[+]" + message)
+	default:
+		color.Red("[_-_]Invalid message level: " + message)
+	}
+}
+
+// PSKs returns the pre-shared keys the listener accepts, starting with its own
+func (s *Server) PSKs() []string {
+	return s.handler.PSKs()
+}
+
+// SetPSKs replaces the pre-shared keys the listener accepts. The first key becomes the listener's own.
+func (s *Server) SetPSKs(psks []string) error {
+	return s.handler.SetPSKs(psks)
+}
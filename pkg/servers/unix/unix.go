@@ -0,0 +1,199 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package unix is an agent listener on a Unix domain socket so co-located tools, such as redirectors and test
+// harnesses, can deliver agent traffic without opening a TCP port. The socket serves the agents' HTTP requests, for a
+// redirector that proxies HTTP or HTTPS agents, or the length prefixed messages of the tcp listener, for a relay of
+// tcp agents.
+package unix
+
+import (
+	// Standard
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/fatih/color"
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/events"
+	"github.com/Ne0nd0g/merlin/pkg/framing"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/servers/http2"
+)
+
+// Server is a Unix domain socket listener for agents
+type Server struct {
+	ID          uuid.UUID   // Unique identifier for the Server object
+	Path        string      // The socket file the server listens on
+	Protocol    string      // Always unix
+	Transport   string      // http to serve HTTP requests or tcp to exchange the tcp listener's length prefixed messages
+	Permissions os.FileMode // The file mode of the socket, which controls the local users that can connect to it
+	handler     *http2.Handler
+}
+
+// New returns a Unix domain socket listener for the socket file path
+func New(path string, transport string, permissions os.FileMode, psk string) (*Server, error) {
+	if path == "" {
+		return nil, fmt.Errorf("a socket file path is required")
+	}
+	transport = strings.ToLower(transport)
+	if transport != "http" && transport != "tcp" {
+		return nil, fmt.Errorf("%s is not a valid Unix socket listener transport; use http or tcp", transport)
+	}
+	id := uuid.NewV4()
+	handler, err := http2.NewHandler(id, psk)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		ID:          id,
+		Path:        path,
+		Protocol:    "unix",
+		Transport:   transport,
+		Permissions: permissions,
+		handler:     handler,
+	}, nil
+}
+
+// Run starts the listener and returns once the socket is bound. A socket file left behind by a previous server is
+// removed first; a socket another listener is accepting connections on, or any other file at the path, is an error.
+func (s *Server) Run() error {
+	logging.Server(fmt.Sprintf("Starting unix Listener at %s", s.Path))
+	if fi, err := os.Lstat(s.Path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and is not a socket file", s.Path)
+		}
+		if conn, err := net.DialTimeout("unix", s.Path, time.Second); err == nil {
+			_ = conn.Close()
+			return fmt.Errorf("the socket file %s is in use by another listener", s.Path)
+		}
+		if err = os.Remove(s.Path); err != nil {
+			return fmt.Errorf("there was an error removing the old socket file %s:\r\n%s", s.Path, err.Error())
+		}
+	}
+	l, err := net.Listen("unix", s.Path)
+	if err != nil {
+		return fmt.Errorf("there was an error starting the Unix socket listener:\r\n%s", err.Error())
+	}
+	if err = os.Chmod(s.Path, s.Permissions); err != nil {
+		_ = l.Close()
+		return fmt.Errorf("there was an error setting the permissions of the socket file %s:\r\n%s", s.Path, err.Error())
+	}
+	message("note", fmt.Sprintf("Starting unix listener on %s for %s messages", s.Path, s.Transport))
+	if err := events.Publish(events.Listener, "", fmt.Sprintf("Started unix listener %s at %s", s.ID, s.Path)); err != nil {
+		message("warn", err.Error())
+	}
+
+	stopped := func(err error) {
+		logging.Server(fmt.Sprintf("The unix listener at %s stopped:\r\n%s", s.Path, err.Error()))
+		if err := events.Publish(events.Listener, "", fmt.Sprintf("Stopped unix listener %s at %s: %s", s.ID, s.Path, err.Error())); err != nil {
+			message("warn", err.Error())
+		}
+	}
+	if s.Transport == "http" {
+		srv := &http.Server{
+			Handler:        http.HandlerFunc(s.serveHTTP),
+			ReadTimeout:    10 * time.Second,
+			MaxHeaderBytes: 1 << 20,
+		}
+		go func() {
+			stopped(srv.Serve(l))
+		}()
+		return nil
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				stopped(err)
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return nil
+}
+
+// serveHTTP passes the request to the agent message handler. Unix socket connections do not have a remote address, so
+// the agent's address is taken from the X-Forwarded-For header a redirector adds, if there is one.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	r.RemoteAddr = remoteAddr(s.Path, r.Header.Get("X-Forwarded-For"))
+	s.handler.ServeHTTP(w, r)
+}
+
+// serve answers the request frames on the connection until it is closed
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close() // #nosec G307
+	addr := remoteAddr(s.Path, "")
+	for {
+		data, err := framing.Read(conn)
+		if err != nil {
+			return
+		}
+		token, body, err := framing.UnpackRequest(data)
+		if err != nil {
+			message("warn", fmt.Sprintf("there was an error unpacking a message from %s:\r\n%s", addr, err.Error()))
+			return
+		}
+		status, response := http2.Serve(s.handler, "UNIX", addr, token, body)
+		if err = framing.Write(conn, framing.PackResponse(status, response)); err != nil {
+			return
+		}
+	}
+}
+
+// remoteAddr returns the first address in the X-Forwarded-For header or, without one, the socket file path
+func remoteAddr(path string, forwarded string) string {
+	if f := strings.TrimSpace(strings.Split(forwarded, ",")[0]); f != "" {
+		return f
+	}
+	return "unix:" + path
+}
+
+// message is used to print a message to the command line
+func message(level string, message string) {
+	switch level {
+	case "info":
+		color.Cyan("[i]" + message)
+	case "note":
+		color.Yellow("[-]" + message)
+	case "warn":
+		color.Red("[!]" + message)
+	case "debug":
+		color.Red("[DEBUG]" + message)
+	case "success":
+		color.Green("[+]" + message)
+	default:
+		color.Red("[_-_]Invalid message level: " + message)
+	}
+}
+
+// PSKs returns the pre-shared keys the listener accepts, starting with its own
+func (s *Server) PSKs() []string {
+	return s.handler.PSKs()
+}
+
+// SetPSKs replaces the pre-shared keys the listener accepts. The first key becomes the listener's own.
+func (s *Server) SetPSKs(psks []string) error {
+	return s.handler.SetPSKs(psks)
+}
@@ -0,0 +1,218 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package ws is an agent listener that upgrades HTTP requests to long-lived WebSocket (ws or wss) connections and
+// exchanges the agent's messages over them without a new request, or TLS handshake, for every check in
+package ws
+
+import (
+	// Standard
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party
+	"github.com/fatih/color"
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/websocket"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/events"
+	"github.com/Ne0nd0g/merlin/pkg/framing"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/servers/http2"
+	"github.com/Ne0nd0g/merlin/pkg/stager"
+	"github.com/Ne0nd0g/merlin/pkg/util"
+)
+
+// Server is a WebSocket listener for agents
+type Server struct {
+	ID          uuid.UUID // Unique identifier for the Server object
+	Interface   string    // The network adapter interface the server will listen on
+	Port        int       // The port the server will listen on
+	Protocol    string    // ws or wss when the connection uses TLS
+	Path        string    // The URL path that is upgraded to a WebSocket connection
+	Subprotocol string    // The WebSocket subprotocol agents must request; empty accepts any
+	Origin      string    // The Origin header agents must send; empty accepts any
+	Certificate string    // The x.509 public key file used for wss; an ephemeral certificate is used when empty
+	Key         string    // The x.509 private key file used for wss
+	handler     *http2.Handler
+}
+
+// New returns a WebSocket listener; protocol is ws for plain text or wss for TLS
+func New(iface string, port int, protocol string, path string, subprotocol string, origin string, certificate string,
+	key string, psk string) (*Server, error) {
+	if protocol != "ws" && protocol != "wss" {
+		return nil, fmt.Errorf("%s is not a valid WebSocket protocol; use ws or wss", protocol)
+	}
+	if port < 1 || port > 65535 {
+		return nil, fmt.Errorf("%d is not a valid port", port)
+	}
+	if path == "" || path[0] != '/' {
+		return nil, fmt.Errorf("%s is not a valid URL path", path)
+	}
+	id := uuid.NewV4()
+	handler, err := http2.NewHandler(id, psk)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		ID:          id,
+		Interface:   iface,
+		Port:        port,
+		Protocol:    protocol,
+		Path:        path,
+		Subprotocol: subprotocol,
+		Origin:      origin,
+		Certificate: certificate,
+		Key:         key,
+		handler:     handler,
+	}, nil
+}
+
+// Run starts the listener and returns once the port is bound
+func (s *Server) Run() error {
+	addr := util.JoinHostPort(s.Interface, strconv.Itoa(s.Port))
+	logging.Server(fmt.Sprintf("Starting %s Listener at %s%s", s.Protocol, addr, s.Path))
+
+	mux := http.NewServeMux()
+	mux.Handle(s.Path, websocket.Server{Handshake: s.handshake, Handler: s.serve})
+	srv := &http.Server{
+		// Plain GET requests are checked against the stagers and agents hosted on this listener first
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Upgrade") == "" && stager.Serve(s.ID, w, r) {
+				return
+			}
+			mux.ServeHTTP(w, r)
+		}),
+		ReadTimeout:    10 * time.Second,
+		MaxHeaderBytes: 1 << 20,
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("there was an error starting the WebSocket listener:\r\n%s", err.Error())
+	}
+	if s.Protocol == "wss" {
+		var cer tls.Certificate
+		if s.Certificate == "" {
+			c, err := util.GenerateTLSCert(nil, nil, nil, nil, nil, nil, true)
+			if err != nil {
+				_ = l.Close()
+				return fmt.Errorf("there was an error generating the TLS certificate:\r\n%s", err.Error())
+			}
+			cer = *c
+		} else if cer, err = tls.LoadX509KeyPair(s.Certificate, s.Key); err != nil {
+			_ = l.Close()
+			return fmt.Errorf("there was an error importing the SSL/TLS x509 key pair:\r\n%s", err.Error())
+		}
+		// WebSocket upgrades require HTTP/1.1 so HTTP/2 is not offered
+		l = tls.NewListener(l, &tls.Config{
+			Certificates: []tls.Certificate{cer},
+			MinVersion:   tls.VersionTLS12,
+			NextProtos:   []string{"http/1.1"},
+		})
+	}
+	message("note", fmt.Sprintf("Starting %s listener on %s%s", s.Protocol, addr, s.Path))
+	if err := events.Publish(events.Listener, "", fmt.Sprintf("Started %s listener %s at %s%s", s.Protocol, s.ID, addr, s.Path)); err != nil {
+		message("warn", err.Error())
+	}
+	go func() {
+		if err := srv.Serve(l); err != nil {
+			logging.Server(fmt.Sprintf("The %s listener at %s stopped:\r\n%s", s.Protocol, addr, err.Error()))
+			if err := events.Publish(events.Listener, "", fmt.Sprintf("Stopped %s listener %s at %s: %s", s.Protocol, s.ID, addr, err.Error())); err != nil {
+				message("warn", err.Error())
+			}
+		}
+	}()
+	return nil
+}
+
+// handshake refuses upgrades without the configured origin or subprotocol
+func (s *Server) handshake(config *websocket.Config, r *http.Request) error {
+	if s.Origin != "" && r.Header.Get("Origin") != s.Origin {
+		return errors.New("the WebSocket origin is not allowed")
+	}
+	config.Protocol = nil
+	if s.Subprotocol != "" {
+		for _, p := range r.Header["Sec-Websocket-Protocol"] {
+			for _, v := range strings.Split(p, ",") {
+				if strings.TrimSpace(v) == s.Subprotocol {
+					config.Protocol = []string{s.Subprotocol}
+					return nil
+				}
+			}
+		}
+		return errors.New("the WebSocket subprotocol is not allowed")
+	}
+	return nil
+}
+
+// serve answers the agent's request messages on the WebSocket connection until it is closed
+func (s *Server) serve(conn *websocket.Conn) {
+	defer conn.Close() // #nosec G307
+	conn.MaxPayloadBytes = framing.MaxLength
+	remoteAddr := conn.Request().RemoteAddr
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(conn, &data); err != nil {
+			return
+		}
+		token, body, err := framing.UnpackRequest(data)
+		if err != nil {
+			message("warn", fmt.Sprintf("there was an error unpacking a WebSocket message from %s:\r\n%s", remoteAddr, err.Error()))
+			return
+		}
+		status, response := http2.Serve(s.handler, "WebSocket", remoteAddr, token, body)
+		if err = websocket.Message.Send(conn, framing.PackResponse(status, response)); err != nil {
+			return
+		}
+	}
+}
+
+// message is used to print a message to the command line
+func message(level string, message string) {
+	switch level {
+	case "info":
+		color.Cyan("[i]" + message)
+	case "note":
+		color.Yellow("[-]" + message)
+	case "warn":
+		color.Red("[!]" + message)
+	case "debug":
+		color.Red("[DEBUG]" + message)
+	case "success":
+		color.Green("[+]" + message)
+	default:
+		color.Red("[_-_]Invalid message level: " + message)
+	}
+}
+
+// PSKs returns the pre-shared keys the listener accepts, starting with its own
+func (s *Server) PSKs() []string {
+	return s.handler.PSKs()
+}
+
+// SetPSKs replaces the pre-shared keys the listener accepts. The first key becomes the listener's own.
+func (s *Server) SetPSKs(psks []string) error {
+	return s.handler.SetPSKs(psks)
+}
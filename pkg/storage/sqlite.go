@@ -0,0 +1,419 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	// Standard
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	// 3rd Party
+	_ "github.com/mattn/go-sqlite3" // #nosec G108 - Registers the sqlite3 database/sql driver
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/config"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/migrate"
+)
+
+// migrations are the changes to the SQLite database's schema in order. Each one sets the database's user_version to
+// its version so the store can verify the database matches this release before using it.
+var migrations = []migrate.Migration{
+	schema(1, "Create the SQLite agent storage database",
+		`CREATE TABLE agents (
+			id TEXT PRIMARY KEY,
+			data TEXT NOT NULL,
+			secret BLOB,
+			rsakey BLOB,
+			opaquerecord BLOB
+		)`,
+		`CREATE TABLE jobs (
+			agent TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			data TEXT NOT NULL,
+			PRIMARY KEY (agent, position)
+		)`,
+		`CREATE TABLE results (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			agent TEXT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX results_agent ON results (agent)`,
+		`CREATE TABLE keys (
+			name TEXT PRIMARY KEY,
+			value BLOB NOT NULL
+		)`,
+	),
+	// Agents saved before the approval queue existed were already trusted, so they are not pending
+	schema(2, "Add the agents' pending approval state",
+		`ALTER TABLE agents ADD COLUMN pending INTEGER NOT NULL DEFAULT 0`,
+	),
+}
+
+func init() {
+	for _, m := range migrations {
+		migrate.Register(m)
+	}
+}
+
+// SQLite is a store that keeps agents, their queued jobs, their results, and server keys in a SQLite database in the
+// data directory's db folder. The agents' session keys, RSA keys, and OPAQUE records and the server keys are encrypted
+// with AES-GCM using a key that is kept in the data directory's x509 folder, outside of the database.
+type SQLite struct {
+	db   *sql.DB
+	aead cipher.AEAD
+}
+
+// databaseFile returns the path of the SQLite database in the data directory
+func databaseFile(dataDir string) string {
+	return filepath.Join(dataDir, "db", "merlin.db")
+}
+
+// keyFile returns the path of the key used to encrypt key material in the SQLite database
+func keyFile(dataDir string) string {
+	return filepath.Join(dataDir, "x509", "storage.key")
+}
+
+// openDatabase opens the SQLite database in the data directory, creating it if it does not exist
+func openDatabase(dataDir string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(databaseFile(dataDir)), 0750); err != nil {
+		return nil, fmt.Errorf("there was an error creating the database directory:\r\n%s", err.Error())
+	}
+	db, err := sql.Open("sqlite3", databaseFile(dataDir)+"?_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("there was an error opening the SQLite database:\r\n%s", err.Error())
+	}
+	// SQLite only allows one writer at a time so every query shares a single connection
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("there was an error opening the SQLite database %s:\r\n%s", databaseFile(dataDir), err.Error())
+	}
+	if err := os.Chmod(databaseFile(dataDir), 0600); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("there was an error setting the SQLite database's permissions:\r\n%s", err.Error())
+	}
+	return db, nil
+}
+
+// schema returns a migration that runs the SQL statements against the SQLite database in a single transaction. The
+// statements are skipped if the database's user_version shows they were already applied, such as when the database is
+// restored from a backup without the record of the data directory's migrations.
+func schema(version int, description string, statements ...string) migrate.Migration {
+	return migrate.Migration{
+		Version:     version,
+		Description: description,
+		Up: func(dataDir string) error {
+			db, err := openDatabase(dataDir)
+			if err != nil {
+				return err
+			}
+			defer db.Close() // #nosec G307
+
+			tx, err := db.Begin()
+			if err != nil {
+				return fmt.Errorf("there was an error starting the migration transaction:\r\n%s", err.Error())
+			}
+			var current int
+			if err := tx.QueryRow("PRAGMA user_version").Scan(&current); err != nil {
+				_ = tx.Rollback()
+				return fmt.Errorf("there was an error reading the database schema version:\r\n%s", err.Error())
+			}
+			if current >= version {
+				return tx.Rollback()
+			}
+			for _, s := range append(statements, fmt.Sprintf("PRAGMA user_version = %d", version)) {
+				if _, err := tx.Exec(s); err != nil {
+					_ = tx.Rollback()
+					return fmt.Errorf("there was an error updating the database schema:\r\n%s", err.Error())
+				}
+			}
+			return tx.Commit()
+		},
+	}
+}
+
+// NewSQLite applies any pending data directory migrations and returns a store that uses the SQLite database in the
+// data directory. The storage key is created if it does not exist and must be kept with the database, including when a
+// backup is restored, or the agents' key material can not be read.
+func NewSQLite(dataDir string) (*SQLite, error) {
+	done, err := migrate.Up(dataDir)
+	for _, m := range done {
+		logging.Server(fmt.Sprintf("Applied data directory migration %d %s", m.Version, m.Description))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := config.Key(keyFile(dataDir), "storage")
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error creating the storage cipher:\r\n%s", err.Error())
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error creating the storage cipher:\r\n%s", err.Error())
+	}
+
+	db, err := openDatabase(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("there was an error reading the database schema version:\r\n%s", err.Error())
+	}
+	if latest := migrations[len(migrations)-1].Version; version != latest {
+		_ = db.Close()
+		return nil, fmt.Errorf("the SQLite database is at schema version %d instead of %d; run \"merlinserver "+
+			"migrate status\" to view the data directory migrations", version, latest)
+	}
+	return &SQLite{db: db, aead: aead}, nil
+}
+
+// SaveAgent creates or replaces the agent's row; its key material is encrypted and kept out of the JSON data column
+func (s *SQLite) SaveAgent(a Agent) error {
+	secret, err := s.encrypt(a.Secret)
+	if err != nil {
+		return err
+	}
+	rsaKey, err := s.encrypt(a.RSAKey)
+	if err != nil {
+		return err
+	}
+	opaque, err := s.encrypt(a.OPAQUERecord)
+	if err != nil {
+		return err
+	}
+	pending := a.Pending
+	a.Secret, a.RSAKey, a.OPAQUERecord, a.Pending = nil, nil, nil, false
+	data, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("there was an error encoding agent %s:\r\n%s", a.ID, err.Error())
+	}
+	_, err = s.db.Exec("INSERT OR REPLACE INTO agents (id, data, secret, rsakey, opaquerecord, pending) "+
+		"VALUES (?, ?, ?, ?, ?, ?)", a.ID.String(), string(data), secret, rsaKey, opaque, pending)
+	if err != nil {
+		return fmt.Errorf("there was an error saving agent %s:\r\n%s", a.ID, err.Error())
+	}
+	return nil
+}
+
+// RemoveAgent deletes the agent, its queued jobs, and its results
+func (s *SQLite) RemoveAgent(id uuid.UUID) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("there was an error removing agent %s:\r\n%s", id, err.Error())
+	}
+	for _, table := range []string{"agents WHERE id", "jobs WHERE agent", "results WHERE agent"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" = ?", id.String()); err != nil { // #nosec G202 - The tables are constants
+			_ = tx.Rollback()
+			return fmt.Errorf("there was an error removing agent %s:\r\n%s", id, err.Error())
+		}
+	}
+	return tx.Commit()
+}
+
+// Agents returns every saved agent with its key material decrypted
+func (s *SQLite) Agents() ([]Agent, error) {
+	rows, err := s.db.Query("SELECT data, secret, rsakey, opaquerecord, pending FROM agents")
+	if err != nil {
+		return nil, fmt.Errorf("there was an error reading the saved agents:\r\n%s", err.Error())
+	}
+	defer rows.Close() // #nosec G307
+
+	var agents []Agent
+	for rows.Next() {
+		var data string
+		var secret, rsaKey, opaque []byte
+		var pending bool
+		if err := rows.Scan(&data, &secret, &rsaKey, &opaque, &pending); err != nil {
+			return agents, fmt.Errorf("there was an error reading a saved agent:\r\n%s", err.Error())
+		}
+		var a Agent
+		if err := json.Unmarshal([]byte(data), &a); err != nil {
+			return agents, fmt.Errorf("there was an error decoding a saved agent:\r\n%s", err.Error())
+		}
+		a.Pending = pending
+		if a.Secret, err = s.decrypt(secret); err != nil {
+			return agents, fmt.Errorf("there was an error decrypting agent %s's session key:\r\n%s", a.ID, err.Error())
+		}
+		if a.RSAKey, err = s.decrypt(rsaKey); err != nil {
+			return agents, fmt.Errorf("there was an error decrypting agent %s's RSA key:\r\n%s", a.ID, err.Error())
+		}
+		if a.OPAQUERecord, err = s.decrypt(opaque); err != nil {
+			return agents, fmt.Errorf("there was an error decrypting agent %s's OPAQUE record:\r\n%s", a.ID, err.Error())
+		}
+		agents = append(agents, a)
+	}
+	return agents, rows.Err()
+}
+
+// SaveJobs replaces the agent's queued jobs
+func (s *SQLite) SaveJobs(id uuid.UUID, jobs []Job) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("there was an error saving agent %s's jobs:\r\n%s", id, err.Error())
+	}
+	if _, err := tx.Exec("DELETE FROM jobs WHERE agent = ?", id.String()); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("there was an error saving agent %s's jobs:\r\n%s", id, err.Error())
+	}
+	for i, job := range jobs {
+		data, err := json.Marshal(job)
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("there was an error encoding job %s:\r\n%s", job.ID, err.Error())
+		}
+		if _, err := tx.Exec("INSERT INTO jobs (agent, position, data) VALUES (?, ?, ?)", id.String(), i, string(data)); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("there was an error saving job %s:\r\n%s", job.ID, err.Error())
+		}
+	}
+	return tx.Commit()
+}
+
+// Jobs returns the agent's queued jobs in the order they were created
+func (s *SQLite) Jobs(id uuid.UUID) ([]Job, error) {
+	rows, err := s.db.Query("SELECT data FROM jobs WHERE agent = ? ORDER BY position", id.String())
+	if err != nil {
+		return nil, fmt.Errorf("there was an error reading agent %s's jobs:\r\n%s", id, err.Error())
+	}
+	defer rows.Close() // #nosec G307
+
+	var jobs []Job
+	for rows.Next() {
+		var data string
+		var job Job
+		if err := rows.Scan(&data); err != nil {
+			return jobs, fmt.Errorf("there was an error reading agent %s's jobs:\r\n%s", id, err.Error())
+		}
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			return jobs, fmt.Errorf("there was an error decoding a job for agent %s:\r\n%s", id, err.Error())
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// AddResult adds the job result for the agent
+func (s *SQLite) AddResult(id uuid.UUID, r Result) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("there was an error encoding the job result:\r\n%s", err.Error())
+	}
+	if _, err := s.db.Exec("INSERT INTO results (agent, data) VALUES (?, ?)", id.String(), string(data)); err != nil {
+		return fmt.Errorf("there was an error saving the job %s result:\r\n%s", r.Job, err.Error())
+	}
+	return nil
+}
+
+// Results returns the agent's job results in the order they were received
+func (s *SQLite) Results(id uuid.UUID) ([]Result, error) {
+	rows, err := s.db.Query("SELECT data FROM results WHERE agent = ? ORDER BY id", id.String())
+	if err != nil {
+		return nil, fmt.Errorf("there was an error reading agent %s's results:\r\n%s", id, err.Error())
+	}
+	defer rows.Close() // #nosec G307
+
+	var results []Result
+	for rows.Next() {
+		var data string
+		var r Result
+		if err := rows.Scan(&data); err != nil {
+			return results, fmt.Errorf("there was an error reading agent %s's results:\r\n%s", id, err.Error())
+		}
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			return results, fmt.Errorf("there was an error decoding a result for agent %s:\r\n%s", id, err.Error())
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// Key returns the named key and saves a new random key if it does not exist
+func (s *SQLite) Key(name string) ([]byte, error) {
+	var value []byte
+	err := s.db.QueryRow("SELECT value FROM keys WHERE name = ?", name).Scan(&value)
+	if err == nil {
+		key, err := s.decrypt(value)
+		if err != nil {
+			return nil, fmt.Errorf("there was an error decrypting the %s key:\r\n%s", name, err.Error())
+		}
+		return key, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("there was an error reading the %s key:\r\n%s", name, err.Error())
+	}
+
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	if value, err = s.encrypt(key); err != nil {
+		return nil, err
+	}
+	if _, err := s.db.Exec("INSERT INTO keys (name, value) VALUES (?, ?)", name, value); err != nil {
+		return nil, fmt.Errorf("there was an error saving the %s key:\r\n%s", name, err.Error())
+	}
+	return key, nil
+}
+
+// Name returns the SQLite storage type's name
+func (s *SQLite) Name() string { return "sqlite" }
+
+// Close closes the database
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+// encrypt seals the plaintext with the storage key and prefixes the random nonce; empty values are stored as NULL
+func (s *SQLite) encrypt(plaintext []byte) ([]byte, error) {
+	if len(plaintext) == 0 {
+		return nil, nil
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("there was an error generating a nonce:\r\n%s", err.Error())
+	}
+	return s.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// decrypt opens a value sealed by encrypt
+func (s *SQLite) decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 {
+		return nil, nil
+	}
+	if len(ciphertext) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("the encrypted value is too short")
+	}
+	nonce := ciphertext[:s.aead.NonceSize()]
+	return s.aead.Open(nil, nonce, ciphertext[s.aead.NonceSize():], nil)
+}
@@ -0,0 +1,200 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package storage persists agents, their queued jobs, and job results so they are not lost when the server restarts
+package storage
+
+import (
+	// Standard
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// Agent is the stored state of an agent, including the key material needed to keep communicating with it
+type Agent struct {
+	ID             uuid.UUID `json:"id"`
+	Platform       string    `json:"platform"`
+	Architecture   string    `json:"architecture"`
+	UserName       string    `json:"username"`
+	UserGUID       string    `json:"userguid"`
+	HostName       string    `json:"hostname"`
+	Ips            []string  `json:"ips"`
+	Interpreters   []string  `json:"interpreters"`
+	Pid            int       `json:"pid"`
+	InitialCheckIn time.Time `json:"initialcheckin"`
+	StatusCheckIn  time.Time `json:"statuscheckin"`
+	Version        string    `json:"version"`
+	Build          string    `json:"build"`
+	WaitTime       string    `json:"waittime"`
+	PaddingMax     int       `json:"paddingmax"`
+	MaxRetry       int       `json:"maxretry"`
+	FailedCheckin  int       `json:"failedcheckin"`
+	Skew           int64     `json:"skew"`
+	Jitter         int       `json:"jitter,omitempty"`       // The most percent the agent's sleep is randomly changed by
+	WorkingHours   string    `json:"workinghours,omitempty"` // The hours the agent only checks in during
+	UTCOffset      int       `json:"utcoffset,omitempty"`    // Seconds east of UTC of the agent host's time zone
+	DelayedAfter   float64   `json:"delayedafter,omitempty"` // Missed check in intervals before the agent is delayed
+	DeadAfter      float64   `json:"deadafter,omitempty"`    // Missed check in intervals before the agent is dead
+	Proto          string    `json:"proto"`
+	KillDate       int64     `json:"killdate"`
+	Callbacks      []string  `json:"callbacks,omitempty"`
+	Rotate         string    `json:"rotate,omitempty"`
+	Pins           []string  `json:"pins,omitempty"`
+	JA3            string    `json:"ja3,omitempty"`
+	Crashes        []Crash   `json:"crashes,omitempty"`
+	Watermark      string    `json:"watermark"`
+	ForkedFrom     uuid.UUID `json:"forkedfrom"`
+	Pending        bool      `json:"pending,omitempty"` // The agent is waiting for an operator to accept it
+	Tags           []string  `json:"tags,omitempty"`    // The groups the operator put the agent in
+	Secret         []byte    `json:"secret"`            // The session key used to encrypt messages
+	RSAKey         []byte    `json:"rsakey"`            // The server's PKCS #1 RSA private key for the agent
+	PublicKey      []byte    `json:"publickey"`         // The agent's PKCS #1 RSA public key
+	OPAQUERecord   []byte    `json:"opaquerecord"`      // The agent's OPAQUE registration used to authenticate it again
+}
+
+// Crash is a panic an agent recovered from and reported with its sanitized stack trace
+type Crash struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"` // The type of message the agent was handling
+	Panic   string    `json:"panic"`
+	Stack   string    `json:"stack"`
+}
+
+// Job is a job that has been created for an agent but not yet sent to it
+type Job struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	Status   string    `json:"status"`
+	Args     []string  `json:"args"`
+	Created  time.Time `json:"created"`
+	After    time.Time `json:"after,omitempty"`    // The job is not sent to the agent before this time
+	Barrier  string    `json:"barrier,omitempty"`  // The barrier holding the job until all of its agents are ready
+	Priority int       `json:"priority,omitempty"` // Jobs with a higher priority are sent first
+}
+
+// Result is the output an agent returned for a job
+type Result struct {
+	Job    string    `json:"job"`
+	Time   time.Time `json:"time"`
+	Stdout string    `json:"stdout,omitempty"`
+	Stderr string    `json:"stderr,omitempty"`
+}
+
+// Store saves and loads agents, their queued jobs, and job results
+type Store interface {
+	SaveAgent(a Agent) error                 // Create or replace the agent
+	RemoveAgent(id uuid.UUID) error          // Delete the agent, its jobs, and its results
+	Agents() ([]Agent, error)                // All saved agents
+	SaveJobs(id uuid.UUID, jobs []Job) error // Replace the agent's queued jobs
+	Jobs(id uuid.UUID) ([]Job, error)        // The agent's queued jobs in the order they were created
+	AddResult(id uuid.UUID, r Result) error  // Add a job result for the agent
+	Results(id uuid.UUID) ([]Result, error)  // The agent's job results in the order they were received
+	Key(name string) ([]byte, error)         // A 32 byte server key, such as the JWT key, created on first use
+	Name() string                            // The name of the storage type used with Open
+}
+
+// current is the store used by the server; it keeps everything in memory until Use is called
+var current Store = NewMemory()
+
+// Use sets the store used by the server
+func Use(s Store) {
+	current = s
+}
+
+// Current returns the store used by the server
+func Current() Store {
+	return current
+}
+
+// Open returns the store for the storage type. The "sqlite" type applies any pending migrations to the data directory
+// and uses its SQLite database; the "memory" type keeps the previous behavior of losing all agents and jobs when the
+// server restarts.
+func Open(kind string, dataDir string) (Store, error) {
+	switch kind {
+	case "sqlite":
+		return NewSQLite(dataDir)
+	case "memory":
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("%s is not a valid storage type; use sqlite or memory", kind)
+	}
+}
+
+// Memory is a store that does not persist anything
+type Memory struct {
+	sync.Mutex
+	keys map[string][]byte
+}
+
+// NewMemory returns a store that does not persist anything
+func NewMemory() *Memory {
+	return &Memory{keys: make(map[string][]byte)}
+}
+
+// SaveAgent does nothing because agents are only kept in memory
+func (m *Memory) SaveAgent(a Agent) error { return nil }
+
+// RemoveAgent does nothing because agents are only kept in memory
+func (m *Memory) RemoveAgent(id uuid.UUID) error { return nil }
+
+// Agents returns no agents
+func (m *Memory) Agents() ([]Agent, error) { return nil, nil }
+
+// SaveJobs does nothing because jobs are only kept in memory
+func (m *Memory) SaveJobs(id uuid.UUID, jobs []Job) error { return nil }
+
+// Jobs returns no jobs
+func (m *Memory) Jobs(id uuid.UUID) ([]Job, error) { return nil, nil }
+
+// AddResult does nothing because results are only displayed and logged
+func (m *Memory) AddResult(id uuid.UUID, r Result) error { return nil }
+
+// Results returns no results
+func (m *Memory) Results(id uuid.UUID) ([]Result, error) { return nil, nil }
+
+// Key returns a random key that lasts until the server exits
+func (m *Memory) Key(name string) ([]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+	if key, ok := m.keys[name]; ok {
+		return key, nil
+	}
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	m.keys[name] = key
+	return key, nil
+}
+
+// Name returns the memory storage type's name
+func (m *Memory) Name() string { return "memory" }
+
+// newKey returns a new random 32 byte key
+func newKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("there was an error generating a key:\r\n%s", err.Error())
+	}
+	return key, nil
+}
@@ -0,0 +1,190 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	// Standard
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// TestSQLite verifies agents, queued jobs, results, and keys are read back from a new SQLite store and removed with the
+// agent, and that the agents' key material is not stored in plaintext
+func TestSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merlin-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // #nosec G104
+
+	d, err := NewSQLite(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := uuid.NewV4()
+	agent := Agent{ID: id, HostName: "test", Pending: true, Secret: []byte("plaintext session key"),
+		RSAKey: []byte("plaintext RSA key"), OPAQUERecord: []byte("plaintext OPAQUE record")}
+	if err := d.SaveAgent(agent); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SaveJobs(id, []Job{{ID: "a", Type: "cmd"}, {ID: "b", Type: "cmd"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range []string{"a", "b"} {
+		if err := d.AddResult(id, Result{Job: job, Stdout: "output"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key, err := d.Key("jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new store, as used after a restart, reads the same data
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d, err = NewSQLite(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close() // #nosec G307
+	agents, err := d.Agents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 1 || agents[0].ID != id || agents[0].HostName != "test" || !agents[0].Pending ||
+		!bytes.Equal(agents[0].Secret, agent.Secret) || !bytes.Equal(agents[0].RSAKey, agent.RSAKey) ||
+		!bytes.Equal(agents[0].OPAQUERecord, agent.OPAQUERecord) {
+		t.Errorf("expected the saved agent, got %+v", agents)
+	}
+	db, err := ioutil.ReadFile(filepath.Join(dir, "db", "merlin.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(db, []byte("plaintext")) {
+		t.Error("expected the agent's key material to be encrypted in the database")
+	}
+	jobs, err := d.Jobs(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != "a" || jobs[1].ID != "b" {
+		t.Errorf("expected jobs a and b in order, got %+v", jobs)
+	}
+	results, err := d.Results(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[1].Job != "b" {
+		t.Errorf("expected 2 results, got %+v", results)
+	}
+	again, err := d.Key("jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 32 || !bytes.Equal(key, again) {
+		t.Error("expected the same 32 byte key after a restart")
+	}
+
+	if err := d.RemoveAgent(id); err != nil {
+		t.Fatal(err)
+	}
+	agents, _ = d.Agents()
+	jobs, _ = d.Jobs(id)
+	results, _ = d.Results(id)
+	if len(agents) != 0 || len(jobs) != 0 || len(results) != 0 {
+		t.Error("expected the agent, its jobs, and its results to be removed")
+	}
+}
+
+// TestSQLiteSchemaVersion verifies opening a new data directory applies the storage migrations and a database at a
+// different schema version is refused
+func TestSQLiteSchemaVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merlin-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // #nosec G104
+
+	s, err := Open("sqlite", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var version int
+	if err := s.(*SQLite).db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != migrations[len(migrations)-1].Version {
+		t.Errorf("expected schema version %d, got %d", migrations[len(migrations)-1].Version, version)
+	}
+	if _, err := s.(*SQLite).db.Exec("PRAGMA user_version = 1000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.(*SQLite).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open("sqlite", dir); err == nil {
+		t.Error("expected a database at a different schema version to be refused")
+	}
+}
+
+// TestSQLiteMigration verifies agents saved by the first schema are kept and not pending approval after the later
+// migrations are applied when the store is opened
+func TestSQLiteMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merlin-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // #nosec G104
+
+	if err := migrations[0].Up(dir); err != nil {
+		t.Fatal(err)
+	}
+	db, err := openDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := uuid.NewV4()
+	if _, err := db.Exec("INSERT INTO agents (id, data) VALUES (?, ?)", id.String(),
+		`{"id":"`+id.String()+`","hostname":"test"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSQLite(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close() // #nosec G307
+	agents, err := s.Agents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 1 || agents[0].ID != id || agents[0].HostName != "test" || agents[0].Pending {
+		t.Errorf("expected the agent saved before the migrations without pending approval, got %+v", agents)
+	}
+}
@@ -0,0 +1,190 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package targets
+
+import (
+	// Standard
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// UserLocation is a single place a user was observed with an interactive session or logon
+type UserLocation struct {
+	UserName  string    // The user's name as returned by the host
+	Domain    string    // The user's logon domain
+	Host      string    // The host the user was observed on
+	AgentID   uuid.UUID // The agent that reported the session
+	Method    string    // The enumeration method that observed the user (i.e. sessions-enum or loggedon)
+	SessionID uint32    // The Windows session ID, if known
+	State     string    // The session state (i.e. Active or Disconnected), if known
+	Client    string    // The remote client name for RDP sessions, if known
+	Seen      time.Time // The time the user was last observed at this location
+}
+
+// Hunt tracks a request to locate a user across a set of agents
+type Hunt struct {
+	User    string      // The user name being hunted
+	Agents  []uuid.UUID // The agents that were tasked to enumerate sessions for the hunt
+	Started time.Time   // The time the hunt was started
+}
+
+// hunts is a map of lower case user names to the hunt for that user
+var hunts = make(map[string]Hunt)
+
+// userLocations is a map of lower case user names to the locations they have been observed
+var userLocations = make(map[string][]UserLocation)
+var mutex = &sync.Mutex{}
+
+// AddUserLocations records the user sessions enumerated from a host. Any locations previously reported for the same
+// host and method are replaced so that users who have logged off are removed from the inventory.
+func AddUserLocations(agentID uuid.UUID, host string, method string, locations []UserLocation) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	host = strings.ToLower(host)
+	for user, locs := range userLocations {
+		var keep []UserLocation
+		for _, l := range locs {
+			if strings.ToLower(l.Host) != host || l.Method != method {
+				keep = append(keep, l)
+			}
+		}
+		if len(keep) == 0 {
+			delete(userLocations, user)
+		} else {
+			userLocations[user] = keep
+		}
+	}
+
+	now := time.Now().UTC()
+	for _, l := range locations {
+		if l.UserName == "" {
+			continue
+		}
+		l.AgentID = agentID
+		l.Host = host
+		l.Method = method
+		l.Seen = now
+		key := strings.ToLower(l.UserName)
+		userLocations[key] = append(userLocations[key], l)
+	}
+}
+
+// GetUserLocations returns every location the provided user has been observed. The user name is case insensitive and
+// may be prefixed with a domain (i.e. DOMAIN\user) to only return locations for that domain.
+func GetUserLocations(user string) []UserLocation {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	domain, user := splitUser(user)
+
+	var locations []UserLocation
+	for _, l := range userLocations[strings.ToLower(user)] {
+		if domain == "" || strings.EqualFold(domain, l.Domain) {
+			locations = append(locations, l)
+		}
+	}
+	return locations
+}
+
+// GetUsers returns a sorted list of every user name in the user location map
+func GetUsers() []string {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var users []string
+	for user := range userLocations {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// GetUserList returns a list of users in the target inventory and is used for command line tab completion
+func GetUserList() func(string) []string {
+	return func(line string) []string {
+		return GetUsers()
+	}
+}
+
+// AddHunt records that the provided agents were tasked to search for the provided user. The user name is case
+// insensitive and may be prefixed with a domain (i.e. DOMAIN\user) to only match users from that domain.
+func AddHunt(user string, agents []uuid.UUID) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	_, name := splitUser(user)
+	hunts[strings.ToLower(name)] = Hunt{
+		User:    user,
+		Agents:  agents,
+		Started: time.Now().UTC(),
+	}
+}
+
+// GetHunt returns the hunt for the provided user and a boolean that is true if the user is being hunted
+func GetHunt(user string) (Hunt, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	_, name := splitUser(user)
+	h, ok := hunts[strings.ToLower(name)]
+	return h, ok
+}
+
+// IsHunted returns true if the provided user from the provided domain matches a user being hunted
+func IsHunted(domain string, user string) bool {
+	h, ok := GetHunt(user)
+	if !ok {
+		return false
+	}
+	huntDomain, _ := splitUser(h.User)
+	return huntDomain == "" || strings.EqualFold(huntDomain, domain)
+}
+
+// GetHuntedHosts returns a sorted, de-duplicated list of hosts the hunted user has been observed on since the hunt started
+func GetHuntedHosts(user string) []string {
+	h, ok := GetHunt(user)
+	if !ok {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, l := range GetUserLocations(h.User) {
+		if l.Seen.Before(h.Started) || seen[l.Host] {
+			continue
+		}
+		seen[l.Host] = true
+		hosts = append(hosts, l.Host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// splitUser separates a DOMAIN\user formatted user name into its domain and user name parts
+func splitUser(user string) (string, string) {
+	if strings.Contains(user, "\\") {
+		s := strings.SplitN(user, "\\", 2)
+		return s[0], s[1]
+	}
+	return "", user
+}
@@ -0,0 +1,207 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package tools manages the server's tool repository, a directory of files with an index of their names, platforms,
+// and SHA-256 hashes, that agents fetch and verify over the C2 channel
+package tools
+
+import (
+	// Standard
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+)
+
+// validName matches tool names and platforms
+var validName = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+
+// Tool is a file in the tool repository
+type Tool struct {
+	Name        string `json:"name"`                  // The name agents fetch the tool by (i.e. seatbelt)
+	Platform    string `json:"platform"`              // The agent platform the tool runs on (i.e. windows); any for every platform
+	File        string `json:"file"`                  // The tool's path relative to the tools directory
+	SHA256      string `json:"sha256"`                // Hex encoded SHA-256 hash of the tool's file
+	Description string `json:"description,omitempty"` // A description of what the tool does
+}
+
+// Directory returns the tool repository directory
+func Directory() string {
+	return filepath.Join(core.CurrentDir, "data", "tools")
+}
+
+// GetIndex returns the tools in the tool repository's index
+func GetIndex() ([]Tool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(Directory(), "index.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("there was an error reading the tool index:\r\n%s", err.Error())
+	}
+	var index struct {
+		Tools []Tool `json:"tools"`
+	}
+	if err = json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("there was an error decoding the tool index:\r\n%s", err.Error())
+	}
+	return index.Tools, nil
+}
+
+// GetToolNames returns the names of the tools in the index for command line completion
+func GetToolNames() func(string) []string {
+	return func(line string) []string {
+		var names []string
+		index, _ := GetIndex()
+		for _, t := range index {
+			if !inSlice(t.Name, names) {
+				names = append(names, t.Name)
+			}
+		}
+		return names
+	}
+}
+
+// Find returns the named tool built for the platform, falling back to a tool that runs on any platform
+func Find(name string, platform string) (Tool, error) {
+	index, err := GetIndex()
+	if err != nil {
+		return Tool{}, err
+	}
+	var fallback *Tool
+	for i, t := range index {
+		if !strings.EqualFold(t.Name, name) {
+			continue
+		}
+		if strings.EqualFold(t.Platform, platform) {
+			return t, nil
+		}
+		if strings.EqualFold(t.Platform, "any") || t.Platform == "" {
+			fallback = &index[i]
+		}
+	}
+	if fallback != nil {
+		return *fallback, nil
+	}
+	return Tool{}, fmt.Errorf("the tool index does not have %s for the %s platform", name, platform)
+}
+
+// Read returns the tool's file after verifying it matches the hash in the index
+func Read(t Tool) ([]byte, error) {
+	path, err := toolPath(t.File)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("there was an error reading the %s tool:\r\n%s", t.Name, err.Error())
+	}
+	if hash := Hash(data); !strings.EqualFold(hash, t.SHA256) {
+		return nil, fmt.Errorf("the %s tool's SHA-256 hash %s does not match the index hash %s", t.Name, hash, t.SHA256)
+	}
+	return data, nil
+}
+
+// Add copies the file into the tool repository and adds it to the index, replacing a tool with the same name and
+// platform
+func Add(file string, name string, platform string, description string) (Tool, error) {
+	platform = strings.ToLower(platform)
+	if !validName.MatchString(name) || !validName.MatchString(platform) {
+		return Tool{}, fmt.Errorf("tool names and platforms may only contain letters, numbers, '.', '_', and '-'")
+	}
+	data, err := ioutil.ReadFile(file) // #nosec G304 Users can include any file they want
+	if err != nil {
+		return Tool{}, fmt.Errorf("there was an error reading %s:\r\n%s", file, err.Error())
+	}
+	index, err := GetIndex()
+	if err != nil {
+		return Tool{}, err
+	}
+
+	t := Tool{
+		Name:        name,
+		Platform:    platform,
+		File:        filepath.ToSlash(filepath.Join(platform, filepath.Base(file))),
+		SHA256:      Hash(data),
+		Description: description,
+	}
+	dest, err := toolPath(t.File)
+	if err != nil {
+		return Tool{}, err
+	}
+	if err = os.MkdirAll(filepath.Dir(dest), 0750); err != nil {
+		return Tool{}, fmt.Errorf("there was an error creating the tool directory:\r\n%s", err.Error())
+	}
+	if err = ioutil.WriteFile(dest, data, 0640); err != nil {
+		return Tool{}, fmt.Errorf("there was an error writing the tool to %s:\r\n%s", dest, err.Error())
+	}
+
+	replaced := false
+	for i, e := range index {
+		if strings.EqualFold(e.Name, name) && strings.EqualFold(e.Platform, platform) {
+			index[i] = t
+			replaced = true
+		}
+	}
+	if !replaced {
+		index = append(index, t)
+	}
+	out, err := json.MarshalIndent(struct {
+		Tools []Tool `json:"tools"`
+	}{index}, "", "  ")
+	if err != nil {
+		return Tool{}, fmt.Errorf("there was an error encoding the tool index:\r\n%s", err.Error())
+	}
+	if err = ioutil.WriteFile(filepath.Join(Directory(), "index.json"), out, 0640); err != nil {
+		return Tool{}, fmt.Errorf("there was an error writing the tool index:\r\n%s", err.Error())
+	}
+	return t, nil
+}
+
+// Hash returns the hex encoded SHA-256 hash of the data
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// toolPath returns the absolute path of a file in the tool repository, refusing paths that leave the directory
+func toolPath(file string) (string, error) {
+	dir := Directory()
+	path := filepath.Join(dir, filepath.FromSlash(file))
+	if !strings.HasPrefix(path, dir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("the tool file %s is outside of the tools directory", file)
+	}
+	return path, nil
+}
+
+// inSlice checks to see if a string is in a slice of strings
+func inSlice(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package tools
+
+import (
+	// Standard
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+)
+
+// TestTools adds tools to a temporary repository, finds them by platform, and detects a modified tool file
+func TestTools(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merlin-tools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cwd := core.CurrentDir
+	core.CurrentDir = dir
+	defer func() { core.CurrentDir = cwd }()
+
+	src := filepath.Join(dir, "tool.bin")
+	if err = ioutil.WriteFile(src, []byte("windows tool"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Add(src, "tool", "windows", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(src, []byte("any tool"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Add(src, "tool", "any", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Add(src, "../tool", "any", ""); err == nil {
+		t.Error("a tool name with a path separator was added")
+	}
+
+	cases := map[string]string{"windows": "windows tool", "linux": "any tool"}
+	for platform, want := range cases {
+		tool, err := Find("tool", platform)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := Read(tool)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("the %s tool was %q, expected %q", platform, data, want)
+		}
+	}
+	if _, err = Find("missing", "linux"); err == nil {
+		t.Error("a tool that is not in the index was found")
+	}
+
+	tool, _ := Find("tool", "windows")
+	if err = ioutil.WriteFile(filepath.Join(Directory(), tool.File), []byte("modified"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Read(tool); err == nil {
+		t.Error("a tool that does not match its index hash was read")
+	}
+}
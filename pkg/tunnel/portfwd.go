@@ -0,0 +1,157 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package tunnel
+
+import (
+	// Standard
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// Forward is a port forward through an agent. A local forward listens on the server and connects to its target from
+// the agent; a reverse forward listens on the agent's host and connects to its target from the server.
+type Forward struct {
+	ID          uint32
+	Type        string    // local or reverse
+	Listen      string    // The address the forward listens on, on the server for local and the agent for reverse
+	Target      string    // The host:port connections are forwarded to
+	Started     time.Time // When the forward was added
+	Connections int       // The open connections
+	Sent        uint64    // Bytes sent to the agent
+	Received    uint64    // Bytes received from the agent
+	Error       string    // Why the agent could not listen for a reverse forward
+	listener    net.Listener
+}
+
+// AddLocal listens on the server's address and forwards its connections through the agent to the target
+func AddLocal(agentID uuid.UUID, listen string, target string) (Forward, error) {
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return Forward{}, fmt.Errorf("%s is not a valid host:port target", target)
+	}
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return Forward{}, fmt.Errorf("there was an error listening on %s:\r\n%s", listen, err.Error())
+	}
+	s := getSession(agentID, true)
+	f := s.addForward("local", l.Addr().String(), target, l)
+	added := f.copy(s)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.open(c, target, false, f)
+		}
+	}()
+	return added, nil
+}
+
+// AddReverse has the agent listen on the address on its host and forwards its connections from the server to the
+// target
+func AddReverse(agentID uuid.UUID, listen string, target string) (Forward, error) {
+	if _, _, err := net.SplitHostPort(listen); err != nil {
+		return Forward{}, fmt.Errorf("%s is not a valid [interface]:port for the agent to listen on", listen)
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return Forward{}, fmt.Errorf("%s is not a valid host:port target", target)
+	}
+	s := getSession(agentID, true)
+	f := s.addForward("reverse", listen, target, nil)
+	added := f.copy(s)
+	s.queue(messages.TunnelPacket{Forward: f.ID, Listen: listen})
+	return added, nil
+}
+
+// RemoveForward stops the agent's port forward and closes its connections
+func RemoveForward(agentID uuid.UUID, id uint32) error {
+	s := getSession(agentID, false)
+	if s == nil {
+		return fmt.Errorf("agent %s does not have port forward %d", agentID, id)
+	}
+	s.Lock()
+	f, ok := s.forwards[id]
+	delete(s.forwards, id)
+	var ids []uint32
+	for cid, c := range s.conns {
+		if c.forward == f {
+			ids = append(ids, cid)
+		}
+	}
+	s.Unlock()
+	if !ok {
+		return fmt.Errorf("agent %s does not have port forward %d", agentID, id)
+	}
+	if f.listener != nil {
+		_ = f.listener.Close()
+	} else {
+		s.queue(messages.TunnelPacket{Forward: id, Close: true})
+	}
+	for _, cid := range ids {
+		s.remove(cid, true)
+	}
+	s.closeIfIdle()
+	return nil
+}
+
+// GetForwards returns the agent's port forwards
+func GetForwards(agentID uuid.UUID) []Forward {
+	s := getSession(agentID, false)
+	if s == nil {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	var forwards []Forward
+	for _, f := range s.forwards {
+		c := *f
+		c.Connections = s.connections(f)
+		c.listener = nil
+		forwards = append(forwards, c)
+	}
+	sort.Slice(forwards, func(i, j int) bool { return forwards[i].ID < forwards[j].ID })
+	return forwards
+}
+
+// addForward records a new port forward for the agent; reverse forwards do not have a listener on the server
+func (s *session) addForward(forwardType string, listen string, target string, l net.Listener) *Forward {
+	s.Lock()
+	defer s.Unlock()
+	s.nextForward++
+	f := &Forward{ID: s.nextForward, Type: forwardType, Listen: listen, Target: target, Started: time.Now().UTC(),
+		listener: l}
+	s.forwards[f.ID] = f
+	return f
+}
+
+// copy returns a copy of the port forward that is safe to use after the session's lock is released
+func (f *Forward) copy(s *session) Forward {
+	s.Lock()
+	defer s.Unlock()
+	c := *f
+	c.listener = nil
+	return c
+}
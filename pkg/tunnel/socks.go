@@ -0,0 +1,204 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package tunnel
+
+import (
+	// Standard
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+)
+
+// Socks is a SOCKS5 proxy on the server that tunnels its connections through an agent
+type Socks struct {
+	AgentID     uuid.UUID
+	Address     string    // The interface and port the SOCKS5 proxy listens on
+	Started     time.Time // When the SOCKS5 proxy was started
+	Connections int       // The open client connections
+	Sent        uint64    // Bytes sent from clients to the agent
+	Received    uint64    // Bytes received from the agent for clients
+	listener    net.Listener
+}
+
+// StartSocks runs a SOCKS5 proxy on the address that tunnels connections through the agent
+func StartSocks(agentID uuid.UUID, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("there was an error starting the SOCKS5 proxy on %s:\r\n%s", addr, err.Error())
+	}
+	s := getSession(agentID, true)
+	s.Lock()
+	if s.socks != nil {
+		s.Unlock()
+		_ = l.Close()
+		return fmt.Errorf("a SOCKS5 proxy is already running for agent %s on %s", agentID, s.socks.Address)
+	}
+	s.socks = &Socks{AgentID: agentID, Address: l.Addr().String(), Started: time.Now().UTC(), listener: l}
+	s.Unlock()
+	go s.acceptSocks(l)
+	return nil
+}
+
+// StopSocks closes the agent's SOCKS5 proxy and its connections
+func StopSocks(agentID uuid.UUID) error {
+	s := getSession(agentID, false)
+	if s == nil {
+		return fmt.Errorf("a SOCKS5 proxy is not running for agent %s", agentID)
+	}
+	s.Lock()
+	p := s.socks
+	s.socks = nil
+	var ids []uint32
+	for id, c := range s.conns {
+		if c.socks {
+			ids = append(ids, id)
+		}
+	}
+	s.Unlock()
+	if p == nil {
+		return fmt.Errorf("a SOCKS5 proxy is not running for agent %s", agentID)
+	}
+	_ = p.listener.Close()
+	for _, id := range ids {
+		s.remove(id, true)
+	}
+	s.closeIfIdle()
+	return nil
+}
+
+// GetSocks returns every running SOCKS5 proxy
+func GetSocks() []Socks {
+	sessions.Lock()
+	defer sessions.Unlock()
+	var proxies []Socks
+	for _, s := range sessions.m {
+		s.Lock()
+		if s.socks != nil {
+			p := *s.socks
+			p.Connections = s.connections(nil)
+			proxies = append(proxies, p)
+		}
+		s.Unlock()
+	}
+	sort.Slice(proxies, func(i, j int) bool { return proxies[i].Started.Before(proxies[j].Started) })
+	return proxies
+}
+
+// acceptSocks serves SOCKS5 clients until the proxy is closed
+func (s *session) acceptSocks(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			target, err := socksHandshake(c)
+			if err != nil {
+				_ = c.Close()
+				return
+			}
+			s.open(c, target, true, nil)
+		}()
+	}
+}
+
+// socksHandshake negotiates a SOCKS5 session without authentication and returns the host:port of the client's
+// CONNECT request; other requests are refused
+func socksHandshake(c net.Conn) (string, error) {
+	_ = c.SetDeadline(time.Now().Add(30 * time.Second))
+	defer c.SetDeadline(time.Time{}) // #nosec G104
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c, header); err != nil {
+		return "", err
+	}
+	if header[0] != 5 {
+		return "", fmt.Errorf("SOCKS version %d is not supported", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return "", err
+	}
+	noAuth := false
+	for _, m := range methods {
+		if m == 0 {
+			noAuth = true
+		}
+	}
+	if !noAuth {
+		_, _ = c.Write([]byte{5, 0xff})
+		return "", errors.New("the client does not support connecting without authentication")
+	}
+	if _, err := c.Write([]byte{5, 0}); err != nil {
+		return "", err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(c, request); err != nil {
+		return "", err
+	}
+	var host string
+	switch request[3] {
+	case 1, 4:
+		ip := make([]byte, 4)
+		if request[3] == 4 {
+			ip = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(c, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(c, length); err != nil {
+			return "", err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(c, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		// Address type not supported
+		_, _ = c.Write(socksReply(8))
+		return "", fmt.Errorf("SOCKS address type %d is not supported", request[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(c, port); err != nil {
+		return "", err
+	}
+	if request[1] != 1 {
+		// Command not supported
+		_, _ = c.Write(socksReply(7))
+		return "", fmt.Errorf("SOCKS command %d is not supported", request[1])
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply returns a SOCKS5 reply with the status code and an empty IPv4 bound address
+func socksReply(status byte) []byte {
+	return []byte{5, status, 0, 1, 0, 0, 0, 0, 0, 0}
+}
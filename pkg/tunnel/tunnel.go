@@ -0,0 +1,338 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package tunnel multiplexes the connections of SOCKS5 proxies and port forwards that run through an agent into
+// packets the agent exchanges with the server over its C2 channel
+package tunnel
+
+import (
+	// Standard
+	"net"
+	"sync"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+const (
+	// connectTimeout is how long a client waits for the agent to connect to its target; the agent's first exchange
+	// waits for its next check in
+	connectTimeout = 5 * time.Minute
+	// maxPending is the most client data queued for an agent before reading from clients pauses
+	maxPending = 4 << 20
+	// maxExchange is the most data returned to the agent in one exchange
+	maxExchange = 1 << 20
+	// readSize is the most data read from a client into one packet
+	readSize = 32 << 10
+)
+
+// sessions are the tunnels keyed by agent ID
+var sessions = struct {
+	sync.Mutex
+	m map[uuid.UUID]*session
+}{m: make(map[uuid.UUID]*session)}
+
+// session is the SOCKS5 proxy, port forwards, and connections tunneled through one agent
+type session struct {
+	sync.Mutex
+	cond        *sync.Cond
+	agentID     uuid.UUID
+	socks       *Socks
+	forwards    map[uint32]*Forward
+	nextForward uint32
+	conns       map[uint32]*conn
+	nextID      uint32
+	pending     []messages.TunnelPacket // Packets waiting for the agent's next exchange
+	size        int                     // Bytes of data in pending
+	closed      bool
+}
+
+// conn is a client connection tunneled through the agent
+type conn struct {
+	net.Conn
+	socks     bool        // The connection is a SOCKS5 client that is sent a reply when the agent connects
+	forward   *Forward    // The port forward the connection belongs to; nil for SOCKS5 connections
+	connected chan string // Receives the agent's connection error, empty on success, after any SOCKS5 reply is written
+}
+
+// signal tells the client's goroutine the result of the agent's connection; only the first result is kept
+func (c *conn) signal(err string) {
+	select {
+	case c.connected <- err:
+	default:
+	}
+}
+
+// Running returns true if the agent has a SOCKS5 proxy or port forward and should be exchanging tunnel packets
+func Running(agentID uuid.UUID) bool {
+	sessions.Lock()
+	defer sessions.Unlock()
+	_, ok := sessions.m[agentID]
+	return ok
+}
+
+// getSession returns the agent's tunnel, creating it when create is true
+func getSession(agentID uuid.UUID, create bool) *session {
+	sessions.Lock()
+	defer sessions.Unlock()
+	s, ok := sessions.m[agentID]
+	if !ok && create {
+		s = &session{
+			agentID:  agentID,
+			forwards: make(map[uint32]*Forward),
+			conns:    make(map[uint32]*conn),
+		}
+		s.cond = sync.NewCond(&s.Mutex)
+		sessions.m[agentID] = s
+	}
+	return s
+}
+
+// closeIfIdle closes the agent's tunnel and its connections once it has no SOCKS5 proxy or port forwards; the agent
+// closes its connections on its next exchange
+func (s *session) closeIfIdle() {
+	sessions.Lock()
+	s.Lock()
+	if s.socks != nil || len(s.forwards) > 0 {
+		s.Unlock()
+		sessions.Unlock()
+		return
+	}
+	if sessions.m[s.agentID] == s {
+		delete(sessions.m, s.agentID)
+	}
+	sessions.Unlock()
+	s.closed = true
+	for id, c := range s.conns {
+		_ = c.Close()
+		delete(s.conns, id)
+	}
+	s.pending = nil
+	s.cond.Broadcast()
+	s.Unlock()
+}
+
+// Exchange delivers the agent's packets to their clients and returns the packets waiting for the agent. The returned
+// command is stop when the agent does not have a SOCKS5 proxy or port forward.
+func Exchange(agentID uuid.UUID, packets []messages.TunnelPacket) (string, []messages.TunnelPacket) {
+	s := getSession(agentID, false)
+	if s == nil {
+		return "stop", nil
+	}
+	for _, p := range packets {
+		s.deliver(p)
+	}
+	return "", s.drain()
+}
+
+// deliver handles a packet from the agent
+func (s *session) deliver(p messages.TunnelPacket) {
+	// A reverse port forward's listener failed or the agent accepted a connection for it
+	if p.Forward != 0 {
+		s.Lock()
+		f, ok := s.forwards[p.Forward]
+		if ok && p.ID == 0 && p.Error != "" {
+			f.Error = p.Error
+		}
+		s.Unlock()
+		if p.ID != 0 {
+			if ok {
+				go s.dial(p.ID, f)
+			} else {
+				s.queue(messages.TunnelPacket{ID: p.ID, Close: true})
+			}
+		}
+		return
+	}
+
+	s.Lock()
+	c, ok := s.conns[p.ID]
+	if ok {
+		if c.forward != nil {
+			c.forward.Received += uint64(len(p.Data))
+		} else if s.socks != nil {
+			s.socks.Received += uint64(len(p.Data))
+		}
+	}
+	s.Unlock()
+	if !ok {
+		if !p.Close {
+			s.queue(messages.TunnelPacket{ID: p.ID, Close: true})
+		}
+		return
+	}
+	if p.Connected {
+		// The SOCKS5 reply is written here, not by the client's goroutine, so it precedes the data that follows it
+		if c.socks {
+			if _, err := c.Write(socksReply(0)); err != nil {
+				s.remove(p.ID, true)
+				return
+			}
+		}
+		c.signal("")
+	} else if p.Close && p.Error != "" {
+		if c.socks {
+			// Host unreachable
+			_, _ = c.Write(socksReply(4))
+		}
+		c.signal(p.Error)
+	}
+	if len(p.Data) > 0 {
+		if _, err := c.Write(p.Data); err != nil {
+			s.remove(p.ID, true)
+			return
+		}
+	}
+	if p.Close {
+		s.remove(p.ID, false)
+	}
+}
+
+// drain removes and returns the packets waiting for the agent
+func (s *session) drain() []messages.TunnelPacket {
+	s.Lock()
+	defer s.Unlock()
+	var out []messages.TunnelPacket
+	size := 0
+	for len(s.pending) > 0 && (size == 0 || size+len(s.pending[0].Data) <= maxExchange) {
+		p := s.pending[0]
+		size += len(p.Data)
+		if c, ok := s.conns[p.ID]; ok && c.forward != nil {
+			c.forward.Sent += uint64(len(p.Data))
+		} else if s.socks != nil {
+			s.socks.Sent += uint64(len(p.Data))
+		}
+		out = append(out, p)
+		s.pending = s.pending[1:]
+	}
+	s.size -= size
+	s.cond.Broadcast()
+	return out
+}
+
+// queue adds a packet for the agent, waiting while too much client data is already waiting
+func (s *session) queue(p messages.TunnelPacket) {
+	s.Lock()
+	defer s.Unlock()
+	for len(p.Data) > 0 && s.size >= maxPending && !s.closed {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return
+	}
+	s.pending = append(s.pending, p)
+	s.size += len(p.Data)
+}
+
+// remove closes a client connection; notify tells the agent to close its side
+func (s *session) remove(id uint32, notify bool) {
+	s.Lock()
+	c, ok := s.conns[id]
+	delete(s.conns, id)
+	s.Unlock()
+	if ok {
+		_ = c.Close()
+		if notify {
+			s.queue(messages.TunnelPacket{ID: id, Close: true})
+		}
+	}
+}
+
+// open asks the agent to connect to the target for a client and relays the client's data once it has
+func (s *session) open(client net.Conn, target string, socks bool, f *Forward) {
+	c := &conn{Conn: client, socks: socks, forward: f, connected: make(chan string, 1)}
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		_ = client.Close()
+		return
+	}
+	s.nextID++
+	id := s.nextID
+	s.conns[id] = c
+	s.Unlock()
+	s.queue(messages.TunnelPacket{ID: id, Target: target})
+
+	select {
+	case e := <-c.connected:
+		if e != "" {
+			return
+		}
+	case <-time.After(connectTimeout):
+		s.Lock()
+		_, ok := s.conns[id]
+		s.Unlock()
+		if ok {
+			if socks {
+				// TTL expired
+				_, _ = client.Write(socksReply(6))
+			}
+			s.remove(id, true)
+		}
+		return
+	}
+	s.relay(id, client)
+}
+
+// dial connects to a reverse port forward's target for a connection the agent accepted
+func (s *session) dial(id uint32, f *Forward) {
+	target, err := net.DialTimeout("tcp", f.Target, 30*time.Second)
+	if err != nil {
+		s.queue(messages.TunnelPacket{ID: id, Close: true, Error: err.Error()})
+		return
+	}
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		_ = target.Close()
+		return
+	}
+	s.conns[id] = &conn{Conn: target, forward: f, connected: make(chan string, 1)}
+	s.Unlock()
+	s.queue(messages.TunnelPacket{ID: id, Connected: true})
+	s.relay(id, target)
+}
+
+// relay sends the connection's data to the agent until the connection is closed
+func (s *session) relay(id uint32, c net.Conn) {
+	buf := make([]byte, readSize)
+	for {
+		n, err := c.Read(buf)
+		if n > 0 {
+			s.queue(messages.TunnelPacket{ID: id, Data: append([]byte(nil), buf[:n]...)})
+		}
+		if err != nil {
+			s.remove(id, true)
+			return
+		}
+	}
+}
+
+// connections returns the number of open connections for the port forward, or for the SOCKS5 proxy when f is nil
+func (s *session) connections(f *Forward) int {
+	count := 0
+	for _, c := range s.conns {
+		if c.forward == f {
+			count++
+		}
+	}
+	return count
+}
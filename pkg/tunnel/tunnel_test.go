@@ -0,0 +1,162 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package tunnel
+
+import (
+	// Standard
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	// 3rd Party
+	"github.com/satori/go.uuid"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/messages"
+)
+
+// TestSocks connects a SOCKS5 client and plays the agent's side of the exchange by echoing the client's data
+func TestSocks(t *testing.T) {
+	agentID := uuid.NewV4()
+	if err := StartSocks(agentID, "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := StartSocks(agentID, "127.0.0.1:0"); err == nil {
+		t.Error("a second SOCKS5 proxy was started for the agent")
+	}
+
+	client, err := net.Dial("tcp", GetSocks()[0].Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close() // #nosec G307
+	// Greeting without authentication and a CONNECT request for example.com:80
+	request := []byte{5, 1, 0, 5, 1, 0, 3, 11}
+	request = append(request, "example.com"...)
+	request = append(request, 0, 80)
+	if _, err = client.Write(request); err != nil {
+		t.Fatal(err)
+	}
+
+	var packets []messages.TunnelPacket
+	for start := time.Now(); len(packets) == 0 && time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		_, packets = Exchange(agentID, nil)
+	}
+	if len(packets) != 1 || packets[0].Target != "example.com:80" {
+		t.Fatalf("the connect packet was %+v", packets)
+	}
+	id := packets[0].ID
+	Exchange(agentID, []messages.TunnelPacket{{ID: id, Connected: true}})
+
+	reply := make([]byte, 12)
+	if _, err = io.ReadFull(client, reply); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply, []byte{5, 0, 5, 0, 0, 1, 0, 0, 0, 0, 0, 0}) {
+		t.Fatalf("the SOCKS5 replies were %v", reply)
+	}
+
+	if _, err = client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	for start := time.Now(); len(data) < 4 && time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		_, packets = Exchange(agentID, nil)
+		for _, p := range packets {
+			data = append(data, p.Data...)
+		}
+	}
+	if string(data) != "ping" {
+		t.Fatalf("the agent received %q", data)
+	}
+	Exchange(agentID, []messages.TunnelPacket{{ID: id, Data: []byte("pong"), Close: true}})
+	echo, err := ioutil.ReadAll(client)
+	if err != nil || string(echo) != "pong" {
+		t.Fatalf("the client received %q, %v", echo, err)
+	}
+
+	if err = StopSocks(agentID); err != nil {
+		t.Fatal(err)
+	}
+	if command, _ := Exchange(agentID, nil); command != "stop" {
+		t.Errorf("the exchange command after stopping was %q", command)
+	}
+}
+
+// TestReverseForward plays the agent's side of a reverse port forward that connects to an echo server
+func TestReverseForward(t *testing.T) {
+	agentID := uuid.NewV4()
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close() // #nosec G307
+	go func() {
+		c, err := echo.Accept()
+		if err == nil {
+			_, _ = io.Copy(c, c)
+			_ = c.Close()
+		}
+	}()
+
+	f, err := AddReverse(agentID, "127.0.0.1:8080", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, packets := Exchange(agentID, nil)
+	if len(packets) != 1 || packets[0].Forward != f.ID || packets[0].Listen != "127.0.0.1:8080" {
+		t.Fatalf("the listen packet was %+v", packets)
+	}
+
+	// The agent accepted a connection
+	id := uint32(1<<31 | 1)
+	Exchange(agentID, []messages.TunnelPacket{{ID: id, Forward: f.ID}})
+	var data []byte
+	connected := false
+	for start := time.Now(); string(data) != "ping" && time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		var in []messages.TunnelPacket
+		if connected && data == nil {
+			in = []messages.TunnelPacket{{ID: id, Data: []byte("ping")}}
+			data = []byte{}
+		}
+		_, packets = Exchange(agentID, in)
+		for _, p := range packets {
+			if p.ID != id || p.Close {
+				t.Fatalf("unexpected packet %+v", p)
+			}
+			connected = connected || p.Connected
+			data = append(data, p.Data...)
+		}
+	}
+	if string(data) != "ping" {
+		t.Fatalf("the agent received %q", data)
+	}
+	if forwards := GetForwards(agentID); len(forwards) != 1 || forwards[0].Connections != 1 {
+		t.Errorf("the port forwards were %+v", forwards)
+	}
+
+	if err = RemoveForward(agentID, f.ID); err != nil {
+		t.Fatal(err)
+	}
+	if command, _ := Exchange(agentID, nil); command != "stop" {
+		t.Errorf("the exchange command after removing the port forward was %q", command)
+	}
+}
@@ -0,0 +1,41 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package util
+
+import (
+	// Standard
+	"testing"
+)
+
+// TestJoinHostPort verifies IPv4, IPv6, and bracketed IPv6 hosts are joined with a port
+func TestJoinHostPort(t *testing.T) {
+	cases := map[string]string{
+		"127.0.0.1":    "127.0.0.1:443",
+		"::1":          "[::1]:443",
+		"[::1]":        "[::1]:443",
+		"fe80::1%eth0": "[fe80::1%eth0]:443",
+		"::":           "[::]:443",
+		"":             ":443",
+		"example.com":  "example.com:443",
+	}
+	for host, want := range cases {
+		if got := JoinHostPort(host, "443"); got != want {
+			t.Errorf("%s was joined as %s instead of %s", host, got, want)
+		}
+	}
+}
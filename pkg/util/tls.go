@@ -24,10 +24,14 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
 	"math/big"
+	"strings"
 	"time"
 )
 
@@ -123,3 +127,21 @@ func getPublicKey(priv interface{}) interface{} {
 		return nil
 	}
 }
+
+// PublicKeyPin returns the pin for a certificate: the base64 encoded SHA-256 hash of its DER encoded public key. The pin
+// stays the same when a certificate is renewed with the same key.
+func PublicKeyPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// ParsePin returns the pin with an optional sha256// prefix removed, or an error if it is not a base64 encoded SHA-256
+// hash
+func ParsePin(pin string) (string, error) {
+	pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256//")
+	hash, err := base64.StdEncoding.DecodeString(pin)
+	if err != nil || len(hash) != sha256.Size {
+		return "", fmt.Errorf("%s is not a base64 encoded SHA-256 public key pin", pin)
+	}
+	return pin, nil
+}
@@ -214,3 +214,37 @@ func TestTLSCertGeneration(t *testing.T) {
 
 	//todo: test certificates generated can be used for TLS operations
 }
+
+// TestPublicKeyPin tests that a certificate renewed with the same key keeps its pin and that pins are parsed
+func TestPublicKeyPin(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Couldn't generate EC key", err)
+	}
+	var pins []string
+	for i := 0; i < 2; i++ {
+		cer, err := GenerateTLSCert(big.NewInt(int64(i+1)), nil, nil, nil, nil, key, false)
+		if err != nil {
+			t.Fatal("Certificate generation error:" + err.Error())
+		}
+		x, err := x509.ParseCertificate(cer.Certificate[0])
+		if err != nil {
+			t.Fatal("Could not parse X509 certificate")
+		}
+		pins = append(pins, PublicKeyPin(x))
+	}
+	if pins[0] != pins[1] {
+		t.Errorf("certificates with the same key have different pins %s and %s", pins[0], pins[1])
+	}
+
+	pin, err := ParsePin("sha256//" + pins[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pin != pins[0] {
+		t.Errorf("parsed pin %s should be %s", pin, pins[0])
+	}
+	if _, err := ParsePin("bm90IGEgcGlu"); err == nil {
+		t.Error("a base64 string that is not a SHA-256 hash was parsed as a pin")
+	}
+}
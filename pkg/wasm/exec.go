@@ -0,0 +1,837 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package wasm
+
+import (
+	// Standard
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"time"
+)
+
+const (
+	maxCallDepth  = 1000    // maxCallDepth limits recursion to stop runaway modules from exhausting the agent's stack
+	deadlineSteps = 1 << 16 // deadlineSteps is how many instructions are executed between checks of the deadline
+)
+
+// label is the branch target of a structured control instruction
+type label struct {
+	target int // target is the position execution continues at when branching to the label
+	arity  int // arity is the number of values a branch to the label carries
+	height int // height is the operand stack height when the label was entered, less its parameters
+	loop   bool
+}
+
+// invoke calls the function at the provided index of the function index space; traps are raised with panic
+func (i *Instance) invoke(index uint32, args []uint64) []uint64 {
+	if int(index) < len(i.host) {
+		h := i.host[index]
+		results, err := h.Func(i, args)
+		if err != nil {
+			panic(err)
+		}
+		if len(results) != len(h.Type.Results) {
+			trap("host function %s.%s returned %d results instead of %d", i.module.imports[index].module,
+				i.module.imports[index].name, len(results), len(h.Type.Results))
+		}
+		return results
+	}
+
+	i.depth++
+	if i.depth > maxCallDepth {
+		trap("call stack exhausted")
+	}
+	defer func() { i.depth-- }()
+
+	f := &i.module.funcs[int(index)-len(i.host)]
+	t := i.module.types[f.typeIndex]
+	locals := make([]uint64, len(t.Params)+len(f.locals))
+	copy(locals, args)
+	return i.execute(f, t, locals)
+}
+
+// blockArity returns the number of parameters and results of a block type read by reader.blockType
+func (i *Instance) blockArity(bt int64) (int, int) {
+	switch {
+	case bt == -1:
+		return 0, 0
+	case bt < -1:
+		return 0, 1
+	case int(bt) < len(i.module.types):
+		return len(i.module.types[bt].Params), len(i.module.types[bt].Results)
+	}
+	trap("invalid block type %d", bt)
+	return 0, 0
+}
+
+// execute interprets a function body and returns its results
+func (i *Instance) execute(f *function, t FuncType, locals []uint64) []uint64 {
+	r := &reader{b: f.body}
+	var stack []uint64
+	var labels []label
+
+	push := func(v uint64) { stack = append(stack, v) }
+	pop := func() uint64 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+	// branch unwinds the operand and label stacks to the nth label and continues at its target
+	branch := func(n uint32) bool {
+		if int(n) >= len(labels) {
+			return false
+		}
+		l := labels[len(labels)-1-int(n)]
+		copy(stack[l.height:], stack[len(stack)-l.arity:])
+		stack = stack[:l.height+l.arity]
+		if l.loop {
+			labels = labels[:len(labels)-int(n)]
+		} else {
+			labels = labels[:len(labels)-1-int(n)]
+		}
+		r.pos = l.target
+		return true
+	}
+	// address pops a memory index, adds the static offset, and returns it after checking the access is in bounds
+	address := func(size uint64) uint64 {
+		r.u32() // alignment hint
+		offset := uint64(r.u32())
+		addr := uint64(uint32(pop())) + offset
+		if addr+size > uint64(len(i.memory)) {
+			trap("out of bounds memory access")
+		}
+		return addr
+	}
+
+	for {
+		i.steps++
+		if i.steps%deadlineSteps == 0 && !i.Deadline.IsZero() && time.Now().After(i.Deadline) {
+			trap("execution deadline exceeded")
+		}
+
+		pc := r.pos
+		op := r.byte()
+		switch op {
+		// Control instructions
+		case 0x00:
+			trap("unreachable")
+		case 0x01:
+		case 0x02, 0x03:
+			params, results := i.blockArity(r.blockType())
+			l := label{arity: results, height: len(stack) - params}
+			if op == 0x03 {
+				l.target, l.arity, l.loop = r.pos, params, true
+			} else {
+				l.target = f.blocks[pc].endAt + 1
+			}
+			labels = append(labels, l)
+		case 0x04:
+			params, results := i.blockArity(r.blockType())
+			b := f.blocks[pc]
+			l := label{target: b.endAt + 1, arity: results, height: len(stack) - 1 - params}
+			if uint32(pop()) != 0 {
+				labels = append(labels, l)
+			} else if b.elseAt >= 0 {
+				labels = append(labels, l)
+				r.pos = b.elseAt + 1
+			} else {
+				r.pos = b.endAt + 1
+			}
+		case 0x05:
+			// Reaching else means the if branch finished; skip the else branch
+			l := labels[len(labels)-1]
+			labels = labels[:len(labels)-1]
+			r.pos = l.target
+		case 0x0b:
+			if len(labels) == 0 {
+				return stack[len(stack)-len(t.Results):]
+			}
+			labels = labels[:len(labels)-1]
+		case 0x0c:
+			if !branch(r.u32()) {
+				return stack[len(stack)-len(t.Results):]
+			}
+		case 0x0d:
+			n := r.u32()
+			if uint32(pop()) != 0 && !branch(n) {
+				return stack[len(stack)-len(t.Results):]
+			}
+		case 0x0e:
+			count := r.u32()
+			index := uint32(stack[len(stack)-1])
+			var target uint32
+			for n := uint32(0); n <= count; n++ {
+				v := r.u32()
+				if n == index || n == count {
+					target = v
+					// Skip the remaining entries so that the reader is left on the next instruction
+					for n++; n <= count; n++ {
+						r.u32()
+					}
+					break
+				}
+			}
+			pop()
+			if !branch(target) {
+				return stack[len(stack)-len(t.Results):]
+			}
+		case 0x0f:
+			return stack[len(stack)-len(t.Results):]
+		case 0x10, 0x11:
+			var index uint32
+			if op == 0x10 {
+				index = r.u32()
+			} else {
+				typeIndex := r.u32()
+				r.u32() // table index
+				element := uint64(uint32(pop()))
+				if element >= uint64(len(i.table)) {
+					trap("undefined element %d", element)
+				}
+				if i.table[element] < 0 {
+					trap("uninitialized element %d", element)
+				}
+				index = uint32(i.table[element])
+				ft, err := i.funcType(index)
+				if err != nil || int(typeIndex) >= len(i.module.types) || !ft.equal(i.module.types[typeIndex]) {
+					trap("indirect call type mismatch")
+				}
+			}
+			ft, err := i.funcType(index)
+			if err != nil {
+				trap("%s", err.Error())
+			}
+			args := make([]uint64, len(ft.Params))
+			copy(args, stack[len(stack)-len(args):])
+			stack = stack[:len(stack)-len(args)]
+			stack = append(stack, i.invoke(index, args)...)
+
+		// Parametric instructions
+		case 0x1a:
+			pop()
+		case 0x1b, 0x1c:
+			if op == 0x1c {
+				for n := r.u32(); n > 0; n-- {
+					r.byte()
+				}
+			}
+			c := uint32(pop())
+			b := pop()
+			if c == 0 {
+				stack[len(stack)-1] = b
+			}
+
+		// Variable instructions
+		case 0x20:
+			push(locals[r.u32()])
+		case 0x21:
+			locals[r.u32()] = pop()
+		case 0x22:
+			locals[r.u32()] = stack[len(stack)-1]
+		case 0x23:
+			push(i.globals[r.u32()])
+		case 0x24:
+			index := r.u32()
+			if !i.module.globals[index].mutable {
+				trap("global %d is immutable", index)
+			}
+			i.globals[index] = pop()
+
+		// Memory instructions
+		case 0x28, 0x2a:
+			a := address(4)
+			push(uint64(binary.LittleEndian.Uint32(i.memory[a:])))
+		case 0x29, 0x2b:
+			a := address(8)
+			push(binary.LittleEndian.Uint64(i.memory[a:]))
+		case 0x2c:
+			a := address(1)
+			push(uint64(uint32(int32(int8(i.memory[a])))))
+		case 0x2d:
+			a := address(1)
+			push(uint64(i.memory[a]))
+		case 0x2e:
+			a := address(2)
+			push(uint64(uint32(int32(int16(binary.LittleEndian.Uint16(i.memory[a:]))))))
+		case 0x2f:
+			a := address(2)
+			push(uint64(binary.LittleEndian.Uint16(i.memory[a:])))
+		case 0x30:
+			a := address(1)
+			push(uint64(int64(int8(i.memory[a]))))
+		case 0x31:
+			a := address(1)
+			push(uint64(i.memory[a]))
+		case 0x32:
+			a := address(2)
+			push(uint64(int64(int16(binary.LittleEndian.Uint16(i.memory[a:])))))
+		case 0x33:
+			a := address(2)
+			push(uint64(binary.LittleEndian.Uint16(i.memory[a:])))
+		case 0x34:
+			a := address(4)
+			push(uint64(int64(int32(binary.LittleEndian.Uint32(i.memory[a:])))))
+		case 0x35:
+			a := address(4)
+			push(uint64(binary.LittleEndian.Uint32(i.memory[a:])))
+		case 0x36, 0x38, 0x3e:
+			v := pop()
+			a := address(4)
+			binary.LittleEndian.PutUint32(i.memory[a:], uint32(v))
+		case 0x37, 0x39:
+			v := pop()
+			a := address(8)
+			binary.LittleEndian.PutUint64(i.memory[a:], v)
+		case 0x3a, 0x3c:
+			v := pop()
+			a := address(1)
+			i.memory[a] = byte(v)
+		case 0x3b, 0x3d:
+			v := pop()
+			a := address(2)
+			binary.LittleEndian.PutUint16(i.memory[a:], uint16(v))
+		case 0x3f:
+			r.byte()
+			push(uint64(len(i.memory) / pageSize))
+		case 0x40:
+			r.byte()
+			pages := len(i.memory) / pageSize
+			delta := uint64(uint32(pop()))
+			if !i.module.hasMem || uint64(pages)+delta > uint64(i.module.memMax) {
+				push(uint64(math.MaxUint32)) // -1
+				break
+			}
+			i.memory = append(i.memory, make([]byte, int(delta)*pageSize)...)
+			push(uint64(pages))
+
+		// Numeric constants
+		case 0x41:
+			push(uint64(uint32(int32(r.sleb(32)))))
+		case 0x42:
+			push(uint64(r.sleb(64)))
+		case 0x43:
+			push(uint64(binary.LittleEndian.Uint32(r.bytes(4))))
+		case 0x44:
+			push(binary.LittleEndian.Uint64(r.bytes(8)))
+
+		// i32 comparison
+		case 0x45:
+			push(b2u(uint32(pop()) == 0))
+		case 0x46, 0x47, 0x48, 0x49, 0x4a, 0x4b, 0x4c, 0x4d, 0x4e, 0x4f:
+			b := uint32(pop())
+			a := uint32(pop())
+			push(b2u(compareI32(op, a, b)))
+
+		// i64 comparison
+		case 0x50:
+			push(b2u(pop() == 0))
+		case 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a:
+			b := pop()
+			a := pop()
+			push(b2u(compareI64(op, a, b)))
+
+		// f32 comparison
+		case 0x5b, 0x5c, 0x5d, 0x5e, 0x5f, 0x60:
+			b := float64(f32(pop()))
+			a := float64(f32(pop()))
+			push(b2u(compareFloat(op-0x5b, a, b)))
+
+		// f64 comparison
+		case 0x61, 0x62, 0x63, 0x64, 0x65, 0x66:
+			b := f64(pop())
+			a := f64(pop())
+			push(b2u(compareFloat(op-0x61, a, b)))
+
+		// i32 arithmetic
+		case 0x67:
+			push(uint64(bits.LeadingZeros32(uint32(pop()))))
+		case 0x68:
+			push(uint64(bits.TrailingZeros32(uint32(pop()))))
+		case 0x69:
+			push(uint64(bits.OnesCount32(uint32(pop()))))
+		case 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70, 0x71, 0x72, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78:
+			b := uint32(pop())
+			a := uint32(pop())
+			push(uint64(arithI32(op, a, b)))
+
+		// i64 arithmetic
+		case 0x79:
+			push(uint64(bits.LeadingZeros64(pop())))
+		case 0x7a:
+			push(uint64(bits.TrailingZeros64(pop())))
+		case 0x7b:
+			push(uint64(bits.OnesCount64(pop())))
+		case 0x7c, 0x7d, 0x7e, 0x7f, 0x80, 0x81, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89, 0x8a:
+			b := pop()
+			a := pop()
+			push(arithI64(op, a, b))
+
+		// f32 arithmetic
+		case 0x8b:
+			push(pop() & 0x7fffffff)
+		case 0x8c:
+			push(pop() ^ 0x80000000)
+		case 0x8d, 0x8e, 0x8f, 0x90, 0x91:
+			push(pf32(float32(unaryFloat(op-0x8d, float64(f32(pop()))))))
+		case 0x92, 0x93, 0x94, 0x95, 0x96, 0x97:
+			b := f32(pop())
+			a := f32(pop())
+			push(pf32(binaryF32(op-0x92, a, b)))
+		case 0x98:
+			b := pop()
+			a := pop()
+			push(a&0x7fffffff | b&0x80000000)
+
+		// f64 arithmetic
+		case 0x99:
+			push(pop() &^ (1 << 63))
+		case 0x9a:
+			push(pop() ^ (1 << 63))
+		case 0x9b, 0x9c, 0x9d, 0x9e, 0x9f:
+			push(pf64(unaryFloat(op-0x9b, f64(pop()))))
+		case 0xa0, 0xa1, 0xa2, 0xa3, 0xa4, 0xa5:
+			b := f64(pop())
+			a := f64(pop())
+			push(pf64(binaryF64(op-0xa0, a, b)))
+		case 0xa6:
+			b := pop()
+			a := pop()
+			push(a&^(1<<63) | b&(1<<63))
+
+		// Conversions
+		case 0xa7:
+			push(uint64(uint32(pop())))
+		case 0xa8:
+			push(uint64(uint32(int32(truncate(float64(f32(pop())), -2147483649, 2147483648)))))
+		case 0xa9:
+			push(uint64(uint32(truncate(float64(f32(pop())), -1, 4294967296))))
+		case 0xaa:
+			push(uint64(uint32(int32(truncate(f64(pop()), -2147483649, 2147483648)))))
+		case 0xab:
+			push(uint64(uint32(truncate(f64(pop()), -1, 4294967296))))
+		case 0xac:
+			push(uint64(int64(int32(uint32(pop())))))
+		case 0xad:
+			push(uint64(uint32(pop())))
+		case 0xae:
+			push(uint64(int64(truncate(float64(f32(pop())), -9223372036854777856, 9223372036854775808))))
+		case 0xaf:
+			push(truncateU64(float64(f32(pop()))))
+		case 0xb0:
+			push(uint64(int64(truncate(f64(pop()), -9223372036854777856, 9223372036854775808))))
+		case 0xb1:
+			push(truncateU64(f64(pop())))
+		case 0xb2:
+			push(pf32(float32(int32(uint32(pop())))))
+		case 0xb3:
+			push(pf32(float32(uint32(pop()))))
+		case 0xb4:
+			push(pf32(float32(int64(pop()))))
+		case 0xb5:
+			push(pf32(float32(pop())))
+		case 0xb6:
+			push(pf32(float32(f64(pop()))))
+		case 0xb7:
+			push(pf64(float64(int32(uint32(pop())))))
+		case 0xb8:
+			push(pf64(float64(uint32(pop()))))
+		case 0xb9:
+			push(pf64(float64(int64(pop()))))
+		case 0xba:
+			push(pf64(float64(pop())))
+		case 0xbb:
+			push(pf64(float64(f32(pop()))))
+		case 0xbc, 0xbd, 0xbe, 0xbf:
+			// Reinterpretations do not change the stored bits
+
+		// Sign extension
+		case 0xc0:
+			push(uint64(uint32(int32(int8(pop())))))
+		case 0xc1:
+			push(uint64(uint32(int32(int16(pop())))))
+		case 0xc2:
+			push(uint64(int64(int8(pop()))))
+		case 0xc3:
+			push(uint64(int64(int16(pop()))))
+		case 0xc4:
+			push(uint64(int64(int32(pop()))))
+
+		// Non-trapping conversions and bulk memory
+		case 0xfc:
+			switch sub := r.u32(); sub {
+			case 0:
+				push(uint64(uint32(int32(saturate(float64(f32(pop())), math.MinInt32, math.MaxInt32)))))
+			case 1:
+				push(uint64(uint32(saturate(float64(f32(pop())), 0, math.MaxUint32))))
+			case 2:
+				push(uint64(uint32(int32(saturate(f64(pop()), math.MinInt32, math.MaxInt32)))))
+			case 3:
+				push(uint64(uint32(saturate(f64(pop()), 0, math.MaxUint32))))
+			case 4:
+				push(uint64(saturateI64(float64(f32(pop())))))
+			case 5:
+				push(saturateU64(float64(f32(pop()))))
+			case 6:
+				push(uint64(saturateI64(f64(pop()))))
+			case 7:
+				push(saturateU64(f64(pop())))
+			case 10:
+				r.byte()
+				r.byte()
+				n := uint64(uint32(pop()))
+				src := uint64(uint32(pop()))
+				dst := uint64(uint32(pop()))
+				if src+n > uint64(len(i.memory)) || dst+n > uint64(len(i.memory)) {
+					trap("out of bounds memory access")
+				}
+				copy(i.memory[dst:dst+n], i.memory[src:src+n])
+			case 11:
+				r.byte()
+				n := uint64(uint32(pop()))
+				v := byte(pop())
+				dst := uint64(uint32(pop()))
+				if dst+n > uint64(len(i.memory)) {
+					trap("out of bounds memory access")
+				}
+				for m := dst; m < dst+n; m++ {
+					i.memory[m] = v
+				}
+			default:
+				trap("unsupported instruction 0xfc %d", sub)
+			}
+		default:
+			trap("unsupported instruction 0x%x", op)
+		}
+	}
+}
+
+// b2u converts a boolean to a WebAssembly i32
+func b2u(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func f32(v uint64) float32 {
+	return math.Float32frombits(uint32(v))
+}
+
+func pf32(f float32) uint64 {
+	return uint64(math.Float32bits(f))
+}
+
+func f64(v uint64) float64 {
+	return math.Float64frombits(v)
+}
+
+func pf64(f float64) uint64 {
+	return math.Float64bits(f)
+}
+
+// compareI32 evaluates the i32 comparison instructions from i32.eq (0x46) to i32.ge_u (0x4f)
+func compareI32(op byte, a uint32, b uint32) bool {
+	switch op {
+	case 0x46:
+		return a == b
+	case 0x47:
+		return a != b
+	case 0x48:
+		return int32(a) < int32(b)
+	case 0x49:
+		return a < b
+	case 0x4a:
+		return int32(a) > int32(b)
+	case 0x4b:
+		return a > b
+	case 0x4c:
+		return int32(a) <= int32(b)
+	case 0x4d:
+		return a <= b
+	case 0x4e:
+		return int32(a) >= int32(b)
+	default:
+		return a >= b
+	}
+}
+
+// compareI64 evaluates the i64 comparison instructions from i64.eq (0x51) to i64.ge_u (0x5a)
+func compareI64(op byte, a uint64, b uint64) bool {
+	switch op {
+	case 0x51:
+		return a == b
+	case 0x52:
+		return a != b
+	case 0x53:
+		return int64(a) < int64(b)
+	case 0x54:
+		return a < b
+	case 0x55:
+		return int64(a) > int64(b)
+	case 0x56:
+		return a > b
+	case 0x57:
+		return int64(a) <= int64(b)
+	case 0x58:
+		return a <= b
+	case 0x59:
+		return int64(a) >= int64(b)
+	default:
+		return a >= b
+	}
+}
+
+// compareFloat evaluates eq, ne, lt, gt, le, and ge in that order for floating point values
+func compareFloat(op byte, a float64, b float64) bool {
+	switch op {
+	case 0:
+		return a == b
+	case 1:
+		return a != b
+	case 2:
+		return a < b
+	case 3:
+		return a > b
+	case 4:
+		return a <= b
+	default:
+		return a >= b
+	}
+}
+
+// arithI32 evaluates the i32 binary instructions from i32.add (0x6a) to i32.rotr (0x78)
+func arithI32(op byte, a uint32, b uint32) uint32 {
+	switch op {
+	case 0x6a:
+		return a + b
+	case 0x6b:
+		return a - b
+	case 0x6c:
+		return a * b
+	case 0x6d:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		if int32(a) == math.MinInt32 && int32(b) == -1 {
+			trap("integer overflow")
+		}
+		return uint32(int32(a) / int32(b))
+	case 0x6e:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		return a / b
+	case 0x6f:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		if int32(b) == -1 {
+			return 0
+		}
+		return uint32(int32(a) % int32(b))
+	case 0x70:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		return a % b
+	case 0x71:
+		return a & b
+	case 0x72:
+		return a | b
+	case 0x73:
+		return a ^ b
+	case 0x74:
+		return a << (b & 31)
+	case 0x75:
+		return uint32(int32(a) >> (b & 31))
+	case 0x76:
+		return a >> (b & 31)
+	case 0x77:
+		return bits.RotateLeft32(a, int(b&31))
+	default:
+		return bits.RotateLeft32(a, -int(b&31))
+	}
+}
+
+// arithI64 evaluates the i64 binary instructions from i64.add (0x7c) to i64.rotr (0x8a)
+func arithI64(op byte, a uint64, b uint64) uint64 {
+	switch op {
+	case 0x7c:
+		return a + b
+	case 0x7d:
+		return a - b
+	case 0x7e:
+		return a * b
+	case 0x7f:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		if int64(a) == math.MinInt64 && int64(b) == -1 {
+			trap("integer overflow")
+		}
+		return uint64(int64(a) / int64(b))
+	case 0x80:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		return a / b
+	case 0x81:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		if int64(b) == -1 {
+			return 0
+		}
+		return uint64(int64(a) % int64(b))
+	case 0x82:
+		if b == 0 {
+			trap("integer divide by zero")
+		}
+		return a % b
+	case 0x83:
+		return a & b
+	case 0x84:
+		return a | b
+	case 0x85:
+		return a ^ b
+	case 0x86:
+		return a << (b & 63)
+	case 0x87:
+		return uint64(int64(a) >> (b & 63))
+	case 0x88:
+		return a >> (b & 63)
+	case 0x89:
+		return bits.RotateLeft64(a, int(b&63))
+	default:
+		return bits.RotateLeft64(a, -int(b&63))
+	}
+}
+
+// unaryFloat evaluates ceil, floor, trunc, nearest, and sqrt in that order
+func unaryFloat(op byte, a float64) float64 {
+	switch op {
+	case 0:
+		return math.Ceil(a)
+	case 1:
+		return math.Floor(a)
+	case 2:
+		return math.Trunc(a)
+	case 3:
+		return math.RoundToEven(a)
+	default:
+		return math.Sqrt(a)
+	}
+}
+
+// binaryF32 evaluates add, sub, mul, div, min, and max in that order for f32 values
+func binaryF32(op byte, a float32, b float32) float32 {
+	switch op {
+	case 0:
+		return a + b
+	case 1:
+		return a - b
+	case 2:
+		return a * b
+	case 3:
+		return a / b
+	case 4:
+		return float32(math.Min(float64(a), float64(b)))
+	default:
+		return float32(math.Max(float64(a), float64(b)))
+	}
+}
+
+// binaryF64 evaluates add, sub, mul, div, min, and max in that order for f64 values
+func binaryF64(op byte, a float64, b float64) float64 {
+	switch op {
+	case 0:
+		return a + b
+	case 1:
+		return a - b
+	case 2:
+		return a * b
+	case 3:
+		return a / b
+	case 4:
+		return math.Min(a, b)
+	default:
+		return math.Max(a, b)
+	}
+}
+
+// truncate converts a float to an integer and traps if the value is NaN or is not between the exclusive bounds
+func truncate(f float64, lower float64, upper float64) int64 {
+	if math.IsNaN(f) {
+		trap("invalid conversion to integer")
+	}
+	t := math.Trunc(f)
+	if t <= lower || t >= upper {
+		trap("integer overflow")
+	}
+	return int64(t)
+}
+
+// truncateU64 converts a float to an unsigned 64-bit integer and traps if it can't be represented
+func truncateU64(f float64) uint64 {
+	if math.IsNaN(f) {
+		trap("invalid conversion to integer")
+	}
+	t := math.Trunc(f)
+	if t <= -1 || t >= 18446744073709551616 {
+		trap("integer overflow")
+	}
+	return uint64(t)
+}
+
+// saturate converts a float to an integer clamped between the provided bounds with NaN converting to zero
+func saturate(f float64, lower float64, upper float64) int64 {
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f <= lower:
+		return int64(lower)
+	case f >= upper:
+		return int64(upper)
+	}
+	return int64(math.Trunc(f))
+}
+
+// saturateI64 converts a float to a signed 64-bit integer clamped to the type's range with NaN converting to zero
+func saturateI64(f float64) int64 {
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f <= math.MinInt64:
+		return math.MinInt64
+	case f >= 9223372036854775808:
+		return math.MaxInt64
+	}
+	return int64(f)
+}
+
+// saturateU64 converts a float to an unsigned 64-bit integer clamped to the type's range with NaN converting to zero
+func saturateU64(f float64) uint64 {
+	switch {
+	case math.IsNaN(f) || f <= 0:
+		return 0
+	case f >= 18446744073709551616:
+		return math.MaxUint64
+	}
+	return uint64(f)
+}
@@ -0,0 +1,227 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package wasm
+
+import (
+	// Standard
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// HostFunction is a Go function that a WebAssembly module can import
+type HostFunction struct {
+	Type FuncType
+	Func func(i *Instance, args []uint64) ([]uint64, error)
+}
+
+// Instance is an instantiated WebAssembly module with its own memory, globals, and table
+type Instance struct {
+	Deadline time.Time // Deadline stops execution with a trap when it is reached; a zero value disables it
+	module   *Module
+	host     []HostFunction
+	memory   []byte
+	globals  []uint64
+	table    []int64
+	steps    uint64
+	depth    int
+}
+
+// Trap is an error that stopped the execution of a WebAssembly module
+type Trap struct {
+	Message string
+}
+
+// Error returns the trap's message
+func (t Trap) Error() string {
+	return "wasm trap: " + t.Message
+}
+
+// trap stops the execution of the module with the provided message
+func trap(format string, a ...interface{}) {
+	panic(Trap{fmt.Sprintf(format, a...)})
+}
+
+// Instantiate links a module with host functions, keyed by module.name, then initializes the module and runs its
+// start function
+func Instantiate(m *Module, imports map[string]HostFunction) (i *Instance, err error) {
+	i = &Instance{module: m}
+
+	for _, imp := range m.imports {
+		h, ok := imports[imp.module+"."+imp.name]
+		if !ok {
+			return nil, fmt.Errorf("the module imports %s.%s which is not provided by the host", imp.module, imp.name)
+		}
+		if !h.Type.equal(m.types[imp.typeIndex]) {
+			return nil, fmt.Errorf("the module imports %s.%s as %s but the host provides %s", imp.module, imp.name,
+				m.types[imp.typeIndex], h.Type)
+		}
+		i.host = append(i.host, h)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			i = nil
+			err = fmt.Errorf("there was an error instantiating the WebAssembly module:\r\n%s", recoverError(r).Error())
+		}
+	}()
+
+	if m.hasMem {
+		i.memory = make([]byte, int(m.memMin)*pageSize)
+	}
+	for _, g := range m.globals {
+		i.globals = append(i.globals, i.evalConst(g.init))
+	}
+	if m.hasTable {
+		i.table = make([]int64, m.tableMin)
+		for t := range i.table {
+			i.table[t] = -1
+		}
+	}
+	for _, e := range m.elements {
+		offset := uint64(uint32(i.evalConst(e.offset)))
+		if offset+uint64(len(e.funcs)) > uint64(len(i.table)) {
+			trap("element segment does not fit in the table")
+		}
+		for n, f := range e.funcs {
+			if int(f) >= len(m.imports)+len(m.funcs) {
+				trap("element segment references an unknown function %d", f)
+			}
+			i.table[offset+uint64(n)] = int64(f)
+		}
+	}
+	for _, d := range m.data {
+		offset := uint64(uint32(i.evalConst(d.offset)))
+		if offset+uint64(len(d.data)) > uint64(len(i.memory)) {
+			trap("data segment does not fit in memory")
+		}
+		copy(i.memory[offset:], d.data)
+	}
+	if m.start >= 0 {
+		i.invoke(uint32(m.start), nil)
+	}
+	return i, nil
+}
+
+// Call executes the exported function with the provided arguments and returns its results
+func (i *Instance) Call(name string, args ...uint64) (results []uint64, err error) {
+	index, ok := i.module.exports[name]
+	if !ok {
+		return nil, fmt.Errorf("the module does not export a function named %s", name)
+	}
+	t, errType := i.funcType(index)
+	if errType != nil {
+		return nil, errType
+	}
+	if len(args) != len(t.Params) {
+		return nil, fmt.Errorf("%s expects %d arguments but %d were provided", name, len(t.Params), len(args))
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			results = nil
+			err = recoverError(r)
+		}
+	}()
+	i.depth = 0
+	return i.invoke(index, args), nil
+}
+
+// Memory returns the instance's linear memory
+func (i *Instance) Memory() []byte {
+	return i.memory
+}
+
+// ReadBytes returns a copy of length bytes from the instance's memory starting at the provided pointer
+func (i *Instance) ReadBytes(ptr uint32, length uint32) ([]byte, error) {
+	if uint64(ptr)+uint64(length) > uint64(len(i.memory)) {
+		return nil, fmt.Errorf("memory read of %d bytes at %d is out of bounds", length, ptr)
+	}
+	b := make([]byte, length)
+	copy(b, i.memory[ptr:])
+	return b, nil
+}
+
+// ReadString returns the string stored in the instance's memory at the provided pointer and length
+func (i *Instance) ReadString(ptr uint32, length uint32) (string, error) {
+	b, err := i.ReadBytes(ptr, length)
+	return string(b), err
+}
+
+// WriteBytes copies the provided data into the instance's memory starting at the provided pointer
+func (i *Instance) WriteBytes(ptr uint32, data []byte) error {
+	if uint64(ptr)+uint64(len(data)) > uint64(len(i.memory)) {
+		return fmt.Errorf("memory write of %d bytes at %d is out of bounds", len(data), ptr)
+	}
+	copy(i.memory[ptr:], data)
+	return nil
+}
+
+// funcType returns the signature of the function at the provided index of the function index space
+func (i *Instance) funcType(index uint32) (FuncType, error) {
+	if int(index) < len(i.module.imports) {
+		return i.module.types[i.module.imports[index].typeIndex], nil
+	}
+	index -= uint32(len(i.module.imports))
+	if int(index) >= len(i.module.funcs) {
+		return FuncType{}, fmt.Errorf("function index %d is out of range", index)
+	}
+	return i.module.types[i.module.funcs[index].typeIndex], nil
+}
+
+// evalConst evaluates a constant initializer expression
+func (i *Instance) evalConst(expr []byte) uint64 {
+	r := &reader{b: expr}
+	switch r.byte() {
+	case 0x41:
+		return uint64(uint32(int32(r.sleb(32))))
+	case 0x42:
+		return uint64(r.sleb(64))
+	case 0x43:
+		return uint64(binary.LittleEndian.Uint32(r.bytes(4)))
+	case 0x44:
+		return binary.LittleEndian.Uint64(r.bytes(8))
+	case 0x23:
+		index := r.u32()
+		if int(index) >= len(i.globals) {
+			trap("constant expression references an uninitialized global %d", index)
+		}
+		return i.globals[index]
+	}
+	trap("invalid constant expression")
+	return 0
+}
+
+// recoverError converts a recovered panic from module execution into an error
+func recoverError(r interface{}) error {
+	switch e := r.(type) {
+	case Trap:
+		return e
+	case parseError:
+		return Trap{e.err.Error()}
+	case runtime.Error:
+		// Malformed modules that were not rejected by the decoder can cause the interpreter to index out of range
+		return Trap{fmt.Sprintf("invalid module: %s", e.Error())}
+	case error:
+		return e
+	default:
+		return errors.New(fmt.Sprint(r))
+	}
+}
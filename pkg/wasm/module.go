@@ -0,0 +1,560 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package wasm is a small WebAssembly interpreter used to run portable task modules on an agent. It implements the
+// WebAssembly 1.0 instruction set, sign extension, non-trapping conversions, and bulk memory copy/fill. Modules may
+// only import functions; memory, tables, and globals must be defined by the module itself.
+package wasm
+
+import (
+	// Standard
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ValueType is a WebAssembly value type
+type ValueType byte
+
+// WebAssembly value types
+const (
+	I32 ValueType = 0x7f
+	I64 ValueType = 0x7e
+	F32 ValueType = 0x7d
+	F64 ValueType = 0x7c
+)
+
+const (
+	pageSize = 65536 // pageSize is the size of a WebAssembly memory page in bytes
+	maxPages = 1024  // maxPages limits a module's linear memory to 64MB
+)
+
+var magic = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+// FuncType is the signature of a WebAssembly function
+type FuncType struct {
+	Params  []ValueType
+	Results []ValueType
+}
+
+// equal returns true if both function types have the same parameters and results
+func (f FuncType) equal(o FuncType) bool {
+	return bytes.Equal(valueTypes(f.Params), valueTypes(o.Params)) && bytes.Equal(valueTypes(f.Results), valueTypes(o.Results))
+}
+
+// String returns the function type in the WebAssembly text format
+func (f FuncType) String() string {
+	return fmt.Sprintf("(param %v) (result %v)", f.Params, f.Results)
+}
+
+// String returns the value type in the WebAssembly text format
+func (v ValueType) String() string {
+	switch v {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	case F32:
+		return "f32"
+	case F64:
+		return "f64"
+	default:
+		return fmt.Sprintf("0x%x", byte(v))
+	}
+}
+
+// importFunc is a function the module imports from the host
+type importFunc struct {
+	module    string
+	name      string
+	typeIndex uint32
+}
+
+// function is a function defined by the module
+type function struct {
+	typeIndex uint32
+	locals    []ValueType
+	body      []byte
+	blocks    map[int]block // blocks maps the position of each block, loop, and if instruction to its else and end
+}
+
+// block holds the positions of a structured instruction's else and end instructions
+type block struct {
+	elseAt int // elseAt is the position of the else instruction or -1 if there isn't one
+	endAt  int // endAt is the position of the end instruction
+}
+
+// global is a module defined global variable
+type global struct {
+	valueType ValueType
+	mutable   bool
+	init      []byte // init is the constant initializer expression
+}
+
+// segment is an active data or element segment
+type segment struct {
+	offset []byte // offset is the constant offset expression
+	data   []byte
+	funcs  []uint32
+}
+
+// Module is a decoded WebAssembly binary module
+type Module struct {
+	types    []FuncType
+	imports  []importFunc
+	funcs    []function
+	tableMin uint32
+	hasTable bool
+	memMin   uint32
+	memMax   uint32
+	hasMem   bool
+	globals  []global
+	exports  map[string]uint32
+	start    int64
+	data     []segment
+	elements []segment
+}
+
+// Imports returns the module and name of every function the module imports from the host in the form module.name
+func (m *Module) Imports() []string {
+	var names []string
+	for _, i := range m.imports {
+		names = append(names, i.module+"."+i.name)
+	}
+	return names
+}
+
+// Exports returns the name of every function exported by the module
+func (m *Module) Exports() []string {
+	var names []string
+	for name := range m.exports {
+		names = append(names, name)
+	}
+	return names
+}
+
+// parseError is used with panic to unwind the decoder when the module is malformed
+type parseError struct {
+	err error
+}
+
+// reader decodes WebAssembly binary encoded values
+type reader struct {
+	b   []byte
+	pos int
+}
+
+func (r *reader) fail(format string, a ...interface{}) {
+	panic(parseError{fmt.Errorf(format, a...)})
+}
+
+func (r *reader) byte() byte {
+	if r.pos >= len(r.b) {
+		r.fail("unexpected end of data at offset %d", r.pos)
+	}
+	b := r.b[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *reader) bytes(n uint32) []byte {
+	if uint64(r.pos)+uint64(n) > uint64(len(r.b)) {
+		r.fail("unexpected end of data reading %d bytes at offset %d", n, r.pos)
+	}
+	b := r.b[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b
+}
+
+func (r *reader) u32() uint32 {
+	var result uint64
+	var shift uint
+	for {
+		b := r.byte()
+		result |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+		if shift >= 35 {
+			r.fail("integer representation too long at offset %d", r.pos)
+		}
+	}
+	if result > math.MaxUint32 {
+		r.fail("integer too large at offset %d", r.pos)
+	}
+	return uint32(result)
+}
+
+func (r *reader) sleb(size uint) int64 {
+	var result int64
+	var shift uint
+	var b byte
+	for {
+		b = r.byte()
+		result |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+		if shift >= size+7 {
+			r.fail("integer representation too long at offset %d", r.pos)
+		}
+	}
+	if shift < 64 && b&0x40 != 0 {
+		result |= -1 << shift
+	}
+	return result
+}
+
+func (r *reader) name() string {
+	return string(r.bytes(r.u32()))
+}
+
+func (r *reader) valueType() ValueType {
+	v := ValueType(r.byte())
+	switch v {
+	case I32, I64, F32, F64:
+		return v
+	}
+	r.fail("unsupported value type 0x%x at offset %d", byte(v), r.pos-1)
+	return 0
+}
+
+func (r *reader) limits() (uint32, uint32, bool) {
+	flag := r.byte()
+	min := r.u32()
+	switch flag {
+	case 0x00:
+		return min, 0, false
+	case 0x01:
+		return min, r.u32(), true
+	}
+	r.fail("unsupported limits flag 0x%x", flag)
+	return 0, 0, false
+}
+
+// constExpr reads a constant initializer expression including its end instruction
+func (r *reader) constExpr() []byte {
+	start := r.pos
+	switch op := r.byte(); op {
+	case 0x41:
+		r.sleb(32)
+	case 0x42:
+		r.sleb(64)
+	case 0x43:
+		r.bytes(4)
+	case 0x44:
+		r.bytes(8)
+	case 0x23:
+		r.u32()
+	default:
+		r.fail("unsupported constant expression instruction 0x%x", op)
+	}
+	if r.byte() != 0x0b {
+		r.fail("constant expression at offset %d is not terminated", start)
+	}
+	return r.b[start:r.pos]
+}
+
+// Parse decodes a WebAssembly binary module
+func Parse(b []byte) (m *Module, err error) {
+	if len(b) < len(magic) || !bytes.Equal(b[:len(magic)], magic) {
+		return nil, errors.New("the data is not a version 1 WebAssembly binary module")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			p, ok := r.(parseError)
+			if !ok {
+				panic(r)
+			}
+			m = nil
+			err = fmt.Errorf("there was an error parsing the WebAssembly module:\r\n%s", p.err.Error())
+		}
+	}()
+
+	m = &Module{exports: make(map[string]uint32), start: -1}
+	r := &reader{b: b, pos: len(magic)}
+	var funcTypes []uint32
+
+	for r.pos < len(r.b) {
+		id := r.byte()
+		size := r.u32()
+		s := &reader{b: r.bytes(size)}
+		switch id {
+		case 0: // Custom
+		case 1: // Type
+			for n := s.u32(); n > 0; n-- {
+				if s.byte() != 0x60 {
+					s.fail("invalid function type")
+				}
+				var t FuncType
+				for p := s.u32(); p > 0; p-- {
+					t.Params = append(t.Params, s.valueType())
+				}
+				for p := s.u32(); p > 0; p-- {
+					t.Results = append(t.Results, s.valueType())
+				}
+				m.types = append(m.types, t)
+			}
+		case 2: // Import
+			for n := s.u32(); n > 0; n-- {
+				i := importFunc{module: s.name(), name: s.name()}
+				if kind := s.byte(); kind != 0x00 {
+					s.fail("import %s.%s is not a function; only function imports are supported", i.module, i.name)
+				}
+				i.typeIndex = s.u32()
+				m.imports = append(m.imports, i)
+			}
+		case 3: // Function
+			for n := s.u32(); n > 0; n-- {
+				funcTypes = append(funcTypes, s.u32())
+			}
+		case 4: // Table
+			if n := s.u32(); n > 1 {
+				s.fail("only one table is supported")
+			} else if n == 1 {
+				if s.byte() != 0x70 {
+					s.fail("only funcref tables are supported")
+				}
+				m.tableMin, _, _ = s.limits()
+				m.hasTable = true
+			}
+		case 5: // Memory
+			if n := s.u32(); n > 1 {
+				s.fail("only one memory is supported")
+			} else if n == 1 {
+				var hasMax bool
+				m.memMin, m.memMax, hasMax = s.limits()
+				if !hasMax || m.memMax > maxPages {
+					m.memMax = maxPages
+				}
+				if m.memMin > m.memMax {
+					s.fail("the module requires %d memory pages but the limit is %d", m.memMin, m.memMax)
+				}
+				m.hasMem = true
+			}
+		case 6: // Global
+			for n := s.u32(); n > 0; n-- {
+				g := global{valueType: s.valueType(), mutable: s.byte() == 0x01}
+				g.init = s.constExpr()
+				m.globals = append(m.globals, g)
+			}
+		case 7: // Export
+			for n := s.u32(); n > 0; n-- {
+				name := s.name()
+				kind := s.byte()
+				index := s.u32()
+				if kind == 0x00 {
+					m.exports[name] = index
+				}
+			}
+		case 8: // Start
+			m.start = int64(s.u32())
+		case 9: // Element
+			for n := s.u32(); n > 0; n-- {
+				if flag := s.u32(); flag != 0 {
+					s.fail("element segment type %d is not supported", flag)
+				}
+				e := segment{offset: s.constExpr()}
+				for c := s.u32(); c > 0; c-- {
+					e.funcs = append(e.funcs, s.u32())
+				}
+				m.elements = append(m.elements, e)
+			}
+		case 10: // Code
+			count := s.u32()
+			if int(count) != len(funcTypes) {
+				s.fail("the function and code section counts do not match")
+			}
+			for i := uint32(0); i < count; i++ {
+				c := &reader{b: s.bytes(s.u32())}
+				f := function{typeIndex: funcTypes[i]}
+				for l := c.u32(); l > 0; l-- {
+					num := c.u32()
+					t := c.valueType()
+					if uint64(len(f.locals))+uint64(num) > 50000 {
+						c.fail("function %d declares too many locals", i)
+					}
+					for ; num > 0; num-- {
+						f.locals = append(f.locals, t)
+					}
+				}
+				f.body = c.b[c.pos:]
+				f.blocks = scanBlocks(c, f.body)
+				m.funcs = append(m.funcs, f)
+			}
+		case 11: // Data
+			for n := s.u32(); n > 0; n-- {
+				var d segment
+				switch flag := s.u32(); flag {
+				case 0:
+					d.offset = s.constExpr()
+				case 1:
+					// Passive segments are only used by memory.init which is not supported
+					s.bytes(s.u32())
+					continue
+				case 2:
+					if s.u32() != 0 {
+						s.fail("only memory 0 is supported")
+					}
+					d.offset = s.constExpr()
+				default:
+					s.fail("data segment type %d is not supported", flag)
+				}
+				d.data = s.bytes(s.u32())
+				m.data = append(m.data, d)
+			}
+		case 12: // Data Count
+		default:
+			r.fail("unknown section id %d", id)
+		}
+	}
+
+	if len(funcTypes) != len(m.funcs) {
+		return nil, errors.New("the module's function section does not have a matching code section")
+	}
+	for _, i := range m.imports {
+		if int(i.typeIndex) >= len(m.types) {
+			return nil, fmt.Errorf("import %s.%s has an invalid type index", i.module, i.name)
+		}
+	}
+	for i, f := range m.funcs {
+		if int(f.typeIndex) >= len(m.types) {
+			return nil, fmt.Errorf("function %d has an invalid type index", i)
+		}
+	}
+	return m, nil
+}
+
+// scanBlocks walks a function body once to match every block, loop, and if instruction with its else and end
+func scanBlocks(r *reader, body []byte) map[int]block {
+	blocks := make(map[int]block)
+	var stack []int
+	c := &reader{b: body}
+	for c.pos < len(c.b) {
+		pc := c.pos
+		op := c.byte()
+		switch op {
+		case 0x02, 0x03, 0x04:
+			blocks[pc] = block{elseAt: -1}
+			stack = append(stack, pc)
+		case 0x05:
+			if len(stack) == 0 {
+				r.fail("else without a matching if at offset %d", pc)
+			}
+			b := blocks[stack[len(stack)-1]]
+			b.elseAt = pc
+			blocks[stack[len(stack)-1]] = b
+		case 0x0b:
+			if len(stack) == 0 {
+				// The final end instruction of the function body
+				if c.pos != len(c.b) {
+					r.fail("unexpected end instruction at offset %d", pc)
+				}
+				return blocks
+			}
+			b := blocks[stack[len(stack)-1]]
+			b.endAt = pc
+			blocks[stack[len(stack)-1]] = b
+			stack = stack[:len(stack)-1]
+		}
+		skipImmediates(c, op)
+	}
+	r.fail("function body is not terminated with an end instruction")
+	return nil
+}
+
+// skipImmediates advances the reader past the immediate arguments of the provided instruction
+func skipImmediates(c *reader, op byte) {
+	switch {
+	case op == 0x02 || op == 0x03 || op == 0x04:
+		c.blockType()
+	case op == 0x0c || op == 0x0d || op == 0x10 || (op >= 0x20 && op <= 0x24):
+		c.u32()
+	case op == 0x0e:
+		for n := c.u32(); n > 0; n-- {
+			c.u32()
+		}
+		c.u32()
+	case op == 0x11:
+		c.u32()
+		c.u32()
+	case op == 0x1c:
+		for n := c.u32(); n > 0; n-- {
+			c.valueType()
+		}
+	case op >= 0x28 && op <= 0x3e:
+		c.u32()
+		c.u32()
+	case op == 0x3f || op == 0x40:
+		c.byte()
+	case op == 0x41:
+		c.sleb(32)
+	case op == 0x42:
+		c.sleb(64)
+	case op == 0x43:
+		c.bytes(4)
+	case op == 0x44:
+		c.bytes(8)
+	case op == 0xfc:
+		switch sub := c.u32(); {
+		case sub <= 7:
+		case sub == 10:
+			c.byte()
+			c.byte()
+		case sub == 11:
+			c.byte()
+		default:
+			c.fail("unsupported instruction 0xfc %d at offset %d", sub, c.pos)
+		}
+	case op <= 0x01 || op == 0x05 || op == 0x0b || op == 0x0f || op == 0x1a || op == 0x1b || (op >= 0x45 && op <= 0xc4):
+	default:
+		c.fail("unsupported instruction 0x%x at offset %d", op, c.pos-1)
+	}
+}
+
+// blockType reads a block type and returns a function type index or -1 for the empty type or -2-valtype for a single result
+func (r *reader) blockType() int64 {
+	b := r.byte()
+	r.pos--
+	if b == 0x40 {
+		r.pos++
+		return -1
+	}
+	switch ValueType(b) {
+	case I32, I64, F32, F64:
+		r.pos++
+		return -2 - int64(b)
+	}
+	index := r.sleb(33)
+	if index < 0 {
+		r.fail("invalid block type at offset %d", r.pos)
+	}
+	return index
+}
+
+// valueTypes converts a slice of value types to bytes for comparison
+func valueTypes(v []ValueType) []byte {
+	b := make([]byte, len(v))
+	for i, t := range v {
+		b[i] = byte(t)
+	}
+	return b
+}
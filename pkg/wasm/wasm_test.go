@@ -0,0 +1,211 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package wasm
+
+import (
+	// Standard
+	"testing"
+	"time"
+)
+
+// uleb encodes an unsigned LEB128 integer
+func uleb(v uint32) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if v == 0 {
+			return b
+		}
+	}
+}
+
+// vec encodes a WebAssembly vector of already encoded items
+func vec(items ...[]byte) []byte {
+	b := uleb(uint32(len(items)))
+	for _, i := range items {
+		b = append(b, i...)
+	}
+	return b
+}
+
+// section encodes a WebAssembly section with its id and size
+func section(id byte, content []byte) []byte {
+	return append(append([]byte{id}, uleb(uint32(len(content)))...), content...)
+}
+
+// name encodes a WebAssembly name
+func name(s string) []byte {
+	return append(uleb(uint32(len(s))), s...)
+}
+
+// code encodes a function body with its local declarations
+func code(locals []byte, body ...byte) []byte {
+	b := append(locals, body...)
+	return append(uleb(uint32(len(b))), b...)
+}
+
+// module assembles a WebAssembly binary from the provided sections
+func module(sections ...[]byte) []byte {
+	b := append([]byte{}, magic...)
+	for _, s := range sections {
+		b = append(b, s...)
+	}
+	return b
+}
+
+// testModule exports add, fac, sum, div, spin, and run functions and imports merlin.output
+func testModule() []byte {
+	return module(
+		section(1, vec(
+			[]byte{0x60, 2, 0x7f, 0x7f, 1, 0x7f}, // 0: (i32, i32) -> i32
+			[]byte{0x60, 1, 0x7e, 1, 0x7e},       // 1: (i64) -> i64
+			[]byte{0x60, 1, 0x7f, 1, 0x7f},       // 2: (i32) -> i32
+			[]byte{0x60, 2, 0x7f, 0x7f, 0},       // 3: (i32, i32) -> ()
+			[]byte{0x60, 0, 0},                   // 4: () -> ()
+		)),
+		section(2, vec(append(append(name("merlin"), name("output")...), 0x00, 3))),
+		section(3, vec([]byte{0}, []byte{1}, []byte{2}, []byte{0}, []byte{4}, []byte{4})),
+		section(5, vec([]byte{0x00, 1})),
+		section(7, vec(
+			append(name("add"), 0x00, 1),
+			append(name("fac"), 0x00, 2),
+			append(name("sum"), 0x00, 3),
+			append(name("div"), 0x00, 4),
+			append(name("spin"), 0x00, 5),
+			append(name("run"), 0x00, 6),
+		)),
+		section(10, vec(
+			// add: local.get 0, local.get 1, i32.add
+			code(vec(), 0x20, 0, 0x20, 1, 0x6a, 0x0b),
+			// fac: if n == 0 then 1 else n * fac(n - 1)
+			code(vec(), 0x20, 0, 0x50, 0x04, 0x7e, 0x42, 1, 0x05, 0x20, 0, 0x20, 0, 0x42, 1, 0x7d, 0x10, 2, 0x7e, 0x0b, 0x0b),
+			// sum: adds n + (n - 1) + ... + 1 in a loop
+			code(vec([]byte{1, 0x7f}),
+				0x02, 0x40, 0x03, 0x40,
+				0x20, 0, 0x45, 0x0d, 1,
+				0x20, 1, 0x20, 0, 0x6a, 0x21, 1,
+				0x20, 0, 0x41, 1, 0x6b, 0x21, 0,
+				0x0c, 0, 0x0b, 0x0b,
+				0x20, 1, 0x0b),
+			// div: local.get 0, local.get 1, i32.div_s
+			code(vec(), 0x20, 0, 0x20, 1, 0x6d, 0x0b),
+			// spin: loop br 0 end
+			code(vec(), 0x03, 0x40, 0x0c, 0, 0x0b, 0x0b),
+			// run: call output with the data segment
+			code(vec(), 0x41, 16, 0x41, 5, 0x10, 0, 0x0b),
+		)),
+		section(11, vec(append([]byte{0, 0x41, 16, 0x0b}, name("hello")...))),
+	)
+}
+
+func instantiate(t *testing.T, output *string) *Instance {
+	m, err := Parse(testModule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports := map[string]HostFunction{
+		"merlin.output": {
+			Type: FuncType{Params: []ValueType{I32, I32}},
+			Func: func(i *Instance, args []uint64) ([]uint64, error) {
+				s, errRead := i.ReadString(uint32(args[0]), uint32(args[1]))
+				*output += s
+				return nil, errRead
+			},
+		},
+	}
+	i, err := Instantiate(m, imports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+// TestCall executes exported functions that use arithmetic, recursion, and structured control flow
+func TestCall(t *testing.T) {
+	var output string
+	i := instantiate(t, &output)
+
+	cases := []struct {
+		name string
+		args []uint64
+		want uint64
+	}{
+		{"add", []uint64{40, 2}, 42},
+		{"add", []uint64{0xffffffff, 1}, 0},
+		{"fac", []uint64{10}, 3628800},
+		{"sum", []uint64{100}, 5050},
+		{"div", []uint64{uint64(0xfffffff6), 2}, 0xfffffffb}, // -10 / 2 = -5
+	}
+	for _, c := range cases {
+		r, err := i.Call(c.name, c.args...)
+		if err != nil {
+			t.Fatalf("%s returned an error: %s", c.name, err)
+		}
+		if len(r) != 1 || r[0] != c.want {
+			t.Errorf("%s%v returned %v, expected %d", c.name, c.args, r, c.want)
+		}
+	}
+}
+
+// TestHostFunction verifies data segments are loaded and imported host functions can read the module's memory
+func TestHostFunction(t *testing.T) {
+	var output string
+	i := instantiate(t, &output)
+	if _, err := i.Call("run"); err != nil {
+		t.Fatal(err)
+	}
+	if output != "hello" {
+		t.Errorf("run wrote %q, expected \"hello\"", output)
+	}
+}
+
+// TestTrap verifies runtime errors and the deadline stop execution without crashing the caller
+func TestTrap(t *testing.T) {
+	var output string
+	i := instantiate(t, &output)
+
+	if _, err := i.Call("div", 1, 0); err == nil {
+		t.Error("dividing by zero did not trap")
+	} else if _, ok := err.(Trap); !ok {
+		t.Errorf("dividing by zero returned a %T instead of a Trap", err)
+	}
+
+	i.Deadline = time.Now().Add(10 * time.Millisecond)
+	if _, err := i.Call("spin"); err == nil {
+		t.Error("an infinite loop was not stopped by the deadline")
+	}
+}
+
+// TestMissingImport verifies a module can't be instantiated without all of its imports
+func TestMissingImport(t *testing.T) {
+	m, err := Parse(testModule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Instantiate(m, nil); err == nil {
+		t.Error("the module was instantiated without the merlin.output import")
+	}
+	if _, err := Parse([]byte("not wasm")); err == nil {
+		t.Error("invalid data was parsed as a WebAssembly module")
+	}
+}
@@ -0,0 +1,335 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package webhook posts JSON notifications to Slack, Discord, or generic webhook URLs when a new agent registers, an
+// agent dies, or a job's output contains a keyword
+package webhook
+
+import (
+	// Standard
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/core"
+	"github.com/Ne0nd0g/merlin/pkg/events"
+	"github.com/Ne0nd0g/merlin/pkg/logging"
+	"github.com/Ne0nd0g/merlin/pkg/redact"
+)
+
+// Output is the event for a job whose output contains one of the webhook's keywords
+const Output = "output"
+
+// Webhook formats
+const (
+	Generic = "generic" // The Notification as JSON, signed with the webhook's secret
+	Slack   = "slack"   // A Slack incoming webhook message
+	Discord = "discord" // A Discord webhook message
+)
+
+// maxExcerpt is the most characters of the output line with the keyword sent with an output notification
+const maxExcerpt = 200
+
+// File is the webhook configuration file
+var File = filepath.Join(core.CurrentDir, "data", "webhooks.json")
+
+// validName are the characters allowed in a webhook name
+var validName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Webhook is a URL that is sent notifications for the selected events
+type Webhook struct {
+	Name     string   `json:"name"`
+	URL      string   `json:"url"`
+	Format   string   `json:"format"`             // generic, slack, or discord
+	Events   []string `json:"events"`             // agent, dead, status, and output
+	Keywords []string `json:"keywords,omitempty"` // Case-insensitive words that job output is searched for
+	Secret   string   `json:"secret,omitempty"`   // Signs generic requests with HMAC-SHA256 in the X-Merlin-Signature header
+}
+
+// Config is the webhook configuration file
+type Config struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// Notification is an event sent to a webhook
+type Notification struct {
+	Event   string    `json:"event"`
+	Agent   string    `json:"agent,omitempty"`
+	Host    string    `json:"host,omitempty"`
+	Job     string    `json:"job,omitempty"`
+	Keyword string    `json:"keyword,omitempty"` // The keyword found in the job's output
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+var mutex sync.Mutex
+var webhooks []Webhook
+var subscribe sync.Once
+
+// Load reads the webhooks from the configuration file, which is where added and removed webhooks are saved, and sends
+// them notifications for the server's events. A file that does not exist has no webhooks.
+func Load(file string) error {
+	var c Config
+	data, err := ioutil.ReadFile(file) // #nosec G304 - The file is provided by the operator
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("there was an error reading the webhook file %s:\r\n%s", file, err.Error())
+	}
+	if err == nil {
+		if err = json.Unmarshal(data, &c); err != nil {
+			return fmt.Errorf("there was an error decoding the webhook file %s:\r\n%s", file, err.Error())
+		}
+	}
+	for _, w := range c.Webhooks {
+		if err = validate(w); err != nil {
+			return fmt.Errorf("the webhook file %s is not valid:\r\n%s", file, err.Error())
+		}
+	}
+	mutex.Lock()
+	File, webhooks = file, c.Webhooks
+	mutex.Unlock()
+	subscribe.Do(func() { events.Subscribe(published) })
+	return nil
+}
+
+// Webhooks returns the configured webhooks
+func Webhooks() []Webhook {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return append([]Webhook{}, webhooks...)
+}
+
+// Add adds the webhook, or replaces the webhook with the same name, and saves the configuration file
+func Add(w Webhook) error {
+	if err := validate(w); err != nil {
+		return err
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	list := []Webhook{w}
+	for _, existing := range webhooks {
+		if existing.Name != w.Name {
+			list = append(list, existing)
+		}
+	}
+	if err := save(list); err != nil {
+		return err
+	}
+	webhooks = list
+	subscribe.Do(func() { events.Subscribe(published) })
+	return nil
+}
+
+// Remove removes the named webhook and saves the configuration file
+func Remove(name string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	var list []Webhook
+	for _, w := range webhooks {
+		if w.Name != name {
+			list = append(list, w)
+		}
+	}
+	if len(list) == len(webhooks) {
+		return fmt.Errorf("%s is not a webhook", name)
+	}
+	if err := save(list); err != nil {
+		return err
+	}
+	webhooks = list
+	return nil
+}
+
+// Test sends a test notification to the named webhook and returns any error
+func Test(name string) error {
+	for _, w := range Webhooks() {
+		if w.Name == name {
+			return post(w, Notification{Event: "test", Message: "Test notification from the Merlin server", Time: time.Now().UTC()})
+		}
+	}
+	return fmt.Errorf("%s is not a webhook", name)
+}
+
+// CheckOutput sends an output notification to every webhook with a keyword that the job's output contains
+func CheckOutput(agent string, host string, job string, output string) {
+	lower := strings.ToLower(output)
+	for _, w := range Webhooks() {
+		if !contains(w.Events, Output) {
+			continue
+		}
+		for _, keyword := range w.Keywords {
+			i := strings.Index(lower, strings.ToLower(keyword))
+			if i < 0 {
+				continue
+			}
+			n := Notification{Event: Output, Agent: agent, Host: host, Job: job, Keyword: keyword, Time: time.Now().UTC()}
+			n.Message = fmt.Sprintf("Job %s output contains %q: %s", job, keyword, excerpt(output, i))
+			go send(w, n)
+			break
+		}
+	}
+}
+
+// published sends the event to the webhooks that selected it
+func published(e events.Event) {
+	if e.Type != events.NewAgent && e.Type != events.Dead && e.Type != events.Status {
+		return
+	}
+	n := Notification{Event: e.Type, Agent: e.Agent, Message: e.Message, Time: e.Time}
+	for _, w := range Webhooks() {
+		if contains(w.Events, e.Type) {
+			go send(w, n)
+		}
+	}
+}
+
+// send posts the notification to the webhook and logs any error
+func send(w Webhook, n Notification) {
+	if err := post(w, n); err != nil {
+		logging.Server(fmt.Sprintf("There was an error sending the %s notification to the %s webhook:\r\n%s", n.Event, w.Name, err.Error()))
+	}
+}
+
+// post sends the notification to the webhook in its format and returns an error if it was not accepted
+func post(w Webhook, n Notification) error {
+	var body []byte
+	var err error
+	text := "Merlin " + n.Event
+	if n.Agent != "" {
+		text += " on agent " + n.Agent
+	}
+	if n.Host != "" {
+		text += " (" + n.Host + ")"
+	}
+	text += ": " + n.Message
+	switch w.Format {
+	case Slack:
+		body, err = json.Marshal(map[string]string{"text": text})
+	case Discord:
+		body, err = json.Marshal(map[string]string{"content": text})
+	default:
+		body, err = json.Marshal(n)
+	}
+	if err != nil {
+		return fmt.Errorf("there was an error encoding the notification:\r\n%s", err.Error())
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("there was an error creating the webhook request:\r\n%s", err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" && w.Format == Generic {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		_, _ = mac.Write(body)
+		req.Header.Set("X-Merlin-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("there was an error sending the notification to the webhook:\r\n%s", err.Error())
+	}
+	defer resp.Body.Close() // #nosec G307
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// save writes the webhooks to the configuration file; the caller holds the mutex
+func save(list []Webhook) error {
+	data, err := json.MarshalIndent(Config{Webhooks: list}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("there was an error encoding the webhooks:\r\n%s", err.Error())
+	}
+	if err = os.MkdirAll(filepath.Dir(File), 0750); err != nil {
+		return fmt.Errorf("there was an error creating the webhook file directory:\r\n%s", err.Error())
+	}
+	// The file holds the webhook URLs, which are credentials for Slack and Discord
+	if err = ioutil.WriteFile(File, data, 0600); err != nil {
+		return fmt.Errorf("there was an error writing the webhook file %s:\r\n%s", File, err.Error())
+	}
+	return nil
+}
+
+// validate returns an error if the webhook can not be used
+func validate(w Webhook) error {
+	if !validName.MatchString(w.Name) {
+		return fmt.Errorf("%q is not a valid webhook name", w.Name)
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("the %s webhook URL %s is not an HTTP or HTTPS URL", w.Name, w.URL)
+	}
+	if w.Format != Generic && w.Format != Slack && w.Format != Discord {
+		return fmt.Errorf("%s is not a webhook format; use %s, %s, or %s", w.Format, Generic, Slack, Discord)
+	}
+	if len(w.Events) == 0 {
+		return fmt.Errorf("the %s webhook does not have any events", w.Name)
+	}
+	for _, e := range w.Events {
+		if !contains(Events(), e) {
+			return fmt.Errorf("%s is not a webhook event; use %s", e, strings.Join(Events(), ", "))
+		}
+	}
+	if contains(w.Events, Output) && len(w.Keywords) == 0 {
+		return fmt.Errorf("the %s webhook needs keywords to search job output for", w.Name)
+	}
+	return nil
+}
+
+// Events returns the events webhooks can be sent
+func Events() []string {
+	return []string{events.NewAgent, events.Dead, events.Status, Output}
+}
+
+// excerpt returns the line of output that contains the index with secrets redacted, shortened to maxExcerpt
+func excerpt(output string, i int) string {
+	start := strings.LastIndex(output[:i], "\n") + 1
+	end := strings.Index(output[i:], "\n")
+	if end < 0 {
+		end = len(output)
+	} else {
+		end += i
+	}
+	line := strings.TrimSpace(redact.String(output[start:end]))
+	if len(line) > maxExcerpt {
+		line = line[:maxExcerpt] + "..."
+	}
+	return line
+}
+
+// contains returns true if the slice has the string
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+// Merlin is a post-exploitation command and control framework.
+// This file is part of Merlin.
+// Copyright (C) 2019  Russel Van Tuyl
+
+// Merlin is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// any later version.
+
+// Merlin is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with Merlin.  If not, see <http://www.gnu.org/licenses/>.
+
+package webhook
+
+import (
+	// Standard
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	// Merlin
+	"github.com/Ne0nd0g/merlin/pkg/events"
+)
+
+// TestWebhooks verifies webhooks are saved, sent only their events in their format, and that generic webhooks are
+// signed and sent the line of job output with the keyword
+func TestWebhooks(t *testing.T) {
+	slack := make(chan map[string]string, 4)
+	slackSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		slack <- body
+	}))
+	defer slackSrv.Close()
+	generic := make(chan Notification, 4)
+	genericSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		_, _ = mac.Write(body)
+		if r.Header.Get("X-Merlin-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var n Notification
+		_ = json.Unmarshal(body, &n)
+		generic <- n
+	}))
+	defer genericSrv.Close()
+
+	dir, err := ioutil.TempDir("", "merlin-webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // #nosec G104
+	events.File = filepath.Join(dir, "events.jsonl")
+	file := filepath.Join(dir, "webhooks.json")
+	if err = Load(file); err != nil {
+		t.Fatalf("a missing webhook file should not be an error: %s", err)
+	}
+
+	if err = Add(Webhook{Name: "bad", URL: "ftp://example.com", Format: Slack, Events: []string{events.Dead}}); err == nil {
+		t.Error("expected an error for a webhook URL that is not HTTP")
+	}
+	if err = Add(Webhook{Name: "bad", URL: slackSrv.URL, Format: Generic, Events: []string{Output}}); err == nil {
+		t.Error("expected an error for an output webhook without keywords")
+	}
+	if err = Add(Webhook{Name: "team", URL: slackSrv.URL, Format: Slack, Events: []string{events.NewAgent}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = Add(Webhook{Name: "siem", URL: genericSrv.URL, Format: Generic, Events: []string{events.Dead, Output}, Keywords: []string{"password"}, Secret: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = Load(file); err != nil || len(Webhooks()) != 2 {
+		t.Fatalf("expected the 2 webhooks to be saved, got %d: %v", len(Webhooks()), err)
+	}
+
+	if err = events.Publish(events.NewAgent, "a1", "New agent on host ws01"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case body := <-slack:
+		if !strings.Contains(body["text"], "New agent on host ws01") {
+			t.Errorf("unexpected Slack message %v", body)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the Slack webhook was not sent the new agent")
+	}
+
+	CheckOutput("a1", "ws01", "j1", "user: admin\r\nPassword: hunter2\r\nlast logon: never")
+	CheckOutput("a1", "ws01", "j2", "nothing to see")
+	select {
+	case n := <-generic:
+		if n.Event != Output || n.Job != "j1" || n.Keyword != "password" || strings.Contains(n.Message, "last logon") || !strings.Contains(n.Message, "Password:") {
+			t.Errorf("unexpected output notification %+v", n)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the generic webhook was not sent the job output")
+	}
+	select {
+	case n := <-generic:
+		t.Errorf("expected only one output notification, got %+v", n)
+	case body := <-slack:
+		t.Errorf("the Slack webhook was sent an event it did not select: %v", body)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	if err = Remove("team"); err != nil {
+		t.Error(err)
+	}
+	if err = Remove("team"); err == nil {
+		t.Error("expected an error removing a webhook that does not exist")
+	}
+}